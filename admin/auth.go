@@ -143,7 +143,7 @@ func (s *AdminServer) requireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		username := s.getSessionUsername(r)
 		if username == "" {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			http.Redirect(w, r, s.adminURL("/login"), http.StatusSeeOther)
 			return
 		}
 
@@ -156,7 +156,7 @@ func (s *AdminServer) requireSiteAccess(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		username := s.getSessionUsername(r)
 		if username == "" {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			http.Redirect(w, r, s.adminURL("/login"), http.StatusSeeOther)
 			return
 		}
 
@@ -165,7 +165,7 @@ func (s *AdminServer) requireSiteAccess(next http.Handler) http.Handler {
 
 		// Check if user has access
 		if !s.canAccessSite(username, siteID) {
-			http.Error(w, "Access denied: You don't have permission to access this website", http.StatusForbidden)
+			s.renderError(w, r, http.StatusForbidden, "You don't have permission to access this website.", nil)
 			return
 		}
 
@@ -178,12 +178,12 @@ func (s *AdminServer) requireSuperadmin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		username := s.getSessionUsername(r)
 		if username == "" {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			http.Redirect(w, r, s.adminURL("/login"), http.StatusSeeOther)
 			return
 		}
 
 		if !isAdmin(username) {
-			http.Error(w, "Access denied: Superadmin access required", http.StatusForbidden)
+			s.renderError(w, r, http.StatusForbidden, "This page needs superadmin access.", nil)
 			return
 		}
 
@@ -1,17 +1,23 @@
 package admin
 
 import (
+	"context"
+	"database/sql"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
+	"io"
 	"log"
 	"math"
 	"math/rand"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -22,14 +28,21 @@ import (
 	"github.com/murdinc/stencil2/database"
 	"github.com/murdinc/stencil2/email"
 	"github.com/murdinc/stencil2/frontend"
+	"github.com/murdinc/stencil2/media"
 	"github.com/murdinc/stencil2/shippo"
 	"github.com/murdinc/stencil2/twilio"
+	"github.com/murdinc/stencil2/utils"
 	"github.com/stripe/stripe-go/v78"
 	"github.com/stripe/stripe-go/v78/refund"
 )
 
-// Helper function to get website from URL parameter (db name)
+// Helper function to get website from URL parameter (db name). On site-scoped routes it's the
+// website loadWebsite already put in the request context
 func (s *AdminServer) getWebsiteFromURL(r *http.Request) (Website, error) {
+	if website, ok := websiteFromContext(r); ok {
+		return website, nil
+	}
+
 	websiteID := chi.URLParam(r, "id")
 	if websiteID == "" {
 		return Website{}, fmt.Errorf("website ID not in URL")
@@ -37,6 +50,17 @@ func (s *AdminServer) getWebsiteFromURL(r *http.Request) (Website, error) {
 	return s.GetWebsite(websiteID)
 }
 
+// requireWebsite loads the website a site-scoped handler works on, showing the not found page
+// when it doesn't exist. Handlers return when ok is false
+func (s *AdminServer) requireWebsite(w http.ResponseWriter, r *http.Request) (Website, bool) {
+	website, err := s.getWebsiteFromURL(r)
+	if err != nil {
+		s.renderError(w, r, http.StatusNotFound, "Website not found", err)
+		return Website{}, false
+	}
+	return website, true
+}
+
 // validateSlug ensures slug is valid: no leading/trailing slashes, only lowercase alphanumeric and hyphens
 func validateSlug(slug string) error {
 	if slug == "" {
@@ -62,7 +86,7 @@ func (s *AdminServer) handleLoginPage(w http.ResponseWriter, r *http.Request) {
 	// Check if already logged in
 	username := s.getSessionUsername(r)
 	if username != "" {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		http.Redirect(w, r, s.adminURL("/"), http.StatusSeeOther)
 		return
 	}
 
@@ -72,7 +96,7 @@ func (s *AdminServer) handleLoginPage(w http.ResponseWriter, r *http.Request) {
 // handleLogin processes the login form
 func (s *AdminServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
@@ -82,15 +106,15 @@ func (s *AdminServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 	validUsername := s.verifyCredentials(username, password)
 	if validUsername != "" {
 		if err := s.createSession(w, r, validUsername); err != nil {
-			http.Error(w, "Failed to create session", http.StatusInternalServerError)
+			s.renderError(w, r, http.StatusInternalServerError, "Failed to create session", nil)
 			return
 		}
 
 		// Redirect superadmin to superadmin console by default
 		if isAdmin(validUsername) {
-			http.Redirect(w, r, "/superadmin", http.StatusSeeOther)
+			http.Redirect(w, r, s.adminURL("/superadmin"), http.StatusSeeOther)
 		} else {
-			http.Redirect(w, r, "/", http.StatusSeeOther)
+			http.Redirect(w, r, s.adminURL("/"), http.StatusSeeOther)
 		}
 		return
 	}
@@ -104,7 +128,7 @@ func (s *AdminServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 func (s *AdminServer) handleLogout(w http.ResponseWriter, r *http.Request) {
 	
 	s.clearSession(w, r)
-	http.Redirect(w, r, "/login", http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/login"), http.StatusSeeOther)
 }
 
 // handleDashboard renders the main dashboard (no site selected)
@@ -115,7 +139,7 @@ func (s *AdminServer) handleDashboard(w http.ResponseWriter, r *http.Request) {
 	// Get all websites the user has access to
 	allSites, err := s.GetAllWebsites()
 	if err != nil {
-		http.Error(w, "Error loading websites", http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error loading websites", nil)
 		return
 	}
 
@@ -143,7 +167,7 @@ func (s *AdminServer) handleDashboard(w http.ResponseWriter, r *http.Request) {
 
 	// If user only has access to one site, redirect to it automatically
 	if len(accessibleSites) == 1 {
-		http.Redirect(w, r, "/site/"+accessibleSites[0].ID, http.StatusFound)
+		http.Redirect(w, r, s.adminURL("/site/%s", accessibleSites[0].ID), http.StatusFound)
 		return
 	}
 
@@ -152,14 +176,14 @@ func (s *AdminServer) handleDashboard(w http.ResponseWriter, r *http.Request) {
 		// Verify the site still exists and user has permission to access it
 		if _, err := s.GetWebsite(cookie.Value); err == nil && s.canAccessSite(username, cookie.Value) {
 			// Redirect to the last selected site
-			http.Redirect(w, r, "/site/"+cookie.Value, http.StatusFound)
+			http.Redirect(w, r, s.adminURL("/site/%s", cookie.Value), http.StatusFound)
 			return
 		}
 		// If site doesn't exist or user doesn't have access, clear the cookie
 		http.SetCookie(w, &http.Cookie{
 			Name:   "last_site",
 			Value:  "",
-			Path:   "/",
+			Path:   s.cookiePath(),
 			MaxAge: -1, // Delete cookie
 		})
 	}
@@ -174,13 +198,12 @@ func (s *AdminServer) handleDashboard(w http.ResponseWriter, r *http.Request) {
 func (s *AdminServer) handleSiteDashboard(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
 	if websiteID == "" {
-		http.Error(w, "Invalid website ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid website ID", nil)
 		return
 	}
 
-	site, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Site not found", http.StatusNotFound)
+	site, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
@@ -188,21 +211,29 @@ func (s *AdminServer) handleSiteDashboard(w http.ResponseWriter, r *http.Request
 	http.SetCookie(w, &http.Cookie{
 		Name:     "last_site",
 		Value:    websiteID,
-		Path:     "/",
+		Path:     s.cookiePath(),
 		MaxAge:   60 * 60 * 24 * 30, // 30 days
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
 	})
 
 	// Get overview stats
-	stats, err := s.GetOverviewStats(websiteID)
+	goals := MonthlyGoals{Revenue: site.GoalMonthlyRevenue, Orders: site.GoalMonthlyOrders}
+	stats, err := s.GetCachedOverviewStats(r.Context(), websiteID, site.Timezone, goals, r.URL.Query().Get("refresh") == "1")
 	if err != nil {
 		log.Printf("Error fetching overview stats: %v", err)
 		stats = &OverviewStats{} // Use empty stats on error
 	}
 
+	// Get trend vs the previous 7 days
+	comparison, err := s.GetOverviewComparison(r.Context(), websiteID, 7, site.Timezone)
+	if err != nil {
+		log.Printf("Error fetching overview comparison: %v", err)
+		comparison = &OverviewComparison{Days: 7}
+	}
+
 	// Get active users count
-	activeUsers, err := s.GetActiveUsers(websiteID, 5)
+	activeUsers, err := s.GetActiveUsers(r.Context(), websiteID, 5)
 	if err != nil {
 		log.Printf("Error fetching active users: %v", err)
 		activeUsers = 0
@@ -215,6 +246,13 @@ func (s *AdminServer) handleSiteDashboard(w http.ResponseWriter, r *http.Request
 		recentOrders = []RecentOrder{}
 	}
 
+	// Get orders support should look at
+	attentionOrders, err := s.GetOrdersNeedingAttention(websiteID)
+	if err != nil {
+		log.Printf("Error fetching orders needing attention: %v", err)
+		attentionOrders = nil
+	}
+
 	// Get time series data for chart (last 7 days for dashboard)
 	// Load user's configured timezone
 	loc, err := time.LoadLocation(site.Timezone)
@@ -226,7 +264,7 @@ func (s *AdminServer) handleSiteDashboard(w http.ResponseWriter, r *http.Request
 	endDate := time.Date(now.Year(), now.Month(), now.Day(), 23, 59, 59, 0, loc)
 	startDate := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, -6) // Start at midnight 6 days ago
 
-	timeSeriesData, err := s.GetAnalyticsTimeSeries(websiteID, startDate, endDate, site.Timezone)
+	timeSeriesData, err := s.GetAnalyticsTimeSeries(r.Context(), websiteID, startDate, endDate, site.Timezone, false, false)
 	if err != nil {
 		log.Printf("Error fetching time series data: %v", err)
 		timeSeriesData = []map[string]interface{}{}
@@ -234,7 +272,7 @@ func (s *AdminServer) handleSiteDashboard(w http.ResponseWriter, r *http.Request
 	timeSeriesJSON, _ := json.Marshal(timeSeriesData)
 
 	// Get engagement metrics
-	engagementData, err := s.GetEngagementTimeSeries(websiteID, startDate, endDate, site.Timezone)
+	engagementData, err := s.GetEngagementTimeSeries(r.Context(), websiteID, startDate, endDate, site.Timezone, false)
 	if err != nil {
 		log.Printf("Error fetching engagement data: %v", err)
 		engagementData = []map[string]interface{}{}
@@ -246,8 +284,10 @@ func (s *AdminServer) handleSiteDashboard(w http.ResponseWriter, r *http.Request
 		"ActiveSection":   "overview",
 		"Website":         site,
 		"Stats":           stats,
+		"Comparison":      comparison,
 		"ActiveUsers":     activeUsers,
 		"RecentOrders":    recentOrders,
+		"AttentionOrders": attentionOrders,
 		"TimeSeriesJSON":  string(timeSeriesJSON),
 		"EngagementJSON":  string(engagementJSON),
 	})
@@ -255,59 +295,324 @@ func (s *AdminServer) handleSiteDashboard(w http.ResponseWriter, r *http.Request
 
 // handleSiteSettings renders the site settings page
 func (s *AdminServer) handleSiteSettings(w http.ResponseWriter, r *http.Request) {
-	siteID := chi.URLParam(r, "id")
-
-	site, err := s.GetWebsite(siteID)
-	if err != nil {
-		http.Error(w, "Site not found", http.StatusNotFound)
+	site, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
+	s.renderSiteSettings(w, r, site, nil)
+}
+
+// renderSiteSettings renders the settings form for site, listing formErrors from a rejected save
+func (s *AdminServer) renderSiteSettings(w http.ResponseWriter, r *http.Request, site Website, formErrors []string) {
+	// Secrets are masked; saving the form with them unchanged keeps the stored values
+	site = site.Redacted()
+
+	robotsPreview := site.RobotsTxt
+	if strings.TrimSpace(robotsPreview) == "" {
+		robotsPreview = frontend.DefaultRobotsTxt(s.EnvConfig.BaseURL)
+	}
+
 	s.renderWithLayout(w, r, "site_settings_content.html", map[string]interface{}{
 		"Title":         site.SiteName + " - Settings",
 		"ActiveSection": "settings",
 		"Website":       site,
 		"ProdMode":      s.EnvConfig.ProdMode,
+		"Imported":      r.URL.Query().Get("imported"),
+		"Recomputed":    r.URL.Query().Get("recomputed"),
+		"Errors":        formErrors,
+		"RobotsPreview": robotsPreview,
 	})
 }
 
+// validateSiteSettings checks the settings that would otherwise be written into a broken config
+func validateSiteSettings(site Website) []string {
+	var problems []string
+
+	if site.Timezone != "" {
+		if _, err := time.LoadLocation(site.Timezone); err != nil {
+			problems = append(problems, fmt.Sprintf("Timezone %q isn't a known IANA timezone, e.g. America/Los_Angeles", site.Timezone))
+		}
+	}
+	if site.TaxRate > 1 {
+		problems = append(problems, "Tax rate is a fraction: use 0.08 for 8%")
+	}
+	if site.Currency != "" && !currencyCodePattern.MatchString(site.Currency) {
+		problems = append(problems, fmt.Sprintf("Currency %q should be a three-letter ISO 4217 code, e.g. USD or EUR", site.Currency))
+	}
+
+	return append(problems, validateRobotsTxt(site.RobotsTxt)...)
+}
+
+// parseAmountField parses a settings amount, which can't be negative. Blank is 0. problem
+// describes what's wrong with value, empty when it's fine
+func parseAmountField(value, label string) (amount float64, problem string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, ""
+	}
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return amount, fmt.Sprintf("%s must be a number", label)
+	} else if amount < 0 {
+		return amount, fmt.Sprintf("%s can't be negative", label)
+	}
+	return amount, ""
+}
+
+// parsePortField parses a settings port, blank for the default
+func parsePortField(value, label string) (port int, problem string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, ""
+	}
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return port, fmt.Sprintf("%s must be between 1 and 65535", label)
+	}
+	return port, ""
+}
+
+// currencyCodePattern matches an ISO 4217 currency code
+var currencyCodePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// robotsDirectives are the robots.txt fields crawlers understand
+var robotsDirectives = map[string]bool{
+	"user-agent":  true,
+	"allow":       true,
+	"disallow":    true,
+	"sitemap":     true,
+	"crawl-delay": true,
+	"host":        true,
+}
+
+// validateRobotsTxt checks that every line of a robots.txt is a comment or a "Field: value" line
+// crawlers understand, with rules under a User-agent
+func validateRobotsTxt(content string) []string {
+	var problems []string
+	seenUserAgent := false
+
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if hash := strings.Index(line, "#"); hash >= 0 {
+			line = strings.TrimSpace(line[:hash])
+		}
+		if line == "" {
+			continue
+		}
+
+		field, value, found := strings.Cut(line, ":")
+		field = strings.ToLower(strings.TrimSpace(field))
+		value = strings.TrimSpace(value)
+
+		switch {
+		case !found:
+			problems = append(problems, fmt.Sprintf("robots.txt line %d isn't a \"Field: value\" line", i+1))
+		case !robotsDirectives[field]:
+			problems = append(problems, fmt.Sprintf("robots.txt line %d has an unknown field %q", i+1, field))
+		case field == "user-agent":
+			seenUserAgent = true
+		case (field == "allow" || field == "disallow") && !seenUserAgent:
+			problems = append(problems, fmt.Sprintf("robots.txt line %d comes before any User-agent line", i+1))
+		case field == "sitemap" && !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://"):
+			problems = append(problems, fmt.Sprintf("robots.txt line %d: Sitemap needs a full URL", i+1))
+		}
+	}
+
+	return problems
+}
+
+// handleSiteExport downloads a JSON backup of the site's content
+func (s *AdminServer) handleSiteExport(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+
+	if _, ok := s.requireWebsite(w, r); !ok {
+		return
+	}
+
+	filename := fmt.Sprintf("%s-export-%s.json", websiteID, time.Now().Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	// The response has started by the time most errors can happen, so they can only be logged
+	if err := s.ExportSite(websiteID, w); err != nil {
+		log.Printf("Error exporting site %s: %v", websiteID, err)
+		return
+	}
+
+	s.LogActivity("export", "website", 0, websiteID, nil)
+}
+
+// handleSiteImport loads a JSON backup from handleSiteExport into the site's (empty) database
+func (s *AdminServer) handleSiteImport(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
+		return
+	}
+
+	file, _, err := r.FormFile("export")
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "No export file uploaded", nil)
+		return
+	}
+	defer file.Close()
+
+	counts, err := s.ImportSite(websiteID, file)
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Error importing site", err)
+		return
+	}
+
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+
+	s.LogActivity("import", "website", 0, websiteID, counts)
+
+	http.Redirect(w, r, s.adminURL("/site/%s/settings?imported=%d", websiteID, total), http.StatusSeeOther)
+}
+
+// handleSiteRecompute recomputes the site's derived data (category counts, ratings, store credit
+// balances, the analytics rollup) from the rows it comes from
+func (s *AdminServer) handleSiteRecompute(w http.ResponseWriter, r *http.Request) {
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	steps, err := s.RecomputeWebsite(r.Context(), website.ID, website.Timezone)
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error recomputing site data", err)
+		return
+	}
+
+	var changed int64
+	details := map[string]interface{}{}
+	for _, step := range steps {
+		changed += step.Rows
+		details[step.Name] = step.Rows
+	}
+
+	s.LogActivity("recompute", "website", 0, website.ID, details)
+
+	http.Redirect(w, r, s.adminURL("/site/%s/settings?recomputed=%d", website.ID, changed), http.StatusSeeOther)
+}
+
+// parseLocaleList reads a comma or space separated list of language tags, dropping ones that
+// aren't valid and repeats
+func parseLocaleList(value string) []string {
+	locales := []string{}
+	seen := make(map[string]bool)
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if locale := utils.NormalizeLocale(field); locale != "" && !seen[locale] {
+			seen[locale] = true
+			locales = append(locales, locale)
+		}
+	}
+	return locales
+}
+
 // handleSiteSettingsUpdate updates site settings
 func (s *AdminServer) handleSiteSettingsUpdate(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
 
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
 	// Get existing website to preserve directory
-	existingWebsite, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+	existingWebsite, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
-	// Parse float values
-	taxRate := 0.0
-	if r.FormValue("taxRate") != "" {
-		fmt.Sscanf(r.FormValue("taxRate"), "%f", &taxRate)
+	// Problems are collected so the form can be shown again with all of them at once
+	var formErrors []string
+
+	parseAmount := func(field, label string) float64 {
+		amount, problem := parseAmountField(r.FormValue(field), label)
+		if problem != "" {
+			formErrors = append(formErrors, problem)
+		}
+		return amount
+	}
+	parsePort := func(field, label string) int {
+		port, problem := parsePortField(r.FormValue(field), label)
+		if problem != "" {
+			formErrors = append(formErrors, problem)
+		}
+		return port
+	}
+
+	taxRate := parseAmount("taxRate", "Tax rate")
+	taxRounding := r.FormValue("taxRounding")
+	if taxRounding != utils.RoundHalfEven {
+		taxRounding = utils.RoundHalfUp
+	}
+	shippingCost := parseAmount("shippingCost", "Shipping cost")
+	minOrderAmount := parseAmount("minOrderAmount", "Minimum order amount")
+	imapPort := parsePort("imapPort", "IMAP port")
+	smtpPort := parsePort("smtpPort", "SMTP port")
+
+	staleOrderDays := 0
+	if value := strings.TrimSpace(r.FormValue("staleOrderDays")); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 1 {
+			formErrors = append(formErrors, "Days before an unshipped order needs attention must be a whole number of at least 1")
+		}
+		staleOrderDays = days
+	}
+
+	orderDigestIntervalHours := 0
+	if value := strings.TrimSpace(r.FormValue("orderDigestIntervalHours")); value != "" {
+		hours, err := strconv.Atoi(value)
+		if err != nil || hours < 1 {
+			formErrors = append(formErrors, "Order digest interval must be a whole number of hours of at least 1")
+		}
+		orderDigestIntervalHours = hours
+	}
+
+	goalMonthlyRevenue := parseAmount("goalMonthlyRevenue", "Monthly revenue goal")
+	goalMonthlyOrders := 0
+	if value := strings.TrimSpace(r.FormValue("goalMonthlyOrders")); value != "" {
+		orders, err := strconv.Atoi(value)
+		if err != nil || orders < 0 {
+			formErrors = append(formErrors, "Monthly order goal must be a whole number")
+		}
+		goalMonthlyOrders = orders
+	}
+
+	chatLowStockThreshold := 0
+	if value := strings.TrimSpace(r.FormValue("chatLowStockThreshold")); value != "" {
+		threshold, err := strconv.Atoi(value)
+		if err != nil || threshold < 1 {
+			formErrors = append(formErrors, "Low stock threshold must be a whole number of at least 1")
+		}
+		chatLowStockThreshold = threshold
 	}
 
-	shippingCost := 0.0
-	if r.FormValue("shippingCost") != "" {
-		fmt.Sscanf(r.FormValue("shippingCost"), "%f", &shippingCost)
+	chatWebhookURL := strings.TrimSpace(r.FormValue("chatWebhookUrl"))
+	if chatWebhookURL != "" && chatWebhookURL != maskSecret(existingWebsite.ChatWebhookURL) {
+		if u, err := url.Parse(chatWebhookURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			formErrors = append(formErrors, "Chat webhook URL must be an https:// URL")
+		}
 	}
 
-	// Parse IMAP port
-	imapPort := 0
-	if r.FormValue("imapPort") != "" {
-		fmt.Sscanf(r.FormValue("imapPort"), "%d", &imapPort)
+	maintenanceRetryAfter := 0
+	if value := strings.TrimSpace(r.FormValue("maintenanceRetryAfter")); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 1 {
+			formErrors = append(formErrors, "Maintenance retry after must be a whole number of seconds of at least 1")
+		}
+		maintenanceRetryAfter = seconds
 	}
 
-	// Parse SMTP port
-	smtpPort := 0
-	if r.FormValue("smtpPort") != "" {
-		fmt.Sscanf(r.FormValue("smtpPort"), "%d", &smtpPort)
+	if err := checkDatabaseName(r.FormValue("databaseName")); err != nil {
+		formErrors = append(formErrors, err.Error())
 	}
 
 	// Sanitize HTTP address - remove http://, https://, and trailing slashes
@@ -316,6 +621,12 @@ func (s *AdminServer) handleSiteSettingsUpdate(w http.ResponseWriter, r *http.Re
 	httpAddress = strings.TrimPrefix(httpAddress, "https://")
 	httpAddress = strings.TrimSuffix(httpAddress, "/")
 
+	// Secrets are shown masked, so a masked value coming back means keep the saved one
+	secret := func(field, saved string) string {
+		return unmaskSecret(r.FormValue(field), saved)
+	}
+	emailPassword := secret("emailPassword", existingWebsite.IMAPPassword)
+
 	website := Website{
 		ID:            websiteID,
 		SiteName:      r.FormValue("siteName"),
@@ -326,14 +637,18 @@ func (s *AdminServer) handleSiteSettingsUpdate(w http.ResponseWriter, r *http.Re
 		APIVersion:    1,
 		Timezone:      r.FormValue("timezone"),
 
+		DefaultLocale: utils.NormalizeLocale(r.FormValue("defaultLocale")),
+		Locales:       parseLocaleList(r.FormValue("locales")),
+
 		StripePublishableKey: r.FormValue("stripePublishableKey"),
-		StripeSecretKey:      r.FormValue("stripeSecretKey"),
+		StripeSecretKey:      secret("stripeSecretKey", existingWebsite.StripeSecretKey),
+		StripeWebhookSecret:  secret("stripeWebhookSecret", existingWebsite.StripeWebhookSecret),
 
-		ShippoAPIKey: r.FormValue("shippoApiKey"),
+		ShippoAPIKey: secret("shippoApiKey", existingWebsite.ShippoAPIKey),
 		LabelFormat:  r.FormValue("labelFormat"),
 
 		TwilioAccountSID: r.FormValue("twilioAccountSid"),
-		TwilioAuthToken:  r.FormValue("twilioAuthToken"),
+		TwilioAuthToken:  secret("twilioAuthToken", existingWebsite.TwilioAuthToken),
 		TwilioFromPhone:  r.FormValue("twilioFromPhone"),
 
 		// Simplified email fields - use single email address for all
@@ -344,20 +659,42 @@ func (s *AdminServer) handleSiteSettingsUpdate(w http.ResponseWriter, r *http.Re
 		IMAPServer:   r.FormValue("imapServer"),
 		IMAPPort:     imapPort,
 		IMAPUsername: r.FormValue("emailAddress"), // Same as email address
-		IMAPPassword: r.FormValue("emailPassword"),
+		IMAPPassword: emailPassword,
 		IMAPUseTLS:   r.FormValue("emailUseTLS") == "true",
 
 		SMTPServer:   r.FormValue("smtpServer"),
 		SMTPPort:     smtpPort,
 		SMTPUsername: r.FormValue("emailAddress"), // Same as email address
-		SMTPPassword: r.FormValue("emailPassword"), // Same as IMAP password
+		SMTPPassword: emailPassword,               // Same as IMAP password
 		SMTPUseTLS:   r.FormValue("emailUseTLS") == "true",
 
-		TaxRate:      taxRate,
-		ShippingCost: shippingCost,
+		TaxRate:           taxRate,
+		TaxInclusive:      r.FormValue("taxInclusive") == "on",
+		TaxRounding:       taxRounding,
+		ShippingCost:      shippingCost,
+		MinOrderAmount:    minOrderAmount,
+		OrderNumberPrefix: strings.TrimSpace(r.FormValue("orderNumberPrefix")),
+		Currency:          strings.ToUpper(strings.TrimSpace(r.FormValue("currency"))),
+		ManualCapture:     r.FormValue("manualCapture") == "on",
+		StaleOrderDays:    staleOrderDays,
+		RestockReturns:    r.FormValue("restockReturns") == "on",
+
+		OrderDigestEnabled:       r.FormValue("orderDigestEnabled") == "on",
+		OrderDigestIntervalHours: orderDigestIntervalHours,
+
+		GoalMonthlyRevenue: goalMonthlyRevenue,
+		GoalMonthlyOrders:  goalMonthlyOrders,
+
+		ChatWebhookURL:        unmaskSecret(chatWebhookURL, existingWebsite.ChatWebhookURL),
+		ChatLowStock:          r.FormValue("chatLowStock") == "on",
+		ChatLowStockThreshold: chatLowStockThreshold,
 
 		EarlyAccessEnabled:  r.FormValue("earlyAccessEnabled") == "on",
-		EarlyAccessPassword: r.FormValue("earlyAccessPassword"),
+		EarlyAccessPassword: secret("earlyAccessPassword", existingWebsite.EarlyAccessPassword),
+
+		MaintenanceEnabled:    r.FormValue("maintenanceEnabled") == "on",
+		MaintenanceMessage:    strings.TrimSpace(r.FormValue("maintenanceMessage")),
+		MaintenanceRetryAfter: maintenanceRetryAfter,
 
 		ShipFromName:    r.FormValue("shipFromName"),
 		ShipFromStreet1: r.FormValue("shipFromStreet1"),
@@ -371,66 +708,242 @@ func (s *AdminServer) handleSiteSettingsUpdate(w http.ResponseWriter, r *http.Re
 		Logo:      r.FormValue("logo"),
 	}
 
+	// Show the form again rather than write a config the site can't use
+	formErrors = append(formErrors, validateSiteSettings(website)...)
+	if len(formErrors) > 0 {
+		s.renderSiteSettings(w, r, website, formErrors)
+		return
+	}
+
 	if err := s.UpdateWebsite(website); err != nil {
-		http.Error(w, fmt.Sprintf("Error updating website: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error updating website", err)
 		return
 	}
 
-	// Reload the website configuration in the running frontend
+	s.reloadFrontendConfig(websiteID)
+
+	s.LogActivity("update", "website", 0, websiteID, website)
+
+	http.Redirect(w, r, s.adminURL("/site/%s/settings", websiteID), http.StatusSeeOther)
+}
+
+// reloadFrontendConfig reloads the website configuration in the running frontend and tells the
+// site's caches its settings changed
+func (s *AdminServer) reloadFrontendConfig(websiteID string) {
 	if frontendWebsite, exists := frontend.GetWebsite(websiteID); exists {
 		if err := frontendWebsite.ReloadConfig(s.EnvConfig.ProdMode); err != nil {
 			log.Printf("Warning: Failed to reload website config: %v", err)
 		}
 	}
+	database.Publish(websiteID, database.SettingsChanged)
+}
 
-	s.LogActivity("update", "website", 0, websiteID, website)
+// handleMaintenanceToggle turns maintenance mode on or off, leaving the rest of the settings as they are
+func (s *AdminServer) handleMaintenanceToggle(w http.ResponseWriter, r *http.Request) {
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	website.MaintenanceEnabled = r.FormValue("enabled") == "true"
+
+	if err := s.UpdateWebsite(website); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error updating maintenance mode", err)
+		return
+	}
+
+	s.reloadFrontendConfig(website.ID)
+
+	s.LogActivity("update", "website", 0, website.ID, map[string]bool{"maintenance": website.MaintenanceEnabled})
 
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/settings", websiteID), http.StatusSeeOther)
+	redirect := s.adminURL("/site/%s", website.ID)
+	if r.FormValue("redirect") == "settings" {
+		redirect = s.adminURL("/site/%s/settings", website.ID)
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
 }
 
 // handleWebhooks renders the webhooks configuration page
 func (s *AdminServer) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	site, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	s.renderWebhooks(w, r, site, nil)
+}
+
+// renderWebhooks renders the webhooks page, with the outcome of a test delivery when there was one
+func (s *AdminServer) renderWebhooks(w http.ResponseWriter, r *http.Request, site Website, testResult *WebhookTestResult) {
+	deliveries, err := s.GetWebhookLog(site.ID, "", 10)
+	if err != nil {
+		log.Printf("Warning: failed to load webhook log for %s: %v", site.ID, err)
+	}
+
+	s.renderWithLayout(w, r, "webhooks_content.html", map[string]interface{}{
+		"Title":         site.SiteName + " - Webhooks",
+		"ActiveSection": "webhooks",
+		"Website":       site,
+		"BaseURL":       s.webhookBaseURL(r, site),
+		"TestResult":    testResult,
+		"Deliveries":    deliveries,
+	})
+}
+
+// handleWebhookLog lists recent inbound webhook deliveries, optionally for one provider
+func (s *AdminServer) handleWebhookLog(w http.ResponseWriter, r *http.Request) {
 	siteID := chi.URLParam(r, "id")
 
-	site, err := s.GetWebsite(siteID)
+	site, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	provider := r.URL.Query().Get("provider")
+	if _, ok := webhookTestPaths[provider]; !ok {
+		provider = ""
+	}
+
+	deliveries, err := s.GetWebhookLog(siteID, provider, 200)
+	if err != nil {
+		log.Printf("Warning: failed to load webhook log for %s: %v", siteID, err)
+	}
+
+	s.renderWithLayout(w, r, "webhook_log_content.html", map[string]interface{}{
+		"Title":         site.SiteName + " - Webhook Log",
+		"ActiveSection": "webhooks",
+		"Website":       site,
+		"Provider":      provider,
+		"Deliveries":    deliveries,
+	})
+}
+
+// handleOutboundWebhooks lists the site's own webhook deliveries waiting to be retried and the
+// ones that failed for good
+func (s *AdminServer) handleOutboundWebhooks(w http.ResponseWriter, r *http.Request) {
+	site, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	pending, err := s.GetPendingOutboundDeliveries(site.ID, 100)
+	if err != nil {
+		log.Printf("Warning: failed to load outbound webhook queue for %s: %v", site.ID, err)
+	}
+	deadLetters, err := s.GetOutboundDeadLetters(site.ID, 200)
+	if err != nil {
+		log.Printf("Warning: failed to load outbound dead letters for %s: %v", site.ID, err)
+	}
+
+	s.renderWithLayout(w, r, "outbound_webhooks_content.html", map[string]interface{}{
+		"Title":         site.SiteName + " - Outbound Webhooks",
+		"ActiveSection": "webhooks",
+		"Website":       site,
+		"Pending":       pending,
+		"DeadLetters":   deadLetters,
+		"Replayed":      r.URL.Query().Get("replayed") != "",
+	})
+}
+
+// handleOutboundReplay queues a dead letter to be sent again
+func (s *AdminServer) handleOutboundReplay(w http.ResponseWriter, r *http.Request) {
+	site, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "deadLetterId"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid delivery ID", nil)
+		return
+	}
+
+	err = s.ReplayOutboundDeadLetter(site.ID, id)
+	if err == sql.ErrNoRows {
+		s.renderError(w, r, http.StatusNotFound, "Delivery not found", nil)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to replay delivery", err)
+		return
+	}
+
+	s.LogActivity("replay", "outbound_webhook", id, site.ID, nil)
+
+	http.Redirect(w, r, s.adminURL("/site/%s/webhooks/outbound?replayed=1", site.ID), http.StatusSeeOther)
+}
+
+// handleOutboundDelete discards a dead letter
+func (s *AdminServer) handleOutboundDelete(w http.ResponseWriter, r *http.Request) {
+	site, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "deadLetterId"))
 	if err != nil {
-		http.Error(w, "Site not found", http.StatusNotFound)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid delivery ID", nil)
+		return
+	}
+
+	if err := s.DeleteOutboundDeadLetter(site.ID, id); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to delete delivery", err)
 		return
 	}
 
+	s.LogActivity("delete", "outbound_webhook", id, site.ID, nil)
+
+	http.Redirect(w, r, s.adminURL("/site/%s/webhooks/outbound", site.ID), http.StatusSeeOther)
+}
+
+// webhookBaseURL returns the scheme and host the site's webhook endpoints are reached at
+func (s *AdminServer) webhookBaseURL(r *http.Request, site Website) string {
+	scheme := "https"
+	if !s.EnvConfig.ProdMode {
+		scheme = "http"
+	}
+
 	// Use the site's specific HTTP address for webhooks
 	baseURL := site.HTTPAddress
 	if baseURL == "" {
 		// Fallback to request host if address not configured
-		scheme := "https"
-		if !s.EnvConfig.ProdMode {
-			scheme = "http"
-		}
 		baseURL = fmt.Sprintf("%s://%s", scheme, r.Host)
 	}
 
 	// Ensure it has the scheme prefix
 	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
-		scheme := "https"
-		if !s.EnvConfig.ProdMode {
-			scheme = "http"
-		}
 		baseURL = fmt.Sprintf("%s://%s", scheme, baseURL)
 	}
 
-	s.renderWithLayout(w, r, "webhooks_content.html", map[string]interface{}{
-		"Title":         site.SiteName + " - Webhooks",
-		"ActiveSection": "webhooks",
-		"Website":       site,
-		"BaseURL":       baseURL,
-	})
+	return baseURL
+}
+
+// handleWebhookTest sends a sample event to one of the site's webhook endpoints and shows how it answered
+func (s *AdminServer) handleWebhookTest(w http.ResponseWriter, r *http.Request) {
+	siteID := chi.URLParam(r, "id")
+	provider := chi.URLParam(r, "provider")
+
+	site, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := s.SendWebhookTest(r.Context(), site, s.webhookBaseURL(r, site), provider)
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "", err)
+		return
+	}
+
+	s.LogActivity("test", "webhook", 0, siteID, map[string]interface{}{"provider": provider, "status": result.StatusCode})
+
+	s.renderWebhooks(w, r, site, result)
 }
 
 // handleWebsitesList renders the websites list
 func (s *AdminServer) handleWebsitesList(w http.ResponseWriter, r *http.Request) {
 	websites, err := s.GetAllWebsites()
 	if err != nil {
-		http.Error(w, "Error loading websites", http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error loading websites", nil)
 		return
 	}
 
@@ -445,7 +958,7 @@ func (s *AdminServer) handleWebsitesList(w http.ResponseWriter, r *http.Request)
 func (s *AdminServer) handleWebsiteNew(w http.ResponseWriter, r *http.Request) {
 	s.renderWithLayout(w, r, "website_form_content.html", map[string]interface{}{
 		"Title":         "Create New Website",
-		"Action":        "/websites/new",
+		"Action":        s.adminURL("/websites/new"),
 		"ActiveSection": "website-new",
 	})
 }
@@ -528,8 +1041,8 @@ func (s *AdminServer) getWebsiteStats(websites []Website) []WebsiteStats {
 		db.QueryRow("SELECT COUNT(*) FROM orders WHERE fulfillment_status = 'unfulfilled' AND payment_status = 'paid'").Scan(&ws.OrdersUnfulfilled)
 
 		// Pageview statistics (last 30 days)
-		db.QueryRow("SELECT COUNT(*) FROM analytics_pageviews WHERE created_at >= DATE_SUB(NOW(), INTERVAL 30 DAY)").Scan(&ws.PageviewsTotal)
-		db.QueryRow("SELECT COUNT(DISTINCT session_id) FROM analytics_pageviews WHERE created_at >= DATE_SUB(NOW(), INTERVAL 30 DAY)").Scan(&ws.PageviewsUnique)
+		db.QueryRow("SELECT COUNT(*) FROM analytics_pageviews WHERE created_at >= DATE_SUB(NOW(), INTERVAL 30 DAY) AND is_bot = 0").Scan(&ws.PageviewsTotal)
+		db.QueryRow("SELECT COUNT(DISTINCT session_id) FROM analytics_pageviews WHERE created_at >= DATE_SUB(NOW(), INTERVAL 30 DAY) AND is_bot = 0").Scan(&ws.PageviewsUnique)
 
 		// Message statistics
 		db.QueryRow("SELECT COUNT(*) FROM messages WHERE status = 'unread'").Scan(&ws.MessagesUnread)
@@ -639,41 +1152,80 @@ func (s *AdminServer) handleSuperadminCheckup(w http.ResponseWriter, r *http.Req
 func (s *AdminServer) handleSuperadminWebsiteNew(w http.ResponseWriter, r *http.Request) {
 	s.renderWithLayout(w, r, "website_form_content.html", map[string]interface{}{
 		"Title":         "Create New Website",
-		"Action":        "/superadmin/websites/new",
+		"Action":        s.adminURL("/superadmin/websites/new"),
 		"ActiveSection": "superadmin-website-new",
 	})
 }
 
-// handleSuperadminWebsiteCreate creates a new website from superadmin
-func (s *AdminServer) handleSuperadminWebsiteCreate(w http.ResponseWriter, r *http.Request) {
+// handleSuperadminWebsiteDuplicateForm renders the new website form for starting from an existing site
+func (s *AdminServer) handleSuperadminWebsiteDuplicateForm(w http.ResponseWriter, r *http.Request) {
+	source, err := s.GetWebsite(chi.URLParam(r, "id"))
+	if err != nil {
+		s.renderError(w, r, http.StatusNotFound, "Website not found", nil)
+		return
+	}
+
+	s.renderWithLayout(w, r, "website_form_content.html", map[string]interface{}{
+		"Title":         "Duplicate " + source.SiteName,
+		"Action":        s.adminURL("/superadmin/websites/%s/duplicate", source.ID),
+		"ActiveSection": "superadmin",
+		"Source":        source,
+	})
+}
+
+// handleSuperadminWebsiteDuplicate creates a new website from an existing site's files and config
+func (s *AdminServer) handleSuperadminWebsiteDuplicate(w http.ResponseWriter, r *http.Request) {
+	sourceID := chi.URLParam(r, "id")
+
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
+	// Sanitize HTTP address - remove http://, https://, and trailing slashes
+	httpAddress := r.FormValue("httpAddress")
+	httpAddress = strings.TrimPrefix(httpAddress, "http://")
+	httpAddress = strings.TrimPrefix(httpAddress, "https://")
+	httpAddress = strings.TrimSuffix(httpAddress, "/")
+
 	website := Website{
 		SiteName:      r.FormValue("siteName"),
 		Directory:     r.FormValue("directory"),
 		DatabaseName:  r.FormValue("databaseName"),
-		HTTPAddress:   r.FormValue("httpAddress"),
+		HTTPAddress:   httpAddress,
 		MediaProxyURL: r.FormValue("mediaProxyUrl"),
-		APIVersion:    1,
 	}
 
-	// Create website directory structure
-	websiteDir := filepath.Join("websites", website.Directory)
-	if err := os.MkdirAll(websiteDir, 0755); err != nil {
-		http.Error(w, fmt.Sprintf("Error creating directory: %v", err), http.StatusInternalServerError)
+	if err := s.DuplicateWebsite(sourceID, website); err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Error duplicating website", err)
 		return
 	}
 
-	// Create subdirectories
-	for _, dir := range []string{"templates", "public", "sitemaps"} {
-		if err := os.MkdirAll(filepath.Join(websiteDir, dir), 0755); err != nil {
-			http.Error(w, fmt.Sprintf("Error creating directory: %v", err), http.StatusInternalServerError)
-			return
-		}
-	}
+	s.LogActivity("duplicate", "website", 0, website.DatabaseName, map[string]string{"source": sourceID})
+
+	http.Redirect(w, r, s.adminURL("/superadmin"), http.StatusSeeOther)
+}
+
+// handleSuperadminWebsiteCreate creates a new website from superadmin
+func (s *AdminServer) handleSuperadminWebsiteCreate(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
+		return
+	}
+
+	website := Website{
+		SiteName:      r.FormValue("siteName"),
+		Directory:     r.FormValue("directory"),
+		DatabaseName:  r.FormValue("databaseName"),
+		HTTPAddress:   r.FormValue("httpAddress"),
+		MediaProxyURL: r.FormValue("mediaProxyUrl"),
+		APIVersion:    1,
+	}
+
+	if err := checkDatabaseName(website.DatabaseName); err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "", err)
+		return
+	}
 
 	// Create complete config file with all required fields
 	devConfig := map[string]interface{}{
@@ -739,22 +1291,22 @@ func (s *AdminServer) handleSuperadminWebsiteCreate(w http.ResponseWriter, r *ht
 		"logo":      "",
 	}
 
-	configData, _ := json.MarshalIndent(devConfig, "", "  ")
-	if err := os.WriteFile(filepath.Join(websiteDir, "config-dev.json"), configData, 0644); err != nil {
-		http.Error(w, fmt.Sprintf("Error creating config file: %v", err), http.StatusInternalServerError)
+	// Create website directory structure and config file
+	if err := createWebsiteFiles(website.Directory, "config-dev.json", devConfig); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error creating website", err)
 		return
 	}
 
 	// Save to admin database
 	_, err := s.CreateWebsite(website)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error saving website: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error saving website", err)
 		return
 	}
 
 	s.LogActivity("create", "website", 0, website.DatabaseName, website)
 
-	http.Redirect(w, r, "/superadmin", http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/superadmin"), http.StatusSeeOther)
 }
 
 // handleSuperadminUsers renders the user management page
@@ -776,7 +1328,7 @@ func (s *AdminServer) handleSuperadminUsers(w http.ResponseWriter, r *http.Reque
 // handleSuperadminUserCreate creates a new user
 func (s *AdminServer) handleSuperadminUserCreate(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
@@ -787,14 +1339,14 @@ func (s *AdminServer) handleSuperadminUserCreate(w http.ResponseWriter, r *http.
 
 	// Validate username
 	if username == "" || username == "admin" {
-		http.Error(w, "Invalid username", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid username", nil)
 		return
 	}
 
 	// Check if user already exists
 	for _, user := range s.EnvConfig.Admin.Users {
 		if user.Username == username {
-			http.Error(w, "User already exists", http.StatusBadRequest)
+			s.renderError(w, r, http.StatusBadRequest, "User already exists", nil)
 			return
 		}
 	}
@@ -802,7 +1354,7 @@ func (s *AdminServer) handleSuperadminUserCreate(w http.ResponseWriter, r *http.
 	// Hash password
 	passwordHash, err := hashPassword(password)
 	if err != nil {
-		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to hash password", nil)
 		return
 	}
 
@@ -819,19 +1371,19 @@ func (s *AdminServer) handleSuperadminUserCreate(w http.ResponseWriter, r *http.
 
 	// Save config file
 	if err := s.saveEnvironmentConfig(); err != nil {
-		http.Error(w, fmt.Sprintf("Error saving config: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error saving config", err)
 		return
 	}
 
 	s.LogActivity("create", "user", 0, username, newUser)
 
-	http.Redirect(w, r, "/superadmin/users", http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/superadmin/users"), http.StatusSeeOther)
 }
 
 // handleSuperadminUserUpdate updates a user's permissions and optionally resets password
 func (s *AdminServer) handleSuperadminUserUpdate(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
@@ -852,7 +1404,7 @@ func (s *AdminServer) handleSuperadminUserUpdate(w http.ResponseWriter, r *http.
 			if newPassword != "" {
 				newPasswordHash, err := hashPassword(newPassword)
 				if err != nil {
-					http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+					s.renderError(w, r, http.StatusInternalServerError, "Failed to hash password", nil)
 					return
 				}
 				s.EnvConfig.Admin.Users[i].PasswordHash = newPasswordHash
@@ -864,25 +1416,25 @@ func (s *AdminServer) handleSuperadminUserUpdate(w http.ResponseWriter, r *http.
 	}
 
 	if !found {
-		http.Error(w, "User not found", http.StatusNotFound)
+		s.renderError(w, r, http.StatusNotFound, "User not found", nil)
 		return
 	}
 
 	// Save config file
 	if err := s.saveEnvironmentConfig(); err != nil {
-		http.Error(w, fmt.Sprintf("Error saving config: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error saving config", err)
 		return
 	}
 
 	s.LogActivity("update", "user", 0, username, nil)
 
-	http.Redirect(w, r, "/superadmin/users", http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/superadmin/users"), http.StatusSeeOther)
 }
 
 // handleSuperadminUserDelete deletes a user
 func (s *AdminServer) handleSuperadminUserDelete(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
@@ -900,7 +1452,7 @@ func (s *AdminServer) handleSuperadminUserDelete(w http.ResponseWriter, r *http.
 	}
 
 	if !found {
-		http.Error(w, "User not found", http.StatusNotFound)
+		s.renderError(w, r, http.StatusNotFound, "User not found", nil)
 		return
 	}
 
@@ -908,13 +1460,13 @@ func (s *AdminServer) handleSuperadminUserDelete(w http.ResponseWriter, r *http.
 
 	// Save config file
 	if err := s.saveEnvironmentConfig(); err != nil {
-		http.Error(w, fmt.Sprintf("Error saving config: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error saving config", err)
 		return
 	}
 
 	s.LogActivity("delete", "user", 0, username, nil)
 
-	http.Redirect(w, r, "/superadmin/users", http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/superadmin/users"), http.StatusSeeOther)
 }
 
 // saveEnvironmentConfig saves the environment config to the appropriate file
@@ -943,7 +1495,7 @@ func (s *AdminServer) saveEnvironmentConfig() error {
 // handleWebsiteCreate creates a new website
 func (s *AdminServer) handleWebsiteCreate(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
@@ -956,21 +1508,11 @@ func (s *AdminServer) handleWebsiteCreate(w http.ResponseWriter, r *http.Request
 		APIVersion:    1,
 	}
 
-	// Create website directory structure
-	websiteDir := filepath.Join("websites", website.Directory)
-	if err := os.MkdirAll(websiteDir, 0755); err != nil {
-		http.Error(w, fmt.Sprintf("Error creating directory: %v", err), http.StatusInternalServerError)
+	if err := checkDatabaseName(website.DatabaseName); err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "", err)
 		return
 	}
 
-	// Create subdirectories
-	for _, dir := range []string{"templates", "public", "sitemaps"} {
-		if err := os.MkdirAll(filepath.Join(websiteDir, dir), 0755); err != nil {
-			http.Error(w, fmt.Sprintf("Error creating directory: %v", err), http.StatusInternalServerError)
-			return
-		}
-	}
-
 	// Create complete config file with all required fields
 	devConfig := map[string]interface{}{
 		"siteName":   website.SiteName,
@@ -1035,22 +1577,22 @@ func (s *AdminServer) handleWebsiteCreate(w http.ResponseWriter, r *http.Request
 		"logo":      "",
 	}
 
-	configData, _ := json.MarshalIndent(devConfig, "", "  ")
-	if err := os.WriteFile(filepath.Join(websiteDir, "config-dev.json"), configData, 0644); err != nil {
-		http.Error(w, fmt.Sprintf("Error creating config file: %v", err), http.StatusInternalServerError)
+	// Create website directory structure and config file
+	if err := createWebsiteFiles(website.Directory, "config-dev.json", devConfig); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error creating website", err)
 		return
 	}
 
 	// Save to admin database
 	_, err := s.CreateWebsite(website)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error saving website: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error saving website", err)
 		return
 	}
 
 	s.LogActivity("create", "website", 0, website.DatabaseName, website)
 
-	http.Redirect(w, r, fmt.Sprintf("/site/%s", website.DatabaseName), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s", website.DatabaseName), http.StatusSeeOther)
 }
 
 // handleWebsiteDelete deletes a website
@@ -1058,22 +1600,21 @@ func (s *AdminServer) handleWebsiteDelete(w http.ResponseWriter, r *http.Request
 	websiteID := chi.URLParam(r, "id")
 
 	if err := s.DeleteWebsite(websiteID); err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting website: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error deleting website", err)
 		return
 	}
 
 	s.LogActivity("delete", "website", 0, websiteID, nil)
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/"), http.StatusSeeOther)
 }
 
 // handleArticlesList renders the articles list for a website
 func (s *AdminServer) handleArticlesList(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
 
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
@@ -1082,6 +1623,9 @@ func (s *AdminServer) handleArticlesList(w http.ResponseWriter, r *http.Request)
 		log.Printf("Error loading articles: %v", err)
 		articles = []Article{}
 	}
+	for i := range articles {
+		articles[i].ThumbnailURL = s.signMediaURL(articles[i].ThumbnailURL)
+	}
 
 	s.renderWithLayout(w, r, "articles_list_content.html", map[string]interface{}{
 		"Title":         website.SiteName + " - Articles",
@@ -1095,9 +1639,8 @@ func (s *AdminServer) handleArticlesList(w http.ResponseWriter, r *http.Request)
 func (s *AdminServer) handleArticleNew(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
 
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
@@ -1120,7 +1663,7 @@ func (s *AdminServer) handleArticleNew(w http.ResponseWriter, r *http.Request) {
 		"Website":       website,
 		"Categories":    categories,
 		"Images":        images,
-		"Action":        fmt.Sprintf("/site/%s/articles/new", websiteID),
+		"Action":        s.adminURL("/site/%s/articles/new", websiteID),
 	})
 }
 
@@ -1130,7 +1673,7 @@ func (s *AdminServer) handleArticleCreate(w http.ResponseWriter, r *http.Request
 
 	// Parse multipart form for file uploads
 	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10 MB max
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
@@ -1138,7 +1681,7 @@ func (s *AdminServer) handleArticleCreate(w http.ResponseWriter, r *http.Request
 
 	// Validate slug
 	if err := validateSlug(slug); err != nil {
-		http.Error(w, fmt.Sprintf("Invalid slug: %v", err), http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid slug", err)
 		return
 	}
 
@@ -1171,36 +1714,23 @@ func (s *AdminServer) handleArticleCreate(w http.ResponseWriter, r *http.Request
 		defer file.Close()
 
 		// Get website info
-		website, err := s.GetWebsite(websiteID)
+		website, err := s.getWebsiteFromURL(r)
 		if err == nil {
-			// Create uploads directory if it doesn't exist
-			uploadsDir := filepath.Join("websites", website.Directory, "public", "uploads")
-			os.MkdirAll(uploadsDir, 0755)
-
-			// Generate unique filename
-			ext := filepath.Ext(header.Filename)
-			filename := fmt.Sprintf("%d_%s%s", time.Now().Unix(), strings.ReplaceAll(header.Filename[:len(header.Filename)-len(ext)], " ", "_"), ext)
-			filePath := filepath.Join(uploadsDir, filename)
-
-			// Save file
-			if dst, err := os.Create(filePath); err == nil {
-				defer dst.Close()
-				dst.ReadFrom(file)
-				fileInfo, _ := dst.Stat()
-
-				// Create image record with protocol-relative URL
-				imageURL := fmt.Sprintf("//%s/public/uploads/%s", website.HTTPAddress, filename)
+			// Save file to the upload backend
+			if stored, err := s.storeUpload(r.Context(), website, file, header); err == nil {
 				image := Image{
-					URL:      imageURL,
+					URL:      stored.URL,
 					AltText:  r.FormValue("image_alt"),
 					Credit:   r.FormValue("image_credit"),
 					Filename: header.Filename,
-					Size:     fileInfo.Size(),
+					Size:     stored.Size,
 				}
 
 				if imageID, err := s.CreateImage(websiteID, image); err == nil {
 					article.ThumbnailID = int(imageID)
 				}
+			} else {
+				log.Printf("Warning: Failed to store uploaded image %s: %v", header.Filename, err)
 			}
 		}
 	} else {
@@ -1215,7 +1745,7 @@ func (s *AdminServer) handleArticleCreate(w http.ResponseWriter, r *http.Request
 
 	id, err := s.CreateArticle(websiteID, article)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating article: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error creating article", err)
 		return
 	}
 
@@ -1235,7 +1765,59 @@ func (s *AdminServer) handleArticleCreate(w http.ResponseWriter, r *http.Request
 
 	s.LogActivity("create", "article", int(id), websiteID, article)
 
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/articles", websiteID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/articles", websiteID), http.StatusSeeOther)
+}
+
+// handleArticleTranslations shows an article's translations
+func (s *AdminServer) handleArticleTranslations(w http.ResponseWriter, r *http.Request) {
+	articleID, err := strconv.Atoi(chi.URLParam(r, "articleId"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid article ID", nil)
+		return
+	}
+
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	article, err := s.GetArticle(website.ID, articleID)
+	if err != nil {
+		s.renderError(w, r, http.StatusNotFound, "Article not found", nil)
+		return
+	}
+
+	s.renderTranslations(w, r, website, articleTranslations, articleID, article.Title, "article",
+		s.adminURL("/site/%s/articles/%d/edit", website.ID, articleID))
+}
+
+// handleArticleTranslationSave adds or replaces an article's translation into a locale
+func (s *AdminServer) handleArticleTranslationSave(w http.ResponseWriter, r *http.Request) {
+	articleID, err := strconv.Atoi(chi.URLParam(r, "articleId"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid article ID", nil)
+		return
+	}
+
+	s.saveTranslationForm(w, r, articleTranslations, articleID,
+		s.adminURL("/site/%s/articles/%d/translations", chi.URLParam(r, "id"), articleID))
+}
+
+// handleArticleTranslationDelete removes an article's translation into a locale
+func (s *AdminServer) handleArticleTranslationDelete(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	articleID, err := strconv.Atoi(chi.URLParam(r, "articleId"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid article ID", nil)
+		return
+	}
+
+	if err := s.deleteTranslation(websiteID, articleTranslations, articleID, chi.URLParam(r, "locale")); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error deleting translation", err)
+		return
+	}
+
+	http.Redirect(w, r, s.adminURL("/site/%s/articles/%d/translations", websiteID, articleID), http.StatusSeeOther)
 }
 
 // handleArticleEdit renders the edit article form
@@ -1244,19 +1826,18 @@ func (s *AdminServer) handleArticleEdit(w http.ResponseWriter, r *http.Request)
 
 	articleID, err := strconv.Atoi(chi.URLParam(r, "articleId"))
 	if err != nil {
-		http.Error(w, "Invalid article ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid article ID", nil)
 		return
 	}
 
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
 	article, err := s.GetArticle(websiteID, articleID)
 	if err != nil {
-		http.Error(w, "Article not found", http.StatusNotFound)
+		s.renderError(w, r, http.StatusNotFound, "Article not found", nil)
 		return
 	}
 
@@ -1288,7 +1869,7 @@ func (s *AdminServer) handleArticleEdit(w http.ResponseWriter, r *http.Request)
 		"Categories":        categories,
 		"Images":            images,
 		"ArticleCategories": articleCategories,
-		"Action":            fmt.Sprintf("/site/%s/articles/%d/edit", websiteID, articleID),
+		"Action":            s.adminURL("/site/%s/articles/%d/edit", websiteID, articleID),
 	})
 }
 
@@ -1298,20 +1879,20 @@ func (s *AdminServer) handleArticleUpdate(w http.ResponseWriter, r *http.Request
 
 	articleID, err := strconv.Atoi(chi.URLParam(r, "articleId"))
 	if err != nil {
-		http.Error(w, "Invalid article ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid article ID", nil)
 		return
 	}
 
 	// Parse multipart form for file uploads
 	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10 MB max
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
 	// Get the existing article to check if we need to set published date
 	existingArticle, err := s.GetArticle(websiteID, articleID)
 	if err != nil {
-		http.Error(w, "Article not found", http.StatusNotFound)
+		s.renderError(w, r, http.StatusNotFound, "Article not found", nil)
 		return
 	}
 
@@ -1319,7 +1900,7 @@ func (s *AdminServer) handleArticleUpdate(w http.ResponseWriter, r *http.Request
 
 	// Validate slug
 	if err := validateSlug(slug); err != nil {
-		http.Error(w, fmt.Sprintf("Invalid slug: %v", err), http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid slug", err)
 		return
 	}
 
@@ -1355,36 +1936,23 @@ func (s *AdminServer) handleArticleUpdate(w http.ResponseWriter, r *http.Request
 		defer file.Close()
 
 		// Get website info
-		website, err := s.GetWebsite(websiteID)
+		website, err := s.getWebsiteFromURL(r)
 		if err == nil {
-			// Create uploads directory if it doesn't exist
-			uploadsDir := filepath.Join("websites", website.Directory, "public", "uploads")
-			os.MkdirAll(uploadsDir, 0755)
-
-			// Generate unique filename
-			ext := filepath.Ext(header.Filename)
-			filename := fmt.Sprintf("%d_%s%s", time.Now().Unix(), strings.ReplaceAll(header.Filename[:len(header.Filename)-len(ext)], " ", "_"), ext)
-			filePath := filepath.Join(uploadsDir, filename)
-
-			// Save file
-			if dst, err := os.Create(filePath); err == nil {
-				defer dst.Close()
-				dst.ReadFrom(file)
-				fileInfo, _ := dst.Stat()
-
-				// Create image record with protocol-relative URL
-				imageURL := fmt.Sprintf("//%s/public/uploads/%s", website.HTTPAddress, filename)
+			// Save file to the upload backend
+			if stored, err := s.storeUpload(r.Context(), website, file, header); err == nil {
 				image := Image{
-					URL:      imageURL,
+					URL:      stored.URL,
 					AltText:  r.FormValue("image_alt"),
 					Credit:   r.FormValue("image_credit"),
 					Filename: header.Filename,
-					Size:     fileInfo.Size(),
+					Size:     stored.Size,
 				}
 
 				if imageID, err := s.CreateImage(websiteID, image); err == nil {
 					article.ThumbnailID = int(imageID)
 				}
+			} else {
+				log.Printf("Warning: Failed to store uploaded image %s: %v", header.Filename, err)
 			}
 		}
 	} else {
@@ -1401,7 +1969,7 @@ func (s *AdminServer) handleArticleUpdate(w http.ResponseWriter, r *http.Request
 	}
 
 	if err := s.UpdateArticle(websiteID, article); err != nil {
-		http.Error(w, fmt.Sprintf("Error updating article: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error updating article", err)
 		return
 	}
 
@@ -1420,7 +1988,7 @@ func (s *AdminServer) handleArticleUpdate(w http.ResponseWriter, r *http.Request
 
 	s.LogActivity("update", "article", articleID, websiteID, article)
 
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/articles", websiteID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/articles", websiteID), http.StatusSeeOther)
 }
 
 // handleArticleDelete deletes an article
@@ -1429,35 +1997,37 @@ func (s *AdminServer) handleArticleDelete(w http.ResponseWriter, r *http.Request
 
 	articleID, err := strconv.Atoi(chi.URLParam(r, "articleId"))
 	if err != nil {
-		http.Error(w, "Invalid article ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid article ID", nil)
 		return
 	}
 
 	if err := s.DeleteArticle(websiteID, articleID); err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting article: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error deleting article", err)
 		return
 	}
 
 	s.LogActivity("delete", "article", articleID, websiteID, nil)
 
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/articles", websiteID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/articles", websiteID), http.StatusSeeOther)
 }
 
 // Product handlers (similar pattern)
 func (s *AdminServer) handleProductsList(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
 
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
-	products, err := s.GetProducts(websiteID, 100, 0)
+	products, err := s.GetProducts(websiteID, 100, 0, true)
 	if err != nil {
 		log.Printf("Error loading products: %v", err)
 		products = []Product{}
 	}
+	for i := range products {
+		products[i].PrimaryImageURL = s.signMediaURL(products[i].PrimaryImageURL)
+	}
 
 	s.renderWithLayout(w, r, "products_list_content.html", map[string]interface{}{
 		"Title":         website.SiteName + " - Products",
@@ -1470,9 +2040,8 @@ func (s *AdminServer) handleProductsList(w http.ResponseWriter, r *http.Request)
 func (s *AdminServer) handleProductNew(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
 
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
@@ -1488,44 +2057,68 @@ func (s *AdminServer) handleProductNew(w http.ResponseWriter, r *http.Request) {
 		"FormTitle":     "Create New Product",
 		"Website":       website,
 		"Collections":   collections,
-		"Action":        fmt.Sprintf("/site/%s/products/new", websiteID),
+		"Action":        s.adminURL("/site/%s/products/new", websiteID),
 	})
 }
 
+// applyPreorderForm sets the product's preorder flag from the product form, and for a preorder the
+// release date it ships from. It returns false when a preorder has no valid release date
+func applyPreorderForm(r *http.Request, product *Product) bool {
+	product.Preorder = r.FormValue("preorder") == "on"
+	if !product.Preorder {
+		return true
+	}
+
+	releaseDate, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(r.FormValue("releaseDate")), time.Local)
+	if err != nil {
+		return false
+	}
+	product.ReleasedDate = releaseDate
+	return true
+}
+
 func (s *AdminServer) handleProductCreate(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
 
 	if err := r.ParseMultipartForm(32 << 20); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
 	price, _ := strconv.ParseFloat(r.FormValue("price"), 64)
 	compareAtPrice, _ := strconv.ParseFloat(r.FormValue("compareAtPrice"), 64)
 	inventoryQuantity, _ := strconv.Atoi(r.FormValue("inventoryQuantity"))
+	maxPerOrder, _ := strconv.Atoi(r.FormValue("maxPerOrder"))
 	featured := r.FormValue("featured") == "on"
 
 	product := Product{
-		Name:              r.FormValue("name"),
-		Slug:              r.FormValue("slug"),
-		Description:       r.FormValue("description"),
-		Price:             price,
-		CompareAtPrice:    compareAtPrice,
-		SKU:               r.FormValue("sku"),
-		InventoryQuantity: inventoryQuantity,
-		InventoryPolicy:   r.FormValue("inventoryPolicy"),
-		Status:            r.FormValue("status"),
-		Featured:          featured,
-	}
-
-	// Set released date to now if status is published
-	if product.Status == "published" {
+		Name:                 r.FormValue("name"),
+		Slug:                 r.FormValue("slug"),
+		Description:          r.FormValue("description"),
+		Price:                price,
+		CompareAtPrice:       compareAtPrice,
+		SKU:                  r.FormValue("sku"),
+		InventoryQuantity:    inventoryQuantity,
+		InventoryPolicy:      r.FormValue("inventoryPolicy"),
+		MaxPerOrder:          maxPerOrder,
+		SubscriptionInterval: r.FormValue("subscriptionInterval"),
+		Status:               r.FormValue("status"),
+		Featured:             featured,
+	}
+
+	if !applyPreorderForm(r, &product) {
+		s.renderError(w, r, http.StatusBadRequest, "Preorder products need a release date", nil)
+		return
+	}
+
+	// Set released date to now if status is published, preorders keep their release date
+	if product.Status == "published" && !product.Preorder {
 		product.ReleasedDate = time.Now()
 	}
 
-	id, err := s.CreateProduct(websiteID, product)
+	id, err := s.CreateProduct(websiteID, product, s.getSessionUsername(r))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating product: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error creating product", err)
 		return
 	}
 
@@ -1546,7 +2139,7 @@ func (s *AdminServer) handleProductCreate(w http.ResponseWriter, r *http.Request
 	}
 
 	// Handle product images - direct upload to product_images_data
-	website, _ := s.GetWebsite(websiteID)
+	website, _ := s.getWebsiteFromURL(r)
 	if website.ID != "" {
 		files := r.MultipartForm.File["product_images"]
 		altText := r.FormValue("new_images_alt")
@@ -1559,34 +2152,22 @@ func (s *AdminServer) handleProductCreate(w http.ResponseWriter, r *http.Request
 			}
 			defer file.Close()
 
-			// Create uploads directory
-			uploadsDir := filepath.Join("websites", website.Directory, "public", "uploads")
-			os.MkdirAll(uploadsDir, 0755)
-
-			// Generate unique filename
-			ext := filepath.Ext(fileHeader.Filename)
-			filename := fmt.Sprintf("%d_%s%s", time.Now().UnixNano(), strings.ReplaceAll(fileHeader.Filename[:len(fileHeader.Filename)-len(ext)], " ", "_"), ext)
-			filePath := filepath.Join(uploadsDir, filename)
-
-			// Save file
-			dst, err := os.Create(filePath)
+			// Save file, privately until the product is published
+			stored, err := s.storeProductImage(r.Context(), website, file, fileHeader, product.Status == "published")
 			if err != nil {
+				log.Printf("Warning: Failed to store uploaded image %s: %v", fileHeader.Filename, err)
 				continue
 			}
-			dst.ReadFrom(file)
-			fileInfo, _ := dst.Stat()
-			dst.Close()
 
 			// Create product image record directly (no shared image library)
-			imageURL := fmt.Sprintf("//%s/public/uploads/%s", website.HTTPAddress, filename)
 			productImage := ProductImageData{
 				ProductID: productID,
-				URL:       imageURL,
+				URL:       stored.URL,
 				Filename:  fileHeader.Filename,
-				Filepath:  filePath,
+				Filepath:  stored.Path,
 				AltText:   altText,
 				Credit:    credit,
-				Size:      fileInfo.Size(),
+				Size:      stored.Size,
 				Position:  position,
 			}
 
@@ -1596,7 +2177,7 @@ func (s *AdminServer) handleProductCreate(w http.ResponseWriter, r *http.Request
 
 	s.LogActivity("create", "product", productID, websiteID, product)
 
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/products", websiteID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/products", websiteID), http.StatusSeeOther)
 }
 
 func (s *AdminServer) handleProductEdit(w http.ResponseWriter, r *http.Request) {
@@ -1604,19 +2185,18 @@ func (s *AdminServer) handleProductEdit(w http.ResponseWriter, r *http.Request)
 
 	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
 	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid product ID", nil)
 		return
 	}
 
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
 	product, err := s.GetProduct(websiteID, productID)
 	if err != nil {
-		http.Error(w, "Product not found", http.StatusNotFound)
+		s.renderError(w, r, http.StatusNotFound, "Product not found", nil)
 		return
 	}
 
@@ -1633,12 +2213,38 @@ func (s *AdminServer) handleProductEdit(w http.ResponseWriter, r *http.Request)
 		productCollections = []Collection{}
 	}
 
-	// Load product images
+	// Load product images, with signed links for ones that are still private
 	productImages, err := s.GetProductImagesData(websiteID, productID)
 	if err != nil {
 		log.Printf("Error loading product images: %v", err)
 		productImages = []ProductImageData{}
 	}
+	for i := range productImages {
+		productImages[i].URL = s.signMediaURL(productImages[i].URL)
+	}
+
+	priceHistory, err := s.GetPriceHistory(websiteID, productID)
+	if err != nil {
+		log.Printf("Error loading price history: %v", err)
+		priceHistory = []PriceChange{}
+	}
+
+	salesDays := 90
+	if d, err := strconv.Atoi(r.URL.Query().Get("sales_days")); err == nil && d > 0 {
+		salesDays = d
+	}
+	now := time.Now()
+	variantSales, err := s.GetSalesByVariant(websiteID, productID, now.AddDate(0, 0, -salesDays), now)
+	if err != nil {
+		log.Printf("Error loading variant sales: %v", err)
+		variantSales = []VariantSales{}
+	}
+
+	bundleItems, err := s.GetBundleItems(websiteID, productID)
+	if err != nil {
+		log.Printf("Error loading bundle items: %v", err)
+		bundleItems = []BundleItem{}
+	}
 
 	s.renderWithLayout(w, r, "product_form_content.html", map[string]interface{}{
 		"Title":              website.SiteName + " - Edit Product",
@@ -1649,8 +2255,193 @@ func (s *AdminServer) handleProductEdit(w http.ResponseWriter, r *http.Request)
 		"Collections":        collections,
 		"ProductCollections": productCollections,
 		"ProductImages":      productImages,
-		"Action":             fmt.Sprintf("/site/%s/products/%d/edit", websiteID, productID),
+		"PriceHistory":       priceHistory,
+		"VariantSales":       variantSales,
+		"SalesDays":          salesDays,
+		"BundleItems":        bundleItems,
+		"BundleError":        r.URL.Query().Get("bundle_error"),
+		"Action":             s.adminURL("/site/%s/products/%d/edit", websiteID, productID),
+	})
+}
+
+// renderTranslations shows a product's or article's translations with a form to add or edit one
+func (s *AdminServer) renderTranslations(w http.ResponseWriter, r *http.Request, website Website, kind translatable, id int, itemName, itemType, itemURL string) {
+	translations, err := s.getTranslations(website.ID, kind, id)
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error loading translations", err)
+		return
+	}
+
+	// Editing an existing translation prefills the form
+	editing := Translation{Locale: r.URL.Query().Get("locale")}
+	for _, t := range translations {
+		if t.Locale == editing.Locale {
+			editing = t
+		}
+	}
+
+	section := "products"
+	if kind.table == articleTranslations.table {
+		section = "articles"
+	}
+
+	s.renderWithLayout(w, r, "translations_content.html", map[string]interface{}{
+		"Title":         website.SiteName + " - Translations",
+		"ActiveSection": section,
+		"Website":       website,
+		"ItemName":      itemName,
+		"ItemType":      itemType,
+		"ItemURL":       itemURL,
+		"PageURL":       strings.TrimSuffix(itemURL, "/edit") + "/translations",
+		"Fields":        kind.fields,
+		"Translations":  translations,
+		"Editing":       editing,
+		"Error":         r.URL.Query().Get("error"),
+	})
+}
+
+// saveTranslationForm saves the translation posted from renderTranslations's form and redirects back
+func (s *AdminServer) saveTranslationForm(w http.ResponseWriter, r *http.Request, kind translatable, id int, pageURL string) {
+	websiteID := chi.URLParam(r, "id")
+
+	values := make(map[string]string, len(kind.fields))
+	for _, f := range kind.fields {
+		values[f.Column] = strings.TrimSpace(r.FormValue(f.Column))
+	}
+
+	locale := r.FormValue("locale")
+	if err := s.saveTranslation(websiteID, kind, id, locale, values); err == errInvalidLocale {
+		http.Redirect(w, r, pageURL+"?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error saving translation", err)
+		return
+	}
+
+	s.LogActivity("update", strings.TrimSuffix(kind.table, "_translations"), id, websiteID, map[string]interface{}{
+		"translation": utils.NormalizeLocale(locale),
+	})
+
+	http.Redirect(w, r, pageURL, http.StatusSeeOther)
+}
+
+// handleProductTranslations shows a product's translations
+func (s *AdminServer) handleProductTranslations(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid product ID", nil)
+		return
+	}
+
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	product, err := s.GetProduct(website.ID, productID)
+	if err != nil {
+		s.renderError(w, r, http.StatusNotFound, "Product not found", nil)
+		return
+	}
+
+	s.renderTranslations(w, r, website, productTranslations, productID, product.Name, "product",
+		s.adminURL("/site/%s/products/%d/edit", website.ID, productID))
+}
+
+// handleProductTranslationSave adds or replaces a product's translation into a locale
+func (s *AdminServer) handleProductTranslationSave(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid product ID", nil)
+		return
+	}
+
+	s.saveTranslationForm(w, r, productTranslations, productID,
+		s.adminURL("/site/%s/products/%d/translations", chi.URLParam(r, "id"), productID))
+}
+
+// handleProductTranslationDelete removes a product's translation into a locale
+func (s *AdminServer) handleProductTranslationDelete(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid product ID", nil)
+		return
+	}
+
+	if err := s.deleteTranslation(websiteID, productTranslations, productID, chi.URLParam(r, "locale")); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error deleting translation", err)
+		return
+	}
+
+	http.Redirect(w, r, s.adminURL("/site/%s/products/%d/translations", websiteID, productID), http.StatusSeeOther)
+}
+
+// handleBundleItemAdd adds a product to a bundle by SKU or slug
+func (s *AdminServer) handleBundleItemAdd(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid product ID", nil)
+		return
+	}
+
+	editURL := s.adminURL("/site/%s/products/%d/edit", websiteID, productID)
+
+	ref := strings.TrimSpace(r.FormValue("component"))
+	quantity, err := strconv.Atoi(r.FormValue("quantity"))
+	if err != nil {
+		quantity = 1
+	}
+
+	componentID, err := s.FindProductID(websiteID, ref, ref)
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error finding product", err)
+		return
+	}
+	if componentID == 0 {
+		http.Redirect(w, r, editURL+"?bundle_error="+url.QueryEscape("No product has the SKU or slug "+ref), http.StatusSeeOther)
+		return
+	}
+
+	if err := s.AddBundleItem(websiteID, productID, componentID, quantity); err != nil {
+		http.Redirect(w, r, editURL+"?bundle_error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	s.LogActivity("update", "product", productID, websiteID, map[string]interface{}{
+		"bundle_component": componentID,
+		"quantity":         quantity,
+	})
+
+	http.Redirect(w, r, editURL, http.StatusSeeOther)
+}
+
+// handleBundleItemDelete takes a product out of a bundle
+func (s *AdminServer) handleBundleItemDelete(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid product ID", nil)
+		return
+	}
+
+	componentID, err := strconv.Atoi(chi.URLParam(r, "componentId"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid component ID", nil)
+		return
+	}
+
+	if err := s.RemoveBundleItem(websiteID, productID, componentID); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error removing bundle item", err)
+		return
+	}
+
+	s.LogActivity("update", "product", productID, websiteID, map[string]interface{}{
+		"bundle_component_removed": componentID,
 	})
+
+	http.Redirect(w, r, s.adminURL("/site/%s/products/%d/edit", websiteID, productID), http.StatusSeeOther)
 }
 
 func (s *AdminServer) handleProductUpdate(w http.ResponseWriter, r *http.Request) {
@@ -1658,40 +2449,48 @@ func (s *AdminServer) handleProductUpdate(w http.ResponseWriter, r *http.Request
 
 	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
 	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid product ID", nil)
 		return
 	}
 
 	if err := r.ParseMultipartForm(32 << 20); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
 	// Get the existing product to check if we need to set published date
 	existingProduct, err := s.GetProduct(websiteID, productID)
 	if err != nil {
-		http.Error(w, "Product not found", http.StatusNotFound)
+		s.renderError(w, r, http.StatusNotFound, "Product not found", nil)
 		return
 	}
 
 	price, _ := strconv.ParseFloat(r.FormValue("price"), 64)
 	compareAtPrice, _ := strconv.ParseFloat(r.FormValue("compareAtPrice"), 64)
 	inventoryQuantity, _ := strconv.Atoi(r.FormValue("inventoryQuantity"))
+	maxPerOrder, _ := strconv.Atoi(r.FormValue("maxPerOrder"))
 	featured := r.FormValue("featured") == "on"
 
 	product := Product{
-		ID:                productID,
-		Name:              r.FormValue("name"),
-		Slug:              r.FormValue("slug"),
-		Description:       r.FormValue("description"),
-		Price:             price,
-		CompareAtPrice:    compareAtPrice,
-		SKU:               r.FormValue("sku"),
-		InventoryQuantity: inventoryQuantity,
-		InventoryPolicy:   r.FormValue("inventoryPolicy"),
-		Status:            r.FormValue("status"),
-		Featured:          featured,
-		ReleasedDate:      existingProduct.ReleasedDate,
+		ID:                   productID,
+		Name:                 r.FormValue("name"),
+		Slug:                 r.FormValue("slug"),
+		Description:          r.FormValue("description"),
+		Price:                price,
+		CompareAtPrice:       compareAtPrice,
+		SKU:                  r.FormValue("sku"),
+		InventoryQuantity:    inventoryQuantity,
+		InventoryPolicy:      r.FormValue("inventoryPolicy"),
+		MaxPerOrder:          maxPerOrder,
+		SubscriptionInterval: r.FormValue("subscriptionInterval"),
+		Status:               r.FormValue("status"),
+		Featured:             featured,
+		ReleasedDate:         existingProduct.ReleasedDate,
+	}
+
+	if !applyPreorderForm(r, &product) {
+		s.renderError(w, r, http.StatusBadRequest, "Preorder products need a release date", nil)
+		return
 	}
 
 	// Set released date to now if status is published and it wasn't published before
@@ -1699,8 +2498,8 @@ func (s *AdminServer) handleProductUpdate(w http.ResponseWriter, r *http.Request
 		product.ReleasedDate = time.Now()
 	}
 
-	if err := s.UpdateProduct(websiteID, product); err != nil {
-		http.Error(w, fmt.Sprintf("Error updating product: %v", err), http.StatusInternalServerError)
+	if err := s.UpdateProduct(websiteID, product, s.getSessionUsername(r)); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error updating product", err)
 		return
 	}
 
@@ -1730,7 +2529,7 @@ func (s *AdminServer) handleProductUpdate(w http.ResponseWriter, r *http.Request
 	position := len(currentImages)
 
 	// Upload and add new images directly to product_images_data
-	website, _ := s.GetWebsite(websiteID)
+	website, _ := s.getWebsiteFromURL(r)
 	if website.ID != "" {
 		files := r.MultipartForm.File["product_images"]
 		altText := r.FormValue("new_images_alt")
@@ -1743,34 +2542,22 @@ func (s *AdminServer) handleProductUpdate(w http.ResponseWriter, r *http.Request
 			}
 			defer file.Close()
 
-			// Create uploads directory
-			uploadsDir := filepath.Join("websites", website.Directory, "public", "uploads")
-			os.MkdirAll(uploadsDir, 0755)
-
-			// Generate unique filename
-			ext := filepath.Ext(fileHeader.Filename)
-			filename := fmt.Sprintf("%d_%s%s", time.Now().UnixNano(), strings.ReplaceAll(fileHeader.Filename[:len(fileHeader.Filename)-len(ext)], " ", "_"), ext)
-			filePath := filepath.Join(uploadsDir, filename)
-
-			// Save file
-			dst, err := os.Create(filePath)
+			// Save file, privately until the product is published
+			stored, err := s.storeProductImage(r.Context(), website, file, fileHeader, product.Status == "published")
 			if err != nil {
+				log.Printf("Warning: Failed to store uploaded image %s: %v", fileHeader.Filename, err)
 				continue
 			}
-			dst.ReadFrom(file)
-			fileInfo, _ := dst.Stat()
-			dst.Close()
 
 			// Create product image record directly (no shared image library)
-			imageURL := fmt.Sprintf("//%s/public/uploads/%s", website.HTTPAddress, filename)
 			productImage := ProductImageData{
 				ProductID: productID,
-				URL:       imageURL,
+				URL:       stored.URL,
 				Filename:  fileHeader.Filename,
-				Filepath:  filePath,
+				Filepath:  stored.Path,
 				AltText:   altText,
 				Credit:    credit,
-				Size:      fileInfo.Size(),
+				Size:      stored.Size,
 				Position:  position,
 			}
 
@@ -1781,7 +2568,7 @@ func (s *AdminServer) handleProductUpdate(w http.ResponseWriter, r *http.Request
 
 	s.LogActivity("update", "product", productID, websiteID, product)
 
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/products", websiteID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/products", websiteID), http.StatusSeeOther)
 }
 
 func (s *AdminServer) handleProductDelete(w http.ResponseWriter, r *http.Request) {
@@ -1789,113 +2576,630 @@ func (s *AdminServer) handleProductDelete(w http.ResponseWriter, r *http.Request
 
 	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
 	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid product ID", nil)
 		return
 	}
 
 	if err := s.DeleteProduct(websiteID, productID); err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting product: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error deleting product", err)
 		return
 	}
 
 	s.LogActivity("delete", "product", productID, websiteID, nil)
 
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/products", websiteID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/products", websiteID), http.StatusSeeOther)
 }
 
-func (s *AdminServer) handleProductImageReorder(w http.ResponseWriter, r *http.Request) {
-	websiteID := chi.URLParam(r, "id")
-	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   "Invalid product ID",
-		})
-		return
-	}
+// productImportColumns are the CSV headers handleProductImport understands
+var productImportColumns = map[string]bool{
+	"name": true, "slug": true, "description": true, "price": true,
+	"sku": true, "inventory": true, "status": true, "collections": true,
+}
 
-	// Parse JSON body with array of image IDs in new order
-	var requestData struct {
-		ImageIDs []int `json:"imageIds"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   "Invalid request data",
-		})
-		return
-	}
+// ProductImportResult reports what happened to one CSV row
+type ProductImportResult struct {
+	Row    int
+	Name   string
+	Action string // "created" or "updated"
+	Error  string
+}
 
-	// Update positions
-	if err := s.UpdateProductImagePositions(websiteID, requestData.ImageIDs); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   fmt.Sprintf("Error updating image positions: %v", err),
-		})
+func (s *AdminServer) handleProductImportForm(w http.ResponseWriter, r *http.Request) {
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
-	s.LogActivity("reorder", "product_images", productID, websiteID, nil)
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
+	s.renderWithLayout(w, r, "product_import_content.html", map[string]interface{}{
+		"Title":         website.SiteName + " - Import Products",
+		"ActiveSection": "products",
+		"Website":       website,
 	})
 }
 
-func (s *AdminServer) handleProductReorder(w http.ResponseWriter, r *http.Request) {
+// handleProductImport creates or updates products from an uploaded CSV. Rows are matched to
+// existing products by SKU, then slug; every row is reported individually so one bad row doesn't
+// stop the rest of the import
+func (s *AdminServer) handleProductImport(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
-	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
-	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
-		return
-	}
 
-	direction := chi.URLParam(r, "direction")
-	if direction != "up" && direction != "down" {
-		http.Error(w, "Invalid direction", http.StatusBadRequest)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
-	if err := s.ReorderProduct(websiteID, productID, direction); err != nil {
-		http.Error(w, fmt.Sprintf("Error reordering product: %v", err), http.StatusInternalServerError)
-		return
+	renderError := func(message string) {
+		s.renderWithLayout(w, r, "product_import_content.html", map[string]interface{}{
+			"Title":         website.SiteName + " - Import Products",
+			"ActiveSection": "products",
+			"Website":       website,
+			"Error":         message,
+		})
 	}
 
-	s.LogActivity("reorder", "product", productID, websiteID, map[string]string{"direction": direction})
-
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/products", websiteID), http.StatusSeeOther)
-}
-
-// Variant handlers
-func (s *AdminServer) handleVariantNew(w http.ResponseWriter, r *http.Request) {
-	websiteID := chi.URLParam(r, "id")
-	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
-	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		renderError("Invalid form data")
 		return
 	}
 
-	website, err := s.GetWebsite(websiteID)
+	file, _, err := r.FormFile("csv_file")
 	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+		renderError("Please choose a CSV file to import")
 		return
 	}
+	defer file.Close()
 
-	product, err := s.GetProduct(websiteID, productID)
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
 	if err != nil {
-		http.Error(w, "Product not found", http.StatusNotFound)
+		renderError(fmt.Sprintf("Could not read CSV header: %v", err))
 		return
 	}
 
-	s.renderWithLayout(w, r, "variant_form_content.html", map[string]interface{}{
-		"Title":         "New Variant",
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if strings.HasPrefix(name, "variant_") {
+			continue // variant columns from an export with ?variants=true are informational
+		}
+		if !productImportColumns[name] {
+			renderError(fmt.Sprintf("Unknown column %q. Allowed columns: name, slug, description, price, sku, inventory, status, collections", name))
+			return
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		renderError("The CSV must have a name column")
+		return
+	}
+	if _, ok := columns["price"]; !ok {
+		renderError("The CSV must have a price column")
+		return
+	}
+
+	// Collections are referenced by slug or name
+	collections, err := s.GetCollections(websiteID)
+	if err != nil {
+		log.Printf("Error loading collections: %v", err)
+		collections = []Collection{}
+	}
+	collectionIDs := make(map[string]int)
+	for _, c := range collections {
+		collectionIDs[strings.ToLower(c.Slug)] = c.ID
+		collectionIDs[strings.ToLower(c.Name)] = c.ID
+	}
+
+	username := s.getSessionUsername(r)
+	var results []ProductImportResult
+	created, updated, failed := 0, 0, 0
+
+	for rowNum := 2; ; rowNum++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		result := ProductImportResult{Row: rowNum}
+		if err != nil {
+			result.Error = fmt.Sprintf("Could not parse row: %v", err)
+			results = append(results, result)
+			failed++
+			continue
+		}
+
+		field := func(name string) (string, bool) {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return "", false
+			}
+			return strings.TrimSpace(record[i]), true
+		}
+
+		result.Action, result.Error = s.importProductRow(websiteID, username, field, collectionIDs)
+		result.Name, _ = field("name")
+		if result.Error != "" {
+			failed++
+		} else if result.Action == "created" {
+			created++
+		} else {
+			updated++
+		}
+		results = append(results, result)
+	}
+
+	s.LogActivity("import", "product", 0, websiteID, map[string]int{"created": created, "updated": updated, "failed": failed})
+
+	s.renderWithLayout(w, r, "product_import_content.html", map[string]interface{}{
+		"Title":         website.SiteName + " - Import Products",
+		"ActiveSection": "products",
+		"Website":       website,
+		"Results":       results,
+		"Created":       created,
+		"Updated":       updated,
+		"Failed":        failed,
+	})
+}
+
+// importProductRow validates a single CSV row and creates or updates its product. It returns the
+// action taken, or an error message for the row
+func (s *AdminServer) importProductRow(websiteID string, username string, field func(string) (string, bool), collectionIDs map[string]int) (string, string) {
+	name, _ := field("name")
+	if name == "" {
+		return "", "name is required"
+	}
+
+	priceStr, _ := field("price")
+	price, err := strconv.ParseFloat(strings.TrimPrefix(priceStr, "$"), 64)
+	if err != nil || price < 0 {
+		return "", fmt.Sprintf("invalid price %q", priceStr)
+	}
+
+	sku, _ := field("sku")
+	slug, hasSlug := field("slug")
+	if slug == "" {
+		slug = strings.ToLower(strings.ReplaceAll(name, " ", "-"))
+	}
+	if err := validateSlug(slug); err != nil {
+		return "", fmt.Sprintf("invalid slug %q: %v", slug, err)
+	}
+
+	inventoryStr, hasInventory := field("inventory")
+	inventory := 0
+	if inventoryStr != "" {
+		inventory, err = strconv.Atoi(inventoryStr)
+		if err != nil {
+			return "", fmt.Sprintf("invalid inventory %q", inventoryStr)
+		}
+	}
+
+	status, hasStatus := field("status")
+	status = strings.ToLower(status)
+	if status != "" && status != "draft" && status != "published" && status != "archived" {
+		return "", fmt.Sprintf("invalid status %q (use draft, published or archived)", status)
+	}
+
+	var rowCollections []int
+	collectionsStr, hasCollections := field("collections")
+	if collectionsStr != "" {
+		for _, ref := range strings.Split(collectionsStr, "|") {
+			ref = strings.ToLower(strings.TrimSpace(ref))
+			if ref == "" {
+				continue
+			}
+			id, ok := collectionIDs[ref]
+			if !ok {
+				return "", fmt.Sprintf("unknown collection %q", ref)
+			}
+			rowCollections = append(rowCollections, id)
+		}
+	}
+
+	lookupSlug := ""
+	if hasSlug {
+		lookupSlug = slug
+	}
+	existingID, err := s.FindProductID(websiteID, sku, lookupSlug)
+	if err != nil {
+		return "", fmt.Sprintf("lookup failed: %v", err)
+	}
+
+	action := "updated"
+	productID := existingID
+	if existingID == 0 {
+		action = "created"
+		if status == "" {
+			status = "draft"
+		}
+		product := Product{
+			Name:              name,
+			Slug:              slug,
+			Price:             price,
+			SKU:               sku,
+			InventoryQuantity: inventory,
+			InventoryPolicy:   "deny",
+			Status:            status,
+		}
+		product.Description, _ = field("description")
+		if status == "published" {
+			product.ReleasedDate = time.Now()
+		}
+
+		id, err := s.CreateProduct(websiteID, product, username)
+		if err != nil {
+			return "", fmt.Sprintf("create failed: %v", err)
+		}
+		productID = int(id)
+	} else {
+		// Only overwrite the columns present in the file
+		product, err := s.GetProduct(websiteID, existingID)
+		if err != nil {
+			return "", fmt.Sprintf("load failed: %v", err)
+		}
+		product.Name = name
+		product.Price = price
+		if hasSlug {
+			product.Slug = slug
+		}
+		if description, ok := field("description"); ok {
+			product.Description = description
+		}
+		if sku != "" {
+			product.SKU = sku
+		}
+		if hasInventory && inventoryStr != "" {
+			product.InventoryQuantity = inventory
+		}
+		if hasStatus && status != "" {
+			if status == "published" && product.ReleasedDate.IsZero() {
+				product.ReleasedDate = time.Now()
+			}
+			product.Status = status
+		}
+
+		if err := s.UpdateProduct(websiteID, product, username); err != nil {
+			return "", fmt.Sprintf("update failed: %v", err)
+		}
+	}
+
+	if hasCollections {
+		if err := s.SetProductCollections(websiteID, productID, rowCollections); err != nil {
+			return action, fmt.Sprintf("saved, but setting collections failed: %v", err)
+		}
+	}
+
+	return action, ""
+}
+
+func (s *AdminServer) handleStocktakeForm(w http.ResponseWriter, r *http.Request) {
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	s.renderWithLayout(w, r, "stocktake_content.html", map[string]interface{}{
+		"Title":         website.SiteName + " - Stocktake",
+		"ActiveSection": "products",
+		"Website":       website,
+	})
+}
+
+// parseStocktakeCounts reads "sku,count" rows, from an uploaded CSV or pasted into the form. A
+// header row is optional, and a SKU counted on several rows (e.g. in two locations) is summed
+func parseStocktakeCounts(reader io.Reader) (map[string]int, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.TrimLeadingSpace = true
+	csvReader.FieldsPerRecord = -1
+
+	counts := make(map[string]int)
+	for rowNum := 1; ; rowNum++ {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse row %d: %v", rowNum, err)
+		}
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("row %d: expected a SKU and a count", rowNum)
+		}
+
+		sku := strings.TrimSpace(strings.TrimPrefix(record[0], "\ufeff"))
+		countStr := strings.TrimSpace(record[1])
+		count, err := strconv.Atoi(countStr)
+		if err != nil {
+			if rowNum == 1 && strings.EqualFold(sku, "sku") {
+				continue
+			}
+			return nil, fmt.Errorf("row %d: invalid count %q", rowNum, countStr)
+		}
+		if sku == "" {
+			return nil, fmt.Errorf("row %d: SKU is required", rowNum)
+		}
+		if count < 0 {
+			return nil, fmt.Errorf("row %d: count can't be negative", rowNum)
+		}
+		counts[sku] += count
+	}
+
+	if len(counts) == 0 {
+		return nil, fmt.Errorf("no counts found")
+	}
+	return counts, nil
+}
+
+// formatStocktakeCounts writes counts back out as "sku,count" rows, so the variance report can
+// carry exactly what was previewed into the apply step
+func formatStocktakeCounts(counts map[string]int) string {
+	skus := make([]string, 0, len(counts))
+	for sku := range counts {
+		skus = append(skus, sku)
+	}
+	sort.Strings(skus)
+
+	var b strings.Builder
+	writer := csv.NewWriter(&b)
+	for _, sku := range skus {
+		writer.Write([]string{sku, strconv.Itoa(counts[sku])})
+	}
+	writer.Flush()
+	return b.String()
+}
+
+// renderStocktake shows the stocktake page, with a summary of the variance report when data has Lines
+func (s *AdminServer) renderStocktake(w http.ResponseWriter, r *http.Request, website Website, data map[string]interface{}) {
+	data["Title"] = website.SiteName + " - Stocktake"
+	data["ActiveSection"] = "products"
+	data["Website"] = website
+
+	if lines, ok := data["Lines"].([]StocktakeLine); ok {
+		data["Changed"], data["Unmatched"], data["NetVariance"] = stocktakeSummary(lines)
+	}
+
+	s.renderWithLayout(w, r, "stocktake_content.html", data)
+}
+
+// stocktakeSummary counts the lines whose quantity changes and the ones that couldn't be matched,
+// and totals the variance of the changed ones
+func stocktakeSummary(lines []StocktakeLine) (changed, unmatched, net int) {
+	for _, line := range lines {
+		if line.Error != "" {
+			unmatched++
+		} else if line.Variance() != 0 {
+			changed++
+			net += line.Variance()
+		}
+	}
+	return changed, unmatched, net
+}
+
+// handleStocktakePreview compares uploaded or pasted counts with inventory and shows the variance
+// report. Nothing changes until it's applied
+func (s *AdminServer) handleStocktakePreview(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		s.renderStocktake(w, r, website, map[string]interface{}{"Error": "Invalid form data"})
+		return
+	}
+
+	var source io.Reader = strings.NewReader(r.FormValue("counts"))
+	if file, _, err := r.FormFile("csv_file"); err == nil {
+		defer file.Close()
+		source = file
+	}
+
+	counts, err := parseStocktakeCounts(source)
+	if err != nil {
+		s.renderStocktake(w, r, website, map[string]interface{}{"Error": "Could not read counts: " + err.Error(), "Counts": r.FormValue("counts")})
+		return
+	}
+
+	lines, err := s.StocktakeVariance(websiteID, counts)
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error comparing stocktake with inventory", err)
+		return
+	}
+
+	s.renderStocktake(w, r, website, map[string]interface{}{
+		"Lines":  lines,
+		"Counts": formatStocktakeCounts(counts),
+	})
+}
+
+// handleStocktakeApply sets inventory to the counts from a previewed variance report
+func (s *AdminServer) handleStocktakeApply(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	counts, err := parseStocktakeCounts(strings.NewReader(r.FormValue("counts")))
+	if err != nil {
+		s.renderStocktake(w, r, website, map[string]interface{}{"Error": "Could not read counts: " + err.Error()})
+		return
+	}
+
+	lines, err := s.ApplyStocktake(websiteID, counts)
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error applying stocktake", err)
+		return
+	}
+
+	changed, unmatched, net := stocktakeSummary(lines)
+	s.LogActivity("stocktake", "product", 0, websiteID, map[string]int{"adjusted": changed, "unmatched": unmatched, "variance": net})
+
+	s.renderStocktake(w, r, website, map[string]interface{}{"Lines": lines, "Applied": true})
+}
+
+// handleProductExport streams products as CSV using the importer's columns, so an exported file can
+// be edited in a spreadsheet and imported again. ?variants=true adds one row per variant (product
+// columns repeated) and ?collection=<slug> limits the export to one collection
+func (s *AdminServer) handleProductExport(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	collectionSlug := r.URL.Query().Get("collection")
+	includeVariants := r.URL.Query().Get("variants") == "true"
+
+	filename := "products"
+	if collectionSlug != "" {
+		filename += "-" + collectionSlug
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-%s.csv", filename, time.Now().Format("2006-01-02")))
+
+	writer := csv.NewWriter(w)
+	header := []string{"name", "slug", "description", "price", "sku", "inventory", "status", "collections"}
+	if includeVariants {
+		header = append(header, "variant_title", "variant_sku", "variant_price_modifier", "variant_inventory")
+	}
+	writer.Write(header)
+
+	err := s.EachProductForExport(websiteID, collectionSlug, func(p Product, collections []string) error {
+		row := []string{
+			p.Name,
+			p.Slug,
+			p.Description,
+			strconv.FormatFloat(p.Price, 'f', 2, 64),
+			p.SKU,
+			strconv.Itoa(p.InventoryQuantity),
+			p.Status,
+			strings.Join(collections, "|"),
+		}
+
+		if !includeVariants {
+			return writer.Write(row)
+		}
+
+		variants, err := s.getProductVariants(websiteID, p.ID)
+		if err != nil || len(variants) == 0 {
+			return writer.Write(append(row, "", "", "", ""))
+		}
+		for _, v := range variants {
+			variantRow := append(append([]string{}, row...),
+				v.Title,
+				v.SKU,
+				strconv.FormatFloat(v.PriceModifier, 'f', 2, 64),
+				strconv.Itoa(v.InventoryQuantity),
+			)
+			if err := writer.Write(variantRow); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// Headers are already sent, so the best we can do is log and cut the file short
+		log.Printf("Error exporting products: %v", err)
+	}
+
+	writer.Flush()
+}
+
+func (s *AdminServer) handleProductImageReorder(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "Invalid product ID",
+		})
+		return
+	}
+
+	// Parse JSON body with array of image IDs in new order
+	var requestData struct {
+		ImageIDs []int `json:"imageIds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "Invalid request data",
+		})
+		return
+	}
+
+	// Update positions
+	if err := s.UpdateProductImagePositions(websiteID, requestData.ImageIDs); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("Error updating image positions: %v", err),
+		})
+		return
+	}
+
+	s.LogActivity("reorder", "product_images", productID, websiteID, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+func (s *AdminServer) handleProductReorder(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid product ID", nil)
+		return
+	}
+
+	direction := chi.URLParam(r, "direction")
+	if direction != "up" && direction != "down" {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid direction", nil)
+		return
+	}
+
+	if err := s.ReorderProduct(websiteID, productID, direction); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error reordering product", err)
+		return
+	}
+
+	s.LogActivity("reorder", "product", productID, websiteID, map[string]string{"direction": direction})
+
+	http.Redirect(w, r, s.adminURL("/site/%s/products", websiteID), http.StatusSeeOther)
+}
+
+// Variant handlers
+func (s *AdminServer) handleVariantNew(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid product ID", nil)
+		return
+	}
+
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	product, err := s.GetProduct(websiteID, productID)
+	if err != nil {
+		s.renderError(w, r, http.StatusNotFound, "Product not found", nil)
+		return
+	}
+
+	s.renderWithLayout(w, r, "variant_form_content.html", map[string]interface{}{
+		"Title":         "New Variant",
 		"Website":       website,
 		"Product":       product,
-		"Action":        fmt.Sprintf("/site/%s/products/%d/variants/create", websiteID, productID),
+		"Action":        s.adminURL("/site/%s/products/%d/variants/create", websiteID, productID),
 		"ActiveSection": "products",
 	})
 }
@@ -1904,27 +3208,34 @@ func (s *AdminServer) handleVariantCreate(w http.ResponseWriter, r *http.Request
 	websiteID := chi.URLParam(r, "id")
 	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
 	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid product ID", nil)
 		return
 	}
 
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
 	priceModifier, _ := strconv.ParseFloat(r.FormValue("priceModifier"), 64)
 	inventoryQuantity, _ := strconv.Atoi(r.FormValue("inventoryQuantity"))
 
+	// Blank or 0 inherits the product's limit
+	var maxPerOrder interface{}
+	if n, _ := strconv.Atoi(r.FormValue("maxPerOrder")); n > 0 {
+		maxPerOrder = n
+	}
+
 	err = s.CreateVariant(websiteID, productID, map[string]interface{}{
 		"title":             r.FormValue("title"),
 		"priceModifier":     priceModifier,
 		"sku":               r.FormValue("sku"),
 		"inventoryQuantity": inventoryQuantity,
+		"maxPerOrder":       maxPerOrder,
 	})
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating variant: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error creating variant", err)
 		return
 	}
 
@@ -1933,38 +3244,37 @@ func (s *AdminServer) handleVariantCreate(w http.ResponseWriter, r *http.Request
 		"title":      r.FormValue("title"),
 	})
 
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/products/%d/edit", websiteID, productID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/products/%d/edit", websiteID, productID), http.StatusSeeOther)
 }
 
 func (s *AdminServer) handleVariantEdit(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
 	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
 	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid product ID", nil)
 		return
 	}
 
 	variantID, err := strconv.Atoi(chi.URLParam(r, "variantId"))
 	if err != nil {
-		http.Error(w, "Invalid variant ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid variant ID", nil)
 		return
 	}
 
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
 	product, err := s.GetProduct(websiteID, productID)
 	if err != nil {
-		http.Error(w, "Product not found", http.StatusNotFound)
+		s.renderError(w, r, http.StatusNotFound, "Product not found", nil)
 		return
 	}
 
 	variant, err := s.GetVariant(websiteID, variantID)
 	if err != nil {
-		http.Error(w, "Variant not found", http.StatusNotFound)
+		s.renderError(w, r, http.StatusNotFound, "Variant not found", nil)
 		return
 	}
 
@@ -1973,7 +3283,7 @@ func (s *AdminServer) handleVariantEdit(w http.ResponseWriter, r *http.Request)
 		"Website":       website,
 		"Product":       product,
 		"Variant":       variant,
-		"Action":        fmt.Sprintf("/site/%s/products/%d/variants/%d/update", websiteID, productID, variantID),
+		"Action":        s.adminURL("/site/%s/products/%d/variants/%d/update", websiteID, productID, variantID),
 		"ActiveSection": "products",
 	})
 }
@@ -1982,33 +3292,40 @@ func (s *AdminServer) handleVariantUpdate(w http.ResponseWriter, r *http.Request
 	websiteID := chi.URLParam(r, "id")
 	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
 	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid product ID", nil)
 		return
 	}
 
 	variantID, err := strconv.Atoi(chi.URLParam(r, "variantId"))
 	if err != nil {
-		http.Error(w, "Invalid variant ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid variant ID", nil)
 		return
 	}
 
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
 	priceModifier, _ := strconv.ParseFloat(r.FormValue("priceModifier"), 64)
 	inventoryQuantity, _ := strconv.Atoi(r.FormValue("inventoryQuantity"))
 
+	// Blank or 0 inherits the product's limit
+	var maxPerOrder interface{}
+	if n, _ := strconv.Atoi(r.FormValue("maxPerOrder")); n > 0 {
+		maxPerOrder = n
+	}
+
 	err = s.UpdateVariant(websiteID, variantID, map[string]interface{}{
 		"title":             r.FormValue("title"),
 		"priceModifier":     priceModifier,
 		"sku":               r.FormValue("sku"),
 		"inventoryQuantity": inventoryQuantity,
+		"maxPerOrder":       maxPerOrder,
 	})
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error updating variant: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error updating variant", err)
 		return
 	}
 
@@ -2017,26 +3334,26 @@ func (s *AdminServer) handleVariantUpdate(w http.ResponseWriter, r *http.Request
 		"title":      r.FormValue("title"),
 	})
 
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/products/%d/edit", websiteID, productID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/products/%d/edit", websiteID, productID), http.StatusSeeOther)
 }
 
 func (s *AdminServer) handleVariantDelete(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
 	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
 	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid product ID", nil)
 		return
 	}
 
 	variantID, err := strconv.Atoi(chi.URLParam(r, "variantId"))
 	if err != nil {
-		http.Error(w, "Invalid variant ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid variant ID", nil)
 		return
 	}
 
 	err = s.DeleteVariant(websiteID, variantID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting variant: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error deleting variant", err)
 		return
 	}
 
@@ -2051,39 +3368,38 @@ func (s *AdminServer) handleVariantReorder(w http.ResponseWriter, r *http.Reques
 	websiteID := chi.URLParam(r, "id")
 	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
 	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid product ID", nil)
 		return
 	}
 
 	variantID, err := strconv.Atoi(chi.URLParam(r, "variantId"))
 	if err != nil {
-		http.Error(w, "Invalid variant ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid variant ID", nil)
 		return
 	}
 
 	direction := chi.URLParam(r, "direction")
 	if direction != "up" && direction != "down" {
-		http.Error(w, "Invalid direction", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid direction", nil)
 		return
 	}
 
 	if err := s.ReorderVariant(websiteID, variantID, direction); err != nil {
-		http.Error(w, fmt.Sprintf("Error reordering variant: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error reordering variant", err)
 		return
 	}
 
 	s.LogActivity("reorder", "variant", variantID, websiteID, map[string]string{"direction": direction, "product_id": fmt.Sprintf("%d", productID)})
 
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/products/%d/edit", websiteID, productID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/products/%d/edit", websiteID, productID), http.StatusSeeOther)
 }
 
 // Category/Collection handlers (simplified - just list and create/delete)
 func (s *AdminServer) handleCategoriesList(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
 
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
@@ -2105,24 +3421,27 @@ func (s *AdminServer) handleCategoryCreate(w http.ResponseWriter, r *http.Reques
 	websiteID := chi.URLParam(r, "id")
 
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
+	parentID, _ := strconv.Atoi(r.FormValue("parent_id"))
+
 	category := Category{
-		Name: r.FormValue("name"),
-		Slug: strings.ToLower(strings.ReplaceAll(r.FormValue("name"), " ", "-")),
+		Name:     r.FormValue("name"),
+		Slug:     strings.ToLower(strings.ReplaceAll(r.FormValue("name"), " ", "-")),
+		ParentID: parentID,
 	}
 
 	id, err := s.CreateCategory(websiteID, category)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating category: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error creating category", err)
 		return
 	}
 
 	s.LogActivity("create", "category", int(id), websiteID, category)
 
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/categories", websiteID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/categories", websiteID), http.StatusSeeOther)
 }
 
 func (s *AdminServer) handleCategoryDelete(w http.ResponseWriter, r *http.Request) {
@@ -2130,26 +3449,25 @@ func (s *AdminServer) handleCategoryDelete(w http.ResponseWriter, r *http.Reques
 
 	categoryID, err := strconv.Atoi(chi.URLParam(r, "categoryId"))
 	if err != nil {
-		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid category ID", nil)
 		return
 	}
 
 	if err := s.DeleteCategory(websiteID, categoryID); err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting category: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error deleting category", err)
 		return
 	}
 
 	s.LogActivity("delete", "category", categoryID, websiteID, nil)
 
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/categories", websiteID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/categories", websiteID), http.StatusSeeOther)
 }
 
 func (s *AdminServer) handleCollectionsList(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
 
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
@@ -2171,7 +3489,7 @@ func (s *AdminServer) handleCollectionCreate(w http.ResponseWriter, r *http.Requ
 	websiteID := chi.URLParam(r, "id")
 
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
@@ -2183,13 +3501,13 @@ func (s *AdminServer) handleCollectionCreate(w http.ResponseWriter, r *http.Requ
 
 	id, err := s.CreateCollection(websiteID, collection)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating collection: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error creating collection", err)
 		return
 	}
 
 	s.LogActivity("create", "collection", int(id), websiteID, collection)
 
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/collections", websiteID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/collections", websiteID), http.StatusSeeOther)
 }
 
 func (s *AdminServer) handleCollectionDelete(w http.ResponseWriter, r *http.Request) {
@@ -2197,189 +3515,431 @@ func (s *AdminServer) handleCollectionDelete(w http.ResponseWriter, r *http.Requ
 
 	collectionID, err := strconv.Atoi(chi.URLParam(r, "collectionId"))
 	if err != nil {
-		http.Error(w, "Invalid collection ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid collection ID", nil)
 		return
 	}
 
 	if err := s.DeleteCollection(websiteID, collectionID); err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting collection: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error deleting collection", err)
+		return
+	}
+
+	s.LogActivity("delete", "collection", collectionID, websiteID, nil)
+
+	http.Redirect(w, r, s.adminURL("/site/%s/collections", websiteID), http.StatusSeeOther)
+}
+
+func (s *AdminServer) handleCollectionEditForm(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	collectionID, err := strconv.Atoi(chi.URLParam(r, "collectionId"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid collection ID", nil)
+		return
+	}
+
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	collection, err := s.GetCollection(websiteID, collectionID)
+	if err != nil {
+		s.renderError(w, r, http.StatusNotFound, "Collection not found", nil)
+		return
+	}
+
+	// Load all images
+	images, err := s.GetImages(websiteID, 1000, 0)
+	if err != nil {
+		log.Printf("Error loading images: %v", err)
+		images = []Image{}
+	}
+
+	// Load collection's current image if it has one
+	var collectionImage *Image
+	if collection.ImageID > 0 {
+		for _, img := range images {
+			if img.ID == collection.ImageID {
+				collectionImage = &img
+				break
+			}
+		}
+	}
+
+	s.renderWithLayout(w, r, "collection_form_content.html", map[string]interface{}{
+		"Title":           "Edit Collection",
+		"ActiveSection":   "collections",
+		"Website":         website,
+		"Collection":      collection,
+		"Images":          images,
+		"CollectionImage": collectionImage,
+		"Action":          s.adminURL("/site/%s/collections/%d/edit", websiteID, collectionID),
+	})
+}
+
+func (s *AdminServer) handleCollectionUpdate(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	collectionID, err := strconv.Atoi(chi.URLParam(r, "collectionId"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid collection ID", nil)
+		return
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
-	s.LogActivity("delete", "collection", collectionID, websiteID, nil)
-
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/collections", websiteID), http.StatusSeeOther)
+	// Get existing collection
+	existingCollection, _ := s.GetCollection(websiteID, collectionID)
+
+	sortOrder, _ := strconv.Atoi(r.FormValue("sortOrder"))
+
+	collection := Collection{
+		ID:          collectionID,
+		Name:        r.FormValue("name"),
+		Slug:        r.FormValue("slug"),
+		Description: r.FormValue("description"),
+		SortOrder:   sortOrder,
+		Status:      r.FormValue("status"),
+		ImageID:     existingCollection.ImageID,
+	}
+
+	// Handle image upload or selection
+	if file, header, err := r.FormFile("new_image"); err == nil {
+		defer file.Close()
+
+		// Get website info
+		website, _ := s.getWebsiteFromURL(r)
+		if website.ID != "" {
+			// Save file to the upload backend
+			stored, err := s.storeUpload(r.Context(), website, file, header)
+			if err == nil {
+				image := Image{
+					URL:      stored.URL,
+					AltText:  r.FormValue("image_alt"),
+					Credit:   r.FormValue("image_credit"),
+					Filename: header.Filename,
+					Size:     stored.Size,
+				}
+
+				if imageID, err := s.CreateImage(websiteID, image); err == nil {
+					collection.ImageID = int(imageID)
+				}
+			} else {
+				log.Printf("Warning: Failed to store uploaded image %s: %v", header.Filename, err)
+			}
+		}
+	} else {
+		// User selected an existing image or cleared it
+		imageIDStr := r.FormValue("image_id")
+		if imageIDStr != "" {
+			if imageID, err := strconv.Atoi(imageIDStr); err == nil {
+				collection.ImageID = imageID
+			}
+		} else {
+			// No image selected, clear it
+			collection.ImageID = 0
+		}
+	}
+
+	if err := s.UpdateCollection(websiteID, collection); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error updating collection", err)
+		return
+	}
+
+	s.LogActivity("update", "collection", collectionID, websiteID, collection)
+
+	http.Redirect(w, r, s.adminURL("/site/%s/collections", websiteID), http.StatusSeeOther)
+}
+
+// handleCollectionPricesForm shows the bulk price adjustment form for a collection
+func (s *AdminServer) handleCollectionPricesForm(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	collectionID, err := strconv.Atoi(chi.URLParam(r, "collectionId"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid collection ID", nil)
+		return
+	}
+
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	collection, err := s.GetCollection(websiteID, collectionID)
+	if err != nil {
+		s.renderError(w, r, http.StatusNotFound, "Collection not found", nil)
+		return
+	}
+
+	s.renderWithLayout(w, r, "collection_prices_content.html", map[string]interface{}{
+		"Title":         "Adjust Prices - " + collection.Name,
+		"ActiveSection": "collections",
+		"Website":       website,
+		"Collection":    collection,
+		"Mode":          "percent",
+		"SetCompareAt":  true,
+	})
+}
+
+// handleCollectionPrices previews a bulk price adjustment and, once confirmed, applies it. The
+// preview is a dry run of the same code path, so what's shown is exactly what will be written
+func (s *AdminServer) handleCollectionPrices(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	collectionID, err := strconv.Atoi(chi.URLParam(r, "collectionId"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid collection ID", nil)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
+		return
+	}
+
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	collection, err := s.GetCollection(websiteID, collectionID)
+	if err != nil {
+		s.renderError(w, r, http.StatusNotFound, "Collection not found", nil)
+		return
+	}
+
+	mode := r.FormValue("mode")
+	setCompareAt := r.FormValue("set_compare_at") == "on"
+	apply := r.FormValue("confirm") == "apply"
+
+	data := map[string]interface{}{
+		"Title":         "Adjust Prices - " + collection.Name,
+		"ActiveSection": "collections",
+		"Website":       website,
+		"Collection":    collection,
+		"Mode":          mode,
+		"Value":         r.FormValue("value"),
+		"SetCompareAt":  setCompareAt,
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(r.FormValue("value")), 64)
+	if err != nil || value == 0 {
+		data["Error"] = "Enter a non-zero number, negative to lower prices"
+		s.renderWithLayout(w, r, "collection_prices_content.html", data)
+		return
+	}
+
+	var changes []BulkPriceChange
+	username := s.getSessionUsername(r)
+	switch mode {
+	case "percent":
+		if value <= -100 {
+			data["Error"] = "A percentage decrease must be less than 100%"
+			s.renderWithLayout(w, r, "collection_prices_content.html", data)
+			return
+		}
+		changes, err = s.BulkAdjustPrices(websiteID, collectionID, value, setCompareAt, username, !apply)
+	case "amount":
+		changes, err = s.BulkAdjustPricesByAmount(websiteID, collectionID, value, setCompareAt, username, !apply)
+	default:
+		s.renderError(w, r, http.StatusBadRequest, "Invalid adjustment mode", nil)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error adjusting prices", err)
+		return
+	}
+
+	if apply {
+		s.LogActivity("update", "collection", collectionID, websiteID, map[string]interface{}{
+			"mode":         mode,
+			"value":        value,
+			"setCompareAt": setCompareAt,
+		})
+		http.Redirect(w, r, s.adminURL("/site/%s/collections", websiteID), http.StatusSeeOther)
+		return
+	}
+
+	changed := 0
+	for _, c := range changes {
+		if c.Skipped == "" {
+			changed++
+		}
+	}
+
+	data["Preview"] = true
+	data["Changes"] = changes
+	data["Changed"] = changed
+	s.renderWithLayout(w, r, "collection_prices_content.html", data)
+}
+
+func (s *AdminServer) handleSalesList(w http.ResponseWriter, r *http.Request) {
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	s.renderSalesList(w, r, website, "")
+}
+
+func (s *AdminServer) renderSalesList(w http.ResponseWriter, r *http.Request, website Website, formError string) {
+	sales, err := s.GetScheduledSales(website.ID)
+	if err != nil {
+		log.Printf("Error loading scheduled sales: %v", err)
+		sales = []ScheduledSale{}
+	}
+
+	collections, err := s.GetCollections(website.ID)
+	if err != nil {
+		log.Printf("Error loading collections: %v", err)
+		collections = []Collection{}
+	}
+
+	loc, err := time.LoadLocation(website.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	for i := range sales {
+		sales[i].StartsAt = sales[i].StartsAt.In(loc)
+		sales[i].EndsAt = sales[i].EndsAt.In(loc)
+	}
+
+	s.renderWithLayout(w, r, "sales_list_content.html", map[string]interface{}{
+		"Title":         website.SiteName + " - Sales",
+		"ActiveSection": "sales",
+		"Website":       website,
+		"Sales":         sales,
+		"Collections":   collections,
+		"Timezone":      loc.String(),
+		"Error":         formError,
+	})
 }
 
-func (s *AdminServer) handleCollectionEditForm(w http.ResponseWriter, r *http.Request) {
+// handleSaleCreate schedules a sale. Start and end are entered in the website's timezone
+func (s *AdminServer) handleSaleCreate(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
-	collectionID, err := strconv.Atoi(chi.URLParam(r, "collectionId"))
-	if err != nil {
-		http.Error(w, "Invalid collection ID", http.StatusBadRequest)
-		return
-	}
 
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
-	collection, err := s.GetCollection(websiteID, collectionID)
-	if err != nil {
-		http.Error(w, "Collection not found", http.StatusNotFound)
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
-	// Load all images
-	images, err := s.GetImages(websiteID, 1000, 0)
+	loc, err := time.LoadLocation(website.Timezone)
 	if err != nil {
-		log.Printf("Error loading images: %v", err)
-		images = []Image{}
+		log.Printf("Error loading timezone %s: %v, defaulting to UTC", website.Timezone, err)
+		loc = time.UTC
 	}
 
-	// Load collection's current image if it has one
-	var collectionImage *Image
-	if collection.ImageID > 0 {
-		for _, img := range images {
-			if img.ID == collection.ImageID {
-				collectionImage = &img
-				break
-			}
-		}
+	collectionID, _ := strconv.Atoi(r.FormValue("collection_id"))
+	percentOff, err := strconv.ParseFloat(strings.TrimSpace(r.FormValue("percent_off")), 64)
+	if err != nil || percentOff <= 0 || percentOff >= 100 {
+		s.renderSalesList(w, r, website, "Percent off must be between 0 and 100")
+		return
 	}
 
-	s.renderWithLayout(w, r, "collection_form_content.html", map[string]interface{}{
-		"Title":           "Edit Collection",
-		"ActiveSection":   "collections",
-		"Website":         website,
-		"Collection":      collection,
-		"Images":          images,
-		"CollectionImage": collectionImage,
-		"Action":          fmt.Sprintf("/site/%s/collections/%d/edit", websiteID, collectionID),
-	})
-}
-
-func (s *AdminServer) handleCollectionUpdate(w http.ResponseWriter, r *http.Request) {
-	websiteID := chi.URLParam(r, "id")
-	collectionID, err := strconv.Atoi(chi.URLParam(r, "collectionId"))
+	startsAt, err := time.ParseInLocation("2006-01-02T15:04", r.FormValue("starts_at"), loc)
 	if err != nil {
-		http.Error(w, "Invalid collection ID", http.StatusBadRequest)
+		s.renderSalesList(w, r, website, "Invalid start date")
 		return
 	}
-
-	if err := r.ParseMultipartForm(32 << 20); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+	endsAt, err := time.ParseInLocation("2006-01-02T15:04", r.FormValue("ends_at"), loc)
+	if err != nil || !endsAt.After(startsAt) {
+		s.renderSalesList(w, r, website, "The end date must be after the start date")
+		return
+	}
+	if !endsAt.After(time.Now()) {
+		s.renderSalesList(w, r, website, "The end date is already in the past")
 		return
 	}
 
-	// Get existing collection
-	existingCollection, _ := s.GetCollection(websiteID, collectionID)
-
-	sortOrder, _ := strconv.Atoi(r.FormValue("sortOrder"))
+	if _, err := s.GetCollection(websiteID, collectionID); err != nil {
+		s.renderSalesList(w, r, website, "Choose a collection")
+		return
+	}
 
-	collection := Collection{
-		ID:          collectionID,
-		Name:        r.FormValue("name"),
-		Slug:        r.FormValue("slug"),
-		Description: r.FormValue("description"),
-		SortOrder:   sortOrder,
-		Status:      r.FormValue("status"),
-		ImageID:     existingCollection.ImageID,
+	sale := ScheduledSale{
+		CollectionID: collectionID,
+		Name:         strings.TrimSpace(r.FormValue("name")),
+		PercentOff:   percentOff,
+		StartsAt:     startsAt,
+		EndsAt:       endsAt,
+		CreatedBy:    s.getSessionUsername(r),
 	}
 
-	// Handle image upload or selection
-	if file, header, err := r.FormFile("new_image"); err == nil {
-		defer file.Close()
+	id, err := s.CreateScheduledSale(websiteID, sale)
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error scheduling sale", err)
+		return
+	}
 
-		// Get website info
-		website, _ := s.GetWebsite(websiteID)
-		if website.ID != "" {
-			// Create uploads directory
-			uploadsDir := filepath.Join("websites", website.Directory, "public", "uploads")
-			os.MkdirAll(uploadsDir, 0755)
+	s.LogActivity("create", "sale", int(id), websiteID, sale)
 
-			// Generate unique filename
-			ext := filepath.Ext(header.Filename)
-			filename := fmt.Sprintf("%d_%s%s", time.Now().Unix(), strings.ReplaceAll(header.Filename[:len(header.Filename)-len(ext)], " ", "_"), ext)
-			filePath := filepath.Join(uploadsDir, filename)
+	// Apply right away if the sale has already started instead of waiting for the next scheduler tick
+	if !startsAt.After(time.Now()) {
+		if err := s.StartScheduledSale(websiteID, int(id)); err != nil {
+			log.Printf("Error starting sale %d: %v", id, err)
+		}
+	}
 
-			// Save file
-			dst, err := os.Create(filePath)
-			if err == nil {
-				defer dst.Close()
-				dst.ReadFrom(file)
-				fileInfo, _ := dst.Stat()
+	http.Redirect(w, r, s.adminURL("/site/%s/sales", websiteID), http.StatusSeeOther)
+}
 
-				// Create image record
-				imageURL := fmt.Sprintf("//%s/public/uploads/%s", website.HTTPAddress, filename)
-				image := Image{
-					URL:      imageURL,
-					AltText:  r.FormValue("image_alt"),
-					Credit:   r.FormValue("image_credit"),
-					Filename: header.Filename,
-					Size:     fileInfo.Size(),
-				}
+// handleSaleCancel cancels a scheduled sale, restoring prices first if it is already running
+func (s *AdminServer) handleSaleCancel(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
 
-				if imageID, err := s.CreateImage(websiteID, image); err == nil {
-					collection.ImageID = int(imageID)
-				}
-			}
-		}
-	} else {
-		// User selected an existing image or cleared it
-		imageIDStr := r.FormValue("image_id")
-		if imageIDStr != "" {
-			if imageID, err := strconv.Atoi(imageIDStr); err == nil {
-				collection.ImageID = imageID
-			}
-		} else {
-			// No image selected, clear it
-			collection.ImageID = 0
-		}
+	saleID, err := strconv.Atoi(chi.URLParam(r, "saleId"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid sale ID", nil)
+		return
 	}
 
-	if err := s.UpdateCollection(websiteID, collection); err != nil {
-		http.Error(w, fmt.Sprintf("Error updating collection: %v", err), http.StatusInternalServerError)
+	if err := s.EndScheduledSale(websiteID, saleID, "cancelled"); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error cancelling sale", err)
 		return
 	}
 
-	s.LogActivity("update", "collection", collectionID, websiteID, collection)
+	s.LogActivity("cancel", "sale", saleID, websiteID, nil)
 
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/collections", websiteID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/sales", websiteID), http.StatusSeeOther)
 }
 
 func (s *AdminServer) handleCollectionReorder(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
 	collectionID, err := strconv.Atoi(chi.URLParam(r, "collectionId"))
 	if err != nil {
-		http.Error(w, "Invalid collection ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid collection ID", nil)
 		return
 	}
 
 	direction := chi.URLParam(r, "direction")
 	if direction != "up" && direction != "down" {
-		http.Error(w, "Invalid direction", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid direction", nil)
 		return
 	}
 
 	if err := s.ReorderCollection(websiteID, collectionID, direction); err != nil {
-		http.Error(w, fmt.Sprintf("Error reordering collection: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error reordering collection", err)
 		return
 	}
 
 	s.LogActivity("reorder", "collection", collectionID, websiteID, map[string]string{"direction": direction})
 
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/collections", websiteID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/collections", websiteID), http.StatusSeeOther)
 }
 
 // Image handlers (basic list and upload/delete)
 func (s *AdminServer) handleImagesList(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
 
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
@@ -2397,78 +3957,89 @@ func (s *AdminServer) handleImagesList(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// storeUpload saves an uploaded file for a website to the configured upload backend under a
+// unique name
+func (s *AdminServer) storeUpload(ctx context.Context, website Website, file io.Reader, header *multipart.FileHeader) (media.StoredFile, error) {
+	return s.uploads.Save(ctx, uploadSite(website), uploadFilename(header.Filename), file, header.Size, header.Header.Get("Content-Type"))
+}
+
+// storeProductImage saves a product image upload. Images of unpublished products are kept private,
+// reachable only through signed links, until PublishProductImages moves them to the upload backend
+func (s *AdminServer) storeProductImage(ctx context.Context, website Website, file io.Reader, header *multipart.FileHeader, published bool) (media.StoredFile, error) {
+	if published {
+		return s.storeUpload(ctx, website, file, header)
+	}
+	return media.SavePrivateUpload(uploadSite(website), uploadFilename(header.Filename), file)
+}
+
+// uploadFilename makes a unique filename for an upload from its original name
+func uploadFilename(original string) string {
+	ext := filepath.Ext(original)
+	return fmt.Sprintf("%d_%s%s", time.Now().UnixNano(), strings.ReplaceAll(strings.TrimSuffix(original, ext), " ", "_"), ext)
+}
+
+// signedMediaTTL is how long signed links to private uploads shown in the admin keep working
+const signedMediaTTL = time.Hour
+
+// signMediaURL returns a signed link to a private upload so the admin can show it. Other URLs are
+// returned as they are
+func (s *AdminServer) signMediaURL(fileURL string) string {
+	return media.SignMediaURL([]byte(s.EnvConfig.Uploads.SigningKey), fileURL, time.Now().Add(signedMediaTTL))
+}
+
+// uploadSite returns what the upload backend needs to know about a website
+func uploadSite(website Website) media.UploadSite {
+	return media.UploadSite{Directory: website.Directory, HTTPAddress: website.HTTPAddress}
+}
+
 func (s *AdminServer) handleImageUpload(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
 
 	// Parse multipart form for file uploads (32 MB max)
 	if err := r.ParseMultipartForm(32 << 20); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
 	// Get the uploaded file
 	file, header, err := r.FormFile("image")
 	if err != nil {
-		http.Error(w, "No image file uploaded", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "No image file uploaded", nil)
 		return
 	}
 	defer file.Close()
 
 	// Get website info to find the directory
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
-		return
-	}
-
-	// Create uploads directory if it doesn't exist
-	uploadsDir := filepath.Join("websites", website.Directory, "public", "uploads")
-	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
-		http.Error(w, fmt.Sprintf("Error creating uploads directory: %v", err), http.StatusInternalServerError)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
-	// Generate unique filename with timestamp
-	ext := filepath.Ext(header.Filename)
-	filename := fmt.Sprintf("%d_%s%s", time.Now().Unix(), strings.ReplaceAll(header.Filename[:len(header.Filename)-len(ext)], " ", "_"), ext)
-	filePath := filepath.Join(uploadsDir, filename)
-
-	// Create the file on disk
-	dst, err := os.Create(filePath)
+	// Save the file to the upload backend
+	stored, err := s.storeUpload(r.Context(), website, file, header)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating file: %v", err), http.StatusInternalServerError)
-		return
-	}
-	defer dst.Close()
-
-	// Copy uploaded file to destination
-	if _, err := dst.ReadFrom(file); err != nil {
-		http.Error(w, fmt.Sprintf("Error saving file: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "", err)
 		return
 	}
 
-	// Get file size
-	fileInfo, _ := dst.Stat()
-
-	// Create image record in database with protocol-relative URL
-	imageURL := fmt.Sprintf("//%s/public/uploads/%s", website.HTTPAddress, filename)
+	// Create image record in database
 	image := Image{
-		URL:      imageURL,
+		URL:      stored.URL,
 		AltText:  r.FormValue("alt"),
 		Credit:   r.FormValue("credit"),
 		Filename: header.Filename,
-		Size:     fileInfo.Size(),
+		Size:     stored.Size,
 	}
 
 	id, err := s.CreateImage(websiteID, image)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating image: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error creating image", err)
 		return
 	}
 
 	s.LogActivity("create", "image", int(id), websiteID, image)
 
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/images", websiteID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/images", websiteID), http.StatusSeeOther)
 }
 
 func (s *AdminServer) handleImageDelete(w http.ResponseWriter, r *http.Request) {
@@ -2476,26 +4047,25 @@ func (s *AdminServer) handleImageDelete(w http.ResponseWriter, r *http.Request)
 
 	imageID, err := strconv.Atoi(chi.URLParam(r, "imageId"))
 	if err != nil {
-		http.Error(w, "Invalid image ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid image ID", nil)
 		return
 	}
 
 	if err := s.DeleteImage(websiteID, imageID); err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting image: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error deleting image", err)
 		return
 	}
 
 	s.LogActivity("delete", "image", imageID, websiteID, nil)
 
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/images", websiteID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/images", websiteID), http.StatusSeeOther)
 }
 
 // handleOrdersList displays list of orders for a website
 func (s *AdminServer) handleOrdersList(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
@@ -2513,18 +4083,14 @@ func (s *AdminServer) handleOrdersList(w http.ResponseWriter, r *http.Request) {
 
 	orders, err := s.GetOrdersFiltered(websiteID, filters)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching orders: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error fetching orders", err)
 		return
 	}
 
-	allSites, _ := s.GetAllWebsites()
-
 	data := map[string]interface{}{
 		"Title":         "Orders",
 		"Website":       website,
 		"Orders":        orders,
-		"AllSites":      allSites,
-		"CurrentSite":   website,
 		"ActiveSection": "orders",
 		"Filters":       filters,
 	}
@@ -2532,36 +4098,66 @@ func (s *AdminServer) handleOrdersList(w http.ResponseWriter, r *http.Request) {
 	s.renderWithLayout(w, r, "orders_list_content.html", data)
 }
 
+// handleOrdersNeedingAttention lists problem orders, most urgent first
+func (s *AdminServer) handleOrdersNeedingAttention(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	orders, err := s.GetOrdersNeedingAttention(websiteID)
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error loading orders", err)
+		return
+	}
+
+	staleDays := website.StaleOrderDays
+	if staleDays <= 0 {
+		staleDays = defaultStaleOrderDays
+	}
+
+	s.renderWithLayout(w, r, "orders_attention_content.html", map[string]interface{}{
+		"Title":         "Orders Needing Attention",
+		"ActiveSection": "orders",
+		"Website":       website,
+		"Orders":        orders,
+		"StaleDays":     staleDays,
+	})
+}
+
 // handleOrderDetail displays order detail
 func (s *AdminServer) handleOrderDetail(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
 	orderIDStr := chi.URLParam(r, "orderId")
 	orderID, err := strconv.Atoi(orderIDStr)
 	if err != nil {
-		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid order ID", nil)
 		return
 	}
 
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
 	order, err := s.GetOrder(websiteID, orderID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching order: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error fetching order", err)
 		return
 	}
 
-	allSites, _ := s.GetAllWebsites()
+	returns, err := s.GetOrderReturnsForOrder(websiteID, orderID)
+	if err != nil {
+		log.Printf("Error loading returns for order %d: %v", orderID, err)
+	}
 
 	data := map[string]interface{}{
-		"Title":       "Order Detail",
-		"Website":     website,
-		"Order":       order,
-		"AllSites":    allSites,
-		"CurrentSite": website,
+		"Title":         "Order Detail",
+		"Website":       website,
+		"Order":         order,
+		"Returns":       returns,
 		"ActiveSection": "orders",
 	}
 
@@ -2574,19 +4170,18 @@ func (s *AdminServer) handlePackingSlip(w http.ResponseWriter, r *http.Request)
 	orderIDStr := chi.URLParam(r, "orderId")
 	orderID, err := strconv.Atoi(orderIDStr)
 	if err != nil {
-		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid order ID", nil)
 		return
 	}
 
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
 	order, err := s.GetOrder(websiteID, orderID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching order: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error fetching order", err)
 		return
 	}
 
@@ -2596,15 +4191,15 @@ func (s *AdminServer) handlePackingSlip(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Render packing slip template without layout (for printing)
-	tmpl, err := template.ParseFiles("admin/templates/packing_slip.html")
+	tmpl, err := s.templates.get("packing_slip.html")
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error loading template: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error loading template", err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "text/html")
 	if err := tmpl.Execute(w, data); err != nil {
-		http.Error(w, fmt.Sprintf("Error rendering template: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error rendering template", err)
 	}
 }
 
@@ -2614,37 +4209,51 @@ func (s *AdminServer) handleOrderFulfillmentUpdate(w http.ResponseWriter, r *htt
 	orderIDStr := chi.URLParam(r, "orderId")
 	orderID, err := strconv.Atoi(orderIDStr)
 	if err != nil {
-		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid order ID", nil)
 		return
 	}
 
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.renderError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		return
 	}
 
 	// Get the order to check payment status
 	order, err := s.GetOrder(websiteID, orderID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching order: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error fetching order", err)
 		return
 	}
 
-	// Only allow fulfillment updates if payment is completed
-	if order.PaymentStatus != "paid" {
-		http.Error(w, "Cannot update fulfillment status: payment has not been completed", http.StatusBadRequest)
+	// Only allow fulfillment updates if payment is completed or authorized for capture on ship
+	if order.PaymentStatus != "paid" && order.PaymentStatus != "authorized" {
+		s.renderError(w, r, http.StatusBadRequest, "Cannot update fulfillment status: payment has not been completed", nil)
 		return
 	}
 
 	fulfillmentStatus := r.FormValue("fulfillment_status")
 	if fulfillmentStatus == "" {
-		http.Error(w, "Fulfillment status is required", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Fulfillment status is required", nil)
 		return
 	}
 
+	// Preorders wait for their release date
+	if shipsOrder(fulfillmentStatus) && order.AwaitingRelease() {
+		s.renderError(w, r, http.StatusBadRequest, fmt.Sprintf("Cannot ship order: it has preorder items releasing %s", order.ShipsOn.Format("January 2, 2006")), nil)
+		return
+	}
+
+	// Authorized payments are captured when the order ships, whichever of those statuses it moves to
+	if shipsOrder(fulfillmentStatus) {
+		if err := s.CaptureOrderPayment(websiteID, orderID); err != nil {
+			s.renderError(w, r, http.StatusBadRequest, "Cannot ship order", err)
+			return
+		}
+	}
+
 	err = s.UpdateOrderFulfillmentStatus(websiteID, orderID, fulfillmentStatus)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error updating fulfillment status: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error updating fulfillment status", err)
 		return
 	}
 
@@ -2653,7 +4262,7 @@ func (s *AdminServer) handleOrderFulfillmentUpdate(w http.ResponseWriter, r *htt
 		emailService, err := email.NewEmailService()
 		if err == nil {
 			// Get website to build config
-			website, err := s.GetWebsite(websiteID)
+			website, err := s.getWebsiteFromURL(r)
 			if err == nil {
 				websiteConfig := &configs.WebsiteConfig{
 					SiteName: website.SiteName,
@@ -2683,7 +4292,7 @@ func (s *AdminServer) handleOrderFulfillmentUpdate(w http.ResponseWriter, r *htt
 	}
 
 	// Redirect back to order detail page
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/orders/%d", websiteID, orderID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/orders/%d", websiteID, orderID), http.StatusSeeOther)
 }
 
 // handleShippingRates gets shipping rates for an order
@@ -2817,8 +4426,8 @@ func (s *AdminServer) handleShippingLabelPurchase(w http.ResponseWriter, r *http
 		return
 	}
 
-	// Only allow label purchase if payment is completed
-	if order.PaymentStatus != "paid" {
+	// Only allow label purchase if payment is completed or authorized for capture on ship
+	if order.PaymentStatus != "paid" && order.PaymentStatus != "authorized" {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success": false,
 			"error":   "Cannot purchase label: payment has not been completed",
@@ -2826,6 +4435,23 @@ func (s *AdminServer) handleShippingLabelPurchase(w http.ResponseWriter, r *http
 		return
 	}
 
+	if order.AwaitingRelease() {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("Cannot purchase label: the order has preorder items releasing %s", order.ShipsOn.Format("January 2, 2006")),
+		})
+		return
+	}
+
+	// Capture authorized payments before buying the label so we never ship unpaid orders
+	if err := s.CaptureOrderPayment(websiteID, orderID); err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("Cannot purchase label: %v", err),
+		})
+		return
+	}
+
 	// Purchase label
 	labelInfo, err := s.PurchaseShippingLabel(websiteID, orderID, rateID)
 	if err != nil {
@@ -2846,7 +4472,7 @@ func (s *AdminServer) handleShippingLabelPurchase(w http.ResponseWriter, r *http
 	// Send shipping confirmation email to customer
 	emailService, err := email.NewEmailService()
 	if err == nil {
-		website, err := s.GetWebsite(websiteID)
+		website, err := s.getWebsiteFromURL(r)
 		if err == nil {
 			websiteConfig := &configs.WebsiteConfig{
 				SiteName: website.SiteName,
@@ -2876,109 +4502,306 @@ func (s *AdminServer) handleShippingLabelPurchase(w http.ResponseWriter, r *http
 		log.Printf("Warning: Failed to create email service: %v", err)
 	}
 
-	// Return success with label info
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success":        true,
-		"trackingNumber": labelInfo.TrackingNumber,
-		"labelUrl":       labelInfo.LabelURL,
-		"carrier":        labelInfo.Carrier,
-		"cost":           labelInfo.Cost,
-	})
+	// Return success with label info
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":        true,
+		"trackingNumber": labelInfo.TrackingNumber,
+		"labelUrl":       labelInfo.LabelURL,
+		"carrier":        labelInfo.Carrier,
+		"cost":           labelInfo.Cost,
+	})
+}
+
+// handleCustomersList displays the list of customers with statistics
+func (s *AdminServer) handleCustomersList(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+
+	// Get website
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse filters from query params
+	filters := CustomerFilters{
+		Sort:    r.URL.Query().Get("sort"),
+		Segment: r.URL.Query().Get("segment"),
+	}
+	if filters.Sort == "" {
+		filters.Sort = "total_desc" // Default sort
+	}
+
+	// Get customers
+	customers, err := s.GetCustomers(websiteID, filters)
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error fetching customers", err)
+		return
+	}
+
+	// Attach RFM segments, counting each for the filter and keeping only the chosen one
+	rfm, err := s.GetCustomerRFM(websiteID)
+	if err != nil {
+		log.Printf("Error scoring customers: %v", err)
+	}
+	segmentCounts := map[string]int{}
+	filtered := customers[:0]
+	for _, c := range customers {
+		c.RFM = rfm[c.ID]
+		segment := ""
+		if c.RFM != nil {
+			segment = c.RFM.Segment.Key
+			segmentCounts[segment]++
+		}
+		if filters.Segment == "" || filters.Segment == segment {
+			filtered = append(filtered, c)
+		}
+	}
+	customers = filtered
+
+	data := map[string]interface{}{
+		"Title":         "Customers",
+		"Website":       website,
+		"Customers":     customers,
+		"ActiveSection": "customers",
+		"Filters":       filters,
+		"Segments":      customerSegments,
+		"SegmentCounts": segmentCounts,
+	}
+
+	s.renderWithLayout(w, r, "customers_list_content.html", data)
+}
+
+// handleCustomerDetail displays a single customer with order history
+func (s *AdminServer) handleCustomerDetail(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	customerIDStr := chi.URLParam(r, "customerId")
+
+	customerID, err := strconv.Atoi(customerIDStr)
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid customer ID", nil)
+		return
+	}
+
+	// Get website
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	// Get customer
+	customer, err := s.GetCustomer(websiteID, customerID)
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error fetching customer", err)
+		return
+	}
+
+	// Get customer orders
+	orders, err := s.GetCustomerOrders(websiteID, customerID)
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error fetching customer orders", err)
+		return
+	}
+
+	// Calculate average order value
+	avgOrderValue := 0.0
+	if customer.OrderCount > 0 {
+		avgOrderValue = customer.TotalSpent / float64(customer.OrderCount)
+	}
+
+	creditTransactions, err := s.GetStoreCreditTransactions(websiteID, customerID)
+	if err != nil {
+		log.Printf("Error loading store credit transactions: %v", err)
+		creditTransactions = []StoreCreditTransaction{}
+	}
+
+	data := map[string]interface{}{
+		"Title":         "Customer Details",
+		"Website":       website,
+		"Customer":      customer,
+		"Orders":        orders,
+		"AvgOrderValue": avgOrderValue,
+		"CreditHistory": creditTransactions,
+		"ActiveSection": "customers",
+		"Error":         r.URL.Query().Get("error"),
+		"Merged":        r.URL.Query().Get("merged"),
+	}
+
+	s.renderWithLayout(w, r, "customer_detail_content.html", data)
 }
 
-// handleCustomersList displays the list of customers with statistics
-func (s *AdminServer) handleCustomersList(w http.ResponseWriter, r *http.Request) {
+// handleCustomerStoreCredit issues store credit to a customer outside of a refund
+func (s *AdminServer) handleCustomerStoreCredit(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
-
-	// Get website
-	website, err := s.GetWebsite(websiteID)
+	customerID, err := strconv.Atoi(chi.URLParam(r, "customerId"))
 	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid customer ID", nil)
 		return
 	}
 
-	// Parse filters from query params
-	filters := CustomerFilters{
-		Sort: r.URL.Query().Get("sort"),
-	}
-	if filters.Sort == "" {
-		filters.Sort = "total_desc" // Default sort
+	detailURL := s.adminURL("/site/%s/customers/%d", websiteID, customerID)
+
+	amount, err := strconv.ParseFloat(r.FormValue("amount"), 64)
+	if err != nil || amount <= 0 {
+		http.Redirect(w, r, detailURL+"?error="+url.QueryEscape("Enter an amount of store credit to issue"), http.StatusSeeOther)
+		return
 	}
 
-	// Get customers
-	customers, err := s.GetCustomers(websiteID, filters)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching customers: %v", err), http.StatusInternalServerError)
+	if err := s.IssueStoreCredit(websiteID, customerID, amount, s.getSessionUsername(r)); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error issuing store credit", err)
 		return
 	}
 
-	allSites, _ := s.GetAllWebsites()
+	s.LogActivity("update", "customer", customerID, websiteID, map[string]interface{}{
+		"store_credit_issued": amount,
+	})
 
-	data := map[string]interface{}{
-		"Title":         "Customers",
-		"Website":       website,
-		"Customers":     customers,
-		"AllSites":      allSites,
-		"CurrentSite":   website,
+	http.Redirect(w, r, detailURL, http.StatusSeeOther)
+}
+
+// handleCustomerPrivacy shows the data export and erasure forms, prefilled with ?email=
+func (s *AdminServer) handleCustomerPrivacy(w http.ResponseWriter, r *http.Request) {
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	s.renderWithLayout(w, r, "customer_privacy_content.html", map[string]interface{}{
+		"Title":         website.SiteName + " - Customer Privacy",
 		"ActiveSection": "customers",
-		"Filters":       filters,
+		"Website":       website,
+		"Email":         r.URL.Query().Get("email"),
+		"Error":         r.URL.Query().Get("error"),
+		"Erased":        r.URL.Query().Get("erased"),
+	})
+}
+
+// handleCustomerDataExport downloads everything stored about an email as JSON
+func (s *AdminServer) handleCustomerDataExport(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	email := strings.TrimSpace(r.URL.Query().Get("email"))
+
+	export, err := s.ExportCustomerData(websiteID, email)
+	if errors.Is(err, errCustomerEmailRequired) {
+		s.renderError(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	} else if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to export customer data", err)
+		return
 	}
 
-	s.renderWithLayout(w, r, "customers_list_content.html", data)
+	s.LogActivity("export", "customer_data", 0, websiteID, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=customer-data-%s.json", time.Now().Format("2006-01-02")))
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(export)
 }
 
-// handleCustomerDetail displays a single customer with order history
-func (s *AdminServer) handleCustomerDetail(w http.ResponseWriter, r *http.Request) {
+// handleCustomerDataErase erases an email's personal data once the email has been typed twice
+func (s *AdminServer) handleCustomerDataErase(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
-	customerIDStr := chi.URLParam(r, "customerId")
+	email := strings.TrimSpace(r.FormValue("email"))
 
-	customerID, err := strconv.Atoi(customerIDStr)
-	if err != nil {
-		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+	privacyURL := func(key, value string) string {
+		return s.adminURL("/site/%s/customers/privacy", websiteID) + "?" + url.Values{key: {value}}.Encode()
+	}
+
+	if email == "" || !strings.EqualFold(email, strings.TrimSpace(r.FormValue("confirmEmail"))) {
+		http.Redirect(w, r, privacyURL("error", "Type the email address again to confirm the erasure"), http.StatusSeeOther)
 		return
 	}
 
-	// Get website
-	website, err := s.GetWebsite(websiteID)
+	erasure, err := s.EraseCustomerData(websiteID, email)
 	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to erase customer data", err)
 		return
 	}
 
-	// Get customer
-	customer, err := s.GetCustomer(websiteID, customerID)
+	// The email itself is what was erased, so only what was affected is logged
+	s.LogActivity("erase", "customer_data", 0, websiteID, erasure)
+
+	summary := fmt.Sprintf("%d orders and %d subscriptions anonymized, %d reviews and %d questions made anonymous, %d messages and %d SMS signups deleted",
+		erasure.Orders, erasure.Subscriptions, erasure.ProductReviews, erasure.ProductQuestions, erasure.Messages, erasure.SMSSignups)
+	if erasure.CustomerDeleted {
+		summary = "Customer profile deleted, " + summary
+	}
+	http.Redirect(w, r, privacyURL("erased", summary), http.StatusSeeOther)
+}
+
+// handleCustomerMerge merges the customer with the email given in the form into the customer being
+// viewed, then returns to the merged customer
+func (s *AdminServer) handleCustomerMerge(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+
+	primaryID, err := strconv.Atoi(chi.URLParam(r, "customerId"))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching customer: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid customer ID", nil)
 		return
 	}
 
-	// Get customer orders
-	orders, err := s.GetCustomerOrders(websiteID, customerID)
+	detailURL := s.adminURL("/site/%s/customers/%d", websiteID, primaryID)
+	redirectWith := func(key, value string) {
+		http.Redirect(w, r, detailURL+"?"+url.Values{key: {value}}.Encode(), http.StatusSeeOther)
+	}
+
+	duplicateEmail := strings.TrimSpace(r.FormValue("duplicateEmail"))
+	duplicate, err := s.GetCustomerByEmail(websiteID, duplicateEmail)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching customer orders: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error looking up customer", err)
+		return
+	}
+	if duplicate == nil {
+		redirectWith("error", fmt.Sprintf("No customer with the email %q", duplicateEmail))
 		return
 	}
 
-	// Calculate average order value
-	avgOrderValue := 0.0
-	if customer.OrderCount > 0 {
-		avgOrderValue = customer.TotalSpent / float64(customer.OrderCount)
+	err = s.MergeCustomers(websiteID, primaryID, duplicate.ID)
+	if errors.Is(err, errMergeSameCustomer) {
+		redirectWith("error", err.Error())
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		s.renderError(w, r, http.StatusNotFound, "Customer not found", nil)
+		return
+	} else if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to merge customers", err)
+		return
+	}
+
+	s.LogActivity("merge", "customer", primaryID, websiteID, map[string]interface{}{
+		"duplicateId":    duplicate.ID,
+		"duplicateEmail": duplicate.Email,
+	})
+
+	redirectWith("merged", duplicate.Email)
+}
+
+// handleSubscriptionsList displays the list of recurring order subscriptions
+func (s *AdminServer) handleSubscriptionsList(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
 	}
 
-	allSites, _ := s.GetAllWebsites()
+	status := r.URL.Query().Get("status")
+
+	subscriptions, err := s.GetSubscriptions(websiteID, status)
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to load subscriptions", nil)
+		return
+	}
 
 	data := map[string]interface{}{
-		"Title":          "Customer Details",
-		"Website":        website,
-		"Customer":       customer,
-		"Orders":         orders,
-		"AvgOrderValue":  avgOrderValue,
-		"AllSites":       allSites,
-		"CurrentSite":    website,
-		"ActiveSection":  "customers",
+		"Title":         "Subscriptions",
+		"Website":       website,
+		"Subscriptions": subscriptions,
+		"Status":        status,
+		"ActiveSection": "subscriptions",
 	}
 
-	s.renderWithLayout(w, r, "customer_detail_content.html", data)
+	s.renderWithLayout(w, r, "subscriptions_list_content.html", data)
 }
 
 // handleSMSSignupsList displays the list of SMS signups
@@ -2986,9 +4809,8 @@ func (s *AdminServer) handleSMSSignupsList(w http.ResponseWriter, r *http.Reques
 	websiteID := chi.URLParam(r, "id")
 
 	// Get website
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
@@ -3009,7 +4831,7 @@ func (s *AdminServer) handleSMSSignupsList(w http.ResponseWriter, r *http.Reques
 	// Get all SMS signups with filters
 	signups, err := s.GetSMSSignups(websiteID, filters)
 	if err != nil {
-		http.Error(w, "Failed to load SMS signups", http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to load SMS signups", nil)
 		return
 	}
 
@@ -3017,15 +4839,11 @@ func (s *AdminServer) handleSMSSignupsList(w http.ResponseWriter, r *http.Reques
 	countryCodes, _ := s.GetUniqueCountryCodes(websiteID)
 	sources, _ := s.GetUniqueSources(websiteID)
 
-	allSites, _ := s.GetAllWebsites()
-
 	data := map[string]interface{}{
 		"Title":         "SMS Signups",
 		"Website":       website,
 		"Signups":       signups,
 		"ActiveSection": "sms-signups",
-		"AllSites":      allSites,
-		"CurrentSite":   website,
 		"Filters":       filters,
 		"CountryCodes":  countryCodes,
 		"Sources":       sources,
@@ -3040,17 +4858,17 @@ func (s *AdminServer) handleDeleteSMSSignup(w http.ResponseWriter, r *http.Reque
 	signupIDStr := chi.URLParam(r, "signupId")
 	signupID, err := strconv.Atoi(signupIDStr)
 	if err != nil {
-		http.Error(w, "Invalid signup ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid signup ID", nil)
 		return
 	}
 
 	err = s.DeleteSMSSignup(websiteID, signupID)
 	if err != nil {
-		http.Error(w, "Failed to delete signup", http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to delete signup", nil)
 		return
 	}
 
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/sms-signups", websiteID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/sms-signups", websiteID), http.StatusSeeOther)
 }
 
 // handleResendSMSVerification resends verification code to an SMS signup
@@ -3080,7 +4898,7 @@ func (s *AdminServer) handleResendSMSVerification(w http.ResponseWriter, r *http
 	}
 
 	// Get website for Twilio config
-	website, err := s.GetWebsite(websiteID)
+	website, err := s.getWebsiteFromURL(r)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Website not found"})
@@ -3140,7 +4958,7 @@ func (s *AdminServer) handleExportSMSSignups(w http.ResponseWriter, r *http.Requ
 	// Get filtered SMS signups
 	signups, err := s.GetSMSSignups(websiteID, filters)
 	if err != nil {
-		http.Error(w, "Failed to load SMS signups", http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to load SMS signups", nil)
 		return
 	}
 
@@ -3169,9 +4987,8 @@ func (s *AdminServer) handleSMSCampaignForm(w http.ResponseWriter, r *http.Reque
 	websiteID := chi.URLParam(r, "id")
 
 	// Get website
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
@@ -3192,7 +5009,7 @@ func (s *AdminServer) handleSMSCampaignForm(w http.ResponseWriter, r *http.Reque
 	// Get verified SMS signups with filters to show count
 	signups, err := s.GetVerifiedSMSSignups(websiteID, filters)
 	if err != nil {
-		http.Error(w, "Failed to load verified SMS signups", http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to load verified SMS signups", nil)
 		return
 	}
 
@@ -3200,15 +5017,11 @@ func (s *AdminServer) handleSMSCampaignForm(w http.ResponseWriter, r *http.Reque
 	countryCodes, _ := s.GetUniqueCountryCodes(websiteID)
 	sources, _ := s.GetUniqueSources(websiteID)
 
-	allSites, _ := s.GetAllWebsites()
-
 	data := map[string]interface{}{
 		"Title":          "SMS Campaign",
 		"Website":        website,
 		"RecipientCount": len(signups),
 		"ActiveSection":  "sms-campaigns",
-		"AllSites":       allSites,
-		"CurrentSite":    website,
 		"Filters":        filters,
 		"CountryCodes":   countryCodes,
 		"Sources":        sources,
@@ -3222,27 +5035,26 @@ func (s *AdminServer) handleSendBulkSMS(w http.ResponseWriter, r *http.Request)
 	websiteID := chi.URLParam(r, "id")
 
 	// Get website
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.renderError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		return
 	}
 
 	// Parse form data
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
 	// Get message from form
 	message := r.FormValue("message")
 	if message == "" {
-		http.Error(w, "Message is required", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Message is required", nil)
 		return
 	}
 
@@ -3257,12 +5069,12 @@ func (s *AdminServer) handleSendBulkSMS(w http.ResponseWriter, r *http.Request)
 	// Get verified SMS signups with filters
 	signups, err := s.GetVerifiedSMSSignups(websiteID, filters)
 	if err != nil {
-		http.Error(w, "Failed to load verified SMS signups", http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to load verified SMS signups", nil)
 		return
 	}
 
 	if len(signups) == 0 {
-		http.Error(w, "No verified recipients found with the selected filters", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "No verified recipients found with the selected filters", nil)
 		return
 	}
 
@@ -3274,7 +5086,7 @@ func (s *AdminServer) handleSendBulkSMS(w http.ResponseWriter, r *http.Request)
 
 	configData, err := os.ReadFile(configPath)
 	if err != nil {
-		http.Error(w, "Failed to read website config", http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to read website config", nil)
 		return
 	}
 
@@ -3287,7 +5099,11 @@ func (s *AdminServer) handleSendBulkSMS(w http.ResponseWriter, r *http.Request)
 	}
 
 	if err := json.Unmarshal(configData, &siteConfig); err != nil {
-		http.Error(w, "Failed to parse website config", http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to parse website config", nil)
+		return
+	}
+	if err := configs.DecryptSecretFields(&siteConfig.Twilio.AuthToken); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to decrypt Twilio credentials", nil)
 		return
 	}
 
@@ -3312,7 +5128,7 @@ func (s *AdminServer) handleSendBulkSMS(w http.ResponseWriter, r *http.Request)
 	// Send bulk SMS
 	results, err := twilioClient.SendBulkSMS(phoneNumbers, messageWithOptOut)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to send bulk SMS: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to send bulk SMS", err)
 		return
 	}
 
@@ -3331,8 +5147,6 @@ func (s *AdminServer) handleSendBulkSMS(w http.ResponseWriter, r *http.Request)
 	countryCodes, _ := s.GetUniqueCountryCodes(websiteID)
 	sources, _ := s.GetUniqueSources(websiteID)
 
-	allSites, _ := s.GetAllWebsites()
-
 	// Render results page
 	data := map[string]interface{}{
 		"Title":          "SMS Campaign Results",
@@ -3343,8 +5157,6 @@ func (s *AdminServer) handleSendBulkSMS(w http.ResponseWriter, r *http.Request)
 		"FailureCount":   failureCount,
 		"Results":        results,
 		"ActiveSection":  "sms-campaigns",
-		"AllSites":       allSites,
-		"CurrentSite":    website,
 		"Filters":        filters,
 		"CountryCodes":   countryCodes,
 		"Sources":        sources,
@@ -3357,59 +5169,191 @@ func (s *AdminServer) handleSendBulkSMS(w http.ResponseWriter, r *http.Request)
 // Analytics
 // ===============================
 
-func (s *AdminServer) handleAnalytics(w http.ResponseWriter, r *http.Request) {
-	websiteID := chi.URLParam(r, "id")
-	website, err := s.GetWebsite(websiteID)
+// defaultFunnelSteps is the checkout funnel shown on the analytics page, using the events the
+// tracker's e-commerce helpers send
+var defaultFunnelSteps = []string{"add_to_cart", "checkout_started", "purchase"}
+
+// maxFunnelSteps caps how many events a custom funnel can have
+const maxFunnelSteps = 8
+
+// analyticsPresets are the named date ranges the analytics page offers, by their ?range= value
+var analyticsPresets = []struct{ Value, Label string }{
+	{"today", "Today"},
+	{"7d", "Last 7 days"},
+	{"30d", "Last 30 days"},
+	{"90d", "Last 90 days"},
+	{"365d", "Last year"},
+	{"month", "This month"},
+	{"lastmonth", "Last month"},
+	{"ytd", "Year to date"},
+}
+
+// maxAnalyticsDays caps how many days an analytics range can cover
+const maxAnalyticsDays = 731
+
+// analyticsRange is the period analytics are shown for, from midnight on Start to the end of the
+// day on End in the site's timezone
+type analyticsRange struct {
+	Preset string // an analyticsPresets value, or "custom" for ?start= and ?end=
+	Start  time.Time
+	End    time.Time
+	Days   int
+}
+
+// StartDate is the first day of the range as YYYY-MM-DD
+func (ar analyticsRange) StartDate() string {
+	return ar.Start.Format("2006-01-02")
+}
+
+// EndDate is the last day of the range as YYYY-MM-DD
+func (ar analyticsRange) EndDate() string {
+	return ar.End.Format("2006-01-02")
+}
+
+// analyticsDateRange resolves the range an analytics request asks for: a preset with ?range=,
+// dates with ?start= and ?end= (YYYY-MM-DD), or the last N days with ?days=N. Without any of them
+// it's the last 30 days. Ranges include today's data when they reach today
+func analyticsDateRange(r *http.Request, timezone string) (analyticsRange, error) {
+	loc, err := time.LoadLocation(timezone)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
-		return
+		log.Printf("Error loading timezone %s: %v, defaulting to UTC", timezone, err)
+		loc = time.UTC
+	}
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+
+	query := r.URL.Query()
+	preset := query.Get("range")
+	if preset == "" {
+		preset = "30d"
+		if query.Get("start") != "" || query.Get("end") != "" {
+			preset = "custom"
+		} else if d, err := strconv.Atoi(query.Get("days")); err == nil && d > 0 {
+			preset = fmt.Sprintf("%dd", d)
+		}
 	}
 
-	// Parse date range from query params (default to last 30 days)
-	daysParam := r.URL.Query().Get("days")
-	days := 30
-	if daysParam != "" {
-		if d, err := strconv.Atoi(daysParam); err == nil && d > 0 {
-			days = d
+	start, end := today, today
+	switch preset {
+	case "today":
+	case "month":
+		start = thisMonth
+	case "lastmonth":
+		start, end = thisMonth.AddDate(0, -1, 0), thisMonth.AddDate(0, 0, -1)
+	case "ytd":
+		start = time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, loc)
+	case "custom":
+		if start, err = time.ParseInLocation("2006-01-02", query.Get("start"), loc); err != nil {
+			return analyticsRange{}, errors.New("start must be a date (YYYY-MM-DD)")
+		}
+		if end, err = time.ParseInLocation("2006-01-02", query.Get("end"), loc); err != nil {
+			return analyticsRange{}, errors.New("end must be a date (YYYY-MM-DD)")
+		}
+		if end.Before(start) {
+			return analyticsRange{}, errors.New("start must be on or before end")
+		}
+	default:
+		// The last N days including today
+		days, err := strconv.Atoi(strings.TrimSuffix(preset, "d"))
+		if err != nil || days <= 0 || !strings.HasSuffix(preset, "d") {
+			return analyticsRange{}, fmt.Errorf("unknown range %q", preset)
 		}
+		start = today.AddDate(0, 0, -days+1)
 	}
 
-	// Set endDate to end of today to include all of today's data
-	// Load user's configured timezone
-	loc, err := time.LoadLocation(website.Timezone)
+	// Counted by calendar day so DST changes don't matter
+	days := 1
+	for day := start; day.Before(end) && days <= maxAnalyticsDays; day = day.AddDate(0, 0, 1) {
+		days++
+	}
+	if days > maxAnalyticsDays {
+		return analyticsRange{}, fmt.Errorf("ranges can cover at most %d days", maxAnalyticsDays)
+	}
+
+	// Day counts without a preset of their own show up as custom dates
+	isPreset := preset == "custom"
+	for _, p := range analyticsPresets {
+		isPreset = isPreset || p.Value == preset
+	}
+	if !isPreset {
+		preset = "custom"
+	}
+
+	return analyticsRange{
+		Preset: preset,
+		Start:  start,
+		End:    time.Date(end.Year(), end.Month(), end.Day(), 23, 59, 59, 0, loc),
+		Days:   days,
+	}, nil
+}
+
+func (s *AdminServer) handleAnalytics(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	dateRange, err := analyticsDateRange(r, website.Timezone)
 	if err != nil {
-		log.Printf("Error loading timezone %s: %v, defaulting to UTC", website.Timezone, err)
-		loc = time.UTC
+		s.renderError(w, r, http.StatusBadRequest, "Invalid date range: "+err.Error(), nil)
+		return
 	}
-	now := time.Now().In(loc)
-	endDate := time.Date(now.Year(), now.Month(), now.Day(), 23, 59, 59, 0, loc)
-	startDate := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, -days+1) // Start at midnight
+	startDate, endDate := dateRange.Start, dateRange.End
+
+	// Crawler traffic is left out unless asked for with ?bots=1
+	includeBots := r.URL.Query().Get("bots") == "1"
+
+	// ?compare=previous overlays the period before the range on the traffic chart
+	compare := r.URL.Query().Get("compare") == "previous"
 
 	// Get analytics data
-	stats, err := s.GetPageViewStats(websiteID, startDate, endDate)
+	stats, err := s.GetPageViewStats(r.Context(), websiteID, startDate, endDate, includeBots)
 	if err != nil {
 		log.Printf("Error fetching analytics stats: %v", err)
 		stats = make(map[string]interface{})
 	}
 
-	topPages, err := s.GetTopPages(websiteID, startDate, endDate, 20)
+	topPages, err := s.GetTopPages(r.Context(), websiteID, startDate, endDate, 20, includeBots)
 	if err != nil {
 		log.Printf("Error fetching top pages: %v", err)
 		topPages = []map[string]interface{}{}
 	}
 
-	topReferrers, err := s.GetTopReferrers(websiteID, startDate, endDate, 20)
+	topReferrers, err := s.GetTopReferrers(r.Context(), websiteID, startDate, endDate, 20, includeBots)
 	if err != nil {
 		log.Printf("Error fetching top referrers: %v", err)
 		topReferrers = []map[string]interface{}{}
 	}
 
-	eventStats, err := s.GetEventStats(websiteID, startDate, endDate, 20)
+	eventStats, err := s.GetEventStats(r.Context(), websiteID, startDate, endDate, 20)
 	if err != nil {
 		log.Printf("Error fetching event stats: %v", err)
 		eventStats = []map[string]interface{}{}
 	}
 
+	// Drill into one event's properties with ?event=name&property=key
+	selectedEvent := r.URL.Query().Get("event")
+	selectedProperty := r.URL.Query().Get("property")
+	var eventProperties []string
+	var eventBreakdown []map[string]interface{}
+	if selectedEvent != "" {
+		eventProperties, err = s.GetEventPropertyKeys(r.Context(), websiteID, selectedEvent, startDate, endDate)
+		if err != nil {
+			log.Printf("Error fetching event properties: %v", err)
+		}
+		if selectedProperty == "" && len(eventProperties) > 0 {
+			selectedProperty = eventProperties[0]
+		}
+		if selectedProperty != "" {
+			eventBreakdown, err = s.GetEventBreakdown(r.Context(), websiteID, selectedEvent, selectedProperty, startDate, endDate, 20)
+			if err != nil {
+				log.Printf("Error fetching event breakdown: %v", err)
+			}
+		}
+	}
+
 	// Calculate average pages per visitor
 	avgPages := 0.0
 	if totalViews, ok := stats["total_views"].(int64); ok {
@@ -3419,26 +5363,32 @@ func (s *AdminServer) handleAnalytics(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get real-time metrics (active in last 5 minutes)
-	activeUsers, err := s.GetActiveUsers(websiteID, 5)
+	activeUsers, err := s.GetActiveUsers(r.Context(), websiteID, 5)
 	if err != nil {
 		log.Printf("Error fetching active users: %v", err)
 		activeUsers = 0
 	}
 
-	currentPages, err := s.GetCurrentPages(websiteID, 5)
+	currentPages, err := s.GetCurrentPages(r.Context(), websiteID, 5)
 	if err != nil {
 		log.Printf("Error fetching current pages: %v", err)
 		currentPages = []map[string]interface{}{}
 	}
 
+	activeReferrers, err := s.GetActiveReferrers(r.Context(), websiteID, 5)
+	if err != nil {
+		log.Printf("Error fetching active referrers: %v", err)
+		activeReferrers = []map[string]interface{}{}
+	}
+
 	// Get engagement metrics
-	bounceRate, err := s.GetBounceRate(websiteID, startDate, endDate)
+	bounceRate, err := s.GetBounceRate(r.Context(), websiteID, startDate, endDate, includeBots)
 	if err != nil {
 		log.Printf("Error fetching bounce rate: %v", err)
 		bounceRate = 0
 	}
 
-	avgSessionDuration, err := s.GetAverageSessionDuration(websiteID, startDate, endDate)
+	avgSessionDuration, err := s.GetAverageSessionDuration(r.Context(), websiteID, startDate, endDate, includeBots)
 	if err != nil {
 		log.Printf("Error fetching avg session duration: %v", err)
 		avgSessionDuration = 0
@@ -3452,38 +5402,66 @@ func (s *AdminServer) handleAnalytics(w http.ResponseWriter, r *http.Request) {
 		sessionDurationDisplay = fmt.Sprintf("%.0fs", avgSessionDuration)
 	}
 
-	deviceBreakdown, err := s.GetDeviceBreakdown(websiteID, startDate, endDate)
+	deviceBreakdown, err := s.GetDeviceBreakdown(r.Context(), websiteID, startDate, endDate)
 	if err != nil {
 		log.Printf("Error fetching device breakdown: %v", err)
 		deviceBreakdown = make(map[string]int)
 	}
 
-	entryPages, err := s.GetEntryPages(websiteID, startDate, endDate, 10)
+	browserBreakdown, err := s.GetBrowserBreakdown(r.Context(), websiteID, startDate, endDate)
+	if err != nil {
+		log.Printf("Error fetching browser breakdown: %v", err)
+		browserBreakdown = []map[string]interface{}{}
+	}
+
+	geoBreakdown, err := s.GetGeoBreakdown(r.Context(), websiteID, startDate, endDate, 10, includeBots)
+	if err != nil {
+		log.Printf("Error fetching geo breakdown: %v", err)
+		geoBreakdown = []GeoBreakdown{}
+	}
+
+	entryPages, err := s.GetEntryPages(r.Context(), websiteID, startDate, endDate, 10, includeBots)
 	if err != nil {
 		log.Printf("Error fetching entry pages: %v", err)
 		entryPages = []map[string]interface{}{}
 	}
 
-	exitPages, err := s.GetExitPages(websiteID, startDate, endDate, 10)
+	exitPages, err := s.GetExitPages(r.Context(), websiteID, startDate, endDate, 10, includeBots)
 	if err != nil {
 		log.Printf("Error fetching exit pages: %v", err)
 		exitPages = []map[string]interface{}{}
 	}
 
 	// Get e-commerce metrics
-	conversionRate, convertedSessions, totalSessions, err := s.GetConversionRate(websiteID, startDate, endDate)
+	conversionRate, convertedSessions, totalSessions, err := s.GetConversionRate(r.Context(), websiteID, startDate, endDate, includeBots)
 	if err != nil {
 		log.Printf("Error fetching conversion rate: %v", err)
 		conversionRate, convertedSessions, totalSessions = 0, 0, 0
 	}
 
-	abandonmentRate, abandonedCarts, totalCarts, err := s.GetCartAbandonmentRate(websiteID, startDate, endDate)
+	abandonmentRate, abandonedCarts, totalCarts, err := s.GetCartAbandonmentRate(r.Context(), websiteID, startDate, endDate)
 	if err != nil {
 		log.Printf("Error fetching cart abandonment: %v", err)
 		abandonmentRate, abandonedCarts, totalCarts = 0, 0, 0
 	}
 
-	revenueMetrics, err := s.GetRevenueMetrics(websiteID, startDate, endDate)
+	// Funnel steps come from ?funnel=event1,event2,... and default to the checkout funnel
+	var funnelSteps []string
+	for _, step := range strings.Split(r.URL.Query().Get("funnel"), ",") {
+		if step = strings.TrimSpace(step); step != "" && len(funnelSteps) < maxFunnelSteps {
+			funnelSteps = append(funnelSteps, step)
+		}
+	}
+	if len(funnelSteps) < 2 {
+		funnelSteps = defaultFunnelSteps
+	}
+	funnel, err := s.GetFunnel(r.Context(), websiteID, funnelSteps, startDate, endDate)
+	if err != nil {
+		log.Printf("Error fetching funnel: %v", err)
+		funnel = []FunnelStep{}
+	}
+
+	revenueMetrics, err := s.GetRevenueMetrics(r.Context(), websiteID, startDate, endDate)
 	if err != nil {
 		log.Printf("Error fetching revenue metrics: %v", err)
 		revenueMetrics = make(map[string]interface{})
@@ -3496,21 +5474,21 @@ func (s *AdminServer) handleAnalytics(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get time series data for charts
-	timeSeriesData, err := s.GetAnalyticsTimeSeries(websiteID, startDate, endDate, website.Timezone)
+	timeSeriesData, err := s.GetAnalyticsTimeSeries(r.Context(), websiteID, startDate, endDate, website.Timezone, includeBots, compare)
 	if err != nil {
 		log.Printf("Error fetching time series data: %v", err)
 		timeSeriesData = []map[string]interface{}{}
 	}
 
 	// Get engagement metrics
-	engagementData, err := s.GetEngagementTimeSeries(websiteID, startDate, endDate, website.Timezone)
+	engagementData, err := s.GetEngagementTimeSeries(r.Context(), websiteID, startDate, endDate, website.Timezone, includeBots)
 	if err != nil {
 		log.Printf("Error fetching engagement data: %v", err)
 		engagementData = []map[string]interface{}{}
 	}
 
 	// Get growth metrics
-	growthData, err := s.GetGrowthTimeSeries(websiteID, startDate, endDate, website.Timezone)
+	growthData, err := s.GetGrowthTimeSeries(r.Context(), websiteID, startDate, endDate, website.Timezone)
 	if err != nil {
 		log.Printf("Error fetching growth data: %v", err)
 		growthData = []map[string]interface{}{}
@@ -3521,41 +5499,49 @@ func (s *AdminServer) handleAnalytics(w http.ResponseWriter, r *http.Request) {
 	engagementJSON, _ := json.Marshal(engagementData)
 	growthJSON, _ := json.Marshal(growthData)
 
-	allSites, _ := s.GetAllWebsites()
-
 	data := map[string]interface{}{
-		"Title":                   "Analytics",
-		"Website":                 website,
-		"AllSites":                allSites,
-		"CurrentSite":             website,
-		"ActiveSection":           "analytics",
-		"Days":                    days,
-		"StartDate":               startDate.Format("2006-01-02"),
-		"EndDate":                 endDate.Format("2006-01-02"),
-		"Stats":                   stats,
-		"AvgPages":                avgPages,
-		"TopPages":                topPages,
-		"TopReferrers":            topReferrers,
-		"EventStats":              eventStats,
-		"ActiveUsers":             activeUsers,
-		"CurrentPages":            currentPages,
-		"BounceRate":              bounceRate,
-		"AvgSessionDuration":      avgSessionDuration,
-		"SessionDurationDisplay":  sessionDurationDisplay,
-		"DeviceBreakdown":         deviceBreakdown,
-		"EntryPages":              entryPages,
-		"ExitPages":               exitPages,
-		"ConversionRate":          conversionRate,
-		"ConvertedSessions":       convertedSessions,
-		"TotalSessions":           totalSessions,
-		"AbandonmentRate":         abandonmentRate,
-		"AbandonedCarts":          abandonedCarts,
-		"TotalCarts":              totalCarts,
-		"RevenueMetrics":          revenueMetrics,
-		"HasOrders":               hasOrders,
-		"TimeSeriesJSON":          string(timeSeriesJSON),
-		"EngagementJSON":          string(engagementJSON),
-		"GrowthJSON":              string(growthJSON),
+		"Title":                  "Analytics",
+		"Website":                website,
+		"ActiveSection":          "analytics",
+		"Range":                  dateRange,
+		"Presets":                analyticsPresets,
+		"IncludeBots":            includeBots,
+		"Compare":                compare,
+		"StartDate":              dateRange.StartDate(),
+		"EndDate":                dateRange.EndDate(),
+		"Stats":                  stats,
+		"AvgPages":               avgPages,
+		"TopPages":               topPages,
+		"TopReferrers":           topReferrers,
+		"EventStats":             eventStats,
+		"SelectedEvent":          selectedEvent,
+		"SelectedProperty":       selectedProperty,
+		"EventProperties":        eventProperties,
+		"EventBreakdown":         eventBreakdown,
+		"ActiveUsers":            activeUsers,
+		"CurrentPages":           currentPages,
+		"ActiveReferrers":        activeReferrers,
+		"BounceRate":             bounceRate,
+		"AvgSessionDuration":     avgSessionDuration,
+		"SessionDurationDisplay": sessionDurationDisplay,
+		"DeviceBreakdown":        deviceBreakdown,
+		"BrowserBreakdown":       browserBreakdown,
+		"GeoBreakdown":           geoBreakdown,
+		"EntryPages":             entryPages,
+		"ExitPages":              exitPages,
+		"ConversionRate":         conversionRate,
+		"ConvertedSessions":      convertedSessions,
+		"TotalSessions":          totalSessions,
+		"AbandonmentRate":        abandonmentRate,
+		"AbandonedCarts":         abandonedCarts,
+		"TotalCarts":             totalCarts,
+		"Funnel":                 funnel,
+		"FunnelSteps":            strings.Join(funnelSteps, ","),
+		"RevenueMetrics":         revenueMetrics,
+		"HasOrders":              hasOrders,
+		"TimeSeriesJSON":         string(timeSeriesJSON),
+		"EngagementJSON":         string(engagementJSON),
+		"GrowthJSON":             string(growthJSON),
 	}
 
 	s.renderWithLayout(w, r, "analytics_content.html", data)
@@ -3564,63 +5550,233 @@ func (s *AdminServer) handleAnalytics(w http.ResponseWriter, r *http.Request) {
 // handleAnalyticsLocationData returns location data as JSON for map visualization
 func (s *AdminServer) handleAnalyticsLocationData(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
-	website, err := s.GetWebsite(websiteID)
+	website, err := s.getWebsiteFromURL(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "Website not found")
 		return
 	}
 
-	// Parse date range from query params (default to last 30 days)
-	daysParam := r.URL.Query().Get("days")
-	days := 30
-	if daysParam != "" {
-		if d, err := strconv.Atoi(daysParam); err == nil && d > 0 {
-			days = d
-		}
-	}
-
-	// Set date range
-	loc, err := time.LoadLocation(website.Timezone)
+	dateRange, err := analyticsDateRange(r, website.Timezone)
 	if err != nil {
-		log.Printf("Error loading timezone %s: %v, defaulting to UTC", website.Timezone, err)
-		loc = time.UTC
+		writeJSONError(w, http.StatusBadRequest, "Invalid date range: "+err.Error())
+		return
 	}
-	now := time.Now().In(loc)
-	endDate := time.Date(now.Year(), now.Month(), now.Day(), 23, 59, 59, 0, loc)
-	startDate := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, -days+1)
+	startDate, endDate := dateRange.Start, dateRange.End
+
+	// Crawler traffic is left out unless asked for with ?bots=1
+	includeBots := r.URL.Query().Get("bots") == "1"
 
 	// Get location data
-	locations, err := s.GetLocationStats(websiteID, startDate, endDate)
+	locations, err := s.GetLocationStats(r.Context(), websiteID, startDate, endDate, includeBots)
 	if err != nil {
 		log.Printf("Error fetching location stats: %v", err)
-		http.Error(w, "Failed to fetch location data", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch location data")
 		return
 	}
 
 	// Get top countries
-	countries, err := s.GetTopCountries(websiteID, startDate, endDate, 10)
+	countries, err := s.GetTopCountries(r.Context(), websiteID, startDate, endDate, 10, includeBots)
 	if err != nil {
 		log.Printf("Error fetching top countries: %v", err)
 		countries = []map[string]interface{}{}
 	}
 
-	// Return JSON response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"locations": locations,
-		"countries": countries,
-	})
+	// Return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"locations": locations,
+		"countries": countries,
+		"startDate": dateRange.StartDate(),
+		"endDate":   dateRange.EndDate(),
+	})
+}
+
+// analyticsExportColumns are the daily metrics in an analytics export, in column order
+var analyticsExportColumns = []string{
+	"pageviews", "visitors", "sessions", "revenue",
+	"paid_orders", "pending_orders", "avg_pages_per_visit", "avg_time_on_site",
+	"new_customers", "new_sms_signups",
+}
+
+// handleAnalyticsExport downloads the analytics, engagement and growth time series merged into one
+// row per day, as CSV or with ?format=json as JSON. Takes the same date range and ?bots= as the page
+func (s *AdminServer) handleAnalyticsExport(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	dateRange, err := analyticsDateRange(r, website.Timezone)
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid date range: "+err.Error(), nil)
+		return
+	}
+	startDate, endDate := dateRange.Start, dateRange.End
+	includeBots := r.URL.Query().Get("bots") == "1"
+
+	traffic, err := s.GetAnalyticsTimeSeries(r.Context(), websiteID, startDate, endDate, website.Timezone, includeBots, false)
+	if err != nil {
+		log.Printf("Error fetching time series data: %v", err)
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to load analytics", nil)
+		return
+	}
+	engagement, err := s.GetEngagementTimeSeries(r.Context(), websiteID, startDate, endDate, website.Timezone, includeBots)
+	if err != nil {
+		log.Printf("Error fetching engagement data: %v", err)
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to load analytics", nil)
+		return
+	}
+	growth, err := s.GetGrowthTimeSeries(r.Context(), websiteID, startDate, endDate, website.Timezone)
+	if err != nil {
+		log.Printf("Error fetching growth data: %v", err)
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to load analytics", nil)
+		return
+	}
+
+	// Each series has one entry per day of the range, keyed by "date"
+	var dates []string
+	rows := make(map[string]map[string]interface{})
+	for _, series := range [][]map[string]interface{}{traffic, engagement, growth} {
+		for _, day := range series {
+			date, _ := day["date"].(string)
+			row, ok := rows[date]
+			if !ok {
+				row = map[string]interface{}{"date": date}
+				rows[date] = row
+				dates = append(dates, date)
+			}
+			for key, value := range day {
+				row[key] = value
+			}
+		}
+	}
+	sort.Strings(dates)
+
+	filename := fmt.Sprintf("analytics-%s-%s-to-%s", websiteID, startDate.Format("2006-01-02"), endDate.Format("2006-01-02"))
+
+	if r.URL.Query().Get("format") == "json" {
+		days := make([]map[string]interface{}, 0, len(dates))
+		for _, date := range dates {
+			days = append(days, rows[date])
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.json", filename))
+		json.NewEncoder(w).Encode(days)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.csv", filename))
+
+	writer := csv.NewWriter(w)
+	writer.Write(append([]string{"date"}, analyticsExportColumns...))
+	for _, date := range dates {
+		record := []string{date}
+		for _, column := range analyticsExportColumns {
+			record = append(record, fmt.Sprint(rows[date][column]))
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+}
+
+// handleAnalyticsRebuild recomputes a site's daily analytics rollup from scratch, e.g. after
+// changing its timezone or importing old data
+func (s *AdminServer) handleAnalyticsRebuild(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.RebuildDailyAnalytics(r.Context(), websiteID, website.Timezone); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error rebuilding analytics", err)
+		return
+	}
+
+	s.LogActivity("rebuild", "analytics", 0, websiteID, nil)
+
+	http.Redirect(w, r, s.adminURL("/site/%s/analytics", websiteID), http.StatusSeeOther)
+}
+
+// realtimeInterval is how often the realtime stream pushes fresh numbers
+const realtimeInterval = 5 * time.Second
+
+// maxRealtimeStreams caps open realtime streams across all sites, since each one polls the database
+const maxRealtimeStreams = 20
+
+// handleRealtimeStream pushes active users, current pages and active referrers as server-sent
+// events every few seconds until the client disconnects or the server shuts down
+func (s *AdminServer) handleRealtimeStream(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.renderError(w, r, http.StatusInternalServerError, "Streaming not supported", nil)
+		return
+	}
+
+	if s.realtimeStreams.Add(1) > maxRealtimeStreams {
+		s.realtimeStreams.Add(-1)
+		s.renderError(w, r, http.StatusServiceUnavailable, "Too many realtime streams, try again later", nil)
+		return
+	}
+	defer s.realtimeStreams.Add(-1)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // stop nginx from buffering the stream
+
+	ticker := time.NewTicker(realtimeInterval)
+	defer ticker.Stop()
+
+	for {
+		activeUsers, err := s.GetActiveUsers(r.Context(), websiteID, 5)
+		if err != nil {
+			log.Printf("Error fetching active users: %v", err)
+		}
+		currentPages, err := s.GetCurrentPages(r.Context(), websiteID, 5)
+		if err != nil {
+			log.Printf("Error fetching current pages: %v", err)
+		}
+		if currentPages == nil {
+			currentPages = []map[string]interface{}{}
+		}
+		activeReferrers, err := s.GetActiveReferrers(r.Context(), websiteID, 5)
+		if err != nil {
+			log.Printf("Error fetching active referrers: %v", err)
+			activeReferrers = []map[string]interface{}{}
+		}
+
+		payload, _ := json.Marshal(map[string]interface{}{
+			"active_users":     activeUsers,
+			"current_pages":    currentPages,
+			"active_referrers": activeReferrers,
+		})
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", payload); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.realtimeCtx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
 // renderTemplate renders a template with data
 func (s *AdminServer) renderTemplate(w http.ResponseWriter, r *http.Request, tmpl string, data interface{}) {
-	tmplPath := filepath.Join("admin", "templates", tmpl+".html")
-
-	t, err := template.ParseFiles(tmplPath)
+	t, err := s.templates.get(tmpl + ".html")
 	if err != nil {
 		// If template doesn't exist, render a simple placeholder
 		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprintf(w, "<h1>%s</h1><pre>%+v</pre><p><a href='/'>Back to Dashboard</a></p>", tmpl, data)
+		fmt.Fprintf(w, "<h1>%s</h1><pre>%+v</pre><p><a href='%s/'>Back to Dashboard</a></p>", tmpl, data, s.basePath)
 		return
 	}
 
@@ -3636,9 +5792,10 @@ func (s *AdminServer) renderTemplate(w http.ResponseWriter, r *http.Request, tmp
 		templateData = make(map[string]interface{})
 	}
 	templateData["CSRFField"] = csrf.TemplateField(r)
+	templateData["BasePath"] = s.basePath
 
 	if err := t.Execute(w, templateData); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "", err)
 	}
 }
 
@@ -3646,9 +5803,8 @@ func (s *AdminServer) renderTemplate(w http.ResponseWriter, r *http.Request, tmp
 func (s *AdminServer) handleMessagesList(w http.ResponseWriter, r *http.Request) {
 	websiteID := chi.URLParam(r, "id")
 	
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
@@ -3675,26 +5831,25 @@ func (s *AdminServer) handleMessageDetail(w http.ResponseWriter, r *http.Request
 
 	messageID, err := strconv.Atoi(messageIDStr)
 	if err != nil {
-		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid message ID", nil)
 		return
 	}
 
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
 	message, err := s.GetMessage(websiteID, messageID)
 	if err != nil {
-		http.Error(w, "Message not found", http.StatusNotFound)
+		s.renderError(w, r, http.StatusNotFound, "Message not found", nil)
 		return
 	}
 
 	// Mark as read when viewing
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err == nil {
-		dbConn := &database.DBConnection{Database: db, Connected: true}
+		dbConn := &database.DBConnection{Database: db.DB, Connected: true}
 		dbConn.MarkMessageAsRead(messageID)
 		db.Close()
 		// Update the message status in memory to reflect the change
@@ -3733,33 +5888,33 @@ func (s *AdminServer) handleMessageReply(w http.ResponseWriter, r *http.Request)
 
 	messageID, err := strconv.Atoi(messageIDStr)
 	if err != nil {
-		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid message ID", nil)
 		return
 	}
 
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", nil)
 		return
 	}
 
 	replyText := r.FormValue("reply_text")
 	if replyText == "" {
-		http.Error(w, "Reply text is required", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Reply text is required", nil)
 		return
 	}
 
 	// Get the original message
 	message, err := s.GetMessage(websiteID, messageID)
 	if err != nil {
-		http.Error(w, "Message not found", http.StatusNotFound)
+		s.renderError(w, r, http.StatusNotFound, "Message not found", nil)
 		return
 	}
 
 	// Send email to customer FIRST
-	website, _ := s.GetWebsite(websiteID)
+	website, _ := s.getWebsiteFromURL(r)
 	err = s.SendReplyEmail(&website, message, replyText)
 
-	redirectURL := fmt.Sprintf("/site/%s/messages/%d", websiteID, messageID)
+	redirectURL := s.adminURL("/site/%s/messages/%d", websiteID, messageID)
 	if err != nil {
 		// Email failed - don't save reply, preserve text for retry
 		log.Printf("Failed to send email: %v", err)
@@ -3771,16 +5926,16 @@ func (s *AdminServer) handleMessageReply(w http.ResponseWriter, r *http.Request)
 	// Email sent successfully - now save reply to database
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Database error", nil)
 		return
 	}
 	defer db.Close()
 
-	dbConn := &database.DBConnection{Database: db, Connected: true}
+	dbConn := &database.DBConnection{Database: db.DB, Connected: true}
 	err = dbConn.CreateReply(messageID, replyText, "admin")
 	if err != nil {
 		log.Printf("Error saving reply: %v", err)
-		http.Error(w, "Failed to save reply", http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to save reply", nil)
 		return
 	}
 
@@ -3796,13 +5951,13 @@ func (s *AdminServer) handleMessageToggleRead(w http.ResponseWriter, r *http.Req
 
 	messageID, err := strconv.Atoi(messageIDStr)
 	if err != nil {
-		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid message ID", nil)
 		return
 	}
 
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Database error", nil)
 		return
 	}
 	defer db.Close()
@@ -3810,11 +5965,11 @@ func (s *AdminServer) handleMessageToggleRead(w http.ResponseWriter, r *http.Req
 	// Get current status
 	message, err := s.GetMessage(websiteID, messageID)
 	if err != nil {
-		http.Error(w, "Message not found", http.StatusNotFound)
+		s.renderError(w, r, http.StatusNotFound, "Message not found", nil)
 		return
 	}
 
-	dbConn := &database.DBConnection{Database: db, Connected: true}
+	dbConn := &database.DBConnection{Database: db.DB, Connected: true}
 	var redirectToList bool
 	if message.Status == "read" {
 		err = dbConn.MarkMessageAsUnread(messageID)
@@ -3825,15 +5980,15 @@ func (s *AdminServer) handleMessageToggleRead(w http.ResponseWriter, r *http.Req
 	}
 
 	if err != nil {
-		http.Error(w, "Failed to update status", http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to update status", nil)
 		return
 	}
 
 	// Redirect appropriately
 	if redirectToList {
-		http.Redirect(w, r, fmt.Sprintf("/site/%s/messages", websiteID), http.StatusSeeOther)
+		http.Redirect(w, r, s.adminURL("/site/%s/messages", websiteID), http.StatusSeeOther)
 	} else {
-		http.Redirect(w, r, fmt.Sprintf("/site/%s/messages/%d", websiteID, messageID), http.StatusSeeOther)
+		http.Redirect(w, r, s.adminURL("/site/%s/messages/%d", websiteID, messageID), http.StatusSeeOther)
 	}
 }
 
@@ -3844,19 +5999,420 @@ func (s *AdminServer) handleMessageDelete(w http.ResponseWriter, r *http.Request
 
 	messageID, err := strconv.Atoi(messageIDStr)
 	if err != nil {
-		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid message ID", nil)
 		return
 	}
 
 	err = s.DeleteMessage(websiteID, messageID)
 	if err != nil {
 		log.Printf("Error deleting message: %v", err)
-		http.Error(w, "Failed to delete message", http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to delete message", nil)
 		return
 	}
 
 	// Redirect back to messages list
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/messages", websiteID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/messages", websiteID), http.StatusSeeOther)
+}
+
+// handleQuestionsList renders customer questions about products, pending ones first by default
+func (s *AdminServer) handleQuestionsList(w http.ResponseWriter, r *http.Request) {
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "pending"
+	}
+	filter := status
+	if status == "all" || !productQuestionStatuses[status] {
+		status, filter = "all", ""
+	}
+
+	questions, err := s.GetProductQuestions(website.ID, filter)
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error loading questions", err)
+		return
+	}
+
+	pendingCount, err := s.CountPendingProductQuestions(website.ID)
+	if err != nil {
+		log.Printf("Error counting pending questions: %v", err)
+	}
+
+	s.renderWithLayout(w, r, "questions_list_content.html", map[string]interface{}{
+		"Title":         "Product Questions",
+		"ActiveSection": "questions",
+		"Website":       website,
+		"Questions":     questions,
+		"Status":        status,
+		"PendingCount":  pendingCount,
+		"Error":         r.URL.Query().Get("error"),
+	})
+}
+
+// questionIDFromURL parses the question ID route parameter, rendering a 400 when it isn't a number
+func (s *AdminServer) questionIDFromURL(w http.ResponseWriter, r *http.Request) (int, bool) {
+	questionID, err := strconv.Atoi(chi.URLParam(r, "questionId"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid question ID", nil)
+		return 0, false
+	}
+	return questionID, true
+}
+
+// questionsListURL is where question actions return to, keeping the status filter they came from
+// and showing errorMessage when there is one
+func (s *AdminServer) questionsListURL(r *http.Request, websiteID, errorMessage string) string {
+	query := url.Values{}
+	if status := r.FormValue("status"); status != "" {
+		query.Set("status", status)
+	}
+	if errorMessage != "" {
+		query.Set("error", errorMessage)
+	}
+
+	listURL := s.adminURL("/site/%s/questions", websiteID)
+	if len(query) > 0 {
+		listURL += "?" + query.Encode()
+	}
+	return listURL
+}
+
+// handleQuestionAnswer saves an answer and publishes the question on its product
+func (s *AdminServer) handleQuestionAnswer(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	questionID, ok := s.questionIDFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	answer := strings.TrimSpace(r.FormValue("answer"))
+	if answer == "" {
+		s.renderError(w, r, http.StatusBadRequest, "Answer is required", nil)
+		return
+	}
+
+	if err := s.AnswerProductQuestion(websiteID, questionID, answer, s.getSessionUsername(r)); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to save answer", err)
+		return
+	}
+
+	s.LogActivity("answer", "product_question", questionID, websiteID, nil)
+
+	http.Redirect(w, r, s.questionsListURL(r, websiteID, ""), http.StatusSeeOther)
+}
+
+// handleQuestionStatus publishes, hides or returns a question to pending
+func (s *AdminServer) handleQuestionStatus(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	questionID, ok := s.questionIDFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	status := r.FormValue("to")
+	err := s.SetProductQuestionStatus(websiteID, questionID, status)
+	if err == errQuestionNotAnswered {
+		http.Redirect(w, r, s.questionsListURL(r, websiteID, err.Error()), http.StatusSeeOther)
+		return
+	}
+	if err == sql.ErrNoRows {
+		s.renderError(w, r, http.StatusNotFound, "Question not found", nil)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Failed to update question", err)
+		return
+	}
+
+	s.LogActivity("update", "product_question", questionID, websiteID, map[string]string{"status": status})
+
+	http.Redirect(w, r, s.questionsListURL(r, websiteID, ""), http.StatusSeeOther)
+}
+
+// handleQuestionDelete deletes a question
+func (s *AdminServer) handleQuestionDelete(w http.ResponseWriter, r *http.Request) {
+	websiteID := chi.URLParam(r, "id")
+	questionID, ok := s.questionIDFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.DeleteProductQuestion(websiteID, questionID); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to delete question", err)
+		return
+	}
+
+	s.LogActivity("delete", "product_question", questionID, websiteID, nil)
+
+	http.Redirect(w, r, s.questionsListURL(r, websiteID, ""), http.StatusSeeOther)
+}
+
+// handleReturnsList renders customers' return requests, those waiting for approval first by default
+func (s *AdminServer) handleReturnsList(w http.ResponseWriter, r *http.Request) {
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = database.ReturnRequested
+	}
+	filter := status
+	if status == "all" || !returnStatuses[status] {
+		status, filter = "all", ""
+	}
+
+	returns, err := s.GetOrderReturns(website.ID, filter)
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error loading returns", err)
+		return
+	}
+
+	requestedCount, err := s.CountRequestedOrderReturns(website.ID)
+	if err != nil {
+		log.Printf("Error counting requested returns: %v", err)
+	}
+
+	s.renderWithLayout(w, r, "returns_list_content.html", map[string]interface{}{
+		"Title":          "Returns",
+		"ActiveSection":  "returns",
+		"Website":        website,
+		"Returns":        returns,
+		"Status":         status,
+		"RequestedCount": requestedCount,
+		"Error":          r.URL.Query().Get("error"),
+	})
+}
+
+// returnIDFromURL parses the return ID route parameter, rendering a 400 when it isn't a number
+func (s *AdminServer) returnIDFromURL(w http.ResponseWriter, r *http.Request) (int, bool) {
+	returnID, err := strconv.Atoi(chi.URLParam(r, "returnId"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid return ID", nil)
+		return 0, false
+	}
+	return returnID, true
+}
+
+// returnsListURL is where return actions go back to, keeping the status filter they came from
+// and showing errorMessage when there is one
+func (s *AdminServer) returnsListURL(r *http.Request, websiteID, errorMessage string) string {
+	query := url.Values{}
+	if status := r.FormValue("status"); status != "" {
+		query.Set("status", status)
+	}
+	if errorMessage != "" {
+		query.Set("error", errorMessage)
+	}
+
+	listURL := s.adminURL("/site/%s/returns", websiteID)
+	if len(query) > 0 {
+		listURL += "?" + query.Encode()
+	}
+	return listURL
+}
+
+// sendReturnUpdate emails the customer that their return moved to a new status. Failures are only
+// logged, the return has been updated either way
+func (s *AdminServer) sendReturnUpdate(website Website, orderReturn OrderReturn, status, note string, refundAmount float64) {
+	emailService, err := email.NewEmailService()
+	if err == nil {
+		err = emailService.SendReturnUpdate(website.emailConfig(), orderReturn.OrderNumber, orderReturn.CustomerEmail,
+			orderReturn.CustomerName, orderReturn.ID, status, note, refundAmount)
+	}
+	if err != nil {
+		log.Printf("Failed to send return %s email for return %d: %v", status, orderReturn.ID, err)
+	}
+}
+
+// handleReturnStatus approves or rejects a requested return and tells the customer
+func (s *AdminServer) handleReturnStatus(w http.ResponseWriter, r *http.Request) {
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+	returnID, ok := s.returnIDFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	status := r.FormValue("to")
+	if status != database.ReturnApproved && status != database.ReturnRejected {
+		s.renderError(w, r, http.StatusBadRequest, "Returns can only be approved or rejected", nil)
+		return
+	}
+
+	orderReturn, err := s.GetOrderReturn(website.ID, returnID)
+	if err == sql.ErrNoRows {
+		s.renderError(w, r, http.StatusNotFound, "Return not found", nil)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error loading return", err)
+		return
+	}
+
+	note := strings.TrimSpace(r.FormValue("note"))
+	err = s.SetOrderReturnStatus(website.ID, returnID, database.ReturnRequested, status, note)
+	if err == errReturnStatusChanged {
+		http.Redirect(w, r, s.returnsListURL(r, website.ID, err.Error()), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to update return", err)
+		return
+	}
+
+	s.LogActivity("update", "order_return", returnID, website.ID, map[string]interface{}{
+		"order_id": orderReturn.OrderID,
+		"status":   status,
+	})
+	s.sendReturnUpdate(website, orderReturn, status, note, 0)
+
+	http.Redirect(w, r, s.returnsListURL(r, website.ID, ""), http.StatusSeeOther)
+}
+
+// handleReturnReceive marks an approved return's items as back in the store, restocking the ones
+// ticked as resellable, and tells the customer
+func (s *AdminServer) handleReturnReceive(w http.ResponseWriter, r *http.Request) {
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+	returnID, ok := s.returnIDFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	orderReturn, err := s.GetOrderReturn(website.ID, returnID)
+	if err == sql.ErrNoRows {
+		s.renderError(w, r, http.StatusNotFound, "Return not found", nil)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error loading return", err)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data", err)
+		return
+	}
+	resellable := make(map[int]bool)
+	for _, value := range r.Form["resellable"] {
+		if orderItemID, err := strconv.Atoi(value); err == nil {
+			resellable[orderItemID] = true
+		}
+	}
+
+	note := strings.TrimSpace(r.FormValue("note"))
+	err = s.ReceiveOrderReturn(website.ID, returnID, resellable, note)
+	if err == errReturnStatusChanged {
+		http.Redirect(w, r, s.returnsListURL(r, website.ID, err.Error()), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to receive return", err)
+		return
+	}
+
+	s.LogActivity("receive", "order_return", returnID, website.ID, map[string]interface{}{
+		"order_id":   orderReturn.OrderID,
+		"resellable": len(resellable),
+	})
+	s.sendReturnUpdate(website, orderReturn, database.ReturnReceived, note, 0)
+
+	http.Redirect(w, r, s.returnsListURL(r, website.ID, ""), http.StatusSeeOther)
+}
+
+// handleReturnRefund refunds a received return to the customer's card or as store credit and
+// tells the customer. The amount defaults to what the returned items sold for; zero closes the
+// return without refunding anything
+func (s *AdminServer) handleReturnRefund(w http.ResponseWriter, r *http.Request) {
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
+		return
+	}
+	returnID, ok := s.returnIDFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	orderReturn, err := s.GetOrderReturn(website.ID, returnID)
+	if err == sql.ErrNoRows {
+		s.renderError(w, r, http.StatusNotFound, "Return not found", nil)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error loading return", err)
+		return
+	}
+
+	refundAmount := orderReturn.ItemsTotal()
+	if value := strings.TrimSpace(r.FormValue("refund_amount")); value != "" {
+		refundAmount, err = strconv.ParseFloat(value, 64)
+		if err != nil || refundAmount < 0 {
+			http.Redirect(w, r, s.returnsListURL(r, website.ID, "Invalid refund amount"), http.StatusSeeOther)
+			return
+		}
+		refundAmount = utils.RoundMoney(refundAmount, "")
+	}
+
+	order, err := s.GetOrder(website.ID, orderReturn.OrderID)
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Error fetching order", err)
+		return
+	}
+	if refundAmount > 0 && order.PaymentStatus != "paid" {
+		http.Redirect(w, r, s.returnsListURL(r, website.ID, "Cannot refund: order has not been paid"), http.StatusSeeOther)
+		return
+	}
+
+	// Claim the return before moving any money, so a double submit can't refund it twice
+	note := strings.TrimSpace(r.FormValue("note"))
+	err = s.SetOrderReturnStatus(website.ID, returnID, database.ReturnReceived, database.ReturnRefunded, note)
+	if err == errReturnStatusChanged {
+		http.Redirect(w, r, s.returnsListURL(r, website.ID, err.Error()), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to update return", err)
+		return
+	}
+
+	refundTo := "card"
+	if refundAmount > 0 {
+		if r.FormValue("refund_to") == "store_credit" {
+			refundTo = "store_credit"
+			_, err = s.RefundOrderToStoreCredit(website.ID, order.ID, refundAmount, s.getSessionUsername(r))
+		} else {
+			_, err = s.refundOrderToCard(website, order, refundAmount)
+		}
+		if err != nil {
+			if reopenErr := s.SetOrderReturnStatus(website.ID, returnID, database.ReturnRefunded, database.ReturnReceived, ""); reopenErr != nil {
+				log.Printf("Failed to reopen return %d after a failed refund: %v", returnID, reopenErr)
+			}
+			http.Redirect(w, r, s.returnsListURL(r, website.ID, err.Error()), http.StatusSeeOther)
+			return
+		}
+	}
+
+	if err := s.SetOrderReturnRefundAmount(website.ID, returnID, refundAmount); err != nil {
+		log.Printf("Failed to save refund amount for return %d: %v", returnID, err)
+	}
+
+	s.LogActivity("refund", "order_return", returnID, website.ID, map[string]interface{}{
+		"order_id":  order.ID,
+		"amount":    refundAmount,
+		"refund_to": refundTo,
+	})
+	s.sendReturnUpdate(website, orderReturn, database.ReturnRefunded, note, refundAmount)
+
+	http.Redirect(w, r, s.returnsListURL(r, website.ID, ""), http.StatusSeeOther)
 }
 
 // SendReplyEmail sends an email reply to the customer using SMTP
@@ -3947,7 +6503,7 @@ func (s *AdminServer) handleShippingLabelCancel(w http.ResponseWriter, r *http.R
 	}
 
 	// Get website for Shippo config
-	website, err := s.GetWebsite(websiteID)
+	website, err := s.getWebsiteFromURL(r)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -4036,80 +6592,99 @@ func (s *AdminServer) handleOrderRefund(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Validate Stripe payment intent exists
-	if order.StripePaymentIntent == "" {
+	// Refund as store credit for the customer instead of to the card
+	if r.FormValue("refund_to") == "store_credit" {
+		newRefundedAmount, err := s.RefundOrderToStoreCredit(websiteID, orderID, refundAmount, s.getSessionUsername(r))
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   fmt.Sprintf("Failed to issue store credit: %v", err),
+			})
+			return
+		}
+
+		s.LogActivity("refund", "order", orderID, websiteID, map[string]interface{}{
+			"amount":    refundAmount,
+			"refund_to": "store_credit",
+		})
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   "No Stripe payment intent found for this order",
+			"success":         true,
+			"message":         fmt.Sprintf("Issued $%.2f store credit", refundAmount),
+			"refunded_amount": newRefundedAmount,
 		})
 		return
 	}
 
-	// Calculate remaining refundable amount
-	remainingAmount := order.Total - order.RefundedAmount
-	if refundAmount > remainingAmount {
+	// Get website for Stripe config
+	website, err := s.getWebsiteFromURL(r)
+	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success": false,
-			"error":   fmt.Sprintf("Refund amount ($%.2f) exceeds remaining refundable amount ($%.2f)", refundAmount, remainingAmount),
+			"error":   "Website not found",
 		})
 		return
 	}
 
-	// Get website for Stripe config
-	website, err := s.GetWebsite(websiteID)
+	newRefundedAmount, err := s.refundOrderToCard(website, order, refundAmount)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success": false,
-			"error":   "Website not found",
+			"error":   err.Error(),
 		})
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":         true,
+		"message":         fmt.Sprintf("Successfully refunded %s", utils.FormatMoney(refundAmount, website.Currency)),
+		"refunded_amount": newRefundedAmount,
+	})
+}
+
+// refundOrderToCard refunds part or all of a paid order to the card it was paid with through
+// Stripe, and returns the order's new refunded total
+func (s *AdminServer) refundOrderToCard(website Website, order Order, refundAmount float64) (float64, error) {
+	// Validate Stripe payment intent exists
+	if order.StripePaymentIntent == "" {
+		return 0, errors.New("No Stripe payment intent found for this order")
+	}
+
+	// Calculate remaining refundable amount, in the smallest unit of the currency the order was charged in
+	remainingAmount := utils.RoundMoney(order.Total-order.RefundedAmount, "")
+	if utils.StripeAmount(refundAmount, website.Currency) > utils.StripeAmount(remainingAmount, website.Currency) {
+		return 0, fmt.Errorf("Refund amount (%s) exceeds remaining refundable amount (%s)", utils.FormatMoney(refundAmount, website.Currency), utils.FormatMoney(remainingAmount, website.Currency))
+	}
+
 	// Initialize Stripe client
 	stripe.Key = website.StripeSecretKey
 
-	// Convert amount to cents for Stripe
-	refundAmountCents := int64(refundAmount * 100)
-
 	// Create refund via Stripe API
 	refundParams := &stripe.RefundParams{
 		PaymentIntent: stripe.String(order.StripePaymentIntent),
-		Amount:        stripe.Int64(refundAmountCents),
+		Amount:        stripe.Int64(utils.StripeAmount(refundAmount, website.Currency)),
 	}
 
-	_, err = refund.New(refundParams)
+	_, err := refund.New(refundParams)
 	if err != nil {
 		log.Printf("Stripe refund failed: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   fmt.Sprintf("Failed to process refund: %v", err),
-		})
-		return
+		return 0, fmt.Errorf("Failed to process refund: %v", err)
 	}
 
 	// Update order refunded amount in database
-	newRefundedAmount := order.RefundedAmount + refundAmount
-	err = s.UpdateOrderRefundedAmount(websiteID, orderID, newRefundedAmount)
+	newRefundedAmount := utils.RoundMoney(order.RefundedAmount+refundAmount, "")
+	err = s.UpdateOrderRefundedAmount(website.ID, order.ID, newRefundedAmount)
 	if err != nil {
 		log.Printf("Failed to update refunded amount in database: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   "Refund processed but failed to update database",
-		})
-		return
+		return 0, errors.New("Refund processed but failed to update database")
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"message": fmt.Sprintf("Successfully refunded $%.2f", refundAmount),
-		"refunded_amount": newRefundedAmount,
-	})
+	return newRefundedAmount, nil
 }
 
 // handleOrderEdit displays the order edit form
@@ -4118,33 +6693,28 @@ func (s *AdminServer) handleOrderEdit(w http.ResponseWriter, r *http.Request) {
 	orderIDStr := chi.URLParam(r, "orderId")
 	orderID, err := strconv.Atoi(orderIDStr)
 	if err != nil {
-		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid order ID", nil)
 		return
 	}
 
 	// Get website
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
 	// Get order details
 	order, err := s.GetOrder(websiteID, orderID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching order: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error fetching order", err)
 		return
 	}
 
-	allSites, _ := s.GetAllWebsites()
-
 	data := map[string]interface{}{
 		"Title":         "Edit Order",
 		"Website":       website,
 		"Order":         order,
 		"TaxRate":       website.TaxRate,
-		"AllSites":      allSites,
-		"CurrentSite":   website,
 		"ActiveSection": "orders",
 	}
 
@@ -4157,21 +6727,21 @@ func (s *AdminServer) handleOrderUpdate(w http.ResponseWriter, r *http.Request)
 	orderIDStr := chi.URLParam(r, "orderId")
 	orderID, err := strconv.Atoi(orderIDStr)
 	if err != nil {
-		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid order ID", nil)
 		return
 	}
 
 	// Get current order state
 	originalOrder, err := s.GetOrder(websiteID, orderID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching order: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Error fetching order", err)
 		return
 	}
 
 	// Parse form data
 	err = r.ParseForm()
 	if err != nil {
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest, "Failed to parse form", nil)
 		return
 	}
 
@@ -4206,8 +6776,8 @@ func (s *AdminServer) handleOrderUpdate(w http.ResponseWriter, r *http.Request)
 		quantity, _ := strconv.Atoi(r.FormValue(fmt.Sprintf("items[%d][quantity]", itemIndex)))
 		price, _ := strconv.ParseFloat(r.FormValue(fmt.Sprintf("items[%d][price]", itemIndex)), 64)
 
-		total := float64(quantity) * price
-		subtotal += total
+		total := utils.RoundMoney(float64(quantity)*price, "")
+		subtotal = utils.RoundMoney(subtotal+total, "")
 
 		newItems = append(newItems, map[string]interface{}{
 			"id":         itemID,
@@ -4225,23 +6795,21 @@ func (s *AdminServer) handleOrderUpdate(w http.ResponseWriter, r *http.Request)
 	deletedItemIDs := r.Form["deleted_items[]"]
 
 	// Get website for tax rate
-	website, err := s.GetWebsite(websiteID)
-	if err != nil {
-		http.Error(w, "Website not found", http.StatusNotFound)
+	website, ok := s.requireWebsite(w, r)
+	if !ok {
 		return
 	}
 
 	// Calculate new totals
-	tax := subtotal * website.TaxRate
-	newTotal := subtotal + tax + originalOrder.ShippingCost
+	tax, newTotal := database.OrderTotals(subtotal, website.TaxRate, originalOrder.ShippingCost, website.TaxInclusive, website.TaxRounding)
 
 	// Calculate payment difference
-	paymentDifference := newTotal - originalOrder.Total
+	paymentDifference := float64(utils.ToCents(newTotal, "")-utils.ToCents(originalOrder.Total, "")) / 100
 
 	// Update order in database
 	err = s.UpdateOrderDetails(websiteID, orderID, customerName, customerEmail, shippingAddr, subtotal, tax, newTotal)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to update order: %v", err), http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to update order", err)
 		return
 	}
 
@@ -4249,7 +6817,7 @@ func (s *AdminServer) handleOrderUpdate(w http.ResponseWriter, r *http.Request)
 	err = s.UpdateOrderItems(websiteID, orderID, newItems, deletedItemIDs, originalOrder.Items)
 	if err != nil {
 		log.Printf("Failed to update order items: %v", err)
-		http.Error(w, "Failed to update order items", http.StatusInternalServerError)
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to update order items", nil)
 		return
 	}
 
@@ -4258,11 +6826,11 @@ func (s *AdminServer) handleOrderUpdate(w http.ResponseWriter, r *http.Request)
 		err = s.AdjustOrderPayment(websiteID, orderID, &originalOrder, paymentDifference)
 		if err != nil {
 			log.Printf("Payment adjustment failed: %v", err)
-			http.Error(w, fmt.Sprintf("Order updated but payment adjustment failed: %v", err), http.StatusInternalServerError)
+			s.renderError(w, r, http.StatusInternalServerError, "Order updated but payment adjustment failed", err)
 			return
 		}
 	}
 
 	// Redirect back to order detail page
-	http.Redirect(w, r, fmt.Sprintf("/site/%s/orders/%d", websiteID, orderID), http.StatusSeeOther)
+	http.Redirect(w, r, s.adminURL("/site/%s/orders/%d", websiteID, orderID), http.StatusSeeOther)
 }
@@ -0,0 +1,97 @@
+package admin
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAmountField(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    float64
+		problem bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{" 0.0825 ", 0.0825, false},
+		{"5.99", 5.99, false},
+		{"-0.01", -0.01, true},
+		{"-5", -5, true},
+		{"abc", 0, true},
+		{"NaN", 0, true},
+		{"Inf", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, problem := parseAmountField(tt.value, "Shipping cost")
+		if (problem != "") != tt.problem {
+			t.Errorf("parseAmountField(%q) problem = %q, want one: %v", tt.value, problem, tt.problem)
+		}
+		if !tt.problem && got != tt.want {
+			t.Errorf("parseAmountField(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+		if problem != "" && !strings.HasPrefix(problem, "Shipping cost") {
+			t.Errorf("parseAmountField(%q) problem %q doesn't name the field", tt.value, problem)
+		}
+	}
+}
+
+func TestParsePortField(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		problem bool
+	}{
+		{"", 0, false},
+		{"1", 1, false},
+		{"587", 587, false},
+		{" 993 ", 993, false},
+		{"65535", 65535, false},
+		{"0", 0, true},
+		{"-25", 0, true},
+		{"65536", 0, true},
+		{"smtp", 0, true},
+		{"25.5", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, problem := parsePortField(tt.value, "SMTP port")
+		if (problem != "") != tt.problem {
+			t.Errorf("parsePortField(%q) problem = %q, want one: %v", tt.value, problem, tt.problem)
+		}
+		if !tt.problem && got != tt.want {
+			t.Errorf("parsePortField(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestValidateSiteSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		site     Website
+		problems int
+	}{
+		{"defaults", Website{}, 0},
+		{"known timezone", Website{Timezone: "America/Los_Angeles"}, 0},
+		{"UTC", Website{Timezone: "UTC"}, 0},
+		{"unknown timezone", Website{Timezone: "America/Springfield"}, 1},
+		{"offset instead of a zone", Website{Timezone: "-08:00"}, 1},
+		{"tax rate as a fraction", Website{TaxRate: 0.0825}, 0},
+		{"tax rate as a percent", Website{TaxRate: 8.25}, 1},
+		{"currency code", Website{Currency: "EUR"}, 0},
+		{"lower case currency", Website{Currency: "eur"}, 1},
+		{"robots.txt", Website{RobotsTxt: "User-agent: *\nDisallow: /admin\nSitemap: https://example.com/sitemap.xml"}, 0},
+		{"robots.txt rule before a user agent", Website{RobotsTxt: "Disallow: /admin\nUser-agent: *"}, 1},
+		{"robots.txt unknown field", Website{RobotsTxt: "User-agent: *\nNoindex: /"}, 1},
+		{"robots.txt relative sitemap", Website{RobotsTxt: "Sitemap: /sitemap.xml"}, 1},
+		{"everything wrong", Website{Timezone: "Mars/Olympus", TaxRate: 10, Currency: "dollars", RobotsTxt: "hello"}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if problems := validateSiteSettings(tt.site); len(problems) != tt.problems {
+				t.Errorf("validateSiteSettings() = %q, want %d problems", problems, tt.problems)
+			}
+		})
+	}
+}
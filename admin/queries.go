@@ -1,21 +1,43 @@
 package admin
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"math"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/murdinc/stencil2/api"
 	"github.com/murdinc/stencil2/configs"
+	"github.com/murdinc/stencil2/database"
+	"github.com/murdinc/stencil2/email"
+	"github.com/murdinc/stencil2/media"
+	"github.com/murdinc/stencil2/metrics"
 	"github.com/murdinc/stencil2/shippo"
 	"github.com/murdinc/stencil2/structs"
 	"github.com/murdinc/stencil2/twilio"
+	"github.com/murdinc/stencil2/utils"
 	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/paymentintent"
 	"github.com/stripe/stripe-go/v78/refund"
 )
 
@@ -30,9 +52,14 @@ type Website struct {
 	APIVersion    int       `json:"apiVersion"`
 	Timezone      string    `json:"timezone"` // IANA timezone (e.g., "America/Los_Angeles")
 
+	// Languages
+	DefaultLocale string   `json:"defaultLocale"` // language the content is written in
+	Locales       []string `json:"locales"`       // translations the API serves, any when empty
+
 	// Stripe
 	StripePublishableKey string `json:"stripePublishableKey"`
 	StripeSecretKey      string `json:"stripeSecretKey"`
+	StripeWebhookSecret  string `json:"stripeWebhookSecret"`
 
 	// Shippo
 	ShippoAPIKey  string `json:"shippoApiKey"`
@@ -63,13 +90,40 @@ type Website struct {
 	SMTPUseTLS   bool   `json:"smtpUseTLS"`
 
 	// Ecommerce
-	TaxRate      float64 `json:"taxRate"`
-	ShippingCost float64 `json:"shippingCost"`
+	TaxRate           float64 `json:"taxRate"`
+	TaxInclusive      bool    `json:"taxInclusive"` // prices include tax, which is backed out rather than added
+	TaxRounding       string  `json:"taxRounding"`  // utils.RoundHalfUp (default) or utils.RoundHalfEven
+	ShippingCost      float64 `json:"shippingCost"`
+	MinOrderAmount    float64 `json:"minOrderAmount"`
+	OrderNumberPrefix string  `json:"orderNumberPrefix"`
+	Currency          string  `json:"currency"` // ISO 4217 code prices are shown in, empty for USD
+	ManualCapture     bool    `json:"manualCapture"`
+	StaleOrderDays    int     `json:"staleOrderDays"`  // Days a paid order can wait to ship before it needs attention
+	RestockReturns    bool    `json:"restockReturns"`  // Received return items are restocked unless unticked
+	RiskReviewScore   int     `json:"riskReviewScore"` // Orders with a risk score of at least this are flagged for review
+
+	// Order digest, replacing the per-order admin notification
+	OrderDigestEnabled       bool `json:"orderDigestEnabled"`
+	OrderDigestIntervalHours int  `json:"orderDigestIntervalHours"` // Hours between digests
+
+	// Monthly goals shown on the dashboard, 0 for none
+	GoalMonthlyRevenue float64 `json:"goalMonthlyRevenue"`
+	GoalMonthlyOrders  int     `json:"goalMonthlyOrders"`
+
+	// Chat notifications
+	ChatWebhookURL        string `json:"chatWebhookUrl"` // Slack or Discord incoming webhook for new orders
+	ChatLowStock          bool   `json:"chatLowStock"`
+	ChatLowStockThreshold int    `json:"chatLowStockThreshold"`
 
 	// Early Access
 	EarlyAccessEnabled  bool   `json:"earlyAccessEnabled"`
 	EarlyAccessPassword string `json:"earlyAccessPassword"`
 
+	// Maintenance
+	MaintenanceEnabled    bool   `json:"maintenanceEnabled"`
+	MaintenanceMessage    string `json:"maintenanceMessage"`
+	MaintenanceRetryAfter int    `json:"maintenanceRetryAfter"` // Seconds sent in the Retry-After header
+
 	// ShipFrom Address
 	ShipFromName    string `json:"shipFromName"`
 	ShipFromStreet1 string `json:"shipFromStreet1"`
@@ -89,6 +143,52 @@ type Website struct {
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// maskedSecretPrefix starts every masked secret, standing in for the hidden characters
+const maskedSecretPrefix = "••••••••"
+
+// maskSecret hides a secret, keeping the last 4 characters of longer ones so it can still be told apart
+func maskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) <= 8 {
+		return maskedSecretPrefix
+	}
+	return maskedSecretPrefix + secret[len(secret)-4:]
+}
+
+// unmaskSecret returns saved when submitted is saved's masked form, i.e. the field was left as
+// shown, and submitted otherwise
+func unmaskSecret(submitted, saved string) string {
+	if submitted != "" && submitted == maskSecret(saved) {
+		return saved
+	}
+	return submitted
+}
+
+// Redacted returns a copy of the website with its secrets masked, for showing in the admin
+func (w Website) Redacted() Website {
+	for _, field := range []*string{
+		&w.StripeSecretKey,
+		&w.StripeWebhookSecret,
+		&w.ShippoAPIKey,
+		&w.TwilioAuthToken,
+		&w.IMAPPassword,
+		&w.SMTPPassword,
+		&w.ChatWebhookURL,
+		&w.EarlyAccessPassword,
+	} {
+		*field = maskSecret(*field)
+	}
+	return w
+}
+
+// MarshalJSON encodes the website with its secrets masked, so they can't end up in a response or log
+func (w Website) MarshalJSON() ([]byte, error) {
+	type website Website
+	return json.Marshal(website(w.Redacted()))
+}
+
 // Article represents an article/post
 type Article struct {
 	ID            int       `json:"id"`
@@ -100,6 +200,7 @@ type Article struct {
 	Type          string    `json:"type"`
 	Status        string    `json:"status"`
 	ThumbnailID   int       `json:"thumbnailId"`
+	ThumbnailURL  string    `json:"thumbnailUrl"` // Only loaded by GetArticles
 	PublishedDate time.Time `json:"publishedDate"`
 	CreatedAt     time.Time `json:"createdAt"`
 	UpdatedAt     time.Time `json:"updatedAt"`
@@ -107,32 +208,41 @@ type Article struct {
 
 // Product represents an e-commerce product
 type Product struct {
-	ID                int                      `json:"id"`
-	Name              string                   `json:"name"`
-	Slug              string                   `json:"slug"`
-	Description       string                   `json:"description"`
-	Price             float64                  `json:"price"`
-	CompareAtPrice    float64                  `json:"compareAtPrice"`
-	SKU               string                   `json:"sku"`
-	InventoryQuantity int                      `json:"inventoryQuantity"`
-	InventoryPolicy   string                   `json:"inventoryPolicy"`
-	Status            string                   `json:"status"`
-	Featured          bool                     `json:"featured"`
-	SortOrder         int                      `json:"sortOrder"`
-	ReleasedDate      time.Time                `json:"releasedDate"`
-	CreatedAt         time.Time                `json:"createdAt"`
-	UpdatedAt         time.Time                `json:"updatedAt"`
-	Variants          []structs.ProductVariant `json:"variants"`
+	ID                   int                      `json:"id"`
+	Name                 string                   `json:"name"`
+	Slug                 string                   `json:"slug"`
+	Description          string                   `json:"description"`
+	Price                float64                  `json:"price"`
+	CompareAtPrice       float64                  `json:"compareAtPrice"`
+	SKU                  string                   `json:"sku"`
+	InventoryQuantity    int                      `json:"inventoryQuantity"`
+	InventoryPolicy      string                   `json:"inventoryPolicy"`
+	MaxPerOrder          int                      `json:"maxPerOrder"`          // 0 means use the default limit
+	SubscriptionInterval string                   `json:"subscriptionInterval"` // week, month or year; empty for one-time products
+	Status               string                   `json:"status"`
+	Featured             bool                     `json:"featured"`
+	SortOrder            int                      `json:"sortOrder"`
+	PrimaryImageURL      string                   `json:"primaryImageUrl"` // First product image, only loaded by GetProducts with withImage
+	VariantCount         int                      `json:"variantCount"`    // Only set by GetProducts
+	MinPrice             float64                  `json:"minPrice"`        // Lowest variant price, or Price without variants; only set by GetProducts
+	MaxPrice             float64                  `json:"maxPrice"`        // Highest variant price, or Price without variants; only set by GetProducts
+	ReleasedDate         time.Time                `json:"releasedDate"`
+	Preorder             bool                     `json:"preorder"` // sold ahead of ReleasedDate, orders ship from then
+	CreatedAt            time.Time                `json:"createdAt"`
+	UpdatedAt            time.Time                `json:"updatedAt"`
+	Variants             []structs.ProductVariant `json:"variants"`
 }
 
 // Category represents an article category
 type Category struct {
-	ID        int       `json:"id"`
-	Name      string    `json:"name"`
-	Slug      string    `json:"slug"`
-	Count     int       `json:"count"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	Slug       string    `json:"slug"`
+	ParentID   int       `json:"parentId"`
+	ParentName string    `json:"parentName"`
+	Count      int       `json:"count"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
 }
 
 // Collection represents a product collection
@@ -163,33 +273,43 @@ type Image struct {
 
 // Order represents a customer order
 type Order struct {
-	ID                   int       `json:"id"`
-	OrderNumber          string    `json:"orderNumber"`
-	CustomerEmail        string    `json:"customerEmail"`
-	CustomerName         string    `json:"customerName"`
-	ShippingAddressLine1 string    `json:"shippingAddressLine1"`
-	ShippingAddressLine2 string    `json:"shippingAddressLine2"`
-	ShippingCity         string    `json:"shippingCity"`
-	ShippingState        string    `json:"shippingState"`
-	ShippingZip          string    `json:"shippingZip"`
-	ShippingCountry      string    `json:"shippingCountry"`
-	Subtotal             float64   `json:"subtotal"`
-	Tax                  float64   `json:"tax"`
-	ShippingCost         float64   `json:"shippingCost"`
-	Total                float64   `json:"total"`
-	PaymentStatus        string    `json:"paymentStatus"`
-	FulfillmentStatus    string    `json:"fulfillmentStatus"`
-	PaymentMethod        string    `json:"paymentMethod"`
-	StripePaymentIntent  string    `json:"stripePaymentIntent"`
-	RefundedAmount       float64   `json:"refundedAmount"`
-	ShippingLabelCost    *float64  `json:"shippingLabelCost"`
-	TrackingNumber       string    `json:"trackingNumber"`
-	ShippingCarrier      string    `json:"shippingCarrier"`
-	ShippingLabelURL     string    `json:"shippingLabelUrl"`
-	ShippoTransactionID  string    `json:"shippoTransactionId"`
+	ID                   int         `json:"id"`
+	OrderNumber          string      `json:"orderNumber"`
+	CustomerEmail        string      `json:"customerEmail"`
+	CustomerName         string      `json:"customerName"`
+	ShippingAddressLine1 string      `json:"shippingAddressLine1"`
+	ShippingAddressLine2 string      `json:"shippingAddressLine2"`
+	ShippingCity         string      `json:"shippingCity"`
+	ShippingState        string      `json:"shippingState"`
+	ShippingZip          string      `json:"shippingZip"`
+	ShippingCountry      string      `json:"shippingCountry"`
+	Subtotal             float64     `json:"subtotal"`
+	Tax                  float64     `json:"tax"`
+	ShippingCost         float64     `json:"shippingCost"`
+	Total                float64     `json:"total"`
+	StoreCredit          float64     `json:"storeCredit"` // Part of Total paid with store credit, only loaded by GetOrder
+	PaymentStatus        string      `json:"paymentStatus"`
+	FulfillmentStatus    string      `json:"fulfillmentStatus"`
+	PaymentMethod        string      `json:"paymentMethod"`
+	StripePaymentIntent  string      `json:"stripePaymentIntent"`
+	RefundedAmount       float64     `json:"refundedAmount"`
+	ShippingLabelCost    *float64    `json:"shippingLabelCost"`
+	TrackingNumber       string      `json:"trackingNumber"`
+	ShippingCarrier      string      `json:"shippingCarrier"`
+	ShippingLabelURL     string      `json:"shippingLabelUrl"`
+	ShippoTransactionID  string      `json:"shippoTransactionId"`
+	RiskScore            int         `json:"riskScore"`   // Fraud-risk score from order creation
+	RiskReasons          []string    `json:"riskReasons"` // Rules that added to RiskScore, only loaded by GetOrder
+	ShipsOn              *time.Time  `json:"shipsOn"`     // Release date of the order's last preorder item, only loaded by GetOrder
 	Items                []OrderItem `json:"items"`
-	CreatedAt            time.Time `json:"createdAt"`
-	UpdatedAt            time.Time `json:"updatedAt"`
+	CreatedAt            time.Time   `json:"createdAt"`
+	UpdatedAt            time.Time   `json:"updatedAt"`
+}
+
+// AwaitingRelease reports whether the order has preorder items that haven't been released, so it
+// can't ship yet
+func (o Order) AwaitingRelease() bool {
+	return o.ShipsOn != nil && time.Now().Before(*o.ShipsOn)
 }
 
 // OrderItem represents a line item in an order
@@ -229,24 +349,72 @@ type OrderFilters struct {
 
 // CustomerFilters represents filters for customer queries
 type CustomerFilters struct {
-	Sort string // total_desc, total_asc, orders_desc, date_desc, date_asc
+	Sort    string // total_desc, total_asc, orders_desc, date_desc, date_asc
+	Segment string // RFM segment key, empty for all customers
 }
 
 // Customer represents a customer with aggregate statistics
 type Customer struct {
-	ID               int        `json:"id"`
-	Email            string     `json:"email"`
-	StripeCustomerID string     `json:"stripeCustomerId"`
-	FirstName        string     `json:"firstName"`
-	LastName         string     `json:"lastName"`
-	Phone            string     `json:"phone"`
-	CreatedAt        time.Time  `json:"createdAt"`
-	UpdatedAt        time.Time  `json:"updatedAt"`
+	ID               int       `json:"id"`
+	Email            string    `json:"email"`
+	StripeCustomerID string    `json:"stripeCustomerId"`
+	FirstName        string    `json:"firstName"`
+	LastName         string    `json:"lastName"`
+	Phone            string    `json:"phone"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+	StoreCredit      float64   `json:"storeCredit"`
+	StoreCreditCode  string    `json:"-"` // given at checkout to spend StoreCredit, empty until credit is first issued
 	// Aggregate fields
 	OrderCount int        `json:"orderCount"`
 	TotalSpent float64    `json:"totalSpent"`
 	FirstOrder *time.Time `json:"firstOrderDate"`
 	LastOrder  *time.Time `json:"lastOrderDate"`
+
+	RFM *CustomerRFM `json:"rfm,omitempty"` // nil for customers without paid orders
+}
+
+// CustomerSegment is a group of customers with similar RFM scores
+type CustomerSegment struct {
+	Key         string `json:"key"`
+	Label       string `json:"label"`
+	Description string `json:"description"`
+	Color       string `json:"color"`
+}
+
+// customerSegments are the RFM segments, in the order segmentFor checks them
+var customerSegments = []CustomerSegment{
+	{"champions", "Champion", "Bought recently, buy often and spend the most", "#38a169"},
+	{"loyal", "Loyal", "Buy often and have bought lately", "#3182ce"},
+	{"new", "New", "Made their first purchase recently", "#805ad5"},
+	{"promising", "Promising", "Recent buyers who haven't bought often yet", "#319795"},
+	{"at_risk", "At Risk", "Used to buy often but haven't for a while", "#dd6b20"},
+	{"lost", "Lost", "Haven't bought in a long time and rarely did", "#a0aec0"},
+	{"needs_attention", "Needs Attention", "Middling recency, frequency and spend", "#d69e2e"},
+}
+
+// CustomerRFM scores a customer's paid orders by recency, frequency and monetary value, each from 1
+// (worst fifth of customers) to 5 (best fifth), and buckets them into a segment
+type CustomerRFM struct {
+	DaysSinceOrder int             `json:"daysSinceOrder"`
+	Frequency      int             `json:"frequency"`
+	Monetary       float64         `json:"monetary"`
+	RecencyScore   int             `json:"recencyScore"`
+	FrequencyScore int             `json:"frequencyScore"`
+	MonetaryScore  int             `json:"monetaryScore"`
+	Segment        CustomerSegment `json:"segment"`
+}
+
+// Subscription represents a recurring order subscription
+type Subscription struct {
+	ID                   int       `json:"id"`
+	StripeSubscriptionID string    `json:"stripeSubscriptionId"`
+	CustomerEmail        string    `json:"customerEmail"`
+	CustomerName         string    `json:"customerName"`
+	BillingInterval      string    `json:"billingInterval"`
+	Amount               float64   `json:"amount"`
+	Status               string    `json:"status"`
+	CreatedAt            time.Time `json:"createdAt"`
 }
 
 type SMSSignup struct {
@@ -292,9 +460,13 @@ func (s *AdminServer) GetAllWebsites() ([]Website, error) {
 			}
 
 			var config struct {
-				SiteName      string `json:"siteName"`
-				APIVersion    int    `json:"apiVersion"`
-				Timezone      string `json:"timezone"`
+				SiteName   string `json:"siteName"`
+				APIVersion int    `json:"apiVersion"`
+				Timezone   string `json:"timezone"`
+				Locales    struct {
+					Default   string   `json:"default"`
+					Supported []string `json:"supported"`
+				} `json:"locales"`
 				Database struct {
 					Name string `json:"name"`
 				} `json:"database"`
@@ -305,6 +477,7 @@ func (s *AdminServer) GetAllWebsites() ([]Website, error) {
 				Stripe struct {
 					PublishableKey string `json:"publishableKey"`
 					SecretKey      string `json:"secretKey"`
+					WebhookSecret  string `json:"webhookSecret"`
 				} `json:"stripe"`
 				Shippo struct {
 					APIKey      string `json:"apiKey"`
@@ -335,13 +508,42 @@ func (s *AdminServer) GetAllWebsites() ([]Website, error) {
 					} `json:"smtp"`
 				} `json:"email"`
 				Ecommerce struct {
-					TaxRate      float64 `json:"taxRate"`
-					ShippingCost float64 `json:"shippingCost"`
+					TaxRate           float64 `json:"taxRate"`
+					TaxInclusive      bool    `json:"taxInclusive"`
+					TaxRounding       string  `json:"taxRounding"`
+					ShippingCost      float64 `json:"shippingCost"`
+					MinOrderAmount    float64 `json:"minOrderAmount"`
+					OrderNumberPrefix string  `json:"orderNumberPrefix"`
+					Currency          string  `json:"currency"`
+					ManualCapture     bool    `json:"manualCapture"`
+					StaleOrderDays    int     `json:"staleOrderDays"`
+					RestockReturns    bool    `json:"restockReturns"`
+					Risk              struct {
+						ReviewScore int `json:"reviewScore"`
+					} `json:"risk"`
+					OrderDigest struct {
+						Enabled       bool `json:"enabled"`
+						IntervalHours int  `json:"intervalHours"`
+					} `json:"orderDigest"`
+					Goals struct {
+						MonthlyRevenue float64 `json:"monthlyRevenue"`
+						MonthlyOrders  int     `json:"monthlyOrders"`
+					} `json:"goals"`
 				} `json:"ecommerce"`
+				Notifications struct {
+					ChatWebhookURL    string `json:"chatWebhookURL"`
+					LowStock          bool   `json:"lowStock"`
+					LowStockThreshold int    `json:"lowStockThreshold"`
+				} `json:"notifications"`
 				EarlyAccess struct {
 					Enabled  bool   `json:"enabled"`
 					Password string `json:"password"`
 				} `json:"earlyAccess"`
+				Maintenance struct {
+					Enabled    bool   `json:"enabled"`
+					Message    string `json:"message"`
+					RetryAfter int    `json:"retryAfter"`
+				} `json:"maintenance"`
 				ShipFrom struct {
 					Name    string `json:"name"`
 					Street1 string `json:"street1"`
@@ -359,6 +561,19 @@ func (s *AdminServer) GetAllWebsites() ([]Website, error) {
 				return nil // Skip invalid JSON
 			}
 
+			// Decrypt secrets stored encrypted
+			if err := configs.DecryptSecretFields(
+				&config.Stripe.SecretKey,
+				&config.Stripe.WebhookSecret,
+				&config.Shippo.APIKey,
+				&config.Twilio.AuthToken,
+				&config.Email.IMAP.Password,
+				&config.Email.SMTP.Password,
+				&config.Notifications.ChatWebhookURL,
+			); err != nil {
+				log.Printf("Warning: secrets in %s could not be decrypted: %v", path, err)
+			}
+
 			// Default timezone to PST if not set
 			if config.Timezone == "" {
 				config.Timezone = "America/Los_Angeles"
@@ -378,8 +593,12 @@ func (s *AdminServer) GetAllWebsites() ([]Website, error) {
 				APIVersion:    config.APIVersion,
 				Timezone:      config.Timezone,
 
+				DefaultLocale: config.Locales.Default,
+				Locales:       config.Locales.Supported,
+
 				StripePublishableKey: config.Stripe.PublishableKey,
 				StripeSecretKey:      config.Stripe.SecretKey,
+				StripeWebhookSecret:  config.Stripe.WebhookSecret,
 
 				ShippoAPIKey: config.Shippo.APIKey,
 				LabelFormat:  config.Shippo.LabelFormat,
@@ -404,12 +623,35 @@ func (s *AdminServer) GetAllWebsites() ([]Website, error) {
 				SMTPPassword: config.Email.SMTP.Password,
 				SMTPUseTLS:   config.Email.SMTP.UseTLS,
 
-				TaxRate:      config.Ecommerce.TaxRate,
-				ShippingCost: config.Ecommerce.ShippingCost,
+				TaxRate:           config.Ecommerce.TaxRate,
+				TaxInclusive:      config.Ecommerce.TaxInclusive,
+				TaxRounding:       config.Ecommerce.TaxRounding,
+				ShippingCost:      config.Ecommerce.ShippingCost,
+				MinOrderAmount:    config.Ecommerce.MinOrderAmount,
+				OrderNumberPrefix: config.Ecommerce.OrderNumberPrefix,
+				Currency:          config.Ecommerce.Currency,
+				ManualCapture:     config.Ecommerce.ManualCapture,
+				StaleOrderDays:    config.Ecommerce.StaleOrderDays,
+				RestockReturns:    config.Ecommerce.RestockReturns,
+				RiskReviewScore:   config.Ecommerce.Risk.ReviewScore,
+
+				OrderDigestEnabled:       config.Ecommerce.OrderDigest.Enabled,
+				OrderDigestIntervalHours: config.Ecommerce.OrderDigest.IntervalHours,
+
+				GoalMonthlyRevenue: config.Ecommerce.Goals.MonthlyRevenue,
+				GoalMonthlyOrders:  config.Ecommerce.Goals.MonthlyOrders,
+
+				ChatWebhookURL:        config.Notifications.ChatWebhookURL,
+				ChatLowStock:          config.Notifications.LowStock,
+				ChatLowStockThreshold: config.Notifications.LowStockThreshold,
 
 				EarlyAccessEnabled:  config.EarlyAccess.Enabled,
 				EarlyAccessPassword: config.EarlyAccess.Password,
 
+				MaintenanceEnabled:    config.Maintenance.Enabled,
+				MaintenanceMessage:    config.Maintenance.Message,
+				MaintenanceRetryAfter: config.Maintenance.RetryAfter,
+
 				ShipFromName:    config.ShipFrom.Name,
 				ShipFromStreet1: config.ShipFrom.Street1,
 				ShipFromStreet2: config.ShipFrom.Street2,
@@ -435,8 +677,24 @@ func (s *AdminServer) GetAllWebsites() ([]Website, error) {
 	return websites, nil
 }
 
+// validDatabaseName matches the database names, and so website IDs, the admin will use. Names end up
+// in connection strings, so anything else is rejected before it gets near one
+var validDatabaseName = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// checkDatabaseName returns an error unless name is safe to use as a database name
+func checkDatabaseName(name string) error {
+	if !validDatabaseName.MatchString(name) {
+		return fmt.Errorf("invalid database name %q: only letters, numbers and underscores are allowed", name)
+	}
+	return nil
+}
+
 // GetWebsite retrieves a single website by ID (which is the database name)
 func (s *AdminServer) GetWebsite(id string) (Website, error) {
+	if err := checkDatabaseName(id); err != nil {
+		return Website{}, err
+	}
+
 	websites, err := s.GetAllWebsites()
 	if err != nil {
 		return Website{}, err
@@ -464,18 +722,54 @@ func (s *AdminServer) CreateWebsite(w Website) (int64, error) {
 	return int64(len(websites) + 1), nil
 }
 
-// UpdateWebsite updates an existing website config file
-func (s *AdminServer) UpdateWebsite(w Website) error {
-	// Determine config file name
+// createWebsiteFiles creates a website's directory structure under websites/ and writes its config file
+func createWebsiteFiles(directory, configName string, config map[string]interface{}) error {
+	websiteDir := filepath.Join("websites", directory)
+	for _, dir := range []string{"templates", "public", "sitemaps"} {
+		if err := os.MkdirAll(filepath.Join(websiteDir, dir), 0755); err != nil {
+			return fmt.Errorf("creating directory: %v", err)
+		}
+	}
+
+	configData, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(websiteDir, configName), configData, 0644); err != nil {
+		return fmt.Errorf("creating config file: %v", err)
+	}
+
+	return nil
+}
+
+// DuplicateWebsite starts a new website from an existing one: its templates and public files
+// (without uploads) are copied and its config is reused with the new name, directory, database and
+// address, and with secrets blanked. The database isn't copied
+func (s *AdminServer) DuplicateWebsite(sourceID string, w Website) error {
+	source, err := s.GetWebsite(sourceID)
+	if err != nil {
+		return err
+	}
+
+	if err := checkDatabaseName(w.DatabaseName); err != nil {
+		return err
+	}
+	if w.Directory == "" || w.Directory != filepath.Base(w.Directory) || strings.HasPrefix(w.Directory, ".") {
+		return fmt.Errorf("invalid directory %q", w.Directory)
+	}
+	if _, err := os.Stat(filepath.Join("websites", w.Directory)); err == nil {
+		return fmt.Errorf("directory %q already exists", w.Directory)
+	}
+	if _, err := s.GetWebsite(w.DatabaseName); err == nil {
+		return fmt.Errorf("a website already uses database %q", w.DatabaseName)
+	}
+
 	configName := "config-dev.json"
 	if s.EnvConfig.ProdMode {
 		configName = "config-prod.json"
 	}
 
-	configPath := filepath.Join("websites", w.Directory, configName)
-
-	// Read existing config
-	data, err := ioutil.ReadFile(configPath)
+	data, err := ioutil.ReadFile(filepath.Join("websites", source.Directory, configName))
 	if err != nil {
 		return err
 	}
@@ -485,121 +779,269 @@ func (s *AdminServer) UpdateWebsite(w Website) error {
 		return err
 	}
 
-	// Update fields
+	redactSecrets(config, "")
 	config["siteName"] = w.SiteName
-	config["apiVersion"] = w.APIVersion
-	config["timezone"] = w.Timezone
+	config["database"] = map[string]interface{}{"name": w.DatabaseName}
+	httpConfig, _ := config["http"].(map[string]interface{})
+	if httpConfig == nil {
+		httpConfig = map[string]interface{}{}
+	}
+	httpConfig["address"] = w.HTTPAddress
+	config["http"] = httpConfig
+	config["mediaProxyUrl"] = w.MediaProxyURL
+
+	if err := createWebsiteFiles(w.Directory, configName, config); err != nil {
+		return err
+	}
+
+	sourceDir := filepath.Join("websites", source.Directory)
+	targetDir := filepath.Join("websites", w.Directory)
+	for _, dir := range []string{"templates", "public"} {
+		if err := copyTree(filepath.Join(sourceDir, dir), filepath.Join(targetDir, dir), filepath.Join(sourceDir, "public", "uploads")); err != nil {
+			return fmt.Errorf("copying %s: %v", dir, err)
+		}
+	}
+
+	return nil
+}
+
+// copyTree copies the files under src to dst, skipping the skip directory
+func copyTree(src, dst, skip string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == src {
+				return nil
+			}
+			return err
+		}
+		if path == skip {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// UpdateWebsite updates an existing website config file. Only the fields the settings form edits
+// are touched; any other keys in the file, including ones this version doesn't know about, are kept
+func (s *AdminServer) UpdateWebsite(w Website) error {
+	// Determine config file name
+	configName := "config-dev.json"
+	if s.EnvConfig.ProdMode {
+		configName = "config-prod.json"
+	}
+
+	configPath := filepath.Join("websites", w.Directory, configName)
 
-	if config["database"] == nil {
-		config["database"] = make(map[string]interface{})
+	// Read existing config
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return err
 	}
-	config["database"].(map[string]interface{})["name"] = w.DatabaseName
 
-	if config["http"] == nil {
-		config["http"] = make(map[string]interface{})
+	// UseNumber keeps numbers we don't touch exactly as written instead of round-tripping them
+	// through float64
+	var config map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&config); err != nil {
+		return fmt.Errorf("error reading %s: %v", configPath, err)
 	}
-	config["http"].(map[string]interface{})["address"] = w.HTTPAddress
+	if config == nil {
+		config = make(map[string]interface{})
+	}
+
+	// Update fields
+	setConfigValue(config, w.SiteName, "siteName")
+	setConfigValue(config, w.APIVersion, "apiVersion")
+	setConfigValue(config, w.Timezone, "timezone")
+	setConfigValue(config, w.DefaultLocale, "locales", "default")
+	setConfigValue(config, w.Locales, "locales", "supported")
+	setConfigValue(config, w.DatabaseName, "database", "name")
+	setConfigValue(config, w.HTTPAddress, "http", "address")
 
 	if w.MediaProxyURL != "" {
-		config["mediaProxyUrl"] = w.MediaProxyURL
+		setConfigValue(config, w.MediaProxyURL, "mediaProxyUrl")
 	}
 
 	// Stripe
-	if config["stripe"] == nil {
-		config["stripe"] = make(map[string]interface{})
-	}
-	config["stripe"].(map[string]interface{})["publishableKey"] = w.StripePublishableKey
-	config["stripe"].(map[string]interface{})["secretKey"] = w.StripeSecretKey
+	setConfigValue(config, w.StripePublishableKey, "stripe", "publishableKey")
+	setConfigValue(config, w.StripeSecretKey, "stripe", "secretKey")
+	setConfigValue(config, w.StripeWebhookSecret, "stripe", "webhookSecret")
 
 	// Shippo
-	if config["shippo"] == nil {
-		config["shippo"] = make(map[string]interface{})
-	}
-	config["shippo"].(map[string]interface{})["apiKey"] = w.ShippoAPIKey
+	setConfigValue(config, w.ShippoAPIKey, "shippo", "apiKey")
 	if w.LabelFormat != "" {
-		config["shippo"].(map[string]interface{})["labelFormat"] = w.LabelFormat
+		setConfigValue(config, w.LabelFormat, "shippo", "labelFormat")
 	}
 
 	// Twilio
-	if config["twilio"] == nil {
-		config["twilio"] = make(map[string]interface{})
-	}
-	config["twilio"].(map[string]interface{})["accountSid"] = w.TwilioAccountSID
-	config["twilio"].(map[string]interface{})["authToken"] = w.TwilioAuthToken
-	config["twilio"].(map[string]interface{})["fromPhone"] = w.TwilioFromPhone
+	setConfigValue(config, w.TwilioAccountSID, "twilio", "accountSid")
+	setConfigValue(config, w.TwilioAuthToken, "twilio", "authToken")
+	setConfigValue(config, w.TwilioFromPhone, "twilio", "fromPhone")
 
 	// Email
-	if config["email"] == nil {
-		config["email"] = make(map[string]interface{})
-	}
-	config["email"].(map[string]interface{})["fromAddress"] = w.EmailFromAddress
-	config["email"].(map[string]interface{})["fromName"] = w.EmailFromName
-	config["email"].(map[string]interface{})["replyTo"] = w.EmailReplyTo
+	setConfigValue(config, w.EmailFromAddress, "email", "fromAddress")
+	setConfigValue(config, w.EmailFromName, "email", "fromName")
+	setConfigValue(config, w.EmailReplyTo, "email", "replyTo")
 
 	// IMAP
-	emailMap := config["email"].(map[string]interface{})
-	if emailMap["imap"] == nil {
-		emailMap["imap"] = make(map[string]interface{})
-	}
-	emailMap["imap"].(map[string]interface{})["server"] = w.IMAPServer
-	emailMap["imap"].(map[string]interface{})["port"] = w.IMAPPort
-	emailMap["imap"].(map[string]interface{})["username"] = w.IMAPUsername
-	emailMap["imap"].(map[string]interface{})["password"] = w.IMAPPassword
-	emailMap["imap"].(map[string]interface{})["useTLS"] = w.IMAPUseTLS
+	setConfigValue(config, w.IMAPServer, "email", "imap", "server")
+	setConfigValue(config, w.IMAPPort, "email", "imap", "port")
+	setConfigValue(config, w.IMAPUsername, "email", "imap", "username")
+	setConfigValue(config, w.IMAPPassword, "email", "imap", "password")
+	setConfigValue(config, w.IMAPUseTLS, "email", "imap", "useTLS")
 
 	// SMTP
-	if emailMap["smtp"] == nil {
-		emailMap["smtp"] = make(map[string]interface{})
-	}
-	emailMap["smtp"].(map[string]interface{})["server"] = w.SMTPServer
-	emailMap["smtp"].(map[string]interface{})["port"] = w.SMTPPort
-	emailMap["smtp"].(map[string]interface{})["username"] = w.SMTPUsername
-	emailMap["smtp"].(map[string]interface{})["password"] = w.SMTPPassword
-	emailMap["smtp"].(map[string]interface{})["useTLS"] = w.SMTPUseTLS
+	setConfigValue(config, w.SMTPServer, "email", "smtp", "server")
+	setConfigValue(config, w.SMTPPort, "email", "smtp", "port")
+	setConfigValue(config, w.SMTPUsername, "email", "smtp", "username")
+	setConfigValue(config, w.SMTPPassword, "email", "smtp", "password")
+	setConfigValue(config, w.SMTPUseTLS, "email", "smtp", "useTLS")
 
 	// Ecommerce
-	if config["ecommerce"] == nil {
-		config["ecommerce"] = make(map[string]interface{})
-	}
-	config["ecommerce"].(map[string]interface{})["taxRate"] = w.TaxRate
-	config["ecommerce"].(map[string]interface{})["shippingCost"] = w.ShippingCost
+	setConfigValue(config, w.TaxRate, "ecommerce", "taxRate")
+	setConfigValue(config, w.TaxInclusive, "ecommerce", "taxInclusive")
+	setConfigValue(config, w.TaxRounding, "ecommerce", "taxRounding")
+	setConfigValue(config, w.ShippingCost, "ecommerce", "shippingCost")
+	setConfigValue(config, w.MinOrderAmount, "ecommerce", "minOrderAmount")
+	setConfigValue(config, w.OrderNumberPrefix, "ecommerce", "orderNumberPrefix")
+	setConfigValue(config, w.Currency, "ecommerce", "currency")
+	setConfigValue(config, w.ManualCapture, "ecommerce", "manualCapture")
+	setConfigValue(config, w.StaleOrderDays, "ecommerce", "staleOrderDays")
+	setConfigValue(config, w.RestockReturns, "ecommerce", "restockReturns")
+	setConfigValue(config, w.OrderDigestEnabled, "ecommerce", "orderDigest", "enabled")
+	setConfigValue(config, w.OrderDigestIntervalHours, "ecommerce", "orderDigest", "intervalHours")
+	setConfigValue(config, w.GoalMonthlyRevenue, "ecommerce", "goals", "monthlyRevenue")
+	setConfigValue(config, w.GoalMonthlyOrders, "ecommerce", "goals", "monthlyOrders")
+
+	// Chat notifications
+	setConfigValue(config, w.ChatWebhookURL, "notifications", "chatWebhookURL")
+	setConfigValue(config, w.ChatLowStock, "notifications", "lowStock")
+	setConfigValue(config, w.ChatLowStockThreshold, "notifications", "lowStockThreshold")
 
 	// Early Access
-	if config["earlyAccess"] == nil {
-		config["earlyAccess"] = make(map[string]interface{})
-	}
-	config["earlyAccess"].(map[string]interface{})["enabled"] = w.EarlyAccessEnabled
-	config["earlyAccess"].(map[string]interface{})["password"] = w.EarlyAccessPassword
+	setConfigValue(config, w.EarlyAccessEnabled, "earlyAccess", "enabled")
+	setConfigValue(config, w.EarlyAccessPassword, "earlyAccess", "password")
+
+	// Maintenance
+	setConfigValue(config, w.MaintenanceEnabled, "maintenance", "enabled")
+	setConfigValue(config, w.MaintenanceMessage, "maintenance", "message")
+	setConfigValue(config, w.MaintenanceRetryAfter, "maintenance", "retryAfter")
 
 	// ShipFrom
-	if config["shipFrom"] == nil {
-		config["shipFrom"] = make(map[string]interface{})
-	}
-	config["shipFrom"].(map[string]interface{})["name"] = w.ShipFromName
-	config["shipFrom"].(map[string]interface{})["street1"] = w.ShipFromStreet1
-	config["shipFrom"].(map[string]interface{})["street2"] = w.ShipFromStreet2
-	config["shipFrom"].(map[string]interface{})["city"] = w.ShipFromCity
-	config["shipFrom"].(map[string]interface{})["state"] = w.ShipFromState
-	config["shipFrom"].(map[string]interface{})["zip"] = w.ShipFromZip
-	config["shipFrom"].(map[string]interface{})["country"] = w.ShipFromCountry
+	setConfigValue(config, w.ShipFromName, "shipFrom", "name")
+	setConfigValue(config, w.ShipFromStreet1, "shipFrom", "street1")
+	setConfigValue(config, w.ShipFromStreet2, "shipFrom", "street2")
+	setConfigValue(config, w.ShipFromCity, "shipFrom", "city")
+	setConfigValue(config, w.ShipFromState, "shipFrom", "state")
+	setConfigValue(config, w.ShipFromZip, "shipFrom", "zip")
+	setConfigValue(config, w.ShipFromCountry, "shipFrom", "country")
 
 	// robots.txt
 	if w.RobotsTxt != "" {
-		config["robotsTxt"] = w.RobotsTxt
+		setConfigValue(config, w.RobotsTxt, "robotsTxt")
 	}
 
 	// Logo
 	if w.Logo != "" {
-		config["logo"] = w.Logo
+		setConfigValue(config, w.Logo, "logo")
+	}
+
+	// Secrets are encrypted when a master key is set
+	if err := configs.EncryptConfigSecrets(config); err != nil {
+		return err
 	}
 
 	// Write back to disk
-	updatedData, err := json.MarshalIndent(config, "", "\t")
+	updatedData, err := marshalConfig(config)
 	if err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(configPath, updatedData, 0644)
+	return writeFileAtomic(configPath, updatedData, 0644)
+}
+
+// setConfigValue sets value at the nested key path in config, creating objects along the way. A
+// value on the path that isn't an object (say "email": "" from a hand-edited file) is replaced
+// rather than asserted on
+func setConfigValue(config map[string]interface{}, value interface{}, path ...string) {
+	section := config
+	for _, key := range path[:len(path)-1] {
+		child, ok := section[key].(map[string]interface{})
+		if !ok {
+			if section[key] != nil {
+				log.Printf("Warning: replacing config value %q of type %T with an object", key, section[key])
+			}
+			child = make(map[string]interface{})
+			section[key] = child
+		}
+		section = child
+	}
+	section[path[len(path)-1]] = value
+}
+
+// marshalConfig encodes a site config the way it's written to disk. Map keys come out sorted, so
+// saving the same settings twice gives the same file, and characters like & in URLs and robots.txt
+// aren't escaped
+func marshalConfig(config map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "\t")
+	if err := encoder.Encode(config); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it into place, so a failed
+// write can't leave a half-written config behind
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
 
 // DeleteWebsite deletes a website directory
@@ -613,27 +1055,61 @@ func (s *AdminServer) DeleteWebsite(id string) error {
 	return os.RemoveAll(websiteDir)
 }
 
-// GetWebsiteConnection gets a database connection for a specific website by ID (database name)
-func (s *AdminServer) GetWebsiteConnection(websiteID string) (*sql.DB, error) {
+// GetWebsiteConnection gets a database connection for a specific website by ID (database name).
+// Its queries are logged when they pass the slow query threshold
+func (s *AdminServer) GetWebsiteConnection(websiteID string) (*database.TimedDB, error) {
 	website, err := s.GetWebsite(websiteID)
 	if err != nil {
 		return nil, err
 	}
 
-	connectionString := s.EnvConfig.Database.User + ":" + s.EnvConfig.Database.Password +
-		"@tcp(" + s.EnvConfig.Database.Host + ":" + s.EnvConfig.Database.Port + ")/" +
-		website.DatabaseName + "?parseTime=true"
+	// The ID was checked, but the name comes from the site's config file
+	if err := checkDatabaseName(website.DatabaseName); err != nil {
+		return nil, err
+	}
+
+	return openWebsiteDB(s.EnvConfig.Database.User, s.EnvConfig.Database.Password,
+		s.EnvConfig.Database.Host, s.EnvConfig.Database.Port, website.DatabaseName)
+}
+
+// GetWebsiteReadConnection gets a connection for a website's analytics reports, on the read
+// replica when one is configured and on the primary otherwise. Replicas lag a little, so use it
+// only for read-only reports, never for anything that's written or shown straight after a write
+func (s *AdminServer) GetWebsiteReadConnection(websiteID string) (*database.TimedDB, error) {
+	host, port, user, password, ok := s.EnvConfig.ReadReplica()
+	if !ok {
+		return s.GetWebsiteConnection(websiteID)
+	}
+
+	website, err := s.GetWebsite(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDatabaseName(website.DatabaseName); err != nil {
+		return nil, err
+	}
+
+	return openWebsiteDB(user, password, host, port, website.DatabaseName)
+}
+
+// openWebsiteDB opens a timed connection to a website's database
+func openWebsiteDB(user, password, host, port, dbName string) (*database.TimedDB, error) {
+	connectionString := user + ":" + password + "@tcp(" + host + ":" + port + ")/" + dbName + "?parseTime=true"
 
 	db, err := sql.Open("mysql", connectionString)
 	if err != nil {
 		return nil, err
 	}
 
-	return db, nil
+	return database.NewTimedDB(db, dbName), nil
 }
 
 // GetWebsiteConnectionByDB gets a database connection for a specific website by database name
 func (s *AdminServer) GetWebsiteConnectionByDB(dbName string) (*sql.DB, error) {
+	if err := checkDatabaseName(dbName); err != nil {
+		return nil, err
+	}
+
 	connectionString := s.EnvConfig.Database.User + ":" + s.EnvConfig.Database.Password +
 		"@tcp(" + s.EnvConfig.Database.Host + ":" + s.EnvConfig.Database.Port + ")/" +
 		dbName + "?parseTime=true"
@@ -663,23 +1139,31 @@ func timezoneToOffset(tz string) string {
 	return "-08:00" // Default to PST
 }
 
-// GetAnalyticsTimeSeries gets daily pageviews, unique visitors, and revenue for charting
-func (s *AdminServer) GetAnalyticsTimeSeries(websiteID string, startDate, endDate time.Time, timezone string) ([]map[string]interface{}, error) {
-	db, err := s.GetWebsiteConnection(websiteID)
-	if err != nil {
-		return nil, err
-	}
-	defer db.Close()
+// dailyTraffic is one day of the traffic time series
+type dailyTraffic struct {
+	Pageviews int
+	Visitors  int
+	Sessions  int
+	Revenue   float64
+}
 
-	// Default to PST if no timezone specified
-	if timezone == "" {
-		timezone = "America/Los_Angeles"
-	}
+// rollupRefreshDays is how many recent days each rollup pass recomputes, so late changes like an
+// order being paid the next day are picked up
+const rollupRefreshDays = 3
 
-	// Convert timezone name to UTC offset for MySQL compatibility
-	offset := timezoneToOffset(timezone)
+// offsetLocation returns a fixed time zone for a "-08:00" style UTC offset
+func offsetLocation(offset string) *time.Location {
+	t, err := time.Parse("-07:00", offset)
+	if err != nil {
+		return time.UTC
+	}
+	return t.Location()
+}
 
-	// Build query with dynamic timezone conversion
+// queryDailyTraffic computes daily pageviews, visitors, sessions and paid revenue live from
+// analytics_pageviews and orders, keyed by date in the given UTC offset. Days without any data are
+// left out
+func queryDailyTraffic(ctx context.Context, db *database.TimedDB, startDate, endDate time.Time, offset string, includeBots bool) (map[string]dailyTraffic, error) {
 	// Convert UTC timestamps to user's timezone before extracting dates
 	query := fmt.Sprintf(`
 		SELECT
@@ -692,6 +1176,7 @@ func (s *AdminServer) GetAnalyticsTimeSeries(websiteID string, startDate, endDat
 			SELECT DISTINCT DATE(CONVERT_TZ(created_at, '+00:00', '%s')) as date
 			FROM analytics_pageviews
 			WHERE DATE(CONVERT_TZ(created_at, '+00:00', '%s')) BETWEEN DATE(?) AND DATE(?)
+			AND (? OR is_bot = 0)
 			UNION
 			SELECT DISTINCT DATE(CONVERT_TZ(created_at, '+00:00', '%s')) as date
 			FROM orders
@@ -705,6 +1190,7 @@ func (s *AdminServer) GetAnalyticsTimeSeries(websiteID string, startDate, endDat
 				COUNT(DISTINCT session_id) as sessions
 			FROM analytics_pageviews
 			WHERE DATE(CONVERT_TZ(created_at, '+00:00', '%s')) BETWEEN DATE(?) AND DATE(?)
+			AND (? OR is_bot = 0)
 			GROUP BY DATE(CONVERT_TZ(created_at, '+00:00', '%s'))
 		) a ON dates.date = a.date
 		LEFT JOIN (
@@ -719,54 +1205,91 @@ func (s *AdminServer) GetAnalyticsTimeSeries(websiteID string, startDate, endDat
 		ORDER BY dates.date ASC
 	`, offset, offset, offset, offset, offset, offset, offset, offset, offset, offset)
 
-	rows, err := db.Query(query, startDate, endDate, startDate, endDate, startDate, endDate, startDate, endDate)
+	rows, err := db.QueryContext(ctx, query, startDate, endDate, includeBots, startDate, endDate, startDate, endDate, includeBots, startDate, endDate)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	// Create a map of actual data indexed by date
-	dataMap := make(map[string]map[string]interface{})
+	days := make(map[string]dailyTraffic)
 	for rows.Next() {
 		var date string
-		var pageviews, visitors, sessions int
-		var revenue float64
-		err := rows.Scan(&date, &pageviews, &visitors, &sessions, &revenue)
+		var day dailyTraffic
+		err := rows.Scan(&date, &day.Pageviews, &day.Visitors, &day.Sessions, &day.Revenue)
 		if err != nil {
 			continue
 		}
-		dataMap[date] = map[string]interface{}{
-			"date":      date,
-			"pageviews": pageviews,
-			"visitors":  visitors,
-			"sessions":  sessions,
-			"revenue":   revenue,
-		}
+		days[date] = day
 	}
 
-	// Generate complete date range with zeros for missing days
+	return days, nil
+}
+
+// GetAnalyticsTimeSeries gets daily pageviews, unique visitors, and revenue for charting. Complete
+// days are read from the analytics_daily rollup; today, and anything from the first day that
+// hasn't been rolled up yet, is queried live. With compare, each day also carries the same day of
+// the equally long period just before the range, under previous_date, previous_pageviews and so on
+func (s *AdminServer) GetAnalyticsTimeSeries(ctx context.Context, websiteID string, startDate, endDate time.Time, timezone string, includeBots, compare bool) ([]map[string]interface{}, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// Default to PST if no timezone specified
+	if timezone == "" {
+		timezone = "America/Los_Angeles"
+	}
+
+	// Convert timezone name to UTC offset for MySQL compatibility
+	offset := timezoneToOffset(timezone)
+
 	// Normalize to midnight for clean date iteration
 	start := time.Date(startDate.Year(), startDate.Month(), startDate.Day(), 0, 0, 0, 0, startDate.Location())
 	end := time.Date(endDate.Year(), endDate.Month(), endDate.Day(), 0, 0, 0, 0, endDate.Location())
 
+	dataMap, err := dailyTrafficSeries(ctx, db, start, endDate, offset, includeBots)
+	if err != nil {
+		return nil, err
+	}
+
+	// The previous period has as many calendar days and ends the day before start
+	days := 0
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		days++
+	}
+	previousStart := start.AddDate(0, 0, -days)
+	var previousMap map[string]dailyTraffic
+	if compare {
+		previousMap, err = dailyTrafficSeries(ctx, db, previousStart, start.Add(-time.Second), offset, includeBots)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Generate complete date range with zeros for missing days
 	var results []map[string]interface{}
 	currentDate := start
-	for !currentDate.After(end) {
+	for i := 0; !currentDate.After(end); i++ {
 		dateStr := currentDate.Format("2006-01-02")
-
-		if data, exists := dataMap[dateStr]; exists {
-			// Use actual data
-			results = append(results, data)
-		} else {
-			// Fill with zeros
-			results = append(results, map[string]interface{}{
-				"date":      dateStr,
-				"pageviews": 0,
-				"visitors":  0,
-				"sessions":  0,
-				"revenue":   0.0,
-			})
+		day := dataMap[dateStr]
+		result := map[string]interface{}{
+			"date":      dateStr,
+			"pageviews": day.Pageviews,
+			"visitors":  day.Visitors,
+			"sessions":  day.Sessions,
+			"revenue":   day.Revenue,
 		}
+		if compare {
+			previousDate := previousStart.AddDate(0, 0, i).Format("2006-01-02")
+			previous := previousMap[previousDate]
+			result["previous_date"] = previousDate
+			result["previous_pageviews"] = previous.Pageviews
+			result["previous_visitors"] = previous.Visitors
+			result["previous_sessions"] = previous.Sessions
+			result["previous_revenue"] = previous.Revenue
+		}
+		results = append(results, result)
 
 		currentDate = currentDate.AddDate(0, 0, 1)
 	}
@@ -774,13 +1297,175 @@ func (s *AdminServer) GetAnalyticsTimeSeries(websiteID string, startDate, endDat
 	return results, nil
 }
 
-// GetEngagementTimeSeries gets daily order count, avg pages per visit, and avg time on site
-func (s *AdminServer) GetEngagementTimeSeries(websiteID string, startDate, endDate time.Time, timezone string) ([]map[string]interface{}, error) {
-	db, err := s.GetWebsiteConnection(websiteID)
+// dailyTrafficSeries gets the traffic for each day from start (midnight) through endDate, keyed by
+// date. Rolled-up days come from analytics_daily and the rest are queried live
+func dailyTrafficSeries(ctx context.Context, db *database.TimedDB, start, endDate time.Time, offset string, includeBots bool) (map[string]dailyTraffic, error) {
+	end := time.Date(endDate.Year(), endDate.Month(), endDate.Day(), 0, 0, 0, 0, endDate.Location())
+
+	// Bot traffic is rolled up separately so the toggle works on rolled-up days too
+	columns := "pageviews, visitors, sessions"
+	if includeBots {
+		columns = "all_pageviews, all_visitors, all_sessions"
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT DATE_FORMAT(date, '%Y-%m-%d'), `+columns+`, revenue
+		FROM analytics_daily
+		WHERE utc_offset = ? AND date BETWEEN ? AND ?
+	`, offset, start.Format("2006-01-02"), end.Format("2006-01-02"))
 	if err != nil {
 		return nil, err
 	}
-	defer db.Close()
+	dataMap := make(map[string]dailyTraffic)
+	for rows.Next() {
+		var date string
+		var day dailyTraffic
+		if err := rows.Scan(&date, &day.Pageviews, &day.Visitors, &day.Sessions, &day.Revenue); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		dataMap[date] = day
+	}
+	rows.Close()
+
+	today := time.Now().In(offsetLocation(offset)).Format("2006-01-02")
+	liveFrom := start
+	for liveFrom.Format("2006-01-02") < today {
+		if _, ok := dataMap[liveFrom.Format("2006-01-02")]; !ok {
+			break
+		}
+		liveFrom = liveFrom.AddDate(0, 0, 1)
+	}
+	if !liveFrom.After(endDate) {
+		live, err := queryDailyTraffic(ctx, db, liveFrom, endDate, offset, includeBots)
+		if err != nil {
+			return nil, err
+		}
+		for currentDate := liveFrom; !currentDate.After(end); currentDate = currentDate.AddDate(0, 0, 1) {
+			delete(dataMap, currentDate.Format("2006-01-02"))
+		}
+		for date, day := range live {
+			dataMap[date] = day
+		}
+	}
+
+	return dataMap, nil
+}
+
+// rollupDailyAnalytics writes analytics_daily rows for one UTC offset, with and without bot traffic,
+// for every day from `from` through yesterday. Quiet days get a row of zeros so they aren't queried
+// live again
+func rollupDailyAnalytics(ctx context.Context, db *database.TimedDB, offset string, from time.Time) error {
+	loc := offsetLocation(offset)
+	now := time.Now().In(loc)
+	from = from.In(loc)
+	start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	end := today.Add(-time.Second)
+	if !start.Before(today) {
+		return nil
+	}
+
+	humans, err := queryDailyTraffic(ctx, db, start, end, offset, false)
+	if err != nil {
+		return err
+	}
+	all, err := queryDailyTraffic(ctx, db, start, end, offset, true)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for day := start; day.Before(today); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		h, a := humans[date], all[date]
+		_, err := tx.ExecContext(ctx, `
+			REPLACE INTO analytics_daily
+			(date, utc_offset, pageviews, visitors, sessions, all_pageviews, all_visitors, all_sessions, revenue)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, date, offset, h.Pageviews, h.Visitors, h.Sessions, a.Pageviews, a.Visitors, a.Sessions, a.Revenue)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RollupDailyAnalytics brings a site's analytics_daily rollup up to date: it recomputes the last
+// few rolled-up days and everything after them, or every day since the first pageview or order
+// when the site hasn't been rolled up yet. Only complete days are rolled up
+func (s *AdminServer) RollupDailyAnalytics(ctx context.Context, websiteID, timezone string) error {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return updateDailyAnalytics(ctx, db, timezone)
+}
+
+// updateDailyAnalytics is RollupDailyAnalytics on an open connection
+func updateDailyAnalytics(ctx context.Context, db *database.TimedDB, timezone string) error {
+	offset := timezoneToOffset(timezone)
+
+	var latest sql.NullString
+	err := db.QueryRowContext(ctx, `SELECT DATE_FORMAT(MAX(date), '%Y-%m-%d') FROM analytics_daily WHERE utc_offset = ?`, offset).Scan(&latest)
+	if err != nil {
+		return err
+	}
+	if latest.Valid {
+		from, err := time.ParseInLocation("2006-01-02", latest.String, offsetLocation(offset))
+		if err != nil {
+			return err
+		}
+		return rollupDailyAnalytics(ctx, db, offset, from.AddDate(0, 0, -rollupRefreshDays))
+	}
+
+	var first sql.NullTime
+	err = db.QueryRowContext(ctx, `
+		SELECT MIN(first_at) FROM (
+			SELECT MIN(created_at) as first_at FROM analytics_pageviews
+			UNION ALL
+			SELECT MIN(created_at) FROM orders
+		) firsts
+	`).Scan(&first)
+	if err != nil || !first.Valid {
+		return err
+	}
+	return rollupDailyAnalytics(ctx, db, offset, first.Time)
+}
+
+// RebuildDailyAnalytics throws away a site's rollup and computes it again from scratch
+func (s *AdminServer) RebuildDailyAnalytics(ctx context.Context, websiteID, timezone string) error {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return rebuildDailyAnalytics(ctx, db, timezone)
+}
+
+// rebuildDailyAnalytics is RebuildDailyAnalytics on an open connection
+func rebuildDailyAnalytics(ctx context.Context, db *database.TimedDB, timezone string) error {
+	if _, err := db.ExecContext(ctx, "DELETE FROM analytics_daily"); err != nil {
+		return err
+	}
+	return updateDailyAnalytics(ctx, db, timezone)
+}
+
+// GetEngagementTimeSeries gets daily order count, avg pages per visit, and avg time on site
+func (s *AdminServer) GetEngagementTimeSeries(ctx context.Context, websiteID string, startDate, endDate time.Time, timezone string, includeBots bool) ([]map[string]interface{}, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
 
 	// Default to PST if no timezone specified
 	if timezone == "" {
@@ -802,6 +1487,7 @@ func (s *AdminServer) GetEngagementTimeSeries(websiteID string, startDate, endDa
 			SELECT DISTINCT DATE(CONVERT_TZ(created_at, '+00:00', '%s')) as date
 			FROM analytics_pageviews
 			WHERE DATE(CONVERT_TZ(created_at, '+00:00', '%s')) BETWEEN DATE(?) AND DATE(?)
+			AND (? OR is_bot = 0)
 			UNION
 			SELECT DISTINCT DATE(CONVERT_TZ(created_at, '+00:00', '%s')) as date
 			FROM orders
@@ -828,6 +1514,7 @@ func (s *AdminServer) GetEngagementTimeSeries(websiteID string, startDate, endDa
 					SUM(time_on_page) as total_time
 				FROM analytics_pageviews
 				WHERE DATE(CONVERT_TZ(created_at, '+00:00', '%s')) BETWEEN DATE(?) AND DATE(?)
+				AND (? OR is_bot = 0)
 				GROUP BY session_id, DATE(CONVERT_TZ(created_at, '+00:00', '%s'))
 			) sessions
 			GROUP BY date
@@ -835,7 +1522,7 @@ func (s *AdminServer) GetEngagementTimeSeries(websiteID string, startDate, endDa
 		ORDER BY dates.date ASC
 	`, offset, offset, offset, offset, offset, offset, offset, offset, offset, offset)
 
-	rows, err := db.Query(query, startDate, endDate, startDate, endDate, startDate, endDate, startDate, endDate)
+	rows, err := db.QueryContext(ctx, query, startDate, endDate, includeBots, startDate, endDate, startDate, endDate, startDate, endDate, includeBots)
 	if err != nil {
 		return nil, err
 	}
@@ -891,8 +1578,8 @@ func (s *AdminServer) GetEngagementTimeSeries(websiteID string, startDate, endDa
 }
 
 // GetGrowthTimeSeries gets daily new customers and SMS signups for charting
-func (s *AdminServer) GetGrowthTimeSeries(websiteID string, startDate, endDate time.Time, timezone string) ([]map[string]interface{}, error) {
-	db, err := s.GetWebsiteConnection(websiteID)
+func (s *AdminServer) GetGrowthTimeSeries(ctx context.Context, websiteID string, startDate, endDate time.Time, timezone string) ([]map[string]interface{}, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
 	if err != nil {
 		return nil, err
 	}
@@ -940,7 +1627,7 @@ func (s *AdminServer) GetGrowthTimeSeries(websiteID string, startDate, endDate t
 		ORDER BY dates.date ASC
 	`, offset, offset, offset, offset, offset, offset, offset, offset, offset, offset)
 
-	rows, err := db.Query(query, startDate, endDate, startDate, endDate, startDate, endDate, startDate, endDate)
+	rows, err := db.QueryContext(ctx, query, startDate, endDate, startDate, endDate, startDate, endDate, startDate, endDate)
 	if err != nil {
 		return nil, err
 	}
@@ -995,8 +1682,10 @@ func (s *AdminServer) GetArticles(websiteID string, limit, offset int) ([]Articl
 	}
 	defer db.Close()
 
-	query := `SELECT id, slug, title, description, content, excerpt, type, status, thumbnail_id, published_date, created_at, updated_at
-		FROM articles_unified ORDER BY created_at DESC LIMIT ? OFFSET ?`
+	query := `SELECT a.id, a.slug, a.title, a.description, a.content, a.excerpt, a.type, a.status, a.thumbnail_id, i.url, a.published_date, a.created_at, a.updated_at
+		FROM articles_unified a
+		LEFT JOIN images_unified i ON i.id = a.thumbnail_id
+		ORDER BY a.created_at DESC LIMIT ? OFFSET ?`
 
 	rows, err := db.Query(query, limit, offset)
 	if err != nil {
@@ -1009,7 +1698,8 @@ func (s *AdminServer) GetArticles(websiteID string, limit, offset int) ([]Articl
 		var a Article
 		var publishedDate sql.NullTime
 		var thumbnailID sql.NullInt64
-		err := rows.Scan(&a.ID, &a.Slug, &a.Title, &a.Description, &a.Content, &a.Excerpt, &a.Type, &a.Status, &thumbnailID, &publishedDate, &a.CreatedAt, &a.UpdatedAt)
+		var thumbnailURL sql.NullString
+		err := rows.Scan(&a.ID, &a.Slug, &a.Title, &a.Description, &a.Content, &a.Excerpt, &a.Type, &a.Status, &thumbnailID, &thumbnailURL, &publishedDate, &a.CreatedAt, &a.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -1019,6 +1709,7 @@ func (s *AdminServer) GetArticles(websiteID string, limit, offset int) ([]Articl
 		if thumbnailID.Valid {
 			a.ThumbnailID = int(thumbnailID.Int64)
 		}
+		a.ThumbnailURL = thumbnailURL.String
 		articles = append(articles, a)
 	}
 
@@ -1115,6 +1806,20 @@ func (s *AdminServer) UpdateArticle(websiteID string, a Article) error {
 	return err
 }
 
+// deletionRecorder is what recordDeletion needs, satisfied by a connection and a transaction
+type deletionRecorder interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// recordDeletion notes a product, article or collection that's about to be deleted in
+// content_deletions, so /api/v1/changes can tell headless clients to drop it
+func recordDeletion(db deletionRecorder, contentType, table string, id int) error {
+	_, err := db.Exec(fmt.Sprintf(`INSERT INTO content_deletions (content_type, content_id, slug)
+		SELECT ?, id, slug FROM %s WHERE id = ?
+		ON DUPLICATE KEY UPDATE slug = VALUES(slug), deleted_at = CURRENT_TIMESTAMP`, table), contentType, id)
+	return err
+}
+
 // DeleteArticle deletes an article
 func (s *AdminServer) DeleteArticle(websiteID string, articleID int) error {
 	db, err := s.GetWebsiteConnection(websiteID)
@@ -1123,21 +1828,41 @@ func (s *AdminServer) DeleteArticle(websiteID string, articleID int) error {
 	}
 	defer db.Close()
 
+	if err := recordDeletion(db, database.ContentTypeArticle, "articles_unified", articleID); err != nil {
+		return err
+	}
+
 	query := `DELETE FROM articles_unified WHERE id = ?`
 	_, err = db.Exec(query, articleID)
-	return err
+	if err != nil {
+		return err
+	}
+
+	// article_categories has no foreign key, so clear it here and recount the categories
+	return s.SetArticleCategories(websiteID, articleID, nil)
 }
 
-// GetProducts retrieves products for a specific website
-func (s *AdminServer) GetProducts(websiteID string, limit, offset int) ([]Product, error) {
+// GetProducts retrieves products for a specific website. withImage also loads each product's
+// first image into PrimaryImageURL, for lists that show thumbnails
+func (s *AdminServer) GetProducts(websiteID string, limit, offset int, withImage bool) ([]Product, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return nil, err
 	}
 	defer db.Close()
 
-	query := `SELECT id, name, slug, description, price, compare_at_price, sku, inventory_quantity, inventory_policy, status, featured, sort_order, created_at, updated_at
-		FROM products_unified ORDER BY sort_order ASC, created_at DESC LIMIT ? OFFSET ?`
+	// The join picks the lowest-positioned image, so there's still a primary image after the one
+	// at position 0 is deleted, and never more than one row per product
+	imageColumn, imageJoin := "''", ""
+	if withImage {
+		imageColumn = "IFNULL(pi.url, '')"
+		imageJoin = `LEFT JOIN product_images_data pi ON pi.id = (
+			SELECT id FROM product_images_data WHERE product_id = p.id ORDER BY position ASC, id ASC LIMIT 1)`
+	}
+
+	query := `SELECT p.id, p.name, p.slug, p.description, p.price, p.compare_at_price, p.sku, p.inventory_quantity, p.inventory_policy, p.status, p.featured, p.sort_order, ` + imageColumn + `, p.created_at, p.updated_at
+		FROM products_unified p ` + imageJoin + `
+		ORDER BY p.sort_order ASC, p.created_at DESC LIMIT ? OFFSET ?`
 
 	rows, err := db.Query(query, limit, offset)
 	if err != nil {
@@ -1148,32 +1873,73 @@ func (s *AdminServer) GetProducts(websiteID string, limit, offset int) ([]Produc
 	products := []Product{}
 	for rows.Next() {
 		var p Product
-		err := rows.Scan(&p.ID, &p.Name, &p.Slug, &p.Description, &p.Price, &p.CompareAtPrice, &p.SKU, &p.InventoryQuantity, &p.InventoryPolicy, &p.Status, &p.Featured, &p.SortOrder, &p.CreatedAt, &p.UpdatedAt)
+		err := rows.Scan(&p.ID, &p.Name, &p.Slug, &p.Description, &p.Price, &p.CompareAtPrice, &p.SKU, &p.InventoryQuantity, &p.InventoryPolicy, &p.Status, &p.Featured, &p.SortOrder, &p.PrimaryImageURL, &p.CreatedAt, &p.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
+		p.MinPrice, p.MaxPrice = p.Price, p.Price
+		products = append(products, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-		// Load variants for this product
-		variantsQuery := `SELECT id, product_id, title, price_modifier, sku, inventory_quantity, position
-			FROM product_variants WHERE product_id = ? ORDER BY position ASC`
-		variantRows, err := db.Query(variantsQuery, p.ID)
-		if err == nil {
-			defer variantRows.Close()
-			for variantRows.Next() {
-				var variant structs.ProductVariant
-				var sku sql.NullString
-				err := variantRows.Scan(&variant.ID, &variant.ProductID, &variant.Title, &variant.PriceModifier, &sku, &variant.InventoryQuantity, &variant.Position)
-				if err == nil {
-					variant.SKU = sku.String
-					p.Variants = append(p.Variants, variant)
-				}
-			}
+	if err := loadProductVariants(db, products); err != nil {
+		log.Printf("Error loading product variants: %v", err)
+	}
+
+	return products, nil
+}
+
+// loadProductVariants fills in the variants, variant count and price range of a page of products
+// with one query, rather than one per product
+func loadProductVariants(db *database.TimedDB, products []Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*Product, len(products))
+	ids := make([]interface{}, len(products))
+	for i := range products {
+		byID[products[i].ID] = &products[i]
+		ids[i] = products[i].ID
+	}
+
+	query := `SELECT id, product_id, title, price_modifier, sku, inventory_quantity, position
+		FROM product_variants WHERE product_id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)
+		ORDER BY product_id, position ASC`
+
+	rows, err := db.Query(query, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var variant structs.ProductVariant
+		var sku sql.NullString
+		if err := rows.Scan(&variant.ID, &variant.ProductID, &variant.Title, &variant.PriceModifier, &sku, &variant.InventoryQuantity, &variant.Position); err != nil {
+			return err
 		}
+		variant.SKU = sku.String
 
-		products = append(products, p)
+		p, ok := byID[variant.ProductID]
+		if !ok {
+			continue
+		}
+
+		price := p.Price + variant.PriceModifier
+		if p.VariantCount == 0 || price < p.MinPrice {
+			p.MinPrice = price
+		}
+		if p.VariantCount == 0 || price > p.MaxPrice {
+			p.MaxPrice = price
+		}
+		p.VariantCount++
+		p.Variants = append(p.Variants, variant)
 	}
 
-	return products, nil
+	return rows.Err()
 }
 
 // GetProduct retrieves a single product
@@ -1184,12 +1950,12 @@ func (s *AdminServer) GetProduct(websiteID string, productID int) (Product, erro
 	}
 	defer db.Close()
 
-	query := `SELECT id, name, slug, description, price, compare_at_price, sku, inventory_quantity, inventory_policy, status, featured, sort_order, released_date, created_at, updated_at
+	query := `SELECT id, name, slug, description, price, compare_at_price, sku, inventory_quantity, inventory_policy, IFNULL(max_per_order, 0), IFNULL(subscription_interval, ''), status, featured, sort_order, released_date, preorder, created_at, updated_at
 		FROM products_unified WHERE id = ?`
 
 	var p Product
 	var releasedDate sql.NullTime
-	err = db.QueryRow(query, productID).Scan(&p.ID, &p.Name, &p.Slug, &p.Description, &p.Price, &p.CompareAtPrice, &p.SKU, &p.InventoryQuantity, &p.InventoryPolicy, &p.Status, &p.Featured, &p.SortOrder, &releasedDate, &p.CreatedAt, &p.UpdatedAt)
+	err = db.QueryRow(query, productID).Scan(&p.ID, &p.Name, &p.Slug, &p.Description, &p.Price, &p.CompareAtPrice, &p.SKU, &p.InventoryQuantity, &p.InventoryPolicy, &p.MaxPerOrder, &p.SubscriptionInterval, &p.Status, &p.Featured, &p.SortOrder, &releasedDate, &p.Preorder, &p.CreatedAt, &p.UpdatedAt)
 	if err != nil {
 		return Product{}, err
 	}
@@ -1209,7 +1975,7 @@ func (s *AdminServer) GetProduct(websiteID string, productID int) (Product, erro
 }
 
 // CreateProduct creates a new product at the top and pushes others down
-func (s *AdminServer) CreateProduct(websiteID string, p Product) (int64, error) {
+func (s *AdminServer) CreateProduct(websiteID string, p Product, changedBy string) (int64, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return 0, err
@@ -1230,905 +1996,1072 @@ func (s *AdminServer) CreateProduct(websiteID string, p Product) (int64, error)
 	}
 
 	// Insert new product with sort_order = 0 (top position)
-	query := `INSERT INTO products_unified (name, slug, description, price, compare_at_price, sku, inventory_quantity, inventory_policy, status, featured, sort_order, released_date)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?)`
+	query := `INSERT INTO products_unified (name, slug, description, price, compare_at_price, sku, inventory_quantity, inventory_policy, max_per_order, subscription_interval, status, featured, sort_order, released_date, preorder)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?, ?)`
 
 	var releasedDate interface{}
 	if !p.ReleasedDate.IsZero() {
 		releasedDate = p.ReleasedDate
 	}
 
-	result, err := tx.Exec(query, p.Name, p.Slug, p.Description, p.Price, p.CompareAtPrice, p.SKU, p.InventoryQuantity, p.InventoryPolicy, p.Status, p.Featured, releasedDate)
+	var maxPerOrder interface{}
+	if p.MaxPerOrder > 0 {
+		maxPerOrder = p.MaxPerOrder
+	}
+
+	var subscriptionInterval interface{}
+	if p.SubscriptionInterval != "" {
+		subscriptionInterval = p.SubscriptionInterval
+	}
+
+	result, err := tx.Exec(query, p.Name, p.Slug, p.Description, p.Price, p.CompareAtPrice, p.SKU, p.InventoryQuantity, p.InventoryPolicy, maxPerOrder, subscriptionInterval, p.Status, p.Featured, releasedDate, p.Preorder)
 	if err != nil {
 		return 0, err
 	}
 
-	if err = tx.Commit(); err != nil {
+	productID, err := result.LastInsertId()
+	if err != nil {
 		return 0, err
 	}
 
-	return result.LastInsertId()
-}
-
-// UpdateProduct updates an existing product
-func (s *AdminServer) UpdateProduct(websiteID string, p Product) error {
-	db, err := s.GetWebsiteConnection(websiteID)
+	// Record the launch price as the start of the product's price history
+	_, err = tx.Exec(`INSERT INTO product_price_history (product_id, price, compare_at_price, changed_by) VALUES (?, ?, ?, ?)`,
+		productID, p.Price, p.CompareAtPrice, changedBy)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	defer db.Close()
-
-	query := `UPDATE products_unified SET name = ?, slug = ?, description = ?, price = ?, compare_at_price = ?, sku = ?, inventory_quantity = ?, inventory_policy = ?, status = ?, featured = ?, sort_order = ?, released_date = ?
-		WHERE id = ?`
 
-	var releasedDate interface{}
-	if !p.ReleasedDate.IsZero() {
-		releasedDate = p.ReleasedDate
+	if err = tx.Commit(); err != nil {
+		return 0, err
 	}
 
-	_, err = db.Exec(query, p.Name, p.Slug, p.Description, p.Price, p.CompareAtPrice, p.SKU, p.InventoryQuantity, p.InventoryPolicy, p.Status, p.Featured, p.SortOrder, releasedDate, p.ID)
-	return err
+	return productID, nil
 }
 
-// DeleteProduct deletes a product
-func (s *AdminServer) DeleteProduct(websiteID string, productID int) error {
+// UpdateProduct updates an existing product
+func (s *AdminServer) UpdateProduct(websiteID string, p Product, changedBy string) error {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	query := `DELETE FROM products_unified WHERE id = ?`
-	_, err = db.Exec(query, productID)
-	return err
-}
-
-// ReorderProduct swaps the sort_order of a product with an adjacent product
-func (s *AdminServer) ReorderProduct(websiteID string, productID int, direction string) error {
-	db, err := s.GetWebsiteConnection(websiteID)
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	defer db.Close()
+	defer tx.Rollback()
 
-	// Get current product's sort_order
-	var currentSortOrder int
-	err = db.QueryRow(`SELECT sort_order FROM products_unified WHERE id = ?`, productID).Scan(&currentSortOrder)
+	// Lock the row so concurrent saves record a consistent before/after price
+	var previousPrice, previousCompareAt float64
+	err = tx.QueryRow(`SELECT price, IFNULL(compare_at_price, 0) FROM products_unified WHERE id = ? FOR UPDATE`, p.ID).Scan(&previousPrice, &previousCompareAt)
 	if err != nil {
 		return err
 	}
 
-	var targetSortOrder int
-	var targetID int
-	if direction == "up" {
-		// Find the product with the next lower sort_order
-		err = db.QueryRow(`SELECT id, sort_order FROM products_unified WHERE sort_order < ? ORDER BY sort_order DESC LIMIT 1`, currentSortOrder).Scan(&targetID, &targetSortOrder)
-	} else if direction == "down" {
-		// Find the product with the next higher sort_order
-		err = db.QueryRow(`SELECT id, sort_order FROM products_unified WHERE sort_order > ? ORDER BY sort_order ASC LIMIT 1`, currentSortOrder).Scan(&targetID, &targetSortOrder)
+	query := `UPDATE products_unified SET name = ?, slug = ?, description = ?, price = ?, compare_at_price = ?, sku = ?, inventory_quantity = ?, inventory_policy = ?, max_per_order = ?, subscription_interval = ?, status = ?, featured = ?, sort_order = ?, released_date = ?, preorder = ?
+		WHERE id = ?`
+
+	var releasedDate interface{}
+	if !p.ReleasedDate.IsZero() {
+		releasedDate = p.ReleasedDate
 	}
 
-	if err == sql.ErrNoRows {
-		// Already at top/bottom, nothing to do - just return success
-		return nil
+	var maxPerOrder interface{}
+	if p.MaxPerOrder > 0 {
+		maxPerOrder = p.MaxPerOrder
 	}
-	if err != nil {
-		return err
+
+	var subscriptionInterval interface{}
+	if p.SubscriptionInterval != "" {
+		subscriptionInterval = p.SubscriptionInterval
 	}
 
-	// Swap sort_order values
-	tx, err := db.Begin()
+	_, err = tx.Exec(query, p.Name, p.Slug, p.Description, p.Price, p.CompareAtPrice, p.SKU, p.InventoryQuantity, p.InventoryPolicy, maxPerOrder, subscriptionInterval, p.Status, p.Featured, p.SortOrder, releasedDate, p.Preorder, p.ID)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
 
-	// Swap the two products' sort_order values
-	_, err = tx.Exec(`UPDATE products_unified SET sort_order = ? WHERE id = ?`, targetSortOrder, productID)
-	if err != nil {
-		return err
+	if priceChanged(previousPrice, p.Price) || priceChanged(previousCompareAt, p.CompareAtPrice) {
+		_, err = tx.Exec(`
+			INSERT INTO product_price_history (product_id, price, compare_at_price, previous_price, previous_compare_at_price, changed_by)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, p.ID, p.Price, p.CompareAtPrice, previousPrice, previousCompareAt, changedBy)
+		if err != nil {
+			return err
+		}
 	}
 
-	_, err = tx.Exec(`UPDATE products_unified SET sort_order = ? WHERE id = ?`, currentSortOrder, targetID)
-	if err != nil {
+	if err := tx.Commit(); err != nil {
 		return err
 	}
 
-	return tx.Commit()
+	// Images uploaded while the product was a draft become public with it
+	if p.Status == "published" {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		if err := s.PublishProductImages(ctx, websiteID, p.ID); err != nil {
+			log.Printf("Warning: Failed to publish images for product %d: %v", p.ID, err)
+		}
+	}
+
+	return nil
 }
 
-// Helper function to load variants for a product
-func (s *AdminServer) getProductVariants(websiteID string, productID int) ([]structs.ProductVariant, error) {
+// priceChanged compares two prices at cent precision, since DECIMAL columns round what we store
+func priceChanged(before, after float64) bool {
+	return math.Round(before*100) != math.Round(after*100)
+}
+
+// PriceChange is one entry in a product's price history
+type PriceChange struct {
+	ID                     int       `json:"id"`
+	ProductID              int       `json:"productId"`
+	Price                  float64   `json:"price"`
+	CompareAtPrice         float64   `json:"compareAtPrice"`
+	PreviousPrice          float64   `json:"previousPrice"`
+	PreviousCompareAtPrice float64   `json:"previousCompareAtPrice"`
+	IsInitial              bool      `json:"isInitial"` // first recorded price, no previous values
+	ChangedBy              string    `json:"changedBy"`
+	ChangedAt              time.Time `json:"changedAt"`
+}
+
+// GetPriceHistory returns a product's price changes, newest first
+func (s *AdminServer) GetPriceHistory(websiteID string, productID int) ([]PriceChange, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return nil, err
 	}
 	defer db.Close()
 
-	query := `
-		SELECT id, product_id, title, price_modifier, sku, inventory_quantity, position
-		FROM product_variants
+	rows, err := db.Query(`
+		SELECT id, product_id, price, IFNULL(compare_at_price, 0), previous_price, IFNULL(previous_compare_at_price, 0), changed_by, changed_at
+		FROM product_price_history
 		WHERE product_id = ?
-		ORDER BY position ASC
-	`
-
-	rows, err := db.Query(query, productID)
+		ORDER BY changed_at DESC, id DESC
+	`, productID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var variants []structs.ProductVariant
+	history := []PriceChange{}
 	for rows.Next() {
-		var variant structs.ProductVariant
-		var sku sql.NullString
-
-		err := rows.Scan(
-			&variant.ID,
-			&variant.ProductID,
-			&variant.Title,
-			&variant.PriceModifier,
-			&sku,
-			&variant.InventoryQuantity,
-			&variant.Position,
-		)
+		var c PriceChange
+		var previousPrice sql.NullFloat64
+		err := rows.Scan(&c.ID, &c.ProductID, &c.Price, &c.CompareAtPrice, &previousPrice, &c.PreviousCompareAtPrice, &c.ChangedBy, &c.ChangedAt)
 		if err != nil {
-			continue
+			return nil, err
 		}
-
-		variant.SKU = sku.String
-
-		variants = append(variants, variant)
+		c.PreviousPrice = previousPrice.Float64
+		c.IsInitial = !previousPrice.Valid
+		history = append(history, c)
 	}
 
-	return variants, nil
+	return history, nil
 }
 
-// Variant management functions
-func (s *AdminServer) CreateVariant(websiteID string, productID int, data map[string]interface{}) error {
+// BundleItem is a product included in a bundle, with its current stock
+type BundleItem struct {
+	ProductID         int
+	Name              string
+	SKU               string
+	Quantity          int
+	InventoryQuantity int
+	Missing           bool // the product was deleted, so the bundle can't be sold until it's removed
+}
+
+// GetBundleItems returns the products a bundle is made of, none when it isn't a bundle
+func (s *AdminServer) GetBundleItems(websiteID string, bundleID int) ([]BundleItem, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer db.Close()
 
-	// Get the max position for this product
-	var maxPosition int
-	err = db.QueryRow(`SELECT COALESCE(MAX(position), 0) FROM product_variants WHERE product_id = ?`, productID).Scan(&maxPosition)
+	rows, err := db.Query(`
+		SELECT bi.component_id, IFNULL(p.name, ''), IFNULL(p.sku, ''), bi.quantity, IFNULL(p.inventory_quantity, 0), p.id IS NULL
+		FROM product_bundle_items bi
+		LEFT JOIN products_unified p ON p.id = bi.component_id
+		WHERE bi.bundle_id = ?
+		ORDER BY p.name
+	`, bundleID)
 	if err != nil {
-		maxPosition = 0
+		return nil, err
 	}
+	defer rows.Close()
 
-	query := `
-		INSERT INTO product_variants (
-			product_id, title, price_modifier, sku, inventory_quantity, position
-		) VALUES (?, ?, ?, ?, ?, ?)
-	`
+	items := []BundleItem{}
+	for rows.Next() {
+		var item BundleItem
+		if err := rows.Scan(&item.ProductID, &item.Name, &item.SKU, &item.Quantity, &item.InventoryQuantity, &item.Missing); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
 
-	_, err = db.Exec(query,
-		productID,
-		data["title"],
-		data["priceModifier"],
-		data["sku"],
-		data["inventoryQuantity"],
-		maxPosition+1,
-	)
-
-	return err
+	return items, rows.Err()
 }
 
-func (s *AdminServer) GetVariant(websiteID string, variantID int) (structs.ProductVariant, error) {
-	db, err := s.GetWebsiteConnection(websiteID)
-	if err != nil {
-		return structs.ProductVariant{}, err
+// AddBundleItem puts quantity of a component product in a bundle, replacing the quantity if it's
+// already there. Bundles can't contain themselves or other bundles, or be part of one.
+func (s *AdminServer) AddBundleItem(websiteID string, bundleID int, componentID int, quantity int) error {
+	if quantity < 1 {
+		return fmt.Errorf("quantity must be at least 1")
 	}
-	defer db.Close()
-
-	query := `
-		SELECT id, product_id, title, price_modifier, sku, inventory_quantity, position
-		FROM product_variants
-		WHERE id = ?
-	`
-
-	var variant structs.ProductVariant
-	var sku sql.NullString
-
-	err = db.QueryRow(query, variantID).Scan(
-		&variant.ID,
-		&variant.ProductID,
-		&variant.Title,
-		&variant.PriceModifier,
-		&sku,
-		&variant.InventoryQuantity,
-		&variant.Position,
-	)
-
-	if err != nil {
-		return structs.ProductVariant{}, err
+	if componentID == bundleID {
+		return fmt.Errorf("a bundle can't include itself")
 	}
 
-	variant.SKU = sku.String
-
-	return variant, nil
-}
-
-func (s *AdminServer) UpdateVariant(websiteID string, variantID int, data map[string]interface{}) error {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	query := `
-		UPDATE product_variants
-		SET title = ?, price_modifier = ?, sku = ?, inventory_quantity = ?
-		WHERE id = ?
-	`
+	var hasVariants bool
+	err = db.QueryRow(`SELECT EXISTS(SELECT 1 FROM product_variants WHERE product_id = ?) FROM products_unified WHERE id = ?`, componentID, componentID).Scan(&hasVariants)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("component product not found")
+	} else if err != nil {
+		return err
+	}
+	if hasVariants {
+		return fmt.Errorf("products with variants can't be bundled")
+	}
 
-	_, err = db.Exec(query,
-		data["title"],
-		data["priceModifier"],
-		data["sku"],
-		data["inventoryQuantity"],
-		variantID,
-	)
+	var nested bool
+	err = db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM product_bundle_items WHERE bundle_id = ? OR component_id = ?)
+	`, componentID, bundleID).Scan(&nested)
+	if err != nil {
+		return err
+	}
+	if nested {
+		return fmt.Errorf("bundles can't be nested")
+	}
 
+	_, err = db.Exec(`
+		INSERT INTO product_bundle_items (bundle_id, component_id, quantity)
+		VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE quantity = VALUES(quantity)
+	`, bundleID, componentID, quantity)
 	return err
 }
 
-func (s *AdminServer) DeleteVariant(websiteID string, variantID int) error {
+// RemoveBundleItem takes a component product out of a bundle
+func (s *AdminServer) RemoveBundleItem(websiteID string, bundleID int, componentID int) error {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	query := `DELETE FROM product_variants WHERE id = ?`
-	_, err = db.Exec(query, variantID)
+	_, err = db.Exec(`DELETE FROM product_bundle_items WHERE bundle_id = ? AND component_id = ?`, bundleID, componentID)
 	return err
 }
 
-// ReorderVariant moves a variant up or down in the list
-func (s *AdminServer) ReorderVariant(websiteID string, variantID int, direction string) error {
-	db, err := s.GetWebsiteConnection(websiteID)
+// VariantSales is how one variant of a product sold over a period
+type VariantSales struct {
+	VariantTitle string // empty for order items without a variant
+	Units        int
+	Revenue      float64
+	Orders       int
+}
+
+// GetSalesByVariant sums a product's order items in paid orders placed in [start, end) by variant
+// title, best sellers first
+func (s *AdminServer) GetSalesByVariant(websiteID string, productID int, start, end time.Time) ([]VariantSales, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer db.Close()
 
-	// Get current variant's position and product_id
-	var currentPosition, productID int
-	err = db.QueryRow(`SELECT position, product_id FROM product_variants WHERE id = ?`, variantID).Scan(&currentPosition, &productID)
+	rows, err := db.Query(`
+		SELECT IFNULL(oi.variant_title, ''), SUM(oi.quantity), COALESCE(SUM(oi.total), 0), COUNT(DISTINCT o.id)
+		FROM order_items oi
+		JOIN orders o ON o.id = oi.order_id
+		WHERE oi.product_id = ? AND o.payment_status = 'paid' AND o.created_at >= ? AND o.created_at < ?
+		GROUP BY IFNULL(oi.variant_title, '')
+		ORDER BY SUM(oi.quantity) DESC, 1
+	`, productID, start, end)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	var targetPosition int
-	var targetID int
-	if direction == "up" {
-		// Find the variant with the next lower position (same product)
-		err = db.QueryRow(`SELECT id, position FROM product_variants WHERE product_id = ? AND position < ? ORDER BY position DESC LIMIT 1`, productID, currentPosition).Scan(&targetID, &targetPosition)
-	} else if direction == "down" {
-		// Find the variant with the next higher position (same product)
-		err = db.QueryRow(`SELECT id, position FROM product_variants WHERE product_id = ? AND position > ? ORDER BY position ASC LIMIT 1`, productID, currentPosition).Scan(&targetID, &targetPosition)
+	sales := []VariantSales{}
+	for rows.Next() {
+		var v VariantSales
+		if err := rows.Scan(&v.VariantTitle, &v.Units, &v.Revenue, &v.Orders); err != nil {
+			return nil, err
+		}
+		sales = append(sales, v)
 	}
 
-	if err == sql.ErrNoRows {
-		// Already at top/bottom, nothing to do
-		return nil
-	}
-	if err != nil {
-		return err
-	}
+	return sales, rows.Err()
+}
 
-	// Swap position values
-	tx, err := db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
+// BulkPriceChange is one product's price before and after a bulk adjustment
+type BulkPriceChange struct {
+	ProductID         int
+	Name              string
+	SKU               string
+	OldPrice          float64
+	NewPrice          float64
+	OldCompareAtPrice float64
+	NewCompareAtPrice float64
+	Skipped           string // reason the product was left alone, empty when it changes
+}
 
-	_, err = tx.Exec(`UPDATE product_variants SET position = ? WHERE id = ?`, targetPosition, variantID)
-	if err != nil {
-		return err
+// BulkAdjustPrices changes the price of every product in a collection by pct percent (-20 for 20% off).
+// With setCompareAt, a product whose price drops keeps its old price as the compare-at price so the
+// storefront shows it as a sale. A dry run computes the changes without writing them
+func (s *AdminServer) BulkAdjustPrices(websiteID string, collectionID int, pct float64, setCompareAt bool, changedBy string, dryRun bool) ([]BulkPriceChange, error) {
+	adjust := func(price float64) float64 {
+		return price * (1 + pct/100)
 	}
+	return s.adjustCollectionPrices(websiteID, collectionID, adjust, setCompareAt, changedBy, dryRun)
+}
 
-	_, err = tx.Exec(`UPDATE product_variants SET position = ? WHERE id = ?`, currentPosition, targetID)
-	if err != nil {
-		return err
+// BulkAdjustPricesByAmount is BulkAdjustPrices with a fixed amount added to (or, when negative,
+// taken off) every price instead of a percentage
+func (s *AdminServer) BulkAdjustPricesByAmount(websiteID string, collectionID int, amount float64, setCompareAt bool, changedBy string, dryRun bool) ([]BulkPriceChange, error) {
+	adjust := func(price float64) float64 {
+		return price + amount
 	}
-
-	return tx.Commit()
+	return s.adjustCollectionPrices(websiteID, collectionID, adjust, setCompareAt, changedBy, dryRun)
 }
 
-// GetCategories retrieves categories for a specific website
-func (s *AdminServer) GetCategories(websiteID string) ([]Category, error) {
+func (s *AdminServer) adjustCollectionPrices(websiteID string, collectionID int, adjust func(float64) float64, setCompareAt bool, changedBy string, dryRun bool) ([]BulkPriceChange, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return nil, err
 	}
 	defer db.Close()
 
-	query := `SELECT id, name, slug, count, created_at, updated_at FROM categories_unified ORDER BY name`
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-	rows, err := db.Query(query)
+	// Lock every product in the collection so nothing changes between reading and writing prices
+	rows, err := tx.Query(`
+		SELECT p.id, p.name, IFNULL(p.sku, ''), p.price, IFNULL(p.compare_at_price, 0)
+		FROM products_unified p
+		INNER JOIN product_collections pc ON p.id = pc.product_id
+		WHERE pc.collection_id = ?
+		ORDER BY pc.position, p.id
+		FOR UPDATE
+	`, collectionID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	categories := []Category{}
+	changes := []BulkPriceChange{}
 	for rows.Next() {
-		var c Category
-		err := rows.Scan(&c.ID, &c.Name, &c.Slug, &c.Count, &c.CreatedAt, &c.UpdatedAt)
-		if err != nil {
+		var c BulkPriceChange
+		if err := rows.Scan(&c.ProductID, &c.Name, &c.SKU, &c.OldPrice, &c.OldCompareAtPrice); err != nil {
+			rows.Close()
 			return nil, err
 		}
-		categories = append(categories, c)
+		changes = append(changes, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return categories, nil
-}
+	for i := range changes {
+		c := &changes[i]
+		c.NewPrice = math.Round(adjust(c.OldPrice)*100) / 100
+		c.NewCompareAtPrice = c.OldCompareAtPrice
 
-// CreateCategory creates a new category
-func (s *AdminServer) CreateCategory(websiteID string, c Category) (int64, error) {
-	db, err := s.GetWebsiteConnection(websiteID)
-	if err != nil {
-		return 0, err
-	}
-	defer db.Close()
+		if c.NewPrice <= 0 {
+			c.Skipped = "price would drop to zero or below"
+			c.NewPrice = c.OldPrice
+			continue
+		}
+		if !priceChanged(c.OldPrice, c.NewPrice) {
+			c.Skipped = "no change"
+			continue
+		}
 
-	query := `INSERT INTO categories_unified (name, slug) VALUES (?, ?)`
-	result, err := db.Exec(query, c.Name, c.Slug)
-	if err != nil {
-		return 0, err
+		// Products already on sale keep their original compare-at price rather than the sale price
+		if setCompareAt && c.NewPrice < c.OldPrice && c.OldCompareAtPrice <= c.OldPrice {
+			c.NewCompareAtPrice = c.OldPrice
+		}
+
+		if dryRun {
+			continue
+		}
+
+		_, err = tx.Exec(`UPDATE products_unified SET price = ?, compare_at_price = ? WHERE id = ?`,
+			c.NewPrice, c.NewCompareAtPrice, c.ProductID)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO product_price_history (product_id, price, compare_at_price, previous_price, previous_compare_at_price, changed_by)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, c.ProductID, c.NewPrice, c.NewCompareAtPrice, c.OldPrice, c.OldCompareAtPrice, changedBy)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return result.LastInsertId()
-}
+	if dryRun {
+		return changes, nil
+	}
 
-// DeleteCategory deletes a category
-func (s *AdminServer) DeleteCategory(websiteID string, categoryID int) error {
-	db, err := s.GetWebsiteConnection(websiteID)
-	if err != nil {
-		return err
+	if err = tx.Commit(); err != nil {
+		return nil, err
 	}
-	defer db.Close()
 
-	query := `DELETE FROM categories_unified WHERE id = ?`
-	_, err = db.Exec(query, categoryID)
-	return err
+	return changes, nil
 }
 
-// GetCollections retrieves collections for a specific website
-func (s *AdminServer) GetCollections(websiteID string) ([]Collection, error) {
+// ScheduledSale is a percentage-off sale on a collection that runs between two dates
+type ScheduledSale struct {
+	ID             int       `json:"id"`
+	CollectionID   int       `json:"collectionId"`
+	CollectionName string    `json:"collectionName"`
+	Name           string    `json:"name"`
+	PercentOff     float64   `json:"percentOff"`
+	StartsAt       time.Time `json:"startsAt"`
+	EndsAt         time.Time `json:"endsAt"`
+	Status         string    `json:"status"` // scheduled, active, ended or cancelled
+	ItemCount      int       `json:"itemCount"`
+	CreatedBy      string    `json:"createdBy"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// GetScheduledSales returns all sales, upcoming and active ones first
+func (s *AdminServer) GetScheduledSales(websiteID string) ([]ScheduledSale, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return nil, err
 	}
 	defer db.Close()
 
-	query := `SELECT id, name, slug, description, image_id, sort_order, status, created_at, updated_at
-		FROM collections_unified ORDER BY sort_order, name`
-
-	rows, err := db.Query(query)
+	rows, err := db.Query(`
+		SELECT ss.id, ss.collection_id, IFNULL(c.name, ''), ss.name, ss.percent_off, ss.starts_at, ss.ends_at, ss.status,
+			(SELECT COUNT(*) FROM scheduled_sale_items si WHERE si.sale_id = ss.id), ss.created_by, ss.created_at
+		FROM scheduled_sales ss
+		LEFT JOIN collections_unified c ON c.id = ss.collection_id
+		ORDER BY FIELD(ss.status, 'active', 'scheduled') DESC, ss.starts_at DESC
+	`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	collections := []Collection{}
+	sales := []ScheduledSale{}
 	for rows.Next() {
-		var c Collection
-		err := rows.Scan(&c.ID, &c.Name, &c.Slug, &c.Description, &c.ImageID, &c.SortOrder, &c.Status, &c.CreatedAt, &c.UpdatedAt)
+		var sale ScheduledSale
+		err := rows.Scan(&sale.ID, &sale.CollectionID, &sale.CollectionName, &sale.Name, &sale.PercentOff,
+			&sale.StartsAt, &sale.EndsAt, &sale.Status, &sale.ItemCount, &sale.CreatedBy, &sale.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
-		collections = append(collections, c)
+		sales = append(sales, sale)
 	}
 
-	return collections, nil
+	return sales, rows.Err()
 }
 
-// CreateCollection creates a new collection at the top and pushes others down
-func (s *AdminServer) CreateCollection(websiteID string, c Collection) (int64, error) {
+// CreateScheduledSale stores a new sale; the sale scheduler applies it once StartsAt passes
+func (s *AdminServer) CreateScheduledSale(websiteID string, sale ScheduledSale) (int64, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return 0, err
 	}
 	defer db.Close()
 
-	// Start transaction
-	tx, err := db.Begin()
-	if err != nil {
-		return 0, err
-	}
-	defer tx.Rollback()
-
-	// Increment all existing collections' sort_order by 1
-	_, err = tx.Exec(`UPDATE collections_unified SET sort_order = sort_order + 1`)
-	if err != nil {
-		return 0, err
-	}
-
-	// Insert new collection with sort_order = 0 (top position)
-	query := `INSERT INTO collections_unified (name, slug, description, image_id, sort_order, status)
-		VALUES (?, ?, ?, ?, 0, ?)`
-
-	result, err := tx.Exec(query, c.Name, c.Slug, c.Description, c.ImageID, c.Status)
+	result, err := db.Exec(`
+		INSERT INTO scheduled_sales (collection_id, name, percent_off, starts_at, ends_at, status, created_by)
+		VALUES (?, ?, ?, ?, ?, 'scheduled', ?)
+	`, sale.CollectionID, sale.Name, sale.PercentOff, sale.StartsAt.UTC(), sale.EndsAt.UTC(), sale.CreatedBy)
 	if err != nil {
 		return 0, err
 	}
 
-	if err = tx.Commit(); err != nil {
-		return 0, err
-	}
-
 	return result.LastInsertId()
 }
 
-// GetCollection retrieves a single collection by ID
-func (s *AdminServer) GetCollection(websiteID string, collectionID int) (Collection, error) {
+// RunScheduledSales starts sales whose start time has passed and ends sales whose end time has passed
+func (s *AdminServer) RunScheduledSales(websiteID string) error {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return Collection{}, err
+		return err
 	}
-	defer db.Close()
 
-	query := `SELECT id, name, slug, description, image_id, sort_order, status, created_at, updated_at
-		FROM collections_unified WHERE id = ?`
+	now := time.Now().UTC()
+	var toStart, toEnd []int
 
-	var c Collection
-	var imageID sql.NullInt64
-	err = db.QueryRow(query, collectionID).Scan(
-		&c.ID, &c.Name, &c.Slug, &c.Description, &imageID, &c.SortOrder, &c.Status, &c.CreatedAt, &c.UpdatedAt,
-	)
+	rows, err := db.Query(`SELECT id, ends_at > ? FROM scheduled_sales WHERE status = 'scheduled' AND starts_at <= ?`, now, now)
 	if err != nil {
-		return Collection{}, err
+		db.Close()
+		return err
 	}
-
-	if imageID.Valid {
-		c.ImageID = int(imageID.Int64)
+	for rows.Next() {
+		var id int
+		var stillRunning bool
+		if err := rows.Scan(&id, &stillRunning); err != nil {
+			rows.Close()
+			db.Close()
+			return err
+		}
+		// A sale whose whole window passed while the server was down is ended without being applied
+		if stillRunning {
+			toStart = append(toStart, id)
+		} else {
+			toEnd = append(toEnd, id)
+		}
 	}
+	rows.Close()
 
-	return c, nil
-}
-
-// UpdateCollection updates an existing collection
-func (s *AdminServer) UpdateCollection(websiteID string, c Collection) error {
-	db, err := s.GetWebsiteConnection(websiteID)
+	rows, err = db.Query(`SELECT id FROM scheduled_sales WHERE status = 'active' AND ends_at <= ?`, now)
 	if err != nil {
+		db.Close()
 		return err
 	}
-	defer db.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			db.Close()
+			return err
+		}
+		toEnd = append(toEnd, id)
+	}
+	rows.Close()
+	db.Close()
 
-	query := `UPDATE collections_unified SET name = ?, slug = ?, description = ?, image_id = ?, sort_order = ?, status = ?
-		WHERE id = ?`
+	for _, id := range toStart {
+		if err := s.StartScheduledSale(websiteID, id); err != nil {
+			log.Printf("Error starting sale %d for %s: %v", id, websiteID, err)
+		}
+	}
+	for _, id := range toEnd {
+		if err := s.EndScheduledSale(websiteID, id, "ended"); err != nil {
+			log.Printf("Error ending sale %d for %s: %v", id, websiteID, err)
+		}
+	}
 
-	_, err = db.Exec(query, c.Name, c.Slug, c.Description, c.ImageID, c.SortOrder, c.Status, c.ID)
-	return err
+	return nil
 }
 
-// DeleteCollection deletes a collection and renumbers the remaining ones
-func (s *AdminServer) DeleteCollection(websiteID string, collectionID int) error {
+// StartScheduledSale discounts every product in the sale's collection, keeping the old price as
+// the compare-at price. Products already discounted by another active sale are left alone so
+// sales never stack. Starting a sale that isn't scheduled does nothing
+func (s *AdminServer) StartScheduledSale(websiteID string, saleID int) error {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	// Start transaction
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	// Delete the collection
-	_, err = tx.Exec(`DELETE FROM collections_unified WHERE id = ?`, collectionID)
+	// The sale row lock serialises the scheduler against manual start/cancel
+	var collectionID int
+	var percentOff float64
+	var status string
+	err = tx.QueryRow(`SELECT collection_id, percent_off, status FROM scheduled_sales WHERE id = ? FOR UPDATE`, saleID).
+		Scan(&collectionID, &percentOff, &status)
 	if err != nil {
 		return err
 	}
+	if status != "scheduled" {
+		return nil
+	}
 
-	// Renumber all collections sequentially starting from 0
-	// Get all collections ordered by current sort_order
-	rows, err := tx.Query(`SELECT id FROM collections_unified ORDER BY sort_order, name`)
+	rows, err := tx.Query(`
+		SELECT p.id, p.price, IFNULL(p.compare_at_price, 0)
+		FROM products_unified p
+		INNER JOIN product_collections pc ON p.id = pc.product_id
+		WHERE pc.collection_id = ?
+			AND NOT EXISTS (
+				SELECT 1 FROM scheduled_sale_items si
+				INNER JOIN scheduled_sales other ON other.id = si.sale_id
+				WHERE si.product_id = p.id AND other.status = 'active'
+			)
+		FOR UPDATE
+	`, collectionID)
 	if err != nil {
 		return err
 	}
 
-	var collectionIDs []int
+	changes := []BulkPriceChange{}
 	for rows.Next() {
-		var id int
-		if err := rows.Scan(&id); err != nil {
+		var c BulkPriceChange
+		if err := rows.Scan(&c.ProductID, &c.OldPrice, &c.OldCompareAtPrice); err != nil {
 			rows.Close()
 			return err
 		}
-		collectionIDs = append(collectionIDs, id)
+		changes = append(changes, c)
 	}
 	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-	// Update each collection with sequential sort_order
-	for i, id := range collectionIDs {
-		_, err = tx.Exec(`UPDATE collections_unified SET sort_order = ? WHERE id = ?`, i, id)
+	changedBy := fmt.Sprintf("sale #%d", saleID)
+	for _, c := range changes {
+		c.NewPrice = math.Round(c.OldPrice*(100-percentOff)) / 100
+		if c.NewPrice <= 0 || !priceChanged(c.OldPrice, c.NewPrice) {
+			continue
+		}
+
+		c.NewCompareAtPrice = c.OldCompareAtPrice
+		if c.OldCompareAtPrice <= c.OldPrice {
+			c.NewCompareAtPrice = c.OldPrice
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO scheduled_sale_items (sale_id, product_id, original_price, original_compare_at_price, sale_price)
+			VALUES (?, ?, ?, NULLIF(?, 0), ?)
+		`, saleID, c.ProductID, c.OldPrice, c.OldCompareAtPrice, c.NewPrice)
 		if err != nil {
 			return err
 		}
-	}
 
-	return tx.Commit()
-}
+		_, err = tx.Exec(`UPDATE products_unified SET price = ?, compare_at_price = ? WHERE id = ?`,
+			c.NewPrice, c.NewCompareAtPrice, c.ProductID)
+		if err != nil {
+			return err
+		}
 
-// ReorderCollection moves a collection up or down in sort order
-func (s *AdminServer) ReorderCollection(websiteID string, collectionID int, direction string) error {
-	db, err := s.GetWebsiteConnection(websiteID)
-	if err != nil {
-		return err
+		_, err = tx.Exec(`
+			INSERT INTO product_price_history (product_id, price, compare_at_price, previous_price, previous_compare_at_price, changed_by)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, c.ProductID, c.NewPrice, c.NewCompareAtPrice, c.OldPrice, c.OldCompareAtPrice, changedBy)
+		if err != nil {
+			return err
+		}
 	}
-	defer db.Close()
 
-	// Get current collection's sort_order
-	var currentSortOrder int
-	err = db.QueryRow(`SELECT sort_order FROM collections_unified WHERE id = ?`, collectionID).Scan(&currentSortOrder)
-	if err != nil {
+	if _, err = tx.Exec(`UPDATE scheduled_sales SET status = 'active' WHERE id = ?`, saleID); err != nil {
 		return err
 	}
 
-	var targetSortOrder int
-	var targetID int
-	if direction == "up" {
-		// Find the collection with the next lower sort_order
-		err = db.QueryRow(`SELECT id, sort_order FROM collections_unified WHERE sort_order < ? ORDER BY sort_order DESC LIMIT 1`, currentSortOrder).Scan(&targetID, &targetSortOrder)
-	} else if direction == "down" {
-		// Find the collection with the next higher sort_order
-		err = db.QueryRow(`SELECT id, sort_order FROM collections_unified WHERE sort_order > ? ORDER BY sort_order ASC LIMIT 1`, currentSortOrder).Scan(&targetID, &targetSortOrder)
+	if err := tx.Commit(); err != nil {
+		return err
 	}
+	database.Publish(db.Site, database.ProductsChanged)
+	return nil
+}
 
-	if err == sql.ErrNoRows {
-		// Already at top/bottom, nothing to do - just return success
-		return nil
-	}
+// EndScheduledSale restores the prices a sale changed and marks it finalStatus (ended or
+// cancelled). A product whose price was edited during the sale keeps the edited price. Ending a
+// sale that already ended or was cancelled does nothing, so a retry after a partial failure is safe
+func (s *AdminServer) EndScheduledSale(websiteID string, saleID int, finalStatus string) error {
+	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return err
 	}
+	defer db.Close()
 
-	// Swap sort_order values
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	// Swap the two collections' sort_order values
-	_, err = tx.Exec(`UPDATE collections_unified SET sort_order = ? WHERE id = ?`, targetSortOrder, collectionID)
+	var status string
+	err = tx.QueryRow(`SELECT status FROM scheduled_sales WHERE id = ? FOR UPDATE`, saleID).Scan(&status)
 	if err != nil {
 		return err
 	}
+	if status != "scheduled" && status != "active" {
+		return nil
+	}
 
-	_, err = tx.Exec(`UPDATE collections_unified SET sort_order = ? WHERE id = ?`, currentSortOrder, targetID)
-	if err != nil {
+	if status == "active" {
+		rows, err := tx.Query(`
+			SELECT si.product_id, si.original_price, IFNULL(si.original_compare_at_price, 0), si.sale_price,
+				p.price, IFNULL(p.compare_at_price, 0)
+			FROM scheduled_sale_items si
+			INNER JOIN products_unified p ON p.id = si.product_id
+			WHERE si.sale_id = ?
+			FOR UPDATE
+		`, saleID)
+		if err != nil {
+			return err
+		}
+
+		type saleItem struct {
+			productID                        int
+			originalPrice, originalCompareAt float64
+			salePrice, price, compareAt      float64
+		}
+		items := []saleItem{}
+		for rows.Next() {
+			var it saleItem
+			if err := rows.Scan(&it.productID, &it.originalPrice, &it.originalCompareAt, &it.salePrice, &it.price, &it.compareAt); err != nil {
+				rows.Close()
+				return err
+			}
+			items = append(items, it)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		changedBy := fmt.Sprintf("sale #%d", saleID)
+		for _, it := range items {
+			if priceChanged(it.price, it.salePrice) {
+				continue
+			}
+
+			_, err = tx.Exec(`UPDATE products_unified SET price = ?, compare_at_price = NULLIF(?, 0) WHERE id = ?`,
+				it.originalPrice, it.originalCompareAt, it.productID)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(`
+				INSERT INTO product_price_history (product_id, price, compare_at_price, previous_price, previous_compare_at_price, changed_by)
+				VALUES (?, ?, ?, ?, ?, ?)
+			`, it.productID, it.originalPrice, it.originalCompareAt, it.price, it.compareAt, changedBy)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err = tx.Exec(`UPDATE scheduled_sales SET status = ? WHERE id = ?`, finalStatus, saleID); err != nil {
 		return err
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	database.Publish(db.Site, database.ProductsChanged)
+	return nil
 }
 
-// GetImages retrieves images for a specific website
-func (s *AdminServer) GetImages(websiteID string, limit, offset int) ([]Image, error) {
+// FindProductID looks up a product by SKU, falling back to slug, returning 0 when neither matches
+func (s *AdminServer) FindProductID(websiteID string, sku string, slug string) (int, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 	defer db.Close()
 
-	query := `SELECT id, url, alt_text, credit, filename, size, width, height, created_at
-		FROM images_unified ORDER BY created_at DESC LIMIT ? OFFSET ?`
+	var id int
+	if sku != "" {
+		err = db.QueryRow(`SELECT id FROM products_unified WHERE sku = ? LIMIT 1`, sku).Scan(&id)
+		if err == nil {
+			return id, nil
+		}
+		if err != sql.ErrNoRows {
+			return 0, err
+		}
+	}
 
-	rows, err := db.Query(query, limit, offset)
-	if err != nil {
-		return nil, err
+	if slug != "" {
+		err = db.QueryRow(`SELECT id FROM products_unified WHERE slug = ?`, slug).Scan(&id)
+		if err == nil {
+			return id, nil
+		}
+		if err != sql.ErrNoRows {
+			return 0, err
+		}
 	}
-	defer rows.Close()
 
-	images := []Image{}
-	for rows.Next() {
-		var img Image
-		var filename, altText, credit sql.NullString
-		var size sql.NullInt64
-		var width, height sql.NullInt64
-		err := rows.Scan(&img.ID, &img.URL, &altText, &credit, &filename, &size, &width, &height, &img.CreatedAt)
+	return 0, nil
+}
+
+// StocktakeLine compares one SKU's counted quantity with the quantity on record
+type StocktakeLine struct {
+	SKU       string
+	ProductID int
+	VariantID int // 0 when the SKU is the product's own
+	Name      string
+	Expected  int
+	Counted   int
+	Error     string // set when the SKU can't be matched to exactly one product or variant
+}
+
+// Variance is how far the count is from the quantity on record, negative for shrinkage
+func (l StocktakeLine) Variance() int {
+	return l.Counted - l.Expected
+}
+
+// stocktakeQuerier is what stocktakeLines needs, satisfied by a connection and a transaction
+type stocktakeQuerier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// stocktakeLines matches counted SKUs against products and variants, in SKU order. lock takes row
+// locks so the quantities can't change before the adjustment is written
+func stocktakeLines(q stocktakeQuerier, counts map[string]int, lock bool) ([]StocktakeLine, error) {
+	suffix := ""
+	if lock {
+		suffix = " FOR UPDATE"
+	}
+
+	skus := make([]string, 0, len(counts))
+	for sku := range counts {
+		skus = append(skus, sku)
+	}
+	sort.Strings(skus)
+
+	lines := make([]StocktakeLine, 0, len(skus))
+	for _, sku := range skus {
+		rows, err := q.Query(`
+			SELECT id, 0, name, IFNULL(inventory_quantity, 0) FROM products_unified WHERE sku = ?`+suffix, sku)
 		if err != nil {
 			return nil, err
 		}
-		if altText.Valid {
-			img.AltText = altText.String
-		}
-		if credit.Valid {
-			img.Credit = credit.String
-		}
-		if filename.Valid {
-			img.Filename = filename.String
+		matches, err := scanStocktakeMatches(rows, sku, counts[sku])
+		if err != nil {
+			return nil, err
 		}
-		if size.Valid {
-			img.Size = size.Int64
+
+		rows, err = q.Query(`
+			SELECT pv.product_id, pv.id, CONCAT(p.name, ' - ', IFNULL(pv.title, '')), IFNULL(pv.inventory_quantity, 0)
+			FROM product_variants pv
+			JOIN products_unified p ON p.id = pv.product_id
+			WHERE pv.sku = ?`+suffix, sku)
+		if err != nil {
+			return nil, err
 		}
-		if width.Valid {
-			img.Width = int(width.Int64)
+		variantMatches, err := scanStocktakeMatches(rows, sku, counts[sku])
+		if err != nil {
+			return nil, err
 		}
-		if height.Valid {
-			img.Height = int(height.Int64)
+		matches = append(matches, variantMatches...)
+
+		switch len(matches) {
+		case 1:
+			// A variant at -1 draws on the product's inventory, which is counted under the product's SKU
+			if matches[0].VariantID != 0 && matches[0].Expected == -1 {
+				matches[0].Expected = 0
+				matches[0].Error = "this variant shares the product's inventory, count the product's SKU instead"
+			}
+			lines = append(lines, matches[0])
+		case 0:
+			lines = append(lines, StocktakeLine{SKU: sku, Counted: counts[sku], Error: "no product or variant has this SKU"})
+		default:
+			lines = append(lines, StocktakeLine{SKU: sku, Counted: counts[sku], Error: fmt.Sprintf("%d products or variants share this SKU", len(matches))})
 		}
-		images = append(images, img)
 	}
 
-	return images, nil
+	return lines, nil
 }
 
-// CreateImage creates a new image entry
-func (s *AdminServer) CreateImage(websiteID string, img Image) (int64, error) {
-	db, err := s.GetWebsiteConnection(websiteID)
-	if err != nil {
-		return 0, err
-	}
-	defer db.Close()
+func scanStocktakeMatches(rows *sql.Rows, sku string, counted int) ([]StocktakeLine, error) {
+	defer rows.Close()
 
-	query := `INSERT INTO images_unified (url, alt_text, credit, filename, size, width, height) VALUES (?, ?, ?, ?, ?, ?, ?)`
-	result, err := db.Exec(query, img.URL, img.AltText, img.Credit, img.Filename, img.Size, img.Width, img.Height)
-	if err != nil {
-		return 0, err
+	var matches []StocktakeLine
+	for rows.Next() {
+		line := StocktakeLine{SKU: sku, Counted: counted}
+		if err := rows.Scan(&line.ProductID, &line.VariantID, &line.Name, &line.Expected); err != nil {
+			return nil, err
+		}
+		matches = append(matches, line)
 	}
-
-	return result.LastInsertId()
+	return matches, rows.Err()
 }
 
-// DeleteImage deletes an image
-func (s *AdminServer) DeleteImage(websiteID string, imageID int) error {
+// StocktakeVariance compares counted quantities, keyed by SKU, with inventory without changing anything
+func (s *AdminServer) StocktakeVariance(websiteID string, counts map[string]int) ([]StocktakeLine, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer db.Close()
 
-	query := `DELETE FROM images_unified WHERE id = ?`
-	_, err = db.Exec(query, imageID)
-	return err
-}
-
-// LogActivity logs an admin action (currently just to stdout, no DB needed)
-func (s *AdminServer) LogActivity(action, entityType string, entityID int, websiteID string, details interface{}) error {
-	// Just log to stdout for now - could write to a file later if needed
-	fmt.Printf("[ADMIN] %s %s (ID: %d, Website: %s)\n", action, entityType, entityID, websiteID)
-	return nil
-}
-
-// ====================
-// Product Images
-// ====================
-
-// ProductImage represents the product-image relationship
-type ProductImage struct {
-	ID       int    `json:"id"`
-	ImageID  int    `json:"imageId"`
-	Position int    `json:"position"`
-	Image    Image  `json:"image"`
+	return stocktakeLines(db, counts, false)
 }
 
-// ====================
-// Product-Collection Relationships
-// ====================
-
-// GetProductCollections retrieves all collections for a product
-func (s *AdminServer) GetProductCollections(websiteID string, productID int) ([]Collection, error) {
+// ApplyStocktake sets inventory to the counted quantities, keyed by SKU, in one transaction and
+// records each change in inventory_log with the reason "stocktake". SKUs that don't match exactly
+// one product or variant are returned with an Error and left alone
+func (s *AdminServer) ApplyStocktake(websiteID string, counts map[string]int) ([]StocktakeLine, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return nil, err
 	}
 	defer db.Close()
 
-	query := `
-		SELECT c.id, c.name, c.slug, c.status, c.sort_order
-		FROM collections_unified c
-		INNER JOIN product_collections pc ON c.id = pc.collection_id
-		WHERE pc.product_id = ?
-		ORDER BY pc.position
-	`
-	rows, err := db.Query(query, productID)
+	tx, err := db.Begin()
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var collections []Collection
-	for rows.Next() {
-		var c Collection
-		err := rows.Scan(&c.ID, &c.Name, &c.Slug, &c.Status, &c.SortOrder)
+	lines, err := stocktakeLines(tx, counts, true)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range lines {
+		if line.Error != "" || line.Variance() == 0 {
+			continue
+		}
+
+		var variantID interface{}
+		if line.VariantID != 0 {
+			_, err = tx.Exec(`UPDATE product_variants SET inventory_quantity = ? WHERE id = ?`, line.Counted, line.VariantID)
+			variantID = line.VariantID
+		} else {
+			_, err = tx.Exec(`UPDATE products_unified SET inventory_quantity = ? WHERE id = ?`, line.Counted, line.ProductID)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO inventory_log (product_id, variant_id, sku, delta, quantity_after, reason, created_at)
+			VALUES (?, ?, ?, ?, ?, 'stocktake', NOW())
+		`, line.ProductID, variantID, line.SKU, line.Variance(), line.Counted)
 		if err != nil {
 			return nil, err
 		}
-		collections = append(collections, c)
 	}
 
-	return collections, nil
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
 }
 
-// SetProductCollections replaces all collections for a product
-func (s *AdminServer) SetProductCollections(websiteID string, productID int, collectionIDs []int) error {
+// EachProductForExport calls fn for every product, in admin sort order, with the slugs of the
+// collections it belongs to. A non-empty collectionSlug limits it to that collection's products
+func (s *AdminServer) EachProductForExport(websiteID string, collectionSlug string, fn func(p Product, collections []string) error) error {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	// Start transaction
-	tx, err := db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	// Delete existing relationships
-	_, err = tx.Exec("DELETE FROM product_collections WHERE product_id = ?", productID)
-	if err != nil {
-		return err
+	query := `SELECT p.id, p.name, p.slug, p.description, p.price, p.sku, p.inventory_quantity, p.status,
+			IFNULL((SELECT GROUP_CONCAT(c.slug ORDER BY c.sort_order, c.name SEPARATOR '|')
+				FROM product_collections pc JOIN collections_unified c ON c.id = pc.collection_id
+				WHERE pc.product_id = p.id), '')
+		FROM products_unified p`
+	var args []interface{}
+	if collectionSlug != "" {
+		query += `
+		WHERE EXISTS (
+			SELECT 1 FROM product_collections pc JOIN collections_unified c ON c.id = pc.collection_id
+			WHERE pc.product_id = p.id AND c.slug = ?
+		)`
+		args = append(args, collectionSlug)
 	}
+	query += ` ORDER BY p.sort_order ASC, p.created_at DESC`
 
-	// Insert new relationships
-	stmt, err := tx.Prepare("INSERT INTO product_collections (product_id, collection_id, position) VALUES (?, ?, ?)")
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
+	defer rows.Close()
 
-	for position, collectionID := range collectionIDs {
-		_, err = stmt.Exec(productID, collectionID, position)
-		if err != nil {
+	for rows.Next() {
+		var p Product
+		var collections string
+		if err := rows.Scan(&p.ID, &p.Name, &p.Slug, &p.Description, &p.Price, &p.SKU, &p.InventoryQuantity, &p.Status, &collections); err != nil {
+			return err
+		}
+
+		var slugs []string
+		if collections != "" {
+			slugs = strings.Split(collections, "|")
+		}
+		if err := fn(p, slugs); err != nil {
 			return err
 		}
 	}
 
-	return tx.Commit()
+	return rows.Err()
 }
 
-// ====================
-// Article-Category Relationships
-// ====================
-
-// GetArticleCategories retrieves all categories for an article
-func (s *AdminServer) GetArticleCategories(websiteID string, articleID int) ([]Category, error) {
+// DeleteProduct deletes a product
+func (s *AdminServer) DeleteProduct(websiteID string, productID int) error {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer db.Close()
 
-	query := `
-		SELECT c.id, c.name, c.slug, c.count
-		FROM categories_unified c
-		INNER JOIN article_categories ac ON c.id = ac.category_id
-		WHERE ac.post_id = ?
-		ORDER BY c.name
-	`
-	rows, err := db.Query(query, articleID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var categories []Category
-	for rows.Next() {
-		var c Category
-		err := rows.Scan(&c.ID, &c.Name, &c.Slug, &c.Count)
-		if err != nil {
-			return nil, err
-		}
-		categories = append(categories, c)
+	if err := recordDeletion(db, database.ContentTypeProduct, "products_unified", productID); err != nil {
+		return err
 	}
 
-	return categories, nil
+	query := `DELETE FROM products_unified WHERE id = ?`
+	_, err = db.Exec(query, productID)
+	return err
 }
 
-// SetArticleCategories replaces all categories for an article
-func (s *AdminServer) SetArticleCategories(websiteID string, articleID int, categoryIDs []int) error {
+// ReorderProduct swaps the sort_order of a product with an adjacent product
+func (s *AdminServer) ReorderProduct(websiteID string, productID int, direction string) error {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	// Get old category IDs before we delete them
-	oldCategoryIDsQuery := "SELECT category_id FROM article_categories WHERE post_id = ?"
-	rows, err := db.Query(oldCategoryIDsQuery, articleID)
+	// Get current product's sort_order
+	var currentSortOrder int
+	err = db.QueryRow(`SELECT sort_order FROM products_unified WHERE id = ?`, productID).Scan(&currentSortOrder)
 	if err != nil {
 		return err
 	}
 
-	var oldCategoryIDs []int
-	for rows.Next() {
-		var catID int
-		if err := rows.Scan(&catID); err != nil {
-			rows.Close()
-			return err
-		}
-		oldCategoryIDs = append(oldCategoryIDs, catID)
+	var targetSortOrder int
+	var targetID int
+	if direction == "up" {
+		// Find the product with the next lower sort_order
+		err = db.QueryRow(`SELECT id, sort_order FROM products_unified WHERE sort_order < ? ORDER BY sort_order DESC LIMIT 1`, currentSortOrder).Scan(&targetID, &targetSortOrder)
+	} else if direction == "down" {
+		// Find the product with the next higher sort_order
+		err = db.QueryRow(`SELECT id, sort_order FROM products_unified WHERE sort_order > ? ORDER BY sort_order ASC LIMIT 1`, currentSortOrder).Scan(&targetID, &targetSortOrder)
 	}
-	rows.Close()
 
-	// Start transaction
-	tx, err := db.Begin()
-	if err != nil {
-		return err
+	if err == sql.ErrNoRows {
+		// Already at top/bottom, nothing to do - just return success
+		return nil
 	}
-	defer tx.Rollback()
-
-	// Delete existing relationships
-	_, err = tx.Exec("DELETE FROM article_categories WHERE post_id = ?", articleID)
 	if err != nil {
 		return err
 	}
 
-	// Insert new relationships
-	stmt, err := tx.Prepare("INSERT INTO article_categories (post_id, category_id) VALUES (?, ?)")
+	// Swap sort_order values
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
-
-	for _, categoryID := range categoryIDs {
-		_, err = stmt.Exec(articleID, categoryID)
-		if err != nil {
-			return err
-		}
-	}
+	defer tx.Rollback()
 
-	// Commit the transaction
-	if err := tx.Commit(); err != nil {
+	// Swap the two products' sort_order values
+	_, err = tx.Exec(`UPDATE products_unified SET sort_order = ? WHERE id = ?`, targetSortOrder, productID)
+	if err != nil {
 		return err
 	}
 
-	// Update counts for all affected categories (old and new)
-	affectedCategories := make(map[int]bool)
-	for _, id := range oldCategoryIDs {
-		affectedCategories[id] = true
-	}
-	for _, id := range categoryIDs {
-		affectedCategories[id] = true
-	}
-
-	// Update count for each affected category
-	for catID := range affectedCategories {
-		if err := s.UpdateCategoryCount(websiteID, catID); err != nil {
-			// Log the error but don't fail the whole operation
-			log.Printf("Error updating category count for category %d: %v", catID, err)
-		}
-	}
-
-	return nil
-}
-
-
-// UpdateCategoryCount recalculates the post count for a category
-func (s *AdminServer) UpdateCategoryCount(websiteID string, categoryID int) error {
-	db, err := s.GetWebsiteConnection(websiteID)
+	_, err = tx.Exec(`UPDATE products_unified SET sort_order = ? WHERE id = ?`, currentSortOrder, targetID)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
 
-	query := `
-		UPDATE categories_unified
-		SET count = (
-			SELECT COUNT(*)
-			FROM article_categories
-			WHERE category_id = ?
-		)
-		WHERE id = ?
-	`
-	_, err = db.Exec(query, categoryID, categoryID)
-	return err
+	return tx.Commit()
 }
 
-// GetOrders retrieves all orders for a website
-func (s *AdminServer) GetOrders(websiteID string) ([]Order, error) {
+// Helper function to load variants for a product
+func (s *AdminServer) getProductVariants(websiteID string, productID int) ([]structs.ProductVariant, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return nil, err
@@ -2136,493 +3069,437 @@ func (s *AdminServer) GetOrders(websiteID string) ([]Order, error) {
 	defer db.Close()
 
 	query := `
-		SELECT
-			id, order_number, customer_email, customer_name,
-			shipping_address_line1, shipping_address_line2,
-			shipping_city, shipping_state, shipping_zip, shipping_country,
-			subtotal, tax, shipping_cost, total,
-			payment_status, fulfillment_status, payment_method,
-			created_at, updated_at
-		FROM orders
-		ORDER BY created_at DESC
+		SELECT id, product_id, title, price_modifier, sku, inventory_quantity, IFNULL(max_per_order, 0), position
+		FROM product_variants
+		WHERE product_id = ?
+		ORDER BY position ASC
 	`
 
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, productID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var orders []Order
+	var variants []structs.ProductVariant
 	for rows.Next() {
-		var o Order
-		var shippingLine2, paymentMethod sql.NullString
+		var variant structs.ProductVariant
+		var sku sql.NullString
 
 		err := rows.Scan(
-			&o.ID, &o.OrderNumber, &o.CustomerEmail, &o.CustomerName,
-			&o.ShippingAddressLine1, &shippingLine2,
-			&o.ShippingCity, &o.ShippingState, &o.ShippingZip, &o.ShippingCountry,
-			&o.Subtotal, &o.Tax, &o.ShippingCost, &o.Total,
-			&o.PaymentStatus, &o.FulfillmentStatus, &paymentMethod,
-			&o.CreatedAt, &o.UpdatedAt,
+			&variant.ID,
+			&variant.ProductID,
+			&variant.Title,
+			&variant.PriceModifier,
+			&sku,
+			&variant.InventoryQuantity,
+			&variant.MaxPerOrder,
+			&variant.Position,
 		)
 		if err != nil {
-			return nil, err
+			continue
 		}
 
-		o.ShippingAddressLine2 = shippingLine2.String
-		o.PaymentMethod = paymentMethod.String
+		variant.SKU = sku.String
 
-		orders = append(orders, o)
+		variants = append(variants, variant)
 	}
 
-	return orders, nil
+	return variants, nil
 }
 
-// GetOrdersFiltered retrieves orders with filters and sorting
-func (s *AdminServer) GetOrdersFiltered(websiteID string, filters OrderFilters) ([]Order, error) {
+// Variant management functions
+func (s *AdminServer) CreateVariant(websiteID string, productID int, data map[string]interface{}) error {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer db.Close()
 
+	// Get the max position for this product
+	var maxPosition int
+	err = db.QueryRow(`SELECT COALESCE(MAX(position), 0) FROM product_variants WHERE product_id = ?`, productID).Scan(&maxPosition)
+	if err != nil {
+		maxPosition = 0
+	}
+
 	query := `
-		SELECT
-			id, order_number, customer_email, customer_name,
-			shipping_address_line1, shipping_address_line2,
-			shipping_city, shipping_state, shipping_zip, shipping_country,
-			subtotal, tax, shipping_cost, total,
-			payment_status, fulfillment_status, payment_method,
-			created_at, updated_at
-		FROM orders
-		WHERE 1=1
+		INSERT INTO product_variants (
+			product_id, title, price_modifier, sku, inventory_quantity, max_per_order, position
+		) VALUES (?, ?, ?, ?, ?, ?, ?)
 	`
 
-	var args []interface{}
-
-	// Add payment status filter
-	if filters.PaymentStatus != "" {
-		query += " AND payment_status = ?"
-		args = append(args, filters.PaymentStatus)
-	}
-
-	// Add fulfillment status filter
-	if filters.FulfillmentStatus != "" {
-		query += " AND fulfillment_status = ?"
-		args = append(args, filters.FulfillmentStatus)
-	}
+	_, err = db.Exec(query,
+		productID,
+		data["title"],
+		data["priceModifier"],
+		data["sku"],
+		data["inventoryQuantity"],
+		data["maxPerOrder"],
+		maxPosition+1,
+	)
 
-	// Add sorting
-	switch filters.Sort {
-	case "date_asc":
-		query += " ORDER BY created_at ASC"
-	case "total_desc":
-		query += " ORDER BY total DESC"
-	case "total_asc":
-		query += " ORDER BY total ASC"
-	default: // date_desc
-		query += " ORDER BY created_at DESC"
-	}
+	return err
+}
 
-	rows, err := db.Query(query, args...)
+func (s *AdminServer) GetVariant(websiteID string, variantID int) (structs.ProductVariant, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return nil, err
+		return structs.ProductVariant{}, err
 	}
-	defer rows.Close()
+	defer db.Close()
 
-	var orders []Order
-	for rows.Next() {
-		var o Order
-		var shippingLine2, paymentMethod sql.NullString
+	query := `
+		SELECT id, product_id, title, price_modifier, sku, inventory_quantity, IFNULL(max_per_order, 0), position
+		FROM product_variants
+		WHERE id = ?
+	`
 
-		err := rows.Scan(
-			&o.ID, &o.OrderNumber, &o.CustomerEmail, &o.CustomerName,
-			&o.ShippingAddressLine1, &shippingLine2,
-			&o.ShippingCity, &o.ShippingState, &o.ShippingZip, &o.ShippingCountry,
-			&o.Subtotal, &o.Tax, &o.ShippingCost, &o.Total,
-			&o.PaymentStatus, &o.FulfillmentStatus, &paymentMethod,
-			&o.CreatedAt, &o.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
+	var variant structs.ProductVariant
+	var sku sql.NullString
 
-		o.ShippingAddressLine2 = shippingLine2.String
-		o.PaymentMethod = paymentMethod.String
+	err = db.QueryRow(query, variantID).Scan(
+		&variant.ID,
+		&variant.ProductID,
+		&variant.Title,
+		&variant.PriceModifier,
+		&sku,
+		&variant.InventoryQuantity,
+		&variant.MaxPerOrder,
+		&variant.Position,
+	)
 
-		orders = append(orders, o)
+	if err != nil {
+		return structs.ProductVariant{}, err
 	}
 
-	return orders, nil
+	variant.SKU = sku.String
+
+	return variant, nil
 }
 
-// GetOrder retrieves a single order with its items
-func (s *AdminServer) GetOrder(websiteID string, orderID int) (Order, error) {
+func (s *AdminServer) UpdateVariant(websiteID string, variantID int, data map[string]interface{}) error {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return Order{}, err
+		return err
 	}
 	defer db.Close()
 
 	query := `
-		SELECT
-			id, order_number, customer_email, customer_name,
-			shipping_address_line1, shipping_address_line2,
-			shipping_city, shipping_state, shipping_zip, shipping_country,
-			subtotal, tax, shipping_cost, total,
-			payment_status, fulfillment_status, payment_method,
-			stripe_payment_intent_id, refunded_amount, shipping_label_cost,
-			tracking_number, shipping_carrier, shipping_label_url, shippo_transaction_id,
-			created_at, updated_at
-		FROM orders
+		UPDATE product_variants
+		SET title = ?, price_modifier = ?, sku = ?, inventory_quantity = ?, max_per_order = ?
 		WHERE id = ?
 	`
 
-	var o Order
-	var shippingLine2, paymentMethod, stripeIntent, trackingNum, carrier, labelURL, shippoTxID sql.NullString
-	var labelCost sql.NullFloat64
-
-	err = db.QueryRow(query, orderID).Scan(
-		&o.ID, &o.OrderNumber, &o.CustomerEmail, &o.CustomerName,
-		&o.ShippingAddressLine1, &shippingLine2,
-		&o.ShippingCity, &o.ShippingState, &o.ShippingZip, &o.ShippingCountry,
-		&o.Subtotal, &o.Tax, &o.ShippingCost, &o.Total,
-		&o.PaymentStatus, &o.FulfillmentStatus, &paymentMethod,
-		&stripeIntent, &o.RefundedAmount, &labelCost,
-		&trackingNum, &carrier, &labelURL, &shippoTxID,
-		&o.CreatedAt, &o.UpdatedAt,
+	_, err = db.Exec(query,
+		data["title"],
+		data["priceModifier"],
+		data["sku"],
+		data["inventoryQuantity"],
+		data["maxPerOrder"],
+		variantID,
 	)
-	if err != nil {
-		return Order{}, err
-	}
-
-	o.ShippingAddressLine2 = shippingLine2.String
-	o.PaymentMethod = paymentMethod.String
-	o.StripePaymentIntent = stripeIntent.String
-	if labelCost.Valid {
-		o.ShippingLabelCost = &labelCost.Float64
-	}
-	o.TrackingNumber = trackingNum.String
-	o.ShippingCarrier = carrier.String
-	o.ShippingLabelURL = labelURL.String
-	o.ShippoTransactionID = shippoTxID.String
-
-	// Get order items
-	itemsQuery := `
-		SELECT id, product_id, variant_id, product_name, variant_title, quantity, price, total
-		FROM order_items
-		WHERE order_id = ?
-	`
-
-	rows, err := db.Query(itemsQuery, orderID)
-	if err != nil {
-		return o, err
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var item OrderItem
-		var variantID sql.NullInt64
-		var variantTitle sql.NullString
-
-		err := rows.Scan(
-			&item.ID, &item.ProductID, &variantID, &item.ProductName, &variantTitle,
-			&item.Quantity, &item.Price, &item.Total,
-		)
-		if err != nil {
-			return o, err
-		}
-
-		if variantID.Valid {
-			item.VariantID = int(variantID.Int64)
-		}
-		item.VariantTitle = variantTitle.String
-		o.Items = append(o.Items, item)
-	}
 
-	return o, nil
+	return err
 }
 
-// UpdateOrderFulfillmentStatus updates the fulfillment status of an order
-func (s *AdminServer) UpdateOrderFulfillmentStatus(websiteID string, orderID int, status string) error {
+func (s *AdminServer) DeleteVariant(websiteID string, variantID int) error {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	query := `UPDATE orders SET fulfillment_status = ?, updated_at = NOW() WHERE id = ?`
-	_, err = db.Exec(query, status, orderID)
+	query := `DELETE FROM product_variants WHERE id = ?`
+	_, err = db.Exec(query, variantID)
 	return err
 }
 
-// LabelInfo contains information about a purchased shipping label
-type LabelInfo struct {
-	TrackingNumber string  `json:"trackingNumber"`
-	LabelURL       string  `json:"labelUrl"`
-	Carrier        string  `json:"carrier"`
-	Cost           float64 `json:"cost"`
-}
-
-// GetShippingRates gets shipping rates from Shippo for an order
-func (s *AdminServer) GetShippingRates(websiteID string, order Order, length, width, height, weight float64) ([]shippo.Rate, error) {
-	// Get website config
-	website, err := s.GetWebsite(websiteID)
+// ReorderVariant moves a variant up or down in the list
+func (s *AdminServer) ReorderVariant(websiteID string, variantID int, direction string) error {
+	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer db.Close()
 
-	// Load website config to get ship-from address
-	configPath := filepath.Join("websites", website.Directory, "config-dev.json")
-	configData, err := ioutil.ReadFile(configPath)
+	// Get current variant's position and product_id
+	var currentPosition, productID int
+	err = db.QueryRow(`SELECT position, product_id FROM product_variants WHERE id = ?`, variantID).Scan(&currentPosition, &productID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read website config: %w", err)
+		return err
 	}
 
-	var siteConfig configs.WebsiteConfig
-	if err := json.Unmarshal(configData, &siteConfig); err != nil {
-		return nil, fmt.Errorf("failed to parse website config: %w", err)
+	var targetPosition int
+	var targetID int
+	if direction == "up" {
+		// Find the variant with the next lower position (same product)
+		err = db.QueryRow(`SELECT id, position FROM product_variants WHERE product_id = ? AND position < ? ORDER BY position DESC LIMIT 1`, productID, currentPosition).Scan(&targetID, &targetPosition)
+	} else if direction == "down" {
+		// Find the variant with the next higher position (same product)
+		err = db.QueryRow(`SELECT id, position FROM product_variants WHERE product_id = ? AND position > ? ORDER BY position ASC LIMIT 1`, productID, currentPosition).Scan(&targetID, &targetPosition)
 	}
 
-	// Get Shippo API key from site config
-	shippoKey := siteConfig.Shippo.APIKey
-
-	// Create Shippo client
-	client := shippo.NewClient(shippoKey)
-
-	// Build addresses
-	fromAddress := shippo.Address{
-		Name:    siteConfig.ShipFrom.Name,
-		Street1: siteConfig.ShipFrom.Street1,
-		Street2: siteConfig.ShipFrom.Street2,
-		City:    siteConfig.ShipFrom.City,
-		State:   siteConfig.ShipFrom.State,
-		Zip:     siteConfig.ShipFrom.Zip,
-		Country: siteConfig.ShipFrom.Country,
+	if err == sql.ErrNoRows {
+		// Already at top/bottom, nothing to do
+		return nil
+	}
+	if err != nil {
+		return err
 	}
 
-	toAddress := shippo.Address{
-		Name:    order.CustomerName,
-		Street1: order.ShippingAddressLine1,
-		Street2: order.ShippingAddressLine2,
-		City:    order.ShippingCity,
-		State:   order.ShippingState,
-		Zip:     order.ShippingZip,
-		Country: order.ShippingCountry,
-		Email:   order.CustomerEmail,
+	// Swap position values
+	tx, err := db.Begin()
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	parcel := shippo.Parcel{
-		Length:       fmt.Sprintf("%.2f", length),
-		Width:        fmt.Sprintf("%.2f", width),
-		Height:       fmt.Sprintf("%.2f", height),
-		DistanceUnit: "in",
-		Weight:       fmt.Sprintf("%.2f", weight),
-		MassUnit:     "lb",
+	_, err = tx.Exec(`UPDATE product_variants SET position = ? WHERE id = ?`, targetPosition, variantID)
+	if err != nil {
+		return err
 	}
 
-	// Get rates from Shippo
-	shipmentResp, err := client.GetRates(fromAddress, toAddress, parcel)
+	_, err = tx.Exec(`UPDATE product_variants SET position = ? WHERE id = ?`, currentPosition, targetID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return shipmentResp.Rates, nil
+	return tx.Commit()
 }
 
-// PurchaseShippingLabel purchases a shipping label from Shippo and updates the order
-func (s *AdminServer) PurchaseShippingLabel(websiteID string, orderID int, rateID string) (*LabelInfo, error) {
-	// Get website config for site-specific Shippo key
-	website, err := s.GetWebsite(websiteID)
+// GetCategories retrieves categories for a specific website
+func (s *AdminServer) GetCategories(websiteID string) ([]Category, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return nil, err
 	}
+	defer db.Close()
 
-	// Get Shippo API key from site config
-	shippoKey := website.ShippoAPIKey
-
-	// Get label format - use site-specific if available, otherwise default to PDF
-	labelFormat := "PDF"
-	if website.LabelFormat != "" {
-		labelFormat = website.LabelFormat
-	}
-
-	// Create Shippo client
-	client := shippo.NewClient(shippoKey)
+	query := `SELECT c.id, c.name, c.slug, IFNULL(c.parent_id, 0), IFNULL(p.name, ''), c.count, c.created_at, c.updated_at
+		FROM categories_unified c
+		LEFT JOIN categories_unified p ON p.id = c.parent_id
+		ORDER BY c.name`
 
-	// Purchase label
-	transaction, err := client.PurchaseLabel(rateID, labelFormat)
+	rows, err := db.Query(query)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	// Extract cost from rate amount
-	cost := 0.0
-	fmt.Sscanf(transaction.Rate, "%f", &cost)
+	categories := []Category{}
+	for rows.Next() {
+		var c Category
+		err := rows.Scan(&c.ID, &c.Name, &c.Slug, &c.ParentID, &c.ParentName, &c.Count, &c.CreatedAt, &c.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
 
-	// Update order in database with label information
+	return categories, nil
+}
+
+// CreateCategory creates a new category
+func (s *AdminServer) CreateCategory(websiteID string, c Category) (int64, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 	defer db.Close()
 
-	// Determine carrier from transaction
-	carrier := "USPS" // Default, would need to parse from rate details
+	var parentID interface{}
+	if c.ParentID > 0 {
+		parentID = c.ParentID
+	}
 
-	query := `
-		UPDATE orders
-		SET tracking_number = ?,
-		    shipping_carrier = ?,
-		    shipping_label_url = ?,
-		    shipping_label_cost = ?,
-		    shippo_transaction_id = ?,
-		    updated_at = NOW()
-		WHERE id = ?
-	`
+	query := `INSERT INTO categories_unified (name, slug, parent_id) VALUES (?, ?, ?)`
+	result, err := db.Exec(query, c.Name, c.Slug, parentID)
+	if err != nil {
+		return 0, err
+	}
 
-	_, err = db.Exec(query, transaction.TrackingNumber, carrier, transaction.LabelURL, cost, transaction.ObjectID, orderID)
+	return result.LastInsertId()
+}
+
+// DeleteCategory deletes a category
+func (s *AdminServer) DeleteCategory(websiteID string, categoryID int) error {
+	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer db.Close()
 
-	return &LabelInfo{
-		TrackingNumber: transaction.TrackingNumber,
-		LabelURL:       transaction.LabelURL,
-		Carrier:        carrier,
-		Cost:           cost,
-	}, nil
+	// Promote children to the deleted category's parent so they don't become orphans
+	_, err = db.Exec(`
+		UPDATE categories_unified c
+		JOIN categories_unified d ON d.id = ?
+		SET c.parent_id = d.parent_id
+		WHERE c.parent_id = ?
+	`, categoryID, categoryID)
+	if err != nil {
+		return err
+	}
+
+	query := `DELETE FROM categories_unified WHERE id = ?`
+	_, err = db.Exec(query, categoryID)
+	return err
 }
 
-// GetProductImagesData retrieves all images for a product from product_images_data
-func (s *AdminServer) GetProductImagesData(websiteID string, productID int) ([]ProductImageData, error) {
+// GetCollections retrieves collections for a specific website
+func (s *AdminServer) GetCollections(websiteID string) ([]Collection, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return nil, err
 	}
 	defer db.Close()
 
-	query := `
-		SELECT id, product_id, url, filename, filepath, alt_text, credit, size, width, height, position, created_at
-		FROM product_images_data
-		WHERE product_id = ?
-		ORDER BY position ASC
-	`
+	query := `SELECT id, name, slug, description, image_id, sort_order, status, created_at, updated_at
+		FROM collections_unified ORDER BY sort_order, name`
 
-	rows, err := db.Query(query, productID)
+	rows, err := db.Query(query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var images []ProductImageData
+	collections := []Collection{}
 	for rows.Next() {
-		var img ProductImageData
-		var altText, credit sql.NullString
-		var size sql.NullInt64
-		var width, height sql.NullInt64
-
-		err := rows.Scan(&img.ID, &img.ProductID, &img.URL, &img.Filename, &img.Filepath,
-			&altText, &credit, &size, &width, &height, &img.Position, &img.CreatedAt)
+		var c Collection
+		err := rows.Scan(&c.ID, &c.Name, &c.Slug, &c.Description, &c.ImageID, &c.SortOrder, &c.Status, &c.CreatedAt, &c.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
-
-		if altText.Valid {
-			img.AltText = altText.String
-		}
-		if credit.Valid {
-			img.Credit = credit.String
-		}
-		if size.Valid {
-			img.Size = size.Int64
-		}
-		if width.Valid {
-			img.Width = int(width.Int64)
-		}
-		if height.Valid {
-			img.Height = int(height.Int64)
-		}
-
-		images = append(images, img)
+		collections = append(collections, c)
 	}
 
-	return images, nil
+	return collections, nil
 }
 
-// AddProductImageData adds a new image directly to a product
-func (s *AdminServer) AddProductImageData(websiteID string, img ProductImageData) (int64, error) {
+// CreateCollection creates a new collection at the top and pushes others down
+func (s *AdminServer) CreateCollection(websiteID string, c Collection) (int64, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return 0, err
 	}
 	defer db.Close()
 
-	query := `INSERT INTO product_images_data (product_id, url, filename, filepath, alt_text, credit, size, width, height, position)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	// Start transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
 
-	result, err := db.Exec(query, img.ProductID, img.URL, img.Filename, img.Filepath,
-		img.AltText, img.Credit, img.Size, img.Width, img.Height, img.Position)
+	// Increment all existing collections' sort_order by 1
+	_, err = tx.Exec(`UPDATE collections_unified SET sort_order = sort_order + 1`)
+	if err != nil {
+		return 0, err
+	}
+
+	// Insert new collection with sort_order = 0 (top position)
+	query := `INSERT INTO collections_unified (name, slug, description, image_id, sort_order, status)
+		VALUES (?, ?, ?, ?, 0, ?)`
+
+	result, err := tx.Exec(query, c.Name, c.Slug, c.Description, c.ImageID, c.Status)
 	if err != nil {
 		return 0, err
 	}
 
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
 	return result.LastInsertId()
 }
 
-// DeleteProductImageData deletes an image and its file from disk
-func (s *AdminServer) DeleteProductImageData(websiteID string, imageID int) error {
+// GetCollection retrieves a single collection by ID
+func (s *AdminServer) GetCollection(websiteID string, collectionID int) (Collection, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return err
+		return Collection{}, err
 	}
 	defer db.Close()
 
-	// First get the filepath
-	var filepath string
-	err = db.QueryRow("SELECT filepath FROM product_images_data WHERE id = ?", imageID).Scan(&filepath)
+	query := `SELECT id, name, slug, description, image_id, sort_order, status, created_at, updated_at
+		FROM collections_unified WHERE id = ?`
+
+	var c Collection
+	var imageID sql.NullInt64
+	err = db.QueryRow(query, collectionID).Scan(
+		&c.ID, &c.Name, &c.Slug, &c.Description, &imageID, &c.SortOrder, &c.Status, &c.CreatedAt, &c.UpdatedAt,
+	)
 	if err != nil {
-		return err
+		return Collection{}, err
 	}
 
-	// Delete from database
-	_, err = db.Exec("DELETE FROM product_images_data WHERE id = ?", imageID)
+	if imageID.Valid {
+		c.ImageID = int(imageID.Int64)
+	}
+
+	return c, nil
+}
+
+// UpdateCollection updates an existing collection
+func (s *AdminServer) UpdateCollection(websiteID string, c Collection) error {
+	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return err
 	}
+	defer db.Close()
 
-	// Delete file from disk
-	if err := os.Remove(filepath); err != nil {
-		log.Printf("Warning: Failed to delete image file %s: %v", filepath, err)
-		// Don't fail the operation if file doesn't exist
-	}
+	query := `UPDATE collections_unified SET name = ?, slug = ?, description = ?, image_id = ?, sort_order = ?, status = ?
+		WHERE id = ?`
 
-	return nil
+	_, err = db.Exec(query, c.Name, c.Slug, c.Description, c.ImageID, c.SortOrder, c.Status, c.ID)
+	return err
 }
 
-// UpdateProductImagePositions updates the position values for reordering
-func (s *AdminServer) UpdateProductImagePositions(websiteID string, imageIDs []int) error {
+// DeleteCollection deletes a collection and renumbers the remaining ones
+func (s *AdminServer) DeleteCollection(websiteID string, collectionID int) error {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
+	// Start transaction
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare("UPDATE product_images_data SET position = ? WHERE id = ?")
+	// Delete the collection
+	if err := recordDeletion(tx, database.ContentTypeCollection, "collections_unified", collectionID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM collections_unified WHERE id = ?`, collectionID)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 
-	for position, imageID := range imageIDs {
-		_, err = stmt.Exec(position, imageID)
+	// Renumber all collections sequentially starting from 0
+	// Get all collections ordered by current sort_order
+	rows, err := tx.Query(`SELECT id FROM collections_unified ORDER BY sort_order, name`)
+	if err != nil {
+		return err
+	}
+
+	var collectionIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		collectionIDs = append(collectionIDs, id)
+	}
+	rows.Close()
+
+	// Update each collection with sequential sort_order
+	for i, id := range collectionIDs {
+		_, err = tx.Exec(`UPDATE collections_unified SET sort_order = ? WHERE id = ?`, i, id)
 		if err != nil {
 			return err
 		}
@@ -2631,194 +3508,196 @@ func (s *AdminServer) UpdateProductImagePositions(websiteID string, imageIDs []i
 	return tx.Commit()
 }
 
-// ====================
-// Customer Management
-// ====================
-
-// GetCustomers retrieves all customers with aggregate statistics
-func (s *AdminServer) GetCustomers(websiteID string, filters CustomerFilters) ([]Customer, error) {
+// ReorderCollection moves a collection up or down in sort order
+func (s *AdminServer) ReorderCollection(websiteID string, collectionID int, direction string) error {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer db.Close()
 
-	query := `
-		SELECT
-			c.id, c.email, c.stripe_customer_id, c.first_name, c.last_name, c.phone,
-			c.created_at, c.updated_at,
-			COUNT(CASE WHEN o.payment_status = 'paid' THEN 1 END) as order_count,
-			COALESCE(SUM(CASE WHEN o.payment_status = 'paid' THEN o.total ELSE 0 END), 0) as total_spent,
-			MIN(CASE WHEN o.payment_status = 'paid' THEN o.created_at END) as first_order,
-			MAX(CASE WHEN o.payment_status = 'paid' THEN o.created_at END) as last_order
-		FROM customers c
-		LEFT JOIN orders o ON c.id = o.customer_id
-		GROUP BY c.id, c.email, c.stripe_customer_id, c.first_name, c.last_name, c.phone, c.created_at, c.updated_at
-	`
+	// Get current collection's sort_order
+	var currentSortOrder int
+	err = db.QueryRow(`SELECT sort_order FROM collections_unified WHERE id = ?`, collectionID).Scan(&currentSortOrder)
+	if err != nil {
+		return err
+	}
 
-	// Add sorting
-	switch filters.Sort {
-	case "total_desc":
-		query += " ORDER BY total_spent DESC"
-	case "total_asc":
-		query += " ORDER BY total_spent ASC"
-	case "orders_desc":
-		query += " ORDER BY order_count DESC"
-	case "date_asc":
-		query += " ORDER BY c.created_at ASC"
-	case "date_desc":
-		query += " ORDER BY c.created_at DESC"
-	default: // Default to total spent descending
-		query += " ORDER BY total_spent DESC"
+	var targetSortOrder int
+	var targetID int
+	if direction == "up" {
+		// Find the collection with the next lower sort_order
+		err = db.QueryRow(`SELECT id, sort_order FROM collections_unified WHERE sort_order < ? ORDER BY sort_order DESC LIMIT 1`, currentSortOrder).Scan(&targetID, &targetSortOrder)
+	} else if direction == "down" {
+		// Find the collection with the next higher sort_order
+		err = db.QueryRow(`SELECT id, sort_order FROM collections_unified WHERE sort_order > ? ORDER BY sort_order ASC LIMIT 1`, currentSortOrder).Scan(&targetID, &targetSortOrder)
 	}
 
-	rows, err := db.Query(query)
+	if err == sql.ErrNoRows {
+		// Already at top/bottom, nothing to do - just return success
+		return nil
+	}
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	var customers []Customer
-	for rows.Next() {
-		var c Customer
-		var stripeCustomerID, phone sql.NullString
-		var firstOrder, lastOrder sql.NullTime
+	// Swap sort_order values
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-		err := rows.Scan(
-			&c.ID, &c.Email, &stripeCustomerID, &c.FirstName, &c.LastName, &phone,
-			&c.CreatedAt, &c.UpdatedAt,
-			&c.OrderCount, &c.TotalSpent, &firstOrder, &lastOrder,
-		)
-		if err != nil {
-			return nil, err
-		}
+	// Swap the two collections' sort_order values
+	_, err = tx.Exec(`UPDATE collections_unified SET sort_order = ? WHERE id = ?`, targetSortOrder, collectionID)
+	if err != nil {
+		return err
+	}
 
-		if stripeCustomerID.Valid {
-			c.StripeCustomerID = stripeCustomerID.String
-		}
-		if phone.Valid {
-			c.Phone = phone.String
-		}
-		if firstOrder.Valid {
-			c.FirstOrder = &firstOrder.Time
-		}
-		if lastOrder.Valid {
-			c.LastOrder = &lastOrder.Time
-		}
-
-		customers = append(customers, c)
+	_, err = tx.Exec(`UPDATE collections_unified SET sort_order = ? WHERE id = ?`, currentSortOrder, targetID)
+	if err != nil {
+		return err
 	}
 
-	return customers, nil
+	return tx.Commit()
 }
 
-// GetCustomer retrieves a single customer with statistics
-func (s *AdminServer) GetCustomer(websiteID string, customerID int) (Customer, error) {
+// GetImages retrieves images for a specific website
+func (s *AdminServer) GetImages(websiteID string, limit, offset int) ([]Image, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return Customer{}, err
+		return nil, err
 	}
 	defer db.Close()
 
-	query := `
-		SELECT
-			c.id, c.email, c.stripe_customer_id, c.first_name, c.last_name, c.phone,
-			c.created_at, c.updated_at,
-			COUNT(CASE WHEN o.payment_status = 'paid' THEN 1 END) as order_count,
-			COALESCE(SUM(CASE WHEN o.payment_status = 'paid' THEN o.total ELSE 0 END), 0) as total_spent,
-			MIN(CASE WHEN o.payment_status = 'paid' THEN o.created_at END) as first_order,
-			MAX(CASE WHEN o.payment_status = 'paid' THEN o.created_at END) as last_order
-		FROM customers c
-		LEFT JOIN orders o ON c.id = o.customer_id
-		WHERE c.id = ?
-		GROUP BY c.id, c.email, c.stripe_customer_id, c.first_name, c.last_name, c.phone, c.created_at, c.updated_at
-	`
-
-	var c Customer
-	var stripeCustomerID, phone sql.NullString
-	var firstOrder, lastOrder sql.NullTime
+	query := `SELECT id, url, alt_text, credit, filename, size, width, height, created_at
+		FROM images_unified ORDER BY created_at DESC LIMIT ? OFFSET ?`
 
-	err = db.QueryRow(query, customerID).Scan(
-		&c.ID, &c.Email, &stripeCustomerID, &c.FirstName, &c.LastName, &phone,
-		&c.CreatedAt, &c.UpdatedAt,
-		&c.OrderCount, &c.TotalSpent, &firstOrder, &lastOrder,
-	)
+	rows, err := db.Query(query, limit, offset)
 	if err != nil {
-		return Customer{}, err
+		return nil, err
 	}
+	defer rows.Close()
 
-	if stripeCustomerID.Valid {
-		c.StripeCustomerID = stripeCustomerID.String
-	}
-	if phone.Valid {
-		c.Phone = phone.String
-	}
-	if firstOrder.Valid {
-		c.FirstOrder = &firstOrder.Time
-	}
-	if lastOrder.Valid {
-		c.LastOrder = &lastOrder.Time
+	images := []Image{}
+	for rows.Next() {
+		var img Image
+		var filename, altText, credit sql.NullString
+		var size sql.NullInt64
+		var width, height sql.NullInt64
+		err := rows.Scan(&img.ID, &img.URL, &altText, &credit, &filename, &size, &width, &height, &img.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if altText.Valid {
+			img.AltText = altText.String
+		}
+		if credit.Valid {
+			img.Credit = credit.String
+		}
+		if filename.Valid {
+			img.Filename = filename.String
+		}
+		if size.Valid {
+			img.Size = size.Int64
+		}
+		if width.Valid {
+			img.Width = int(width.Int64)
+		}
+		if height.Valid {
+			img.Height = int(height.Int64)
+		}
+		images = append(images, img)
 	}
 
-	return c, nil
+	return images, nil
 }
 
-// GetCustomerByEmail retrieves a customer by email address with statistics
-func (s *AdminServer) GetCustomerByEmail(websiteID string, email string) (*Customer, error) {
+// CreateImage creates a new image entry
+func (s *AdminServer) CreateImage(websiteID string, img Image) (int64, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 	defer db.Close()
 
-	query := `
-		SELECT
-			c.id, c.email, c.stripe_customer_id, c.first_name, c.last_name, c.phone,
-			c.created_at, c.updated_at,
-			COUNT(CASE WHEN o.payment_status = 'paid' THEN 1 END) as order_count,
-			COALESCE(SUM(CASE WHEN o.payment_status = 'paid' THEN o.total ELSE 0 END), 0) as total_spent,
-			MIN(CASE WHEN o.payment_status = 'paid' THEN o.created_at END) as first_order,
-			MAX(CASE WHEN o.payment_status = 'paid' THEN o.created_at END) as last_order
-		FROM customers c
-		LEFT JOIN orders o ON c.id = o.customer_id
-		WHERE c.email = ?
-		GROUP BY c.id, c.email, c.stripe_customer_id, c.first_name, c.last_name, c.phone, c.created_at, c.updated_at
-	`
+	query := `INSERT INTO images_unified (url, alt_text, credit, filename, size, width, height) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	result, err := db.Exec(query, img.URL, img.AltText, img.Credit, img.Filename, img.Size, img.Width, img.Height)
+	if err != nil {
+		return 0, err
+	}
 
-	var c Customer
-	var stripeCustomerID, phone sql.NullString
-	var firstOrder, lastOrder sql.NullTime
+	return result.LastInsertId()
+}
 
-	err = db.QueryRow(query, email).Scan(
-		&c.ID, &c.Email, &stripeCustomerID, &c.FirstName, &c.LastName, &phone,
-		&c.CreatedAt, &c.UpdatedAt,
-		&c.OrderCount, &c.TotalSpent, &firstOrder, &lastOrder,
-	)
+// DeleteImage deletes an image and its file from the upload backend
+func (s *AdminServer) DeleteImage(websiteID string, imageID int) error {
+	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // No customer found, not an error
-		}
-		return nil, err
+		return err
 	}
+	defer db.Close()
 
-	if stripeCustomerID.Valid {
-		c.StripeCustomerID = stripeCustomerID.String
+	var imageURL string
+	err = db.QueryRow("SELECT url FROM images_unified WHERE id = ?", imageID).Scan(&imageURL)
+	if err != nil {
+		return err
 	}
-	if phone.Valid {
-		c.Phone = phone.String
+
+	query := `DELETE FROM images_unified WHERE id = ?`
+	if _, err = db.Exec(query, imageID); err != nil {
+		return err
 	}
-	if firstOrder.Valid {
-		c.FirstOrder = &firstOrder.Time
+
+	s.deleteUpload(websiteID, imageURL)
+	return nil
+}
+
+// deleteUpload removes an uploaded file from the upload backend. Failures are only logged since
+// the image record is already gone
+func (s *AdminServer) deleteUpload(websiteID, fileURL string) {
+	website, err := s.GetWebsite(websiteID)
+	if err != nil {
+		log.Printf("Warning: Failed to delete upload %s: %v", fileURL, err)
+		return
 	}
-	if lastOrder.Valid {
-		c.LastOrder = &lastOrder.Time
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := s.uploads.Delete(ctx, uploadSite(website), fileURL); err != nil {
+		log.Printf("Warning: Failed to delete upload %s: %v", fileURL, err)
 	}
+	if err := media.DeletePrivateUpload(uploadSite(website), fileURL); err != nil {
+		log.Printf("Warning: Failed to delete private upload %s: %v", fileURL, err)
+	}
+}
 
-	return &c, nil
+// LogActivity logs an admin action (currently just to stdout, no DB needed)
+func (s *AdminServer) LogActivity(action, entityType string, entityID int, websiteID string, details interface{}) error {
+	// Just log to stdout for now - could write to a file later if needed
+	fmt.Printf("[ADMIN] %s %s (ID: %d, Website: %s)\n", action, entityType, entityID, websiteID)
+	return nil
 }
 
-// GetCustomerOrders retrieves all orders for a specific customer
-func (s *AdminServer) GetCustomerOrders(websiteID string, customerID int) ([]Order, error) {
+// ====================
+// Product Images
+// ====================
+
+// ProductImage represents the product-image relationship
+type ProductImage struct {
+	ID       int    `json:"id"`
+	ImageID  int    `json:"imageId"`
+	Position int    `json:"position"`
+	Image    Image  `json:"image"`
+}
+
+// ====================
+// Product-Collection Relationships
+// ====================
+
+// GetProductCollections retrieves all collections for a product
+func (s *AdminServer) GetProductCollections(websiteID string, productID int) ([]Collection, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return nil, err
@@ -2826,296 +3705,210 @@ func (s *AdminServer) GetCustomerOrders(websiteID string, customerID int) ([]Ord
 	defer db.Close()
 
 	query := `
-		SELECT
-			id, order_number, customer_email, customer_name,
-			shipping_address_line1, shipping_address_line2,
-			shipping_city, shipping_state, shipping_zip, shipping_country,
-			subtotal, tax, shipping_cost, total,
-			payment_status, fulfillment_status, payment_method,
-			created_at, updated_at
-		FROM orders
-		WHERE customer_id = ?
-		ORDER BY created_at DESC
+		SELECT c.id, c.name, c.slug, c.status, c.sort_order
+		FROM collections_unified c
+		INNER JOIN product_collections pc ON c.id = pc.collection_id
+		WHERE pc.product_id = ?
+		ORDER BY pc.position
 	`
-
-	rows, err := db.Query(query, customerID)
+	rows, err := db.Query(query, productID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var orders []Order
+	var collections []Collection
 	for rows.Next() {
-		var o Order
-		var shippingLine2, paymentMethod sql.NullString
-
-		err := rows.Scan(
-			&o.ID, &o.OrderNumber, &o.CustomerEmail, &o.CustomerName,
-			&o.ShippingAddressLine1, &shippingLine2,
-			&o.ShippingCity, &o.ShippingState, &o.ShippingZip, &o.ShippingCountry,
-			&o.Subtotal, &o.Tax, &o.ShippingCost, &o.Total,
-			&o.PaymentStatus, &o.FulfillmentStatus, &paymentMethod,
-			&o.CreatedAt, &o.UpdatedAt,
-		)
+		var c Collection
+		err := rows.Scan(&c.ID, &c.Name, &c.Slug, &c.Status, &c.SortOrder)
 		if err != nil {
 			return nil, err
 		}
-
-		o.ShippingAddressLine2 = shippingLine2.String
-		o.PaymentMethod = paymentMethod.String
-
-		orders = append(orders, o)
+		collections = append(collections, c)
 	}
 
-	return orders, nil
+	return collections, nil
 }
 
-// GetSMSSignups retrieves SMS signups for a website with filters
-func (s *AdminServer) GetSMSSignups(websiteID string, filters SMSSignupFilters) ([]SMSSignup, error) {
+// SetProductCollections replaces all collections for a product
+func (s *AdminServer) SetProductCollections(websiteID string, productID int, collectionIDs []int) error {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer db.Close()
 
-	query := `
-		SELECT id, COALESCE(country_code, '+1'), phone, COALESCE(email, ''), COALESCE(source, ''), created_at
-		FROM sms_signups
-		WHERE 1=1
-	`
-
-	var args []interface{}
+	// Start transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	// Filter by country code
-	if filters.CountryCode != "" {
-		query += ` AND country_code = ?`
-		args = append(args, filters.CountryCode)
+	// Delete existing relationships
+	_, err = tx.Exec("DELETE FROM product_collections WHERE product_id = ?", productID)
+	if err != nil {
+		return err
 	}
 
-	// Filter by source
-	if filters.Source != "" {
-		query += ` AND source = ?`
-		args = append(args, filters.Source)
-	}
-
-	// Filter by date range
-	if filters.DateFrom != "" {
-		query += ` AND created_at >= ?`
-		args = append(args, filters.DateFrom+" 00:00:00")
-	}
-	if filters.DateTo != "" {
-		query += ` AND created_at <= ?`
-		args = append(args, filters.DateTo+" 23:59:59")
-	}
-
-	// Sorting
-	switch filters.Sort {
-	case "date_asc":
-		query += ` ORDER BY created_at ASC`
-	case "phone_asc":
-		query += ` ORDER BY phone ASC`
-	case "phone_desc":
-		query += ` ORDER BY phone DESC`
-	default:
-		query += ` ORDER BY created_at DESC`
-	}
-
-	rows, err := db.Query(query, args...)
+	// Insert new relationships
+	stmt, err := tx.Prepare("INSERT INTO product_collections (product_id, collection_id, position) VALUES (?, ?, ?)")
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	defer stmt.Close()
 
-	var signups []SMSSignup
-	for rows.Next() {
-		var s SMSSignup
-		err := rows.Scan(&s.ID, &s.CountryCode, &s.Phone, &s.Email, &s.Source, &s.CreatedAt)
+	for position, collectionID := range collectionIDs {
+		_, err = stmt.Exec(productID, collectionID, position)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		signups = append(signups, s)
 	}
 
-	return signups, nil
+	return tx.Commit()
 }
 
-// GetUniqueCountryCodes retrieves unique country codes from SMS signups
-func (s *AdminServer) GetUniqueCountryCodes(websiteID string) ([]string, error) {
+// ====================
+// Article-Category Relationships
+// ====================
+
+// GetArticleCategories retrieves all categories for an article
+func (s *AdminServer) GetArticleCategories(websiteID string, articleID int) ([]Category, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return nil, err
 	}
 	defer db.Close()
 
-	query := `SELECT DISTINCT country_code FROM sms_signups WHERE country_code IS NOT NULL ORDER BY country_code`
-	rows, err := db.Query(query)
+	query := `
+		SELECT c.id, c.name, c.slug, c.count
+		FROM categories_unified c
+		INNER JOIN article_categories ac ON c.id = ac.category_id
+		WHERE ac.post_id = ?
+		ORDER BY c.name
+	`
+	rows, err := db.Query(query, articleID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var codes []string
+	var categories []Category
 	for rows.Next() {
-		var code string
-		if err := rows.Scan(&code); err != nil {
+		var c Category
+		err := rows.Scan(&c.ID, &c.Name, &c.Slug, &c.Count)
+		if err != nil {
 			return nil, err
 		}
-		codes = append(codes, code)
+		categories = append(categories, c)
 	}
 
-	return codes, nil
+	return categories, nil
 }
 
-// GetUniqueSources retrieves unique sources from SMS signups
-func (s *AdminServer) GetUniqueSources(websiteID string) ([]string, error) {
+// SetArticleCategories replaces all categories for an article
+func (s *AdminServer) SetArticleCategories(websiteID string, articleID int, categoryIDs []int) error {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer db.Close()
 
-	query := `SELECT DISTINCT source FROM sms_signups WHERE source IS NOT NULL AND source != '' ORDER BY source`
-	rows, err := db.Query(query)
+	// Get old category IDs before we delete them
+	oldCategoryIDsQuery := "SELECT category_id FROM article_categories WHERE post_id = ?"
+	rows, err := db.Query(oldCategoryIDsQuery, articleID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	var sources []string
+	var oldCategoryIDs []int
 	for rows.Next() {
-		var source string
-		if err := rows.Scan(&source); err != nil {
-			return nil, err
+		var catID int
+		if err := rows.Scan(&catID); err != nil {
+			rows.Close()
+			return err
 		}
-		sources = append(sources, source)
+		oldCategoryIDs = append(oldCategoryIDs, catID)
 	}
+	rows.Close()
 
-	return sources, nil
-}
-
-// DeleteSMSSignup deletes an SMS signup by ID
-func (s *AdminServer) DeleteSMSSignup(websiteID string, signupID int) error {
-	db, err := s.GetWebsiteConnection(websiteID)
+	// Start transaction
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-
-	query := `DELETE FROM sms_signups WHERE id = ?`
-	_, err = db.Exec(query, signupID)
-	return err
-}
+	defer tx.Rollback()
 
-// GetVerifiedSMSSignups retrieves only verified SMS signups with filters
-func (s *AdminServer) GetVerifiedSMSSignups(websiteID string, filters SMSSignupFilters) ([]SMSSignup, error) {
-	db, err := s.GetWebsiteConnection(websiteID)
+	// Delete existing relationships
+	_, err = tx.Exec("DELETE FROM article_categories WHERE post_id = ?", articleID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer db.Close()
-
-	query := `
-		SELECT id, COALESCE(country_code, '+1'), phone, COALESCE(email, ''), COALESCE(source, ''), created_at
-		FROM sms_signups
-		WHERE verified = 1 AND unsubscribed = 0
-	`
 
-	var args []interface{}
-
-	// Filter by country code
-	if filters.CountryCode != "" {
-		query += ` AND country_code = ?`
-		args = append(args, filters.CountryCode)
+	// Insert new relationships
+	stmt, err := tx.Prepare("INSERT INTO article_categories (post_id, category_id) VALUES (?, ?)")
+	if err != nil {
+		return err
 	}
+	defer stmt.Close()
 
-	// Filter by source
-	if filters.Source != "" {
-		query += ` AND source = ?`
-		args = append(args, filters.Source)
+	for _, categoryID := range categoryIDs {
+		_, err = stmt.Exec(articleID, categoryID)
+		if err != nil {
+			return err
+		}
 	}
 
-	// Filter by date range
-	if filters.DateFrom != "" {
-		query += ` AND created_at >= ?`
-		args = append(args, filters.DateFrom+" 00:00:00")
-	}
-	if filters.DateTo != "" {
-		query += ` AND created_at <= ?`
-		args = append(args, filters.DateTo+" 23:59:59")
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return err
 	}
 
-	// Sorting
-	switch filters.Sort {
-	case "date_asc":
-		query += ` ORDER BY created_at ASC`
-	case "phone_asc":
-		query += ` ORDER BY phone ASC`
-	case "phone_desc":
-		query += ` ORDER BY phone DESC`
-	default:
-		query += ` ORDER BY created_at DESC`
+	// Update counts for all affected categories (old and new)
+	affectedCategories := make(map[int]bool)
+	for _, id := range oldCategoryIDs {
+		affectedCategories[id] = true
 	}
-
-	rows, err := db.Query(query, args...)
-	if err != nil {
-		return nil, err
+	for _, id := range categoryIDs {
+		affectedCategories[id] = true
 	}
-	defer rows.Close()
 
-	var signups []SMSSignup
-	for rows.Next() {
-		var s SMSSignup
-		err := rows.Scan(&s.ID, &s.CountryCode, &s.Phone, &s.Email, &s.Source, &s.CreatedAt)
-		if err != nil {
-			return nil, err
+	// Update count for each affected category
+	for catID := range affectedCategories {
+		if err := s.UpdateCategoryCount(websiteID, catID); err != nil {
+			// Log the error but don't fail the whole operation
+			log.Printf("Error updating category count for category %d: %v", catID, err)
 		}
-		signups = append(signups, s)
 	}
 
-	return signups, nil
+	return nil
 }
 
-// ===============================
-// Analytics Queries
-// ===============================
 
-// GetPageViewStats returns basic pageview statistics for a date range
-func (s *AdminServer) GetPageViewStats(websiteID string, startDate, endDate time.Time) (map[string]interface{}, error) {
+// UpdateCategoryCount recalculates the published post count for a category
+func (s *AdminServer) UpdateCategoryCount(websiteID string, categoryID int) error {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer db.Close()
 
-	stats := make(map[string]interface{})
-
-	// Total pageviews
-	var totalViews int
-	err = db.QueryRow(`
-		SELECT COUNT(*) FROM analytics_pageviews
-		WHERE created_at BETWEEN ? AND ?
-	`, startDate, endDate).Scan(&totalViews)
-	if err != nil {
-		return nil, err
-	}
-	stats["total_views"] = totalViews
-
-	// Unique sessions
-	var uniqueSessions int
-	err = db.QueryRow(`
-		SELECT COUNT(DISTINCT session_id) FROM analytics_pageviews
-		WHERE created_at BETWEEN ? AND ?
-	`, startDate, endDate).Scan(&uniqueSessions)
-	if err != nil {
-		return nil, err
-	}
-	stats["unique_sessions"] = uniqueSessions
-
-	return stats, nil
+	query := `
+		UPDATE categories_unified
+		SET count = (
+			SELECT COUNT(*)
+			FROM article_categories ac
+			JOIN articles_unified a ON a.id = ac.post_id
+			WHERE ac.category_id = ? AND a.status = 'published'
+		)
+		WHERE id = ?
+	`
+	_, err = db.Exec(query, categoryID, categoryID)
+	return err
 }
 
-// GetTopPages returns the most visited pages for a date range
-func (s *AdminServer) GetTopPages(websiteID string, startDate, endDate time.Time, limit int) ([]map[string]interface{}, error) {
+// GetOrders retrieves all orders for a website
+func (s *AdminServer) GetOrders(websiteID string) ([]Order, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return nil, err
@@ -3123,1388 +3916,5292 @@ func (s *AdminServer) GetTopPages(websiteID string, startDate, endDate time.Time
 	defer db.Close()
 
 	query := `
-		SELECT path, COUNT(*) as views, COUNT(DISTINCT session_id) as unique_visitors
-		FROM analytics_pageviews
-		WHERE created_at BETWEEN ? AND ?
-		GROUP BY path
-		ORDER BY views DESC
-		LIMIT ?
+		SELECT
+			id, order_number, customer_email, customer_name,
+			shipping_address_line1, shipping_address_line2,
+			shipping_city, shipping_state, shipping_zip, shipping_country,
+			subtotal, tax, shipping_cost, total,
+			payment_status, fulfillment_status, payment_method, risk_score,
+			created_at, updated_at
+		FROM orders
+		ORDER BY created_at DESC
 	`
 
-	rows, err := db.Query(query, startDate, endDate, limit)
+	rows, err := db.Query(query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var pages []map[string]interface{}
+	var orders []Order
 	for rows.Next() {
-		var path string
-		var views, uniqueVisitors int
-		err := rows.Scan(&path, &views, &uniqueVisitors)
+		var o Order
+		var shippingLine2, paymentMethod sql.NullString
+
+		err := rows.Scan(
+			&o.ID, &o.OrderNumber, &o.CustomerEmail, &o.CustomerName,
+			&o.ShippingAddressLine1, &shippingLine2,
+			&o.ShippingCity, &o.ShippingState, &o.ShippingZip, &o.ShippingCountry,
+			&o.Subtotal, &o.Tax, &o.ShippingCost, &o.Total,
+			&o.PaymentStatus, &o.FulfillmentStatus, &paymentMethod, &o.RiskScore,
+			&o.CreatedAt, &o.UpdatedAt,
+		)
 		if err != nil {
 			return nil, err
 		}
-		pages = append(pages, map[string]interface{}{
-			"path":            path,
-			"views":           views,
-			"unique_visitors": uniqueVisitors,
-		})
+
+		o.ShippingAddressLine2 = shippingLine2.String
+		o.PaymentMethod = paymentMethod.String
+
+		orders = append(orders, o)
 	}
 
-	return pages, nil
+	return orders, nil
 }
 
-// GetTopReferrers returns the top referrers for a date range
-func (s *AdminServer) GetTopReferrers(websiteID string, startDate, endDate time.Time, limit int) ([]map[string]interface{}, error) {
-	db, err := s.GetWebsiteConnection(websiteID)
-	if err != nil {
-		return nil, err
-	}
-	defer db.Close()
+// defaultStaleOrderDays is how long a paid order can go unshipped before it needs attention, when
+// the site doesn't set ecommerce.staleOrderDays
+const defaultStaleOrderDays = 3
 
-	query := `
-		SELECT referrer, COUNT(*) as visits
-		FROM analytics_pageviews
-		WHERE created_at BETWEEN ? AND ?
-		AND referrer IS NOT NULL
-		AND referrer != ''
-		GROUP BY referrer
-		ORDER BY visits DESC
-		LIMIT ?
-	`
+// defaultRiskReviewScore is the risk score that flags an order for review when
+// ecommerce.risk.reviewScore isn't set
+const defaultRiskReviewScore = 50
 
-	rows, err := db.Query(query, startDate, endDate, limit)
-	if err != nil {
-		return nil, err
+// RiskReviewThreshold returns the risk score that flags the site's orders for review
+func (w Website) RiskReviewThreshold() int {
+	if w.RiskReviewScore > 0 {
+		return w.RiskReviewScore
 	}
-	defer rows.Close()
+	return defaultRiskReviewScore
+}
 
-	var referrers []map[string]interface{}
-	for rows.Next() {
-		var referrer string
-		var visits int
-		err := rows.Scan(&referrer, &visits)
-		if err != nil {
-			return nil, err
-		}
-		referrers = append(referrers, map[string]interface{}{
-			"referrer": referrer,
-			"visits":   visits,
-		})
+// defaultOrderDigestHours is how often the order digest goes out when
+// ecommerce.orderDigest.intervalHours isn't set
+const defaultOrderDigestHours = 24
+
+// orderDigestGrace reaches back before the last digest for orders placed then but only paid after
+// it was sent, so they aren't left out of both
+const orderDigestGrace = 24 * time.Hour
+
+// OrderDigestInterval returns how long the site waits between order digests
+func (w Website) OrderDigestInterval() time.Duration {
+	if w.OrderDigestIntervalHours > 0 {
+		return time.Duration(w.OrderDigestIntervalHours) * time.Hour
 	}
+	return defaultOrderDigestHours * time.Hour
+}
 
-	return referrers, nil
+// emailConfig is the part of the site config the email service needs to send as the site
+func (w Website) emailConfig() *configs.WebsiteConfig {
+	config := &configs.WebsiteConfig{SiteName: w.SiteName}
+	config.Email.FromAddress = w.EmailFromAddress
+	config.Email.FromName = w.EmailFromName
+	config.Email.ReplyTo = w.EmailReplyTo
+	config.Email.SMTP.Server = w.SMTPServer
+	config.Email.SMTP.Port = w.SMTPPort
+	config.Email.SMTP.Username = w.SMTPUsername
+	config.Email.SMTP.Password = w.SMTPPassword
+	config.Email.SMTP.UseTLS = w.SMTPUseTLS
+	config.Ecommerce.Currency = w.Currency
+	return config
 }
 
-// GetEventStats returns statistics for custom events in a date range
-func (s *AdminServer) GetEventStats(websiteID string, startDate, endDate time.Time, limit int) ([]map[string]interface{}, error) {
-	db, err := s.GetWebsiteConnection(websiteID)
+// RunOrderDigest emails the admin the paid and authorized orders placed since the last digest once
+// the site's digest interval has passed. The first run only records a starting point, since orders
+// before then were notified one by one. A digest with no new orders is recorded without an email
+func (s *AdminServer) RunOrderDigest(ctx context.Context, website Website) error {
+	db, err := s.GetWebsiteConnection(website.ID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer db.Close()
 
-	query := `
-		SELECT event_name, COUNT(*) as count
-		FROM analytics_events
-		WHERE created_at BETWEEN ? AND ?
-		GROUP BY event_name
-		ORDER BY count DESC
-		LIMIT ?
-	`
+	var first, last sql.NullTime
+	err = db.QueryRowContext(ctx, `SELECT MIN(sent_at), MAX(sent_at) FROM order_digests`).Scan(&first, &last)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	interval := website.OrderDigestInterval()
+	if !last.Valid {
+		_, err = db.ExecContext(ctx, `INSERT INTO order_digests (order_count, total, sent_at) VALUES (0, 0, ?)`, now)
+		return err
+	}
+	if now.Sub(last.Time) < interval {
+		return nil
+	}
+
+	// Don't reach back past the start of digests, or past a single interval when digests were off
+	// for a while and those orders were notified one by one
+	since := last.Time.Add(-orderDigestGrace)
+	if floor := now.Add(-interval - orderDigestGrace); since.Before(floor) {
+		since = floor
+	}
+	if since.Before(first.Time) {
+		since = first.Time
+	}
 
-	rows, err := db.Query(query, startDate, endDate, limit)
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, order_number, customer_name, customer_email, total, created_at
+		FROM orders
+		WHERE payment_status IN ('paid', 'authorized') AND digest_id IS NULL AND created_at >= ?
+		ORDER BY created_at
+	`, since)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	var events []map[string]interface{}
+	var orderIDs []interface{}
+	var orders []email.DigestOrder
+	var total float64
 	for rows.Next() {
-		var eventName string
-		var count int
-		err := rows.Scan(&eventName, &count)
-		if err != nil {
-			return nil, err
+		var id int
+		var order email.DigestOrder
+		if err := rows.Scan(&id, &order.OrderNumber, &order.CustomerName, &order.CustomerEmail, &order.Total, &order.CreatedAt); err != nil {
+			rows.Close()
+			return err
 		}
-		events = append(events, map[string]interface{}{
-			"event_name": eventName,
-			"count":      count,
-		})
+		if publicURL := strings.TrimRight(s.EnvConfig.Admin.PublicURL, "/"); publicURL != "" {
+			order.URL = publicURL + s.adminURL("/site/%s/orders/%d", website.ID, id)
+		}
+		orderIDs = append(orderIDs, id)
+		orders = append(orders, order)
+		total = utils.RoundMoney(total+order.Total, "")
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
 	}
 
-	return events, nil
-}
+	if len(orders) > 0 {
+		emailService, err := email.NewEmailService()
+		if err != nil {
+			return err
+		}
+		if err := emailService.SendAdminOrderDigest(website.emailConfig(), orders); err != nil {
+			return fmt.Errorf("failed to send order digest: %w", err)
+		}
+	}
 
-// ===============================
-// Real-Time Analytics
-// ===============================
+	result, err := db.ExecContext(ctx, `INSERT INTO order_digests (order_count, total, sent_at) VALUES (?, ?, ?)`, len(orders), total, now)
+	if err != nil {
+		return err
+	}
+	if len(orderIDs) == 0 {
+		return nil
+	}
 
-// GetActiveUsers returns count of users active in the last N minutes
-func (s *AdminServer) GetActiveUsers(websiteID string, minutesAgo int) (int, error) {
-	db, err := s.GetWebsiteConnection(websiteID)
+	digestID, err := result.LastInsertId()
 	if err != nil {
-		return 0, err
+		return err
 	}
-	defer db.Close()
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(orderIDs)), ", ")
+	_, err = db.ExecContext(ctx, `UPDATE orders SET digest_id = ? WHERE id IN (`+placeholders+`)`, append([]interface{}{digestID}, orderIDs...)...)
+	return err
+}
 
-	cutoffTime := time.Now().Add(-time.Duration(minutesAgo) * time.Minute)
+// failedPaymentAttentionDays limits failed payments in the attention list to recent ones
+const failedPaymentAttentionDays = 14
 
-	query := `
-		SELECT COUNT(DISTINCT session_id) as active_users
-		FROM (
-			SELECT session_id, MAX(created_at) as last_activity
-			FROM analytics_pageviews
-			WHERE created_at >= ?
-			GROUP BY session_id
-			UNION
-			SELECT session_id, MAX(created_at) as last_activity
-			FROM analytics_events
-			WHERE created_at >= ?
-			GROUP BY session_id
-		) as combined
-	`
+// Reasons an order needs attention, most urgent first
+const (
+	attentionStaleUnshipped = iota
+	attentionDeliveryFailed
+	attentionReturned
+	attentionPaymentFailed
+)
 
-	var activeUsers int
-	err = db.QueryRow(query, cutoffTime, cutoffTime).Scan(&activeUsers)
+// OrderNeedingAttention is an order support should look at, and why
+type OrderNeedingAttention struct {
+	Order
+	Reason      string
+	Urgency     int // Lower is more urgent
+	DaysWaiting int // Days since the order was placed
+}
+
+// GetOrdersNeedingAttention lists problem orders, most urgent first: paid (or authorized) orders
+// unshipped for longer than the site's staleOrderDays, deliveries that failed, returns that
+// haven't been refunded, and recent failed payments. Within a reason, older orders come first
+func (s *AdminServer) GetOrdersNeedingAttention(websiteID string) ([]OrderNeedingAttention, error) {
+	website, err := s.GetWebsite(websiteID)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	staleDays := website.StaleOrderDays
+	if staleDays <= 0 {
+		staleDays = defaultStaleOrderDays
 	}
 
-	return activeUsers, nil
-}
-
-// GetCurrentPages returns pages currently being viewed by active users
-func (s *AdminServer) GetCurrentPages(websiteID string, minutesAgo int) ([]map[string]interface{}, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return nil, err
 	}
 	defer db.Close()
 
-	cutoffTime := time.Now().Add(-time.Duration(minutesAgo) * time.Minute)
-
-	// Get sessions with activity (pageviews or heartbeats) in the last N minutes
-	// and find their most recent page
 	query := `
-		SELECT p.path, COUNT(DISTINCT p.session_id) as active_viewers
-		FROM (
-			SELECT session_id, MAX(last_activity) as last_activity
-			FROM (
-				SELECT session_id, MAX(created_at) as last_activity
-				FROM analytics_pageviews
-				WHERE created_at >= ?
-				GROUP BY session_id
-
-				UNION ALL
-
-				SELECT session_id, MAX(created_at) as last_activity
-				FROM analytics_events
-				WHERE event_name = 'heartbeat' AND created_at >= ?
-				GROUP BY session_id
-			) combined
-			GROUP BY session_id
-			HAVING MAX(last_activity) >= ?
-		) active_sessions
-		JOIN (
-			SELECT session_id, path, created_at,
-				ROW_NUMBER() OVER (PARTITION BY session_id ORDER BY created_at DESC) as rn
-			FROM analytics_pageviews
-		) p ON active_sessions.session_id = p.session_id AND p.rn = 1
-		GROUP BY p.path
-		ORDER BY active_viewers DESC
-		LIMIT 20
+		SELECT
+			id, order_number, customer_email, customer_name,
+			subtotal, tax, shipping_cost, total,
+			payment_status, fulfillment_status, payment_method,
+			created_at, updated_at
+		FROM orders
+		WHERE (payment_status IN ('paid', 'authorized')
+				AND fulfillment_status IN ('unfulfilled', 'processing')
+				AND created_at < NOW() - INTERVAL ? DAY)
+			OR fulfillment_status = 'failed'
+			OR (fulfillment_status = 'returned' AND payment_status <> 'refunded')
+			OR (payment_status = 'failed' AND created_at >= NOW() - INTERVAL ? DAY)
+		ORDER BY created_at ASC
+		LIMIT 500
 	`
 
-	rows, err := db.Query(query, cutoffTime, cutoffTime, cutoffTime)
+	rows, err := db.Query(query, staleDays, failedPaymentAttentionDays)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var pages []map[string]interface{}
+	var orders []OrderNeedingAttention
 	for rows.Next() {
-		var path string
-		var viewers int
-		err := rows.Scan(&path, &viewers)
+		var o OrderNeedingAttention
+		var paymentMethod sql.NullString
+
+		err := rows.Scan(
+			&o.ID, &o.OrderNumber, &o.CustomerEmail, &o.CustomerName,
+			&o.Subtotal, &o.Tax, &o.ShippingCost, &o.Total,
+			&o.PaymentStatus, &o.FulfillmentStatus, &paymentMethod,
+			&o.CreatedAt, &o.UpdatedAt,
+		)
 		if err != nil {
 			return nil, err
 		}
-		pages = append(pages, map[string]interface{}{
-			"path":    path,
-			"viewers": viewers,
-		})
+		o.PaymentMethod = paymentMethod.String
+		o.DaysWaiting = int(time.Since(o.CreatedAt).Hours() / 24)
+
+		switch {
+		case o.FulfillmentStatus == "failed":
+			o.Urgency, o.Reason = attentionDeliveryFailed, "Delivery failed"
+		case o.FulfillmentStatus == "returned":
+			o.Urgency, o.Reason = attentionReturned, "Returned, not refunded"
+		case o.PaymentStatus == "failed":
+			o.Urgency, o.Reason = attentionPaymentFailed, "Payment failed"
+		default:
+			o.Urgency, o.Reason = attentionStaleUnshipped, fmt.Sprintf("Not shipped after %d days", o.DaysWaiting)
+		}
+
+		orders = append(orders, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return pages, nil
-}
+	// Rows come oldest first, so a stable sort keeps that order within each reason
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].Urgency < orders[j].Urgency
+	})
 
-// ===============================
-// Engagement Metrics
-// ===============================
+	return orders, nil
+}
 
-// GetBounceRate returns the bounce rate (single-page sessions) for a date range
-func (s *AdminServer) GetBounceRate(websiteID string, startDate, endDate time.Time) (float64, error) {
+// GetOrdersFiltered retrieves orders with filters and sorting
+func (s *AdminServer) GetOrdersFiltered(websiteID string, filters OrderFilters) ([]Order, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	defer db.Close()
 
 	query := `
 		SELECT
-			COUNT(DISTINCT CASE WHEN pageview_count = 1 THEN session_id END) as bounced_sessions,
-			COUNT(DISTINCT session_id) as total_sessions
-		FROM (
-			SELECT session_id, COUNT(*) as pageview_count
-			FROM analytics_pageviews
-			WHERE created_at BETWEEN ? AND ?
-			GROUP BY session_id
-		) as session_stats
+			id, order_number, customer_email, customer_name,
+			shipping_address_line1, shipping_address_line2,
+			shipping_city, shipping_state, shipping_zip, shipping_country,
+			subtotal, tax, shipping_cost, total,
+			payment_status, fulfillment_status, payment_method, risk_score,
+			created_at, updated_at
+		FROM orders
+		WHERE 1=1
 	`
 
-	var bouncedSessions, totalSessions int
-	err = db.QueryRow(query, startDate, endDate).Scan(&bouncedSessions, &totalSessions)
-	if err != nil {
-		return 0, err
-	}
+	var args []interface{}
 
-	if totalSessions == 0 {
-		return 0, nil
+	// Add payment status filter
+	if filters.PaymentStatus != "" {
+		query += " AND payment_status = ?"
+		args = append(args, filters.PaymentStatus)
 	}
 
-	bounceRate := (float64(bouncedSessions) / float64(totalSessions)) * 100
-	return bounceRate, nil
-}
+	// Add fulfillment status filter
+	if filters.FulfillmentStatus != "" {
+		query += " AND fulfillment_status = ?"
+		args = append(args, filters.FulfillmentStatus)
+	}
 
-// GetAverageSessionDuration returns average session duration in seconds
-func (s *AdminServer) GetAverageSessionDuration(websiteID string, startDate, endDate time.Time) (float64, error) {
-	db, err := s.GetWebsiteConnection(websiteID)
-	if err != nil {
-		return 0, err
-	}
-	defer db.Close()
-
-	query := `
-		SELECT AVG(duration) as avg_duration
-		FROM (
-			SELECT
-				session_id,
-				SUM(time_on_page) as duration
-			FROM analytics_pageviews
-			WHERE created_at BETWEEN ? AND ?
-			GROUP BY session_id
-			HAVING SUM(time_on_page) > 0
-		) as session_durations
-	`
-
-	var avgDuration sql.NullFloat64
-	err = db.QueryRow(query, startDate, endDate).Scan(&avgDuration)
-	if err != nil {
-		return 0, err
-	}
-
-	if !avgDuration.Valid {
-		return 0, nil
-	}
-
-	return avgDuration.Float64, nil
-}
-
-// GetDeviceBreakdown returns breakdown of traffic by device type
-func (s *AdminServer) GetDeviceBreakdown(websiteID string, startDate, endDate time.Time) (map[string]int, error) {
-	db, err := s.GetWebsiteConnection(websiteID)
-	if err != nil {
-		return nil, err
+	// Add sorting
+	switch filters.Sort {
+	case "date_asc":
+		query += " ORDER BY created_at ASC"
+	case "total_desc":
+		query += " ORDER BY total DESC"
+	case "total_asc":
+		query += " ORDER BY total ASC"
+	default: // date_desc
+		query += " ORDER BY created_at DESC"
 	}
-	defer db.Close()
-
-	// Categorize by screen width since we track that
-	query := `
-		SELECT
-			CASE
-				WHEN screen_width < 768 THEN 'mobile'
-				WHEN screen_width < 1024 THEN 'tablet'
-				ELSE 'desktop'
-			END as device_type,
-			COUNT(DISTINCT session_id) as sessions
-		FROM analytics_pageviews
-		WHERE created_at BETWEEN ? AND ?
-		GROUP BY device_type
-	`
 
-	rows, err := db.Query(query, startDate, endDate)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	breakdown := make(map[string]int)
+	var orders []Order
 	for rows.Next() {
-		var deviceType string
-		var sessions int
-		err := rows.Scan(&deviceType, &sessions)
+		var o Order
+		var shippingLine2, paymentMethod sql.NullString
+
+		err := rows.Scan(
+			&o.ID, &o.OrderNumber, &o.CustomerEmail, &o.CustomerName,
+			&o.ShippingAddressLine1, &shippingLine2,
+			&o.ShippingCity, &o.ShippingState, &o.ShippingZip, &o.ShippingCountry,
+			&o.Subtotal, &o.Tax, &o.ShippingCost, &o.Total,
+			&o.PaymentStatus, &o.FulfillmentStatus, &paymentMethod, &o.RiskScore,
+			&o.CreatedAt, &o.UpdatedAt,
+		)
 		if err != nil {
 			return nil, err
 		}
-		breakdown[deviceType] = sessions
+
+		o.ShippingAddressLine2 = shippingLine2.String
+		o.PaymentMethod = paymentMethod.String
+
+		orders = append(orders, o)
 	}
 
-	return breakdown, nil
+	return orders, nil
 }
 
-// GetEntryPages returns the top pages where users enter the site
-func (s *AdminServer) GetEntryPages(websiteID string, startDate, endDate time.Time, limit int) ([]map[string]interface{}, error) {
+// GetOrder retrieves a single order with its items
+func (s *AdminServer) GetOrder(websiteID string, orderID int) (Order, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return nil, err
+		return Order{}, err
 	}
 	defer db.Close()
 
 	query := `
-		SELECT path, COUNT(*) as entries
-		FROM (
-			SELECT session_id, path,
-				ROW_NUMBER() OVER (PARTITION BY session_id ORDER BY created_at ASC) as rn
-			FROM analytics_pageviews
-			WHERE created_at BETWEEN ? AND ?
-		) as first_pages
-		WHERE rn = 1
-		GROUP BY path
-		ORDER BY entries DESC
-		LIMIT ?
+		SELECT
+			id, order_number, customer_email, customer_name,
+			shipping_address_line1, shipping_address_line2,
+			shipping_city, shipping_state, shipping_zip, shipping_country,
+			subtotal, tax, shipping_cost, total,
+			payment_status, fulfillment_status, payment_method,
+			stripe_payment_intent_id, refunded_amount, shipping_label_cost,
+			tracking_number, shipping_carrier, shipping_label_url, shippo_transaction_id,
+			risk_score, COALESCE(risk_reasons, '[]'), ships_on, store_credit,
+			created_at, updated_at
+		FROM orders
+		WHERE id = ?
 	`
 
-	rows, err := db.Query(query, startDate, endDate, limit)
+	var o Order
+	var shippingLine2, paymentMethod, stripeIntent, trackingNum, carrier, labelURL, shippoTxID sql.NullString
+	var labelCost sql.NullFloat64
+	var riskReasons string
+	var shipsOn sql.NullTime
+
+	err = db.QueryRow(query, orderID).Scan(
+		&o.ID, &o.OrderNumber, &o.CustomerEmail, &o.CustomerName,
+		&o.ShippingAddressLine1, &shippingLine2,
+		&o.ShippingCity, &o.ShippingState, &o.ShippingZip, &o.ShippingCountry,
+		&o.Subtotal, &o.Tax, &o.ShippingCost, &o.Total,
+		&o.PaymentStatus, &o.FulfillmentStatus, &paymentMethod,
+		&stripeIntent, &o.RefundedAmount, &labelCost,
+		&trackingNum, &carrier, &labelURL, &shippoTxID,
+		&o.RiskScore, &riskReasons, &shipsOn, &o.StoreCredit,
+		&o.CreatedAt, &o.UpdatedAt,
+	)
 	if err != nil {
-		return nil, err
+		return Order{}, err
 	}
-	defer rows.Close()
-
-	var pages []map[string]interface{}
-	for rows.Next() {
-		var path string
-		var entries int
-		err := rows.Scan(&path, &entries)
-		if err != nil {
-			return nil, err
-		}
-		pages = append(pages, map[string]interface{}{
-			"path":    path,
-			"entries": entries,
-		})
+	if shipsOn.Valid {
+		o.ShipsOn = &shipsOn.Time
 	}
 
-	return pages, nil
-}
-
-// GetExitPages returns the top pages where users leave the site
-func (s *AdminServer) GetExitPages(websiteID string, startDate, endDate time.Time, limit int) ([]map[string]interface{}, error) {
-	db, err := s.GetWebsiteConnection(websiteID)
-	if err != nil {
-		return nil, err
+	o.ShippingAddressLine2 = shippingLine2.String
+	o.PaymentMethod = paymentMethod.String
+	o.StripePaymentIntent = stripeIntent.String
+	if labelCost.Valid {
+		o.ShippingLabelCost = &labelCost.Float64
+	}
+	o.TrackingNumber = trackingNum.String
+	o.ShippingCarrier = carrier.String
+	o.ShippingLabelURL = labelURL.String
+	o.ShippoTransactionID = shippoTxID.String
+	if err := json.Unmarshal([]byte(riskReasons), &o.RiskReasons); err != nil {
+		log.Printf("Error parsing risk reasons for order %d: %v", o.ID, err)
 	}
-	defer db.Close()
 
-	query := `
-		SELECT path, COUNT(*) as exits
-		FROM (
-			SELECT session_id, path,
-				ROW_NUMBER() OVER (PARTITION BY session_id ORDER BY created_at DESC) as rn
-			FROM analytics_pageviews
-			WHERE created_at BETWEEN ? AND ?
-		) as last_pages
-		WHERE rn = 1
-		GROUP BY path
-		ORDER BY exits DESC
-		LIMIT ?
+	// Get order items
+	itemsQuery := `
+		SELECT id, product_id, variant_id, product_name, variant_title, quantity, price, total
+		FROM order_items
+		WHERE order_id = ?
 	`
 
-	rows, err := db.Query(query, startDate, endDate, limit)
+	rows, err := db.Query(itemsQuery, orderID)
 	if err != nil {
-		return nil, err
+		return o, err
 	}
 	defer rows.Close()
 
-	var pages []map[string]interface{}
 	for rows.Next() {
-		var path string
-		var exits int
-		err := rows.Scan(&path, &exits)
+		var item OrderItem
+		var variantID sql.NullInt64
+		var variantTitle sql.NullString
+
+		err := rows.Scan(
+			&item.ID, &item.ProductID, &variantID, &item.ProductName, &variantTitle,
+			&item.Quantity, &item.Price, &item.Total,
+		)
 		if err != nil {
-			return nil, err
+			return o, err
 		}
-		pages = append(pages, map[string]interface{}{
-			"path":  path,
-			"exits": exits,
-		})
+
+		if variantID.Valid {
+			item.VariantID = int(variantID.Int64)
+		}
+		item.VariantTitle = variantTitle.String
+		o.Items = append(o.Items, item)
 	}
 
-	return pages, nil
+	return o, nil
 }
 
-// ===============================
-// E-Commerce Analytics
-// ===============================
-
-// GetConversionRate returns the conversion rate (% of sessions that result in purchase)
-func (s *AdminServer) GetConversionRate(websiteID string, startDate, endDate time.Time) (float64, int, int, error) {
+// UpdateOrderFulfillmentStatus updates the fulfillment status of an order
+func (s *AdminServer) UpdateOrderFulfillmentStatus(websiteID string, orderID int, status string) error {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return 0, 0, 0, err
+		return err
 	}
 	defer db.Close()
 
-	query := `
-		SELECT
-			COUNT(DISTINCT pv.session_id) as total_sessions,
-			COUNT(DISTINCT CASE WHEN e.event_name = 'purchase' THEN pv.session_id END) as converted_sessions
-		FROM analytics_pageviews pv
-		LEFT JOIN analytics_events e ON pv.session_id = e.session_id
-			AND e.event_name = 'purchase'
-			AND e.created_at BETWEEN ? AND ?
-		WHERE pv.created_at BETWEEN ? AND ?
-	`
+	query := `UPDATE orders SET fulfillment_status = ?, updated_at = NOW() WHERE id = ?`
+	_, err = db.Exec(query, status, orderID)
+	return err
+}
 
-	var totalSessions, convertedSessions int
-	err = db.QueryRow(query, startDate, endDate, startDate, endDate).Scan(&totalSessions, &convertedSessions)
-	if err != nil {
-		return 0, 0, 0, err
-	}
+// LabelInfo contains information about a purchased shipping label
+type LabelInfo struct {
+	TrackingNumber string  `json:"trackingNumber"`
+	LabelURL       string  `json:"labelUrl"`
+	Carrier        string  `json:"carrier"`
+	Cost           float64 `json:"cost"`
+}
 
-	var conversionRate float64
-	if totalSessions > 0 {
-		conversionRate = (float64(convertedSessions) / float64(totalSessions)) * 100
+// GetShippingRates gets shipping rates from Shippo for an order
+func (s *AdminServer) GetShippingRates(websiteID string, order Order, length, width, height, weight float64) ([]shippo.Rate, error) {
+	// Get website config
+	website, err := s.GetWebsite(websiteID)
+	if err != nil {
+		return nil, err
 	}
 
-	return conversionRate, convertedSessions, totalSessions, nil
-}
-
-// GetCartAbandonmentRate returns cart abandonment metrics
-func (s *AdminServer) GetCartAbandonmentRate(websiteID string, startDate, endDate time.Time) (float64, int, int, error) {
-	db, err := s.GetWebsiteConnection(websiteID)
+	// Load website config to get ship-from address
+	configPath := filepath.Join("websites", website.Directory, "config-dev.json")
+	configData, err := ioutil.ReadFile(configPath)
 	if err != nil {
-		return 0, 0, 0, err
+		return nil, fmt.Errorf("failed to read website config: %w", err)
 	}
-	defer db.Close()
+
+	var siteConfig configs.WebsiteConfig
+	if err := json.Unmarshal(configData, &siteConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse website config: %w", err)
+	}
+	if err := siteConfig.DecryptSecrets(); err != nil {
+		return nil, err
+	}
+
+	// Get Shippo API key from site config
+	shippoKey := siteConfig.Shippo.APIKey
+
+	// Create Shippo client
+	client := shippo.NewClient(shippoKey)
+
+	// Build addresses
+	fromAddress := shippo.Address{
+		Name:    siteConfig.ShipFrom.Name,
+		Street1: siteConfig.ShipFrom.Street1,
+		Street2: siteConfig.ShipFrom.Street2,
+		City:    siteConfig.ShipFrom.City,
+		State:   siteConfig.ShipFrom.State,
+		Zip:     siteConfig.ShipFrom.Zip,
+		Country: siteConfig.ShipFrom.Country,
+	}
+
+	toAddress := shippo.Address{
+		Name:    order.CustomerName,
+		Street1: order.ShippingAddressLine1,
+		Street2: order.ShippingAddressLine2,
+		City:    order.ShippingCity,
+		State:   order.ShippingState,
+		Zip:     order.ShippingZip,
+		Country: order.ShippingCountry,
+		Email:   order.CustomerEmail,
+	}
+
+	parcel := shippo.Parcel{
+		Length:       fmt.Sprintf("%.2f", length),
+		Width:        fmt.Sprintf("%.2f", width),
+		Height:       fmt.Sprintf("%.2f", height),
+		DistanceUnit: "in",
+		Weight:       fmt.Sprintf("%.2f", weight),
+		MassUnit:     "lb",
+	}
+
+	// Get rates from Shippo
+	shipmentResp, err := client.GetRates(fromAddress, toAddress, parcel)
+	if err != nil {
+		return nil, err
+	}
+
+	return shipmentResp.Rates, nil
+}
+
+// PurchaseShippingLabel purchases a shipping label from Shippo and updates the order
+func (s *AdminServer) PurchaseShippingLabel(websiteID string, orderID int, rateID string) (*LabelInfo, error) {
+	// Get website config for site-specific Shippo key
+	website, err := s.GetWebsite(websiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get Shippo API key from site config
+	shippoKey := website.ShippoAPIKey
+
+	// Get label format - use site-specific if available, otherwise default to PDF
+	labelFormat := "PDF"
+	if website.LabelFormat != "" {
+		labelFormat = website.LabelFormat
+	}
+
+	// Create Shippo client
+	client := shippo.NewClient(shippoKey)
+
+	// Purchase label
+	transaction, err := client.PurchaseLabel(rateID, labelFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract cost from rate amount
+	cost := 0.0
+	fmt.Sscanf(transaction.Rate, "%f", &cost)
+
+	// Update order in database with label information
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// Determine carrier from transaction
+	carrier := "USPS" // Default, would need to parse from rate details
 
 	query := `
-		SELECT
-			COUNT(DISTINCT CASE WHEN added.session_id IS NOT NULL THEN added.session_id END) as sessions_with_cart,
-			COUNT(DISTINCT CASE WHEN purchased.session_id IS NOT NULL THEN purchased.session_id END) as sessions_with_purchase
-		FROM (
-			SELECT DISTINCT session_id
-			FROM analytics_events
-			WHERE event_name = 'add_to_cart'
-			AND created_at BETWEEN ? AND ?
-		) as added
-		LEFT JOIN (
-			SELECT DISTINCT session_id
-			FROM analytics_events
-			WHERE event_name = 'purchase'
-			AND created_at BETWEEN ? AND ?
-		) as purchased ON added.session_id = purchased.session_id
+		UPDATE orders
+		SET tracking_number = ?,
+		    shipping_carrier = ?,
+		    shipping_label_url = ?,
+		    shipping_label_cost = ?,
+		    shippo_transaction_id = ?,
+		    updated_at = NOW()
+		WHERE id = ?
 	`
 
-	var sessionsWithCart, sessionsWithPurchase int
-	err = db.QueryRow(query, startDate, endDate, startDate, endDate).Scan(&sessionsWithCart, &sessionsWithPurchase)
+	_, err = db.Exec(query, transaction.TrackingNumber, carrier, transaction.LabelURL, cost, transaction.ObjectID, orderID)
 	if err != nil {
-		return 0, 0, 0, err
+		return nil, err
 	}
 
-	var abandonmentRate float64
-	if sessionsWithCart > 0 {
-		abandoned := sessionsWithCart - sessionsWithPurchase
-		abandonmentRate = (float64(abandoned) / float64(sessionsWithCart)) * 100
+	return &LabelInfo{
+		TrackingNumber: transaction.TrackingNumber,
+		LabelURL:       transaction.LabelURL,
+		Carrier:        carrier,
+		Cost:           cost,
+	}, nil
+}
+
+// GetProductImagesData retrieves all images for a product from product_images_data
+func (s *AdminServer) GetProductImagesData(websiteID string, productID int) ([]ProductImageData, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
 	}
+	defer db.Close()
 
-	return abandonmentRate, sessionsWithCart - sessionsWithPurchase, sessionsWithCart, nil
+	query := `
+		SELECT id, product_id, url, filename, filepath, alt_text, credit, size, width, height, position, created_at
+		FROM product_images_data
+		WHERE product_id = ?
+		ORDER BY position ASC
+	`
+
+	rows, err := db.Query(query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []ProductImageData
+	for rows.Next() {
+		var img ProductImageData
+		var altText, credit sql.NullString
+		var size sql.NullInt64
+		var width, height sql.NullInt64
+
+		err := rows.Scan(&img.ID, &img.ProductID, &img.URL, &img.Filename, &img.Filepath,
+			&altText, &credit, &size, &width, &height, &img.Position, &img.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if altText.Valid {
+			img.AltText = altText.String
+		}
+		if credit.Valid {
+			img.Credit = credit.String
+		}
+		if size.Valid {
+			img.Size = size.Int64
+		}
+		if width.Valid {
+			img.Width = int(width.Int64)
+		}
+		if height.Valid {
+			img.Height = int(height.Int64)
+		}
+
+		images = append(images, img)
+	}
+
+	return images, nil
 }
 
-// GetRevenueMetrics returns revenue statistics for a date range
-func (s *AdminServer) GetRevenueMetrics(websiteID string, startDate, endDate time.Time) (map[string]interface{}, error) {
+// AddProductImageData adds a new image directly to a product
+func (s *AdminServer) AddProductImageData(websiteID string, img ProductImageData) (int64, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	defer db.Close()
+
+	query := `INSERT INTO product_images_data (product_id, url, filename, filepath, alt_text, credit, size, width, height, position)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := db.Exec(query, img.ProductID, img.URL, img.Filename, img.Filepath,
+		img.AltText, img.Credit, img.Size, img.Width, img.Height, img.Position)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// DeleteProductImageData deletes an image and its file from the upload backend
+func (s *AdminServer) DeleteProductImageData(websiteID string, imageID int) error {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// First get the URL the file is served from
+	var imageURL string
+	err = db.QueryRow("SELECT url FROM product_images_data WHERE id = ?", imageID).Scan(&imageURL)
+	if err != nil {
+		return err
+	}
+
+	// Delete from database
+	_, err = db.Exec("DELETE FROM product_images_data WHERE id = ?", imageID)
+	if err != nil {
+		return err
+	}
+
+	// Delete the file; don't fail the operation if it's already gone
+	s.deleteUpload(websiteID, imageURL)
+
+	return nil
+}
+
+// PublishProductImages moves a product's private images to the upload backend so they're served
+// directly. Called when the product is published
+func (s *AdminServer) PublishProductImages(ctx context.Context, websiteID string, productID int) error {
+	website, err := s.GetWebsite(websiteID)
+	if err != nil {
+		return err
+	}
+	site := uploadSite(website)
+
+	images, err := s.GetProductImagesData(websiteID, productID)
+	if err != nil {
+		return err
+	}
+
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
 	}
 	defer db.Close()
 
-	query := `
-		SELECT
-			COUNT(*) as total_orders,
-			SUM(total) as total_revenue,
-			AVG(total) as avg_order_value,
-			MAX(total) as highest_order
-		FROM orders
-		WHERE payment_status = 'paid'
-		AND created_at BETWEEN ? AND ?
-	`
+	for _, img := range images {
+		if _, private := media.PrivateUploadFile(site, img.URL); !private {
+			continue
+		}
+
+		stored, err := media.PublishPrivateUpload(ctx, s.uploads, site, img.URL)
+		if err != nil {
+			return fmt.Errorf("publishing image %d: %v", img.ID, err)
+		}
+
+		_, err = db.ExecContext(ctx, "UPDATE product_images_data SET url = ?, filepath = ? WHERE id = ?", stored.URL, stored.Path, img.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpdateProductImagePositions updates the position values for reordering
+func (s *AdminServer) UpdateProductImagePositions(websiteID string, imageIDs []int) error {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("UPDATE product_images_data SET position = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for position, imageID := range imageIDs {
+		_, err = stmt.Exec(position, imageID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ====================
+// Customer Management
+// ====================
+
+// GetCustomers retrieves all customers with aggregate statistics
+func (s *AdminServer) GetCustomers(websiteID string, filters CustomerFilters) ([]Customer, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT
+			c.id, c.email, c.stripe_customer_id, c.first_name, c.last_name, c.phone,
+			c.created_at, c.updated_at,
+			COUNT(CASE WHEN o.payment_status = 'paid' THEN 1 END) as order_count,
+			COALESCE(SUM(CASE WHEN o.payment_status = 'paid' THEN o.total ELSE 0 END), 0) as total_spent,
+			MIN(CASE WHEN o.payment_status = 'paid' THEN o.created_at END) as first_order,
+			MAX(CASE WHEN o.payment_status = 'paid' THEN o.created_at END) as last_order
+		FROM customers c
+		LEFT JOIN orders o ON c.id = o.customer_id
+		GROUP BY c.id, c.email, c.stripe_customer_id, c.first_name, c.last_name, c.phone, c.created_at, c.updated_at
+	`
+
+	// Add sorting
+	switch filters.Sort {
+	case "total_desc":
+		query += " ORDER BY total_spent DESC"
+	case "total_asc":
+		query += " ORDER BY total_spent ASC"
+	case "orders_desc":
+		query += " ORDER BY order_count DESC"
+	case "date_asc":
+		query += " ORDER BY c.created_at ASC"
+	case "date_desc":
+		query += " ORDER BY c.created_at DESC"
+	default: // Default to total spent descending
+		query += " ORDER BY total_spent DESC"
+	}
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var customers []Customer
+	for rows.Next() {
+		var c Customer
+		var stripeCustomerID, phone sql.NullString
+		var firstOrder, lastOrder sql.NullTime
+
+		err := rows.Scan(
+			&c.ID, &c.Email, &stripeCustomerID, &c.FirstName, &c.LastName, &phone,
+			&c.CreatedAt, &c.UpdatedAt,
+			&c.OrderCount, &c.TotalSpent, &firstOrder, &lastOrder,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if stripeCustomerID.Valid {
+			c.StripeCustomerID = stripeCustomerID.String
+		}
+		if phone.Valid {
+			c.Phone = phone.String
+		}
+		if firstOrder.Valid {
+			c.FirstOrder = &firstOrder.Time
+		}
+		if lastOrder.Valid {
+			c.LastOrder = &lastOrder.Time
+		}
+
+		customers = append(customers, c)
+	}
+
+	return customers, nil
+}
+
+// errMergeSameCustomer is returned when asked to merge a customer into itself
+var errMergeSameCustomer = errors.New("a customer can't be merged into itself")
+
+// MergeCustomers folds a duplicate customer into the primary one: the duplicate's orders,
+// subscriptions, reviews and saved wishlist products move to the primary, details the primary is
+// missing (name, phone, Stripe customer) are copied over, and the duplicate is deleted. Returns
+// sql.ErrNoRows when either customer doesn't exist
+func (s *AdminServer) MergeCustomers(websiteID string, primaryID, duplicateID int) error {
+	if primaryID == duplicateID {
+		return errMergeSameCustomer
+	}
+
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock both rows so a checkout can't attach new orders to the duplicate mid-merge
+	var dupStripeID, dupPhone, dupCreditCode sql.NullString
+	var dupFirstName, dupLastName string
+	var dupCredit float64
+	err = tx.QueryRow(`SELECT stripe_customer_id, first_name, last_name, phone, store_credit, store_credit_code FROM customers WHERE id = ? FOR UPDATE`, duplicateID).Scan(&dupStripeID, &dupFirstName, &dupLastName, &dupPhone, &dupCredit, &dupCreditCode)
+	if err != nil {
+		return err
+	}
+	var primaryExists int
+	err = tx.QueryRow(`SELECT 1 FROM customers WHERE id = ? FOR UPDATE`, primaryID).Scan(&primaryExists)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"orders", "subscriptions", "product_reviews", "store_credit_transactions", "store_credit_holds"} {
+		_, err = tx.Exec(`UPDATE `+table+` SET customer_id = ? WHERE customer_id = ?`, primaryID, duplicateID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A customer has at most one wishlist, so the duplicate's products join the primary's if it has one
+	var primaryWishlistID sql.NullInt64
+	err = tx.QueryRow(`SELECT id FROM wishlists WHERE customer_id = ?`, primaryID).Scan(&primaryWishlistID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if primaryWishlistID.Valid {
+		_, err = tx.Exec(`
+			INSERT IGNORE INTO wishlist_items (wishlist_id, product_id, created_at)
+			SELECT ?, i.product_id, i.created_at
+			FROM wishlist_items i
+			JOIN wishlists w ON w.id = i.wishlist_id
+			WHERE w.customer_id = ?
+		`, primaryWishlistID.Int64, duplicateID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE i FROM wishlist_items i JOIN wishlists w ON w.id = i.wishlist_id WHERE w.customer_id = ?`, duplicateID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM wishlists WHERE customer_id = ?`, duplicateID)
+	} else {
+		_, err = tx.Exec(`UPDATE wishlists SET customer_id = ? WHERE customer_id = ?`, primaryID, duplicateID)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Delete the duplicate before copying its Stripe customer, which must be unique
+	_, err = tx.Exec(`DELETE FROM customers WHERE id = ?`, duplicateID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE customers SET
+			stripe_customer_id = IFNULL(stripe_customer_id, ?),
+			first_name = IF(first_name = '', ?, first_name),
+			last_name = IF(last_name = '', ?, last_name),
+			phone = IF(IFNULL(phone, '') = '', ?, phone),
+			store_credit = store_credit + ?,
+			store_credit_code = IFNULL(store_credit_code, ?)
+		WHERE id = ?
+	`, dupStripeID, dupFirstName, dupLastName, dupPhone, dupCredit, dupCreditCode, primaryID)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Customer counts feed the cached overview stats
+	s.InvalidateOverviewStats(websiteID)
+	return nil
+}
+
+// erasedCustomerName replaces the customer's name on orders and subscriptions kept after an erasure
+const erasedCustomerName = "Erased customer"
+
+// errCustomerEmailRequired is returned by the export and erasure when no email is given, which would
+// otherwise match orders already erased
+var errCustomerEmailRequired = errors.New("an email address is required")
+
+// customerEmailMatch matches a table's rows placed under the email or by the customer with it
+const customerEmailMatch = `(customer_email = ? OR customer_id IN (SELECT id FROM customers WHERE email = ?))`
+
+// ExportCustomerData gathers everything stored about the person with the email, for a data access
+// request: their customer profile, orders and order items, subscriptions, wishlist, reviews,
+// product questions, contact messages and replies, and SMS signups. Each section is a list of rows
+// keyed by column name
+func (s *AdminServer) ExportCustomerData(websiteID, email string) (map[string]interface{}, error) {
+	if email == "" {
+		return nil, errCustomerEmailRequired
+	}
+
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	sections := []struct {
+		name  string
+		query string
+		args  []interface{}
+	}{
+		{"customer", `SELECT * FROM customers WHERE email = ?`, []interface{}{email}},
+		{"orders", `SELECT * FROM orders WHERE ` + customerEmailMatch + ` ORDER BY created_at`, []interface{}{email, email}},
+		{"order_items", `SELECT oi.* FROM order_items oi JOIN orders o ON o.id = oi.order_id WHERE o.customer_email = ? OR o.customer_id IN (SELECT id FROM customers WHERE email = ?) ORDER BY oi.order_id, oi.id`, []interface{}{email, email}},
+		{"subscriptions", `SELECT * FROM subscriptions WHERE ` + customerEmailMatch + ` ORDER BY created_at`, []interface{}{email, email}},
+		{"store_credit_transactions", `SELECT t.* FROM store_credit_transactions t JOIN customers c ON c.id = t.customer_id WHERE c.email = ? ORDER BY t.created_at, t.id`, []interface{}{email}},
+		{"wishlist", `SELECT i.product_id, p.name, i.created_at FROM wishlist_items i JOIN wishlists w ON w.id = i.wishlist_id LEFT JOIN products_unified p ON p.id = i.product_id WHERE w.customer_id IN (SELECT id FROM customers WHERE email = ?) ORDER BY i.created_at`, []interface{}{email}},
+		{"product_reviews", `SELECT * FROM product_reviews WHERE email = ? OR customer_id IN (SELECT id FROM customers WHERE email = ?) ORDER BY created_at`, []interface{}{email, email}},
+		{"product_questions", `SELECT * FROM product_questions WHERE email = ? ORDER BY created_at`, []interface{}{email}},
+		{"messages", `SELECT * FROM messages WHERE email = ? ORDER BY created_at`, []interface{}{email}},
+		{"message_replies", `SELECT r.* FROM message_replies r JOIN messages m ON m.id = r.message_id WHERE m.email = ? ORDER BY r.sent_at`, []interface{}{email}},
+		{"sms_signups", `SELECT * FROM sms_signups WHERE email = ?`, []interface{}{email}},
+	}
+
+	export := map[string]interface{}{
+		"email":      email,
+		"exportedAt": time.Now().UTC(),
+	}
+	for _, section := range sections {
+		rows, err := queryRowMaps(db, section.query, section.args...)
+		if err != nil {
+			return nil, fmt.Errorf("exporting %s: %w", section.name, err)
+		}
+		export[section.name] = rows
+	}
+
+	return export, nil
+}
+
+// queryRowMaps runs a query and returns its rows as maps keyed by column name
+func queryRowMaps(db *database.TimedDB, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	result := []map[string]interface{}{}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[column] = string(b)
+			} else {
+				row[column] = values[i]
+			}
+		}
+		result = append(result, row)
+	}
+
+	return result, rows.Err()
+}
+
+// CustomerErasure counts what an erasure removed or anonymized
+type CustomerErasure struct {
+	CustomerDeleted  bool `json:"customerDeleted"`
+	Orders           int  `json:"orders"`
+	Subscriptions    int  `json:"subscriptions"`
+	ProductReviews   int  `json:"productReviews"`
+	ProductQuestions int  `json:"productQuestions"`
+	Messages         int  `json:"messages"`
+	SMSSignups       int  `json:"smsSignups"`
+}
+
+// EraseCustomerData removes the personal data stored about the person with the email, for an
+// erasure request. Orders and subscriptions are kept for accounting with their totals, items,
+// payment references and the state and country taxes were charged for, but lose the name, email,
+// street addresses and link to the customer. Reviews and questions stay up without the name and
+// email. The customer profile and wishlist, contact messages and SMS signups are deleted
+func (s *AdminServer) EraseCustomerData(websiteID, email string) (CustomerErasure, error) {
+	var erasure CustomerErasure
+	if email == "" {
+		return erasure, errCustomerEmailRequired
+	}
+
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return erasure, err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return erasure, err
+	}
+	defer tx.Rollback()
+
+	var customerID sql.NullInt64
+	err = tx.QueryRow(`SELECT id FROM customers WHERE email = ? FOR UPDATE`, email).Scan(&customerID)
+	if err != nil && err != sql.ErrNoRows {
+		return erasure, err
+	}
+
+	// exec runs an update or delete and returns how many rows it touched
+	exec := func(query string, args ...interface{}) int {
+		if err != nil {
+			return 0
+		}
+		var result sql.Result
+		result, err = tx.Exec(query, args...)
+		if err != nil {
+			return 0
+		}
+		n, _ := result.RowsAffected()
+		return int(n)
+	}
+
+	erasure.Orders = exec(`
+		UPDATE orders SET
+			customer_email = '', customer_name = ?, customer_id = NULL,
+			shipping_address_line1 = NULL, shipping_address_line2 = NULL, shipping_city = NULL, shipping_zip = NULL,
+			billing_address_line1 = NULL, billing_city = NULL, billing_zip = NULL,
+			shipping_label_url = NULL, risk_reasons = NULL
+		WHERE `+customerEmailMatch, erasedCustomerName, email, email)
+	erasure.Subscriptions = exec(`
+		UPDATE subscriptions SET customer_email = '', customer_name = ?, customer_id = NULL, shipping_address = '', cart_id = NULL
+		WHERE `+customerEmailMatch, erasedCustomerName, email, email)
+	erasure.ProductReviews = exec(`
+		UPDATE product_reviews SET name = '', email = '', customer_id = NULL
+		WHERE email = ? OR customer_id IN (SELECT id FROM customers WHERE email = ?)`, email, email)
+	erasure.ProductQuestions = exec(`UPDATE product_questions SET name = '', email = '' WHERE email = ?`, email)
+	erasure.Messages = exec(`DELETE FROM messages WHERE email = ?`, email)
+	erasure.SMSSignups = exec(`DELETE FROM sms_signups WHERE email = ?`, email)
+	if customerID.Valid {
+		exec(`DELETE i FROM wishlist_items i JOIN wishlists w ON w.id = i.wishlist_id WHERE w.customer_id = ?`, customerID.Int64)
+		exec(`DELETE FROM wishlists WHERE customer_id = ?`, customerID.Int64)
+		exec(`DELETE FROM store_credit_transactions WHERE customer_id = ?`, customerID.Int64)
+		exec(`DELETE FROM store_credit_holds WHERE customer_id = ?`, customerID.Int64)
+		erasure.CustomerDeleted = exec(`DELETE FROM customers WHERE id = ?`, customerID.Int64) > 0
+	}
+	if err != nil {
+		return CustomerErasure{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return CustomerErasure{}, err
+	}
+
+	s.InvalidateOverviewStats(websiteID)
+	return erasure, nil
+}
+
+// GetCustomerRFM scores every customer with paid orders against the others, keyed by customer ID
+func (s *AdminServer) GetCustomerRFM(websiteID string) (map[int]*CustomerRFM, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+		SELECT customer_id, DATEDIFF(NOW(), MAX(created_at)), COUNT(*), COALESCE(SUM(total), 0)
+		FROM orders
+		WHERE payment_status = 'paid' AND customer_id IS NOT NULL
+		GROUP BY customer_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	var rfms []*CustomerRFM
+	for rows.Next() {
+		var id int
+		var rfm CustomerRFM
+		if err := rows.Scan(&id, &rfm.DaysSinceOrder, &rfm.Frequency, &rfm.Monetary); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+		rfms = append(rfms, &rfm)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Fewer days since the last order is better, so recency is scored on its negative
+	quintileScores(rfms, func(r *CustomerRFM) float64 { return -float64(r.DaysSinceOrder) }, func(r *CustomerRFM, score int) { r.RecencyScore = score })
+	quintileScores(rfms, func(r *CustomerRFM) float64 { return float64(r.Frequency) }, func(r *CustomerRFM, score int) { r.FrequencyScore = score })
+	quintileScores(rfms, func(r *CustomerRFM) float64 { return r.Monetary }, func(r *CustomerRFM, score int) { r.MonetaryScore = score })
+
+	result := make(map[int]*CustomerRFM, len(rfms))
+	for i, rfm := range rfms {
+		rfm.Segment = segmentFor(rfm)
+		result[ids[i]] = rfm
+	}
+	return result, nil
+}
+
+// quintileScores ranks the customers by value and sets scores from 1 for the lowest fifth to 5 for
+// the highest. Equal values get the same score
+func quintileScores(rfms []*CustomerRFM, value func(*CustomerRFM) float64, set func(*CustomerRFM, int)) {
+	sorted := make([]*CustomerRFM, len(rfms))
+	copy(sorted, rfms)
+	sort.SliceStable(sorted, func(i, j int) bool { return value(sorted[i]) < value(sorted[j]) })
+
+	score := 1
+	for i, rfm := range sorted {
+		if i == 0 || value(rfm) != value(sorted[i-1]) {
+			score = i*5/len(sorted) + 1
+		}
+		set(rfm, score)
+	}
+}
+
+// segmentFor buckets a scored customer into the first customerSegments entry it fits
+func segmentFor(rfm *CustomerRFM) CustomerSegment {
+	r, f, m := rfm.RecencyScore, rfm.FrequencyScore, rfm.MonetaryScore
+	var key string
+	switch {
+	case r >= 4 && f >= 4 && m >= 4:
+		key = "champions"
+	case r >= 3 && f >= 4:
+		key = "loyal"
+	case r >= 4 && rfm.Frequency == 1:
+		key = "new"
+	case r >= 4:
+		key = "promising"
+	case r <= 2 && f >= 3:
+		key = "at_risk"
+	case r <= 2:
+		key = "lost"
+	default:
+		key = "needs_attention"
+	}
+	for _, segment := range customerSegments {
+		if segment.Key == key {
+			return segment
+		}
+	}
+	return CustomerSegment{}
+}
+
+// GetCustomer retrieves a single customer with statistics
+func (s *AdminServer) GetCustomer(websiteID string, customerID int) (Customer, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return Customer{}, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT
+			c.id, c.email, c.stripe_customer_id, c.first_name, c.last_name, c.phone,
+			c.created_at, c.updated_at, c.store_credit, IFNULL(c.store_credit_code, ''),
+			COUNT(CASE WHEN o.payment_status = 'paid' THEN 1 END) as order_count,
+			COALESCE(SUM(CASE WHEN o.payment_status = 'paid' THEN o.total ELSE 0 END), 0) as total_spent,
+			MIN(CASE WHEN o.payment_status = 'paid' THEN o.created_at END) as first_order,
+			MAX(CASE WHEN o.payment_status = 'paid' THEN o.created_at END) as last_order
+		FROM customers c
+		LEFT JOIN orders o ON c.id = o.customer_id
+		WHERE c.id = ?
+		GROUP BY c.id, c.email, c.stripe_customer_id, c.first_name, c.last_name, c.phone, c.created_at, c.updated_at,
+			c.store_credit, c.store_credit_code
+	`
+
+	var c Customer
+	var stripeCustomerID, phone sql.NullString
+	var firstOrder, lastOrder sql.NullTime
+
+	err = db.QueryRow(query, customerID).Scan(
+		&c.ID, &c.Email, &stripeCustomerID, &c.FirstName, &c.LastName, &phone,
+		&c.CreatedAt, &c.UpdatedAt, &c.StoreCredit, &c.StoreCreditCode,
+		&c.OrderCount, &c.TotalSpent, &firstOrder, &lastOrder,
+	)
+	if err != nil {
+		return Customer{}, err
+	}
+
+	if stripeCustomerID.Valid {
+		c.StripeCustomerID = stripeCustomerID.String
+	}
+	if phone.Valid {
+		c.Phone = phone.String
+	}
+	if firstOrder.Valid {
+		c.FirstOrder = &firstOrder.Time
+	}
+	if lastOrder.Valid {
+		c.LastOrder = &lastOrder.Time
+	}
+
+	return c, nil
+}
+
+// GetCustomerByEmail retrieves a customer by email address with statistics
+func (s *AdminServer) GetCustomerByEmail(websiteID string, email string) (*Customer, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT
+			c.id, c.email, c.stripe_customer_id, c.first_name, c.last_name, c.phone,
+			c.created_at, c.updated_at,
+			COUNT(CASE WHEN o.payment_status = 'paid' THEN 1 END) as order_count,
+			COALESCE(SUM(CASE WHEN o.payment_status = 'paid' THEN o.total ELSE 0 END), 0) as total_spent,
+			MIN(CASE WHEN o.payment_status = 'paid' THEN o.created_at END) as first_order,
+			MAX(CASE WHEN o.payment_status = 'paid' THEN o.created_at END) as last_order
+		FROM customers c
+		LEFT JOIN orders o ON c.id = o.customer_id
+		WHERE c.email = ?
+		GROUP BY c.id, c.email, c.stripe_customer_id, c.first_name, c.last_name, c.phone, c.created_at, c.updated_at
+	`
+
+	var c Customer
+	var stripeCustomerID, phone sql.NullString
+	var firstOrder, lastOrder sql.NullTime
+
+	err = db.QueryRow(query, email).Scan(
+		&c.ID, &c.Email, &stripeCustomerID, &c.FirstName, &c.LastName, &phone,
+		&c.CreatedAt, &c.UpdatedAt,
+		&c.OrderCount, &c.TotalSpent, &firstOrder, &lastOrder,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No customer found, not an error
+		}
+		return nil, err
+	}
+
+	if stripeCustomerID.Valid {
+		c.StripeCustomerID = stripeCustomerID.String
+	}
+	if phone.Valid {
+		c.Phone = phone.String
+	}
+	if firstOrder.Valid {
+		c.FirstOrder = &firstOrder.Time
+	}
+	if lastOrder.Valid {
+		c.LastOrder = &lastOrder.Time
+	}
+
+	return &c, nil
+}
+
+// GetCustomerOrders retrieves all orders for a specific customer
+func (s *AdminServer) GetCustomerOrders(websiteID string, customerID int) ([]Order, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT
+			id, order_number, customer_email, customer_name,
+			shipping_address_line1, shipping_address_line2,
+			shipping_city, shipping_state, shipping_zip, shipping_country,
+			subtotal, tax, shipping_cost, total,
+			payment_status, fulfillment_status, payment_method,
+			created_at, updated_at
+		FROM orders
+		WHERE customer_id = ?
+		ORDER BY created_at DESC
+	`
+
+	rows, err := db.Query(query, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []Order
+	for rows.Next() {
+		var o Order
+		var shippingLine2, paymentMethod sql.NullString
+
+		err := rows.Scan(
+			&o.ID, &o.OrderNumber, &o.CustomerEmail, &o.CustomerName,
+			&o.ShippingAddressLine1, &shippingLine2,
+			&o.ShippingCity, &o.ShippingState, &o.ShippingZip, &o.ShippingCountry,
+			&o.Subtotal, &o.Tax, &o.ShippingCost, &o.Total,
+			&o.PaymentStatus, &o.FulfillmentStatus, &paymentMethod,
+			&o.CreatedAt, &o.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		o.ShippingAddressLine2 = shippingLine2.String
+		o.PaymentMethod = paymentMethod.String
+
+		orders = append(orders, o)
+	}
+
+	return orders, nil
+}
+
+// GetSubscriptions retrieves subscriptions for a website, optionally filtered by status
+func (s *AdminServer) GetSubscriptions(websiteID string, status string) ([]Subscription, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT id, stripe_subscription_id, customer_email, customer_name, billing_interval, amount, status, created_at
+		FROM subscriptions
+		WHERE 1=1
+	`
+
+	var args []interface{}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY created_at DESC`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []Subscription
+	for rows.Next() {
+		var sub Subscription
+		err := rows.Scan(&sub.ID, &sub.StripeSubscriptionID, &sub.CustomerEmail, &sub.CustomerName, &sub.BillingInterval, &sub.Amount, &sub.Status, &sub.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, sub)
+	}
+
+	return subscriptions, nil
+}
+
+// GetSMSSignups retrieves SMS signups for a website with filters
+func (s *AdminServer) GetSMSSignups(websiteID string, filters SMSSignupFilters) ([]SMSSignup, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT id, COALESCE(country_code, '+1'), phone, COALESCE(email, ''), COALESCE(source, ''), created_at
+		FROM sms_signups
+		WHERE 1=1
+	`
+
+	var args []interface{}
+
+	// Filter by country code
+	if filters.CountryCode != "" {
+		query += ` AND country_code = ?`
+		args = append(args, filters.CountryCode)
+	}
+
+	// Filter by source
+	if filters.Source != "" {
+		query += ` AND source = ?`
+		args = append(args, filters.Source)
+	}
+
+	// Filter by date range
+	if filters.DateFrom != "" {
+		query += ` AND created_at >= ?`
+		args = append(args, filters.DateFrom+" 00:00:00")
+	}
+	if filters.DateTo != "" {
+		query += ` AND created_at <= ?`
+		args = append(args, filters.DateTo+" 23:59:59")
+	}
+
+	// Sorting
+	switch filters.Sort {
+	case "date_asc":
+		query += ` ORDER BY created_at ASC`
+	case "phone_asc":
+		query += ` ORDER BY phone ASC`
+	case "phone_desc":
+		query += ` ORDER BY phone DESC`
+	default:
+		query += ` ORDER BY created_at DESC`
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var signups []SMSSignup
+	for rows.Next() {
+		var s SMSSignup
+		err := rows.Scan(&s.ID, &s.CountryCode, &s.Phone, &s.Email, &s.Source, &s.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		signups = append(signups, s)
+	}
+
+	return signups, nil
+}
+
+// GetUniqueCountryCodes retrieves unique country codes from SMS signups
+func (s *AdminServer) GetUniqueCountryCodes(websiteID string) ([]string, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `SELECT DISTINCT country_code FROM sms_signups WHERE country_code IS NOT NULL ORDER BY country_code`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// GetUniqueSources retrieves unique sources from SMS signups
+func (s *AdminServer) GetUniqueSources(websiteID string) ([]string, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `SELECT DISTINCT source FROM sms_signups WHERE source IS NOT NULL AND source != '' ORDER BY source`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sources []string
+	for rows.Next() {
+		var source string
+		if err := rows.Scan(&source); err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+
+	return sources, nil
+}
+
+// DeleteSMSSignup deletes an SMS signup by ID
+func (s *AdminServer) DeleteSMSSignup(websiteID string, signupID int) error {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	query := `DELETE FROM sms_signups WHERE id = ?`
+	_, err = db.Exec(query, signupID)
+	return err
+}
+
+// GetVerifiedSMSSignups retrieves only verified SMS signups with filters
+func (s *AdminServer) GetVerifiedSMSSignups(websiteID string, filters SMSSignupFilters) ([]SMSSignup, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT id, COALESCE(country_code, '+1'), phone, COALESCE(email, ''), COALESCE(source, ''), created_at
+		FROM sms_signups
+		WHERE verified = 1 AND unsubscribed = 0
+	`
+
+	var args []interface{}
+
+	// Filter by country code
+	if filters.CountryCode != "" {
+		query += ` AND country_code = ?`
+		args = append(args, filters.CountryCode)
+	}
+
+	// Filter by source
+	if filters.Source != "" {
+		query += ` AND source = ?`
+		args = append(args, filters.Source)
+	}
+
+	// Filter by date range
+	if filters.DateFrom != "" {
+		query += ` AND created_at >= ?`
+		args = append(args, filters.DateFrom+" 00:00:00")
+	}
+	if filters.DateTo != "" {
+		query += ` AND created_at <= ?`
+		args = append(args, filters.DateTo+" 23:59:59")
+	}
+
+	// Sorting
+	switch filters.Sort {
+	case "date_asc":
+		query += ` ORDER BY created_at ASC`
+	case "phone_asc":
+		query += ` ORDER BY phone ASC`
+	case "phone_desc":
+		query += ` ORDER BY phone DESC`
+	default:
+		query += ` ORDER BY created_at DESC`
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var signups []SMSSignup
+	for rows.Next() {
+		var s SMSSignup
+		err := rows.Scan(&s.ID, &s.CountryCode, &s.Phone, &s.Email, &s.Source, &s.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		signups = append(signups, s)
+	}
+
+	return signups, nil
+}
+
+// ===============================
+// Analytics Queries
+// ===============================
+
+// GetPageViewStats returns basic pageview statistics for a date range
+func (s *AdminServer) GetPageViewStats(ctx context.Context, websiteID string, startDate, endDate time.Time, includeBots bool) (map[string]interface{}, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	stats := make(map[string]interface{})
+
+	// Total pageviews
+	var totalViews int
+	err = db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM analytics_pageviews
+		WHERE created_at BETWEEN ? AND ?
+		AND (? OR is_bot = 0)
+	`, startDate, endDate, includeBots).Scan(&totalViews)
+	if err != nil {
+		return nil, err
+	}
+	stats["total_views"] = totalViews
+
+	// Unique sessions
+	var uniqueSessions int
+	err = db.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT session_id) FROM analytics_pageviews
+		WHERE created_at BETWEEN ? AND ?
+		AND (? OR is_bot = 0)
+	`, startDate, endDate, includeBots).Scan(&uniqueSessions)
+	if err != nil {
+		return nil, err
+	}
+	stats["unique_sessions"] = uniqueSessions
+
+	return stats, nil
+}
+
+// GetTopPages returns the most visited pages for a date range
+func (s *AdminServer) GetTopPages(ctx context.Context, websiteID string, startDate, endDate time.Time, limit int, includeBots bool) ([]map[string]interface{}, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT path, COUNT(*) as views, COUNT(DISTINCT session_id) as unique_visitors
+		FROM analytics_pageviews
+		WHERE created_at BETWEEN ? AND ?
+		AND (? OR is_bot = 0)
+		GROUP BY path
+		ORDER BY views DESC
+		LIMIT ?
+	`
+
+	rows, err := db.QueryContext(ctx, query, startDate, endDate, includeBots, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pages []map[string]interface{}
+	for rows.Next() {
+		var path string
+		var views, uniqueVisitors int
+		err := rows.Scan(&path, &views, &uniqueVisitors)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, map[string]interface{}{
+			"path":            path,
+			"views":           views,
+			"unique_visitors": uniqueVisitors,
+		})
+	}
+
+	return pages, nil
+}
+
+// GetTopReferrers returns the top referrers for a date range
+func (s *AdminServer) GetTopReferrers(ctx context.Context, websiteID string, startDate, endDate time.Time, limit int, includeBots bool) ([]map[string]interface{}, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT referrer, COUNT(*) as visits
+		FROM analytics_pageviews
+		WHERE created_at BETWEEN ? AND ?
+		AND (? OR is_bot = 0)
+		AND referrer IS NOT NULL
+		AND referrer != ''
+		GROUP BY referrer
+		ORDER BY visits DESC
+		LIMIT ?
+	`
+
+	rows, err := db.QueryContext(ctx, query, startDate, endDate, includeBots, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var referrers []map[string]interface{}
+	for rows.Next() {
+		var referrer string
+		var visits int
+		err := rows.Scan(&referrer, &visits)
+		if err != nil {
+			return nil, err
+		}
+		referrers = append(referrers, map[string]interface{}{
+			"referrer": referrer,
+			"visits":   visits,
+		})
+	}
+
+	return referrers, nil
+}
+
+// GetEventStats returns statistics for custom events in a date range
+func (s *AdminServer) GetEventStats(ctx context.Context, websiteID string, startDate, endDate time.Time, limit int) ([]map[string]interface{}, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT event_name, COUNT(*) as count
+		FROM analytics_events
+		WHERE created_at BETWEEN ? AND ?
+		GROUP BY event_name
+		ORDER BY count DESC
+		LIMIT ?
+	`
+
+	rows, err := db.QueryContext(ctx, query, startDate, endDate, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []map[string]interface{}
+	for rows.Next() {
+		var eventName string
+		var count int
+		err := rows.Scan(&eventName, &count)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, map[string]interface{}{
+			"event_name": eventName,
+			"count":      count,
+		})
+	}
+
+	return events, nil
+}
+
+// validEventPropertyKey reports whether a property name is safe to use in a JSON path: letters,
+// digits and underscores only
+func validEventPropertyKey(key string) bool {
+	if key == "" || len(key) > 64 {
+		return false
+	}
+	for _, r := range key {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// GetEventPropertyKeys returns the property names recorded with an event in a date range, sorted.
+// Only the most recent events are sampled, which is enough to find the properties a site sends
+func (s *AdminServer) GetEventPropertyKeys(ctx context.Context, websiteID, eventName string, startDate, endDate time.Time) ([]string, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT event_data
+		FROM analytics_events
+		WHERE event_name = ?
+		AND created_at BETWEEN ? AND ?
+		AND event_data IS NOT NULL
+		ORDER BY created_at DESC
+		LIMIT 500
+	`, eventName, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var properties map[string]interface{}
+		if json.Unmarshal(data, &properties) != nil {
+			continue
+		}
+		for key := range properties {
+			if validEventPropertyKey(key) {
+				seen[key] = true
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// GetEventBreakdown groups an event by the value of one of its properties, e.g. add_to_cart by
+// product_id, most frequent first. Events without the property are counted under "(none)"
+func (s *AdminServer) GetEventBreakdown(ctx context.Context, websiteID, eventName, propertyKey string, startDate, endDate time.Time, limit int) ([]map[string]interface{}, error) {
+	if !validEventPropertyKey(propertyKey) {
+		return nil, fmt.Errorf("invalid property name %q", propertyKey)
+	}
+
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT IFNULL(JSON_UNQUOTE(JSON_EXTRACT(event_data, ?)), '(none)') as property_value,
+			COUNT(*) as count, COUNT(DISTINCT session_id) as sessions
+		FROM analytics_events
+		WHERE event_name = ?
+		AND created_at BETWEEN ? AND ?
+		GROUP BY property_value
+		ORDER BY count DESC
+		LIMIT ?
+	`
+
+	rows, err := db.QueryContext(ctx, query, "$."+propertyKey, eventName, startDate, endDate, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var breakdown []map[string]interface{}
+	for rows.Next() {
+		var value string
+		var count, sessions int
+		err := rows.Scan(&value, &count, &sessions)
+		if err != nil {
+			return nil, err
+		}
+		breakdown = append(breakdown, map[string]interface{}{
+			"value":    value,
+			"count":    count,
+			"sessions": sessions,
+		})
+	}
+
+	return breakdown, nil
+}
+
+// ===============================
+// Real-Time Analytics
+// ===============================
+
+// GetActiveUsers returns count of users active in the last N minutes
+func (s *AdminServer) GetActiveUsers(ctx context.Context, websiteID string, minutesAgo int) (int, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	cutoffTime := time.Now().Add(-time.Duration(minutesAgo) * time.Minute)
+
+	query := `
+		SELECT COUNT(DISTINCT session_id) as active_users
+		FROM (
+			SELECT session_id, MAX(created_at) as last_activity
+			FROM analytics_pageviews
+			WHERE created_at >= ? AND is_bot = 0
+			GROUP BY session_id
+			UNION
+			SELECT session_id, MAX(created_at) as last_activity
+			FROM analytics_events
+			WHERE created_at >= ?
+			GROUP BY session_id
+		) as combined
+	`
+
+	var activeUsers int
+	err = db.QueryRowContext(ctx, query, cutoffTime, cutoffTime).Scan(&activeUsers)
+	if err != nil {
+		return 0, err
+	}
+
+	return activeUsers, nil
+}
+
+// GetCurrentPages returns pages currently being viewed by active users
+func (s *AdminServer) GetCurrentPages(ctx context.Context, websiteID string, minutesAgo int) ([]map[string]interface{}, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	cutoffTime := time.Now().Add(-time.Duration(minutesAgo) * time.Minute)
+
+	// Get sessions with activity (pageviews or heartbeats) in the last N minutes
+	// and find their most recent page
+	query := `
+		SELECT p.path, COUNT(DISTINCT p.session_id) as active_viewers
+		FROM (
+			SELECT session_id, MAX(last_activity) as last_activity
+			FROM (
+				SELECT session_id, MAX(created_at) as last_activity
+				FROM analytics_pageviews
+				WHERE created_at >= ? AND is_bot = 0
+				GROUP BY session_id
+
+				UNION ALL
+
+				SELECT session_id, MAX(created_at) as last_activity
+				FROM analytics_events
+				WHERE event_name = 'heartbeat' AND created_at >= ?
+				GROUP BY session_id
+			) combined
+			GROUP BY session_id
+			HAVING MAX(last_activity) >= ?
+		) active_sessions
+		JOIN (
+			SELECT session_id, path, created_at,
+				ROW_NUMBER() OVER (PARTITION BY session_id ORDER BY created_at DESC) as rn
+			FROM analytics_pageviews
+		) p ON active_sessions.session_id = p.session_id AND p.rn = 1
+		GROUP BY p.path
+		ORDER BY active_viewers DESC
+		LIMIT 20
+	`
+
+	rows, err := db.QueryContext(ctx, query, cutoffTime, cutoffTime, cutoffTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pages []map[string]interface{}
+	for rows.Next() {
+		var path string
+		var viewers int
+		err := rows.Scan(&path, &viewers)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, map[string]interface{}{
+			"path":    path,
+			"viewers": viewers,
+		})
+	}
+
+	return pages, nil
+}
+
+// GetActiveReferrers returns where users active in the last N minutes came from: the referrer
+// host of each active session's first pageview, with "Direct" for sessions without one. Activity
+// is combined from pageviews and events like GetActiveUsers
+func (s *AdminServer) GetActiveReferrers(ctx context.Context, websiteID string, minutesAgo int) ([]map[string]interface{}, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	cutoffTime := time.Now().Add(-time.Duration(minutesAgo) * time.Minute)
+
+	query := `
+		SELECT IFNULL(p.referrer, '') as referrer, COUNT(*) as active_users
+		FROM (
+			SELECT session_id
+			FROM analytics_pageviews
+			WHERE created_at >= ? AND is_bot = 0
+			UNION
+			SELECT session_id
+			FROM analytics_events
+			WHERE created_at >= ?
+		) active_sessions
+		JOIN analytics_pageviews p ON p.id = (
+			SELECT MIN(first.id) FROM analytics_pageviews first
+			WHERE first.session_id = active_sessions.session_id
+		)
+		WHERE p.is_bot = 0
+		GROUP BY referrer
+	`
+
+	rows, err := db.QueryContext(ctx, query, cutoffTime, cutoffTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Full referrer URLs are grouped by host so every page of a source counts together
+	counts := make(map[string]int)
+	for rows.Next() {
+		var referrer string
+		var users int
+		err := rows.Scan(&referrer, &users)
+		if err != nil {
+			return nil, err
+		}
+		source := "Direct"
+		if u, err := url.Parse(referrer); err == nil && u.Host != "" {
+			source = strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+		} else if referrer != "" {
+			source = referrer
+		}
+		counts[source] += users
+	}
+
+	referrers := make([]map[string]interface{}, 0, len(counts))
+	for source, users := range counts {
+		referrers = append(referrers, map[string]interface{}{
+			"referrer":     source,
+			"active_users": users,
+		})
+	}
+	sort.Slice(referrers, func(i, j int) bool {
+		a, b := referrers[i]["active_users"].(int), referrers[j]["active_users"].(int)
+		if a != b {
+			return a > b
+		}
+		return referrers[i]["referrer"].(string) < referrers[j]["referrer"].(string)
+	})
+	if len(referrers) > 20 {
+		referrers = referrers[:20]
+	}
+
+	return referrers, nil
+}
+
+// ===============================
+// Engagement Metrics
+// ===============================
+
+// GetBounceRate returns the bounce rate (single-page sessions) for a date range
+func (s *AdminServer) GetBounceRate(ctx context.Context, websiteID string, startDate, endDate time.Time, includeBots bool) (float64, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT
+			COUNT(DISTINCT CASE WHEN pageview_count = 1 THEN session_id END) as bounced_sessions,
+			COUNT(DISTINCT session_id) as total_sessions
+		FROM (
+			SELECT session_id, COUNT(*) as pageview_count
+			FROM analytics_pageviews
+			WHERE created_at BETWEEN ? AND ?
+			AND (? OR is_bot = 0)
+			GROUP BY session_id
+		) as session_stats
+	`
+
+	var bouncedSessions, totalSessions int
+	err = db.QueryRowContext(ctx, query, startDate, endDate, includeBots).Scan(&bouncedSessions, &totalSessions)
+	if err != nil {
+		return 0, err
+	}
+
+	if totalSessions == 0 {
+		return 0, nil
+	}
+
+	bounceRate := (float64(bouncedSessions) / float64(totalSessions)) * 100
+	return bounceRate, nil
+}
+
+// GetAverageSessionDuration returns average session duration in seconds
+func (s *AdminServer) GetAverageSessionDuration(ctx context.Context, websiteID string, startDate, endDate time.Time, includeBots bool) (float64, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT AVG(duration) as avg_duration
+		FROM (
+			SELECT
+				session_id,
+				SUM(time_on_page) as duration
+			FROM analytics_pageviews
+			WHERE created_at BETWEEN ? AND ?
+			AND (? OR is_bot = 0)
+			GROUP BY session_id
+			HAVING SUM(time_on_page) > 0
+		) as session_durations
+	`
+
+	var avgDuration sql.NullFloat64
+	err = db.QueryRowContext(ctx, query, startDate, endDate, includeBots).Scan(&avgDuration)
+	if err != nil {
+		return 0, err
+	}
+
+	if !avgDuration.Valid {
+		return 0, nil
+	}
+
+	return avgDuration.Float64, nil
+}
+
+// GetDeviceBreakdown returns breakdown of traffic by device type
+func (s *AdminServer) GetDeviceBreakdown(ctx context.Context, websiteID string, startDate, endDate time.Time) (map[string]int, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// Pageviews are classified from the user agent when tracked; older rows without a
+	// classification fall back to screen width
+	query := `
+		SELECT
+			COALESCE(device_type, CASE
+				WHEN screen_width < 768 THEN 'mobile'
+				WHEN screen_width < 1024 THEN 'tablet'
+				ELSE 'desktop'
+			END) as device,
+			COUNT(DISTINCT session_id) as sessions
+		FROM analytics_pageviews
+		WHERE created_at BETWEEN ? AND ?
+		GROUP BY device
+	`
+
+	rows, err := db.QueryContext(ctx, query, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	breakdown := make(map[string]int)
+	for rows.Next() {
+		var deviceType string
+		var sessions int
+		err := rows.Scan(&deviceType, &sessions)
+		if err != nil {
+			return nil, err
+		}
+		breakdown[deviceType] = sessions
+	}
+
+	return breakdown, nil
+}
+
+// GetBrowserBreakdown returns sessions per browser family for a date range, busiest first. Bots
+// are left out; pageviews tracked before browsers were recorded are counted as "Unknown"
+func (s *AdminServer) GetBrowserBreakdown(ctx context.Context, websiteID string, startDate, endDate time.Time) ([]map[string]interface{}, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT IFNULL(browser, 'Unknown') as browser_name, COUNT(DISTINCT session_id) as sessions
+		FROM analytics_pageviews
+		WHERE created_at BETWEEN ? AND ?
+		AND is_bot = 0
+		GROUP BY browser_name
+		ORDER BY sessions DESC
+	`
+
+	rows, err := db.QueryContext(ctx, query, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var browsers []map[string]interface{}
+	for rows.Next() {
+		var browser string
+		var sessions int
+		err := rows.Scan(&browser, &sessions)
+		if err != nil {
+			return nil, err
+		}
+		browsers = append(browsers, map[string]interface{}{
+			"browser":  browser,
+			"sessions": sessions,
+		})
+	}
+
+	return browsers, nil
+}
+
+// GetEntryPages returns the top pages where users enter the site
+func (s *AdminServer) GetEntryPages(ctx context.Context, websiteID string, startDate, endDate time.Time, limit int, includeBots bool) ([]map[string]interface{}, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT path, COUNT(*) as entries
+		FROM (
+			SELECT session_id, path,
+				ROW_NUMBER() OVER (PARTITION BY session_id ORDER BY created_at ASC) as rn
+			FROM analytics_pageviews
+			WHERE created_at BETWEEN ? AND ?
+			AND (? OR is_bot = 0)
+		) as first_pages
+		WHERE rn = 1
+		GROUP BY path
+		ORDER BY entries DESC
+		LIMIT ?
+	`
+
+	rows, err := db.QueryContext(ctx, query, startDate, endDate, includeBots, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pages []map[string]interface{}
+	for rows.Next() {
+		var path string
+		var entries int
+		err := rows.Scan(&path, &entries)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, map[string]interface{}{
+			"path":    path,
+			"entries": entries,
+		})
+	}
+
+	return pages, nil
+}
+
+// GetExitPages returns the top pages where users leave the site
+func (s *AdminServer) GetExitPages(ctx context.Context, websiteID string, startDate, endDate time.Time, limit int, includeBots bool) ([]map[string]interface{}, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT path, COUNT(*) as exits
+		FROM (
+			SELECT session_id, path,
+				ROW_NUMBER() OVER (PARTITION BY session_id ORDER BY created_at DESC) as rn
+			FROM analytics_pageviews
+			WHERE created_at BETWEEN ? AND ?
+			AND (? OR is_bot = 0)
+		) as last_pages
+		WHERE rn = 1
+		GROUP BY path
+		ORDER BY exits DESC
+		LIMIT ?
+	`
+
+	rows, err := db.QueryContext(ctx, query, startDate, endDate, includeBots, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pages []map[string]interface{}
+	for rows.Next() {
+		var path string
+		var exits int
+		err := rows.Scan(&path, &exits)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, map[string]interface{}{
+			"path":  path,
+			"exits": exits,
+		})
+	}
+
+	return pages, nil
+}
+
+// ===============================
+// E-Commerce Analytics
+// ===============================
+
+// GetConversionRate returns the conversion rate (% of sessions that result in purchase)
+func (s *AdminServer) GetConversionRate(ctx context.Context, websiteID string, startDate, endDate time.Time, includeBots bool) (float64, int, int, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT
+			COUNT(DISTINCT pv.session_id) as total_sessions,
+			COUNT(DISTINCT CASE WHEN e.event_name = 'purchase' THEN pv.session_id END) as converted_sessions
+		FROM analytics_pageviews pv
+		LEFT JOIN analytics_events e ON pv.session_id = e.session_id
+			AND e.event_name = 'purchase'
+			AND e.created_at BETWEEN ? AND ?
+		WHERE pv.created_at BETWEEN ? AND ?
+		AND (? OR pv.is_bot = 0)
+	`
+
+	var totalSessions, convertedSessions int
+	err = db.QueryRowContext(ctx, query, startDate, endDate, startDate, endDate, includeBots).Scan(&totalSessions, &convertedSessions)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	var conversionRate float64
+	if totalSessions > 0 {
+		conversionRate = (float64(convertedSessions) / float64(totalSessions)) * 100
+	}
+
+	return conversionRate, convertedSessions, totalSessions, nil
+}
+
+// GetCartAbandonmentRate returns cart abandonment metrics
+func (s *AdminServer) GetCartAbandonmentRate(ctx context.Context, websiteID string, startDate, endDate time.Time) (float64, int, int, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT
+			COUNT(DISTINCT CASE WHEN added.session_id IS NOT NULL THEN added.session_id END) as sessions_with_cart,
+			COUNT(DISTINCT CASE WHEN purchased.session_id IS NOT NULL THEN purchased.session_id END) as sessions_with_purchase
+		FROM (
+			SELECT DISTINCT session_id
+			FROM analytics_events
+			WHERE event_name = 'add_to_cart'
+			AND created_at BETWEEN ? AND ?
+		) as added
+		LEFT JOIN (
+			SELECT DISTINCT session_id
+			FROM analytics_events
+			WHERE event_name = 'purchase'
+			AND created_at BETWEEN ? AND ?
+		) as purchased ON added.session_id = purchased.session_id
+	`
+
+	var sessionsWithCart, sessionsWithPurchase int
+	err = db.QueryRowContext(ctx, query, startDate, endDate, startDate, endDate).Scan(&sessionsWithCart, &sessionsWithPurchase)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	var abandonmentRate float64
+	if sessionsWithCart > 0 {
+		abandoned := sessionsWithCart - sessionsWithPurchase
+		abandonmentRate = (float64(abandoned) / float64(sessionsWithCart)) * 100
+	}
+
+	return abandonmentRate, sessionsWithCart - sessionsWithPurchase, sessionsWithCart, nil
+}
+
+// FunnelStep is one step of a funnel: how many sessions reached it and how many were lost since
+// the step before
+type FunnelStep struct {
+	Event    string
+	Sessions int
+	Percent  float64 // share of sessions that entered the funnel
+	DropOff  float64 // share of the previous step's sessions that didn't reach this one
+}
+
+// GetFunnel counts the sessions that fired each event in steps, in order, within a date range. A
+// session only reaches a step once it has reached every step before it, so an add_to_cart after
+// the purchase doesn't count towards the add_to_cart step of a cart -> purchase funnel
+func (s *AdminServer) GetFunnel(ctx context.Context, websiteID string, steps []string, startDate, endDate time.Time) ([]FunnelStep, error) {
+	funnel := make([]FunnelStep, len(steps))
+	for i, step := range steps {
+		funnel[i].Event = step
+	}
+	if len(steps) == 0 {
+		return funnel, nil
+	}
+
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(steps)), ", ")
+	args := []interface{}{startDate, endDate}
+	for _, step := range steps {
+		args = append(args, step)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT session_id, event_name
+		FROM analytics_events
+		WHERE created_at BETWEEN ? AND ?
+		AND event_name IN (`+placeholders+`)
+		ORDER BY session_id, created_at, id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Walk each session's events in time order, advancing through the steps as they're hit
+	var currentSession string
+	reached := 0
+	finishSession := func() {
+		for i := 0; i < reached; i++ {
+			funnel[i].Sessions++
+		}
+	}
+	for rows.Next() {
+		var sessionID, eventName string
+		if err := rows.Scan(&sessionID, &eventName); err != nil {
+			return nil, err
+		}
+		if sessionID != currentSession {
+			finishSession()
+			currentSession = sessionID
+			reached = 0
+		}
+		if reached < len(steps) && eventName == steps[reached] {
+			reached++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	finishSession()
+
+	for i := range funnel {
+		if funnel[0].Sessions > 0 {
+			funnel[i].Percent = float64(funnel[i].Sessions) / float64(funnel[0].Sessions) * 100
+		}
+		if i > 0 && funnel[i-1].Sessions > 0 {
+			funnel[i].DropOff = float64(funnel[i-1].Sessions-funnel[i].Sessions) / float64(funnel[i-1].Sessions) * 100
+		}
+	}
+
+	return funnel, nil
+}
+
+// GetRevenueMetrics returns revenue statistics for a date range
+func (s *AdminServer) GetRevenueMetrics(ctx context.Context, websiteID string, startDate, endDate time.Time) (map[string]interface{}, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT
+			COUNT(*) as total_orders,
+			SUM(total) as total_revenue,
+			AVG(total) as avg_order_value,
+			MAX(total) as highest_order
+		FROM orders
+		WHERE payment_status = 'paid'
+		AND created_at BETWEEN ? AND ?
+	`
+
+	var totalOrders int
+	var totalRevenue, avgOrderValue, highestOrder sql.NullFloat64
+
+	err = db.QueryRowContext(ctx, query, startDate, endDate).Scan(&totalOrders, &totalRevenue, &avgOrderValue, &highestOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := map[string]interface{}{
+		"total_orders": totalOrders,
+		"total_revenue": func() float64 {
+			if totalRevenue.Valid {
+				return totalRevenue.Float64
+			}
+			return 0
+		}(),
+		"avg_order_value": func() float64 {
+			if avgOrderValue.Valid {
+				return avgOrderValue.Float64
+			}
+			return 0
+		}(),
+		"highest_order": func() float64 {
+			if highestOrder.Valid {
+				return highestOrder.Float64
+			}
+			return 0
+		}(),
+	}
+
+	return metrics, nil
+}
+
+// GetLocationStats returns geographic statistics for pageviews in a date range
+func (s *AdminServer) GetLocationStats(ctx context.Context, websiteID string, startDate, endDate time.Time, includeBots bool) ([]map[string]interface{}, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT
+			country,
+			country_code,
+			region,
+			city,
+			latitude,
+			longitude,
+			COUNT(*) as pageviews,
+			COUNT(DISTINCT visitor_id) as unique_visitors
+		FROM analytics_pageviews
+		WHERE created_at BETWEEN ? AND ?
+		AND (? OR is_bot = 0)
+		AND country_code IS NOT NULL
+		AND latitude IS NOT NULL
+		AND longitude IS NOT NULL
+		GROUP BY country, country_code, region, city, latitude, longitude
+		ORDER BY pageviews DESC
+	`
+
+	rows, err := db.QueryContext(ctx, query, startDate, endDate, includeBots)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var locations []map[string]interface{}
+	for rows.Next() {
+		var country, countryCode, region, city string
+		var latitude, longitude float64
+		var pageviews, uniqueVisitors int
+		err := rows.Scan(&country, &countryCode, &region, &city, &latitude, &longitude, &pageviews, &uniqueVisitors)
+		if err != nil {
+			return nil, err
+		}
+		locations = append(locations, map[string]interface{}{
+			"country":          country,
+			"country_code":     countryCode,
+			"region":           region,
+			"city":             city,
+			"latitude":         latitude,
+			"longitude":        longitude,
+			"pageviews":        pageviews,
+			"unique_visitors":  uniqueVisitors,
+		})
+	}
+
+	return locations, nil
+}
+
+// GetTopCountries returns the top countries by pageviews for a date range
+func (s *AdminServer) GetTopCountries(ctx context.Context, websiteID string, startDate, endDate time.Time, limit int, includeBots bool) ([]map[string]interface{}, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT
+			country,
+			country_code,
+			COUNT(*) as pageviews,
+			COUNT(DISTINCT visitor_id) as unique_visitors
+		FROM analytics_pageviews
+		WHERE created_at BETWEEN ? AND ?
+		AND (? OR is_bot = 0)
+		AND country_code IS NOT NULL
+		GROUP BY country, country_code
+		ORDER BY pageviews DESC
+		LIMIT ?
+	`
+
+	rows, err := db.QueryContext(ctx, query, startDate, endDate, includeBots, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var countries []map[string]interface{}
+	for rows.Next() {
+		var country, countryCode string
+		var pageviews, uniqueVisitors int
+		err := rows.Scan(&country, &countryCode, &pageviews, &uniqueVisitors)
+		if err != nil {
+			return nil, err
+		}
+		countries = append(countries, map[string]interface{}{
+			"country":         country,
+			"country_code":    countryCode,
+			"pageviews":       pageviews,
+			"unique_visitors": uniqueVisitors,
+		})
+	}
+
+	return countries, nil
+}
+
+// GeoBreakdown is one country's share of visitors, with its busiest regions
+type GeoBreakdown struct {
+	CountryCode string // empty for visitors GeoIP couldn't place
+	Country     string
+	Visitors    int
+	Pageviews   int
+	Percent     float64 // share of all visitors in the range
+	Regions     []GeoRegion
+}
+
+// GeoRegion is a region's visitor count within a country
+type GeoRegion struct {
+	Region   string
+	Visitors int
+}
+
+// GetGeoBreakdown returns the top countries by unique visitors for a date range, each with its top
+// three regions. Visitors without a location are grouped into a final entry with no country code
+func (s *AdminServer) GetGeoBreakdown(ctx context.Context, websiteID string, startDate, endDate time.Time, limit int, includeBots bool) ([]GeoBreakdown, error) {
+	db, err := s.GetWebsiteReadConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var totalVisitors int
+	err = db.QueryRowContext(ctx, `SELECT COUNT(DISTINCT visitor_id) FROM analytics_pageviews WHERE created_at BETWEEN ? AND ? AND (? OR is_bot = 0)`,
+		startDate, endDate, includeBots).Scan(&totalVisitors)
+	if err != nil {
+		return nil, err
+	}
+	if totalVisitors == 0 {
+		return []GeoBreakdown{}, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT IFNULL(NULLIF(country_code, ''), '') as code, IFNULL(MAX(country), ''),
+			COUNT(DISTINCT visitor_id) as visitors, COUNT(*) as pageviews
+		FROM analytics_pageviews
+		WHERE created_at BETWEEN ? AND ?
+		AND (? OR is_bot = 0)
+		GROUP BY code
+		ORDER BY code = '', visitors DESC
+	`, startDate, endDate, includeBots)
+	if err != nil {
+		return nil, err
+	}
+
+	breakdown := []GeoBreakdown{}
+	var unknown *GeoBreakdown
+	for rows.Next() {
+		var g GeoBreakdown
+		if err := rows.Scan(&g.CountryCode, &g.Country, &g.Visitors, &g.Pageviews); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		g.Percent = float64(g.Visitors) / float64(totalVisitors) * 100
+		if g.CountryCode == "" {
+			g.Country = "Unknown"
+			unknown = &g
+			continue
+		}
+		if len(breakdown) < limit {
+			breakdown = append(breakdown, g)
+		}
+	}
+	rows.Close()
+
+	rows, err = db.QueryContext(ctx, `
+		SELECT country_code, region, COUNT(DISTINCT visitor_id) as visitors
+		FROM analytics_pageviews
+		WHERE created_at BETWEEN ? AND ?
+		AND (? OR is_bot = 0)
+		AND country_code IS NOT NULL AND country_code != ''
+		AND region IS NOT NULL AND region != ''
+		GROUP BY country_code, region
+		ORDER BY visitors DESC
+	`, startDate, endDate, includeBots)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byCode := make(map[string]*GeoBreakdown, len(breakdown))
+	for i := range breakdown {
+		byCode[breakdown[i].CountryCode] = &breakdown[i]
+	}
+	for rows.Next() {
+		var code string
+		var region GeoRegion
+		if err := rows.Scan(&code, &region.Region, &region.Visitors); err != nil {
+			return nil, err
+		}
+		if g, ok := byCode[code]; ok && len(g.Regions) < 3 {
+			g.Regions = append(g.Regions, region)
+		}
+	}
+
+	if unknown != nil {
+		breakdown = append(breakdown, *unknown)
+	}
+
+	return breakdown, nil
+}
+
+// Overview Dashboard Queries
+
+type OverviewStats struct {
+	// Content Stats
+	TotalArticles     int
+	PublishedArticles int
+	DraftArticles     int
+
+	// Analytics Stats
+	TotalPageviews      int
+	PageviewsToday      int
+	PageviewsThisWeek   int
+	PageviewsThisMonth  int
+	UniqueVisitorsToday int
+
+	// E-commerce Stats
+	TotalProducts   int
+	RepeatCustomers int
+	TotalOrders     int
+	TotalRevenue    float64
+	TotalCustomers  int
+	OrdersToShip    int // paid orders still in "processing"
+
+	// Recent Activity
+	OrdersToday         int
+	RevenueToday        float64
+	OrdersThisWeek      int
+	RevenueThisWeek     float64
+	OrdersThisMonth     int
+	RevenueThisMonth    float64
+	PaidOrdersThisMonth int
+
+	// Progress toward the site's monthly goals, one per goal that's set
+	Goals []GoalProgress
+
+	// Marketing Stats
+	TotalSMSSignups int
+
+	// Messages Stats
+	UnreadMessages int
+	TotalMessages  int
+}
+
+// MonthlyGoals are a site's targets for each calendar month, 0 for none
+type MonthlyGoals struct {
+	Revenue float64
+	Orders  int
+}
+
+// GoalProgress is how far the current calendar month has got toward one monthly goal
+type GoalProgress struct {
+	Metric   string // "revenue" or "orders"
+	Target   float64
+	Current  float64
+	Percent  float64 // of the target, past 100 once it's beaten
+	Expected float64 // percent of the month gone, where Percent needs to be to stay on pace
+}
+
+// BarWidth is Percent capped at 100, for drawing a progress bar
+func (g GoalProgress) BarWidth() float64 {
+	return math.Min(g.Percent, 100)
+}
+
+// OnPace reports whether the month is at least as far toward the goal as it is through the month
+func (g GoalProgress) OnPace() bool {
+	return g.Percent >= g.Expected
+}
+
+// goalProgress works out progress toward target with the month monthStart begins, at now
+func goalProgress(metric string, target, current float64, monthStart, now time.Time) GoalProgress {
+	monthEnd := monthStart.AddDate(0, 1, 0)
+	return GoalProgress{
+		Metric:   metric,
+		Target:   target,
+		Current:  current,
+		Percent:  current / target * 100,
+		Expected: float64(now.Sub(monthStart)) / float64(monthEnd.Sub(monthStart)) * 100,
+	}
+}
+
+// overviewStatsTTL is how long overview stats are served from cache
+const overviewStatsTTL = 60 * time.Second
+
+type overviewStatsCacheEntry struct {
+	stats     *OverviewStats
+	timezone  string
+	goals     MonthlyGoals
+	expiresAt time.Time
+}
+
+// overviewStatsCache holds recently computed overview stats per site
+type overviewStatsCache struct {
+	mu      sync.Mutex
+	entries map[string]overviewStatsCacheEntry
+}
+
+// GetCachedOverviewStats returns overview stats from the cache when fresh, otherwise computes and caches them.
+// refresh bypasses the cache.
+func (s *AdminServer) GetCachedOverviewStats(ctx context.Context, websiteID string, timezone string, goals MonthlyGoals, refresh bool) (*OverviewStats, error) {
+	cache := &s.overviewCache
+
+	if !refresh {
+		cache.mu.Lock()
+		entry, ok := cache.entries[websiteID]
+		cache.mu.Unlock()
+		if ok && entry.timezone == timezone && entry.goals == goals && time.Now().Before(entry.expiresAt) {
+			return entry.stats, nil
+		}
+	}
+
+	stats, err := s.GetOverviewStats(ctx, websiteID, timezone, goals)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mu.Lock()
+	if cache.entries == nil {
+		cache.entries = make(map[string]overviewStatsCacheEntry)
+	}
+	cache.entries[websiteID] = overviewStatsCacheEntry{
+		stats:     stats,
+		timezone:  timezone,
+		goals:     goals,
+		expiresAt: time.Now().Add(overviewStatsTTL),
+	}
+	cache.mu.Unlock()
+
+	return stats, nil
+}
+
+// InvalidateOverviewStats drops the cached overview stats for a site
+func (s *AdminServer) InvalidateOverviewStats(websiteID string) {
+	s.overviewCache.mu.Lock()
+	delete(s.overviewCache.entries, websiteID)
+	s.overviewCache.mu.Unlock()
+}
+
+// calendarPeriodStarts returns the start of the day, week (Monday) and month containing now, in now's location.
+// Dates are built with time.Date rather than subtracting durations so DST changes don't shift them off midnight.
+func calendarPeriodStarts(now time.Time) (dayStart, weekStart, monthStart time.Time) {
+	loc := now.Location()
+	dayStart = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	daysSinceMonday := (int(now.Weekday()) + 6) % 7
+	weekStart = time.Date(now.Year(), now.Month(), now.Day()-daysSinceMonday, 0, 0, 0, 0, loc)
+
+	monthStart = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	return dayStart, weekStart, monthStart
+}
+
+// GetOverviewStats returns the overview stats, with today/week/month as calendar periods in the site timezone,
+// and progress toward the site's goals for this month
+func (s *AdminServer) GetOverviewStats(ctx context.Context, websiteID string, timezone string, goals MonthlyGoals) (*OverviewStats, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	return overviewStats(ctx, db, time.Now().In(loc), goals), nil
+}
+
+// overviewStats runs the overview queries against a site's database as of now. Tables a site
+// doesn't have leave their stats at zero
+func overviewStats(ctx context.Context, db *database.TimedDB, now time.Time, goals MonthlyGoals) *OverviewStats {
+	todayStart, weekStart, monthStart := calendarPeriodStarts(now)
+
+	stats := &OverviewStats{}
+
+	// Content Stats
+	err := db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*) as total,
+			SUM(CASE WHEN status = 'published' THEN 1 ELSE 0 END) as published,
+			SUM(CASE WHEN status = 'draft' THEN 1 ELSE 0 END) as draft
+		FROM articles_unified
+	`).Scan(&stats.TotalArticles, &stats.PublishedArticles, &stats.DraftArticles)
+	if err != nil && err != sql.ErrNoRows {
+		// If table doesn't exist, continue with zeros
+		stats.TotalArticles = 0
+		stats.PublishedArticles = 0
+		stats.DraftArticles = 0
+	}
+
+	// E-commerce Stats - Products
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM products_unified`).Scan(&stats.TotalProducts)
+	if err != nil && err != sql.ErrNoRows {
+		stats.TotalProducts = 0
+	}
+
+	// E-commerce Stats - Repeat Customers (customers with 2+ paid orders)
+	err = db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM customers c
+		WHERE (
+			SELECT COUNT(*)
+			FROM orders o
+			WHERE o.customer_id = c.id
+			AND o.payment_status = 'paid'
+		) >= 2
+	`).Scan(&stats.RepeatCustomers)
+	if err != nil && err != sql.ErrNoRows {
+		stats.RepeatCustomers = 0
+	}
+
+	// E-commerce Stats - Orders. All-time totals count only paid orders;
+	// period counts include every order, with revenue from paid orders.
+	var totalRevenue, revenueToday, revenueWeek, revenueMonth sql.NullFloat64
+	err = db.QueryRowContext(ctx, `
+		SELECT
+			COALESCE(SUM(CASE WHEN payment_status = 'paid' THEN 1 ELSE 0 END), 0) as total_orders,
+			COALESCE(SUM(CASE WHEN payment_status = 'paid' THEN total ELSE 0 END), 0) as total_revenue,
+			COALESCE(SUM(CASE WHEN created_at >= ? THEN 1 ELSE 0 END), 0) as orders_today,
+			COALESCE(SUM(CASE WHEN created_at >= ? AND payment_status = 'paid' THEN total ELSE 0 END), 0) as revenue_today,
+			COALESCE(SUM(CASE WHEN created_at >= ? THEN 1 ELSE 0 END), 0) as orders_week,
+			COALESCE(SUM(CASE WHEN created_at >= ? AND payment_status = 'paid' THEN total ELSE 0 END), 0) as revenue_week,
+			COALESCE(SUM(CASE WHEN created_at >= ? THEN 1 ELSE 0 END), 0) as orders_month,
+			COALESCE(SUM(CASE WHEN created_at >= ? AND payment_status = 'paid' THEN total ELSE 0 END), 0) as revenue_month,
+			COALESCE(SUM(CASE WHEN created_at >= ? AND payment_status = 'paid' THEN 1 ELSE 0 END), 0) as paid_orders_month
+		FROM orders
+	`, todayStart, todayStart, weekStart, weekStart, monthStart, monthStart, monthStart).Scan(
+		&stats.TotalOrders, &totalRevenue,
+		&stats.OrdersToday, &revenueToday,
+		&stats.OrdersThisWeek, &revenueWeek,
+		&stats.OrdersThisMonth, &revenueMonth,
+		&stats.PaidOrdersThisMonth,
+	)
+	if err != nil && err != sql.ErrNoRows {
+		stats.TotalOrders, stats.OrdersToday, stats.OrdersThisWeek, stats.OrdersThisMonth, stats.PaidOrdersThisMonth = 0, 0, 0, 0, 0
+	} else {
+		stats.TotalRevenue = totalRevenue.Float64
+		stats.RevenueToday = revenueToday.Float64
+		stats.RevenueThisWeek = revenueWeek.Float64
+		stats.RevenueThisMonth = revenueMonth.Float64
+	}
+
+	// Goals for this calendar month in the site timezone
+	if goals.Revenue > 0 {
+		stats.Goals = append(stats.Goals, goalProgress("revenue", goals.Revenue, stats.RevenueThisMonth, monthStart, now))
+	}
+	if goals.Orders > 0 {
+		stats.Goals = append(stats.Goals, goalProgress("orders", float64(goals.Orders), float64(stats.PaidOrdersThisMonth), monthStart, now))
+	}
+
+	// E-commerce Stats - Orders waiting to be shipped
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM orders WHERE payment_status = 'paid' AND fulfillment_status = 'processing'`).Scan(&stats.OrdersToShip)
+	if err != nil && err != sql.ErrNoRows {
+		stats.OrdersToShip = 0
+	}
+
+	// E-commerce Stats - Customers
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM customers`).Scan(&stats.TotalCustomers)
+	if err != nil && err != sql.ErrNoRows {
+		stats.TotalCustomers = 0
+	}
+
+	// Marketing Stats
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sms_signups`).Scan(&stats.TotalSMSSignups)
+	if err != nil && err != sql.ErrNoRows {
+		stats.TotalSMSSignups = 0
+	}
+
+	// Analytics Stats
+	err = db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*) as total,
+			COALESCE(SUM(CASE WHEN created_at >= ? THEN 1 ELSE 0 END), 0) as today,
+			COALESCE(SUM(CASE WHEN created_at >= ? THEN 1 ELSE 0 END), 0) as week,
+			COALESCE(SUM(CASE WHEN created_at >= ? THEN 1 ELSE 0 END), 0) as month,
+			COUNT(DISTINCT CASE WHEN created_at >= ? THEN session_id END) as unique_today
+		FROM analytics_pageviews
+		WHERE is_bot = 0
+	`, todayStart, weekStart, monthStart, todayStart).Scan(
+		&stats.TotalPageviews,
+		&stats.PageviewsToday,
+		&stats.PageviewsThisWeek,
+		&stats.PageviewsThisMonth,
+		&stats.UniqueVisitorsToday,
+	)
+	if err != nil && err != sql.ErrNoRows {
+		stats.TotalPageviews, stats.PageviewsToday, stats.PageviewsThisWeek, stats.PageviewsThisMonth = 0, 0, 0, 0
+		stats.UniqueVisitorsToday = 0
+	}
+
+	// Messages Stats
+	err = db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*) as total,
+			COALESCE(SUM(CASE WHEN status = 'unread' THEN 1 ELSE 0 END), 0) as unread
+		FROM messages
+	`).Scan(&stats.TotalMessages, &stats.UnreadMessages)
+	if err != nil && err != sql.ErrNoRows {
+		stats.TotalMessages = 0
+		stats.UnreadMessages = 0
+	}
+
+	return stats
+}
+
+// MetricDelta compares a metric for the current period against the preceding one
+type MetricDelta struct {
+	Current     float64
+	Previous    float64
+	Change      float64 // Percent change from Previous, only meaningful when HasPrevious
+	HasPrevious bool
+}
+
+// Up reports whether the metric increased
+func (d MetricDelta) Up() bool {
+	return d.Current > d.Previous
+}
+
+// Down reports whether the metric decreased
+func (d MetricDelta) Down() bool {
+	return d.Current < d.Previous
+}
+
+// AbsChange is the size of the percent change, for display next to an up/down arrow
+func (d MetricDelta) AbsChange() float64 {
+	return math.Abs(d.Change)
+}
+
+func newMetricDelta(current, previous float64) MetricDelta {
+	d := MetricDelta{Current: current, Previous: previous}
+	if previous != 0 {
+		d.Change = (current - previous) / previous * 100
+		d.HasPrevious = true
+	}
+	return d
+}
+
+// OverviewComparison holds the overview trend metrics for the last N days vs the N days before
+type OverviewComparison struct {
+	Days      int
+	Revenue   MetricDelta
+	Orders    MetricDelta
+	Pageviews MetricDelta
+	Signups   MetricDelta
+}
+
+// GetOverviewComparison compares revenue, orders, pageviews and SMS signups for the last `days` days
+// (starting at midnight in the site timezone) against the same elapsed time in the preceding period
+func (s *AdminServer) GetOverviewComparison(ctx context.Context, websiteID string, days int, timezone string) (*OverviewComparison, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	now := time.Now().In(loc)
+	currentStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, -(days - 1))
+	previousStart := currentStart.AddDate(0, 0, -days)
+	previousEnd := previousStart.Add(now.Sub(currentStart))
+
+	// periodMetrics returns revenue, orders, pageviews and signups for [start, end)
+	periodMetrics := func(start, end time.Time) (revenue float64, orders, pageviews, signups int) {
+		err := db.QueryRowContext(ctx, `
+			SELECT COUNT(*), COALESCE(SUM(total), 0)
+			FROM orders
+			WHERE payment_status = 'paid' AND created_at >= ? AND created_at < ?
+		`, start, end).Scan(&orders, &revenue)
+		if err != nil {
+			revenue, orders = 0, 0
+		}
+
+		err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM analytics_pageviews WHERE created_at >= ? AND created_at < ? AND is_bot = 0`, start, end).Scan(&pageviews)
+		if err != nil {
+			pageviews = 0
+		}
+
+		err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sms_signups WHERE created_at >= ? AND created_at < ?`, start, end).Scan(&signups)
+		if err != nil {
+			signups = 0
+		}
+		return
+	}
+
+	curRevenue, curOrders, curPageviews, curSignups := periodMetrics(currentStart, now)
+	prevRevenue, prevOrders, prevPageviews, prevSignups := periodMetrics(previousStart, previousEnd)
+
+	return &OverviewComparison{
+		Days:      days,
+		Revenue:   newMetricDelta(curRevenue, prevRevenue),
+		Orders:    newMetricDelta(float64(curOrders), float64(prevOrders)),
+		Pageviews: newMetricDelta(float64(curPageviews), float64(prevPageviews)),
+		Signups:   newMetricDelta(float64(curSignups), float64(prevSignups)),
+	}, nil
+}
+
+type RecentOrder struct {
+	ID            int
+	OrderNumber   string
+	CustomerName  string
+	CustomerEmail string
+	Total         float64
+	PaymentStatus string
+	CreatedAt     time.Time
+}
+
+func (s *AdminServer) GetRecentOrders(websiteID string, limit int) ([]RecentOrder, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT
+			id,
+			order_number,
+			customer_name,
+			customer_email,
+			total,
+			payment_status,
+			created_at
+		FROM orders
+		ORDER BY created_at DESC
+		LIMIT ?
+	`
+
+	rows, err := db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []RecentOrder
+	for rows.Next() {
+		var order RecentOrder
+		err := rows.Scan(
+			&order.ID,
+			&order.OrderNumber,
+			&order.CustomerName,
+			&order.CustomerEmail,
+			&order.Total,
+			&order.PaymentStatus,
+			&order.CreatedAt,
+		)
+		if err != nil {
+			continue
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, nil
+}
+
+// Message Management Queries
+
+type Message struct {
+	ID           int
+	Name         string
+	Email        string
+	Message      string
+	Status       string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	ReplyCount   int
+	LastReplyAt  *time.Time
+}
+
+type MessageWithReplies struct {
+	ID        int
+	Name      string
+	Email     string
+	Message   string
+	Status    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Replies   []MessageReply
+}
+
+type MessageReply struct {
+	ID        int
+	MessageID int
+	ReplyText string
+	SentAt    time.Time
+	SentBy    string
+}
+
+func (s *AdminServer) GetMessages(websiteID string) ([]Message, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT
+			m.id,
+			m.name,
+			m.email,
+			m.message,
+			m.status,
+			m.created_at,
+			m.updated_at,
+			COALESCE(COUNT(r.id), 0) as reply_count,
+			MAX(r.sent_at) as last_reply_at
+		FROM messages m
+		LEFT JOIN message_replies r ON m.id = r.message_id
+		GROUP BY m.id, m.name, m.email, m.message, m.status, m.created_at, m.updated_at
+		ORDER BY m.created_at DESC
+	`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var msg Message
+		err := rows.Scan(
+			&msg.ID,
+			&msg.Name,
+			&msg.Email,
+			&msg.Message,
+			&msg.Status,
+			&msg.CreatedAt,
+			&msg.UpdatedAt,
+			&msg.ReplyCount,
+			&msg.LastReplyAt,
+		)
+		if err != nil {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, nil
+}
+
+func (s *AdminServer) GetMessage(websiteID string, messageID int) (*MessageWithReplies, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// Get the message
+	messageQuery := `
+		SELECT id, name, email, message, status, created_at, updated_at
+		FROM messages
+		WHERE id = ?
+	`
+
+	var msg MessageWithReplies
+	err = db.QueryRow(messageQuery, messageID).Scan(
+		&msg.ID,
+		&msg.Name,
+		&msg.Email,
+		&msg.Message,
+		&msg.Status,
+		&msg.CreatedAt,
+		&msg.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get replies
+	repliesQuery := `
+		SELECT id, message_id, reply_text, sent_at, sent_by
+		FROM message_replies
+		WHERE message_id = ?
+		ORDER BY sent_at ASC
+	`
+
+	rows, err := db.Query(repliesQuery, messageID)
+	if err != nil {
+		return &msg, nil // Return message even if replies fail
+	}
+	defer rows.Close()
+
+	var replies []MessageReply
+	for rows.Next() {
+		var reply MessageReply
+		err := rows.Scan(
+			&reply.ID,
+			&reply.MessageID,
+			&reply.ReplyText,
+			&reply.SentAt,
+			&reply.SentBy,
+		)
+		if err != nil {
+			continue
+		}
+		replies = append(replies, reply)
+	}
+
+	msg.Replies = replies
+	return &msg, nil
+}
+
+func (s *AdminServer) GetUnreadMessageCount(websiteID string) (int, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	query := `SELECT COUNT(*) FROM messages WHERE status = 'unread'`
+	var count int
+	err = db.QueryRow(query).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (s *AdminServer) DeleteMessage(websiteID string, messageID int) error {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Delete the message (replies will be cascade deleted due to FOREIGN KEY constraint)
+	query := `DELETE FROM messages WHERE id = ?`
+	_, err = db.Exec(query, messageID)
+	return err
+}
+
+// ProductQuestion is a customer question about a product, as moderated in the admin
+type ProductQuestion struct {
+	ID          int
+	ProductID   int
+	ProductName string
+	ProductSlug string
+	Name        string
+	Email       string
+	Question    string
+	Answer      string
+	AnsweredBy  string
+	Status      string // pending, published or hidden
+	CreatedAt   time.Time
+	AnsweredAt  *time.Time
+}
+
+// productQuestionStatuses are the statuses a question can be filtered by or moved to
+var productQuestionStatuses = map[string]bool{"pending": true, "published": true, "hidden": true}
+
+// GetProductQuestions lists a site's product questions with the given status (all when empty), newest first
+func (s *AdminServer) GetProductQuestions(websiteID, status string) ([]ProductQuestion, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT q.id, q.product_id, IFNULL(p.name, ''), IFNULL(p.slug, ''), q.name, q.email, q.question,
+			IFNULL(q.answer, ''), q.answered_by, q.status, q.created_at, q.answered_at
+		FROM product_questions q
+		LEFT JOIN products_unified p ON p.id = q.product_id
+	`
+	var args []interface{}
+	if status != "" {
+		query += ` WHERE q.status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY q.created_at DESC`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var questions []ProductQuestion
+	for rows.Next() {
+		var q ProductQuestion
+		var answeredAt sql.NullTime
+		if err := rows.Scan(&q.ID, &q.ProductID, &q.ProductName, &q.ProductSlug, &q.Name, &q.Email, &q.Question,
+			&q.Answer, &q.AnsweredBy, &q.Status, &q.CreatedAt, &answeredAt); err != nil {
+			return nil, err
+		}
+		if answeredAt.Valid {
+			q.AnsweredAt = &answeredAt.Time
+		}
+		questions = append(questions, q)
+	}
+
+	return questions, rows.Err()
+}
+
+// CountPendingProductQuestions returns how many questions are waiting for an answer
+func (s *AdminServer) CountPendingProductQuestions(websiteID string) (int, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM product_questions WHERE status = 'pending'`).Scan(&count)
+	return count, err
+}
+
+// AnswerProductQuestion saves the answer to a question and publishes it on the product
+func (s *AdminServer) AnswerProductQuestion(websiteID string, questionID int, answer, answeredBy string) error {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		UPDATE product_questions
+		SET answer = ?, answered_by = ?, status = 'published', answered_at = NOW()
+		WHERE id = ?
+	`, answer, answeredBy, questionID)
+	return err
+}
+
+// errQuestionNotAnswered is returned when publishing a question that has no answer yet
+var errQuestionNotAnswered = errors.New("answer the question before publishing it")
+
+// SetProductQuestionStatus moves a question to pending, published or hidden. Only answered
+// questions can be published
+func (s *AdminServer) SetProductQuestionStatus(websiteID string, questionID int, status string) error {
+	if !productQuestionStatuses[status] {
+		return fmt.Errorf("unknown question status %q", status)
+	}
+
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if status == "published" {
+		var answer string
+		if err := db.QueryRow(`SELECT IFNULL(answer, '') FROM product_questions WHERE id = ?`, questionID).Scan(&answer); err != nil {
+			return err
+		}
+		if answer == "" {
+			return errQuestionNotAnswered
+		}
+	}
+
+	_, err = db.Exec(`UPDATE product_questions SET status = ? WHERE id = ?`, status, questionID)
+	return err
+}
+
+// DeleteProductQuestion removes a question
+func (s *AdminServer) DeleteProductQuestion(websiteID string, questionID int) error {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`DELETE FROM product_questions WHERE id = ?`, questionID)
+	return err
+}
+
+// OrderReturn is a customer's request to return items from an order, as processed in the admin
+type OrderReturn struct {
+	ID            int
+	OrderID       int
+	OrderNumber   string
+	CustomerName  string
+	CustomerEmail string
+	Status        string // requested, approved, rejected, received or refunded
+	Reason        string
+	AdminNote     string
+	RefundAmount  float64
+	Restocked     bool
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Items         []OrderReturnItem
+}
+
+// OrderReturnItem is how many of an order line are being returned
+type OrderReturnItem struct {
+	OrderItemID  int
+	ProductID    int
+	VariantID    int
+	ProductName  string
+	VariantTitle string
+	Quantity     int
+	Price        float64
+	Restocked    bool // resellable, so added back to stock when the return was received
+}
+
+// ItemsTotal is what the returned items sold for, the refund suggested by default
+func (r OrderReturn) ItemsTotal() float64 {
+	var total float64
+	for _, item := range r.Items {
+		total += item.Price * float64(item.Quantity)
+	}
+	return utils.RoundMoney(total, "")
+}
+
+// returnStatuses are the statuses returns can be filtered by
+var returnStatuses = map[string]bool{
+	database.ReturnRequested: true, database.ReturnApproved: true, database.ReturnRejected: true,
+	database.ReturnReceived: true, database.ReturnRefunded: true,
+}
+
+// errReturnStatusChanged is returned when a return isn't in the status an action expects, usually
+// because someone else processed it first
+var errReturnStatusChanged = errors.New("this return has already been processed")
+
+// GetOrderReturns lists a site's returns with the given status (all when empty), newest first
+func (s *AdminServer) GetOrderReturns(websiteID, status string) ([]OrderReturn, error) {
+	where, args := "", []interface{}{}
+	if status != "" {
+		where, args = "WHERE r.status = ?", append(args, status)
+	}
+	return s.queryOrderReturns(websiteID, where, args...)
+}
+
+// GetOrderReturnsForOrder lists an order's returns, newest first
+func (s *AdminServer) GetOrderReturnsForOrder(websiteID string, orderID int) ([]OrderReturn, error) {
+	return s.queryOrderReturns(websiteID, "WHERE r.order_id = ?", orderID)
+}
+
+// GetOrderReturn loads a single return with its items
+func (s *AdminServer) GetOrderReturn(websiteID string, returnID int) (OrderReturn, error) {
+	returns, err := s.queryOrderReturns(websiteID, "WHERE r.id = ?", returnID)
+	if err != nil {
+		return OrderReturn{}, err
+	}
+	if len(returns) == 0 {
+		return OrderReturn{}, sql.ErrNoRows
+	}
+	return returns[0], nil
+}
+
+// queryOrderReturns loads the returns matching where, with their order details and items
+func (s *AdminServer) queryOrderReturns(websiteID, where string, args ...interface{}) ([]OrderReturn, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+		SELECT r.id, r.order_id, o.order_number, o.customer_name, o.customer_email, r.status, IFNULL(r.reason, ''),
+			IFNULL(r.admin_note, ''), r.refund_amount, r.restocked, r.created_at, r.updated_at
+		FROM order_returns r
+		JOIN orders o ON o.id = r.order_id
+		`+where+`
+		ORDER BY r.created_at DESC, r.id DESC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var returns []OrderReturn
+	byID := make(map[int]int)
+	for rows.Next() {
+		var r OrderReturn
+		if err := rows.Scan(&r.ID, &r.OrderID, &r.OrderNumber, &r.CustomerName, &r.CustomerEmail, &r.Status, &r.Reason,
+			&r.AdminNote, &r.RefundAmount, &r.Restocked, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		byID[r.ID] = len(returns)
+		returns = append(returns, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(returns) == 0 {
+		return returns, nil
+	}
+
+	itemRows, err := db.Query(`
+		SELECT ri.return_id, ri.order_item_id, oi.product_id, IFNULL(oi.variant_id, 0), oi.product_name,
+			IFNULL(oi.variant_title, ''), ri.quantity, oi.price, ri.restocked
+		FROM order_return_items ri
+		JOIN order_returns r ON r.id = ri.return_id
+		JOIN order_items oi ON oi.id = ri.order_item_id
+		`+where+`
+		ORDER BY ri.return_id, ri.order_item_id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer itemRows.Close()
+
+	for itemRows.Next() {
+		var returnID int
+		var item OrderReturnItem
+		if err := itemRows.Scan(&returnID, &item.OrderItemID, &item.ProductID, &item.VariantID, &item.ProductName,
+			&item.VariantTitle, &item.Quantity, &item.Price, &item.Restocked); err != nil {
+			return nil, err
+		}
+		if i, ok := byID[returnID]; ok {
+			returns[i].Items = append(returns[i].Items, item)
+		}
+	}
+
+	return returns, itemRows.Err()
+}
+
+// CountRequestedOrderReturns returns how many returns are waiting for approval
+func (s *AdminServer) CountRequestedOrderReturns(websiteID string) (int, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM order_returns WHERE status = ?`, database.ReturnRequested).Scan(&count)
+	return count, err
+}
+
+// SetOrderReturnStatus moves a return from one status to another, saving note for the customer
+// when it isn't empty. Returns errReturnStatusChanged when the return isn't in status from
+func (s *AdminServer) SetOrderReturnStatus(websiteID string, returnID int, from, to, note string) error {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := db.Exec(`
+		UPDATE order_returns
+		SET status = ?, admin_note = IF(? = '', admin_note, ?)
+		WHERE id = ? AND status = ?
+	`, to, note, note, returnID, from)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return errReturnStatusChanged
+	}
+	return nil
+}
+
+// SetOrderReturnRefundAmount records how much was refunded for a return
+func (s *AdminServer) SetOrderReturnRefundAmount(websiteID string, returnID int, amount float64) error {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`UPDATE order_returns SET refund_amount = ? WHERE id = ?`, amount, returnID)
+	return err
+}
+
+// ReceiveOrderReturn marks an approved return as received, adding the lines in resellable (keyed
+// by order item ID) back to stock; damaged items stay out. Each restock is recorded in
+// inventory_log with the reason "return". Once every item of the order has come back, the order's
+// fulfillment status becomes returned
+func (s *AdminServer) ReceiveOrderReturn(websiteID string, returnID int, resellable map[int]bool, note string) error {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var orderID int
+	var status string
+	err = tx.QueryRow(`SELECT order_id, status FROM order_returns WHERE id = ? FOR UPDATE`, returnID).Scan(&orderID, &status)
+	if err != nil {
+		return err
+	}
+	if status != database.ReturnApproved {
+		return errReturnStatusChanged
+	}
+
+	rows, err := tx.Query(`
+		SELECT ri.order_item_id, oi.product_id, IFNULL(oi.variant_id, 0), ri.quantity
+		FROM order_return_items ri
+		JOIN order_items oi ON oi.id = ri.order_item_id
+		WHERE ri.return_id = ?
+	`, returnID)
+	if err != nil {
+		return err
+	}
+	type restockLine struct{ orderItemID, productID, variantID, quantity int }
+	var lines []restockLine
+	for rows.Next() {
+		var line restockLine
+		if err := rows.Scan(&line.orderItemID, &line.productID, &line.variantID, &line.quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		if resellable[line.orderItemID] {
+			lines = append(lines, line)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	restocked := false
+	for _, line := range lines {
+		// A bundle holds no stock of its own, so its components go back on the shelf, the way
+		// CreateOrder took them off
+		targets := []restockLine{line}
+		if line.variantID == 0 {
+			components, err := tx.Query(`SELECT component_id, quantity FROM product_bundle_items WHERE bundle_id = ?`, line.productID)
+			if err != nil {
+				return err
+			}
+			var bundle []restockLine
+			for components.Next() {
+				var componentID, quantity int
+				if err := components.Scan(&componentID, &quantity); err != nil {
+					components.Close()
+					return err
+				}
+				bundle = append(bundle, restockLine{orderItemID: line.orderItemID, productID: componentID, quantity: quantity * line.quantity})
+			}
+			components.Close()
+			if err := components.Err(); err != nil {
+				return err
+			}
+			if len(bundle) > 0 {
+				targets = bundle
+			}
+		}
+
+		lineRestocked := false
+		for _, target := range targets {
+			var sku string
+			var quantityAfter int
+			var variantID interface{}
+			if target.variantID != 0 {
+				_, err = tx.Exec(`UPDATE product_variants SET inventory_quantity = inventory_quantity + ? WHERE id = ?`, target.quantity, target.variantID)
+				if err == nil {
+					err = tx.QueryRow(`SELECT IFNULL(sku, ''), inventory_quantity FROM product_variants WHERE id = ?`, target.variantID).Scan(&sku, &quantityAfter)
+				}
+				variantID = target.variantID
+			} else {
+				_, err = tx.Exec(`UPDATE products_unified SET inventory_quantity = inventory_quantity + ? WHERE id = ?`, target.quantity, target.productID)
+				if err == nil {
+					err = tx.QueryRow(`SELECT IFNULL(sku, ''), inventory_quantity FROM products_unified WHERE id = ?`, target.productID).Scan(&sku, &quantityAfter)
+				}
+			}
+			if err == sql.ErrNoRows {
+				// The product was deleted since the order, so there's nothing to restock
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(`
+				INSERT INTO inventory_log (product_id, variant_id, sku, delta, quantity_after, reason, created_at)
+				VALUES (?, ?, ?, ?, ?, 'return', NOW())
+			`, target.productID, variantID, sku, target.quantity, quantityAfter)
+			if err != nil {
+				return err
+			}
+			lineRestocked = true
+		}
+		if !lineRestocked {
+			continue
+		}
+
+		if _, err := tx.Exec(`UPDATE order_return_items SET restocked = 1 WHERE return_id = ? AND order_item_id = ?`, returnID, line.orderItemID); err != nil {
+			return err
+		}
+		restocked = true
+	}
+
+	_, err = tx.Exec(`
+		UPDATE order_returns
+		SET status = ?, restocked = ?, admin_note = IF(? = '', admin_note, ?)
+		WHERE id = ?
+	`, database.ReturnReceived, restocked, note, note, returnID)
+	if err != nil {
+		return err
+	}
+
+	// Every ordered unit is in a received or refunded return
+	var outstanding int
+	err = tx.QueryRow(`
+		SELECT COUNT(*)
+		FROM order_items oi
+		WHERE oi.order_id = ? AND oi.quantity > (
+			SELECT IFNULL(SUM(ri.quantity), 0)
+			FROM order_return_items ri
+			JOIN order_returns r ON r.id = ri.return_id
+			WHERE ri.order_item_id = oi.id AND r.status IN (?, ?)
+		)
+	`, orderID, database.ReturnReceived, database.ReturnRefunded).Scan(&outstanding)
+	if err != nil {
+		return err
+	}
+	if outstanding == 0 {
+		if _, err := tx.Exec(`UPDATE orders SET fulfillment_status = 'returned', updated_at = NOW() WHERE id = ?`, orderID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetSMSSignupByID retrieves a single SMS signup by ID
+func (s *AdminServer) GetSMSSignupByID(websiteID string, signupID int) (SMSSignup, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return SMSSignup{}, err
+	}
+	defer db.Close()
+
+	sqlQuery := `
+		SELECT id, COALESCE(country_code, '+1'), phone, COALESCE(email, ''), COALESCE(source, ''), verified, created_at
+		FROM sms_signups
+		WHERE id = ?
+	`
+	var signup SMSSignup
+	err = db.QueryRow(sqlQuery, signupID).Scan(&signup.ID, &signup.CountryCode, &signup.Phone, &signup.Email, &signup.Source, &signup.Verified, &signup.CreatedAt)
+	if err != nil {
+		return SMSSignup{}, err
+	}
+	return signup, nil
+}
+
+// SetSMSVerificationCode stores a verification code for an SMS signup
+func (s *AdminServer) SetSMSVerificationCode(websiteID, countryCode, phone, verificationCode string) error {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	sqlQuery := `
+		UPDATE sms_signups
+		SET verification_code = ?, verification_expires_at = DATE_ADD(NOW(), INTERVAL 15 MINUTE)
+		WHERE country_code = ? AND phone = ?
+	`
+	_, err = db.Exec(sqlQuery, verificationCode, countryCode, phone)
+	return err
+}
+
+// ClearOrderShippingLabel clears shipping label information from an order
+func (s *AdminServer) ClearOrderShippingLabel(websiteID string, orderID int, fulfillmentStatus string) error {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	sqlQuery := `
+		UPDATE orders
+		SET tracking_number = NULL,
+			shipping_carrier = NULL,
+			shipping_label_url = NULL,
+			shipping_label_cost = NULL,
+			shippo_transaction_id = NULL,
+			fulfillment_status = ?,
+			updated_at = NOW()
+		WHERE id = ?
+	`
+	_, err = db.Exec(sqlQuery, fulfillmentStatus, orderID)
+	return err
+}
+
+// GetTwilio returns a Twilio client for the given website
+func (s *AdminServer) GetTwilio(website *Website) *twilio.Client {
+	if website.TwilioAccountSID == "" || website.TwilioAuthToken == "" || website.TwilioFromPhone == "" {
+		return nil
+	}
+	return twilio.NewClient(website.TwilioAccountSID, website.TwilioAuthToken, website.TwilioFromPhone)
+}
+
+// UpdateOrderRefundedAmount updates the refunded amount for an order
+func (s *AdminServer) UpdateOrderRefundedAmount(websiteID string, orderID int, refundedAmount float64) error {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	sqlQuery := `
+		UPDATE orders
+		SET refunded_amount = ?,
+			updated_at = NOW()
+		WHERE id = ?
+	`
+	_, err = db.Exec(sqlQuery, refundedAmount, orderID)
+	return err
+}
+
+// TranslationField is a column of a product or article that can be translated
+type TranslationField struct {
+	Column    string
+	Label     string
+	Multiline bool
+}
+
+// translatable describes the table holding one kind of content's translations
+type translatable struct {
+	table    string
+	idColumn string
+	fields   []TranslationField
+}
+
+var (
+	productTranslations = translatable{"product_translations", "product_id", []TranslationField{
+		{"name", "Name", false},
+		{"description", "Description", true},
+	}}
+	articleTranslations = translatable{"article_translations", "article_id", []TranslationField{
+		{"title", "Title", false},
+		{"description", "Description", true},
+		{"deck", "Deck", true},
+		{"content", "Content", true},
+	}}
+)
+
+// Translation is a product's or article's text in one locale, keyed by column
+type Translation struct {
+	Locale    string
+	Values    map[string]string
+	UpdatedAt time.Time
+}
+
+// errInvalidLocale is returned when saving a translation under something that isn't a language tag
+var errInvalidLocale = errors.New("locale must be a language tag like fr or pt-BR")
+
+// getTranslations returns every translation of a product or article, by locale
+func (s *AdminServer) getTranslations(websiteID string, kind translatable, id int) ([]Translation, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	columns := make([]string, len(kind.fields))
+	for i, f := range kind.fields {
+		columns[i] = "IFNULL(" + f.Column + ", '')"
+	}
+
+	rows, err := db.Query(`
+		SELECT locale, updated_at, `+strings.Join(columns, ", ")+`
+		FROM `+kind.table+`
+		WHERE `+kind.idColumn+` = ?
+		ORDER BY locale
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	translations := []Translation{}
+	for rows.Next() {
+		var t Translation
+		values := make([]string, len(kind.fields))
+		dest := []interface{}{&t.Locale, &t.UpdatedAt}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		t.Values = make(map[string]string, len(kind.fields))
+		for i, f := range kind.fields {
+			t.Values[f.Column] = values[i]
+		}
+		translations = append(translations, t)
+	}
+
+	return translations, rows.Err()
+}
+
+// saveTranslation creates or replaces a product's or article's translation into a locale
+func (s *AdminServer) saveTranslation(websiteID string, kind translatable, id int, locale string, values map[string]string) error {
+	locale = utils.NormalizeLocale(locale)
+	if locale == "" {
+		return errInvalidLocale
+	}
+
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	columns := []string{kind.idColumn, "locale"}
+	args := []interface{}{id, locale}
+	var updates []string
+	for _, f := range kind.fields {
+		columns = append(columns, f.Column)
+		args = append(args, values[f.Column])
+		updates = append(updates, f.Column+" = VALUES("+f.Column+")")
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO `+kind.table+` (`+strings.Join(columns, ", ")+`)
+		VALUES (`+strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")+`)
+		ON DUPLICATE KEY UPDATE `+strings.Join(updates, ", "), args...)
+	return err
+}
+
+// deleteTranslation removes a product's or article's translation into a locale
+func (s *AdminServer) deleteTranslation(websiteID string, kind translatable, id int, locale string) error {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`DELETE FROM `+kind.table+` WHERE `+kind.idColumn+` = ? AND locale = ?`, id, locale)
+	return err
+}
+
+// StoreCreditTransaction is store credit issued to or spent by a customer
+type StoreCreditTransaction struct {
+	ID           int
+	Amount       float64 // negative when spent
+	BalanceAfter float64
+	OrderID      int    // 0 when not tied to an order
+	OrderNumber  string // empty when not tied to an order, or the order was deleted
+	Reason       string // refund, checkout or adjustment
+	CreatedBy    string // admin user, empty for checkout
+	CreatedAt    time.Time
+}
+
+// GetStoreCreditTransactions returns a customer's store credit history, newest first
+func (s *AdminServer) GetStoreCreditTransactions(websiteID string, customerID int) ([]StoreCreditTransaction, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+		SELECT t.id, t.amount, t.balance_after, IFNULL(t.order_id, 0), IFNULL(o.order_number, ''), t.reason, t.created_by, t.created_at
+		FROM store_credit_transactions t
+		LEFT JOIN orders o ON o.id = t.order_id
+		WHERE t.customer_id = ?
+		ORDER BY t.created_at DESC, t.id DESC
+	`, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []StoreCreditTransaction{}
+	for rows.Next() {
+		var t StoreCreditTransaction
+		err := rows.Scan(&t.ID, &t.Amount, &t.BalanceAfter, &t.OrderID, &t.OrderNumber, &t.Reason, &t.CreatedBy, &t.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, t)
+	}
+
+	return transactions, rows.Err()
+}
+
+// storeCreditCode makes the code a customer gives at checkout to spend their credit
+func storeCreditCode() string {
+	return strings.ToUpper(utils.GenerateSessionID()[:16])
+}
+
+// issueStoreCredit adds amount to a customer's balance in tx, giving them a code if they don't have
+// one yet, and records it
+func issueStoreCredit(tx *sql.Tx, customerID int, amount float64, orderID interface{}, reason, createdBy string) error {
+	result, err := tx.Exec(`
+		UPDATE customers SET store_credit = store_credit + ?, store_credit_code = IFNULL(store_credit_code, ?)
+		WHERE id = ?
+	`, amount, storeCreditCode(), customerID)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO store_credit_transactions (customer_id, amount, balance_after, order_id, reason, created_by, created_at)
+		SELECT id, ?, store_credit, ?, ?, ?, NOW() FROM customers WHERE id = ?
+	`, amount, orderID, reason, createdBy, customerID)
+	return err
+}
+
+// IssueStoreCredit adds store credit to a customer's balance outside of a refund
+func (s *AdminServer) IssueStoreCredit(websiteID string, customerID int, amount float64, createdBy string) error {
+	if amount <= 0 {
+		return fmt.Errorf("store credit must be more than zero")
+	}
+
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := issueStoreCredit(tx, customerID, amount, nil, "adjustment", createdBy); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// errNoOrderCustomer is returned when refunding to store credit an order with no customer to hold it
+var errNoOrderCustomer = errors.New("this order has no customer to give store credit to")
+
+// RefundOrderToStoreCredit refunds amount of an order as store credit for its customer instead of
+// to the card, adding it to the order's refunded amount. Returns the new refunded amount
+func (s *AdminServer) RefundOrderToStoreCredit(websiteID string, orderID int, amount float64, createdBy string) (float64, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var customerID sql.NullInt64
+	var total, refunded float64
+	err = tx.QueryRow(`SELECT customer_id, total, refunded_amount FROM orders WHERE id = ? FOR UPDATE`, orderID).Scan(&customerID, &total, &refunded)
+	if err != nil {
+		return 0, err
+	}
+	if !customerID.Valid {
+		return 0, errNoOrderCustomer
+	}
+	if utils.ToCents(amount, "") > utils.ToCents(total, "")-utils.ToCents(refunded, "") {
+		return 0, fmt.Errorf("refund amount ($%.2f) exceeds remaining refundable amount ($%.2f)", amount, total-refunded)
+	}
+
+	if err := issueStoreCredit(tx, int(customerID.Int64), amount, orderID, "refund", createdBy); err != nil {
+		return 0, err
+	}
+	refunded = utils.RoundMoney(refunded+amount, "")
+	if _, err := tx.Exec(`UPDATE orders SET refunded_amount = ?, updated_at = NOW() WHERE id = ?`, refunded, orderID); err != nil {
+		return 0, err
+	}
+
+	return refunded, tx.Commit()
+}
+
+// UpdateOrderDetails updates customer info, shipping address, and order totals
+func (s *AdminServer) UpdateOrderDetails(websiteID string, orderID int, customerName, customerEmail string, shippingAddr map[string]string, subtotal, tax, total float64) error {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	sqlQuery := `
+		UPDATE orders
+		SET customer_name = ?,
+			customer_email = ?,
+			shipping_address_line1 = ?,
+			shipping_address_line2 = ?,
+			shipping_city = ?,
+			shipping_state = ?,
+			shipping_zip = ?,
+			shipping_country = ?,
+			subtotal = ?,
+			tax = ?,
+			total = ?,
+			updated_at = NOW()
+		WHERE id = ?
+	`
+	_, err = db.Exec(sqlQuery,
+		customerName, customerEmail,
+		shippingAddr["line1"], shippingAddr["line2"],
+		shippingAddr["city"], shippingAddr["state"],
+		shippingAddr["zip"], utils.NormalizeCountry(shippingAddr["country"]),
+		subtotal, tax, total,
+		orderID,
+	)
+	return err
+}
+
+// UpdateOrderItems updates, deletes, and manages inventory for order items
+func (s *AdminServer) UpdateOrderItems(websiteID string, orderID int, newItems []map[string]interface{}, deletedItemIDs []string, originalItems []OrderItem) error {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Create map of original items for easy lookup
+	originalItemsMap := make(map[int]OrderItem)
+	for _, item := range originalItems {
+		originalItemsMap[item.ID] = item
+	}
+
+	// Update existing items
+	for _, item := range newItems {
+		itemID := item["id"].(int)
+		quantity := item["quantity"].(int)
+		price := item["price"].(float64)
+		total := item["total"].(float64)
+
+		// Update item in database
+		sqlQuery := `
+			UPDATE order_items
+			SET quantity = ?,
+				price = ?,
+				total = ?
+			WHERE id = ? AND order_id = ?
+		`
+		_, err = db.Exec(sqlQuery, quantity, price, total, itemID, orderID)
+		if err != nil {
+			return fmt.Errorf("failed to update order item %d: %w", itemID, err)
+		}
+
+		// Update inventory if quantity changed
+		if originalItem, exists := originalItemsMap[itemID]; exists {
+			quantityDiff := quantity - originalItem.Quantity
+			if quantityDiff != 0 {
+				productID := item["product_id"].(int)
+				variantID := item["variant_id"].(int)
+
+				// Restore old quantity, deduct new quantity
+				if variantID > 0 {
+					// Update variant inventory
+					sqlQuery = `
+						UPDATE product_variants
+						SET inventory_quantity = inventory_quantity - ?
+						WHERE id = ?
+					`
+					_, err = db.Exec(sqlQuery, quantityDiff, variantID)
+				} else {
+					// Update product inventory
+					sqlQuery = `
+						UPDATE products
+						SET inventory_quantity = inventory_quantity - ?
+						WHERE id = ?
+					`
+					_, err = db.Exec(sqlQuery, quantityDiff, productID)
+				}
+				if err != nil {
+					log.Printf("Warning: Failed to update inventory for item %d: %v", itemID, err)
+				}
+			}
+		}
+	}
+
+	// Delete removed items and restore their inventory
+	for _, deletedIDStr := range deletedItemIDs {
+		deletedID, _ := strconv.Atoi(deletedIDStr)
+
+		// Find original item to restore inventory
+		if originalItem, exists := originalItemsMap[deletedID]; exists {
+			// Restore inventory
+			if originalItem.VariantID > 0 {
+				sqlQuery := `
+					UPDATE product_variants
+					SET inventory_quantity = inventory_quantity + ?
+					WHERE id = ?
+				`
+				_, err = db.Exec(sqlQuery, originalItem.Quantity, originalItem.VariantID)
+			} else {
+				sqlQuery := `
+					UPDATE products
+					SET inventory_quantity = inventory_quantity + ?
+					WHERE id = ?
+				`
+				_, err = db.Exec(sqlQuery, originalItem.Quantity, originalItem.ProductID)
+			}
+			if err != nil {
+				log.Printf("Warning: Failed to restore inventory for deleted item %d: %v", deletedID, err)
+			}
+		}
+
+		// Delete item from database
+		sqlQuery := `DELETE FROM order_items WHERE id = ? AND order_id = ?`
+		_, err = db.Exec(sqlQuery, deletedID, orderID)
+		if err != nil {
+			return fmt.Errorf("failed to delete order item %d: %w", deletedID, err)
+		}
+	}
+
+	return nil
+}
+
+// shipsOrder reports whether an order moved to the fulfillment status has left for the customer,
+// which an authorized payment has to be captured for (see CaptureOrderPayment)
+func shipsOrder(fulfillmentStatus string) bool {
+	switch fulfillmentStatus {
+	case "fulfilled", "shipped", "delivered":
+		return true
+	}
+	return false
+}
+
+// authorizationLifetime is how long Stripe holds an uncaptured card authorization
+const authorizationLifetime = 7 * 24 * time.Hour
+
+// CaptureOrderPayment captures a previously authorized (manual capture) payment and marks the order paid.
+// Orders that aren't authorized are left alone, so it's safe to call on every shipment.
+func (s *AdminServer) CaptureOrderPayment(websiteID string, orderID int) error {
+	order, err := s.GetOrder(websiteID, orderID)
+	if err != nil {
+		return err
+	}
+
+	if order.PaymentStatus != "authorized" {
+		return nil
+	}
+
+	if order.StripePaymentIntent == "" {
+		return fmt.Errorf("no stripe payment intent found")
+	}
+
+	// Stripe cancels uncaptured authorizations after 7 days
+	if time.Since(order.CreatedAt) > authorizationLifetime {
+		s.UpdateOrderPaymentStatus(websiteID, orderID, "expired")
+		return fmt.Errorf("payment authorization expired on %s - the customer needs to pay again", order.CreatedAt.Add(authorizationLifetime).Format("Jan 2, 2006"))
+	}
+
+	website, err := s.GetWebsite(websiteID)
+	if err != nil {
+		return err
+	}
+
+	stripe.Key = website.StripeSecretKey
+
+	pi, err := paymentintent.Capture(order.StripePaymentIntent, nil)
+	if err != nil {
+		// Sync the status if Stripe already canceled the authorization
+		if current, getErr := paymentintent.Get(order.StripePaymentIntent, nil); getErr == nil && current.Status == stripe.PaymentIntentStatusCanceled {
+			s.UpdateOrderPaymentStatus(websiteID, orderID, "expired")
+		}
+		return fmt.Errorf("failed to capture payment: %w", err)
+	}
+
+	if pi.Status != stripe.PaymentIntentStatusSucceeded {
+		return fmt.Errorf("payment capture returned status %s", pi.Status)
+	}
+
+	// Stripe's payment_intent.succeeded webhook may have marked it paid already; count it once
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := db.Exec(`UPDATE orders SET payment_status = 'paid', updated_at = NOW() WHERE id = ? AND payment_status <> 'paid'`, orderID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		metrics.RecordPaidOrder(website.SiteName, website.Currency, order.Total)
+	}
+	return nil
+}
+
+// UpdateOrderPaymentStatus updates the payment status of an order
+func (s *AdminServer) UpdateOrderPaymentStatus(websiteID string, orderID int, status string) error {
+	db, err := s.GetWebsiteConnection(websiteID)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	query := `UPDATE orders SET payment_status = ?, updated_at = NOW() WHERE id = ?`
+	_, err = db.Exec(query, status, orderID)
+	return err
+}
+
+// AdjustOrderPayment handles payment adjustments when order total changes
+func (s *AdminServer) AdjustOrderPayment(websiteID string, orderID int, order *Order, difference float64) error {
+	// Get website for Stripe config
+	website, err := s.GetWebsite(websiteID)
+	if err != nil {
+		return err
+	}
+
+	// Initialize Stripe client
+	stripe.Key = website.StripeSecretKey
+
+	if order.StripePaymentIntent == "" {
+		return fmt.Errorf("no stripe payment intent found")
+	}
+
+	if difference > 0 {
+		// Total increased - charge additional amount
+		// Note: In a real implementation, you'd need to:
+		// 1. Send a payment link to the customer
+		// 2. Or use a saved payment method if available
+		// 3. Create a new PaymentIntent for the difference
+		// For now, we'll log this and return an error
+		log.Printf("Order %d requires additional payment of %s", orderID, utils.FormatMoney(difference, website.Currency))
+		return fmt.Errorf("order total increased by %s - customer needs to be charged separately", utils.FormatMoney(difference, website.Currency))
+
+	} else if difference < 0 {
+		// Total decreased - issue refund, in the currency the order was charged in
+		refundAmount := -difference
+
+		refundParams := &stripe.RefundParams{
+			PaymentIntent: stripe.String(order.StripePaymentIntent),
+			Amount:        stripe.Int64(utils.StripeAmount(refundAmount, website.Currency)),
+		}
+
+		_, err := refund.New(refundParams)
+		if err != nil {
+			return fmt.Errorf("failed to refund difference: %w", err)
+		}
+
+		// Update refunded amount in database
+		newRefundedAmount := utils.RoundMoney(order.RefundedAmount+refundAmount, "")
+		err = s.UpdateOrderRefundedAmount(websiteID, orderID, newRefundedAmount)
+		if err != nil {
+			log.Printf("Refund processed but failed to update database: %v", err)
+		}
+	}
+
+	return nil
+}
 
-	var totalOrders int
-	var totalRevenue, avgOrderValue, highestOrder sql.NullFloat64
+// ====================
+// Site Export / Import
+// ====================
 
-	err = db.QueryRow(query, startDate, endDate).Scan(&totalOrders, &totalRevenue, &avgOrderValue, &highestOrder)
+// siteExportVersion is bumped when the export format changes in a way ImportSite can't read
+const siteExportVersion = 1
+
+// siteExportTables are the content tables in a site export, parents first. Orders, customers and
+// analytics aren't content and stay out of it
+var siteExportTables = []string{
+	"categories_unified",
+	"authors_unified",
+	"tags_unified",
+	"images_unified",
+	"articles_unified",
+	"article_information",
+	"article_authors",
+	"article_categories",
+	"article_tags",
+	"collections_unified",
+	"products_unified",
+	"product_collections",
+	"product_images_data",
+	"product_variants",
+}
+
+// ExportSite writes a JSON bundle of a website's content and its config, with secrets redacted.
+// Rows are streamed table by table so large sites don't have to fit in memory. Uploaded files
+// aren't included, only their metadata
+func (s *AdminServer) ExportSite(websiteID string, w io.Writer) error {
+	website, err := s.GetWebsite(websiteID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	metrics := map[string]interface{}{
-		"total_orders": totalOrders,
-		"total_revenue": func() float64 {
-			if totalRevenue.Valid {
-				return totalRevenue.Float64
-			}
-			return 0
-		}(),
-		"avg_order_value": func() float64 {
-			if avgOrderValue.Valid {
-				return avgOrderValue.Float64
-			}
-			return 0
-		}(),
-		"highest_order": func() float64 {
-			if highestOrder.Valid {
-				return highestOrder.Float64
-			}
-			return 0
-		}(),
+	siteConfig, err := s.redactedSiteConfig(website)
+	if err != nil {
+		return err
 	}
 
-	return metrics, nil
-}
-
-// GetLocationStats returns geographic statistics for pageviews in a date range
-func (s *AdminServer) GetLocationStats(websiteID string, startDate, endDate time.Time) ([]map[string]interface{}, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer db.Close()
 
-	query := `
-		SELECT
-			country,
-			country_code,
-			region,
-			city,
-			latitude,
-			longitude,
-			COUNT(*) as pageviews,
-			COUNT(DISTINCT visitor_id) as unique_visitors
-		FROM analytics_pageviews
-		WHERE created_at BETWEEN ? AND ?
-		AND country_code IS NOT NULL
-		AND latitude IS NOT NULL
-		AND longitude IS NOT NULL
-		GROUP BY country, country_code, region, city, latitude, longitude
-		ORDER BY pageviews DESC
-	`
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
 
-	rows, err := db.Query(query, startDate, endDate)
-	if err != nil {
-		return nil, err
+	fmt.Fprintf(bw, `{"version":%d,"exportedAt":`, siteExportVersion)
+	enc.Encode(time.Now().UTC())
+	bw.WriteString(`,"site":`)
+	if err := enc.Encode(siteConfig); err != nil {
+		return err
 	}
-	defer rows.Close()
+	bw.WriteString(`,"tables":{`)
 
-	var locations []map[string]interface{}
-	for rows.Next() {
-		var country, countryCode, region, city string
-		var latitude, longitude float64
-		var pageviews, uniqueVisitors int
-		err := rows.Scan(&country, &countryCode, &region, &city, &latitude, &longitude, &pageviews, &uniqueVisitors)
-		if err != nil {
-			return nil, err
+	for i, table := range siteExportTables {
+		if i > 0 {
+			bw.WriteString(",")
 		}
-		locations = append(locations, map[string]interface{}{
-			"country":          country,
-			"country_code":     countryCode,
-			"region":           region,
-			"city":             city,
-			"latitude":         latitude,
-			"longitude":        longitude,
-			"pageviews":        pageviews,
-			"unique_visitors":  uniqueVisitors,
-		})
+		enc.Encode(table)
+		bw.WriteString(":[")
+		if err := exportTable(db, table, bw, enc); err != nil {
+			return fmt.Errorf("exporting %s: %v", table, err)
+		}
+		bw.WriteString("]")
 	}
 
-	return locations, nil
+	bw.WriteString("}}\n")
+	return bw.Flush()
 }
 
-// GetTopCountries returns the top countries by pageviews for a date range
-func (s *AdminServer) GetTopCountries(websiteID string, startDate, endDate time.Time, limit int) ([]map[string]interface{}, error) {
-	db, err := s.GetWebsiteConnection(websiteID)
+// exportTable writes every row of a table as a JSON object keyed by column name
+func exportTable(db *database.TimedDB, table string, bw *bufio.Writer, enc *json.Encoder) error {
+	rows, err := db.Query("SELECT * FROM " + table)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer db.Close()
-
-	query := `
-		SELECT
-			country,
-			country_code,
-			COUNT(*) as pageviews,
-			COUNT(DISTINCT visitor_id) as unique_visitors
-		FROM analytics_pageviews
-		WHERE created_at BETWEEN ? AND ?
-		AND country_code IS NOT NULL
-		GROUP BY country, country_code
-		ORDER BY pageviews DESC
-		LIMIT ?
-	`
+	defer rows.Close()
 
-	rows, err := db.Query(query, startDate, endDate, limit)
+	columns, err := rows.Columns()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	var countries []map[string]interface{}
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	first := true
 	for rows.Next() {
-		var country, countryCode string
-		var pageviews, uniqueVisitors int
-		err := rows.Scan(&country, &countryCode, &pageviews, &uniqueVisitors)
-		if err != nil {
-			return nil, err
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			switch v := values[i].(type) {
+			case []byte:
+				row[column] = string(v)
+			case time.Time:
+				// MySQL won't take RFC 3339 timestamps back, so use its own format
+				row[column] = v.Format("2006-01-02 15:04:05")
+			default:
+				row[column] = v
+			}
+		}
+
+		if !first {
+			bw.WriteString(",")
+		}
+		first = false
+		if err := enc.Encode(row); err != nil {
+			return err
 		}
-		countries = append(countries, map[string]interface{}{
-			"country":         country,
-			"country_code":    countryCode,
-			"pageviews":       pageviews,
-			"unique_visitors": uniqueVisitors,
-		})
 	}
 
-	return countries, nil
+	return rows.Err()
 }
 
-// Overview Dashboard Queries
-
-type OverviewStats struct {
-	// Content Stats
-	TotalArticles   int
-	PublishedArticles int
-	DraftArticles   int
+// redactedSiteConfig reads a website's config file with anything that looks like a credential blanked out
+func (s *AdminServer) redactedSiteConfig(website Website) (map[string]interface{}, error) {
+	configName := "config-dev.json"
+	if s.EnvConfig.ProdMode {
+		configName = "config-prod.json"
+	}
 
-	// Analytics Stats
-	TotalPageviews    int
-	PageviewsToday    int
-	PageviewsThisWeek int
-	PageviewsThisMonth int
-	UniqueVisitorsToday int
+	data, err := ioutil.ReadFile(filepath.Join("websites", website.Directory, configName))
+	if err != nil {
+		return nil, err
+	}
 
-	// E-commerce Stats
-	TotalProducts    int
-	RepeatCustomers  int
-	TotalOrders      int
-	TotalRevenue     float64
-	TotalCustomers   int
+	var config map[string]interface{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
 
-	// Recent Activity
-	OrdersToday     int
-	RevenueToday    float64
-	OrdersThisWeek  int
-	RevenueThisWeek float64
-	OrdersThisMonth int
-	RevenueThisMonth float64
+	redactSecrets(config, "REDACTED")
+	return config, nil
+}
 
-	// Marketing Stats
-	TotalSMSSignups int
+// redactSecrets replaces non-empty secret values (keys, passwords, tokens, webhook URLs) anywhere in
+// a decoded config with replacement
+func redactSecrets(v interface{}, replacement string) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for key, value := range t {
+			if isSecretConfigKey(key) {
+				if str, ok := value.(string); ok && str != "" {
+					t[key] = replacement
+				}
+				continue
+			}
+			// Outbound webhook URLs (notifications.webhooks) can carry their own credentials
+			if key == "webhooks" {
+				if webhooks, ok := value.([]interface{}); ok {
+					for _, webhook := range webhooks {
+						if entry, ok := webhook.(map[string]interface{}); ok {
+							if endpoint, ok := entry["url"].(string); ok && endpoint != "" {
+								entry["url"] = replacement
+							}
+						}
+					}
+				}
+			}
+			redactSecrets(value, replacement)
+		}
+	case []interface{}:
+		for _, value := range t {
+			redactSecrets(value, replacement)
+		}
+	}
+}
 
-	// Messages Stats
-	UnreadMessages int
-	TotalMessages  int
+// isSecretConfigKey reports whether a config key holds a credential. Publishable keys are public;
+// incoming webhook URLs like notifications.chatWebhookURL let anyone post to the channel
+func isSecretConfigKey(key string) bool {
+	key = strings.ToLower(key)
+	if key == "publishablekey" {
+		return false
+	}
+	for _, marker := range []string{"secret", "password", "token", "apikey", "webhookurl"} {
+		if strings.Contains(key, marker) {
+			return true
+		}
+	}
+	return false
 }
 
-func (s *AdminServer) GetOverviewStats(websiteID string) (*OverviewStats, error) {
+// ImportSite recreates the content from an ExportSite bundle in a website's database, keeping the
+// original IDs so relationships survive. The site's content tables must be empty; its config is
+// left alone since the bundle's copy is redacted. Returns the number of rows imported per table
+func (s *AdminServer) ImportSite(websiteID string, r io.Reader) (map[string]int, error) {
+	website, err := s.GetWebsite(websiteID)
+	if err != nil {
+		return nil, err
+	}
+
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return nil, err
 	}
 	defer db.Close()
 
-	stats := &OverviewStats{}
-
-	// Content Stats
-	err = db.QueryRow(`
-		SELECT
-			COUNT(*) as total,
-			SUM(CASE WHEN status = 'published' THEN 1 ELSE 0 END) as published,
-			SUM(CASE WHEN status = 'draft' THEN 1 ELSE 0 END) as draft
-		FROM articles_unified
-	`).Scan(&stats.TotalArticles, &stats.PublishedArticles, &stats.DraftArticles)
-	if err != nil && err != sql.ErrNoRows {
-		// If table doesn't exist, continue with zeros
-		stats.TotalArticles = 0
-		stats.PublishedArticles = 0
-		stats.DraftArticles = 0
+	// A fresh database may not have been opened by the site yet
+	siteDB := &database.DBConnection{Database: db.DB, Connected: true, Name: website.DatabaseName}
+	if err := siteDB.InitArticleTables(); err != nil {
+		return nil, err
 	}
-
-	// E-commerce Stats - Products
-	err = db.QueryRow(`SELECT COUNT(*) FROM products_unified`).Scan(&stats.TotalProducts)
-	if err != nil && err != sql.ErrNoRows {
-		stats.TotalProducts = 0
+	if err := siteDB.InitEcommerceTables(); err != nil {
+		return nil, err
 	}
 
-	// E-commerce Stats - Repeat Customers (customers with 2+ paid orders)
-	err = db.QueryRow(`
-		SELECT COUNT(*)
-		FROM customers c
-		WHERE (
-			SELECT COUNT(*)
-			FROM orders o
-			WHERE o.customer_id = c.id
-			AND o.payment_status = 'paid'
-		) >= 2
-	`).Scan(&stats.RepeatCustomers)
-	if err != nil && err != sql.ErrNoRows {
-		stats.RepeatCustomers = 0
+	for _, table := range siteExportTables {
+		var hasRows bool
+		if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM " + table + ")").Scan(&hasRows); err != nil {
+			return nil, err
+		}
+		if hasRows {
+			return nil, fmt.Errorf("%s already has rows; import into a fresh database", table)
+		}
 	}
 
-	// E-commerce Stats - Orders (only paid orders)
-	var totalRevenue sql.NullFloat64
-	err = db.QueryRow(`
-		SELECT
-			COUNT(*) as total_orders,
-			COALESCE(SUM(total), 0) as total_revenue
-		FROM orders
-		WHERE payment_status = 'paid'
-	`).Scan(&stats.TotalOrders, &totalRevenue)
-	if err != nil && err != sql.ErrNoRows {
-		stats.TotalOrders = 0
-		stats.TotalRevenue = 0
-	} else if totalRevenue.Valid {
-		stats.TotalRevenue = totalRevenue.Float64
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
 	}
+	defer tx.Rollback()
 
-	// E-commerce Stats - Customers
-	err = db.QueryRow(`SELECT COUNT(*) FROM customers`).Scan(&stats.TotalCustomers)
-	if err != nil && err != sql.ErrNoRows {
-		stats.TotalCustomers = 0
+	// Rows keep their IDs, and tables referencing each other can't all be inserted parent-first
+	if _, err := tx.Exec("SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+		return nil, err
 	}
 
-	// Today's Activity
-	todayStart := time.Now().Truncate(24 * time.Hour)
-	var revenueToday sql.NullFloat64
-	err = db.QueryRow(`
-		SELECT
-			COUNT(*) as orders_today,
-			COALESCE(SUM(CASE WHEN payment_status = 'paid' THEN total ELSE 0 END), 0) as revenue_today
-		FROM orders
-		WHERE created_at >= ?
-	`, todayStart).Scan(&stats.OrdersToday, &revenueToday)
-	if err != nil && err != sql.ErrNoRows {
-		stats.OrdersToday = 0
-		stats.RevenueToday = 0
-	} else if revenueToday.Valid {
-		stats.RevenueToday = revenueToday.Float64
-	}
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
 
-	// This Week's Activity
-	weekStart := time.Now().AddDate(0, 0, -7)
-	var revenueWeek sql.NullFloat64
-	err = db.QueryRow(`
-		SELECT
-			COUNT(*) as orders_week,
-			COALESCE(SUM(CASE WHEN payment_status = 'paid' THEN total ELSE 0 END), 0) as revenue_week
-		FROM orders
-		WHERE created_at >= ?
-	`, weekStart).Scan(&stats.OrdersThisWeek, &revenueWeek)
-	if err != nil && err != sql.ErrNoRows {
-		stats.OrdersThisWeek = 0
-		stats.RevenueThisWeek = 0
-	} else if revenueWeek.Valid {
-		stats.RevenueThisWeek = revenueWeek.Float64
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
 	}
 
-	// This Month's Activity
-	monthStart := time.Now().AddDate(0, 0, -30)
-	var revenueMonth sql.NullFloat64
-	err = db.QueryRow(`
-		SELECT
-			COUNT(*) as orders_month,
-			COALESCE(SUM(CASE WHEN payment_status = 'paid' THEN total ELSE 0 END), 0) as revenue_month
-		FROM orders
-		WHERE created_at >= ?
-	`, monthStart).Scan(&stats.OrdersThisMonth, &revenueMonth)
-	if err != nil && err != sql.ErrNoRows {
-		stats.OrdersThisMonth = 0
-		stats.RevenueThisMonth = 0
-	} else if revenueMonth.Valid {
-		stats.RevenueThisMonth = revenueMonth.Float64
+	counts := make(map[string]int)
+	sawTables := false
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch token {
+		case "version":
+			var version int
+			if err := dec.Decode(&version); err != nil {
+				return nil, err
+			}
+			if version != siteExportVersion {
+				return nil, fmt.Errorf("unsupported export version %d", version)
+			}
+		case "tables":
+			if err := importTables(tx, dec, counts); err != nil {
+				return nil, err
+			}
+			sawTables = true
+		default:
+			// exportedAt and the redacted site config
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	// Marketing Stats
-	err = db.QueryRow(`SELECT COUNT(*) FROM sms_signups`).Scan(&stats.TotalSMSSignups)
-	if err != nil && err != sql.ErrNoRows {
-		stats.TotalSMSSignups = 0
+	if !sawTables {
+		return nil, fmt.Errorf("not a site export: no tables found")
 	}
 
-	// Analytics Stats
-	err = db.QueryRow(`SELECT COUNT(*) FROM analytics_pageviews`).Scan(&stats.TotalPageviews)
-	if err != nil && err != sql.ErrNoRows {
-		stats.TotalPageviews = 0
+	if _, err := tx.Exec("SET FOREIGN_KEY_CHECKS = 1"); err != nil {
+		return nil, err
 	}
 
-	// Pageviews Today
-	err = db.QueryRow(`
-		SELECT COUNT(*) FROM analytics_pageviews
-		WHERE created_at >= ?
-	`, todayStart).Scan(&stats.PageviewsToday)
-	if err != nil && err != sql.ErrNoRows {
-		stats.PageviewsToday = 0
-	}
+	return counts, tx.Commit()
+}
 
-	// Pageviews This Week
-	err = db.QueryRow(`
-		SELECT COUNT(*) FROM analytics_pageviews
-		WHERE created_at >= ?
-	`, weekStart).Scan(&stats.PageviewsThisWeek)
-	if err != nil && err != sql.ErrNoRows {
-		stats.PageviewsThisWeek = 0
+// importTables inserts the rows of the "tables" object in an export, one row at a time
+func importTables(tx *sql.Tx, dec *json.Decoder, counts map[string]int) error {
+	allowed := make(map[string]bool, len(siteExportTables))
+	for _, table := range siteExportTables {
+		allowed[table] = true
 	}
 
-	// Pageviews This Month
-	err = db.QueryRow(`
-		SELECT COUNT(*) FROM analytics_pageviews
-		WHERE created_at >= ?
-	`, monthStart).Scan(&stats.PageviewsThisMonth)
-	if err != nil && err != sql.ErrNoRows {
-		stats.PageviewsThisMonth = 0
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
 	}
 
-	// Unique Visitors Today
-	err = db.QueryRow(`
-		SELECT COUNT(DISTINCT session_id) FROM analytics_pageviews
-		WHERE created_at >= ?
-	`, todayStart).Scan(&stats.UniqueVisitorsToday)
-	if err != nil && err != sql.ErrNoRows {
-		stats.UniqueVisitorsToday = 0
-	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		table, _ := token.(string)
+		if !allowed[table] {
+			return fmt.Errorf("unexpected table %q in export", table)
+		}
 
-	// Messages Stats
-	err = db.QueryRow(`SELECT COUNT(*) FROM messages WHERE status = 'unread'`).Scan(&stats.UnreadMessages)
-	if err != nil && err != sql.ErrNoRows {
-		stats.UnreadMessages = 0
-	}
+		// Only columns that exist here are imported, so older exports still load
+		columns := make(map[string]bool)
+		columnRows, err := tx.Query("SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ?", table)
+		if err != nil {
+			return err
+		}
+		for columnRows.Next() {
+			var column string
+			if err := columnRows.Scan(&column); err != nil {
+				columnRows.Close()
+				return err
+			}
+			columns[column] = true
+		}
+		columnRows.Close()
 
-	err = db.QueryRow(`SELECT COUNT(*) FROM messages`).Scan(&stats.TotalMessages)
-	if err != nil && err != sql.ErrNoRows {
-		stats.TotalMessages = 0
-	}
+		if err := expectDelim(dec, '['); err != nil {
+			return err
+		}
 
-	return stats, nil
-}
+		for dec.More() {
+			var row map[string]interface{}
+			if err := dec.Decode(&row); err != nil {
+				return fmt.Errorf("reading %s: %v", table, err)
+			}
 
-type RecentOrder struct {
-	ID            int
-	OrderNumber   string
-	CustomerName  string
-	CustomerEmail string
-	Total         float64
-	PaymentStatus string
-	CreatedAt     time.Time
-}
+			var names []string
+			for name := range row {
+				if columns[name] {
+					names = append(names, name)
+				}
+			}
+			if len(names) == 0 {
+				continue
+			}
+			sort.Strings(names)
 
-func (s *AdminServer) GetRecentOrders(websiteID string, limit int) ([]RecentOrder, error) {
-	db, err := s.GetWebsiteConnection(websiteID)
-	if err != nil {
-		return nil, err
+			args := make([]interface{}, len(names))
+			for i, name := range names {
+				args[i] = row[name]
+			}
+
+			query := fmt.Sprintf("INSERT INTO %s (`%s`) VALUES (%s)", table, strings.Join(names, "`, `"),
+				strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", "))
+			if _, err := tx.Exec(query, args...); err != nil {
+				return fmt.Errorf("importing into %s: %v", table, err)
+			}
+			counts[table]++
+		}
+
+		if err := expectDelim(dec, ']'); err != nil {
+			return err
+		}
 	}
-	defer db.Close()
 
-	query := `
-		SELECT
-			id,
-			order_number,
-			customer_name,
-			customer_email,
-			total,
-			payment_status,
-			created_at
-		FROM orders
-		ORDER BY created_at DESC
-		LIMIT ?
-	`
+	return expectDelim(dec, '}')
+}
 
-	rows, err := db.Query(query, limit)
+// expectDelim reads the next JSON token and fails unless it's the given delimiter
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
-
-	var orders []RecentOrder
-	for rows.Next() {
-		var order RecentOrder
-		err := rows.Scan(
-			&order.ID,
-			&order.OrderNumber,
-			&order.CustomerName,
-			&order.CustomerEmail,
-			&order.Total,
-			&order.PaymentStatus,
-			&order.CreatedAt,
-		)
-		if err != nil {
-			continue
-		}
-		orders = append(orders, order)
+	if token != delim {
+		return fmt.Errorf("invalid site export: expected %q", delim)
 	}
-
-	return orders, nil
+	return nil
 }
 
-// Message Management Queries
+// ====================
+// Webhook Tests & Log
+// ====================
 
-type Message struct {
-	ID           int
-	Name         string
-	Email        string
-	Message      string
-	Status       string
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
-	ReplyCount   int
-	LastReplyAt  *time.Time
+// WebhookLogEntry is an inbound webhook delivery recorded by the site's API
+type WebhookLogEntry struct {
+	ID         int
+	Provider   string
+	EventType  string
+	EventID    string
+	StatusCode int
+	Test       bool
+	Body       string // Redacted and truncated
+	ReceivedAt time.Time
 }
 
-type MessageWithReplies struct {
-	ID        int
-	Name      string
-	Email     string
-	Message   string
-	Status    string
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Replies   []MessageReply
+// WebhookTestResult is how a webhook endpoint answered a test delivery from the admin
+type WebhookTestResult struct {
+	Provider   string
+	URL        string
+	StatusCode int
+	Status     string
+	Body       string // Start of the response body
+	Error      string // Set when no response came back
+	Duration   time.Duration
 }
 
-type MessageReply struct {
-	ID        int
-	MessageID int
-	ReplyText string
-	SentAt    time.Time
-	SentBy    string
+// webhookTestPaths maps providers to the webhook endpoint they post to
+var webhookTestPaths = map[string]string{
+	"stripe": "/api/v1/webhook/stripe",
+	"shippo": "/api/v1/webhook/shippo",
+	"sms":    "/api/v1/sms-webhook",
 }
 
-func (s *AdminServer) GetMessages(websiteID string) ([]Message, error) {
-	db, err := s.GetWebsiteConnection(websiteID)
-	if err != nil {
-		return nil, err
+// SendWebhookTest posts a harmless sample event to one of the site's webhook endpoints at baseURL.
+// Stripe events are signed with the site's webhook secret, so a passing test also means the secret
+// matches. The endpoint records the delivery as a test receipt
+func (s *AdminServer) SendWebhookTest(ctx context.Context, site Website, baseURL, provider string) (*WebhookTestResult, error) {
+	path, ok := webhookTestPaths[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown webhook provider %q", provider)
+	}
+
+	var body []byte
+	contentType := "application/json"
+	switch provider {
+	case "stripe":
+		// Not an event type the endpoint acts on, so it's acknowledged and ignored
+		body, _ = json.Marshal(map[string]interface{}{
+			"id":          fmt.Sprintf("evt_test_%d", time.Now().Unix()),
+			"object":      "event",
+			"type":        "stencil.webhook_test",
+			"api_version": stripe.APIVersion,
+			"created":     time.Now().Unix(),
+			"livemode":    false,
+			"data":        map[string]interface{}{"object": map[string]interface{}{}},
+		})
+	case "shippo":
+		// No tracking number, so no order is touched
+		body, _ = json.Marshal(map[string]interface{}{
+			"event": "track_updated",
+			"test":  true,
+			"data":  map[string]interface{}{},
+		})
+	case "sms":
+		// Twilio posts a form; "TEST" isn't an opt-out keyword
+		body = []byte(url.Values{"From": {"+15005550006"}, "Body": {"TEST"}}.Encode())
+		contentType = "application/x-www-form-urlencoded"
 	}
-	defer db.Close()
 
-	query := `
-		SELECT
-			m.id,
-			m.name,
-			m.email,
-			m.message,
-			m.status,
-			m.created_at,
-			m.updated_at,
-			COALESCE(COUNT(r.id), 0) as reply_count,
-			MAX(r.sent_at) as last_reply_at
-		FROM messages m
-		LEFT JOIN message_replies r ON m.id = r.message_id
-		GROUP BY m.id, m.name, m.email, m.message, m.status, m.created_at, m.updated_at
-		ORDER BY m.created_at DESC
-	`
+	result := &WebhookTestResult{Provider: provider, URL: strings.TrimRight(baseURL, "/") + path}
 
-	rows, err := db.Query(query)
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, result.URL, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(api.WebhookTestHeader, "true")
+	if provider == "stripe" && site.StripeWebhookSecret != "" {
+		req.Header.Set("Stripe-Signature", stripeTestSignature(site.StripeWebhookSecret, body, time.Now()))
+	}
 
-	var messages []Message
-	for rows.Next() {
-		var msg Message
-		err := rows.Scan(
-			&msg.ID,
-			&msg.Name,
-			&msg.Email,
-			&msg.Message,
-			&msg.Status,
-			&msg.CreatedAt,
-			&msg.UpdatedAt,
-			&msg.ReplyCount,
-			&msg.LastReplyAt,
-		)
-		if err != nil {
-			continue
-		}
-		messages = append(messages, msg)
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	result.Duration = time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil
 	}
+	defer resp.Body.Close()
 
-	return messages, nil
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
+	result.StatusCode = resp.StatusCode
+	result.Status = resp.Status
+	result.Body = strings.TrimSpace(string(respBody))
+	return result, nil
 }
 
-func (s *AdminServer) GetMessage(websiteID string, messageID int) (*MessageWithReplies, error) {
+// stripeTestSignature builds a Stripe-Signature header for payload the way Stripe signs deliveries
+func stripeTestSignature(secret string, payload []byte, now time.Time) string {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// GetWebhookLog returns the site's most recent webhook deliveries, newest first, optionally for
+// one provider only
+func (s *AdminServer) GetWebhookLog(websiteID, provider string, limit int) ([]WebhookLogEntry, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return nil, err
 	}
 	defer db.Close()
 
-	// Get the message
-	messageQuery := `
-		SELECT id, name, email, message, status, created_at, updated_at
-		FROM messages
-		WHERE id = ?
+	query := `
+		SELECT id, provider, event_type, event_id, status_code, test, COALESCE(body, ''), received_at
+		FROM webhook_log
 	`
-
-	var msg MessageWithReplies
-	err = db.QueryRow(messageQuery, messageID).Scan(
-		&msg.ID,
-		&msg.Name,
-		&msg.Email,
-		&msg.Message,
-		&msg.Status,
-		&msg.CreatedAt,
-		&msg.UpdatedAt,
-	)
-	if err != nil {
-		return nil, err
+	args := []interface{}{}
+	if provider != "" {
+		query += " WHERE provider = ?"
+		args = append(args, provider)
 	}
+	query += " ORDER BY received_at DESC, id DESC LIMIT ?"
+	args = append(args, limit)
 
-	// Get replies
-	repliesQuery := `
-		SELECT id, message_id, reply_text, sent_at, sent_by
-		FROM message_replies
-		WHERE message_id = ?
-		ORDER BY sent_at ASC
-	`
-
-	rows, err := db.Query(repliesQuery, messageID)
+	rows, err := db.Query(query, args...)
 	if err != nil {
-		return &msg, nil // Return message even if replies fail
+		return nil, err
 	}
 	defer rows.Close()
 
-	var replies []MessageReply
+	var entries []WebhookLogEntry
 	for rows.Next() {
-		var reply MessageReply
-		err := rows.Scan(
-			&reply.ID,
-			&reply.MessageID,
-			&reply.ReplyText,
-			&reply.SentAt,
-			&reply.SentBy,
-		)
-		if err != nil {
-			continue
+		var entry WebhookLogEntry
+		if err := rows.Scan(&entry.ID, &entry.Provider, &entry.EventType, &entry.EventID, &entry.StatusCode, &entry.Test, &entry.Body, &entry.ReceivedAt); err != nil {
+			return nil, err
 		}
-		replies = append(replies, reply)
+		entries = append(entries, entry)
 	}
 
-	msg.Replies = replies
-	return &msg, nil
+	return entries, rows.Err()
 }
 
-func (s *AdminServer) GetUnreadMessageCount(websiteID string) (int, error) {
+// ====================
+// Outbound Webhooks
+// ====================
+
+// OutboundDelivery is one of the site's own webhook deliveries, either still queued for another
+// attempt or, once it ran out of attempts, a dead letter
+type OutboundDelivery struct {
+	ID             int
+	Endpoint       string // "chat" or the name of one of notifications.webhooks
+	Event          string
+	Payload        string
+	Attempts       int
+	LastStatusCode int // 0 when no response came back
+	LastError      string
+	CreatedAt      time.Time
+	NextAttemptAt  time.Time // queued deliveries only
+	FailedAt       time.Time // dead letters only
+}
+
+// GetPendingOutboundDeliveries returns deliveries that have failed at least once and are waiting
+// to be retried, soonest first
+func (s *AdminServer) GetPendingOutboundDeliveries(websiteID string, limit int) ([]OutboundDelivery, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	defer db.Close()
 
-	query := `SELECT COUNT(*) FROM messages WHERE status = 'unread'`
-	var count int
-	err = db.QueryRow(query).Scan(&count)
+	rows, err := db.Query(`
+		SELECT id, endpoint, event, payload, attempts, last_status_code, COALESCE(last_error, ''), created_at, next_attempt_at
+		FROM outbound_deliveries
+		WHERE attempts > 0
+		ORDER BY next_attempt_at, id
+		LIMIT ?
+	`, limit)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	defer rows.Close()
 
-	return count, nil
-}
-
-func (s *AdminServer) DeleteMessage(websiteID string, messageID int) error {
-	db, err := s.GetWebsiteConnection(websiteID)
-	if err != nil {
-		return err
+	var deliveries []OutboundDelivery
+	for rows.Next() {
+		var d OutboundDelivery
+		if err := rows.Scan(&d.ID, &d.Endpoint, &d.Event, &d.Payload, &d.Attempts, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.NextAttemptAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
 	}
-	defer db.Close()
 
-	// Delete the message (replies will be cascade deleted due to FOREIGN KEY constraint)
-	query := `DELETE FROM messages WHERE id = ?`
-	_, err = db.Exec(query, messageID)
-	return err
+	return deliveries, rows.Err()
 }
 
-// GetSMSSignupByID retrieves a single SMS signup by ID
-func (s *AdminServer) GetSMSSignupByID(websiteID string, signupID int) (SMSSignup, error) {
+// GetOutboundDeadLetters returns deliveries that failed for good, newest first
+func (s *AdminServer) GetOutboundDeadLetters(websiteID string, limit int) ([]OutboundDelivery, error) {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return SMSSignup{}, err
+		return nil, err
 	}
 	defer db.Close()
 
-	sqlQuery := `
-		SELECT id, COALESCE(country_code, '+1'), phone, COALESCE(email, ''), COALESCE(source, ''), verified, created_at
-		FROM sms_signups
-		WHERE id = ?
-	`
-	var signup SMSSignup
-	err = db.QueryRow(sqlQuery, signupID).Scan(&signup.ID, &signup.CountryCode, &signup.Phone, &signup.Email, &signup.Source, &signup.Verified, &signup.CreatedAt)
+	rows, err := db.Query(`
+		SELECT id, endpoint, event, payload, attempts, last_status_code, COALESCE(last_error, ''), created_at, failed_at
+		FROM outbound_dead_letters
+		ORDER BY failed_at DESC, id DESC
+		LIMIT ?
+	`, limit)
 	if err != nil {
-		return SMSSignup{}, err
+		return nil, err
 	}
-	return signup, nil
-}
+	defer rows.Close()
 
-// SetSMSVerificationCode stores a verification code for an SMS signup
-func (s *AdminServer) SetSMSVerificationCode(websiteID, countryCode, phone, verificationCode string) error {
-	db, err := s.GetWebsiteConnection(websiteID)
-	if err != nil {
-		return err
+	var deliveries []OutboundDelivery
+	for rows.Next() {
+		var d OutboundDelivery
+		if err := rows.Scan(&d.ID, &d.Endpoint, &d.Event, &d.Payload, &d.Attempts, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.FailedAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
 	}
-	defer db.Close()
 
-	sqlQuery := `
-		UPDATE sms_signups
-		SET verification_code = ?, verification_expires_at = DATE_ADD(NOW(), INTERVAL 15 MINUTE)
-		WHERE country_code = ? AND phone = ?
-	`
-	_, err = db.Exec(sqlQuery, verificationCode, countryCode, phone)
-	return err
+	return deliveries, rows.Err()
 }
 
-// ClearOrderShippingLabel clears shipping label information from an order
-func (s *AdminServer) ClearOrderShippingLabel(websiteID string, orderID int, fulfillmentStatus string) error {
+// ReplayOutboundDeadLetter puts a dead letter back in the queue with a fresh set of attempts. The
+// site's API sends it within a minute
+func (s *AdminServer) ReplayOutboundDeadLetter(websiteID string, id int) error {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	sqlQuery := `
-		UPDATE orders
-		SET tracking_number = NULL,
-			shipping_carrier = NULL,
-			shipping_label_url = NULL,
-			shipping_label_cost = NULL,
-			shippo_transaction_id = NULL,
-			fulfillment_status = ?,
-			updated_at = NOW()
-		WHERE id = ?
-	`
-	_, err = db.Exec(sqlQuery, fulfillmentStatus, orderID)
-	return err
-}
-
-// GetTwilio returns a Twilio client for the given website
-func (s *AdminServer) GetTwilio(website *Website) *twilio.Client {
-	if website.TwilioAccountSID == "" || website.TwilioAuthToken == "" || website.TwilioFromPhone == "" {
-		return nil
-	}
-	return twilio.NewClient(website.TwilioAccountSID, website.TwilioAuthToken, website.TwilioFromPhone)
-}
-
-// UpdateOrderRefundedAmount updates the refunded amount for an order
-func (s *AdminServer) UpdateOrderRefundedAmount(websiteID string, orderID int, refundedAmount float64) error {
-	db, err := s.GetWebsiteConnection(websiteID)
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	defer db.Close()
+	defer tx.Rollback()
 
-	sqlQuery := `
-		UPDATE orders
-		SET refunded_amount = ?,
-			updated_at = NOW()
+	result, err := tx.Exec(`
+		INSERT INTO outbound_deliveries (endpoint, event, payload, next_attempt_at, created_at)
+		SELECT endpoint, event, payload, NOW(), created_at
+		FROM outbound_dead_letters
 		WHERE id = ?
-	`
-	_, err = db.Exec(sqlQuery, refundedAmount, orderID)
-	return err
-}
-
-// UpdateOrderDetails updates customer info, shipping address, and order totals
-func (s *AdminServer) UpdateOrderDetails(websiteID string, orderID int, customerName, customerEmail string, shippingAddr map[string]string, subtotal, tax, total float64) error {
-	db, err := s.GetWebsiteConnection(websiteID)
+	`, id)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec(`DELETE FROM outbound_dead_letters WHERE id = ?`, id); err != nil {
+		return err
+	}
 
-	sqlQuery := `
-		UPDATE orders
-		SET customer_name = ?,
-			customer_email = ?,
-			shipping_address_line1 = ?,
-			shipping_address_line2 = ?,
-			shipping_city = ?,
-			shipping_state = ?,
-			shipping_zip = ?,
-			shipping_country = ?,
-			subtotal = ?,
-			tax = ?,
-			total = ?,
-			updated_at = NOW()
-		WHERE id = ?
-	`
-	_, err = db.Exec(sqlQuery,
-		customerName, customerEmail,
-		shippingAddr["line1"], shippingAddr["line2"],
-		shippingAddr["city"], shippingAddr["state"],
-		shippingAddr["zip"], shippingAddr["country"],
-		subtotal, tax, total,
-		orderID,
-	)
-	return err
+	return tx.Commit()
 }
 
-// UpdateOrderItems updates, deletes, and manages inventory for order items
-func (s *AdminServer) UpdateOrderItems(websiteID string, orderID int, newItems []map[string]interface{}, deletedItemIDs []string, originalItems []OrderItem) error {
+// DeleteOutboundDeadLetter discards a dead letter that shouldn't be sent
+func (s *AdminServer) DeleteOutboundDeadLetter(websiteID string, id int) error {
 	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	// Create map of original items for easy lookup
-	originalItemsMap := make(map[int]OrderItem)
-	for _, item := range originalItems {
-		originalItemsMap[item.ID] = item
-	}
-
-	// Update existing items
-	for _, item := range newItems {
-		itemID := item["id"].(int)
-		quantity := item["quantity"].(int)
-		price := item["price"].(float64)
-		total := item["total"].(float64)
-
-		// Update item in database
-		sqlQuery := `
-			UPDATE order_items
-			SET quantity = ?,
-				price = ?,
-				total = ?
-			WHERE id = ? AND order_id = ?
-		`
-		_, err = db.Exec(sqlQuery, quantity, price, total, itemID, orderID)
-		if err != nil {
-			return fmt.Errorf("failed to update order item %d: %w", itemID, err)
-		}
+	_, err = db.Exec(`DELETE FROM outbound_dead_letters WHERE id = ?`, id)
+	return err
+}
 
-		// Update inventory if quantity changed
-		if originalItem, exists := originalItemsMap[itemID]; exists {
-			quantityDiff := quantity - originalItem.Quantity
-			if quantityDiff != 0 {
-				productID := item["product_id"].(int)
-				variantID := item["variant_id"].(int)
+// ====================
+// Recompute
+// ====================
 
-				// Restore old quantity, deduct new quantity
-				if variantID > 0 {
-					// Update variant inventory
-					sqlQuery = `
-						UPDATE product_variants
-						SET inventory_quantity = inventory_quantity - ?
-						WHERE id = ?
-					`
-					_, err = db.Exec(sqlQuery, quantityDiff, variantID)
-				} else {
-					// Update product inventory
-					sqlQuery = `
-						UPDATE products
-						SET inventory_quantity = inventory_quantity - ?
-						WHERE id = ?
-					`
-					_, err = db.Exec(sqlQuery, quantityDiff, productID)
-				}
-				if err != nil {
-					log.Printf("Warning: Failed to update inventory for item %d: %v", itemID, err)
-				}
-			}
-		}
-	}
+// RecomputeStep is one pass of RecomputeSite and what it changed
+type RecomputeStep struct {
+	Name     string
+	Rows     int64 // rows changed; the analytics rollup doesn't count them
+	Duration time.Duration
+}
 
-	// Delete removed items and restore their inventory
-	for _, deletedIDStr := range deletedItemIDs {
-		deletedID, _ := strconv.Atoi(deletedIDStr)
+// recomputeSteps rebuild data kept alongside the rows it's derived from. Each one overwrites what
+// it derives, so running them again changes nothing
+var recomputeSteps = []struct {
+	name string
+	run  func(ctx context.Context, db *database.TimedDB, timezone string) (int64, error)
+}{
+	{"category post counts", recomputeCategoryCounts},
+	{"product rating summaries", recomputeProductRatings},
+	{"customer store credit balances", recomputeStoreCredit},
+	{"daily analytics rollup", func(ctx context.Context, db *database.TimedDB, timezone string) (int64, error) {
+		return 0, rebuildDailyAnalytics(ctx, db, timezone)
+	}},
+}
 
-		// Find original item to restore inventory
-		if originalItem, exists := originalItemsMap[deletedID]; exists {
-			// Restore inventory
-			if originalItem.VariantID > 0 {
-				sqlQuery := `
-					UPDATE product_variants
-					SET inventory_quantity = inventory_quantity + ?
-					WHERE id = ?
-				`
-				_, err = db.Exec(sqlQuery, originalItem.Quantity, originalItem.VariantID)
-			} else {
-				sqlQuery := `
-					UPDATE products
-					SET inventory_quantity = inventory_quantity + ?
-					WHERE id = ?
-				`
-				_, err = db.Exec(sqlQuery, originalItem.Quantity, originalItem.ProductID)
-			}
-			if err != nil {
-				log.Printf("Warning: Failed to restore inventory for deleted item %d: %v", deletedID, err)
-			}
+// RecomputeSite runs every recompute step on a site's database in turn, logging its progress, e.g.
+// after a bad import. It stops at the first step that fails and returns the ones that finished
+func RecomputeSite(ctx context.Context, db *database.TimedDB, timezone string) ([]RecomputeStep, error) {
+	var done []RecomputeStep
+	for i, step := range recomputeSteps {
+		if err := ctx.Err(); err != nil {
+			return done, err
 		}
 
-		// Delete item from database
-		sqlQuery := `DELETE FROM order_items WHERE id = ? AND order_id = ?`
-		_, err = db.Exec(sqlQuery, deletedID, orderID)
+		log.Printf("Recompute [%s] %d/%d: %s...", db.Site, i+1, len(recomputeSteps), step.name)
+		start := time.Now()
+		rows, err := step.run(ctx, db, timezone)
 		if err != nil {
-			return fmt.Errorf("failed to delete order item %d: %w", deletedID, err)
+			return done, fmt.Errorf("%s: %w", step.name, err)
 		}
-	}
 
-	return nil
+		result := RecomputeStep{Name: step.name, Rows: rows, Duration: time.Since(start).Round(time.Millisecond)}
+		log.Printf("Recompute [%s] %d/%d: %s done, %d rows changed in %v", db.Site, i+1, len(recomputeSteps), step.name, result.Rows, result.Duration)
+		done = append(done, result)
+	}
+	return done, nil
 }
 
-// AdjustOrderPayment handles payment adjustments when order total changes
-func (s *AdminServer) AdjustOrderPayment(websiteID string, orderID int, order *Order, difference float64) error {
-	// Get website for Stripe config
-	website, err := s.GetWebsite(websiteID)
+// RecomputeWebsite runs RecomputeSite for one of the admin's sites
+func (s *AdminServer) RecomputeWebsite(ctx context.Context, websiteID, timezone string) ([]RecomputeStep, error) {
+	db, err := s.GetWebsiteConnection(websiteID)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer db.Close()
 
-	// Initialize Stripe client
-	stripe.Key = website.StripeSecretKey
+	return RecomputeSite(ctx, db, timezone)
+}
 
-	if order.StripePaymentIntent == "" {
-		return fmt.Errorf("no stripe payment intent found")
+// recomputeCategoryCounts sets every category's count to its published articles, as
+// UpdateCategoryCount does for one
+func recomputeCategoryCounts(ctx context.Context, db *database.TimedDB, timezone string) (int64, error) {
+	result, err := db.ExecContext(ctx, `
+		UPDATE categories_unified c
+		SET c.count = (
+			SELECT COUNT(*)
+			FROM article_categories ac
+			JOIN articles_unified a ON a.id = ac.post_id
+			WHERE ac.category_id = c.id AND a.status = 'published'
+		)
+	`)
+	if err != nil {
+		return 0, err
 	}
+	return result.RowsAffected()
+}
 
-	if difference > 0 {
-		// Total increased - charge additional amount
-		// Note: In a real implementation, you'd need to:
-		// 1. Send a payment link to the customer
-		// 2. Or use a saved payment method if available
-		// 3. Create a new PaymentIntent for the difference
-		// For now, we'll log this and return an error
-		log.Printf("Order %d requires additional payment of $%.2f", orderID, difference)
-		return fmt.Errorf("order total increased by $%.2f - customer needs to be charged separately", difference)
-
-	} else if difference < 0 {
-		// Total decreased - issue refund
-		refundAmount := -difference
-		refundAmountCents := int64(refundAmount * 100)
-
-		refundParams := &stripe.RefundParams{
-			PaymentIntent: stripe.String(order.StripePaymentIntent),
-			Amount:        stripe.Int64(refundAmountCents),
-		}
-
-		_, err := refund.New(refundParams)
-		if err != nil {
-			return fmt.Errorf("failed to refund difference: %w", err)
-		}
-
-		// Update refunded amount in database
-		newRefundedAmount := order.RefundedAmount + refundAmount
-		err = s.UpdateOrderRefundedAmount(websiteID, orderID, newRefundedAmount)
-		if err != nil {
-			log.Printf("Refund processed but failed to update database: %v", err)
-		}
+// recomputeProductRatings sets every product's review count and average rating from its approved
+// reviews, as RefreshProductReviewsSummary does for one
+func recomputeProductRatings(ctx context.Context, db *database.TimedDB, timezone string) (int64, error) {
+	result, err := db.ExecContext(ctx, `
+		UPDATE products_unified p
+		LEFT JOIN (
+			SELECT product_id, COUNT(*) as review_count, ROUND(AVG(rating), 2) as average_rating
+			FROM product_reviews
+			WHERE status = ?
+			GROUP BY product_id
+		) r ON r.product_id = p.id
+		SET p.review_count = IFNULL(r.review_count, 0), p.average_rating = IFNULL(r.average_rating, 0)
+	`, database.ReviewStatusApproved)
+	if err != nil {
+		return 0, err
 	}
+	return result.RowsAffected()
+}
 
-	return nil
+// recomputeStoreCredit sets every customer's store credit balance to the sum of their store credit
+// transactions
+func recomputeStoreCredit(ctx context.Context, db *database.TimedDB, timezone string) (int64, error) {
+	result, err := db.ExecContext(ctx, `
+		UPDATE customers c
+		LEFT JOIN (
+			SELECT customer_id, SUM(amount) as balance
+			FROM store_credit_transactions
+			GROUP BY customer_id
+		) t ON t.customer_id = c.id
+		SET c.store_credit = IFNULL(t.balance, 0)
+	`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
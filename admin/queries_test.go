@@ -0,0 +1,343 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/murdinc/stencil2/configs"
+	"github.com/murdinc/stencil2/database"
+)
+
+// benchmarkSiteDB connects to the MySQL database in STENCIL_TEST_DSN and creates a site's tables.
+// Benchmarks that need a database are skipped when it isn't set
+func benchmarkSiteDB(b *testing.B) *database.TimedDB {
+	b.Helper()
+	dsn := os.Getenv("STENCIL_TEST_DSN")
+	if dsn == "" {
+		b.Skip("STENCIL_TEST_DSN not set")
+	}
+
+	pool, err := sql.Open("mysql", dsn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { pool.Close() })
+
+	dbConn := &database.DBConnection{Database: pool, Connected: true, Name: "stencil_test"}
+	for _, createTables := range []func() error{dbConn.InitArticleTables, dbConn.InitEcommerceTables, dbConn.InitAnalyticsTables, dbConn.InitMessagesTables} {
+		if err := createTables(); err != nil {
+			b.Fatalf("creating the site tables: %v", err)
+		}
+	}
+	return database.NewTimedDB(pool, dbConn.Name)
+}
+
+func TestCalendarPeriodStarts(t *testing.T) {
+	la, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	date := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, la)
+	}
+
+	tests := []struct {
+		name      string
+		now       time.Time
+		day       time.Time
+		week      time.Time
+		month     time.Time
+		dayLength time.Duration
+	}{
+		{"last day of a month", date(2025, time.January, 31, 15, 0),
+			date(2025, time.January, 31, 0, 0), date(2025, time.January, 27, 0, 0), date(2025, time.January, 1, 0, 0), 24 * time.Hour},
+		{"first day of the next month keeps the week", date(2025, time.February, 1, 0, 30),
+			date(2025, time.February, 1, 0, 0), date(2025, time.January, 27, 0, 0), date(2025, time.February, 1, 0, 0), 24 * time.Hour},
+		{"new year's day mid-week", date(2025, time.January, 1, 12, 0),
+			date(2025, time.January, 1, 0, 0), date(2024, time.December, 30, 0, 0), date(2025, time.January, 1, 0, 0), 24 * time.Hour},
+		{"sunday belongs to the week before", date(2025, time.March, 2, 18, 0),
+			date(2025, time.March, 2, 0, 0), date(2025, time.February, 24, 0, 0), date(2025, time.March, 1, 0, 0), 24 * time.Hour},
+		{"spring forward day", date(2025, time.March, 9, 10, 0),
+			date(2025, time.March, 9, 0, 0), date(2025, time.March, 3, 0, 0), date(2025, time.March, 1, 0, 0), 23 * time.Hour},
+		{"monday after spring forward", date(2025, time.March, 10, 9, 0),
+			date(2025, time.March, 10, 0, 0), date(2025, time.March, 10, 0, 0), date(2025, time.March, 1, 0, 0), 24 * time.Hour},
+		{"fall back day", date(2025, time.November, 2, 23, 0),
+			date(2025, time.November, 2, 0, 0), date(2025, time.October, 27, 0, 0), date(2025, time.November, 1, 0, 0), 25 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			day, week, month := calendarPeriodStarts(tt.now)
+			if !day.Equal(tt.day) {
+				t.Errorf("day start = %v, want %v", day, tt.day)
+			}
+			if !week.Equal(tt.week) {
+				t.Errorf("week start = %v, want %v", week, tt.week)
+			}
+			if !month.Equal(tt.month) {
+				t.Errorf("month start = %v, want %v", month, tt.month)
+			}
+			for _, start := range []time.Time{day, week, month} {
+				if h, m, _ := start.Clock(); h != 0 || m != 0 {
+					t.Errorf("%v isn't local midnight", start)
+				}
+			}
+			if next, _, _ := calendarPeriodStarts(day.AddDate(0, 0, 1)); next.Sub(day) != tt.dayLength {
+				t.Errorf("day is %v long, want %v", next.Sub(day), tt.dayLength)
+			}
+		})
+	}
+}
+
+// BenchmarkOverviewStats times the batched overview queries, a handful of round-trips where
+// there used to be one per stat
+func BenchmarkOverviewStats(b *testing.B) {
+	db := benchmarkSiteDB(b)
+	ctx := context.Background()
+	goals := MonthlyGoals{Revenue: 10000, Orders: 100}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		overviewStats(ctx, db, time.Now(), goals)
+	}
+}
+
+func TestCheckDatabaseName(t *testing.T) {
+	valid := []string{"stencil", "shop_2", "ABC_def_123"}
+	for _, name := range valid {
+		if err := checkDatabaseName(name); err != nil {
+			t.Errorf("checkDatabaseName(%q) = %v, want nil", name, err)
+		}
+	}
+
+	malicious := []string{"", "a;DROP", "a;DROP DATABASE stencil", "../x", "a b", "db?x=1", "db/other", "db\n", "db`", "db'--", "dbé"}
+	for _, name := range malicious {
+		if err := checkDatabaseName(name); err == nil {
+			t.Errorf("checkDatabaseName(%q) = nil, want an error", name)
+		}
+	}
+}
+
+func TestGetWebsiteRejectsBadNames(t *testing.T) {
+	// Names are checked before any lookup, so no database is needed
+	s := &AdminServer{}
+	for _, name := range []string{"a;DROP", "../x", "db?x=1"} {
+		if _, err := s.GetWebsite(name); err == nil {
+			t.Errorf("GetWebsite(%q) succeeded, want an error", name)
+		}
+		if _, err := s.GetWebsiteConnectionByDB(name); err == nil {
+			t.Errorf("GetWebsiteConnectionByDB(%q) succeeded, want an error", name)
+		}
+	}
+}
+
+func TestUpdateWebsiteKeepsUnknownKeys(t *testing.T) {
+	t.Setenv(configs.SecretsKeyEnv, "")
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll(filepath.Join("websites", "shop"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Custom keys the Website struct doesn't know, and known sections stored as the wrong type
+	original := `{
+		"siteName": "Old name",
+		"customBanner": {"text": "Sale & more", "colors": ["red", 2, null, {"deep": true}]},
+		"legacyId": 12345678901234567890,
+		"ratio": 0.1,
+		"email": "",
+		"ecommerce": 5,
+		"stripe": ["not", "an", "object"],
+		"shipFrom": {"name": "Warehouse", "dock": 7},
+		"notifications": {"webhooks": [5, {"url": "https://example.com/hook", "secret": 7}]}
+	}`
+	configPath := filepath.Join("websites", "shop", "config-dev.json")
+	if err := os.WriteFile(configPath, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &AdminServer{EnvConfig: &configs.EnvironmentConfig{}}
+	site := Website{Directory: "shop", SiteName: "New name", DatabaseName: "shop", SMTPPort: 587, TaxRate: 0.08}
+
+	if err := s.UpdateWebsite(site); err != nil {
+		t.Fatalf("UpdateWebsite: %v", err)
+	}
+	first, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(first))
+	decoder.UseNumber()
+	if err := decoder.Decode(&config); err != nil {
+		t.Fatalf("written config isn't valid JSON: %v", err)
+	}
+
+	if config["siteName"] != "New name" {
+		t.Errorf("siteName = %v, want the new name", config["siteName"])
+	}
+	if got := config["legacyId"]; got != json.Number("12345678901234567890") {
+		t.Errorf("legacyId = %v, want it exactly as written", got)
+	}
+	if got := config["ratio"]; got != json.Number("0.1") {
+		t.Errorf("ratio = %v, want 0.1", got)
+	}
+	banner, _ := config["customBanner"].(map[string]interface{})
+	if banner["text"] != "Sale & more" || len(banner["colors"].([]interface{})) != 4 {
+		t.Errorf("customBanner = %v, want it unchanged", config["customBanner"])
+	}
+	if !strings.Contains(string(first), "Sale & more") {
+		t.Error("& was escaped in the written config")
+	}
+	shipFrom, _ := config["shipFrom"].(map[string]interface{})
+	if shipFrom["dock"] != json.Number("7") {
+		t.Errorf("shipFrom.dock = %v, want 7", shipFrom["dock"])
+	}
+	ecommerce, ok := config["ecommerce"].(map[string]interface{})
+	if !ok || ecommerce["taxRate"] != json.Number("0.08") {
+		t.Errorf("ecommerce = %v, want an object with the tax rate", config["ecommerce"])
+	}
+	smtp, _ := config["email"].(map[string]interface{})["smtp"].(map[string]interface{})
+	if smtp["port"] != json.Number("587") {
+		t.Errorf("email.smtp.port = %v, want 587", smtp["port"])
+	}
+
+	// Saving the same settings again writes the same bytes
+	if err := s.UpdateWebsite(site); err != nil {
+		t.Fatalf("second UpdateWebsite: %v", err)
+	}
+	second, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Errorf("config changed between identical saves:\n%s\n---\n%s", first, second)
+	}
+}
+
+func TestMaskSecret(t *testing.T) {
+	tests := []struct {
+		secret string
+		want   string
+	}{
+		{"", ""},
+		{"short", maskedSecretPrefix},
+		{"12345678", maskedSecretPrefix},
+		{"sk_live_51Habcd1234", maskedSecretPrefix + "1234"},
+	}
+
+	for _, tt := range tests {
+		if got := maskSecret(tt.secret); got != tt.want {
+			t.Errorf("maskSecret(%q) = %q, want %q", tt.secret, got, tt.want)
+		}
+	}
+}
+
+func TestUnmaskSecret(t *testing.T) {
+	const saved = "sk_live_51Habcd1234"
+
+	tests := []struct {
+		name      string
+		submitted string
+		saved     string
+		want      string
+	}{
+		{"placeholder keeps the saved secret", maskSecret(saved), saved, saved},
+		{"placeholder for a short secret", maskedSecretPrefix, "hunter2", "hunter2"},
+		{"new secret replaces it", "sk_live_new9876", saved, "sk_live_new9876"},
+		{"blank clears it", "", saved, ""},
+		{"first secret", "sk_live_first", "", "sk_live_first"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unmaskSecret(tt.submitted, tt.saved); got != tt.want {
+				t.Errorf("unmaskSecret(%q, %q) = %q, want %q", tt.submitted, tt.saved, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebsiteRedacted(t *testing.T) {
+	site := Website{SiteName: "Shop", StripeSecretKey: "sk_live_51Habcd1234", SMTPPassword: "mail-password-5678"}
+
+	data, err := json.Marshal(site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{site.StripeSecretKey, site.SMTPPassword} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("JSON contains the secret %q", secret)
+		}
+	}
+
+	redacted := site.Redacted()
+	if redacted.SiteName != "Shop" {
+		t.Errorf("Redacted changed the site name to %q", redacted.SiteName)
+	}
+	if site.StripeSecretKey != "sk_live_51Habcd1234" {
+		t.Error("Redacted changed the original website")
+	}
+	if got := unmaskSecret(redacted.StripeSecretKey, site.StripeSecretKey); got != site.StripeSecretKey {
+		t.Errorf("saving the redacted form back gives %q, want the saved secret", got)
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	var config map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+		"siteName": "Shop",
+		"stripe": {"publishableKey": "pk_live_1", "secretKey": "sk_live_1"},
+		"notifications": {
+			"chatWebhookURL": "https://hooks.slack.com/services/T000/B000/XXXX",
+			"lowStock": true,
+			"webhooks": [{"name": "erp", "url": "https://erp.example.com/hook?key=abc", "secret": "whsec_1"}]
+		},
+		"mediaProxyUrl": "https://media.example.com"
+	}`), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redactSecrets(config, "REDACTED")
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"sk_live_1", "hooks.slack.com", "erp.example.com", "whsec_1"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("redacted config still contains %q", secret)
+		}
+	}
+	for _, kept := range []string{"pk_live_1", "media.example.com", `"name":"erp"`} {
+		if !strings.Contains(string(data), kept) {
+			t.Errorf("redacted config lost %q", kept)
+		}
+	}
+}
+
+func TestShipsOrder(t *testing.T) {
+	tests := []struct {
+		status string
+		want   bool
+	}{
+		{"unfulfilled", false},
+		{"processing", false},
+		{"fulfilled", true},
+		{"shipped", true},
+		{"delivered", true},
+	}
+
+	for _, tt := range tests {
+		if got := shipsOrder(tt.status); got != tt.want {
+			t.Errorf("shipsOrder(%q) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
@@ -1,15 +1,20 @@
 package admin
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/Masterminds/sprig"
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/csrf"
+	"github.com/murdinc/stencil2/utils"
 )
 
 // Template functions - merge Sprig functions with custom ones
@@ -26,9 +31,113 @@ var templateFuncs = func() template.FuncMap {
 		mb := kb / 1024.0
 		return fmt.Sprintf("%.1f MB", mb)
 	}
+	funcs["formatMoney"] = formatMoney
 	return funcs
 }()
 
+// formatMoney formats an amount for templates. It takes the numeric types prices are kept in,
+// including the *float64 of optional amounts, which show as "—" when nil
+func formatMoney(amount interface{}, currency string) string {
+	switch v := amount.(type) {
+	case float64:
+		return utils.FormatMoney(v, currency)
+	case *float64:
+		if v == nil {
+			return "—"
+		}
+		return utils.FormatMoney(*v, currency)
+	case float32:
+		return utils.FormatMoney(float64(v), currency)
+	case int:
+		return utils.FormatMoney(float64(v), currency)
+	case int64:
+		return utils.FormatMoney(float64(v), currency)
+	}
+	return fmt.Sprint(amount)
+}
+
+// templateCache holds the parsed admin templates so pages don't re-read and re-parse them from
+// disk on every request. With reload set (dev mode) they're parsed fresh each time instead, so
+// template edits show up without a restart
+type templateCache struct {
+	mu        sync.RWMutex
+	templates map[string]*template.Template
+	reload    bool
+}
+
+func newTemplateCache(reload bool) *templateCache {
+	return &templateCache{templates: make(map[string]*template.Template), reload: reload}
+}
+
+// get returns the template for name, which is "layout.html+<content>" for pages rendered in the
+// layout or the file name for standalone templates, parsing and caching it on first use
+func (c *templateCache) get(name string) (*template.Template, error) {
+	if !c.reload {
+		c.mu.RLock()
+		tmpl, ok := c.templates[name]
+		c.mu.RUnlock()
+		if ok {
+			return tmpl, nil
+		}
+	}
+
+	tmpl, err := parseAdminTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.reload {
+		c.mu.Lock()
+		c.templates[name] = tmpl
+		c.mu.Unlock()
+	}
+	return tmpl, nil
+}
+
+// preload parses every admin template up front. A template that doesn't parse is logged and left
+// for get to report when it's used, so one broken page doesn't stop the admin starting
+func (c *templateCache) preload() {
+	if c.reload {
+		return
+	}
+
+	files, err := filepath.Glob(filepath.Join("admin", "templates", "*.html"))
+	if err != nil {
+		log.Printf("Error listing admin templates: %v", err)
+		return
+	}
+
+	for _, file := range files {
+		name := filepath.Base(file)
+		if name == "layout.html" {
+			continue
+		}
+		if strings.HasSuffix(name, "_content.html") {
+			name = layoutTemplateName(name)
+		}
+		if _, err := c.get(name); err != nil {
+			log.Printf("Error parsing admin template %s: %v", name, err)
+		}
+	}
+}
+
+// layoutTemplateName is the cache name for a content template rendered in the layout
+func layoutTemplateName(contentTemplate string) string {
+	return "layout.html+" + contentTemplate
+}
+
+// parseAdminTemplate parses a template named as in templateCache.get
+func parseAdminTemplate(name string) (*template.Template, error) {
+	dir := filepath.Join("admin", "templates")
+	if contentTemplate, ok := strings.CutPrefix(name, "layout.html+"); ok {
+		return template.New("layout.html").Funcs(templateFuncs).ParseFiles(
+			filepath.Join(dir, "layout.html"),
+			filepath.Join(dir, contentTemplate),
+		)
+	}
+	return template.New(name).Funcs(templateFuncs).ParseFiles(filepath.Join(dir, name))
+}
+
 // LayoutData holds common data for all pages
 type LayoutData struct {
 	Title         string
@@ -40,15 +149,25 @@ type LayoutData struct {
 
 // renderWithLayout renders a page using the layout template
 func (s *AdminServer) renderWithLayout(w http.ResponseWriter, r *http.Request, contentTemplate string, data map[string]interface{}) {
+	s.renderPage(w, r, http.StatusOK, contentTemplate, data)
+}
+
+// renderPage renders a page in the layout with the given status. The page is rendered into a
+// buffer first, so a template error shows an error instead of half a page
+func (s *AdminServer) renderPage(w http.ResponseWriter, r *http.Request, status int, contentTemplate string, data map[string]interface{}) {
 	// Get current user from session
 	username := s.getSessionUsername(r)
 	isAdminUser := isAdmin(username)
 
-	// Get all sites
-	allSites, err := s.GetAllWebsites()
-	if err != nil {
-		log.Printf("Error loading websites: %v", err)
-		allSites = []Website{}
+	// Get all sites, already loaded on site-scoped routes
+	allSites, ok := allWebsitesFromContext(r)
+	if !ok {
+		var err error
+		allSites, err = s.GetAllWebsites()
+		if err != nil {
+			log.Printf("Error loading websites: %v", err)
+			allSites = []Website{}
+		}
 	}
 
 	// Filter sites based on user permissions
@@ -73,10 +192,12 @@ func (s *AdminServer) renderWithLayout(w http.ResponseWriter, r *http.Request, c
 		}
 	}
 
-	// Get current site if ID is in URL
+	// Get current site if ID is in URL, unless it's one the user can't see (e.g. on an access denied page)
 	var currentSite *Website
 	siteID := chi.URLParam(r, "id")
-	if siteID != "" {
+	if site, ok := websiteFromContext(r); ok {
+		currentSite = &site
+	} else if siteID != "" && s.canAccessSite(username, siteID) {
 		site, err := s.GetWebsite(siteID)
 		if err == nil {
 			currentSite = &site
@@ -102,40 +223,132 @@ func (s *AdminServer) renderWithLayout(w http.ResponseWriter, r *http.Request, c
 		"Username":      username,
 		"CSRFToken":     csrf.Token(r),
 		"CSRFField":     csrf.TemplateField(r),
+		"BasePath":      s.basePath,
+		"Currency":      "",
+	}
+	if currentSite != nil {
+		finalData["Currency"] = currentSite.Currency
 	}
 	for k, v := range data {
 		finalData[k] = v
 	}
 
-	// Parse templates with custom functions
-	tmpl, err := template.New("layout.html").Funcs(templateFuncs).ParseFiles(
-		filepath.Join("admin", "templates", "layout.html"),
-		filepath.Join("admin", "templates", contentTemplate),
-	)
+	tmpl, err := s.templates.get(layoutTemplateName(contentTemplate))
 	if err != nil {
 		log.Printf("Template parse error: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
 	// Execute
-	if err := tmpl.ExecuteTemplate(w, "layout.html", finalData); err != nil {
-		log.Printf("Template execution error: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "layout.html", finalData); err != nil {
+		log.Printf("Template execution error in %s: %v", contentTemplate, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// renderError shows a styled error page and logs err. For most statuses only message is shown,
+// so internal error text never reaches the browser; a 400's err describes what was wrong with the
+// request (a bad slug, a declined capture) and is shown after it. An empty message uses a default
+// for the status. Requests that expect JSON get {"success": false, "error": message} like the
+// admin's JSON endpoints
+func (s *AdminServer) renderError(w http.ResponseWriter, r *http.Request, status int, message string, err error) {
+	if err != nil {
+		log.Printf("%s %s: %d %s: %v", r.Method, r.URL.Path, status, message, err)
 	}
+
+	switch {
+	case status == http.StatusBadRequest && err != nil && message != "":
+		message = fmt.Sprintf("%s: %v", message, err)
+	case status == http.StatusBadRequest && err != nil:
+		message = err.Error()
+	case message == "":
+		message = defaultErrorMessage(status)
+	}
+
+	if wantsJSON(r) {
+		writeJSONError(w, status, message)
+		return
+	}
+
+	s.renderPage(w, r, status, "error_content.html", map[string]interface{}{
+		"Title":         http.StatusText(status),
+		"ActiveSection": "",
+		"StatusCode":    status,
+		"Message":       message,
+	})
+}
+
+// handleNotFound renders the 404 page for unknown admin URLs. Visitors who aren't logged in are
+// sent to the login page instead, so the layout's site list isn't shown to them
+func (s *AdminServer) handleNotFound(w http.ResponseWriter, r *http.Request) {
+	if s.getSessionUsername(r) == "" {
+		http.Redirect(w, r, s.adminURL("/login"), http.StatusSeeOther)
+		return
+	}
+	s.renderError(w, r, http.StatusNotFound, "", nil)
+}
+
+// handleMethodNotAllowed renders the 405 page, e.g. for a GET to a form's POST-only URL
+func (s *AdminServer) handleMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	if s.getSessionUsername(r) == "" {
+		http.Redirect(w, r, s.adminURL("/login"), http.StatusSeeOther)
+		return
+	}
+	s.renderError(w, r, http.StatusMethodNotAllowed, "", nil)
+}
+
+// writeJSONError writes the error response the admin's JSON endpoints use
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"error":   message,
+	})
+}
+
+// defaultErrorMessage is the message renderError shows when a handler doesn't give one
+func defaultErrorMessage(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "The request wasn't valid. Check the form and try again."
+	case http.StatusForbidden:
+		return "You don't have access to this page."
+	case http.StatusNotFound:
+		return "That page doesn't exist or has been removed."
+	case http.StatusMethodNotAllowed:
+		return "That action isn't supported here."
+	}
+	if status >= 500 {
+		return "Something went wrong. The error has been logged; try again in a moment."
+	}
+	return http.StatusText(status)
+}
+
+// wantsJSON reports whether a request came from admin JavaScript expecting a JSON response
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json") ||
+		strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") ||
+		r.Header.Get("X-Requested-With") == "XMLHttpRequest"
 }
 
 // renderSimpleTemplate renders without the layout (for login, etc)
 func (s *AdminServer) renderSimpleTemplate(w http.ResponseWriter, tmplName string, data interface{}) {
-	tmplPath := filepath.Join("admin", "templates", tmplName)
-
-	t, err := template.ParseFiles(tmplPath)
+	t, err := s.templates.get(tmplName)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("Template parse error: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
 	if err := t.Execute(w, data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("Template execution error in %s: %v", tmplName, err)
 	}
 }
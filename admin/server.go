@@ -1,8 +1,13 @@
 package admin
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -12,6 +17,8 @@ import (
 	"github.com/murdinc/stencil2/configs"
 	"github.com/murdinc/stencil2/database"
 	"github.com/murdinc/stencil2/email"
+	"github.com/murdinc/stencil2/media"
+	"github.com/murdinc/stencil2/metrics"
 )
 
 type AdminServer struct {
@@ -20,6 +27,27 @@ type AdminServer struct {
 	DBConn       *database.DBConnection
 	SessionStore *sessions.CookieStore
 	CSRFKey      []byte
+
+	// basePath is the normalized prefix the admin is mounted under ("" for root, otherwise e.g. "/admin")
+	basePath string
+
+	httpServer    *http.Server
+	stopPolling   context.CancelFunc
+	stopScheduler context.CancelFunc
+	pollers       sync.WaitGroup
+
+	// realtimeCtx is cancelled on shutdown so open realtime streams end instead of holding it up
+	realtimeCtx     context.Context
+	stopRealtime    context.CancelFunc
+	realtimeStreams atomic.Int32
+
+	overviewCache overviewStatsCache
+
+	// uploads stores uploaded images on the local disk or in S3, per the environment config
+	uploads media.Uploader
+
+	// templates caches parsed admin templates; outside prod mode they're re-parsed on every render
+	templates *templateCache
 }
 
 // NewAdminServer creates a new admin server instance
@@ -33,9 +61,11 @@ func NewAdminServer(envConfig configs.EnvironmentConfig) (*AdminServer, error) {
 		log.Fatal("Admin session key must be exactly 32 bytes")
 	}
 
+	basePath := normalizeBasePath(envConfig.Admin.BasePath)
+
 	store := sessions.NewCookieStore(sessionKey)
 	store.Options = &sessions.Options{
-		Path:     "/",
+		Path:     cookiePathFor(basePath),
 		MaxAge:   86400, // 24 hours
 		HttpOnly: true,
 		Secure:   envConfig.ProdMode,
@@ -48,13 +78,39 @@ func NewAdminServer(envConfig configs.EnvironmentConfig) (*AdminServer, error) {
 		log.Fatal("Admin CSRF key must be exactly 32 bytes")
 	}
 
+	uploads, err := media.NewUploader(envConfig.Uploads.Backend, media.S3Config{
+		Bucket:          envConfig.Uploads.S3.Bucket,
+		Region:          envConfig.Uploads.S3.Region,
+		Endpoint:        envConfig.Uploads.S3.Endpoint,
+		AccessKeyID:     envConfig.Uploads.S3.AccessKeyID,
+		SecretAccessKey: envConfig.Uploads.S3.SecretAccessKey,
+		PublicURL:       envConfig.Uploads.S3.PublicURL,
+		Prefix:          envConfig.Uploads.S3.Prefix,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up uploads: %v", err)
+	}
+
 	server := &AdminServer{
 		Router:       chi.NewRouter(),
 		EnvConfig:    &envConfig,
 		DBConn:       nil, // Not needed anymore
 		SessionStore: store,
 		CSRFKey:      csrfKey,
+		basePath:     basePath,
+		uploads:      uploads,
+		templates:    newTemplateCache(!envConfig.ProdMode),
 	}
+	server.templates.preload()
+	server.realtimeCtx, server.stopRealtime = context.WithCancel(context.Background())
+
+	// Storefront orders and payments don't go through the admin's routes, so they're heard about
+	// through the sites' events instead of invalidateOverviewOnWrite
+	database.SubscribeAll(func(dbName string, event database.ContentEvent) {
+		if event == database.OrdersChanged {
+			server.InvalidateOverviewStats(dbName)
+		}
+	})
 
 	server.setupRoutes()
 
@@ -66,13 +122,16 @@ func (s *AdminServer) setupRoutes() {
 	// Middleware
 	s.Router.Use(middleware.Logger)
 	s.Router.Use(middleware.Recoverer)
-	s.Router.Use(middleware.Compress(5))
+	s.Router.Use(metrics.Middleware("admin"))
+	if !s.EnvConfig.HTTP.DisableCompression {
+		s.Router.Use(middleware.Compress(5))
+	}
 
 	// CSRF protection - only enable in production
 	if s.EnvConfig.ProdMode {
 		csrfOptions := []csrf.Option{
 			csrf.Secure(true),
-			csrf.Path("/"),
+			csrf.Path(s.cookiePath()),
 			csrf.SameSite(csrf.SameSiteLaxMode),
 			csrf.RequestHeader("X-CSRF-Token"),
 			csrf.TrustedOrigins([]string{"admin.sudoba.sh"}),
@@ -82,6 +141,10 @@ func (s *AdminServer) setupRoutes() {
 		s.Router.Use(csrfMiddleware)
 	}
 
+	// Unknown URLs get the admin's 404 page. Set before the routes so subrouters inherit it
+	s.Router.NotFound(s.handleNotFound)
+	s.Router.MethodNotAllowed(s.handleMethodNotAllowed)
+
 	// Public routes (login)
 	s.Router.Get("/login", s.handleLoginPage)
 	s.Router.Post("/login", s.handleLogin)
@@ -108,6 +171,8 @@ func (s *AdminServer) setupRoutes() {
 			r.Get("/superadmin/checkup", s.handleSuperadminCheckup)
 			r.Get("/superadmin/websites/new", s.handleSuperadminWebsiteNew)
 			r.Post("/superadmin/websites/new", s.handleSuperadminWebsiteCreate)
+			r.Get("/superadmin/websites/{id}/duplicate", s.handleSuperadminWebsiteDuplicateForm)
+			r.Post("/superadmin/websites/{id}/duplicate", s.handleSuperadminWebsiteDuplicate)
 			r.Get("/superadmin/users", s.handleSuperadminUsers)
 			r.Post("/superadmin/users/create", s.handleSuperadminUserCreate)
 			r.Post("/superadmin/users/update", s.handleSuperadminUserUpdate)
@@ -117,12 +182,24 @@ func (s *AdminServer) setupRoutes() {
 		// Site context routes (ID is database name) - requires site access
 		r.Route("/site/{id}", func(r chi.Router) {
 			r.Use(s.requireSiteAccess)
+			r.Use(s.loadWebsite)
+			r.Use(s.invalidateOverviewOnWrite)
+			r.Use(publishContentOnWrite)
 
 			// Site dashboard and settings
 			r.Get("/", s.handleSiteDashboard)
 			r.Get("/settings", s.handleSiteSettings)
 			r.Post("/settings", s.handleSiteSettingsUpdate)
+			r.Post("/maintenance", s.handleMaintenanceToggle)
+			r.Get("/export", s.handleSiteExport)
+			r.Post("/import", s.handleSiteImport)
+			r.Post("/recompute", s.handleSiteRecompute)
 			r.Get("/webhooks", s.handleWebhooks)
+			r.Get("/webhooks/log", s.handleWebhookLog)
+			r.Post("/webhooks/test/{provider}", s.handleWebhookTest)
+			r.Get("/webhooks/outbound", s.handleOutboundWebhooks)
+			r.Post("/webhooks/outbound/{deadLetterId}/replay", s.handleOutboundReplay)
+			r.Post("/webhooks/outbound/{deadLetterId}/delete", s.handleOutboundDelete)
 			r.Post("/delete", s.handleWebsiteDelete)
 
 			// Article management
@@ -132,16 +209,30 @@ func (s *AdminServer) setupRoutes() {
 			r.Get("/articles/{articleId}/edit", s.handleArticleEdit)
 			r.Post("/articles/{articleId}/edit", s.handleArticleUpdate)
 			r.Post("/articles/{articleId}/delete", s.handleArticleDelete)
+			r.Get("/articles/{articleId}/translations", s.handleArticleTranslations)
+			r.Post("/articles/{articleId}/translations", s.handleArticleTranslationSave)
+			r.Post("/articles/{articleId}/translations/{locale}/delete", s.handleArticleTranslationDelete)
 
 			// Product management
 			r.Get("/products", s.handleProductsList)
 			r.Get("/products/new", s.handleProductNew)
 			r.Post("/products/new", s.handleProductCreate)
+			r.Get("/products/import", s.handleProductImportForm)
+			r.Post("/products/import", s.handleProductImport)
+			r.Get("/products/export", s.handleProductExport)
+			r.Get("/products/stocktake", s.handleStocktakeForm)
+			r.Post("/products/stocktake", s.handleStocktakePreview)
+			r.Post("/products/stocktake/apply", s.handleStocktakeApply)
 			r.Get("/products/{productId}/edit", s.handleProductEdit)
 			r.Post("/products/{productId}/edit", s.handleProductUpdate)
 			r.Post("/products/{productId}/delete", s.handleProductDelete)
 			r.Post("/products/{productId}/reorder/{direction}", s.handleProductReorder)
 			r.Post("/products/{productId}/images/reorder", s.handleProductImageReorder)
+			r.Post("/products/{productId}/bundle", s.handleBundleItemAdd)
+			r.Post("/products/{productId}/bundle/{componentId}/delete", s.handleBundleItemDelete)
+			r.Get("/products/{productId}/translations", s.handleProductTranslations)
+			r.Post("/products/{productId}/translations", s.handleProductTranslationSave)
+			r.Post("/products/{productId}/translations/{locale}/delete", s.handleProductTranslationDelete)
 
 			// Variant management
 			r.Get("/products/{productId}/variants/new", s.handleVariantNew)
@@ -161,9 +252,16 @@ func (s *AdminServer) setupRoutes() {
 			r.Post("/collections/new", s.handleCollectionCreate)
 			r.Get("/collections/{collectionId}/edit", s.handleCollectionEditForm)
 			r.Post("/collections/{collectionId}/edit", s.handleCollectionUpdate)
+			r.Get("/collections/{collectionId}/prices", s.handleCollectionPricesForm)
+			r.Post("/collections/{collectionId}/prices", s.handleCollectionPrices)
 			r.Post("/collections/{collectionId}/reorder/{direction}", s.handleCollectionReorder)
 			r.Post("/collections/{collectionId}/delete", s.handleCollectionDelete)
 
+			// Scheduled sales
+			r.Get("/sales", s.handleSalesList)
+			r.Post("/sales/new", s.handleSaleCreate)
+			r.Post("/sales/{saleId}/cancel", s.handleSaleCancel)
+
 			// Image management
 			r.Get("/images", s.handleImagesList)
 			r.Post("/images/upload", s.handleImageUpload)
@@ -171,6 +269,7 @@ func (s *AdminServer) setupRoutes() {
 
 			// Order management
 			r.Get("/orders", s.handleOrdersList)
+			r.Get("/orders/attention", s.handleOrdersNeedingAttention)
 			r.Get("/orders/{orderId}", s.handleOrderDetail)
 			r.Get("/orders/{orderId}/edit", s.handleOrderEdit)
 			r.Post("/orders/{orderId}/update", s.handleOrderUpdate)
@@ -183,7 +282,15 @@ func (s *AdminServer) setupRoutes() {
 
 			// Customer management
 			r.Get("/customers", s.handleCustomersList)
+			r.Get("/customers/privacy", s.handleCustomerPrivacy)
+			r.Get("/customers/privacy/export", s.handleCustomerDataExport)
+			r.Post("/customers/privacy/erase", s.handleCustomerDataErase)
 			r.Get("/customers/{customerId}", s.handleCustomerDetail)
+			r.Post("/customers/{customerId}/merge", s.handleCustomerMerge)
+			r.Post("/customers/{customerId}/store-credit", s.handleCustomerStoreCredit)
+
+			// Subscriptions (recurring orders)
+			r.Get("/subscriptions", s.handleSubscriptionsList)
 
 			// Messages (Contact Form)
 			r.Get("/messages", s.handleMessagesList)
@@ -192,6 +299,18 @@ func (s *AdminServer) setupRoutes() {
 			r.Post("/messages/{messageId}/toggle-read", s.handleMessageToggleRead)
 			r.Post("/messages/{messageId}/delete", s.handleMessageDelete)
 
+			// Product questions (Q&A)
+			r.Get("/questions", s.handleQuestionsList)
+			r.Post("/questions/{questionId}/answer", s.handleQuestionAnswer)
+			r.Post("/questions/{questionId}/status", s.handleQuestionStatus)
+			r.Post("/questions/{questionId}/delete", s.handleQuestionDelete)
+
+			// Returns
+			r.Get("/returns", s.handleReturnsList)
+			r.Post("/returns/{returnId}/status", s.handleReturnStatus)
+			r.Post("/returns/{returnId}/receive", s.handleReturnReceive)
+			r.Post("/returns/{returnId}/refund", s.handleReturnRefund)
+
 			// SMS Signups (Marketing)
 			r.Get("/sms-signups", s.handleSMSSignupsList)
 			r.Post("/sms-signups/{signupId}/delete", s.handleDeleteSMSSignup)
@@ -205,11 +324,58 @@ func (s *AdminServer) setupRoutes() {
 			// Analytics
 			r.Get("/analytics", s.handleAnalytics)
 			r.Get("/analytics/location-data", s.handleAnalyticsLocationData)
+			r.Get("/analytics/export", s.handleAnalyticsExport)
+			r.Post("/analytics/rebuild", s.handleAnalyticsRebuild)
+			r.Get("/realtime", s.handleRealtimeStream)
 		})
 	})
 
 	// Static assets for admin UI
-	s.Router.Handle("/static/*", http.StripPrefix("/static/", http.FileServer(http.Dir("admin/static"))))
+	s.Router.Handle("/static/*", http.StripPrefix(s.basePath+"/static/", http.FileServer(http.Dir("admin/static"))))
+}
+
+// Handler returns the admin router mounted under the configured base path
+func (s *AdminServer) Handler() http.Handler {
+	if s.basePath == "" {
+		return s.Router
+	}
+
+	root := chi.NewRouter()
+	root.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, s.basePath+"/", http.StatusFound)
+	})
+	root.Mount(s.basePath, s.Router)
+	return root
+}
+
+// adminURL builds an absolute admin URL under the base path. The path is used as a
+// format string when args are given, e.g. s.adminURL("/site/%s/orders", websiteID)
+func (s *AdminServer) adminURL(path string, args ...interface{}) string {
+	if len(args) > 0 {
+		path = fmt.Sprintf(path, args...)
+	}
+	return s.basePath + path
+}
+
+// cookiePath returns the path admin cookies are scoped to
+func (s *AdminServer) cookiePath() string {
+	return cookiePathFor(s.basePath)
+}
+
+func cookiePathFor(basePath string) string {
+	if basePath == "" {
+		return "/"
+	}
+	return basePath
+}
+
+// normalizeBasePath turns a configured prefix like "admin/" into "/admin", and "/" into ""
+func normalizeBasePath(basePath string) string {
+	basePath = strings.Trim(strings.TrimSpace(basePath), "/")
+	if basePath == "" {
+		return ""
+	}
+	return "/" + basePath
 }
 
 // Start starts the admin server
@@ -219,12 +385,55 @@ func (s *AdminServer) Start() error {
 		port = "8081"
 	}
 
-	log.Printf("Starting admin server on port %s", port)
-	return http.ListenAndServe(":"+port, s.Router)
+	s.httpServer = &http.Server{
+		Addr:    ":" + port,
+		Handler: s.Handler(),
+	}
+
+	log.Printf("Starting admin server on port %s%s", port, s.basePath)
+	return s.httpServer.ListenAndServe()
 }
 
-// StartEmailPolling starts background email polling for all websites with IMAP configured
-func (s *AdminServer) StartEmailPolling() {
+// Shutdown stops email polling, lets in-flight admin requests finish and waits for running
+// email polls and scheduler passes, giving up when ctx expires
+func (s *AdminServer) Shutdown(ctx context.Context) error {
+	if s.stopPolling != nil {
+		s.stopPolling()
+	}
+	if s.stopScheduler != nil {
+		s.stopScheduler()
+	}
+	s.stopRealtime()
+
+	var err error
+	if s.httpServer != nil {
+		err = s.httpServer.Shutdown(ctx)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.pollers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("Admin background jobs stopped")
+	case <-ctx.Done():
+		log.Println("Timed out waiting for admin background jobs to finish")
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+
+	return err
+}
+
+// StartEmailPolling starts background email polling for all websites with IMAP configured.
+// Polling stops when ctx is done or the server is shut down
+func (s *AdminServer) StartEmailPolling(ctx context.Context) {
+	ctx, s.stopPolling = context.WithCancel(ctx)
+
 	// Poll every 5 minutes
 	ticker := time.NewTicker(5 * time.Minute)
 
@@ -235,12 +444,114 @@ func (s *AdminServer) StartEmailPolling() {
 
 	// Then run on schedule
 	go func() {
-		for range ticker.C {
-			s.pollAllWebsites()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.pollAllWebsites()
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 }
 
+// rollupInterval is how often the scheduler brings the daily analytics rollup up to date
+const rollupInterval = time.Hour
+
+// StartScheduler runs the admin's timed jobs until ctx is cancelled: scheduled collection sales are
+// applied and reverted and due order digests are sent once a minute, and the daily analytics rollup
+// is refreshed once an hour
+func (s *AdminServer) StartScheduler(ctx context.Context) {
+	ctx, s.stopScheduler = context.WithCancel(ctx)
+	ticker := time.NewTicker(time.Minute)
+
+	log.Println("Starting scheduler (sales and order digests every minute, analytics rollup every hour)")
+
+	s.pollers.Add(1)
+	go func() {
+		defer s.pollers.Done()
+		defer ticker.Stop()
+
+		s.runAllScheduledSales(ctx)
+		s.runAllOrderDigests(ctx)
+		s.runAllAnalyticsRollups(ctx)
+		lastRollup := time.Now()
+		for {
+			select {
+			case <-ticker.C:
+				s.runAllScheduledSales(ctx)
+				s.runAllOrderDigests(ctx)
+				if time.Since(lastRollup) >= rollupInterval {
+					s.runAllAnalyticsRollups(ctx)
+					lastRollup = time.Now()
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// runAllScheduledSales runs due sales for each website, stopping between websites on shutdown
+func (s *AdminServer) runAllScheduledSales(ctx context.Context) {
+	websites, err := s.GetAllWebsites()
+	if err != nil {
+		log.Printf("Error getting websites for sale scheduler: %v", err)
+		return
+	}
+
+	for _, website := range websites {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := s.RunScheduledSales(website.ID); err != nil {
+			log.Printf("Error running scheduled sales for %s: %v", website.SiteName, err)
+		}
+	}
+}
+
+// runAllOrderDigests sends the order digest for each website that has it turned on and an admin
+// email to send it to, stopping between websites on shutdown
+func (s *AdminServer) runAllOrderDigests(ctx context.Context) {
+	websites, err := s.GetAllWebsites()
+	if err != nil {
+		log.Printf("Error getting websites for order digests: %v", err)
+		return
+	}
+
+	for _, website := range websites {
+		if ctx.Err() != nil {
+			return
+		}
+		if !website.OrderDigestEnabled || website.EmailFromAddress == "" {
+			continue
+		}
+		if err := s.RunOrderDigest(ctx, website); err != nil {
+			log.Printf("Error running order digest for %s: %v", website.SiteName, err)
+		}
+	}
+}
+
+// runAllAnalyticsRollups brings each website's daily analytics rollup up to date, stopping between
+// websites on shutdown
+func (s *AdminServer) runAllAnalyticsRollups(ctx context.Context) {
+	websites, err := s.GetAllWebsites()
+	if err != nil {
+		log.Printf("Error getting websites for analytics rollup: %v", err)
+		return
+	}
+
+	for _, website := range websites {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := s.RollupDailyAnalytics(ctx, website.ID, website.Timezone); err != nil {
+			log.Printf("Error rolling up analytics for %s: %v", website.SiteName, err)
+		}
+	}
+}
+
 // pollAllWebsites polls IMAP for all websites that have it configured
 func (s *AdminServer) pollAllWebsites() {
 	websites, err := s.GetAllWebsites()
@@ -256,7 +567,11 @@ func (s *AdminServer) pollAllWebsites() {
 		}
 
 		// Poll this website
-		go s.pollWebsiteEmails(website)
+		s.pollers.Add(1)
+		go func(website Website) {
+			defer s.pollers.Done()
+			s.pollWebsiteEmails(website)
+		}(website)
 	}
 }
 
@@ -282,7 +597,7 @@ func (s *AdminServer) pollWebsiteEmails(website Website) {
 	defer db.Close()
 
 	// Create message matcher
-	matcher := &email.DBMessageMatcher{DB: db}
+	matcher := &email.DBMessageMatcher{DB: db.DB}
 
 	// Poll for emails
 	result, err := email.PollIncomingEmails(imapConfig, matcher)
@@ -305,3 +620,112 @@ func (s *AdminServer) pollWebsiteEmails(website Website) {
 		}
 	}
 }
+
+// requestWebsites is what loadWebsite puts in the request context
+type requestWebsites struct {
+	site Website
+	all  []Website
+}
+
+type requestWebsitesKey struct{}
+
+// loadWebsite reads the site configs once per request and puts the website from the URL, along
+// with the full site list the layout shows, in the request context. Unknown websites get the 404
+// page before any handler runs
+func (s *AdminServer) loadWebsite(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if err := checkDatabaseName(id); err != nil {
+			s.renderError(w, r, http.StatusNotFound, "Website not found", nil)
+			return
+		}
+
+		websites, err := s.GetAllWebsites()
+		if err != nil {
+			s.renderError(w, r, http.StatusInternalServerError, "Error loading websites", err)
+			return
+		}
+
+		for _, website := range websites {
+			if website.ID == id {
+				ctx := context.WithValue(r.Context(), requestWebsitesKey{}, &requestWebsites{site: website, all: websites})
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+		}
+
+		s.renderError(w, r, http.StatusNotFound, "Website not found", nil)
+	})
+}
+
+// websiteFromContext returns the website loadWebsite found for the request, and false outside
+// site-scoped routes
+func websiteFromContext(r *http.Request) (Website, bool) {
+	loaded, ok := r.Context().Value(requestWebsitesKey{}).(*requestWebsites)
+	if !ok {
+		return Website{}, false
+	}
+	return loaded.site, true
+}
+
+// allWebsitesFromContext returns the site list loadWebsite read for the request, and false
+// outside site-scoped routes
+func allWebsitesFromContext(r *http.Request) ([]Website, bool) {
+	loaded, ok := r.Context().Value(requestWebsitesKey{}).(*requestWebsites)
+	if !ok {
+		return nil, false
+	}
+	return loaded.all, true
+}
+
+// contentEvents maps the first path segment of a site's admin routes to the content events a
+// write under it publishes. Settings events are published by reloadFrontendConfig
+var contentEvents = map[string][]database.ContentEvent{
+	"products":    {database.ProductsChanged, database.CollectionsChanged},
+	"sales":       {database.ProductsChanged},
+	"orders":      {database.ProductsChanged}, // refunds and cancellations restock
+	"returns":     {database.ProductsChanged}, // receiving a return restocks
+	"collections": {database.CollectionsChanged},
+	"articles":    {database.ArticlesChanged},
+	"categories":  {database.ArticlesChanged},
+	"images":      {database.ProductsChanged, database.ArticlesChanged},
+	"import":      {database.ProductsChanged, database.CollectionsChanged, database.ArticlesChanged},
+	"recompute":   {database.ProductsChanged, database.ArticlesChanged},
+}
+
+// publishContentOnWrite publishes the content events for a write to the site's
+// products, collections, articles etc., so the frontend and API drop cached copies straight away
+func publishContentOnWrite(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			return
+		}
+		website, ok := websiteFromContext(r)
+		if !ok {
+			return
+		}
+		prefix := "/site/" + chi.URLParam(r, "id") + "/"
+		i := strings.Index(r.URL.Path, prefix)
+		if i < 0 {
+			return
+		}
+		section := strings.SplitN(r.URL.Path[i+len(prefix):], "/", 2)[0]
+		if events, ok := contentEvents[section]; ok {
+			database.Publish(website.DatabaseName, events...)
+		}
+	})
+}
+
+// invalidateOverviewOnWrite drops the site's cached overview stats after any write (order, product,
+// message changes etc.) so the dashboard reflects admin edits immediately
+func (s *AdminServer) invalidateOverviewOnWrite(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			s.InvalidateOverviewStats(chi.URLParam(r, "id"))
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/murdinc/stencil2/database"
+)
+
+func TestPublishContentOnWrite(t *testing.T) {
+	const dbName = "publish_content_test"
+
+	var mu sync.Mutex
+	var published []database.ContentEvent
+	database.Subscribe(dbName, func(event database.ContentEvent) {
+		mu.Lock()
+		published = append(published, event)
+		mu.Unlock()
+	})
+
+	r := chi.NewRouter()
+	r.Route("/site/{id}", func(r chi.Router) {
+		// Stands in for loadWebsite
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx := context.WithValue(r.Context(), requestWebsitesKey{}, &requestWebsites{site: Website{ID: "shop", DatabaseName: dbName}})
+				next.ServeHTTP(w, r.WithContext(ctx))
+			})
+		})
+		r.Use(publishContentOnWrite)
+		ok := func(w http.ResponseWriter, r *http.Request) {}
+		r.Get("/returns", ok)
+		r.Post("/returns/{returnId}/receive", ok)
+		r.Post("/orders/{orderId}/refund", ok)
+		r.Post("/collections/{collectionId}", ok)
+		r.Post("/messages/{messageId}/read", ok)
+	})
+
+	tests := []struct {
+		method string
+		path   string
+		want   []database.ContentEvent
+	}{
+		{http.MethodPost, "/site/shop/returns/7/receive", []database.ContentEvent{database.ProductsChanged}},
+		{http.MethodPost, "/site/shop/orders/3/refund", []database.ContentEvent{database.ProductsChanged}},
+		{http.MethodPost, "/site/shop/collections/2", []database.ContentEvent{database.CollectionsChanged}},
+		{http.MethodPost, "/site/shop/messages/4/read", nil},
+		{http.MethodGet, "/site/shop/returns", nil},
+	}
+
+	for _, tt := range tests {
+		mu.Lock()
+		published = nil
+		mu.Unlock()
+
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+
+		mu.Lock()
+		got := published
+		mu.Unlock()
+		if len(got) != len(tt.want) {
+			t.Errorf("%s %s published %v, want %v", tt.method, tt.path, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s %s published %v, want %v", tt.method, tt.path, got, tt.want)
+				break
+			}
+		}
+	}
+}
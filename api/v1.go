@@ -4,11 +4,16 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"net/url"
@@ -17,12 +22,17 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/murdinc/stencil2/chat"
 	"github.com/murdinc/stencil2/configs"
 	"github.com/murdinc/stencil2/database"
 	"github.com/murdinc/stencil2/email"
+	"github.com/murdinc/stencil2/media"
+	"github.com/murdinc/stencil2/metrics"
+	"github.com/murdinc/stencil2/outbound"
 	"github.com/murdinc/stencil2/session"
 	"github.com/murdinc/stencil2/shippo"
 	"github.com/murdinc/stencil2/structs"
@@ -30,8 +40,12 @@ import (
 	"github.com/murdinc/stencil2/utils"
 	"github.com/oschwald/geoip2-golang"
 	"github.com/stripe/stripe-go/v78"
+	billingportalsession "github.com/stripe/stripe-go/v78/billingportal/session"
+	checkoutsession "github.com/stripe/stripe-go/v78/checkout/session"
 	"github.com/stripe/stripe-go/v78/customer"
 	"github.com/stripe/stripe-go/v78/paymentintent"
+	"github.com/stripe/stripe-go/v78/price"
+	"github.com/stripe/stripe-go/v78/subscription"
 	"github.com/stripe/stripe-go/v78/webhook"
 )
 
@@ -45,6 +59,26 @@ var rateLimiter = &contactRateLimiter{
 	submissions: make(map[string][]time.Time),
 }
 
+// questionRateLimiter limits product questions separately, so asking one doesn't use up the contact form
+var questionRateLimiter = &contactRateLimiter{
+	submissions: make(map[string][]time.Time),
+}
+
+// returnRateLimiter limits return requests, which also check an order number and email pair
+var returnRateLimiter = &contactRateLimiter{
+	submissions: make(map[string][]time.Time),
+}
+
+var cleanupOnce sync.Once
+
+// Background jobs started by the API run until StopBackgroundJobs cancels this context
+var backgroundCtx, stopBackground = context.WithCancel(context.Background())
+
+// StopBackgroundJobs stops the API's background goroutines (rate limiter cleanup). Called on shutdown
+func StopBackgroundJobs() {
+	stopBackground()
+}
+
 // Global GeoIP reader (loaded once, shared across all sites)
 var geoipReader *geoip2.Reader
 var geoipOnce sync.Once
@@ -101,23 +135,42 @@ func (rl *contactRateLimiter) cleanup() {
 	}
 }
 
-// startCleanup starts a background goroutine to periodically clean up old entries
-func (rl *contactRateLimiter) startCleanup() {
+// startCleanup starts a background goroutine to periodically clean up old entries until ctx is done
+func (rl *contactRateLimiter) startCleanup(ctx context.Context) {
 	go func() {
 		ticker := time.NewTicker(10 * time.Minute)
-		for range ticker.C {
-			rl.cleanup()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rl.cleanup()
+			case <-ctx.Done():
+				log.Println("Contact rate limiter cleanup stopped")
+				return
+			}
 		}
 	}()
 }
 
-// initGeoIP initializes the GeoIP database reader (called once)
-func initGeoIP() {
+// defaultGeoIPPath is where the DB-IP City Lite database is downloaded when no database is configured
+const defaultGeoIPPath = "data/dbip-city-lite.mmdb"
+
+// initGeoIP initializes the GeoIP database reader (called once). A configured database is used as-is;
+// without one the free DB-IP Lite database is downloaded. Analytics simply skips geolocation when
+// neither is available
+func initGeoIP(configuredPath string) {
 	geoipOnce.Do(func() {
-		dbPath := "data/dbip-city-lite.mmdb"
+		dbPath := configuredPath
+		if dbPath == "" {
+			dbPath = defaultGeoIPPath
+		}
 
-		// Check if database exists, if not, download it
+		// Check if database exists, if not, download it (only the default one)
 		if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+			if configuredPath != "" {
+				log.Printf("GeoIP database not found at %s, geolocation tracking will be disabled", dbPath)
+				return
+			}
 			log.Printf("GeoIP database not found at %s, attempting to download...", dbPath)
 			if err := downloadGeoIPDatabase(dbPath); err != nil {
 				log.Printf("Failed to download GeoIP database: %v", err)
@@ -189,6 +242,24 @@ func downloadGeoIPDatabase(dbPath string) error {
 	return nil
 }
 
+// clientIP returns the visitor's IP address. Behind a proxy that's the first (client) entry of
+// X-Forwarded-For, then X-Real-IP; otherwise the connection's remote address without its port
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		if ip := strings.TrimSpace(first); ip != "" {
+			return ip
+		}
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
 // lookupGeolocation returns geographic data for an IP address
 func lookupGeolocation(ipString string) (country, countryCode, region, city string, latitude, longitude *float64) {
 	// Return empty if GeoIP is not initialized
@@ -196,13 +267,11 @@ func lookupGeolocation(ipString string) (country, countryCode, region, city stri
 		return "", "", "", "", nil, nil
 	}
 
-	// Clean IP address (remove port if present)
-	if colonIndex := strings.LastIndex(ipString, ":"); colonIndex != -1 {
-		ipString = ipString[:colonIndex]
+	// Parse IP, dropping the port if present
+	if host, _, err := net.SplitHostPort(ipString); err == nil {
+		ipString = host
 	}
-
-	// Parse IP
-	ip := net.ParseIP(ipString)
+	ip := net.ParseIP(strings.TrimSpace(ipString))
 	if ip == nil {
 		return "", "", "", "", nil, nil
 	}
@@ -235,6 +304,34 @@ func lookupGeolocation(ipString string) (country, countryCode, region, city stri
 	return country, countryCode, region, city, &lat, &lon
 }
 
+// parseBotIPRanges parses the configured crawler CIDR ranges, skipping any that are invalid
+func parseBotIPRanges(ranges []string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range ranges {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			log.Printf("Ignoring invalid bot IP range %q: %v", cidr, err)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// isBotNetwork reports whether an IP address falls in one of the configured crawler ranges
+func (api *APIV1) isBotNetwork(ipAddress string) bool {
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return false
+	}
+	for _, network := range api.botNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // API represents the V1 API instance.
 type APIV1 struct {
 	Routes        []Route
@@ -242,6 +339,9 @@ type APIV1 struct {
 	websiteConfig *configs.WebsiteConfig
 	envConfig     *configs.EnvironmentConfig
 	shippoClient  *shippo.Client
+	botNetworks   []*net.IPNet
+	catalogCache  *catalogCache
+	dispatcher    *outbound.Dispatcher
 }
 
 type ErrorResponse struct {
@@ -249,16 +349,35 @@ type ErrorResponse struct {
 	ErrorString string `json:"error_message"`
 }
 
+// APIResponse is the envelope list endpoints return when called with ?envelope=true.
+// Without the flag they return bare arrays, as they always have
+type APIResponse struct {
+	Data  interface{}   `json:"data"`
+	Meta  *ResponseMeta `json:"meta,omitempty"`
+	Error string        `json:"error,omitempty"`
+}
+
+// ResponseMeta carries pagination info for list endpoints
+type ResponseMeta struct {
+	Total  int `json:"total"`  // items matching the request across all pages
+	Count  int `json:"count"`  // items in this response
+	Offset int `json:"offset"` // offset of the first item in this response
+}
+
 // NewAPIV1 creates and returns a new instance of the V1 API.
 func NewAPIV1(dbConn *database.DBConnection, websiteConfig *configs.WebsiteConfig, envConfig *configs.EnvironmentConfig) *APIV1 {
 	// Get Shippo API key from site config
 	shippoKey := websiteConfig.Shippo.APIKey
 
 	// Start rate limiter cleanup (only once for all sites)
-	rateLimiter.startCleanup()
+	cleanupOnce.Do(func() {
+		rateLimiter.startCleanup(backgroundCtx)
+		questionRateLimiter.startCleanup(backgroundCtx)
+		returnRateLimiter.startCleanup(backgroundCtx)
+	})
 
 	// Initialize GeoIP database (only once for all sites)
-	initGeoIP()
+	initGeoIP(envConfig.GeoIP.DatabasePath)
 
 	api := &APIV1{
 		Routes:        make([]Route, 0),
@@ -266,7 +385,14 @@ func NewAPIV1(dbConn *database.DBConnection, websiteConfig *configs.WebsiteConfi
 		websiteConfig: websiteConfig,
 		envConfig:     envConfig,
 		shippoClient:  shippo.NewClient(shippoKey),
+		botNetworks:   parseBotIPRanges(envConfig.Analytics.BotIPRanges),
+		catalogCache:  &catalogCache{entries: make(map[string]catalogCacheEntry)},
 	}
+	database.Subscribe(dbConn.Name, api.catalogCache.invalidate)
+
+	// Deliver chat posts and webhook events, with retries, until shutdown
+	api.dispatcher = outbound.NewDispatcher(dbConn, api.outboundEndpoint)
+	go api.dispatcher.Run(backgroundCtx)
 
 	api.initRoutesV1()
 
@@ -293,17 +419,23 @@ func (api *APIV1) initRoutesV1() {
 	// single post
 	api.addRoute("/api/v1/post/{slug}", "GET", api.getPost, "post")
 
+	// content changed since a time, for incremental sync
+	api.addRoute("/api/v1/changes", "GET", api.getChanges, "changes")
+
 	// E-commerce routes
 
 	// Collections
-	api.addRoute("/api/v1/collections", "GET", api.getCollections, "collections")
-	api.addRoute("/api/v1/collection/{slug}", "GET", api.getCollection, "collection")
+	api.addRoute("/api/v1/collections", "GET", api.cachedCatalog(api.getCollections), "collections")
+	api.addRoute("/api/v1/collection/{slug}", "GET", api.cachedCatalog(api.getCollection), "collection")
 
 	// Products
-	api.addRoute("/api/v1/products", "GET", api.getProducts, "products")
-	api.addRoute("/api/v1/products/{count}", "GET", api.getProducts, "products")
-	api.addRoute("/api/v1/products/{count}/{offset}", "GET", api.getProducts, "products")
-	api.addRoute("/api/v1/product/{slug}", "GET", api.getProduct, "product")
+	api.addRoute("/api/v1/products", "GET", api.cachedCatalog(api.getProducts), "products")
+	api.addRoute("/api/v1/products/on-sale", "GET", api.cachedCatalog(api.getOnSaleProducts), "on-sale-products")
+	api.addRoute("/api/v1/products/{count}", "GET", api.cachedCatalog(api.getProducts), "products")
+	api.addRoute("/api/v1/products/{count}/{offset}", "GET", api.cachedCatalog(api.getProducts), "products")
+	api.addRoute("/api/v1/product/{slug}", "GET", api.cachedCatalog(api.getProduct), "product")
+	api.addRoute("/api/v1/product/{slug}/questions", "GET", api.getProductQuestions, "questions")
+	api.addRoute("/api/v1/product/{slug}/questions", "POST", api.askProductQuestion, "questions")
 
 	// Collection Products
 	api.addRoute("/api/v1/collection/{slug}/products", "GET", api.getCollectionProducts, "products")
@@ -312,26 +444,40 @@ func (api *APIV1) initRoutesV1() {
 
 	// Cart
 	api.addRoute("/api/v1/cart", "GET", api.getCart, "cart")
+	api.addRoute("/api/v1/cart/detailed", "GET", api.getCartDetailed, "cart")
 	api.addRoute("/api/v1/cart/add", "POST", api.addToCart, "cart")
 	api.addRoute("/api/v1/cart/update/{itemId}", "POST", api.updateCartItem, "cart")
 	api.addRoute("/api/v1/cart/remove/{itemId}", "POST", api.removeFromCart, "cart")
 
+	// Wishlist
+	api.addRoute("/api/v1/wishlist", "GET", api.getWishlist, "wishlist")
+	api.addRoute("/api/v1/wishlist/add", "POST", api.addToWishlist, "wishlist")
+	api.addRoute("/api/v1/wishlist/remove/{productId}", "POST", api.removeFromWishlist, "wishlist")
+	api.addRoute("/api/v1/recently-viewed", "GET", api.getRecentlyViewed, "recently-viewed")
+	api.addRoute("/api/v1/recently-viewed", "POST", api.recordProductView, "recently-viewed")
+
 	// Checkout & Orders
 	api.addRoute("/api/v1/config", "GET", api.getConfig, "config")
 	api.addRoute("/api/v1/validate-address", "POST", api.validateAddress, "address")
 	api.addRoute("/api/v1/create-payment-intent", "POST", api.createPaymentIntent, "payment")
+	api.addRoute("/api/v1/create-checkout-session", "POST", api.createCheckoutSession, "payment")
+	api.addRoute("/api/v1/customer-portal", "POST", api.createCustomerPortalSession, "payment")
+	api.addRoute("/api/v1/create-subscription", "POST", api.createSubscription, "payment")
 	api.addRoute("/api/v1/checkout", "POST", api.createOrder, "order")
+	api.addRoute("/api/v1/order/confirm/{token}", "GET", api.confirmOrder, "order-confirm")
 	api.addRoute("/api/v1/order/{orderNumber}", "GET", api.getOrder, "order")
+	api.addRoute("/api/v1/order/{orderNumber}/returns", "GET", api.getOrderReturns, "returns")
+	api.addRoute("/api/v1/order/{orderNumber}/returns", "POST", api.requestReturn, "returns")
 	api.addRoute("/api/v1/tracking/{carrier}/{trackingNumber}", "GET", api.getTracking, "tracking")
 	api.addRoute("/api/v1/webhook/stripe", "GET", api.webhookInfo, "webhook")
-	api.addRoute("/api/v1/webhook/stripe", "POST", api.handleStripeWebhook, "webhook")
+	api.addRoute("/api/v1/webhook/stripe", "POST", api.recordWebhook("stripe", api.handleStripeWebhook), "webhook")
 	api.addRoute("/api/v1/webhook/shippo", "GET", api.webhookInfo, "webhook")
-	api.addRoute("/api/v1/webhook/shippo", "POST", api.handleShippoWebhook, "webhook")
+	api.addRoute("/api/v1/webhook/shippo", "POST", api.recordWebhook("shippo", api.handleShippoWebhook), "webhook")
 
 	// Marketing
 	api.addRoute("/api/v1/sms-signup", "POST", api.createSMSSignup, "sms")
 	api.addRoute("/api/v1/sms-verify", "POST", api.verifySMSCode, "sms")
-	api.addRoute("/api/v1/sms-webhook", "POST", api.handleSMSWebhook, "sms")
+	api.addRoute("/api/v1/sms-webhook", "POST", api.recordWebhook("sms", api.handleSMSWebhook), "sms")
 
 	// Analytics
 	api.addRoute("/api/v1/track", "POST", api.trackAnalytics, "analytics")
@@ -343,23 +489,116 @@ func (api *APIV1) initRoutesV1() {
 func (api *APIV1) APIRouter(siteName string) chi.Router {
 	r := chi.NewRouter()
 	r.NotFound(api.NotFoundHandler)
+	r.Use(routeMetrics)
+
+	// Gzip JSON and text responses for clients that accept it; media never passes through here
+	if !api.envConfig.HTTP.DisableCompression {
+		r.Use(compressResponses)
+	}
+
+	// Collect the methods served on each path for CORS preflight responses
+	pathMethods := make(map[string][]string)
+	var paths []string
+	for _, route := range api.Routes {
+		if _, exists := pathMethods[route.Path]; !exists {
+			paths = append(paths, route.Path)
+		}
+		pathMethods[route.Path] = append(pathMethods[route.Path], route.Method)
+	}
 
 	for _, route := range api.Routes {
 		fmt.Printf("			> Setting up API route: %s %s%s\n", route.Method, siteName, route.Path)
+		limits := api.requestLimits(route)
+		cors := api.corsHeaders(route.Path, pathMethods[route.Path])
 		switch route.Method {
 		case "GET":
-			r.With(APIRouterCtx).Get(route.Path, route.HTTPHandler)
+			r.With(APIRouterCtx, cors, limits).Get(route.Path, route.HTTPHandler)
 		case "POST":
-			r.With(APIRouterCtx).Post(route.Path, route.HTTPHandler)
+			r.With(APIRouterCtx, cors, limits).Post(route.Path, route.HTTPHandler)
 		case "PUT":
-			r.With(APIRouterCtx).Put(route.Path, route.HTTPHandler)
+			r.With(APIRouterCtx, cors, limits).Put(route.Path, route.HTTPHandler)
 		case "DELETE":
-			r.With(APIRouterCtx).Delete(route.Path, route.HTTPHandler)
+			r.With(APIRouterCtx, cors, limits).Delete(route.Path, route.HTTPHandler)
+		}
+	}
+
+	// Answer preflight requests for every non-webhook path
+	for _, path := range paths {
+		if isWebhookPath(path) {
+			continue
 		}
+		r.With(api.corsHeaders(path, pathMethods[path])).Options(path, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
 	}
 	return r
 }
 
+// isWebhookPath reports whether path is a server-to-server webhook endpoint, which gets no CORS
+// headers and no request timeout
+func isWebhookPath(path string) bool {
+	return strings.Contains(path, "webhook")
+}
+
+// allowedOrigin reports whether origin may call the API, and whether it was listed explicitly
+// (only explicitly listed origins may send credentials; a "*" entry allows anonymous calls)
+func (api *APIV1) allowedOrigin(origin string) (allowed bool, explicit bool) {
+	for _, entry := range api.websiteConfig.HTTP.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(entry, "/"), origin) {
+			return true, true
+		}
+		if entry == "*" {
+			allowed = true
+		}
+	}
+	return allowed, false
+}
+
+// corsHeaders adds CORS headers for origins listed in the site's http.allowedOrigins. Requests
+// without an Origin header (same-origin, server-to-server) pass through untouched, and disallowed
+// preflights are rejected so the default stays same-origin only
+func (api *APIV1) corsHeaders(path string, methods []string) func(http.Handler) http.Handler {
+	allowMethods := strings.Join(append(append([]string{}, methods...), "OPTIONS"), ", ")
+
+	return func(next http.Handler) http.Handler {
+		if isWebhookPath(path) {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Origin")
+			ok, explicit := api.allowedOrigin(origin)
+			if !ok {
+				if r.Method == http.MethodOptions {
+					writeAPIError(w, http.StatusForbidden, "origin not allowed")
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if explicit {
+				w.Header().Set("Access-Control-Allow-Credentials", "true") // cart and checkout rely on the session cookie
+			}
+
+			if r.Method == http.MethodOptions {
+				w.Header().Set("Access-Control-Allow-Methods", allowMethods)
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+				w.Header().Set("Access-Control-Max-Age", "600")
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func APIRouterCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		taxonomy := chi.URLParam(r, "taxonomy")
@@ -391,301 +630,1077 @@ func APIRouterCtx(next http.Handler) http.Handler {
 	})
 }
 
-func (api *APIV1) GetInternalHandler(path string) (string, map[string]string, error) {
-	params := make(map[string]string)
-	if path == "" {
-		return "", params, nil
+// runsToCompletion reports whether a route is exempt from the request timeout: webhooks, so a
+// delivery is never abandoned halfway through, and the order and payment endpoints, whose Stripe
+// calls and inserts carry on regardless of the deadline. Answering those early would only tell the
+// client to retry something that went through
+func runsToCompletion(route Route) bool {
+	if isWebhookPath(route.Path) {
+		return true
 	}
+	return route.Method == "POST" && (route.InternalHandler == "order" || route.InternalHandler == "payment")
+}
 
-	// Parse the path and separate URL parameters
-	u, err := url.Parse(path)
-	if err != nil {
-		return "", params, err
-	}
-	path = u.Path
+// requestLimits caps the request body size and bounds how long a handler may run, using the
+// http.maxBodyBytes and http.requestTimeout environment settings. Routes that runsToCompletion are
+// exempt from the timeout (webhooks cap their own bodies)
+func (api *APIV1) requestLimits(route Route) func(http.Handler) http.Handler {
+	maxBytes := api.envConfig.HTTP.MaxBodyBytes
+	timeout := time.Duration(api.envConfig.HTTP.RequestTimeout) * time.Second
+	if runsToCompletion(route) {
+		timeout = 0
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if maxBytes > 0 {
+				if r.ContentLength > maxBytes {
+					writeAPIError(w, http.StatusRequestEntityTooLarge, "request body too large")
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			}
 
-	// Extract URL parameters into a map
-	queryParams := u.Query()
-	for key := range queryParams {
-		params[key] = queryParams.Get(key)
-	}
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-	for _, route := range api.Routes {
-		if route.Path == path {
-			return route.InternalHandler, params, nil
-		}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			// Nothing is buffered: the handler writes straight through. If it hasn't started its
+			// response by the deadline it gets a 503 and any later writes are dropped; once it
+			// has, the response is committed and it's left to finish (streamed listings included)
+			tw := &timeoutWriter{w: w, header: w.Header().Clone()}
+			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
+
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+				close(done)
+			}()
+
+			select {
+			case p := <-panicked:
+				panic(p)
+			case <-done:
+				return
+			case <-ctx.Done():
+			}
+
+			tw.mu.Lock()
+			if !tw.started {
+				tw.timedOut = true
+				tw.mu.Unlock()
+				log.Printf("API request timed out after %s: %s %s", timeout, r.Method, r.URL.Path)
+				writeAPIError(w, http.StatusServiceUnavailable, "request timed out")
+				return
+			}
+			tw.mu.Unlock()
+
+			select {
+			case p := <-panicked:
+				panic(p)
+			case <-done:
+			}
+		})
 	}
-	return "", params, errors.New("internal handler not found!")
 }
 
-// addRoute adds a new route to the API.
-func (api *APIV1) addRoute(path string, method string, httpHandler http.HandlerFunc, internalHandler string) {
-	api.Routes = append(api.Routes, Route{
-		Path:            path,
-		Method:          method,
-		HTTPHandler:     httpHandler,
-		InternalHandler: internalHandler,
-	})
+// timeoutWriter passes a handler's response through to w unless requestLimits has already answered
+// with a 503. Headers are kept apart until the first write so a late handler can't touch w's
+type timeoutWriter struct {
+	mu       sync.Mutex
+	w        http.ResponseWriter
+	header   http.Header
+	started  bool
+	timedOut bool
 }
 
-func (api *APIV1) getCategories(w http.ResponseWriter, r *http.Request) {
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
 
-	// Parse the path and separate URL parameters
-	u, err := url.Parse(r.URL.String())
-	if err != nil {
-		fmt.Println("Error:", err)
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
 	}
+	tw.start(http.StatusOK)
+	return tw.w.Write(p)
+}
 
-	// Extract URL parameters into a map
-	params := make(map[string]string)
-	queryParams := u.Query()
-	for key := range queryParams {
-		params[key] = queryParams.Get(key)
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.started {
+		return
 	}
+	tw.start(code)
+}
 
-	categories, err := api.dbConn.GetCategories(params)
-	if err != nil {
-		// 500? 404?
-		fmt.Println("Error:", err)
+// Flush sends anything written so far, for handlers that stream
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
 	}
+	tw.start(http.StatusOK)
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
 
-	jsonData, err := json.MarshalIndent(categories, "", "    ")
-	if err != nil {
-		fmt.Println("Error:", err)
+// start commits the response, copying the handler's headers over. Callers hold mu
+func (tw *timeoutWriter) start(code int) {
+	if tw.started {
 		return
 	}
+	tw.started = true
+	header := tw.w.Header()
+	for key := range header {
+		if _, ok := tw.header[key]; !ok {
+			header.Del(key)
+		}
+	}
+	for key, values := range tw.header {
+		header[key] = values
+	}
+	tw.w.WriteHeader(code)
+}
 
-	// Set the Content-Type header to application/json
-	w.Header().Set("Content-Type", "application/json")
+// minCompressBytes is the smallest response compressResponses gzips. Below it the gzip framing
+// costs about as much as it saves
+const minCompressBytes = 1024
 
-	// Write the JSON data to the response writer
-	w.Write(jsonData)
-}
+// compressTypes are the content types worth compressing
+var compressTypes = []string{"application/json", "text/plain", "text/csv"}
 
-func (api *APIV1) getPost(w http.ResponseWriter, r *http.Request) {
+// compressResponses gzips JSON and text responses of at least minCompressBytes for clients that
+// send Accept-Encoding: gzip. Smaller responses, and anything already encoded, go out as they are
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-	// get the slug context
-	ctx := r.Context()
+		cw := &compressWriter{ResponseWriter: w}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
 
-	vars, ok := ctx.Value("vars").(map[string]string)
-	if !ok {
-		// TODO what to actually do here?
-		http.Error(w, http.StatusText(422), 422)
-		return
-	}
+// compressWriter holds back the start of a response until there's minCompressBytes of it, or
+// the handler finishes or flushes, and then sends it gzipped or as is
+type compressWriter struct {
+	http.ResponseWriter
+	code    int
+	buf     []byte
+	started bool
+	gz      *gzip.Writer
+}
 
-	// Parse the path and separate URL parameters
-	u, err := url.Parse(r.URL.String())
-	if err != nil {
-		fmt.Println("Error:", err)
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.code == 0 {
+		cw.code = code
 	}
+}
 
-	// Extract URL parameters into a map
-	params := make(map[string]string)
-	queryParams := u.Query()
-	for key := range queryParams {
-		params[key] = queryParams.Get(key)
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.code == 0 {
+		cw.code = http.StatusOK
 	}
-
-	post, err := api.dbConn.GetSingularPost(vars, params)
-	if err != nil {
-		// 500? 404?
-		fmt.Println("Error:", err)
+	if !cw.started {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < minCompressBytes {
+			return len(p), nil
+		}
+		if err := cw.start(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
 	}
-
-	jsonData, err := json.MarshalIndent(post, "", "    ")
-	if err != nil {
-		fmt.Println("Error:", err)
-		return
+	if cw.gz != nil {
+		return cw.gz.Write(p)
 	}
-
-	// Set the Content-Type header to application/json
-	w.Header().Set("Content-Type", "application/json")
-
-	// Write the JSON data to the response writer
-	w.Write(jsonData)
+	return cw.ResponseWriter.Write(p)
 }
 
-func (api *APIV1) getPosts(w http.ResponseWriter, r *http.Request) {
-
-	// get the slug context
-	ctx := r.Context()
-	vars, ok := ctx.Value("vars").(map[string]string)
-	if !ok {
-		// TODO what to actually do here?
-		http.Error(w, http.StatusText(422), 422)
-		return
+// start sends the header and whatever is held back, compressing from here on when the response
+// is big enough and of a compressible type
+func (cw *compressWriter) start() error {
+	cw.started = true
+	header := cw.Header()
+	if header.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
 	}
 
-	// Parse the path and separate URL parameters
-	u, err := url.Parse(r.URL.String())
-	if err != nil {
-		fmt.Println("Error:", err)
+	if len(cw.buf) >= minCompressBytes && header.Get("Content-Encoding") == "" && compressible(header.Get("Content-Type")) {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		cw.gz = gzip.NewWriter(cw.ResponseWriter)
 	}
 
-	// Extract URL parameters into a map
-	params := make(map[string]string)
-	queryParams := u.Query()
-	for key := range queryParams {
-		params[key] = queryParams.Get(key)
+	if cw.code != 0 {
+		cw.ResponseWriter.WriteHeader(cw.code)
+	}
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.gz != nil {
+		_, err = cw.gz.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
 	}
+	cw.buf = nil
+	return err
+}
 
-	posts, err := api.dbConn.GetMultiplePosts(vars, params)
-	if err != nil {
-		// 500? 404?
-		fmt.Println("Error:", err)
+// Flush sends what's been written so far, so streamed responses keep moving
+func (cw *compressWriter) Flush() {
+	if !cw.started {
+		cw.start()
+	}
+	if cw.gz != nil {
+		cw.gz.Flush()
 	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
 
-	jsonData, err := json.MarshalIndent(posts, "", "    ")
-	if err != nil {
-		fmt.Println("Error:", err)
-		return
+// Close sends a response that never reached minCompressBytes and ends the gzip stream
+func (cw *compressWriter) Close() {
+	if !cw.started {
+		cw.start()
+	}
+	if cw.gz != nil {
+		cw.gz.Close()
 	}
+}
 
-	// Set the Content-Type header to application/json
-	w.Header().Set("Content-Type", "application/json")
+// compressible reports whether contentType is one of compressTypes
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+	for _, t := range compressTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
 
-	// Write the JSON data to the response writer
-	w.Write(jsonData)
+// routeMetrics labels the request metrics with the API route matched, which the site's router
+// can't see since the API runs on its own chi
+func routeMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			metrics.SetRoute(r, rctx.RoutePattern())
+		}
+	})
 }
 
-// E-commerce API Handlers
+// writeWithETag writes a read response with an ETag derived from its body, answering 304 Not
+// Modified when the client's If-None-Match already matches. Cache-Control from APIRouterCtx is kept.
+// The ETag is weak since the same body may be sent gzipped or not
+func writeWithETag(w http.ResponseWriter, r *http.Request, body []byte) {
+	etag := bodyETag(body)
+	w.Header().Set("ETag", "W/"+etag)
 
-func (api *APIV1) getCollections(w http.ResponseWriter, r *http.Request) {
-	collections, err := api.dbConn.GetCollections()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	jsonData, err := json.MarshalIndent(collections, "", "    ")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	w.Write(body)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonData)
+// bodyETag derives a (strong form) ETag from a response body
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
 }
 
-func (api *APIV1) getCollection(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	vars, ok := ctx.Value("vars").(map[string]string)
-	if !ok {
-		http.Error(w, http.StatusText(422), 422)
+// defaultCatalogCacheSeconds is how long product and collection reads are cached when
+// http.catalogCacheSeconds isn't set
+const defaultCatalogCacheSeconds = 60
+
+// maxCatalogCacheEntries caps how many responses a site's catalog cache holds; it's emptied when full
+const maxCatalogCacheEntries = 1000
+
+// catalogCache holds a site's recent product and collection responses by URL and language
+type catalogCache struct {
+	mu      sync.Mutex
+	entries map[string]catalogCacheEntry
+	version atomic.Int64 // bumped on every invalidation, so responses started before it aren't kept
+}
+
+// invalidate empties the cache when an event touches what it holds. It's subscribed to the site's
+// content events in NewAPIV1
+func (c *catalogCache) invalidate(event database.ContentEvent) {
+	switch event {
+	case database.ProductsChanged, database.CollectionsChanged, database.SettingsChanged:
+	default:
 		return
 	}
 
-	collection, err := api.dbConn.GetCollection(vars["slug"])
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+	c.mu.Lock()
+	c.version.Add(1)
+	c.entries = make(map[string]catalogCacheEntry)
+	c.mu.Unlock()
+}
+
+// catalogCacheEntry is a cached response, good until expiresAt
+type catalogCacheEntry struct {
+	expiresAt time.Time
+	header    http.Header
+	body      []byte
+}
+
+// responseRecorder captures a handler's response so it can be cached
+type responseRecorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) Header() http.Header { return rec.header }
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	if rec.code == 0 {
+		rec.code = http.StatusOK
+	}
+	return rec.body.Write(p)
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	if rec.code == 0 {
+		rec.code = code
+	}
+}
+
+// catalogCacheTTL is how long catalog responses are cached, 0 when caching is off
+func (api *APIV1) catalogCacheTTL() time.Duration {
+	seconds := api.websiteConfig.HTTP.CatalogCacheSeconds
+	if seconds == 0 {
+		seconds = defaultCatalogCacheSeconds
+	}
+	if seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// cachedCatalog serves a product or collection read from memory while it's fresh, and otherwise
+// runs handler and caches its response when it succeeds. Product, collection and settings events
+// (see database.Publish) from admin edits and orders empty the cache. Requests
+// with Cache-Control: no-cache skip the cache. X-Cache says whether the response was a HIT or MISS
+func (api *APIV1) cachedCatalog(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ttl := api.catalogCacheTTL()
+		if ttl <= 0 {
+			handler(w, r)
+			return
+		}
+
+		key := r.URL.RequestURI() + "\x00" + r.Header.Get("Accept-Language")
+		version := api.catalogCache.version.Load()
+		bypass := strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache")
+
+		if !bypass {
+			api.catalogCache.mu.Lock()
+			entry, ok := api.catalogCache.entries[key]
+			api.catalogCache.mu.Unlock()
+			if ok && time.Now().Before(entry.expiresAt) {
+				w.Header().Set("X-Cache", "HIT")
+				writeCachedResponse(w, r, entry.header, http.StatusOK, entry.body)
+				return
+			}
+		}
+
+		// Run the handler without the client's If-None-Match so there's a whole body to keep
+		rec := &responseRecorder{header: make(http.Header)}
+		inner := r.Clone(r.Context())
+		inner.Header.Del("If-None-Match")
+		handler(rec, inner)
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+
+		// Streamed listings come without an ETag, so give them one now the whole body is here
+		if rec.code == http.StatusOK && rec.header.Get("ETag") == "" {
+			rec.header.Set("ETag", "W/"+bodyETag(rec.body.Bytes()))
+		}
+
+		if rec.code == http.StatusOK {
+			api.catalogCache.mu.Lock()
+			// Skip responses that were invalidated while the handler ran, they may already be stale
+			if api.catalogCache.version.Load() == version {
+				if len(api.catalogCache.entries) >= maxCatalogCacheEntries {
+					api.catalogCache.entries = make(map[string]catalogCacheEntry)
+				}
+				api.catalogCache.entries[key] = catalogCacheEntry{
+					expiresAt: time.Now().Add(ttl),
+					header:    rec.header.Clone(),
+					body:      rec.body.Bytes(),
+				}
+			}
+			api.catalogCache.mu.Unlock()
+		}
+
+		w.Header().Set("X-Cache", "MISS")
+		writeCachedResponse(w, r, rec.header, rec.code, rec.body.Bytes())
+	}
+}
+
+// writeCachedResponse writes a recorded response, answering 304 Not Modified when the client
+// already has its ETag
+func writeCachedResponse(w http.ResponseWriter, r *http.Request, header http.Header, code int, body []byte) {
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+
+	if etag := strings.TrimPrefix(header.Get("ETag"), "W/"); code == http.StatusOK && etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	jsonData, err := json.MarshalIndent(collection, "", "    ")
-	if err != nil {
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// wantsEnvelope reports whether the client opted into the APIResponse envelope
+func wantsEnvelope(r *http.Request) bool {
+	return r.URL.Query().Get("envelope") == "true"
+}
+
+// writeList writes a listing as a bare array, or wrapped in an APIResponse with pagination
+// metadata when requested. total is only called for enveloped responses; nil means count is the total
+func (api *APIV1) writeList(w http.ResponseWriter, r *http.Request, items interface{}, count int, vars map[string]string, total func() (int, error)) {
+	if !wantsEnvelope(r) {
+		api.streamJSON(w, r, items)
+		return
+	}
+
+	meta := &ResponseMeta{Total: count, Count: count}
+	if vars != nil {
+		meta.Offset, _ = database.ListWindow(vars)
+	}
+	if total != nil {
+		n, err := total()
+		if err != nil {
+			log.Printf("Error counting %s: %v", r.URL.Path, err)
+		} else {
+			meta.Total = n
+		}
+	}
+
+	api.streamJSON(w, r, APIResponse{Data: items, Meta: meta})
+}
+
+// writeListError reports a failed listing, as an APIResponse error when the envelope was requested
+func (api *APIV1) writeListError(w http.ResponseWriter, r *http.Request, err error) {
+	if !wantsEnvelope(r) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	jsonData, _ := json.Marshal(APIResponse{Error: err.Error()})
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
 	w.Write(jsonData)
 }
 
-func (api *APIV1) getProducts(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	vars, ok := ctx.Value("vars").(map[string]string)
-	if !ok {
-		http.Error(w, http.StatusText(422), 422)
+// streamJSON encodes a (potentially large) listing straight to the response instead of building it
+// in memory. Output is compact in production unless ?pretty=true is passed, and indented in dev.
+// There's no ETag since that would need the whole body first; cachedCatalog adds one to what it keeps
+func (api *APIV1) streamJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	enc := json.NewEncoder(w)
+	if api.indentJSON(r) {
+		enc.SetIndent("", "    ")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := enc.Encode(v); err != nil {
+		// Headers are already sent, so all we can do is log it
+		log.Printf("Error streaming JSON response for %s: %v", r.URL.Path, err)
+	}
+}
+
+// indentJSON reports whether listings should be indented: always in dev, and in production on ?pretty=true
+func (api *APIV1) indentJSON(r *http.Request) bool {
+	return !api.envConfig.ProdMode || r.URL.Query().Get("pretty") == "true"
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag (weak comparison)
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeAPIError writes a JSON ErrorResponse with the given status
+func writeAPIError(w http.ResponseWriter, statusCode int, message string) {
+	jsonData, err := json.Marshal(ErrorResponse{
+		StatusCode:  statusCode,
+		ErrorString: message,
+	})
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(jsonData)
+}
+
+// writeLookupError answers a failed lookup of a single item ("product", "collection", "order"): 404
+// when there's no such item, otherwise a logged 500, so database problems don't pass for missing items
+func writeLookupError(w http.ResponseWriter, item string, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		writeAPIError(w, http.StatusNotFound, strings.ToUpper(item[:1])+item[1:]+" not found")
 		return
 	}
+	log.Printf("Error loading %s: %v", item, err)
+	writeAPIError(w, http.StatusInternalServerError, "Failed to load "+item)
+}
+
+func (api *APIV1) GetInternalHandler(path string) (string, map[string]string, error) {
+	params := make(map[string]string)
+	if path == "" {
+		return "", params, nil
+	}
+
+	// Parse the path and separate URL parameters
+	u, err := url.Parse(path)
+	if err != nil {
+		return "", params, err
+	}
+	path = u.Path
+
+	// Extract URL parameters into a map
+	queryParams := u.Query()
+	for key := range queryParams {
+		params[key] = queryParams.Get(key)
+	}
+
+	for _, route := range api.Routes {
+		if route.Path == path {
+			return route.InternalHandler, params, nil
+		}
+	}
+	return "", params, errors.New("internal handler not found!")
+}
+
+// addRoute adds a new route to the API.
+func (api *APIV1) addRoute(path string, method string, httpHandler http.HandlerFunc, internalHandler string) {
+	api.Routes = append(api.Routes, Route{
+		Path:            path,
+		Method:          method,
+		HTTPHandler:     httpHandler,
+		InternalHandler: internalHandler,
+	})
+}
+
+// mediaURL serves a local upload through the site's media proxy when one is configured, so
+// stored image URLs can stay as they are
+func (api *APIV1) mediaURL(imageURL string) string {
+	return media.ProxiedUploadURL(imageURL, api.websiteConfig.MediaProxyURL)
+}
+
+// proxyPostImages rewrites a post's image URLs with mediaURL
+func (api *APIV1) proxyPostImages(post *structs.Post) {
+	post.Image.URL = api.mediaURL(post.Image.URL)
+	for i := range post.Slides {
+		post.Slides[i].Image.URL = api.mediaURL(post.Slides[i].Image.URL)
+	}
+}
+
+// prepareProduct readies a product for output: image URLs go through mediaURL and tax-inclusive
+// prices are marked with the tax they contain
+func (api *APIV1) prepareProduct(product *structs.Product) {
+	api.setProductTax(product)
+	for i := range product.Images {
+		product.Images[i].Image.URL = api.mediaURL(product.Images[i].Image.URL)
+	}
+	for i := range product.Collections {
+		product.Collections[i].Image.URL = api.mediaURL(product.Collections[i].Image.URL)
+	}
+}
+
+// requestLocales returns the translations a request asks for, most preferred first: ?locale= or
+// else Accept-Language, each followed by its language alone. Locales outside locales.supported
+// (when set) and the default language, which needs no translation, are left out
+func (api *APIV1) requestLocales(r *http.Request) []string {
+	var requested []string
+	if locale := utils.NormalizeLocale(r.URL.Query().Get("locale")); locale != "" {
+		requested = []string{locale}
+	} else {
+		requested = utils.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	}
 
+	config := api.websiteConfig.Locales
+	defaultLocale := utils.NormalizeLocale(config.Default)
+	supported := make(map[string]bool)
+	for _, locale := range config.Supported {
+		supported[utils.NormalizeLocale(locale)] = true
+	}
+
+	var locales []string
+	for _, locale := range utils.LocaleFallbacks(requested) {
+		if locale == defaultLocale {
+			// Everything after the default language is less preferred than the text as written
+			break
+		}
+		if len(supported) == 0 || supported[locale] {
+			locales = append(locales, locale)
+		}
+	}
+	return locales
+}
+
+// setContentLanguage marks a response as varying by Accept-Language and names the language it's
+// in: the translation used, else the default language when one is configured
+func (api *APIV1) setContentLanguage(w http.ResponseWriter, locale string) {
+	w.Header().Add("Vary", "Accept-Language")
+	if locale == "" {
+		locale = utils.NormalizeLocale(api.websiteConfig.Locales.Default)
+	}
+	if locale != "" {
+		w.Header().Set("Content-Language", locale)
+	}
+}
+
+func (api *APIV1) getCategories(w http.ResponseWriter, r *http.Request) {
+
+	// Parse the path and separate URL parameters
 	u, err := url.Parse(r.URL.String())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		fmt.Println("Error:", err)
 	}
 
+	// Extract URL parameters into a map
 	params := make(map[string]string)
 	queryParams := u.Query()
 	for key := range queryParams {
 		params[key] = queryParams.Get(key)
 	}
 
-	products, err := api.dbConn.GetProducts(vars, params)
+	categories, err := api.dbConn.GetCategories(params)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		// 500? 404?
+		fmt.Println("Error:", err)
 	}
 
-	jsonData, err := json.MarshalIndent(products, "", "    ")
+	jsonData, err := json.MarshalIndent(categories, "", "    ")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		fmt.Println("Error:", err)
 		return
 	}
 
+	// Set the Content-Type header to application/json
 	w.Header().Set("Content-Type", "application/json")
+
+	// Write the JSON data to the response writer
 	w.Write(jsonData)
 }
 
-func (api *APIV1) getProduct(w http.ResponseWriter, r *http.Request) {
+func (api *APIV1) getPost(w http.ResponseWriter, r *http.Request) {
+
+	// get the slug context
 	ctx := r.Context()
+
 	vars, ok := ctx.Value("vars").(map[string]string)
 	if !ok {
+		// TODO what to actually do here?
 		http.Error(w, http.StatusText(422), 422)
 		return
 	}
 
-	product, err := api.dbConn.GetProduct(vars["slug"])
+	// Parse the path and separate URL parameters
+	u, err := url.Parse(r.URL.String())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
-		return
+		fmt.Println("Error:", err)
 	}
 
-	jsonData, err := json.MarshalIndent(product, "", "    ")
+	// Extract URL parameters into a map
+	params := make(map[string]string)
+	queryParams := u.Query()
+	for key := range queryParams {
+		params[key] = queryParams.Get(key)
+	}
+
+	post, err := api.dbConn.GetSingularPost(vars, params)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		// 500? 404?
+		fmt.Println("Error:", err)
+	}
+	api.proxyPostImages(&post)
+
+	if post.ID > 0 {
+		if err := api.dbConn.TranslatePost(&post, api.requestLocales(r)); err != nil {
+			log.Printf("Error translating post %s: %v", post.Slug, err)
+		}
+	}
+	api.setContentLanguage(w, post.Locale)
+
+	jsonData, err := json.MarshalIndent(post, "", "    ")
+	if err != nil {
+		fmt.Println("Error:", err)
 		return
 	}
 
+	// Set the Content-Type header to application/json
 	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonData)
+
+	// Write the JSON data to the response writer
+	writeWithETag(w, r, jsonData)
 }
 
-func (api *APIV1) getCollectionProducts(w http.ResponseWriter, r *http.Request) {
+func (api *APIV1) getPosts(w http.ResponseWriter, r *http.Request) {
+
+	// get the slug context
 	ctx := r.Context()
 	vars, ok := ctx.Value("vars").(map[string]string)
 	if !ok {
+		// TODO what to actually do here?
 		http.Error(w, http.StatusText(422), 422)
 		return
 	}
 
+	// Parse the path and separate URL parameters
 	u, err := url.Parse(r.URL.String())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		fmt.Println("Error:", err)
 	}
 
+	// Extract URL parameters into a map
 	params := make(map[string]string)
 	queryParams := u.Query()
 	for key := range queryParams {
 		params[key] = queryParams.Get(key)
 	}
 
-	products, err := api.dbConn.GetCollectionProducts(vars["slug"], vars, params)
+	posts, err := api.dbConn.GetMultiplePosts(vars, params)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		// 500? 404?
+		fmt.Println("Error:", err)
+	}
+	for i := range posts {
+		api.proxyPostImages(&posts[i])
+	}
+
+	api.writeList(w, r, posts, len(posts), vars, func() (int, error) {
+		return api.dbConn.CountMultiplePosts(vars)
+	})
+}
+
+// E-commerce API Handlers
+
+func (api *APIV1) getCollections(w http.ResponseWriter, r *http.Request) {
+	collections, err := api.dbConn.GetCollections()
+	if err != nil {
+		api.writeListError(w, r, err)
 		return
 	}
+	for i := range collections {
+		collections[i].Image.URL = api.mediaURL(collections[i].Image.URL)
+	}
 
-	jsonData, err := json.MarshalIndent(products, "", "    ")
+	// Collections aren't paginated, so the page is the whole set
+	api.writeList(w, r, collections, len(collections), nil, nil)
+}
+
+func (api *APIV1) getCollection(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars, ok := ctx.Value("vars").(map[string]string)
+	if !ok {
+		writeAPIError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+		return
+	}
+
+	collection, err := api.dbConn.GetCollection(vars["slug"])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeLookupError(w, "collection", err)
+		return
+	}
+	collection.Image.URL = api.mediaURL(collection.Image.URL)
+
+	jsonData, err := json.MarshalIndent(collection, "", "    ")
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonData)
+	writeWithETag(w, r, jsonData)
+}
+
+func (api *APIV1) getProducts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars, ok := ctx.Value("vars").(map[string]string)
+	if !ok {
+		http.Error(w, http.StatusText(422), 422)
+		return
+	}
+
+	u, err := url.Parse(r.URL.String())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	params := make(map[string]string)
+	queryParams := u.Query()
+	for key := range queryParams {
+		params[key] = queryParams.Get(key)
+	}
+
+	products, err := api.dbConn.GetProducts(vars, params)
+	if err != nil {
+		api.writeListError(w, r, err)
+		return
+	}
+	for i := range products {
+		api.prepareProduct(&products[i])
+	}
+
+	api.writeList(w, r, products, len(products), vars, func() (int, error) {
+		return api.dbConn.CountProducts(params)
+	})
+}
+
+func (api *APIV1) getOnSaleProducts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars, ok := ctx.Value("vars").(map[string]string)
+	if !ok {
+		http.Error(w, http.StatusText(422), 422)
+		return
+	}
+
+	u, err := url.Parse(r.URL.String())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	params := make(map[string]string)
+	queryParams := u.Query()
+	for key := range queryParams {
+		params[key] = queryParams.Get(key)
+	}
+
+	products, err := api.dbConn.GetOnSaleProducts(vars, params)
+	if err != nil {
+		api.writeListError(w, r, err)
+		return
+	}
+	for i := range products {
+		api.prepareProduct(&products[i])
+	}
+
+	api.writeList(w, r, products, len(products), vars, func() (int, error) {
+		return api.dbConn.CountProducts(map[string]string{"on_sale": "true"})
+	})
+}
+
+func (api *APIV1) getProduct(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars, ok := ctx.Value("vars").(map[string]string)
+	if !ok {
+		writeAPIError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+		return
+	}
+
+	product, err := api.dbConn.GetProduct(vars["slug"])
+	if err != nil {
+		writeLookupError(w, "product", err)
+		return
+	}
+	api.prepareProduct(&product)
+
+	if err := api.dbConn.TranslateProduct(&product, api.requestLocales(r)); err != nil {
+		log.Printf("Error translating product %s: %v", product.Slug, err)
+	}
+	api.setContentLanguage(w, product.Locale)
+
+	jsonData, err := json.MarshalIndent(product, "", "    ")
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeWithETag(w, r, jsonData)
+}
+
+// getProductQuestions lists the answered questions on a product
+func (api *APIV1) getProductQuestions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars, ok := ctx.Value("vars").(map[string]string)
+	if !ok {
+		http.Error(w, http.StatusText(422), 422)
+		return
+	}
+
+	questions, err := api.dbConn.GetProductQuestions(vars["slug"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonData, err := json.MarshalIndent(questions, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeWithETag(w, r, jsonData)
+}
+
+// maxQuestionLength caps how long a product question can be
+const maxQuestionLength = 2000
+
+// askProductQuestion stores a customer's question about a product and lets the admin know. It's
+// only shown once it has been answered in the admin
+func (api *APIV1) askProductQuestion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	ctx := r.Context()
+	vars, ok := ctx.Value("vars").(map[string]string)
+	if !ok {
+		http.Error(w, http.StatusText(422), 422)
+		return
+	}
+
+	var req struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Question string `json:"question"`
+		Website  string `json:"website"` // Honeypot field
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Honeypot - if filled, it's a bot
+	if req.Website != "" {
+		log.Printf("Spam detected: question honeypot filled by %s", r.RemoteAddr)
+		http.Error(w, "Invalid submission", http.StatusBadRequest)
+		return
+	}
+
+	// Rate limiting - max 3 questions per hour per IP
+	ip := clientIP(r)
+	if !questionRateLimiter.checkRateLimit(ip) {
+		log.Printf("Question rate limit exceeded for IP: %s", ip)
+		http.Error(w, "Too many questions. Please try again later.", http.StatusTooManyRequests)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	req.Email = strings.TrimSpace(req.Email)
+	req.Question = strings.TrimSpace(req.Question)
+
+	if req.Name == "" || req.Email == "" || req.Question == "" {
+		http.Error(w, "Name, email, and question are required", http.StatusBadRequest)
+		return
+	}
+	if !strings.Contains(req.Email, "@") {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+	if len(req.Question) > maxQuestionLength {
+		http.Error(w, fmt.Sprintf("Questions can be at most %d characters", maxQuestionLength), http.StatusBadRequest)
+		return
+	}
+
+	product, err := api.dbConn.CreateProductQuestion(vars["slug"], req.Name, req.Email, req.Question)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving product question: %v", err)
+		http.Error(w, "Failed to submit question", http.StatusInternalServerError)
+		return
+	}
+
+	emailService, err := email.NewEmailService()
+	if err == nil {
+		err = emailService.SendAdminQuestionNotification(api.websiteConfig, product.Name, req.Name, req.Email, req.Question)
+	}
+	if err != nil {
+		// The question is saved and shows up in the admin either way
+		log.Printf("Failed to send question notification email: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Thanks for your question! We'll answer it soon.",
+	})
+}
+
+func (api *APIV1) getCollectionProducts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars, ok := ctx.Value("vars").(map[string]string)
+	if !ok {
+		http.Error(w, http.StatusText(422), 422)
+		return
+	}
+
+	u, err := url.Parse(r.URL.String())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	params := make(map[string]string)
+	queryParams := u.Query()
+	for key := range queryParams {
+		params[key] = queryParams.Get(key)
+	}
+
+	products, err := api.dbConn.GetCollectionProducts(vars["slug"], vars, params)
+	if err != nil {
+		api.writeListError(w, r, err)
+		return
+	}
+	for i := range products {
+		api.prepareProduct(&products[i])
+	}
+
+	api.writeList(w, r, products, len(products), vars, func() (int, error) {
+		return api.dbConn.CountCollectionProducts(vars["slug"])
+	})
 }
 
 func (api *APIV1) getCart(w http.ResponseWriter, r *http.Request) {
@@ -707,105 +1722,1276 @@ func (api *APIV1) getCart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	jsonData, err := json.MarshalIndent(cart, "", "    ")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	jsonData, err := json.MarshalIndent(cart, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+func (api *APIV1) getCartDetailed(w http.ResponseWriter, r *http.Request) {
+	sessionID := session.GetCartSession(r)
+	if sessionID == "" {
+		emptyCart := structs.Cart{
+			Items:    []structs.CartItem{},
+			Subtotal: 0,
+		}
+		jsonData, _ := json.MarshalIndent(emptyCart, "", "    ")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonData)
+		return
+	}
+
+	cart, err := api.dbConn.GetCartDetailed(sessionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonData, err := json.MarshalIndent(cart, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+func (api *APIV1) addToCart(w http.ResponseWriter, r *http.Request) {
+	sessionID := session.GetOrCreateCartSession(r, w)
+
+	var reqBody struct {
+		ProductID int `json:"product_id"`
+		VariantID int `json:"variant_id"`
+		Quantity  int `json:"quantity"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate quantity, the upper bound is the purchase limit (see database.CheckMaxPerOrder)
+	if reqBody.Quantity < 1 {
+		http.Error(w, "Quantity must be at least 1", http.StatusBadRequest)
+		return
+	}
+
+	err = api.dbConn.AddToCart(sessionID, reqBody.ProductID, reqBody.VariantID, reqBody.Quantity)
+	if errors.Is(err, database.ErrProductNotFound) || errors.Is(err, database.ErrVariantMismatch) || errors.Is(err, database.ErrVariantRequired) || errors.Is(err, database.ErrMaxPerOrderExceeded) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if errors.Is(err, database.ErrInsufficientStock) || errors.Is(err, database.ErrBundleComponentMissing) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cart, err := api.dbConn.GetCart(sessionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonData, err := json.MarshalIndent(cart, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+func (api *APIV1) updateCartItem(w http.ResponseWriter, r *http.Request) {
+	itemIDStr := chi.URLParam(r, "itemId")
+	itemID, err := strconv.Atoi(itemIDStr)
+	if err != nil {
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return
+	}
+
+	var reqBody struct {
+		Quantity int `json:"quantity"`
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate quantity, the upper bound is the purchase limit (see database.CheckMaxPerOrder)
+	if reqBody.Quantity < 1 {
+		http.Error(w, "Quantity must be at least 1", http.StatusBadRequest)
+		return
+	}
+
+	err = api.dbConn.UpdateCartItem(itemID, reqBody.Quantity)
+	if errors.Is(err, database.ErrMaxPerOrderExceeded) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sessionID := session.GetCartSession(r)
+	cart, err := api.dbConn.GetCart(sessionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonData, err := json.MarshalIndent(cart, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+func (api *APIV1) removeFromCart(w http.ResponseWriter, r *http.Request) {
+	itemIDStr := chi.URLParam(r, "itemId")
+	itemID, err := strconv.Atoi(itemIDStr)
+	if err != nil {
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return
+	}
+
+	err = api.dbConn.RemoveFromCart(itemID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sessionID := session.GetCartSession(r)
+	cart, err := api.dbConn.GetCart(sessionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonData, err := json.MarshalIndent(cart, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+// writeWishlist responds with the session's wishlist. It's per shopper, so it's never cached
+func (api *APIV1) writeWishlist(w http.ResponseWriter, sessionID string) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	wishlist := structs.Wishlist{Items: []structs.WishlistItem{}}
+	if sessionID != "" {
+		var err error
+		wishlist, err = api.dbConn.GetWishlist(sessionID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i := range wishlist.Items {
+			api.prepareProduct(&wishlist.Items[i].Product)
+		}
+	}
+
+	jsonData, err := json.MarshalIndent(wishlist, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+func (api *APIV1) getWishlist(w http.ResponseWriter, r *http.Request) {
+	api.writeWishlist(w, session.GetCartSession(r))
+}
+
+func (api *APIV1) addToWishlist(w http.ResponseWriter, r *http.Request) {
+	sessionID := session.GetOrCreateCartSession(r, w)
+
+	var reqBody struct {
+		ProductID int `json:"product_id"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err = api.dbConn.AddToWishlist(sessionID, reqBody.ProductID)
+	if errors.Is(err, database.ErrProductNotFound) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if errors.Is(err, database.ErrWishlistFull) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	api.writeWishlist(w, sessionID)
+}
+
+func (api *APIV1) removeFromWishlist(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	sessionID := session.GetCartSession(r)
+	if sessionID != "" {
+		if err := api.dbConn.RemoveFromWishlist(sessionID, productID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	api.writeWishlist(w, sessionID)
+}
+
+// recentlyViewedLimit is how many products are remembered per session
+func (api *APIV1) recentlyViewedLimit() int {
+	if limit := api.websiteConfig.Ecommerce.RecentlyViewedLimit; limit > 0 {
+		return limit
+	}
+	return database.DefaultRecentlyViewedLimit
+}
+
+func (api *APIV1) recordProductView(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	var reqBody struct {
+		ProductID int `json:"product_id"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil || reqBody.ProductID <= 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	sessionID := session.GetOrCreateCartSession(r, w)
+	if err := api.dbConn.RecordProductView(sessionID, reqBody.ProductID, api.recentlyViewedLimit()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *APIV1) getRecentlyViewed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	limit := api.recentlyViewedLimit()
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n < limit {
+		limit = n
+	}
+	exclude, _ := strconv.Atoi(r.URL.Query().Get("exclude"))
+
+	products := []structs.Product{}
+	if sessionID := session.GetCartSession(r); sessionID != "" {
+		var err error
+		products, err = api.dbConn.GetRecentlyViewedProducts(sessionID, exclude, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i := range products {
+			api.prepareProduct(&products[i])
+		}
+	}
+
+	jsonData, err := json.MarshalIndent(products, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+// mergeWishlist hands the session's wishlist to the customer once checkout has identified them.
+// A failure here never fails the checkout
+func (api *APIV1) mergeWishlist(sessionID string, customerID int) {
+	if sessionID == "" || customerID == 0 {
+		return
+	}
+	if err := api.dbConn.MergeWishlist(sessionID, customerID); err != nil {
+		log.Printf("Warning: failed to merge wishlist into customer %d: %v", customerID, err)
+	}
+}
+
+func (api *APIV1) createOrder(w http.ResponseWriter, r *http.Request) {
+	sessionID := session.GetCartSession(r)
+	if sessionID == "" {
+		http.Error(w, "No cart session found", http.StatusBadRequest)
+		return
+	}
+
+	cart, err := api.dbConn.GetCart(sessionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(cart.Items) == 0 {
+		http.Error(w, "Cart is empty", http.StatusBadRequest)
+		return
+	}
+
+	if !api.checkMinOrderAmount(w, cart.Subtotal) {
+		return
+	}
+
+	if !api.checkMaxPerOrder(w, cart) {
+		return
+	}
+	if !api.checkBundleStock(w, cart) {
+		return
+	}
+
+	if !api.rejectSubscriptionItems(w, cart) {
+		return
+	}
+
+	var orderData map[string]interface{}
+	err = json.NewDecoder(r.Body).Decode(&orderData)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !api.checkAddressValidated(w, orderData) {
+		return
+	}
+
+	orderData["cart_items"] = cart.Items
+
+	// Get tax rate and shipping cost from config (0 is valid)
+	orderData["tax_rate"] = api.websiteConfig.Ecommerce.TaxRate
+	orderData["tax_inclusive"] = api.websiteConfig.Ecommerce.TaxInclusive
+	orderData["tax_rounding"] = api.websiteConfig.Ecommerce.TaxRounding
+	orderData["shipping_cost"] = api.websiteConfig.Ecommerce.ShippingCost
+	orderData["order_number_prefix"] = api.websiteConfig.Ecommerce.OrderNumberPrefix
+	api.ScoreOrderRisk(orderData)
+
+	// Store credit and payment status are never taken from the body. Credit for a card payment was
+	// held when its PaymentIntent was created and CreateOrder spends that hold, so without one the
+	// credit has to cover the whole order, or the rest would never be charged
+	delete(orderData, "store_credit")
+	delete(orderData, "payment_status")
+	_, total := api.orderTotals(cart.Subtotal)
+	if paymentIntentID, _ := orderData["payment_intent_id"].(string); paymentIntentID != "" {
+		if !api.checkPaymentIntent(w, paymentIntentID, total) {
+			return
+		}
+	} else {
+		storeCredit, ok := api.storeCreditFor(w, orderData, total)
+		if !ok {
+			return
+		}
+		if storeCredit > 0 && utils.ToCents(storeCredit, "") < utils.ToCents(total, "") {
+			http.Error(w, database.ErrPartialStoreCredit.Error(), http.StatusBadRequest)
+			return
+		}
+		orderData["store_credit"] = storeCredit
+	}
+
+	// A retry of a checkout that already went through gets a 409 rather than a second order
+	order, err := api.dbConn.CreateOrder(orderData)
+	if errors.Is(err, database.ErrInsufficientStoreCredit) || errors.Is(err, database.ErrDuplicateOrder) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if errors.Is(err, database.ErrPartialStoreCredit) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if order.CustomerID != nil {
+		api.mergeWishlist(sessionID, *order.CustomerID)
+	}
+
+	session.ClearCartSession(w)
+
+	// The thank-you page exchanges this for the order once, rather than looking it up by number
+	response := struct {
+		structs.Order
+		ConfirmationToken string `json:"confirmation_token,omitempty"`
+	}{Order: order}
+	ttl := api.orderConfirmTTL()
+	if token, err := api.dbConn.CreateOrderConfirmation(order.ID, sessionID, ttl); err != nil {
+		log.Printf("Warning: failed to create confirmation token for order %s: %v", order.OrderNumber, err)
+	} else {
+		response.ConfirmationToken = token
+		session.SetOrderConfirmSession(w, sessionID, int(ttl.Seconds()))
+	}
+
+	jsonData, err := json.MarshalIndent(response, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(jsonData)
+}
+
+// orderConfirmTTL is how long createOrder's confirmation token stays redeemable
+func (api *APIV1) orderConfirmTTL() time.Duration {
+	if minutes := api.websiteConfig.Ecommerce.OrderConfirmMinutes; minutes > 0 {
+		return time.Duration(minutes) * time.Minute
+	}
+	return database.DefaultOrderConfirmTTL
+}
+
+// confirmOrder exchanges the one-time token from createOrder for the order's details. It only works
+// once, before the token expires, and from the browser that placed the order
+func (api *APIV1) confirmOrder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars, ok := ctx.Value("vars").(map[string]string)
+	if !ok {
+		http.Error(w, http.StatusText(422), 422)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	sessionID := session.GetOrderConfirmSession(r)
+	if sessionID == "" {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	order, err := api.dbConn.RedeemOrderConfirmation(vars["token"], sessionID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Warning: failed to redeem order confirmation: %v", err)
+		}
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	jsonData, err := json.MarshalIndent(order, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+// getOrder looks an order up by number for the customer who placed it, so the email it was placed
+// with has to be given too. Order numbers are sequential and easy to guess on their own
+func (api *APIV1) getOrder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars, ok := ctx.Value("vars").(map[string]string)
+	if !ok {
+		writeAPIError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	// Same response for a wrong email as for a missing order, so order numbers can't be probed
+	email := strings.TrimSpace(r.URL.Query().Get("email"))
+	order, err := api.dbConn.GetOrder(vars["orderNumber"])
+	if err != nil {
+		writeLookupError(w, "order", err)
+		return
+	}
+	if email == "" || !strings.EqualFold(order.CustomerEmail, email) {
+		writeAPIError(w, http.StatusNotFound, "Order not found")
+		return
+	}
+
+	jsonData, err := json.MarshalIndent(order, "", "    ")
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+// getOrderReturns lists the returns on an order, for the customer who placed it
+func (api *APIV1) getOrderReturns(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars, ok := ctx.Value("vars").(map[string]string)
+	if !ok {
+		http.Error(w, http.StatusText(422), 422)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	email := strings.TrimSpace(r.URL.Query().Get("email"))
+	order, err := api.dbConn.GetOrder(vars["orderNumber"])
+	if err != nil {
+		writeLookupError(w, "order", err)
+		return
+	}
+	if email == "" || !strings.EqualFold(order.CustomerEmail, email) {
+		writeAPIError(w, http.StatusNotFound, "Order not found")
+		return
+	}
+
+	returns, err := api.dbConn.GetOrderReturns(order)
+	if err != nil {
+		log.Printf("Error loading returns for order %s: %v", order.OrderNumber, err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to load returns")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(returns)
+}
+
+// requestReturn lets a customer ask to return items from a delivered order. The store approves
+// or rejects it in the admin
+func (api *APIV1) requestReturn(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	ctx := r.Context()
+	vars, ok := ctx.Value("vars").(map[string]string)
+	if !ok {
+		http.Error(w, http.StatusText(422), 422)
+		return
+	}
+
+	var req struct {
+		Email  string                    `json:"email"`
+		Reason string                    `json:"reason"`
+		Items  []structs.OrderReturnItem `json:"items"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	ip := clientIP(r)
+	if !returnRateLimiter.checkRateLimit(ip) {
+		log.Printf("Return request rate limit exceeded for IP: %s", ip)
+		writeAPIError(w, http.StatusTooManyRequests, "Too many return requests. Please try again later.")
+		return
+	}
+
+	customerEmail := strings.TrimSpace(req.Email)
+	order, err := api.dbConn.GetOrder(vars["orderNumber"])
+	if err != nil {
+		writeLookupError(w, "order", err)
+		return
+	}
+	if customerEmail == "" || !strings.EqualFold(order.CustomerEmail, customerEmail) {
+		writeAPIError(w, http.StatusNotFound, "Order not found")
+		return
+	}
+
+	orderReturn, err := api.dbConn.CreateReturn(order, req.Reason, req.Items)
+	switch {
+	case errors.Is(err, database.ErrReturnNotDelivered):
+		writeAPIError(w, http.StatusConflict, err.Error())
+		return
+	case errors.Is(err, database.ErrReturnReason), errors.Is(err, database.ErrReturnNoItems),
+		errors.Is(err, database.ErrReturnItemNotFound), errors.Is(err, database.ErrReturnQuantityLimit):
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		log.Printf("Error creating return for order %s: %v", order.OrderNumber, err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to request return")
+		return
+	}
+
+	emailService, err := email.NewEmailService()
+	if err == nil {
+		err = emailService.SendReturnUpdate(api.websiteConfig, order.OrderNumber, order.CustomerEmail, order.CustomerName,
+			orderReturn.ID, orderReturn.Status, "", 0)
+	}
+	if err != nil {
+		// The return is saved and shows up in the admin either way
+		log.Printf("Failed to send return requested email: %v", err)
+	}
+	api.sendWebhookEvent("return.requested", orderReturn)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(orderReturn)
+}
+
+func (api *APIV1) NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Create the error response struct
+	errResponse := ErrorResponse{
+		StatusCode:  404,
+		ErrorString: "endpoint not found",
+	}
+
+	// Convert the struct to JSON
+	jsonData, err := json.Marshal(errResponse)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
+		return
+	}
+
+	// Set the Content-Type header to application/json
+	w.Header().Set("Content-Type", "application/json")
+	// Set the status code for the response
+	w.WriteHeader(errResponse.StatusCode)
+	// Write the JSON data to the response writer
+	w.Write(jsonData)
+}
+
+// orderTotals works out the tax and total for a cart subtotal with the site's tax rate, shipping
+// cost and tax-inclusive setting
+func (api *APIV1) orderTotals(subtotal float64) (tax, total float64) {
+	ecommerce := api.websiteConfig.Ecommerce
+	return database.OrderTotals(subtotal, ecommerce.TaxRate, ecommerce.ShippingCost, ecommerce.TaxInclusive, ecommerce.TaxRounding)
+}
+
+// storeCreditFor returns how much of total the store credit named in a checkout body covers: none
+// without a store_credit_code, otherwise the balance of the customer with the body's email, up to
+// the total. Writes a 400 and returns false when the code isn't theirs
+func (api *APIV1) storeCreditFor(w http.ResponseWriter, body map[string]interface{}, total float64) (float64, bool) {
+	code, _ := body["store_credit_code"].(string)
+	if code == "" {
+		return 0, true
+	}
+	email, _ := body["email"].(string)
+	api.releaseStaleStoreCredit(email)
+
+	balance, err := api.dbConn.AvailableStoreCredit(email, code)
+	if errors.Is(err, database.ErrStoreCreditCode) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return 0, false
+	}
+	return math.Min(balance, total), true
+}
+
+// checkPaymentIntent makes sure the PaymentIntent an order is placed with is one of ours for this
+// order: in the site's currency, for the total less the store credit held for it, and not canceled.
+// An intent with credit held has to be paid or authorized, or the credit would go to an order
+// nobody pays for. Writes an error and returns false otherwise
+func (api *APIV1) checkPaymentIntent(w http.ResponseWriter, paymentIntentID string, total float64) bool {
+	held, err := api.dbConn.StoreCreditHeld(paymentIntentID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	stripe.Key = api.websiteConfig.Stripe.SecretKey
+	pi, err := paymentintent.Get(paymentIntentID, nil)
+	if err != nil {
+		log.Printf("Warning: failed to get payment intent %s for checkout: %v", paymentIntentID, err)
+		http.Error(w, "Invalid payment intent", http.StatusBadRequest)
+		return false
+	}
+
+	amountDue := float64(utils.ToCents(total, "")-utils.ToCents(held, "")) / 100
+	currency := api.stripeCurrency()
+	if !strings.EqualFold(string(pi.Currency), currency) || pi.Amount != utils.StripeAmount(amountDue, currency) {
+		log.Printf("Warning: payment intent %s is for %d %s but the order comes to %d %s", paymentIntentID, pi.Amount, pi.Currency, utils.StripeAmount(amountDue, currency), currency)
+		http.Error(w, "Payment intent doesn't match the order total", http.StatusBadRequest)
+		return false
+	}
+
+	switch pi.Status {
+	case stripe.PaymentIntentStatusSucceeded, stripe.PaymentIntentStatusRequiresCapture, stripe.PaymentIntentStatusProcessing:
+	case stripe.PaymentIntentStatusCanceled:
+		http.Error(w, "Payment intent was canceled", http.StatusBadRequest)
+		return false
+	default:
+		if held > 0 {
+			http.Error(w, "Payment intent hasn't been paid", http.StatusPaymentRequired)
+			return false
+		}
+	}
+	return true
+}
+
+// storeCreditHoldTTL is how long store credit stays held for a PaymentIntent that hasn't become an
+// order, see releaseStaleStoreCredit
+const storeCreditHoldTTL = 24 * time.Hour
+
+// releaseStaleStoreCredit gives back store credit held for PaymentIntents that won't become orders:
+// the customer's earlier ones, now they're checking out again, and any that outlived
+// storeCreditHoldTTL. Each unpaid intent is canceled first so it can't be paid without its credit;
+// paid and authorized ones keep their hold for /checkout
+func (api *APIV1) releaseStaleStoreCredit(email string) {
+	paymentIntentIDs, err := api.dbConn.StaleStoreCreditHolds(email)
+	if err != nil {
+		log.Printf("Warning: failed to list stale store credit holds: %v", err)
+		return
+	}
+	if len(paymentIntentIDs) > 0 {
+		stripe.Key = api.websiteConfig.Stripe.SecretKey
+	}
+
+	for _, id := range paymentIntentIDs {
+		pi, err := paymentintent.Get(id, nil)
+		if err != nil {
+			log.Printf("Warning: keeping store credit held for payment intent %s, it couldn't be fetched: %v", id, err)
+			continue
+		}
+		switch pi.Status {
+		case stripe.PaymentIntentStatusCanceled:
+		case stripe.PaymentIntentStatusRequiresPaymentMethod, stripe.PaymentIntentStatusRequiresConfirmation, stripe.PaymentIntentStatusRequiresAction:
+			if _, err := paymentintent.Cancel(id, nil); err != nil {
+				log.Printf("Warning: keeping store credit held for payment intent %s, it couldn't be canceled: %v", id, err)
+				continue
+			}
+		default:
+			// Paid or authorized, so /checkout spends the hold
+			continue
+		}
+		if _, err := api.dbConn.ReleaseStoreCreditHold(id); err != nil {
+			log.Printf("Warning: failed to release store credit held for payment intent %s: %v", id, err)
+		}
+	}
+}
+
+// setProductTax marks a product's price as tax-inclusive, with the tax it contains, when the site
+// shows prices that way
+func (api *APIV1) setProductTax(product *structs.Product) {
+	if !api.websiteConfig.Ecommerce.TaxInclusive {
+		return
+	}
+	product.PriceIncludesTax = true
+	product.IncludedTax = database.IncludedTax(product.Price, api.websiteConfig.Ecommerce.TaxRate, api.websiteConfig.Ecommerce.TaxRounding)
+}
+
+// getChanges lists the products, articles and collections changed or deleted since ?since=, an
+// RFC 3339 time or Unix seconds. Without it everything published is listed. Clients poll again with
+// next_since, and revalidate with the ETag since an unchanged feed gives the same body
+func (api *APIV1) getChanges(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if value := r.URL.Query().Get("since"); value != "" {
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+			since = time.Unix(seconds, 0)
+		} else if since, err = time.Parse(time.RFC3339, value); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "since must be an RFC 3339 time or Unix seconds")
+			return
+		}
+	}
+
+	changes, err := api.dbConn.GetContentChanges(since)
+	if err != nil {
+		log.Printf("Error listing content changes: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to list changes")
+		return
+	}
+
+	var jsonData []byte
+	if api.indentJSON(r) {
+		jsonData, err = json.MarshalIndent(changes, "", "    ")
+	} else {
+		jsonData, err = json.Marshal(changes)
+	}
+	if err != nil {
+		log.Printf("Error encoding content changes: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to list changes")
+		return
+	}
+
+	// Shared caches would serve old feeds, so make clients revalidate every poll
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "application/json")
+	writeWithETag(w, r, jsonData)
+}
+
+// getConfig returns public configuration (like Stripe publishable key)
+func (api *APIV1) getConfig(w http.ResponseWriter, r *http.Request) {
+	// Get Stripe publishable key from site config
+	publishableKey := api.websiteConfig.Stripe.PublishableKey
+
+	// Get tax rate and shipping cost from config (0 is valid)
+	taxRate := api.websiteConfig.Ecommerce.TaxRate
+	shippingCost := api.websiteConfig.Ecommerce.ShippingCost
+	minOrderAmount := api.websiteConfig.Ecommerce.MinOrderAmount
+
+	response := map[string]interface{}{
+		"stripePublishableKey":     publishableKey,
+		"taxRate":                  taxRate,
+		"taxInclusive":             api.websiteConfig.Ecommerce.TaxInclusive,
+		"shippingCost":             shippingCost,
+		"minOrderAmount":           minOrderAmount,
+		"requireAddressValidation": api.websiteConfig.Ecommerce.RequireAddressValidation,
+	}
+
+	jsonData, err := json.MarshalIndent(response, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+// checkMinOrderAmount writes a 400 and returns false if the subtotal is below the configured minimum
+func (api *APIV1) checkMinOrderAmount(w http.ResponseWriter, subtotal float64) bool {
+	minOrderAmount := api.websiteConfig.Ecommerce.MinOrderAmount
+	if minOrderAmount <= 0 || utils.ToCents(subtotal, "") >= utils.ToCents(minOrderAmount, "") {
+		return true
+	}
+
+	currency := api.websiteConfig.Ecommerce.Currency
+	http.Error(w, fmt.Sprintf("Minimum order amount is %s - add %s more to check out", utils.FormatMoney(minOrderAmount, currency), utils.FormatMoney(minOrderAmount-subtotal, currency)), http.StatusBadRequest)
+	return false
+}
+
+// checkMaxPerOrder writes a 400 and returns false if any cart line exceeds its purchase limit, or
+// the lines of a product together exceed the product's
+func (api *APIV1) checkMaxPerOrder(w http.ResponseWriter, cart structs.Cart) bool {
+	productQuantities := make(map[int]int)
+	for _, item := range cart.Items {
+		productQuantities[item.ProductID] += item.Quantity
+	}
+
+	for _, item := range cart.Items {
+		err := api.dbConn.CheckMaxPerOrder(item.ProductID, item.VariantID, item.Quantity, productQuantities[item.ProductID])
+		if errors.Is(err, database.ErrMaxPerOrderExceeded) {
+			http.Error(w, fmt.Sprintf("%s: %v", item.Product.Name, err), http.StatusBadRequest)
+			return false
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return false
+		}
+	}
+	return true
+}
+
+// checkBundleStock writes a 409 and returns false if a bundle in the cart can't be made up from
+// its components' stock
+func (api *APIV1) checkBundleStock(w http.ResponseWriter, cart structs.Cart) bool {
+	err := api.dbConn.CheckBundleStock(cart.Items)
+	if errors.Is(err, database.ErrInsufficientStock) || errors.Is(err, database.ErrBundleComponentMissing) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// getOrCreateStripeCustomer gets or creates the local customer from a checkout request body
+// (email + shipping_address names) and links it to a Stripe customer. Both are zero values if
+// the body doesn't identify a customer or Stripe fails. stripe.Key must already be set.
+func (api *APIV1) getOrCreateStripeCustomer(requestBody map[string]interface{}) (structs.Customer, string) {
+	if requestBody == nil {
+		return structs.Customer{}, ""
+	}
+
+	email, _ := requestBody["email"].(string)
+	shippingAddr, _ := requestBody["shipping_address"].(map[string]interface{})
+	firstName, _ := shippingAddr["first_name"].(string)
+	lastName, _ := shippingAddr["last_name"].(string)
+	if email == "" || firstName == "" || lastName == "" {
+		return structs.Customer{}, ""
+	}
+
+	// Get or create local customer record
+	cust, err := api.dbConn.GetOrCreateCustomer(email, firstName, lastName)
+	if err != nil {
+		return structs.Customer{}, ""
+	}
+
+	// Check if customer already has Stripe ID
+	if cust.StripeCustomerID != "" {
+		return cust, cust.StripeCustomerID
+	}
+
+	// Create Stripe customer
+	stripeParams := &stripe.CustomerParams{
+		Email: stripe.String(email),
+		Name:  stripe.String(firstName + " " + lastName),
+	}
+	stripeCust, err := customer.New(stripeParams)
+	if err != nil || stripeCust == nil {
+		return cust, ""
+	}
+
+	// Update local customer record with Stripe ID
+	api.dbConn.UpdateCustomerStripeID(cust.ID, stripeCust.ID)
+	cust.StripeCustomerID = stripeCust.ID
+	return cust, stripeCust.ID
+}
+
+// rejectSubscriptionItems writes a 400 and returns false if the cart contains subscription products,
+// which must go through /api/v1/create-subscription instead of a one-time payment
+func (api *APIV1) rejectSubscriptionItems(w http.ResponseWriter, cart structs.Cart) bool {
+	for _, item := range cart.Items {
+		if item.Product.SubscriptionInterval != "" {
+			http.Error(w, fmt.Sprintf("%s is a subscription - check out subscriptions separately", item.Product.Name), http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+// createPaymentIntent creates a Stripe payment intent for the cart
+func (api *APIV1) createPaymentIntent(w http.ResponseWriter, r *http.Request) {
+	sessionID := session.GetCartSession(r)
+	if sessionID == "" {
+		http.Error(w, "No cart session found", http.StatusBadRequest)
+		return
+	}
+
+	cart, err := api.dbConn.GetCart(sessionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(cart.Items) == 0 {
+		http.Error(w, "Cart is empty", http.StatusBadRequest)
+		return
+	}
+
+	if !api.checkMinOrderAmount(w, cart.Subtotal) {
+		return
+	}
+
+	if !api.checkMaxPerOrder(w, cart) {
+		return
+	}
+	if !api.checkBundleStock(w, cart) {
+		return
+	}
+
+	if !api.rejectSubscriptionItems(w, cart) {
+		return
+	}
+
+	// Parse request body to extract customer email and shipping address
+	var requestBody map[string]interface{}
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err == nil {
+		json.Unmarshal(bodyBytes, &requestBody)
+		// Restore body for potential future reads
+		r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	}
+
+	// Calculate total (subtotal + tax + shipping, or subtotal + shipping when prices include tax)
+	subtotal := cart.Subtotal
+	tax, total := api.orderTotals(subtotal)
+
+	// Get Stripe secret key from site config
+	stripeKey := api.websiteConfig.Stripe.SecretKey
+	if stripeKey == "" {
+		http.Error(w, "Stripe not configured", http.StatusInternalServerError)
+		return
+	}
+
+	// Set Stripe API key
+	stripe.Key = stripeKey
+
+	// Try to get/create customer and link to Stripe
+	cust, stripeCustomerID := api.getOrCreateStripeCustomer(requestBody)
+	api.mergeWishlist(sessionID, cust.ID)
+
+	// Store credit comes off the charge. It's held with the PaymentIntent and spent when /checkout
+	// creates the order
+	storeCredit, ok := api.storeCreditFor(w, requestBody, total)
+	if !ok {
+		return
+	}
+	amountDueCents := utils.ToCents(total, "") - utils.ToCents(storeCredit, "")
+	amountDue := float64(amountDueCents) / 100
+	if amountDueCents <= 0 {
+		// Nothing to charge, the order is placed with /checkout directly
+		jsonData, err := json.MarshalIndent(map[string]interface{}{
+			"clientSecret": "",
+			"amount":       0,
+			"subtotal":     subtotal,
+			"tax":          tax,
+			"shipping":     api.websiteConfig.Ecommerce.ShippingCost,
+			"storeCredit":  storeCredit,
+		}, "", "    ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonData)
+		return
+	}
+
+	// Create payment intent
+	params := &stripe.PaymentIntentParams{
+		Amount:             stripe.Int64(utils.StripeAmount(amountDue, api.stripeCurrency())),
+		Currency:           stripe.String(api.stripeCurrency()),
+		PaymentMethodTypes: stripe.StringSlice([]string{"card", "link"}), // Card payments, Apple Pay, Google Pay, and Link
+	}
+
+	// Link to Stripe customer if we have one
+	if stripeCustomerID != "" {
+		params.Customer = stripe.String(stripeCustomerID)
+	}
+
+	// Authorize only - the admin captures the payment when the order ships
+	if api.websiteConfig.Ecommerce.ManualCapture {
+		params.CaptureMethod = stripe.String(string(stripe.PaymentIntentCaptureMethodManual))
+	}
+
+	if storeCredit > 0 {
+		params.AddMetadata("store_credit", strconv.FormatFloat(storeCredit, 'f', 2, 64))
+	}
+
+	pi, err := paymentintent.New(params)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create payment intent: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Set the credit aside now the charge leaves it out. If it's gone, the intent goes too
+	if storeCredit > 0 {
+		email, _ := requestBody["email"].(string)
+		code, _ := requestBody["store_credit_code"].(string)
+		if err := api.dbConn.HoldStoreCredit(email, code, pi.ID, storeCredit, storeCreditHoldTTL); err != nil {
+			if _, cancelErr := paymentintent.Cancel(pi.ID, nil); cancelErr != nil {
+				log.Printf("Warning: failed to cancel payment intent %s after its store credit hold failed: %v", pi.ID, cancelErr)
+			}
+			switch {
+			case errors.Is(err, database.ErrInsufficientStoreCredit):
+				http.Error(w, err.Error(), http.StatusConflict)
+			case errors.Is(err, database.ErrStoreCreditCode):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
+	response := map[string]interface{}{
+		"clientSecret": pi.ClientSecret,
+		"amount":       amountDue,
+		"subtotal":     subtotal,
+		"tax":          tax,
+		"shipping":     api.websiteConfig.Ecommerce.ShippingCost,
+		"storeCredit":  storeCredit,
+	}
+
+	jsonData, err := json.MarshalIndent(response, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+// stripeCurrency is the site's currency the way Stripe takes it, e.g. "usd"
+func (api *APIV1) stripeCurrency() string {
+	return strings.ToLower(utils.NormalizeCurrency(api.websiteConfig.Ecommerce.Currency))
+}
+
+// shippingCountries are the countries hosted Checkout collects shipping addresses for:
+// ecommerce.shippingCountries, or the country the site ships from, or the US
+func (api *APIV1) shippingCountries() []string {
+	var countries []string
+	for _, country := range api.websiteConfig.Ecommerce.ShippingCountries {
+		if country = utils.NormalizeCountry(country); country != "" {
+			countries = append(countries, country)
+		}
+	}
+	if len(countries) > 0 {
+		return countries
+	}
+	if country := utils.NormalizeCountry(api.websiteConfig.ShipFrom.Country); country != "" {
+		return []string{country}
+	}
+	return []string{"US"}
+}
+
+// createCheckoutSession creates a hosted Stripe Checkout session for the cart and returns its URL.
+// The cart is saved with the session, and the order is created from that copy when the
+// checkout.session.completed webhook arrives.
+func (api *APIV1) createCheckoutSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := session.GetCartSession(r)
+	if sessionID == "" {
+		http.Error(w, "No cart session found", http.StatusBadRequest)
+		return
+	}
+
+	cart, err := api.dbConn.GetCart(sessionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(cart.Items) == 0 {
+		http.Error(w, "Cart is empty", http.StatusBadRequest)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonData)
-}
-
-func (api *APIV1) addToCart(w http.ResponseWriter, r *http.Request) {
-	sessionID := session.GetOrCreateCartSession(r, w)
-
-	var reqBody struct {
-		ProductID int `json:"product_id"`
-		VariantID int `json:"variant_id"`
-		Quantity  int `json:"quantity"`
-	}
-
-	err := json.NewDecoder(r.Body).Decode(&reqBody)
-	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !api.checkMinOrderAmount(w, cart.Subtotal) {
 		return
 	}
 
-	// Validate quantity (1-100)
-	if reqBody.Quantity < 1 {
-		http.Error(w, "Quantity must be at least 1", http.StatusBadRequest)
+	if !api.checkMaxPerOrder(w, cart) {
 		return
 	}
-	if reqBody.Quantity > 100 {
-		http.Error(w, "Quantity cannot exceed 100", http.StatusBadRequest)
+	if !api.checkBundleStock(w, cart) {
 		return
 	}
 
-	err = api.dbConn.AddToCart(sessionID, reqBody.ProductID, reqBody.VariantID, reqBody.Quantity)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !api.rejectSubscriptionItems(w, cart) {
 		return
 	}
 
-	cart, err := api.dbConn.GetCart(sessionID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	stripeKey := api.websiteConfig.Stripe.SecretKey
+	if stripeKey == "" {
+		http.Error(w, "Stripe not configured", http.StatusInternalServerError)
 		return
 	}
+	stripe.Key = stripeKey
 
-	jsonData, err := json.MarshalIndent(cart, "", "    ")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	// Optional return paths, relative to the site so they can't redirect elsewhere
+	var requestBody struct {
+		SuccessPath string `json:"success_path"`
+		CancelPath  string `json:"cancel_path"`
 	}
+	json.NewDecoder(r.Body).Decode(&requestBody)
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonData)
-}
-
-func (api *APIV1) updateCartItem(w http.ResponseWriter, r *http.Request) {
-	itemIDStr := chi.URLParam(r, "itemId")
-	itemID, err := strconv.Atoi(itemIDStr)
-	if err != nil {
-		http.Error(w, "Invalid item ID", http.StatusBadRequest)
-		return
+	successPath := "/checkout/success?session_id={CHECKOUT_SESSION_ID}"
+	if strings.HasPrefix(requestBody.SuccessPath, "/") && !strings.HasPrefix(requestBody.SuccessPath, "//") {
+		successPath = requestBody.SuccessPath
 	}
-
-	var reqBody struct {
-		Quantity int `json:"quantity"`
+	cancelPath := "/cart"
+	if strings.HasPrefix(requestBody.CancelPath, "/") && !strings.HasPrefix(requestBody.CancelPath, "//") {
+		cancelPath = requestBody.CancelPath
 	}
+	baseURL := "https://" + api.websiteConfig.SiteName
+	currency := api.stripeCurrency()
 
-	err = json.NewDecoder(r.Body).Decode(&reqBody)
-	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+	// amountTotal adds up what Stripe will charge, to check against the session when it completes
+	var amountTotal int64
+	lineItems := []*stripe.CheckoutSessionLineItemParams{}
+	for _, item := range cart.Items {
+		name := item.Product.Name
+		if item.Variant.Title != "" {
+			name += " - " + item.Variant.Title
+		}
+		unitAmount := utils.StripeAmount(item.Price, currency)
+		amountTotal += unitAmount * int64(item.Quantity)
+		lineItems = append(lineItems, &stripe.CheckoutSessionLineItemParams{
+			PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
+				Currency: stripe.String(currency),
+				ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
+					Name: stripe.String(name),
+				},
+				UnitAmount: stripe.Int64(unitAmount),
+			},
+			Quantity: stripe.Int64(int64(item.Quantity)),
+		})
 	}
 
-	// Validate quantity (1-100)
-	if reqBody.Quantity < 1 {
-		http.Error(w, "Quantity must be at least 1", http.StatusBadRequest)
-		return
+	// Tax is a flat rate on the subtotal, so it's charged as its own line. Tax-inclusive prices
+	// already carry it
+	tax, _ := api.orderTotals(cart.Subtotal)
+	if tax > 0 && !api.websiteConfig.Ecommerce.TaxInclusive {
+		amountTotal += utils.StripeAmount(tax, currency)
+		lineItems = append(lineItems, &stripe.CheckoutSessionLineItemParams{
+			PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
+				Currency: stripe.String(currency),
+				ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
+					Name: stripe.String("Tax"),
+				},
+				UnitAmount: stripe.Int64(utils.StripeAmount(tax, currency)),
+			},
+			Quantity: stripe.Int64(1),
+		})
 	}
-	if reqBody.Quantity > 100 {
-		http.Error(w, "Quantity cannot exceed 100", http.StatusBadRequest)
-		return
+	shippingAmount := utils.StripeAmount(api.websiteConfig.Ecommerce.ShippingCost, currency)
+	amountTotal += shippingAmount
+
+	params := &stripe.CheckoutSessionParams{
+		Mode:              stripe.String(string(stripe.CheckoutSessionModePayment)),
+		LineItems:         lineItems,
+		ClientReferenceID: stripe.String(sessionID),
+		SuccessURL:        stripe.String(baseURL + successPath),
+		CancelURL:         stripe.String(baseURL + cancelPath),
+		ShippingAddressCollection: &stripe.CheckoutSessionShippingAddressCollectionParams{
+			AllowedCountries: stripe.StringSlice(api.shippingCountries()),
+		},
+		ShippingOptions: []*stripe.CheckoutSessionShippingOptionParams{
+			{
+				ShippingRateData: &stripe.CheckoutSessionShippingOptionShippingRateDataParams{
+					DisplayName: stripe.String("Standard Shipping"),
+					Type:        stripe.String("fixed_amount"),
+					FixedAmount: &stripe.CheckoutSessionShippingOptionShippingRateDataFixedAmountParams{
+						Amount:   stripe.Int64(shippingAmount),
+						Currency: stripe.String(currency),
+					},
+				},
+			},
+		},
+	}
+
+	if api.websiteConfig.Ecommerce.ManualCapture {
+		params.PaymentIntentData = &stripe.CheckoutSessionPaymentIntentDataParams{
+			CaptureMethod: stripe.String(string(stripe.PaymentIntentCaptureMethodManual)),
+		}
 	}
 
-	err = api.dbConn.UpdateCartItem(itemID, reqBody.Quantity)
+	cs, err := checkoutsession.New(params)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to create checkout session: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	sessionID := session.GetCartSession(r)
-	cart, err := api.dbConn.GetCart(sessionID)
+	// The order is built from the cart as it is now, whatever happens to it before the customer pays
+	err = api.dbConn.SaveCheckoutSnapshot(cs.ID, database.CheckoutSnapshot{
+		CartSessionID: sessionID,
+		Items:         cart.Items,
+		AmountTotal:   amountTotal,
+		Currency:      currency,
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if _, expireErr := checkoutsession.Expire(cs.ID, nil); expireErr != nil {
+			log.Printf("Warning: failed to expire checkout session %s after saving its cart failed: %v", cs.ID, expireErr)
+		}
+		http.Error(w, fmt.Sprintf("Failed to save checkout session: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	jsonData, err := json.MarshalIndent(cart, "", "    ")
+	response := map[string]interface{}{
+		"id":  cs.ID,
+		"url": cs.URL,
+	}
+
+	jsonData, err := json.MarshalIndent(response, "", "    ")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -815,38 +3001,106 @@ func (api *APIV1) updateCartItem(w http.ResponseWriter, r *http.Request) {
 	w.Write(jsonData)
 }
 
-func (api *APIV1) removeFromCart(w http.ResponseWriter, r *http.Request) {
-	itemIDStr := chi.URLParam(r, "itemId")
-	itemID, err := strconv.Atoi(itemIDStr)
-	if err != nil {
-		http.Error(w, "Invalid item ID", http.StatusBadRequest)
-		return
+// handleCheckoutSessionCompleted creates the order for a completed hosted checkout and marks it paid
+func (api *APIV1) handleCheckoutSessionCompleted(cs stripe.CheckoutSession) error {
+	if cs.PaymentIntent == nil || cs.PaymentIntent.ID == "" {
+		return fmt.Errorf("checkout session %s has no payment intent", cs.ID)
 	}
+	paymentIntentID := cs.PaymentIntent.ID
 
-	err = api.dbConn.RemoveFromCart(itemID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	// Stripe retries webhooks, so sessions we've already turned into orders only need confirming,
+	// which does nothing once it's been done
+	if _, err := api.dbConn.GetOrderByPaymentIntentID(paymentIntentID); err == nil {
+		return api.confirmCheckoutPayment(paymentIntentID)
 	}
 
-	sessionID := session.GetCartSession(r)
-	cart, err := api.dbConn.GetCart(sessionID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	// The order is for what the session was created for, not what's in the cart now
+	snapshot, err := api.dbConn.GetCheckoutSnapshot(cs.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("checkout session %s has no saved cart", cs.ID)
+	} else if err != nil {
+		return fmt.Errorf("failed to get checkout snapshot: %v", err)
+	}
+	if len(snapshot.Items) == 0 {
+		return fmt.Errorf("cart %s for checkout session %s is empty", snapshot.CartSessionID, cs.ID)
+	}
+	if cs.AmountTotal != snapshot.AmountTotal || !strings.EqualFold(string(cs.Currency), snapshot.Currency) {
+		return fmt.Errorf("checkout session %s charged %d %s but its cart came to %d %s", cs.ID, cs.AmountTotal, cs.Currency, snapshot.AmountTotal, snapshot.Currency)
 	}
 
-	jsonData, err := json.MarshalIndent(cart, "", "    ")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	customerEmail := ""
+	name := ""
+	if cs.CustomerDetails != nil {
+		customerEmail = cs.CustomerDetails.Email
+		name = cs.CustomerDetails.Name
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonData)
+	address := &stripe.Address{}
+	if cs.ShippingDetails != nil {
+		if cs.ShippingDetails.Name != "" {
+			name = cs.ShippingDetails.Name
+		}
+		if cs.ShippingDetails.Address != nil {
+			address = cs.ShippingDetails.Address
+		}
+	}
+
+	firstName, lastName, _ := strings.Cut(strings.TrimSpace(name), " ")
+
+	orderData := map[string]interface{}{
+		"email": customerEmail,
+		"shipping_address": map[string]interface{}{
+			"first_name": firstName,
+			"last_name":  strings.TrimSpace(lastName),
+			"address":    address.Line1,
+			"address2":   address.Line2,
+			"city":       address.City,
+			"state":      address.State,
+			"zip":        address.PostalCode,
+			"country":    address.Country,
+		},
+		"cart_items":          snapshot.Items,
+		"payment_intent_id":   paymentIntentID,
+		"tax_rate":            api.websiteConfig.Ecommerce.TaxRate,
+		"tax_inclusive":       api.websiteConfig.Ecommerce.TaxInclusive,
+		"tax_rounding":        api.websiteConfig.Ecommerce.TaxRounding,
+		"shipping_cost":       api.websiteConfig.Ecommerce.ShippingCost,
+		"order_number_prefix": api.websiteConfig.Ecommerce.OrderNumberPrefix,
+	}
+	if cs.CustomerDetails != nil && cs.CustomerDetails.Address != nil {
+		orderData["billing_address"] = map[string]interface{}{"country": cs.CustomerDetails.Address.Country}
+	}
+	api.ScoreOrderRisk(orderData)
+
+	// A delivery racing this one may have created the order since the check above
+	if _, err := api.dbConn.CreateOrder(orderData); errors.Is(err, database.ErrDuplicateOrder) {
+		return api.confirmCheckoutPayment(paymentIntentID)
+	} else if err != nil {
+		return fmt.Errorf("failed to create order: %v", err)
+	}
+
+	if err := api.dbConn.ClearCart(snapshot.CartSessionID); err != nil {
+		log.Printf("Failed to clear cart %s: %v", snapshot.CartSessionID, err)
+	}
+	if err := api.dbConn.DeleteCheckoutSnapshot(cs.ID); err != nil {
+		log.Printf("Failed to delete checkout snapshot %s: %v", cs.ID, err)
+	}
+
+	return api.confirmCheckoutPayment(paymentIntentID)
 }
 
-func (api *APIV1) createOrder(w http.ResponseWriter, r *http.Request) {
+// confirmCheckoutPayment takes a hosted Checkout order the same way as the payment intent flow:
+// it's marked paid (or authorized) and confirmation emails go out, once
+func (api *APIV1) confirmCheckoutPayment(paymentIntentID string) error {
+	if api.websiteConfig.Ecommerce.ManualCapture {
+		return api.handlePaymentAuthorized(paymentIntentID)
+	}
+	return api.handlePaymentSuccess(paymentIntentID)
+}
+
+// createSubscription creates a Stripe subscription for a cart of subscription products and returns the
+// client secret for confirming its first payment. Orders are created by the invoice.payment_succeeded webhook.
+func (api *APIV1) createSubscription(w http.ResponseWriter, r *http.Request) {
 	sessionID := session.GetCartSession(r)
 	if sessionID == "" {
 		http.Error(w, "No cart session found", http.StatusBadRequest)
@@ -864,97 +3118,182 @@ func (api *APIV1) createOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var orderData map[string]interface{}
-	err = json.NewDecoder(r.Body).Decode(&orderData)
-	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !api.checkMinOrderAmount(w, cart.Subtotal) {
 		return
 	}
 
-	orderData["cart_items"] = cart.Items
-
-	// Get tax rate and shipping cost from config (0 is valid)
-	orderData["tax_rate"] = api.websiteConfig.Ecommerce.TaxRate
-	orderData["shipping_cost"] = api.websiteConfig.Ecommerce.ShippingCost
-
-	order, err := api.dbConn.CreateOrder(orderData)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !api.checkMaxPerOrder(w, cart) {
 		return
 	}
-
-	session.ClearCartSession(w)
-
-	jsonData, err := json.MarshalIndent(order, "", "    ")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !api.checkBundleStock(w, cart) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonData)
-}
+	// Every item has to renew on the same schedule
+	interval := cart.Items[0].Product.SubscriptionInterval
+	for _, item := range cart.Items {
+		if item.Product.SubscriptionInterval == "" {
+			http.Error(w, fmt.Sprintf("%s is not a subscription - check out one-time items separately", item.Product.Name), http.StatusBadRequest)
+			return
+		}
+		if item.Product.SubscriptionInterval != interval {
+			http.Error(w, "All subscription items must have the same billing interval", http.StatusBadRequest)
+			return
+		}
+	}
 
-func (api *APIV1) getOrder(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	vars, ok := ctx.Value("vars").(map[string]string)
+	var requestBody map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	shippingAddr, ok := requestBody["shipping_address"].(map[string]interface{})
 	if !ok {
-		http.Error(w, http.StatusText(422), 422)
+		http.Error(w, "Shipping address is required", http.StatusBadRequest)
 		return
 	}
+	// Recurring orders are created from this address later, so it has to be complete now
+	for _, field := range []string{"first_name", "last_name", "address", "city", "state", "zip", "country"} {
+		if value, _ := shippingAddr[field].(string); value == "" {
+			http.Error(w, fmt.Sprintf("Shipping address %s is required", field), http.StatusBadRequest)
+			return
+		}
+	}
 
-	order, err := api.dbConn.GetOrder(vars["orderNumber"])
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+	stripeKey := api.websiteConfig.Stripe.SecretKey
+	if stripeKey == "" {
+		http.Error(w, "Stripe not configured", http.StatusInternalServerError)
 		return
 	}
+	stripe.Key = stripeKey
 
-	jsonData, err := json.MarshalIndent(order, "", "    ")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Subscriptions need a Stripe customer to bill
+	cust, stripeCustomerID := api.getOrCreateStripeCustomer(requestBody)
+	if stripeCustomerID == "" {
+		http.Error(w, "Email and name are required for subscriptions", http.StatusBadRequest)
 		return
 	}
+	api.mergeWishlist(sessionID, cust.ID)
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonData)
-}
+	subtotal := cart.Subtotal
+	tax, total := api.orderTotals(subtotal)
+	shippingCost := api.websiteConfig.Ecommerce.ShippingCost
 
-func (api *APIV1) NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	// Tax-inclusive item prices already carry the tax, so it isn't billed as its own item
+	chargedTax := tax
+	if api.websiteConfig.Ecommerce.TaxInclusive {
+		chargedTax = 0
+	}
+
+	// Recurring prices are created per checkout since the catalog price can change between subscribers.
+	// They're in the site currency, like one-time payments
+	currency := api.stripeCurrency()
+	newRecurringPrice := func(name string, amount float64) (string, error) {
+		p, err := price.New(&stripe.PriceParams{
+			Currency:   stripe.String(currency),
+			UnitAmount: stripe.Int64(utils.StripeAmount(amount, currency)),
+			Recurring: &stripe.PriceRecurringParams{
+				Interval: stripe.String(interval),
+			},
+			ProductData: &stripe.PriceProductDataParams{
+				Name: stripe.String(name),
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		return p.ID, nil
+	}
 
-	// Create the error response struct
-	errResponse := ErrorResponse{
-		StatusCode:  404,
-		ErrorString: "endpoint not found",
+	items := []*stripe.SubscriptionItemsParams{}
+	for _, item := range cart.Items {
+		name := item.Product.Name
+		if item.Variant.Title != "" {
+			name += " - " + item.Variant.Title
+		}
+		priceID, err := newRecurringPrice(name, item.Price)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to create subscription price: %v", err), http.StatusInternalServerError)
+			return
+		}
+		items = append(items, &stripe.SubscriptionItemsParams{
+			Price:    stripe.String(priceID),
+			Quantity: stripe.Int64(int64(item.Quantity)),
+		})
 	}
 
-	// Convert the struct to JSON
-	jsonData, err := json.Marshal(errResponse)
+	// Tax and shipping recur with every order
+	extras := []struct {
+		name   string
+		amount float64
+	}{
+		{"Tax", chargedTax},
+		{"Shipping", shippingCost},
+	}
+	for _, extra := range extras {
+		if extra.amount <= 0 {
+			continue
+		}
+		priceID, err := newRecurringPrice(extra.name, extra.amount)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to create subscription price: %v", err), http.StatusInternalServerError)
+			return
+		}
+		items = append(items, &stripe.SubscriptionItemsParams{
+			Price:    stripe.String(priceID),
+			Quantity: stripe.Int64(1),
+		})
+	}
+
+	params := &stripe.SubscriptionParams{
+		Customer:        stripe.String(stripeCustomerID),
+		Items:           items,
+		PaymentBehavior: stripe.String("default_incomplete"),
+		PaymentSettings: &stripe.SubscriptionPaymentSettingsParams{
+			SaveDefaultPaymentMethod: stripe.String("on_subscription"),
+		},
+	}
+	params.AddExpand("latest_invoice.payment_intent")
+
+	sub, err := subscription.New(params)
 	if err != nil {
-		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to create subscription: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Set the Content-Type header to application/json
-	w.Header().Set("Content-Type", "application/json")
-	// Set the status code for the response
-	w.WriteHeader(errResponse.StatusCode)
-	// Write the JSON data to the response writer
-	w.Write(jsonData)
-}
-
-// getConfig returns public configuration (like Stripe publishable key)
-func (api *APIV1) getConfig(w http.ResponseWriter, r *http.Request) {
-	// Get Stripe publishable key from site config
-	publishableKey := api.websiteConfig.Stripe.PublishableKey
+	if sub.LatestInvoice == nil || sub.LatestInvoice.PaymentIntent == nil {
+		http.Error(w, "Subscription has no payment to confirm", http.StatusInternalServerError)
+		return
+	}
 
-	// Get tax rate and shipping cost from config (0 is valid)
-	taxRate := api.websiteConfig.Ecommerce.TaxRate
-	shippingCost := api.websiteConfig.Ecommerce.ShippingCost
+	customerEmail, _ := requestBody["email"].(string)
+	localSub := structs.Subscription{
+		StripeSubscriptionID: sub.ID,
+		CustomerEmail:        customerEmail,
+		CustomerName:         cust.FirstName + " " + cust.LastName,
+		CartID:               sessionID,
+		ShippingAddress:      shippingAddr,
+		Items:                cart.Items,
+		BillingInterval:      interval,
+		Amount:               total,
+		Status:               string(sub.Status),
+	}
+	if cust.ID > 0 {
+		localSub.CustomerID = &cust.ID
+	}
+	if err := api.dbConn.CreateSubscription(localSub); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	response := map[string]interface{}{
-		"stripePublishableKey": publishableKey,
-		"taxRate":              taxRate,
-		"shippingCost":         shippingCost,
+		"subscriptionId": sub.ID,
+		"clientSecret":   sub.LatestInvoice.PaymentIntent.ClientSecret,
+		"interval":       interval,
+		"amount":         total,
+		"subtotal":       subtotal,
+		"tax":            tax,
+		"shipping":       shippingCost,
 	}
 
 	jsonData, err := json.MarshalIndent(response, "", "    ")
@@ -967,113 +3306,106 @@ func (api *APIV1) getConfig(w http.ResponseWriter, r *http.Request) {
 	w.Write(jsonData)
 }
 
-// createPaymentIntent creates a Stripe payment intent for the cart
-func (api *APIV1) createPaymentIntent(w http.ResponseWriter, r *http.Request) {
-	sessionID := session.GetCartSession(r)
-	if sessionID == "" {
-		http.Error(w, "No cart session found", http.StatusBadRequest)
-		return
+// handleSubscriptionInvoicePaid creates a recurring order from a paid subscription invoice
+func (api *APIV1) handleSubscriptionInvoicePaid(invoice stripe.Invoice) error {
+	if invoice.Subscription == nil {
+		return nil
 	}
+	if invoice.PaymentIntent == nil || invoice.PaymentIntent.ID == "" {
+		return fmt.Errorf("invoice %s has no payment intent", invoice.ID)
+	}
+	paymentIntentID := invoice.PaymentIntent.ID
 
-	cart, err := api.dbConn.GetCart(sessionID)
+	// Stripe retries webhooks, so invoices we've already turned into orders only need marking paid,
+	// which does nothing once it's been done
+	if _, err := api.dbConn.GetOrderByPaymentIntentID(paymentIntentID); err == nil {
+		return api.handlePaymentSuccess(paymentIntentID)
+	}
+
+	sub, err := api.dbConn.GetSubscriptionByStripeID(invoice.Subscription.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return fmt.Errorf("failed to get subscription %s: %v", invoice.Subscription.ID, err)
 	}
 
-	if len(cart.Items) == 0 {
-		http.Error(w, "Cart is empty", http.StatusBadRequest)
-		return
+	orderData := map[string]interface{}{
+		"email":               sub.CustomerEmail,
+		"shipping_address":    sub.ShippingAddress,
+		"cart_items":          sub.Items,
+		"payment_intent_id":   paymentIntentID,
+		"tax_rate":            api.websiteConfig.Ecommerce.TaxRate,
+		"tax_inclusive":       api.websiteConfig.Ecommerce.TaxInclusive,
+		"tax_rounding":        api.websiteConfig.Ecommerce.TaxRounding,
+		"shipping_cost":       api.websiteConfig.Ecommerce.ShippingCost,
+		"order_number_prefix": api.websiteConfig.Ecommerce.OrderNumberPrefix,
 	}
+	api.ScoreOrderRisk(orderData)
 
-	// Parse request body to extract customer email and shipping address
-	var requestBody map[string]interface{}
-	bodyBytes, err := io.ReadAll(r.Body)
-	if err == nil {
-		json.Unmarshal(bodyBytes, &requestBody)
-		// Restore body for potential future reads
-		r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	// A delivery racing this one may have created the order since the check above
+	if _, err := api.dbConn.CreateOrder(orderData); errors.Is(err, database.ErrDuplicateOrder) {
+		return api.handlePaymentSuccess(paymentIntentID)
+	} else if err != nil {
+		return fmt.Errorf("failed to create subscription order: %v", err)
 	}
 
-	// Calculate total (subtotal + tax + shipping)
-	subtotal := cart.Subtotal
+	// The first invoice is the checkout itself, so empty the cart it came from
+	if invoice.BillingReason == stripe.InvoiceBillingReasonSubscriptionCreate && sub.CartID != "" {
+		if err := api.dbConn.ClearCart(sub.CartID); err != nil {
+			log.Printf("Failed to clear cart %s: %v", sub.CartID, err)
+		}
+	}
 
-	// Get tax rate and shipping cost from config (0 is valid)
-	taxRate := api.websiteConfig.Ecommerce.TaxRate
-	tax := subtotal * taxRate
+	if err := api.dbConn.UpdateSubscriptionStatus(sub.StripeSubscriptionID, "active"); err != nil {
+		log.Printf("Failed to update subscription status: %v", err)
+	}
 
-	shippingCost := api.websiteConfig.Ecommerce.ShippingCost
+	return api.handlePaymentSuccess(paymentIntentID)
+}
+
+// createCustomerPortalSession creates a Stripe billing portal session for a returning customer.
+// There are no customer accounts, so the customer is identified by one of their order numbers plus its email.
+func (api *APIV1) createCustomerPortalSession(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		Email       string `json:"email"`
+		OrderNumber string `json:"order_number"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil || requestBody.Email == "" || requestBody.OrderNumber == "" {
+		http.Error(w, "Email and order number are required", http.StatusBadRequest)
+		return
+	}
+
+	// Same response for a wrong email as for a missing order, so order numbers can't be probed
+	order, err := api.dbConn.GetOrder(requestBody.OrderNumber)
+	if err != nil || !strings.EqualFold(order.CustomerEmail, strings.TrimSpace(requestBody.Email)) {
+		http.Error(w, "Customer not found", http.StatusNotFound)
+		return
+	}
 
-	total := subtotal + tax + shippingCost
+	cust, err := api.dbConn.GetCustomerByEmail(order.CustomerEmail)
+	if err != nil || cust.StripeCustomerID == "" {
+		http.Error(w, "Customer not found", http.StatusNotFound)
+		return
+	}
 
-	// Get Stripe secret key from site config
 	stripeKey := api.websiteConfig.Stripe.SecretKey
 	if stripeKey == "" {
 		http.Error(w, "Stripe not configured", http.StatusInternalServerError)
 		return
 	}
-
-	// Set Stripe API key
 	stripe.Key = stripeKey
 
-	// Try to get/create customer and link to Stripe
-	var stripeCustomerID string
-	if requestBody != nil {
-		if email, ok := requestBody["email"].(string); ok && email != "" {
-			if shippingAddr, ok := requestBody["shipping_address"].(map[string]interface{}); ok {
-				firstName, _ := shippingAddr["first_name"].(string)
-				lastName, _ := shippingAddr["last_name"].(string)
-
-				if firstName != "" && lastName != "" {
-					// Get or create local customer record
-					cust, err := api.dbConn.GetOrCreateCustomer(email, firstName, lastName)
-					if err == nil {
-						// Check if customer already has Stripe ID
-						if cust.StripeCustomerID != "" {
-							stripeCustomerID = cust.StripeCustomerID
-						} else {
-							// Create Stripe customer
-							stripeParams := &stripe.CustomerParams{
-								Email: stripe.String(email),
-								Name:  stripe.String(firstName + " " + lastName),
-							}
-							stripeCust, err := customer.New(stripeParams)
-							if err == nil && stripeCust != nil {
-								stripeCustomerID = stripeCust.ID
-								// Update local customer record with Stripe ID
-								api.dbConn.UpdateCustomerStripeID(cust.ID, stripeCust.ID)
-							}
-						}
-					}
-				}
-			}
-		}
-	}
-
-	// Create payment intent
-	params := &stripe.PaymentIntentParams{
-		Amount:              stripe.Int64(int64(total * 100)), // Convert to cents
-		Currency:            stripe.String(string(stripe.CurrencyUSD)),
-		PaymentMethodTypes:  stripe.StringSlice([]string{"card", "link"}), // Card payments, Apple Pay, Google Pay, and Link
-	}
-
-	// Link to Stripe customer if we have one
-	if stripeCustomerID != "" {
-		params.Customer = stripe.String(stripeCustomerID)
+	params := &stripe.BillingPortalSessionParams{
+		Customer:  stripe.String(cust.StripeCustomerID),
+		ReturnURL: stripe.String("https://" + api.websiteConfig.SiteName + "/"),
 	}
 
-	pi, err := paymentintent.New(params)
+	ps, err := billingportalsession.New(params)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create payment intent: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to create customer portal session: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	response := map[string]interface{}{
-		"clientSecret": pi.ClientSecret,
-		"amount":       total,
-		"subtotal":     subtotal,
-		"tax":          tax,
-		"shipping":     shippingCost,
+		"url": ps.URL,
 	}
 
 	jsonData, err := json.MarshalIndent(response, "", "    ")
@@ -1092,6 +3424,171 @@ func (api *APIV1) webhookInfo(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
+// WebhookTestHeader marks a test delivery sent from the admin webhooks page
+const WebhookTestHeader = "X-Stencil-Webhook-Test"
+
+// webhookStatusWriter remembers the status a webhook handler answered with
+type webhookStatusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (sw *webhookStatusWriter) WriteHeader(code int) {
+	if sw.code == 0 {
+		sw.code = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *webhookStatusWriter) Write(p []byte) (int, error) {
+	if sw.code == 0 {
+		sw.code = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+// recordWebhook wraps a webhook handler so every delivery is saved to webhook_log with its event
+// type and ID, the status it got, and its body with personal data redacted, for the admin webhooks pages
+func (api *APIV1) recordWebhook(provider string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Read the body up front so it can be logged after the handler is done
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+			api.saveWebhookLog(provider, nil, http.StatusBadRequest, r)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		sw := &webhookStatusWriter{ResponseWriter: w}
+		next(sw, r)
+		if sw.code == 0 {
+			sw.code = http.StatusOK
+		}
+
+		api.saveWebhookLog(provider, body, sw.code, r)
+	}
+}
+
+func (api *APIV1) saveWebhookLog(provider string, body []byte, statusCode int, r *http.Request) {
+	eventType, eventID := webhookEventInfo(provider, body)
+	test := r.Header.Get(WebhookTestHeader) != ""
+
+	if err := api.dbConn.RecordWebhook(provider, eventType, eventID, statusCode, test, redactWebhookBody(provider, body)); err != nil {
+		log.Printf("Error logging %s webhook: %v", provider, err)
+	}
+}
+
+// webhookEventInfo pulls the event type and ID out of a delivery. Shippo events are identified by
+// their tracking number and Twilio messages by their SID
+func webhookEventInfo(provider string, body []byte) (eventType, eventID string) {
+	switch provider {
+	case "stripe":
+		var event struct {
+			ID   string `json:"id"`
+			Type string `json:"type"`
+		}
+		json.Unmarshal(body, &event)
+		return event.Type, event.ID
+	case "shippo":
+		var event struct {
+			Event          string `json:"event"`
+			TrackingNumber string `json:"tracking_number"`
+		}
+		json.Unmarshal(body, &event)
+		return event.Event, event.TrackingNumber
+	case "sms":
+		form, _ := url.ParseQuery(string(body))
+		return "message", form.Get("MessageSid")
+	}
+	return "", ""
+}
+
+// webhookLogBodyLimit caps how much of each delivery's body is kept
+const webhookLogBodyLimit = 4096
+
+// webhookRedactedKeys are JSON fields holding customer details or secrets; their values are
+// replaced before a delivery is logged
+var webhookRedactedKeys = map[string]bool{
+	"email":            true,
+	"receipt_email":    true,
+	"customer_email":   true,
+	"name":             true,
+	"customer_name":    true,
+	"phone":            true,
+	"address":          true,
+	"shipping":         true,
+	"billing_details":  true,
+	"customer_details": true,
+	"address_to":       true,
+	"address_from":     true,
+	"client_secret":    true,
+	"last4":            true,
+	"fingerprint":      true,
+	"ip_address":       true,
+}
+
+// redactWebhookBody returns a delivery's body for the webhook log with customer details and
+// secrets replaced, truncated to webhookLogBodyLimit
+func redactWebhookBody(provider string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	var redacted string
+	if provider == "sms" {
+		// Twilio posts a form: keep the routing fields, hide who sent what
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return "(body not logged: unreadable form)"
+		}
+		for _, key := range []string{"Body", "From", "FromCity", "FromState", "FromZip", "FromCountry"} {
+			if form.Get(key) != "" {
+				form.Set(key, "[redacted]")
+			}
+		}
+		redacted = form.Encode()
+	} else {
+		var payload interface{}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&payload); err != nil {
+			return "(body not logged: not valid JSON)"
+		}
+		redactWebhookValue(payload)
+		out, err := json.Marshal(payload)
+		if err != nil {
+			return "(body not logged)"
+		}
+		redacted = string(out)
+	}
+
+	if len(redacted) > webhookLogBodyLimit {
+		redacted = strings.ToValidUTF8(redacted[:webhookLogBodyLimit], "") + "…"
+	}
+	return redacted
+}
+
+// redactWebhookValue replaces the values of webhookRedactedKeys anywhere in a decoded payload
+func redactWebhookValue(v interface{}) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for key, value := range t {
+			if webhookRedactedKeys[strings.ToLower(key)] {
+				if value != nil && value != "" {
+					t[key] = "[redacted]"
+				}
+				continue
+			}
+			redactWebhookValue(value)
+		}
+	case []interface{}:
+		for _, value := range t {
+			redactWebhookValue(value)
+		}
+	}
+}
+
 // handleStripeWebhook handles Stripe webhook events
 func (api *APIV1) handleStripeWebhook(w http.ResponseWriter, r *http.Request) {
 	const MaxBodyBytes = int64(65536)
@@ -1103,11 +3600,16 @@ func (api *APIV1) handleStripeWebhook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get Stripe secret key from site config
-	stripeKey := api.websiteConfig.Stripe.SecretKey
+	// Webhooks are signed with the endpoint's signing secret, not the API secret key
+	webhookSecret := api.websiteConfig.Stripe.WebhookSecret
+	if webhookSecret == "" {
+		log.Printf("Stripe webhook received but no webhook secret is configured for %s", api.websiteConfig.SiteName)
+		http.Error(w, "Stripe webhook secret not configured", http.StatusInternalServerError)
+		return
+	}
 
 	// Verify webhook signature
-	event, err := webhook.ConstructEvent(body, r.Header.Get("Stripe-Signature"), stripeKey)
+	event, err := webhook.ConstructEvent(body, r.Header.Get("Stripe-Signature"), webhookSecret)
 	if err != nil {
 		log.Printf("Webhook signature verification failed: %v", err)
 		http.Error(w, "Invalid signature", http.StatusUnauthorized)
@@ -1132,6 +3634,93 @@ func (api *APIV1) handleStripeWebhook(w http.ResponseWriter, r *http.Request) {
 			// Don't return error to Stripe, we've received the webhook
 		}
 
+	case "checkout.session.completed":
+		var checkoutSession stripe.CheckoutSession
+		err := json.Unmarshal(event.Data.Raw, &checkoutSession)
+		if err != nil {
+			log.Printf("Error parsing webhook JSON: %v", err)
+			http.Error(w, "Error parsing webhook", http.StatusBadRequest)
+			return
+		}
+
+		// Stripe redelivers the event on a 5xx, so a paid session isn't left without its order
+		err = api.handleCheckoutSessionCompleted(checkoutSession)
+		if err != nil {
+			log.Printf("Error handling checkout session completion: %v", err)
+			http.Error(w, "Error handling checkout session", http.StatusInternalServerError)
+			return
+		}
+
+	case "payment_intent.amount_capturable_updated":
+		// Manual capture: the payment is authorized but not yet captured
+		var paymentIntent stripe.PaymentIntent
+		err := json.Unmarshal(event.Data.Raw, &paymentIntent)
+		if err != nil {
+			log.Printf("Error parsing webhook JSON: %v", err)
+			http.Error(w, "Error parsing webhook", http.StatusBadRequest)
+			return
+		}
+
+		err = api.handlePaymentAuthorized(paymentIntent.ID)
+		if err != nil {
+			log.Printf("Error handling payment authorization: %v", err)
+		}
+
+	case "payment_intent.canceled":
+		var paymentIntent stripe.PaymentIntent
+		err := json.Unmarshal(event.Data.Raw, &paymentIntent)
+		if err != nil {
+			log.Printf("Error parsing webhook JSON: %v", err)
+			http.Error(w, "Error parsing webhook", http.StatusBadRequest)
+			return
+		}
+
+		// Uncaptured authorizations are canceled by Stripe once they expire
+		status := "canceled"
+		if paymentIntent.CancellationReason == stripe.PaymentIntentCancellationReasonAutomatic {
+			status = "expired"
+		}
+		err = api.dbConn.UpdateOrderPaymentStatusByIntentID(paymentIntent.ID, status)
+		if err != nil {
+			log.Printf("Error updating payment status: %v", err)
+		}
+
+		// Store credit held for an intent that never became an order goes back to the customer
+		if _, err := api.dbConn.ReleaseStoreCreditHold(paymentIntent.ID); err != nil {
+			log.Printf("Error releasing store credit hold: %v", err)
+		}
+
+	case "invoice.payment_succeeded":
+		var invoice stripe.Invoice
+		err := json.Unmarshal(event.Data.Raw, &invoice)
+		if err != nil {
+			log.Printf("Error parsing webhook JSON: %v", err)
+			http.Error(w, "Error parsing webhook", http.StatusBadRequest)
+			return
+		}
+
+		// Stripe redelivers the event on a 5xx, so a paid invoice isn't left without its order
+		err = api.handleSubscriptionInvoicePaid(invoice)
+		if err != nil {
+			log.Printf("Error handling subscription invoice: %v", err)
+			http.Error(w, "Error handling subscription invoice", http.StatusInternalServerError)
+			return
+		}
+
+	case "customer.subscription.updated", "customer.subscription.deleted":
+		var sub stripe.Subscription
+		err := json.Unmarshal(event.Data.Raw, &sub)
+		if err != nil {
+			log.Printf("Error parsing webhook JSON: %v", err)
+			http.Error(w, "Error parsing webhook", http.StatusBadRequest)
+			return
+		}
+
+		err = api.dbConn.UpdateSubscriptionStatus(sub.ID, string(sub.Status))
+		if err != nil {
+			log.Printf("Error updating subscription status: %v", err)
+		}
+
 	case "payment_intent.payment_failed":
 		var paymentIntent stripe.PaymentIntent
 		err := json.Unmarshal(event.Data.Raw, &paymentIntent)
@@ -1141,7 +3730,8 @@ func (api *APIV1) handleStripeWebhook(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Update order status to failed
+		// Update order status to failed. The intent stays open for another payment method, so any
+		// store credit held for it stays until it's canceled or the hold expires
 		err = api.dbConn.UpdateOrderPaymentStatusByIntentID(paymentIntent.ID, "failed")
 		if err != nil {
 			log.Printf("Error updating payment status: %v", err)
@@ -1171,18 +3761,55 @@ func (api *APIV1) handleStripeWebhook(w http.ResponseWriter, r *http.Request) {
 
 // handlePaymentSuccess updates order status and sends confirmation email
 func (api *APIV1) handlePaymentSuccess(paymentIntentID string) error {
+	// Get the order first - a captured authorization already sent its emails
+	order, err := api.dbConn.GetOrderByPaymentIntentID(paymentIntentID)
+	if err != nil {
+		return fmt.Errorf("failed to get order: %v", err)
+	}
+	alreadyConfirmed := order.PaymentStatus == "authorized" || order.PaymentStatus == "paid"
+
 	// Update payment status in database
-	err := api.dbConn.UpdateOrderPaymentStatusByIntentID(paymentIntentID, "paid")
+	newlyPaid, err := api.dbConn.MarkOrderPaidByIntentID(paymentIntentID)
 	if err != nil {
 		return fmt.Errorf("failed to update payment status: %v", err)
 	}
+	if newlyPaid {
+		metrics.RecordPaidOrder(api.websiteConfig.SiteName, api.websiteConfig.Ecommerce.Currency, order.Total)
+	}
+
+	if alreadyConfirmed {
+		return nil
+	}
 
-	// Get the order to send confirmation email
+	return api.sendOrderEmails(order)
+}
+
+// handlePaymentAuthorized marks a manually captured order as authorized and sends confirmation email
+func (api *APIV1) handlePaymentAuthorized(paymentIntentID string) error {
 	order, err := api.dbConn.GetOrderByPaymentIntentID(paymentIntentID)
 	if err != nil {
 		return fmt.Errorf("failed to get order: %v", err)
 	}
 
+	// Stripe can resend this event; only confirm a pending order once
+	if order.PaymentStatus != "pending" {
+		return nil
+	}
+
+	err = api.dbConn.UpdateOrderPaymentStatusByIntentID(paymentIntentID, "authorized")
+	if err != nil {
+		return fmt.Errorf("failed to update payment status: %v", err)
+	}
+
+	return api.sendOrderEmails(order)
+}
+
+// sendOrderEmails sends the customer confirmation and admin notification emails for an order,
+// posts it to the site's chat webhook and sends the order.placed webhook event
+func (api *APIV1) sendOrderEmails(order structs.Order) error {
+	api.postOrderToChat(order)
+	api.sendWebhookEvent("order.placed", order)
+
 	// Send confirmation email
 	emailService, err := email.NewEmailService()
 	if err != nil {
@@ -1212,12 +3839,18 @@ func (api *APIV1) handlePaymentSuccess(paymentIntentID string) error {
 		order.Tax,
 		order.ShippingCost,
 		order.Total,
+		order.ShipsOn,
 	)
 	if err != nil {
 		log.Printf("Failed to send confirmation email: %v", err)
 		// Don't fail the webhook if email fails
 	}
 
+	// The admin hears about the order in the next digest instead
+	if api.websiteConfig.Ecommerce.OrderDigest.Enabled {
+		return nil
+	}
+
 	// Send admin notification email
 	err = emailService.SendAdminOrderNotification(
 		api.websiteConfig,
@@ -1229,6 +3862,7 @@ func (api *APIV1) handlePaymentSuccess(paymentIntentID string) error {
 		order.Tax,
 		order.ShippingCost,
 		order.Total,
+		order.ShipsOn,
 	)
 	if err != nil {
 		log.Printf("Failed to send admin notification email: %v", err)
@@ -1238,6 +3872,155 @@ func (api *APIV1) handlePaymentSuccess(paymentIntentID string) error {
 	return nil
 }
 
+// postOrderToChat posts a new order, and the products it left low on stock when that's turned on, to
+// the site's Slack or Discord webhook. Failures are only logged
+func (api *APIV1) postOrderToChat(order structs.Order) {
+	notifications := api.websiteConfig.Notifications
+	if notifications.ChatWebhookURL == "" {
+		return
+	}
+
+	money := func(amount float64) string { return utils.FormatMoney(amount, api.websiteConfig.Ecommerce.Currency) }
+
+	var items []string
+	for _, item := range order.Items {
+		name := item.ProductName
+		if item.VariantTitle != "" {
+			name += " - " + item.VariantTitle
+		}
+		items = append(items, fmt.Sprintf("%d × %s", item.Quantity, name))
+	}
+
+	customer := order.CustomerEmail
+	if order.CustomerName != "" {
+		customer = fmt.Sprintf("%s (%s)", order.CustomerName, order.CustomerEmail)
+	}
+
+	msg := chat.Message{
+		Title: fmt.Sprintf("New order #%s on %s", order.OrderNumber, api.websiteConfig.SiteName),
+		URL:   api.adminOrderURL(order.ID),
+		Fields: []chat.Field{
+			{Name: "Total", Value: money(order.Total), Inline: true},
+			{Name: "Customer", Value: customer, Inline: true},
+			{Name: "Items", Value: strings.Join(items, "\n")},
+		},
+	}
+	if order.ShippingCity != "" {
+		msg.Fields = append(msg.Fields, chat.Field{Name: "Ships to", Value: strings.Trim(fmt.Sprintf("%s, %s %s", order.ShippingCity, order.ShippingState, order.ShippingCountry), ", "), Inline: true})
+	}
+	api.postToChat("order.placed", msg)
+
+	if !notifications.LowStock {
+		return
+	}
+	lowStock, err := api.dbConn.GetOrderLowStockItems(order.ID, notifications.LowStockThreshold)
+	if err != nil {
+		log.Printf("Failed to check low stock for order %s: %v", order.OrderNumber, err)
+		return
+	}
+	if len(lowStock) == 0 {
+		return
+	}
+
+	var lines []string
+	for _, item := range lowStock {
+		name := item.ProductName
+		if item.VariantTitle != "" {
+			name += " - " + item.VariantTitle
+		}
+		if item.SKU != "" {
+			name += " (" + item.SKU + ")"
+		}
+		lines = append(lines, fmt.Sprintf("%s: %d left", name, item.Available))
+	}
+	api.postToChat("stock.low", chat.Message{
+		Title: fmt.Sprintf("Low stock on %s", api.websiteConfig.SiteName),
+		Text:  strings.Join(lines, "\n"),
+	})
+}
+
+// chatEndpoint is the outbound endpoint name of the site's Slack or Discord webhook
+const chatEndpoint = "chat"
+
+// outboundEndpoint looks up where an outbound delivery goes: the chat webhook, or one of the
+// site's notifications.webhooks by name
+func (api *APIV1) outboundEndpoint(name string) (outbound.Endpoint, bool) {
+	notifications := api.websiteConfig.Notifications
+	if name == chatEndpoint {
+		endpoint := outbound.Endpoint{URL: notifications.ChatWebhookURL, MaxAttempts: notifications.MaxAttempts}
+		return endpoint, endpoint.URL != ""
+	}
+
+	for _, webhook := range notifications.Webhooks {
+		if webhook.Name != name {
+			continue
+		}
+		maxAttempts := webhook.MaxAttempts
+		if maxAttempts == 0 {
+			maxAttempts = notifications.MaxAttempts
+		}
+		return outbound.Endpoint{URL: webhook.URL, Secret: webhook.Secret, MaxAttempts: maxAttempts}, true
+	}
+	return outbound.Endpoint{}, false
+}
+
+// queueOutbound queues a delivery and wakes the dispatcher to send it. Failures are only logged
+func (api *APIV1) queueOutbound(endpoint, event string, payload []byte) {
+	if _, err := api.dbConn.QueueOutboundDelivery(endpoint, event, payload); err != nil {
+		log.Printf("Failed to queue %s webhook to %s: %v", event, endpoint, err)
+		return
+	}
+	api.dispatcher.Notify()
+}
+
+// postToChat queues a message for the site's Slack or Discord webhook
+func (api *APIV1) postToChat(event string, msg chat.Message) {
+	client := chat.NewClient(api.websiteConfig.Notifications.ChatWebhookURL)
+	payload, err := client.Payload(msg)
+	if err != nil {
+		log.Printf("Failed to encode %s chat message: %v", event, err)
+		return
+	}
+	api.queueOutbound(chatEndpoint, event, payload)
+}
+
+// sendWebhookEvent queues an event for each of the site's webhooks that wants it. The body is
+// {"event": ..., "created_at": ..., "data": data}
+func (api *APIV1) sendWebhookEvent(event string, data interface{}) {
+	var payload []byte
+	for _, webhook := range api.websiteConfig.Notifications.Webhooks {
+		if webhook.Name == "" || webhook.URL == "" || !webhook.Subscribed(event) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			payload, err = json.Marshal(map[string]interface{}{
+				"event":      event,
+				"created_at": time.Now().UTC(),
+				"data":       data,
+			})
+			if err != nil {
+				log.Printf("Failed to encode %s webhook event: %v", event, err)
+				return
+			}
+		}
+		api.queueOutbound(webhook.Name, event, payload)
+	}
+}
+
+// adminOrderURL links to the order in the admin, or returns "" when the admin's public URL isn't set
+func (api *APIV1) adminOrderURL(orderID int) string {
+	publicURL := strings.TrimRight(api.envConfig.Admin.PublicURL, "/")
+	if publicURL == "" {
+		return ""
+	}
+	basePath := strings.Trim(api.envConfig.Admin.BasePath, "/ ")
+	if basePath != "" {
+		basePath = "/" + basePath
+	}
+	return fmt.Sprintf("%s%s/site/%s/orders/%d", publicURL, basePath, api.websiteConfig.Database.Name, orderID)
+}
+
 // handleShippoWebhook handles incoming Shippo tracking webhooks
 func (api *APIV1) handleShippoWebhook(w http.ResponseWriter, r *http.Request) {
 	// Read the request body
@@ -1375,9 +4158,167 @@ func (api *APIV1) validateAddress(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A valid address gets a token createOrder accepts as proof of validation. It covers the address
+	// as submitted, so a client that switches to Shippo's suggested form must validate that too
+	response := struct {
+		*shippo.AddressResponse
+		ValidationToken string `json:"validation_token,omitempty"`
+	}{AddressResponse: validatedAddr}
+	if validatedAddr.Validation.IsValid {
+		fingerprint := addressFingerprint(addr.Street1, addr.Street2, addr.City, addr.State, addr.Zip, addr.Country)
+		response.ValidationToken = api.addressValidationToken(fingerprint, time.Now().Add(addressValidationTTL))
+	}
+
 	// Return validation results
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(validatedAddr)
+	json.NewEncoder(w).Encode(response)
+}
+
+// addressValidationTTL is how long a token from validateAddress is accepted by createOrder
+const addressValidationTTL = time.Hour
+
+// addressFingerprint normalizes the delivery-relevant parts of an address so a token survives
+// differences in case and spacing
+func addressFingerprint(street1, street2, city, state, zip, country string) string {
+	parts := []string{street1, street2, city, state, zip, country}
+	for i, part := range parts {
+		parts[i] = strings.ToLower(strings.Join(strings.Fields(part), " "))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// addressValidationToken signs an address fingerprint and expiry time with the server's
+// http.tokenKey, so createOrder can check an address was validated without storing anything.
+// It's empty when there's no key to sign with
+func (api *APIV1) addressValidationToken(fingerprint string, expires time.Time) string {
+	key := api.envConfig.HTTP.TokenKey
+	if key == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte("address-validation:"+api.websiteConfig.Database.Name+":"+key))
+	fmt.Fprintf(mac, "%d\n%s", expires.Unix(), fingerprint)
+	return fmt.Sprintf("%d.%s", expires.Unix(), hex.EncodeToString(mac.Sum(nil)))
+}
+
+// validAddressToken reports whether token was issued by validateAddress for this address and hasn't expired
+func (api *APIV1) validAddressToken(token, fingerprint string) bool {
+	if api.envConfig.HTTP.TokenKey == "" {
+		return false
+	}
+	expiry, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(api.addressValidationToken(fingerprint, time.Unix(unix, 0))))
+}
+
+// checkAddressValidated writes a 400 and returns false when the site requires validated addresses
+// and the order's shipping address doesn't carry a valid token from validateAddress
+func (api *APIV1) checkAddressValidated(w http.ResponseWriter, orderData map[string]interface{}) bool {
+	if !api.websiteConfig.Ecommerce.RequireAddressValidation {
+		return true
+	}
+	if api.envConfig.HTTP.TokenKey == "" {
+		log.Printf("[%s] address validation is required but http.tokenKey isn't set, so no order can be validated", api.websiteConfig.Database.Name)
+		http.Error(w, "Address validation is not configured", http.StatusServiceUnavailable)
+		return false
+	}
+
+	shippingAddr, _ := orderData["shipping_address"].(map[string]interface{})
+	field := func(key string) string {
+		value, _ := shippingAddr[key].(string)
+		return value
+	}
+	fingerprint := addressFingerprint(field("address"), field("address2"), field("city"), field("state"), field("zip"), field("country"))
+
+	token, _ := orderData["address_validation_token"].(string)
+	if token == "" || !api.validAddressToken(token, fingerprint) {
+		http.Error(w, "Shipping address has not been validated - validate it with /api/v1/validate-address and include the returned validation_token as address_validation_token", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// defaultRiskLargeOrderAmount is the first-order subtotal that adds risk when
+// ecommerce.risk.largeOrderAmount isn't set
+const defaultRiskLargeOrderAmount = 500.0
+
+// Points each risk rule adds to an order's score
+const (
+	riskPointsCountryMismatch = 30
+	riskPointsLargeFirstOrder = 30
+	riskPointsBlockedDomain   = 50
+	riskPointsFailedPayments  = 30
+)
+
+// ScoreOrderRisk scores an order about to be created for fraud risk and stores the score and the
+// reasons in orderData for CreateOrder. The rules are deliberately simple: billing and shipping
+// countries that differ, a large first order, an email domain on the site's risk list, and
+// recent failed payments from the same email. Orders are never rejected, only flagged for review
+func (api *APIV1) ScoreOrderRisk(orderData map[string]interface{}) {
+	rules := api.websiteConfig.Ecommerce.Risk
+	score := 0
+	var reasons []string
+
+	// Billing country that doesn't match the shipping country
+	shippingAddr, _ := orderData["shipping_address"].(map[string]interface{})
+	billingAddr, _ := orderData["billing_address"].(map[string]interface{})
+	shippingCountry, _ := shippingAddr["country"].(string)
+	billingCountry, _ := billingAddr["country"].(string)
+	shippingCountry = utils.NormalizeCountry(shippingCountry)
+	billingCountry = utils.NormalizeCountry(billingCountry)
+	if billingCountry != "" && shippingCountry != "" && billingCountry != shippingCountry {
+		score += riskPointsCountryMismatch
+		reasons = append(reasons, fmt.Sprintf("Billing country %s differs from shipping country %s", billingCountry, shippingCountry))
+	}
+
+	// Email domain on the risk list, including its subdomains
+	email, _ := orderData["email"].(string)
+	email = strings.ToLower(strings.TrimSpace(email))
+	if _, domain, found := strings.Cut(email, "@"); found {
+		for _, blocked := range rules.BlockedEmailDomains {
+			blocked = strings.ToLower(strings.TrimSpace(blocked))
+			if blocked != "" && (domain == blocked || strings.HasSuffix(domain, "."+blocked)) {
+				score += riskPointsBlockedDomain
+				reasons = append(reasons, fmt.Sprintf("Email domain %s is on the risk list", domain))
+				break
+			}
+		}
+	}
+
+	// A large order from someone who hasn't bought before, or who just had payments fail
+	paidOrders, failedPayments, err := api.dbConn.GetEmailOrderHistory(email, 24)
+	if err != nil {
+		log.Printf("Error loading order history for risk scoring: %v", err)
+	} else {
+		largeOrder := rules.LargeOrderAmount
+		if largeOrder <= 0 {
+			largeOrder = defaultRiskLargeOrderAmount
+		}
+
+		subtotal := 0.0
+		if items, ok := orderData["cart_items"].([]structs.CartItem); ok {
+			for _, item := range items {
+				subtotal += item.Total
+			}
+		}
+
+		if paidOrders == 0 && subtotal >= largeOrder {
+			score += riskPointsLargeFirstOrder
+			reasons = append(reasons, fmt.Sprintf("First order with a large subtotal ($%.2f)", subtotal))
+		}
+		if failedPayments >= 2 {
+			score += riskPointsFailedPayments
+			reasons = append(reasons, fmt.Sprintf("%d failed payments from this email in the last 24 hours", failedPayments))
+		}
+	}
+
+	orderData["risk_score"] = score
+	orderData["risk_reasons"] = reasons
 }
 
 // getTracking retrieves package tracking information using Shippo
@@ -1652,13 +4593,7 @@ func (api *APIV1) trackAnalytics(w http.ResponseWriter, r *http.Request) {
 
 	// Extract client info from request
 	userAgent := r.UserAgent()
-	ipAddress := r.Header.Get("X-Forwarded-For")
-	if ipAddress == "" {
-		ipAddress = r.Header.Get("X-Real-IP")
-	}
-	if ipAddress == "" {
-		ipAddress = r.RemoteAddr
-	}
+	ipAddress := clientIP(r)
 
 	// Track based on type
 	if reqBody.EventType == "u" {
@@ -1704,6 +4639,7 @@ func (api *APIV1) trackAnalytics(w http.ResponseWriter, r *http.Request) {
 			city,
 			latitude,
 			longitude,
+			api.isBotNetwork(ipAddress),
 		)
 		if err != nil {
 			log.Printf("Failed to track pageview: %v", err)
@@ -1757,12 +4693,9 @@ func (api *APIV1) submitContactForm(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// SPAM PREVENTION 3: Rate limiting - max 3 submissions per hour per IP
-	clientIP := r.RemoteAddr
-	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-		clientIP = strings.Split(forwarded, ",")[0]
-	}
-	if !rateLimiter.checkRateLimit(clientIP) {
-		log.Printf("Rate limit exceeded for IP: %s", clientIP)
+	ip := clientIP(r)
+	if !rateLimiter.checkRateLimit(ip) {
+		log.Printf("Rate limit exceeded for IP: %s", ip)
 		http.Error(w, "Too many submissions. Please try again later.", http.StatusTooManyRequests)
 		return
 	}
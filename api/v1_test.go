@@ -0,0 +1,293 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/murdinc/stencil2/configs"
+)
+
+func TestCheckMinOrderAmount(t *testing.T) {
+	tests := []struct {
+		name     string
+		minimum  float64
+		subtotal float64
+		want     bool
+	}{
+		{"no minimum", 0, 0.01, true},
+		{"exactly the minimum", 25, 25, true},
+		{"lines summing to the minimum", 25, 0.02 + 21.08 + 3.90, true},
+		{"one cent below", 25, 24.99, false},
+		{"above the minimum", 25, 40, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &APIV1{websiteConfig: &configs.WebsiteConfig{}}
+			api.websiteConfig.Ecommerce.MinOrderAmount = tt.minimum
+
+			rec := httptest.NewRecorder()
+			if got := api.checkMinOrderAmount(rec, tt.subtotal); got != tt.want {
+				t.Fatalf("checkMinOrderAmount(%v) with minimum %v = %v, want %v", tt.subtotal, tt.minimum, got, tt.want)
+			}
+			if !tt.want && rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+		})
+	}
+
+	t.Run("message in the site currency", func(t *testing.T) {
+		api := &APIV1{websiteConfig: &configs.WebsiteConfig{}}
+		api.websiteConfig.Ecommerce.MinOrderAmount = 3000
+		api.websiteConfig.Ecommerce.Currency = "JPY"
+
+		rec := httptest.NewRecorder()
+		api.checkMinOrderAmount(rec, 2500)
+		if want := "Minimum order amount is ¥3,000 - add ¥500 more to check out"; strings.TrimSpace(rec.Body.String()) != want {
+			t.Errorf("message = %q, want %q", rec.Body.String(), want)
+		}
+	})
+}
+
+func TestCompressResponses(t *testing.T) {
+	large := `{"items":"` + strings.Repeat("x", 4*minCompressBytes) + `"}`
+	small := `{"ok":true}`
+
+	tests := []struct {
+		name           string
+		contentType    string
+		body           string
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{"large JSON", "application/json", large, "gzip, deflate, br", true},
+		{"large text without a content type", "", strings.Repeat("plain text ", 200), "gzip", true},
+		{"small JSON", "application/json", small, "gzip", false},
+		{"large JSON the client can't decode", "application/json", large, "", false},
+		{"large image", "image/png", large, "gzip", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := compressResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				// Written in pieces, the way json.Encoder and fmt.Fprintf write
+				for i := 0; i < len(tt.body); i += 100 {
+					io.WriteString(w, tt.body[i:min(i+100, len(tt.body))])
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/products", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			gotGzip := rec.Header().Get("Content-Encoding") == "gzip"
+			if gotGzip != tt.wantGzip {
+				t.Fatalf("Content-Encoding = %q, want gzip: %v", rec.Header().Get("Content-Encoding"), tt.wantGzip)
+			}
+
+			body := rec.Body.Bytes()
+			if gotGzip {
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body, err = io.ReadAll(zr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if string(body) != tt.body {
+				t.Errorf("body changed on the way through: got %d bytes, want %d", len(body), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestIsBotNetwork(t *testing.T) {
+	api := &APIV1{botNetworks: parseBotIPRanges([]string{"66.249.64.0/19", " 2001:4860:4801::/48 ", "not-a-range"})}
+	if len(api.botNetworks) != 2 {
+		t.Fatalf("parsed %d ranges, want 2 (the invalid one skipped)", len(api.botNetworks))
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"66.249.66.1", true}, // Googlebot
+		{"66.249.96.1", false},
+		{"2001:4860:4801:10::1", true},
+		{"2001:4860:4802::1", false},
+		{"203.0.113.7", false},
+		{"", false},
+		{"garbage", false},
+	}
+
+	for _, tt := range tests {
+		if got := api.isBotNetwork(tt.ip); got != tt.want {
+			t.Errorf("isBotNetwork(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestAddressValidationToken(t *testing.T) {
+	newAPI := func(tokenKey string) *APIV1 {
+		api := &APIV1{websiteConfig: &configs.WebsiteConfig{}, envConfig: &configs.EnvironmentConfig{}}
+		api.websiteConfig.Database.Name = "shop"
+		api.envConfig.HTTP.TokenKey = tokenKey
+		return api
+	}
+	fingerprint := addressFingerprint("1 Main St", "", "Springfield", "IL", "62701", "US")
+	api := newAPI("0123456789abcdef0123456789abcdef")
+
+	token := api.addressValidationToken(fingerprint, time.Now().Add(time.Hour))
+	if !api.validAddressToken(token, fingerprint) {
+		t.Errorf("token isn't valid for the address it was issued for")
+	}
+	if api.validAddressToken(token, addressFingerprint("2 Main St", "", "Springfield", "IL", "62701", "US")) {
+		t.Errorf("token is valid for another address")
+	}
+	if newAPI("fedcba9876543210fedcba9876543210").validAddressToken(token, fingerprint) {
+		t.Errorf("token is valid under another key")
+	}
+	if expired := api.addressValidationToken(fingerprint, time.Now().Add(-time.Minute)); api.validAddressToken(expired, fingerprint) {
+		t.Errorf("expired token is valid")
+	}
+
+	unkeyed := newAPI("")
+	if token := unkeyed.addressValidationToken(fingerprint, time.Now().Add(time.Hour)); token != "" {
+		t.Errorf("issued token %q without a key", token)
+	}
+	if unkeyed.validAddressToken(token, fingerprint) {
+		t.Errorf("token is valid without a key")
+	}
+}
+
+func TestRequestLimitsTimeout(t *testing.T) {
+	api := &APIV1{envConfig: &configs.EnvironmentConfig{}}
+	api.envConfig.HTTP.RequestTimeout = 1
+
+	t.Run("no response by the deadline", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		handler := api.requestLimits(Route{Path: "/api/v1/products"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			<-release
+			w.Header().Set("X-Late", "1")
+			io.WriteString(w, "too late")
+		}))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/products", nil))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+		}
+	})
+
+	t.Run("order and payment routes run to completion", func(t *testing.T) {
+		route := Route{Path: "/api/v1/checkout", Method: "POST", InternalHandler: "order"}
+		handler := api.requestLimits(route)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := r.Context().Deadline(); ok {
+				t.Errorf("checkout has a deadline")
+			}
+			time.Sleep(1100 * time.Millisecond)
+			io.WriteString(w, "placed")
+		}))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/checkout", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "placed" {
+			t.Errorf("got %d %q, want the handler's response", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("response started before the deadline", func(t *testing.T) {
+		flushed := make(chan bool, 1)
+		handler := api.requestLimits(Route{Path: "/api/v1/products"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "first ")
+			w.(http.Flusher).Flush()
+			flushed <- true
+			<-r.Context().Done()
+			io.WriteString(w, "second")
+		}))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/products", nil))
+		if !<-flushed || !rec.Flushed {
+			t.Errorf("the first write wasn't passed straight through")
+		}
+		if rec.Code != http.StatusOK || rec.Body.String() != "first second" {
+			t.Errorf("got %d %q, want the whole streamed response", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestStreamedListingETag(t *testing.T) {
+	api := &APIV1{
+		websiteConfig: &configs.WebsiteConfig{},
+		envConfig:     &configs.EnvironmentConfig{ProdMode: true},
+		catalogCache:  &catalogCache{entries: make(map[string]catalogCacheEntry)},
+	}
+	listing := func(w http.ResponseWriter, r *http.Request) {
+		api.streamJSON(w, r, []string{"a", "b"})
+	}
+
+	rec := httptest.NewRecorder()
+	listing(rec, httptest.NewRequest(http.MethodGet, "/api/v1/posts", nil))
+	if etag := rec.Header().Get("ETag"); etag != "" {
+		t.Errorf("streamed listing has ETag %q, want none", etag)
+	}
+	if got := rec.Body.String(); got != "[\"a\",\"b\"]\n" {
+		t.Errorf("body = %q", got)
+	}
+
+	cached := api.cachedCatalog(listing)
+	rec = httptest.NewRecorder()
+	cached(rec, httptest.NewRequest(http.MethodGet, "/api/v1/products", nil))
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("cached listing has no ETag")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/products", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	cached(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("revalidating with the cached ETag: status = %d, want %d", rec.Code, http.StatusNotModified)
+	}
+}
+
+func TestShippingCountries(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured []string
+		shipFrom   string
+		want       []string
+	}{
+		{"default", nil, "", []string{"US"}},
+		{"ship-from country", nil, "Canada", []string{"CA"}},
+		{"configured", []string{"us", "Canada", " "}, "GB", []string{"US", "CA"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &APIV1{websiteConfig: &configs.WebsiteConfig{}}
+			api.websiteConfig.Ecommerce.ShippingCountries = tt.configured
+			api.websiteConfig.ShipFrom.Country = tt.shipFrom
+
+			if got := api.shippingCountries(); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("shippingCountries() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
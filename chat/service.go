@@ -0,0 +1,147 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Field is one labelled value shown under a message, e.g. the order total
+type Field struct {
+	Name   string
+	Value  string
+	Inline bool // shown side by side with the other inline fields
+}
+
+// Message is a notification posted to a chat channel
+type Message struct {
+	Title  string
+	Text   string
+	URL    string // optional link for the title
+	Fields []Field
+}
+
+// Client posts messages to a Slack or Discord incoming webhook
+type Client struct {
+	WebhookURL string
+	HTTPClient *http.Client
+}
+
+// NewClient creates a new chat client for an incoming webhook URL
+func NewClient(webhookURL string) *Client {
+	return &Client{
+		WebhookURL: webhookURL,
+		HTTPClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// IsDiscord reports whether the webhook is a Discord one, which takes a different payload than Slack's
+func (c *Client) IsDiscord() bool {
+	u, err := url.Parse(c.WebhookURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return host == "discord.com" || host == "discordapp.com" || strings.HasSuffix(host, ".discord.com")
+}
+
+// Payload encodes the message as the webhook's JSON body, Slack's or Discord's format
+func (c *Client) Payload(msg Message) ([]byte, error) {
+	var payload interface{}
+	if c.IsDiscord() {
+		payload = discordPayload(msg)
+	} else {
+		payload = slackPayload(msg)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message: %w", err)
+	}
+	return body, nil
+}
+
+// Post sends the message to the webhook straight away, without the retries of the outbound queue
+func (c *Client) Post(msg Message) error {
+	body, err := c.Payload(msg)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.HTTPClient.Post(c.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	return nil
+}
+
+// slackPayload builds a Slack incoming webhook message with the fields as an attachment
+func slackPayload(msg Message) map[string]interface{} {
+	title := slackEscape(msg.Title)
+	if msg.URL != "" {
+		title = fmt.Sprintf("<%s|%s>", msg.URL, title)
+	}
+	text := "*" + title + "*"
+	if msg.Text != "" {
+		text += "\n" + slackEscape(msg.Text)
+	}
+
+	fields := make([]map[string]interface{}, len(msg.Fields))
+	for i, field := range msg.Fields {
+		fields[i] = map[string]interface{}{
+			"title": field.Name,
+			"value": slackEscape(field.Value),
+			"short": field.Inline,
+		}
+	}
+
+	payload := map[string]interface{}{"text": text}
+	if len(fields) > 0 {
+		payload["attachments"] = []map[string]interface{}{{"fields": fields}}
+	}
+	return payload
+}
+
+// slackEscape escapes the characters Slack treats as markup in message text
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// discordPayload builds a Discord webhook message with the fields as an embed
+func discordPayload(msg Message) map[string]interface{} {
+	fields := make([]map[string]interface{}, len(msg.Fields))
+	for i, field := range msg.Fields {
+		fields[i] = map[string]interface{}{
+			"name":   field.Name,
+			"value":  field.Value,
+			"inline": field.Inline,
+		}
+	}
+
+	embed := map[string]interface{}{
+		"title":  msg.Title,
+		"fields": fields,
+	}
+	if msg.Text != "" {
+		embed["description"] = msg.Text
+	}
+	if msg.URL != "" {
+		embed["url"] = msg.URL
+	}
+
+	return map[string]interface{}{"embeds": []map[string]interface{}{embed}}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/murdinc/stencil2/admin"
+	"github.com/murdinc/stencil2/configs"
+	"github.com/murdinc/stencil2/database"
+)
+
+// recomputeCmd represents the recompute command
+var recomputeCmd = &cobra.Command{
+	Use:   "recompute",
+	Short: "Recompute derived data",
+	Long: `Recomputes the data sites keep alongside what it's derived from (category post counts,
+product rating summaries, customer store credit balances and the daily analytics rollup), e.g.
+after a bad import. Every site is recomputed unless --site names one by its database name`,
+	Run: func(cmd *cobra.Command, args []string) {
+		recompute(RecomputeSite)
+	},
+}
+
+var RecomputeSite string
+
+func init() {
+	rootCmd.AddCommand(recomputeCmd)
+	// flags and configuration settings.
+	recomputeCmd.Flags().StringVarP(&RecomputeSite, "site", "s", "", "Database name of the only site to recompute")
+}
+
+func recompute(site string) {
+	envConfig, err := configs.ReadEnvironmentConfig(ProdMode, false)
+	if err != nil {
+		log.Fatalf("Failed to load the environment config: %v", err)
+	}
+
+	websiteConfigs, err := configs.ReadWebsiteConfigs(ProdMode)
+	if err != nil {
+		log.Fatalf("Failed to load site configs: %v", err)
+	}
+
+	found, failed := false, false
+	for _, websiteConfig := range websiteConfigs {
+		if websiteConfig.Database.Name == "" || (site != "" && websiteConfig.Database.Name != site) {
+			continue
+		}
+		found = true
+
+		dbConn := &database.DBConnection{}
+		err := dbConn.Connect(envConfig.Database.User, envConfig.Database.Password, envConfig.Database.Host, envConfig.Database.Port, websiteConfig.Database.Name, 10*time.Second)
+		if err != nil {
+			log.Printf("Recompute [%s] failed: %v", websiteConfig.Database.Name, err)
+			failed = true
+			continue
+		}
+
+		start := time.Now()
+		_, err = admin.RecomputeSite(context.Background(), database.NewTimedDB(dbConn.Database, dbConn.Name), websiteConfig.Timezone)
+		dbConn.Database.Close()
+		if err != nil {
+			log.Printf("Recompute [%s] failed: %v", websiteConfig.Database.Name, err)
+			failed = true
+			continue
+		}
+		log.Printf("Recompute [%s] finished in %v", websiteConfig.Database.Name, time.Since(start).Round(time.Millisecond))
+	}
+
+	if site != "" && !found {
+		log.Fatalf("No site uses the database %q", site)
+	}
+	if failed {
+		log.Fatal("Recompute failed for some sites")
+	}
+}
@@ -21,8 +21,11 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/murdinc/stencil2/admin"
+	"github.com/murdinc/stencil2/api"
 	"github.com/murdinc/stencil2/configs"
+	"github.com/murdinc/stencil2/database"
 	"github.com/murdinc/stencil2/frontend"
+	"github.com/murdinc/stencil2/metrics"
 	"github.com/murdinc/stencil2/utils"
 )
 
@@ -38,6 +41,9 @@ var serveCmd = &cobra.Command{
 
 var HideErrors bool
 
+// shutdownTimeout bounds how long in-flight requests and background jobs get to finish on SIGINT/SIGTERM
+const shutdownTimeout = 30 * time.Second
+
 func init() {
 	rootCmd.AddCommand(serveCmd)
 	// flags and configuration settings.
@@ -55,9 +61,32 @@ func serve() {
 		log.Fatalf("Failed to load the environment config: %v", err)
 	}
 
+	// Log slow database queries when a threshold is configured
+	if envConfig.Database.SlowQueryMs > 0 {
+		database.SetSlowQueryThreshold(time.Duration(envConfig.Database.SlowQueryMs) * time.Millisecond)
+		log.Printf("Logging database queries slower than %dms", envConfig.Database.SlowQueryMs)
+	}
+
+	// Record metrics from the start so the first requests are counted
+	if envConfig.Metrics.Enabled {
+		metrics.Enable()
+	}
+
+	configModified := false
+
+	// Check if the key for tokens the API signs needs to be generated
+	if len(envConfig.HTTP.TokenKey) != 32 {
+		configModified = true
+		tokenKey, err := utils.GenerateRandomKey(32)
+		if err != nil {
+			log.Fatalf("Failed to generate API token key: %v", err)
+		}
+		envConfig.HTTP.TokenKey = tokenKey
+		log.Println("Generated new API token key")
+	}
+
 	// Setup admin credentials and keys if needed
 	if envConfig.Admin.Enabled {
-		configModified := false
 
 		// Check if admin password needs to be set
 		if envConfig.Admin.Password == "" {
@@ -89,13 +118,24 @@ func serve() {
 			log.Println("Generated new CSRF key")
 		}
 
-		// Save config if modified
-		if configModified {
-			if err := configs.SaveEnvironmentConfig(&envConfig, ProdMode); err != nil {
-				log.Fatalf("Failed to save environment config: %v", err)
+		// Check if the key for signed private media links needs to be generated
+		if len(envConfig.Uploads.SigningKey) != 32 {
+			configModified = true
+			signingKey, err := utils.GenerateRandomKey(32)
+			if err != nil {
+				log.Fatalf("Failed to generate media signing key: %v", err)
 			}
-			log.Println("✓ Environment config updated and saved")
+			envConfig.Uploads.SigningKey = signingKey
+			log.Println("Generated new media signing key")
+		}
+	}
+
+	// Save config if modified
+	if configModified {
+		if err := configs.SaveEnvironmentConfig(&envConfig, ProdMode); err != nil {
+			log.Fatalf("Failed to save environment config: %v", err)
 		}
+		log.Println("✓ Environment config updated and saved")
 	}
 
 	// Read in the site configs
@@ -172,11 +212,6 @@ func serve() {
 		router := result.website.GetRouter()
 		hr.Map(result.website.WebsiteConfig.HTTP.Address, router())
 
-		// Defer database close
-		if result.website.DBConn.Connected {
-			defer result.website.DBConn.Database.Close()
-		}
-
 		// Start file watcher on dev
 		if ProdMode == false {
 			go result.website.StartWatcher()
@@ -185,18 +220,27 @@ func serve() {
 
 	log.Printf("✓ Successfully initialized all %d websites", len(websites))
 
+	// Background jobs (email polling etc.) run until shutdown cancels this context
+	bgCtx, cancelBackground := context.WithCancel(context.Background())
+	defer cancelBackground()
+
 	// Start admin server if enabled
+	var adminServer *admin.AdminServer
 	if envConfig.Admin.Enabled {
-		adminServer, err := admin.NewAdminServer(envConfig)
+		adminServer, err = admin.NewAdminServer(envConfig)
 		if err != nil {
 			log.Printf("Warning: Failed to start admin server: %v", err)
+			adminServer = nil
 		} else {
 			// Start email polling service
-			adminServer.StartEmailPolling()
+			adminServer.StartEmailPolling(bgCtx)
+
+			// Start scheduled sales and the analytics rollup
+			adminServer.StartScheduler(bgCtx)
 
 			// Start admin HTTP server
 			go func() {
-				if err := adminServer.Start(); err != nil {
+				if err := adminServer.Start(); err != nil && err != http.ErrServerClosed {
 					log.Printf("Admin server error: %v", err)
 				}
 			}()
@@ -232,6 +276,25 @@ func serve() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Metrics get their own listener, so they aren't served on every site's host
+	var metricsSrv *http.Server
+	if envConfig.Metrics.Enabled {
+		metricsRouter := chi.NewRouter()
+		metricsRouter.Handle("/metrics", metrics.Handler())
+		metricsSrv = &http.Server{
+			Addr:         envConfig.Metrics.Address,
+			Handler:      metricsRouter,
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+		}
+		go func() {
+			log.Printf("Metrics listening on %s/metrics", envConfig.Metrics.Address)
+			if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Metrics server error: %v", err)
+			}
+		}()
+	}
+
 	// Start server in goroutine
 	go func() {
 		log.Printf("HTTP server listening on %s", port)
@@ -247,14 +310,40 @@ func serve() {
 
 	log.Println("Shutting down server...")
 
-	// Give outstanding requests 10 seconds to complete
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Stop tickers first so no new background work starts during shutdown
+	log.Println("Stopping background jobs...")
+	cancelBackground()
+	api.StopBackgroundJobs()
+
+	// Give outstanding requests (including in-flight payment webhooks) time to complete
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
+	log.Println("Waiting for in-flight requests to finish...")
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	if metricsSrv != nil {
+		metricsSrv.Shutdown(ctx)
+	}
+
+	if adminServer != nil {
+		if err := adminServer.Shutdown(ctx); err != nil {
+			log.Printf("Admin server forced to shutdown: %v", err)
+		}
+	}
+
+	// Close database pools once no request can use them anymore
+	log.Println("Closing database connections...")
+	for _, website := range websites {
+		if website.DBConn.Connected {
+			if err := website.DBConn.Close(); err != nil {
+				log.Printf("[%s] Error closing database: %v", website.WebsiteConfig.SiteName, err)
+			}
+		}
+	}
+
 	log.Println("Server stopped gracefully")
 }
 
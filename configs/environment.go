@@ -20,21 +20,58 @@ type EnvironmentConfig struct {
 	HideErrors bool
 	BaseURL    string `json:"baseUrl"` // Base URL for webhooks (e.g., "https://example.com")
 	Database   struct {
-		Host     string `json:"host"`
-		User     string `json:"user"`
-		Port     string `json:"port"`
-		Password string `json:"password"`
+		Host        string `json:"host"`
+		User        string `json:"user"`
+		Port        string `json:"port"`
+		Password    string `json:"password"`
+		SlowQueryMs int    `json:"slowQueryMs"` // Log queries slower than this many milliseconds; 0 (default) turns it off
+		Replica     struct {
+			Host     string `json:"host"`     // Read replica for analytics reports and listings; everything uses the primary when empty
+			Port     string `json:"port"`     // Defaults to the primary's port
+			User     string `json:"user"`     // Defaults to the primary's user
+			Password string `json:"password"` // Defaults to the primary's password
+			Disabled bool   `json:"disabled"` // Send reads to the primary even though a replica is configured
+		} `json:"replica"`
 	} `json:"database"`
 	HTTP struct {
-		Port string `json:"port"`
+		Port               string `json:"port"`
+		MaxBodyBytes       int64  `json:"maxBodyBytes"`       // Max request body size for the public API (default 1 MB)
+		RequestTimeout     int    `json:"requestTimeout"`     // Per-request timeout for the public API in seconds (default 10)
+		DisableCompression bool   `json:"disableCompression"` // Turn off gzip for API and admin responses (e.g. when a proxy compresses)
+		TokenKey           string `json:"tokenKey"`           // 32-byte key for tokens the API signs, like address validation (auto-generated)
 	} `json:"http"`
+	GeoIP struct {
+		DatabasePath string `json:"databasePath"` // MaxMind GeoLite2/GeoIP2 City .mmdb file; defaults to a downloaded DB-IP City Lite database
+	} `json:"geoip"`
+	Analytics struct {
+		BotIPRanges []string `json:"botIPRanges"` // CIDR ranges of known crawlers; pageviews from them are flagged as bots
+	} `json:"analytics"`
+	Metrics struct {
+		Enabled bool   `json:"enabled"` // Record request, order and query metrics and serve them at /metrics
+		Address string `json:"address"` // Address the /metrics listener binds to (default 127.0.0.1:9100)
+	} `json:"metrics"`
+	Uploads struct {
+		Backend    string `json:"backend"`    // "local" (default) writes to websites/<dir>/public/uploads; "s3" uses the bucket below
+		SigningKey string `json:"signingKey"` // 32-byte key for signed links to private uploads (auto-generated)
+		S3         struct {
+			Bucket          string `json:"bucket"`
+			Region          string `json:"region"`
+			Endpoint        string `json:"endpoint"` // Optional, for S3-compatible services
+			AccessKeyID     string `json:"accessKeyId"`
+			SecretAccessKey string `json:"secretAccessKey"`
+			PublicURL       string `json:"publicUrl"` // Optional base URL uploads are served from (e.g. a CDN)
+			Prefix          string `json:"prefix"`    // Optional key prefix
+		} `json:"s3"`
+	} `json:"uploads"`
 	Admin struct {
-		Enabled    bool   `json:"enabled"`
-		Port       string `json:"port"`
-		Password   string `json:"password"`   // Password for the main "admin" user
-		SessionKey string `json:"sessionKey"` // 32-byte key for encrypting session cookies
-		CSRFKey    string `json:"csrfKey"`    // 32-byte key for CSRF token encryption
-		Users      []AdminUser `json:"users"` // Additional users with limited permissions
+		Enabled    bool        `json:"enabled"`
+		Port       string      `json:"port"`
+		Password   string      `json:"password"`   // Password for the main "admin" user
+		SessionKey string      `json:"sessionKey"` // 32-byte key for encrypting session cookies
+		CSRFKey    string      `json:"csrfKey"`    // 32-byte key for CSRF token encryption
+		BasePath   string      `json:"basePath"`   // Optional path prefix to mount the admin under (e.g. "/admin")
+		PublicURL  string      `json:"publicURL"`  // Scheme and host the admin is reached at (e.g. "https://admin.example.com"), for links in emails
+		Users      []AdminUser `json:"users"`      // Additional users with limited permissions
 	} `json:"admin"`
 }
 
@@ -70,6 +107,19 @@ func ReadEnvironmentConfig(prodMode bool, hideErrors bool) (EnvironmentConfig, e
 		envConfig.HTTP.Port = "80"
 	}
 
+	// default API request limits
+	if envConfig.HTTP.MaxBodyBytes == 0 {
+		envConfig.HTTP.MaxBodyBytes = 1 << 20
+	}
+	if envConfig.HTTP.RequestTimeout == 0 {
+		envConfig.HTTP.RequestTimeout = 10
+	}
+
+	// default metrics listener, local only
+	if envConfig.Metrics.Address == "" {
+		envConfig.Metrics.Address = "127.0.0.1:9100"
+	}
+
 	// default admin port
 	if envConfig.Admin.Port == "" {
 		envConfig.Admin.Port = "8081"
@@ -78,6 +128,27 @@ func ReadEnvironmentConfig(prodMode bool, hideErrors bool) (EnvironmentConfig, e
 	return envConfig, nil
 }
 
+// ReadReplica returns the read replica's host, port, user and password, taking any that aren't set
+// from the primary. ok is false when no replica is configured or it's disabled
+func (envConfig *EnvironmentConfig) ReadReplica() (host, port, user, password string, ok bool) {
+	replica := envConfig.Database.Replica
+	if replica.Host == "" || replica.Disabled {
+		return "", "", "", "", false
+	}
+
+	host, port, user, password = replica.Host, replica.Port, replica.User, replica.Password
+	if port == "" {
+		port = envConfig.Database.Port
+	}
+	if user == "" {
+		user = envConfig.Database.User
+	}
+	if password == "" {
+		password = envConfig.Database.Password
+	}
+	return host, port, user, password, true
+}
+
 // SaveEnvironmentConfig saves the environment config to disk
 func SaveEnvironmentConfig(envConfig *EnvironmentConfig, prodMode bool) error {
 	configName := "env-dev.json"
@@ -93,6 +164,7 @@ func SaveEnvironmentConfig(envConfig *EnvironmentConfig, prodMode bool) error {
 		"database": envConfig.Database,
 		"http":     envConfig.HTTP,
 		"admin":    envConfig.Admin,
+		"uploads":  envConfig.Uploads,
 	}
 
 	// Marshal config to JSON with indentation
@@ -0,0 +1,202 @@
+package configs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SecretsKeyEnv is the environment variable holding the master key for encrypting site secrets.
+// Any string works; it's hashed into an AES-256 key. When it isn't set, secrets are stored as
+// plain text like before
+const SecretsKeyEnv = "STENCIL_SECRETS_KEY"
+
+// encryptedPrefix marks an encrypted config value, so plain text values from older configs can
+// still be told apart and read as they are
+const encryptedPrefix = "enc:v1:"
+
+// SecretConfigPaths are the site config values that get encrypted, as nested JSON key paths
+var SecretConfigPaths = [][]string{
+	{"stripe", "secretKey"},
+	{"stripe", "webhookSecret"},
+	{"shippo", "apiKey"},
+	{"twilio", "authToken"},
+	{"email", "imap", "password"},
+	{"email", "smtp", "password"},
+	{"notifications", "chatWebhookURL"},
+}
+
+// secretsKey returns the AES key derived from SecretsKeyEnv, or nil when it isn't set
+func secretsKey() []byte {
+	master := os.Getenv(SecretsKeyEnv)
+	if master == "" {
+		return nil
+	}
+	key := sha256.Sum256([]byte(master))
+	return key[:]
+}
+
+// SecretsEncryptionEnabled reports whether a master key is set, so saved secrets are encrypted
+func SecretsEncryptionEnabled() bool {
+	return os.Getenv(SecretsKeyEnv) != ""
+}
+
+// IsEncryptedSecret reports whether value was written by EncryptSecret
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// EncryptSecret encrypts value with AES-GCM under the master key. Empty values, values that are
+// already encrypted, and every value when no master key is set are returned unchanged
+func EncryptSecret(value string) (string, error) {
+	key := secretsKey()
+	if key == nil || value == "" || IsEncryptedSecret(value) {
+		return value, nil
+	}
+
+	gcm, err := secretsCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(value), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret returns the plain text of a value from EncryptSecret. Unencrypted values are
+// returned as they are. Errors never include the value
+func DecryptSecret(value string) (string, error) {
+	if !IsEncryptedSecret(value) {
+		return value, nil
+	}
+
+	key := secretsKey()
+	if key == nil {
+		return "", fmt.Errorf("config has encrypted secrets but %s isn't set", SecretsKeyEnv)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return "", errors.New("encrypted secret isn't valid base64")
+	}
+
+	gcm, err := secretsCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted secret is too short")
+	}
+
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("can't decrypt secret, check %s", SecretsKeyEnv)
+	}
+	return string(plain), nil
+}
+
+func secretsCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// DecryptSecretFields decrypts each field in place. A field that can't be decrypted keeps its
+// stored value, so saving the config again doesn't lose it, and the first error is returned
+func DecryptSecretFields(fields ...*string) error {
+	var firstErr error
+	for _, field := range fields {
+		plain, err := DecryptSecret(*field)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		*field = plain
+	}
+	return firstErr
+}
+
+// EncryptConfigSecrets encrypts the SecretConfigPaths string values, and each outbound webhook's
+// secret, in a decoded config, in place
+func EncryptConfigSecrets(config map[string]interface{}) error {
+	for _, path := range SecretConfigPaths {
+		section := config
+		for _, key := range path[:len(path)-1] {
+			child, ok := section[key].(map[string]interface{})
+			if !ok {
+				section = nil
+				break
+			}
+			section = child
+		}
+		if section == nil {
+			continue
+		}
+
+		field := path[len(path)-1]
+		value, ok := section[field].(string)
+		if !ok {
+			continue
+		}
+
+		encrypted, err := EncryptSecret(value)
+		if err != nil {
+			return fmt.Errorf("encrypting %s: %v", strings.Join(path, "."), err)
+		}
+		section[field] = encrypted
+	}
+
+	// Each of notifications.webhooks has its own secret
+	if notifications, ok := config["notifications"].(map[string]interface{}); ok {
+		webhooks, _ := notifications["webhooks"].([]interface{})
+		for i, webhook := range webhooks {
+			fields, ok := webhook.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			value, ok := fields["secret"].(string)
+			if !ok {
+				continue
+			}
+			encrypted, err := EncryptSecret(value)
+			if err != nil {
+				return fmt.Errorf("encrypting notifications.webhooks[%d].secret: %v", i, err)
+			}
+			fields["secret"] = encrypted
+		}
+	}
+	return nil
+}
+
+// DecryptSecrets decrypts the config's secret fields in place
+func (c *WebsiteConfig) DecryptSecrets() error {
+	fields := []*string{
+		&c.Stripe.SecretKey,
+		&c.Stripe.WebhookSecret,
+		&c.Shippo.APIKey,
+		&c.Twilio.AuthToken,
+		&c.Email.IMAP.Password,
+		&c.Email.SMTP.Password,
+		&c.Notifications.ChatWebhookURL,
+	}
+	// Webhook secrets are in a list, which SecretConfigPaths can't name
+	for i := range c.Notifications.Webhooks {
+		fields = append(fields, &c.Notifications.Webhooks[i].Secret)
+	}
+	return DecryptSecretFields(fields...)
+}
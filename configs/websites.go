@@ -18,11 +18,14 @@ type WebsiteConfig struct {
 	} `json:"database"`
 	MediaProxyURL string `json:"mediaProxyUrl"`
 	HTTP          struct {
-		Address string `json:"address"`
+		Address             string   `json:"address"`
+		AllowedOrigins      []string `json:"allowedOrigins"`      // Origins allowed to call /api/v1 cross-origin (CORS), "*" for any; same-origin only when empty
+		CatalogCacheSeconds int      `json:"catalogCacheSeconds"` // How long product and collection API reads are cached in memory; 0 for 60, -1 for no cache
 	} `json:"http"`
 	Stripe struct {
 		PublishableKey string `json:"publishableKey"`
 		SecretKey      string `json:"secretKey"`
+		WebhookSecret  string `json:"webhookSecret"` // whsec_... signing secret for the webhook endpoint
 	} `json:"stripe"`
 	Shippo struct {
 		APIKey      string `json:"apiKey"`
@@ -52,14 +55,80 @@ type WebsiteConfig struct {
 			UseTLS   bool   `json:"useTLS"`   // usually true for Gmail
 		} `json:"smtp"`
 	} `json:"email"`
+	Locales struct {
+		Default   string   `json:"default"`   // language the content is written in, e.g. "en"
+		Supported []string `json:"supported"` // translations the API serves; any locale with a translation when empty
+	} `json:"locales"`
 	Ecommerce struct {
-		TaxRate      float64 `json:"taxRate"`      // e.g., 0.08 for 8%
-		ShippingCost float64 `json:"shippingCost"` // flat rate shipping cost
+		TaxRate           float64 `json:"taxRate"`           // e.g., 0.08 for 8%
+		TaxInclusive      bool    `json:"taxInclusive"`      // prices already include tax, which is backed out rather than added
+		TaxRounding       string  `json:"taxRounding"`       // how half cents round, "halfUp" (default) or "halfEven"
+		ShippingCost      float64 `json:"shippingCost"`      // flat rate shipping cost
+		MinOrderAmount    float64 `json:"minOrderAmount"`    // minimum cart subtotal, 0 for none
+		OrderNumberPrefix string  `json:"orderNumberPrefix"` // e.g., "ORD-", defaults to ORD-
+		Currency          string  `json:"currency"`          // ISO 4217 code prices are shown in and charged in, defaults to USD
+		ManualCapture     bool    `json:"manualCapture"`     // authorize at checkout, capture when the order ships
+		StaleOrderDays    int     `json:"staleOrderDays"`    // days a paid order can go unshipped before the admin flags it, default 3
+		RestockReturns    bool    `json:"restockReturns"`    // returned items are marked resellable, and restocked, by default when received
+
+		// OrderDigest replaces the admin's per-order notification email with one email listing the
+		// orders placed since the last digest, sent every IntervalHours (default 24)
+		OrderDigest struct {
+			Enabled       bool `json:"enabled"`
+			IntervalHours int  `json:"intervalHours"`
+		} `json:"orderDigest"`
+
+		// RecentlyViewedLimit caps how many products /api/v1/recently-viewed remembers per session, default 10
+		RecentlyViewedLimit int `json:"recentlyViewedLimit"`
+
+		// OrderConfirmMinutes is how long the confirmation_token createOrder returns can be exchanged
+		// at /api/v1/order/confirm/{token}, default 30
+		OrderConfirmMinutes int `json:"orderConfirmMinutes"`
+
+		// ShippingCountries are the ISO country codes hosted Checkout collects shipping addresses
+		// for, defaults to the shipFrom country (or US when that isn't set)
+		ShippingCountries []string `json:"shippingCountries"`
+
+		// RequireAddressValidation rejects orders whose shipping address wasn't first checked with
+		// /api/v1/validate-address; the order must carry the token that endpoint returns
+		RequireAddressValidation bool `json:"requireAddressValidation"`
+
+		// Risk tunes the fraud-risk score orders get when they're created
+		Risk struct {
+			LargeOrderAmount    float64  `json:"largeOrderAmount"`    // a first order with a subtotal of at least this is riskier, default 500
+			BlockedEmailDomains []string `json:"blockedEmailDomains"` // email domains (e.g. disposable mail services) that make an order riskier
+			ReviewScore         int      `json:"reviewScore"`         // orders scoring at least this are flagged for review in the admin, default 50
+		} `json:"risk"`
+
+		// Goals are the monthly targets the admin dashboard tracks progress toward, 0 for none
+		Goals struct {
+			MonthlyRevenue float64 `json:"monthlyRevenue"` // paid revenue per calendar month
+			MonthlyOrders  int     `json:"monthlyOrders"`  // paid orders per calendar month
+		} `json:"goals"`
 	} `json:"ecommerce"`
+
+	// Notifications posts new paid orders, and optionally low stock, to a Slack or Discord channel,
+	// and sends the site's events to its own webhooks. Both go through the outbound queue, which
+	// retries failed deliveries
+	Notifications struct {
+		ChatWebhookURL    string `json:"chatWebhookURL"`    // Slack or Discord incoming webhook, empty to turn off
+		LowStock          bool   `json:"lowStock"`          // also post when an order leaves a product with little stock
+		LowStockThreshold int    `json:"lowStockThreshold"` // stock at or below this is low, default 5
+		MaxAttempts       int    `json:"maxAttempts"`       // tries before a delivery is dead-lettered, default 6
+
+		// Webhooks get the events they list as signed JSON POSTs, e.g. to another store or an ERP
+		Webhooks []OutboundWebhook `json:"webhooks"`
+	} `json:"notifications"`
 	EarlyAccess struct {
 		Enabled  bool   `json:"enabled"`
 		Password string `json:"password"`
 	} `json:"earlyAccess"`
+	// Maintenance takes the site down for visitors while the API, and so webhooks, keep working
+	Maintenance struct {
+		Enabled    bool   `json:"enabled"`
+		Message    string `json:"message"`    // shown on the maintenance page
+		RetryAfter int    `json:"retryAfter"` // seconds sent in the Retry-After header, default 3600
+	} `json:"maintenance"`
 	ShipFrom struct {
 		Name    string `json:"name"`
 		Street1 string `json:"street1"`
@@ -74,6 +143,28 @@ type WebsiteConfig struct {
 	Directory string
 }
 
+// OutboundWebhook is one of a site's own webhooks
+type OutboundWebhook struct {
+	Name        string   `json:"name"`        // shown in the admin's failed deliveries, must be unique
+	URL         string   `json:"url"`         // https endpoint the events are POSTed to
+	Secret      string   `json:"secret"`      // signs each payload in the X-Stencil-Signature header
+	Events      []string `json:"events"`      // events to send, e.g. "order.placed"; all when empty
+	MaxAttempts int      `json:"maxAttempts"` // overrides notifications.maxAttempts for this webhook
+}
+
+// Subscribed reports whether the webhook wants the event
+func (w OutboundWebhook) Subscribed(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
 func ReadWebsiteConfigs(prodMode bool) ([]WebsiteConfig, error) {
 	var websiteConfigs []WebsiteConfig
 
@@ -109,6 +200,11 @@ func ReadWebsiteConfigs(prodMode bool) ([]WebsiteConfig, error) {
 				return fmt.Errorf("failed to parse config file in directory %s: %v", path, err)
 			}
 
+			// Decrypt secrets stored encrypted; the site still loads if the key is missing
+			if err := websiteConfig.DecryptSecrets(); err != nil {
+				log.Printf("Warning: secrets in %s could not be decrypted: %v", configPath, err)
+			}
+
 			// default API version (1)
 			if websiteConfig.APIVersion == 0 {
 				websiteConfig.APIVersion = 1
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/murdinc/stencil2/utils"
 )
 
 // InitAnalyticsTables creates analytics tables if they don't exist
@@ -30,6 +32,9 @@ func (db *DBConnection) InitAnalyticsTables() error {
 			city VARCHAR(100) DEFAULT NULL,
 			latitude DECIMAL(10, 8) DEFAULT NULL,
 			longitude DECIMAL(11, 8) DEFAULT NULL,
+			device_type VARCHAR(10) DEFAULT NULL,
+			browser VARCHAR(50) DEFAULT NULL,
+			is_bot TINYINT(1) NOT NULL DEFAULT 0,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			time_on_page INT DEFAULT 0,
 			INDEX idx_visitor_id (visitor_id),
@@ -45,6 +50,22 @@ func (db *DBConnection) InitAnalyticsTables() error {
 			INDEX idx_country_created (country_code, created_at)
 		)`,
 
+		// Analytics - Daily rollup of the traffic time series, per UTC offset since days depend on
+		// the site's timezone. The all_ columns include bot traffic
+		`CREATE TABLE IF NOT EXISTS analytics_daily (
+			date DATE NOT NULL,
+			utc_offset VARCHAR(6) NOT NULL,
+			pageviews INT NOT NULL DEFAULT 0,
+			visitors INT NOT NULL DEFAULT 0,
+			sessions INT NOT NULL DEFAULT 0,
+			all_pageviews INT NOT NULL DEFAULT 0,
+			all_visitors INT NOT NULL DEFAULT 0,
+			all_sessions INT NOT NULL DEFAULT 0,
+			revenue DECIMAL(12, 2) NOT NULL DEFAULT 0,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			PRIMARY KEY (utc_offset, date)
+		)`,
+
 		// Analytics - Custom Events
 		`CREATE TABLE IF NOT EXISTS analytics_events (
 			id BIGINT PRIMARY KEY AUTO_INCREMENT,
@@ -69,17 +90,59 @@ func (db *DBConnection) InitAnalyticsTables() error {
 		}
 	}
 
+	// Pageviews recorded before is_bot existed are flagged from their device type once it's added
+	var hasBotColumn int
+	err := db.Database.QueryRow(`
+		SELECT COUNT(*) FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'analytics_pageviews' AND COLUMN_NAME = 'is_bot'
+	`).Scan(&hasBotColumn)
+	if err != nil {
+		return fmt.Errorf("failed to migrate analytics table: %v", err)
+	}
+
+	// Columns added after the original schema, for existing databases. Older pageviews keep NULL
+	// and are classified by screen width when reporting
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"analytics_pageviews", "device_type", "VARCHAR(10) DEFAULT NULL"},
+		{"analytics_pageviews", "browser", "VARCHAR(50) DEFAULT NULL"},
+		{"analytics_pageviews", "is_bot", "TINYINT(1) NOT NULL DEFAULT 0"},
+	}
+
+	for _, c := range columns {
+		err := db.AddColumnIfMissing(c.table, c.column, c.definition)
+		if err != nil {
+			return fmt.Errorf("failed to migrate analytics table: %v", err)
+		}
+	}
+
+	if hasBotColumn == 0 {
+		_, err = db.Database.Exec(`UPDATE analytics_pageviews SET is_bot = 1 WHERE device_type = 'bot'`)
+		if err != nil {
+			return fmt.Errorf("failed to flag bot pageviews: %v", err)
+		}
+	}
+
 	return nil
 }
 
-// TrackPageView records a page view and returns the pageview ID
-func (db *DBConnection) TrackPageView(visitorID, sessionID, path, referrer, userAgent, ipAddress string, screenWidth, screenHeight int, country, countryCode, region, city string, latitude, longitude *float64) (int64, error) {
+// TrackPageView records a page view and returns the pageview ID. Crawler user agents and requests
+// from known bot networks (botNetwork) are stored with is_bot set so reports can leave them out
+func (db *DBConnection) TrackPageView(visitorID, sessionID, path, referrer, userAgent, ipAddress string, screenWidth, screenHeight int, country, countryCode, region, city string, latitude, longitude *float64, botNetwork bool) (int64, error) {
+	// Classify once at write time so reports can group on plain columns
+	deviceType := utils.ClassifyDevice(userAgent, screenWidth)
+	browser := utils.ClassifyBrowser(userAgent)
+	isBot := botNetwork || deviceType == utils.DeviceBot
+
 	sqlQuery := `
 		INSERT INTO analytics_pageviews
-		(visitor_id, session_id, path, referrer, user_agent, ip_address, screen_width, screen_height, country, country_code, region, city, latitude, longitude)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		(visitor_id, session_id, path, referrer, user_agent, ip_address, screen_width, screen_height, country, country_code, region, city, latitude, longitude, device_type, browser, is_bot)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?, ?)
 	`
-	result, err := db.ExecuteQuery(sqlQuery, visitorID, sessionID, path, referrer, userAgent, ipAddress, screenWidth, screenHeight, country, countryCode, region, city, latitude, longitude)
+	result, err := db.ExecuteQuery(sqlQuery, visitorID, sessionID, path, referrer, userAgent, ipAddress, screenWidth, screenHeight, country, countryCode, region, city, latitude, longitude, deviceType, browser, isBot)
 	if err != nil {
 		return 0, err
 	}
@@ -130,7 +193,7 @@ func (db *DBConnection) GetPageViewStats(startDate, endDate time.Time) (map[stri
 
 	// Total pageviews
 	var totalViews int
-	err := db.QueryRow(`
+	err := db.ReadRow(`
 		SELECT COUNT(*) FROM analytics_pageviews
 		WHERE created_at BETWEEN ? AND ?
 	`, startDate, endDate).Scan(&totalViews)
@@ -141,7 +204,7 @@ func (db *DBConnection) GetPageViewStats(startDate, endDate time.Time) (map[stri
 
 	// Unique visitors (by visitor_id, not session_id)
 	var uniqueVisitors int
-	err = db.QueryRow(`
+	err = db.ReadRow(`
 		SELECT COUNT(DISTINCT visitor_id) FROM analytics_pageviews
 		WHERE created_at BETWEEN ? AND ?
 	`, startDate, endDate).Scan(&uniqueVisitors)
@@ -152,7 +215,7 @@ func (db *DBConnection) GetPageViewStats(startDate, endDate time.Time) (map[stri
 
 	// Unique sessions
 	var uniqueSessions int
-	err = db.QueryRow(`
+	err = db.ReadRow(`
 		SELECT COUNT(DISTINCT session_id) FROM analytics_pageviews
 		WHERE created_at BETWEEN ? AND ?
 	`, startDate, endDate).Scan(&uniqueSessions)
@@ -175,7 +238,7 @@ func (db *DBConnection) GetTopPages(startDate, endDate time.Time, limit int) ([]
 		LIMIT ?
 	`
 
-	rows, err := db.Database.Query(sqlQuery, startDate, endDate, limit)
+	rows, err := db.ReadRows(sqlQuery, startDate, endDate, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -212,7 +275,7 @@ func (db *DBConnection) GetTopReferrers(startDate, endDate time.Time, limit int)
 		LIMIT ?
 	`
 
-	rows, err := db.Database.Query(sqlQuery, startDate, endDate, limit)
+	rows, err := db.ReadRows(sqlQuery, startDate, endDate, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -246,7 +309,7 @@ func (db *DBConnection) GetEventStats(startDate, endDate time.Time, limit int) (
 		LIMIT ?
 	`
 
-	rows, err := db.Database.Query(sqlQuery, startDate, endDate, limit)
+	rows, err := db.ReadRows(sqlQuery, startDate, endDate, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -290,7 +353,7 @@ func (db *DBConnection) GetLocationStats(startDate, endDate time.Time) ([]map[st
 		ORDER BY pageviews DESC
 	`
 
-	rows, err := db.Database.Query(sqlQuery, startDate, endDate)
+	rows, err := db.ReadRows(sqlQuery, startDate, endDate)
 	if err != nil {
 		return nil, err
 	}
@@ -336,7 +399,7 @@ func (db *DBConnection) GetTopCountries(startDate, endDate time.Time, limit int)
 		LIMIT ?
 	`
 
-	rows, err := db.Database.Query(sqlQuery, startDate, endDate, limit)
+	rows, err := db.ReadRows(sqlQuery, startDate, endDate, limit)
 	if err != nil {
 		return nil, err
 	}
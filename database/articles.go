@@ -17,10 +17,12 @@ func (db *DBConnection) InitArticleTables() error {
 			name VARCHAR(255) NOT NULL,
 			slug VARCHAR(255) UNIQUE NOT NULL,
 			description TEXT,
+			parent_id INT DEFAULT NULL,
 			count INT DEFAULT 0,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-			INDEX idx_slug (slug)
+			INDEX idx_slug (slug),
+			INDEX idx_parent_id (parent_id)
 		)`,
 
 		// Authors table
@@ -139,6 +141,28 @@ func (db *DBConnection) InitArticleTables() error {
 			"UNIQUE INDEX idx_year_month (`year_month`)" +
 		")",
 
+		// Article text in other languages, see TranslatePost
+		`CREATE TABLE IF NOT EXISTS article_translations (
+			article_id INT NOT NULL,
+			locale VARCHAR(20) NOT NULL,
+			title VARCHAR(500) NOT NULL DEFAULT '',
+			description TEXT,
+			deck TEXT,
+			content LONGTEXT,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			PRIMARY KEY (article_id, locale)
+		)`,
+
+		// Deleted articles, products and collections, for GetContentChanges
+		`CREATE TABLE IF NOT EXISTS content_deletions (
+			content_type VARCHAR(20) NOT NULL,
+			content_id INT NOT NULL,
+			slug VARCHAR(500) NOT NULL DEFAULT '',
+			deleted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (content_type, content_id),
+			INDEX idx_deleted_at (deleted_at)
+		)`,
+
 		// Article duplicates for slides
 		`CREATE TABLE IF NOT EXISTS article_duplicates_slides (
 			id INT PRIMARY KEY AUTO_INCREMENT,
@@ -158,5 +182,21 @@ func (db *DBConnection) InitArticleTables() error {
 		}
 	}
 
+	// Columns added after the original schema, for existing databases
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"categories_unified", "parent_id", "INT DEFAULT NULL"},
+	}
+
+	for _, c := range columns {
+		err := db.AddColumnIfMissing(c.table, c.column, c.definition)
+		if err != nil {
+			return fmt.Errorf("failed to migrate article table: %v", err)
+		}
+	}
+
 	return nil
 }
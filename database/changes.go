@@ -0,0 +1,118 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/murdinc/stencil2/structs"
+)
+
+// Content types in content_deletions and /api/v1/changes
+const (
+	ContentTypeProduct    = "product"
+	ContentTypeArticle    = "article"
+	ContentTypeCollection = "collection"
+)
+
+// changeSources are the tables GetContentChanges reads, with the content type each holds
+var changeSources = []struct {
+	contentType string
+	table       string
+}{
+	{ContentTypeProduct, "products_unified"},
+	{ContentTypeArticle, "articles_unified"},
+	{ContentTypeCollection, "collections_unified"},
+}
+
+// GetContentChanges lists the products, articles and collections updated at or after since, and
+// those deleted or unpublished since then. A zero since lists everything published and no
+// deletions, for a first full sync. NextSince is the newest change seen, so polling with it again
+// repeats only changes made in that same second
+func (db *DBConnection) GetContentChanges(since time.Time) (structs.ContentChanges, error) {
+	since = since.UTC().Truncate(time.Second)
+	changes := structs.ContentChanges{
+		Since:       since,
+		NextSince:   since,
+		Products:    []structs.ContentChange{},
+		Articles:    []structs.ContentChange{},
+		Collections: []structs.ContentChange{},
+		Deleted:     []structs.ContentDeletion{},
+	}
+	sinceValue := since.Format("2006-01-02 15:04:05")
+
+	for _, source := range changeSources {
+		rows, err := db.QueryRows(fmt.Sprintf(`
+			SELECT id, slug, updated_at, status = 'published'
+			FROM %s
+			WHERE updated_at >= ?
+			ORDER BY updated_at, id
+		`, source.table), sinceValue)
+		if err != nil {
+			return changes, err
+		}
+
+		for rows.Next() {
+			var change structs.ContentChange
+			var published bool
+			if err := rows.Scan(&change.ID, &change.Slug, &change.UpdatedAt, &published); err != nil {
+				rows.Close()
+				return changes, err
+			}
+			if change.UpdatedAt.After(changes.NextSince) {
+				changes.NextSince = change.UpdatedAt
+			}
+
+			if !published {
+				// A first sync has nothing to drop
+				if !since.IsZero() {
+					changes.Deleted = append(changes.Deleted, structs.ContentDeletion{
+						Type: source.contentType, ID: change.ID, Slug: change.Slug, DeletedAt: change.UpdatedAt,
+					})
+				}
+				continue
+			}
+
+			switch source.contentType {
+			case ContentTypeProduct:
+				changes.Products = append(changes.Products, change)
+			case ContentTypeArticle:
+				changes.Articles = append(changes.Articles, change)
+			case ContentTypeCollection:
+				changes.Collections = append(changes.Collections, change)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return changes, err
+		}
+	}
+
+	if since.IsZero() {
+		return changes, nil
+	}
+
+	rows, err := db.QueryRows(`
+		SELECT content_type, content_id, slug, deleted_at
+		FROM content_deletions
+		WHERE deleted_at >= ?
+		ORDER BY deleted_at, content_id
+	`, sinceValue)
+	if err != nil {
+		return changes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var deletion structs.ContentDeletion
+		if err := rows.Scan(&deletion.Type, &deletion.ID, &deletion.Slug, &deletion.DeletedAt); err != nil {
+			return changes, err
+		}
+		if deletion.DeletedAt.After(changes.NextSince) {
+			changes.NextSince = deletion.DeletedAt
+		}
+		changes.Deleted = append(changes.Deleted, deletion)
+	}
+
+	return changes, rows.Err()
+}
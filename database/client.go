@@ -11,7 +11,9 @@ import (
 
 type DBConnection struct {
 	Database  *sql.DB
+	Replica   *sql.DB // read replica for the read-only methods, nil when reads use Database
 	Connected bool
+	Name      string // database name, used when logging slow queries
 }
 
 // Connect initializes the database connection and waits for it to become available or times out after a specified duration
@@ -22,18 +24,13 @@ func (dbConn *DBConnection) Connect(username, password, host, port, dbName strin
 		return nil
 	}
 
-	connectionString := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true", username, password, host, port, dbName)
+	dbConn.Name = dbName
 	var err error
-	dbConn.Database, err = sql.Open("mysql", connectionString)
+	dbConn.Database, err = openPool(username, password, host, port, dbName)
 	if err != nil {
 		return err
 	}
 
-	// Configure connection pool
-	dbConn.Database.SetMaxOpenConns(25)
-	dbConn.Database.SetMaxIdleConns(5)
-	dbConn.Database.SetConnMaxLifetime(5 * time.Minute)
-
 	startTime := time.Now()
 	for {
 		err = dbConn.Database.Ping()
@@ -51,9 +48,90 @@ func (dbConn *DBConnection) Connect(username, password, host, port, dbName strin
 	}
 }
 
+// ConnectReplica opens a second pool on a read replica of the same database, which the read-only
+// methods (see reader) use from then on. If the replica can't be reached reads stay on the primary
+func (dbConn *DBConnection) ConnectReplica(username, password, host, port string) {
+	if !dbConn.Connected {
+		return
+	}
+
+	replica, err := openPool(username, password, host, port, dbConn.Name)
+	if err == nil {
+		err = replica.Ping()
+	}
+	if err != nil {
+		log.Printf("Read replica for [%s] unavailable, reading from the primary: %v", dbConn.Name, err)
+		if replica != nil {
+			replica.Close()
+		}
+		return
+	}
+
+	log.Printf("Connected to the read replica: [%s] on %s", dbConn.Name, host)
+	dbConn.Replica = replica
+}
+
+// openPool opens a connection pool to a database
+func openPool(username, password, host, port, dbName string) (*sql.DB, error) {
+	connectionString := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true", username, password, host, port, dbName)
+	db, err := sql.Open("mysql", connectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	// Configure connection pool
+	db.SetMaxOpenConns(25)
+	db.SetMaxIdleConns(5)
+	db.SetConnMaxLifetime(5 * time.Minute)
+	return db, nil
+}
+
+// reader is the pool for read-only queries: the replica when there is one, otherwise the primary.
+// Replicas lag behind, so only post listings and reports read from it, never anything a write has
+// to see straight away (carts, orders, stock checks). Product and collection reads stay on the
+// primary too: the API's catalog cache keeps what they return, so a fill from a lagging replica
+// right after an edit would be served until it expires
+func (dbConn *DBConnection) reader() *sql.DB {
+	if dbConn.Replica != nil {
+		return dbConn.Replica
+	}
+	return dbConn.Database
+}
+
+// ReadRow is QueryRow on the read replica, for read-only methods
+func (dbConn *DBConnection) ReadRow(query string, args ...interface{}) *sql.Row {
+	started := time.Now()
+	row := dbConn.reader().QueryRow(query, args...)
+	logIfSlow(dbConn.Name, query, started)
+	return row
+}
+
+// ReadRows is QueryRows on the read replica, for read-only methods
+func (dbConn *DBConnection) ReadRows(query string, args ...interface{}) (*sql.Rows, error) {
+	started := time.Now()
+	rows, err := dbConn.reader().Query(query, args...)
+	logIfSlow(dbConn.Name, query, started)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Close closes the primary pool and the replica's, if there is one
+func (dbConn *DBConnection) Close() error {
+	if dbConn.Replica != nil {
+		if err := dbConn.Replica.Close(); err != nil {
+			log.Printf("Error closing the read replica for [%s]: %v", dbConn.Name, err)
+		}
+	}
+	return dbConn.Database.Close()
+}
+
 // ExecuteQuery executes a single SQL query
 func (dbConn *DBConnection) ExecuteQuery(query string, args ...interface{}) (sql.Result, error) {
+	started := time.Now()
 	result, err := dbConn.Database.Exec(query, args...)
+	logIfSlow(dbConn.Name, query, started)
 	if err != nil {
 		return nil, err
 	}
@@ -63,16 +141,55 @@ func (dbConn *DBConnection) ExecuteQuery(query string, args ...interface{}) (sql
 
 // QueryRow executes a query that is expected to return a single row
 func (dbConn *DBConnection) QueryRow(query string, args ...interface{}) *sql.Row {
+	started := time.Now()
 	row := dbConn.Database.QueryRow(query, args...)
+	logIfSlow(dbConn.Name, query, started)
 	return row
 }
 
 // QueryRows executes a query that is expected to return multiple rows
 func (dbConn *DBConnection) QueryRows(query string, args ...interface{}) (*sql.Rows, error) {
+	started := time.Now()
 	rows, err := dbConn.Database.Query(query, args...)
+	logIfSlow(dbConn.Name, query, started)
 	if err != nil {
 		return nil, err
 	}
 	return rows, nil
 }
 
+// AddColumnIfMissing adds a column to an existing table if it doesn't already have it
+func (dbConn *DBConnection) AddColumnIfMissing(table, column, definition string) error {
+	var count int
+	err := dbConn.Database.QueryRow(`
+		SELECT COUNT(*) FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?
+	`, table, column).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	_, err = dbConn.Database.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// AddIndexIfMissing adds an index to an existing table if it doesn't already have one by that name
+func (dbConn *DBConnection) AddIndexIfMissing(table, index, definition string) error {
+	var count int
+	err := dbConn.Database.QueryRow(`
+		SELECT COUNT(*) FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?
+	`, table, index).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	_, err = dbConn.Database.Exec(fmt.Sprintf("ALTER TABLE %s ADD %s", table, definition))
+	return err
+}
@@ -1,13 +1,20 @@
 package database
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"strings"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/murdinc/stencil2/structs"
+	"github.com/murdinc/stencil2/utils"
 )
 
 // InitEcommerceTables creates e-commerce tables if they don't exist
@@ -42,9 +49,13 @@ func (db *DBConnection) InitEcommerceTables() error {
 			sku VARCHAR(100),
 			inventory_quantity INT DEFAULT 0,
 			inventory_policy VARCHAR(50) DEFAULT 'deny',
+			max_per_order INT DEFAULT NULL,
+			subscription_interval VARCHAR(10) DEFAULT NULL,
 			status VARCHAR(50) DEFAULT 'draft',
 			featured BOOLEAN DEFAULT FALSE,
 			sort_order INT DEFAULT 0,
+			review_count INT NOT NULL DEFAULT 0,
+			average_rating DECIMAL(3, 2) NOT NULL DEFAULT 0.00,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 			released_date DATETIME,
@@ -107,11 +118,185 @@ func (db *DBConnection) InitEcommerceTables() error {
 			price_modifier DECIMAL(10, 2) DEFAULT 0.00,
 			sku VARCHAR(100),
 			inventory_quantity INT DEFAULT 0,
+			max_per_order INT DEFAULT NULL,
 			position INT DEFAULT 0,
 			INDEX idx_product_id (product_id),
 			INDEX idx_sku (sku)
 		)`,
 
+		// Wishlists, owned by a cart session and, once they've checked out, a customer
+		`CREATE TABLE IF NOT EXISTS wishlists (
+			id INT PRIMARY KEY AUTO_INCREMENT,
+			session_id VARCHAR(255) DEFAULT NULL,
+			customer_id INT DEFAULT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			UNIQUE KEY idx_session_id (session_id),
+			UNIQUE KEY idx_customer_id (customer_id)
+		)`,
+
+		// Wishlist Items
+		`CREATE TABLE IF NOT EXISTS wishlist_items (
+			wishlist_id INT NOT NULL,
+			product_id INT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (wishlist_id, product_id),
+			INDEX idx_product_id (product_id)
+		)`,
+
+		// One-time tokens createOrder hands out for the thank-you page, bound to the checkout's cart session
+		`CREATE TABLE IF NOT EXISTS order_confirmations (
+			token_hash CHAR(64) PRIMARY KEY,
+			order_id INT NOT NULL,
+			session_id VARCHAR(255) NOT NULL,
+			expires_at DATETIME NOT NULL,
+			INDEX idx_expires_at (expires_at)
+		)`,
+
+		// Cart lines each hosted Checkout session was created for, which its order is built from
+		`CREATE TABLE IF NOT EXISTS checkout_snapshots (
+			checkout_session_id VARCHAR(255) PRIMARY KEY,
+			cart_session_id VARCHAR(255) NOT NULL,
+			items MEDIUMTEXT NOT NULL,
+			amount_total BIGINT NOT NULL,
+			currency VARCHAR(3) NOT NULL,
+			created_at DATETIME NOT NULL,
+			INDEX idx_created_at (created_at)
+		)`,
+
+		// Admin order digests sent, the latest one marks where the next begins
+		`CREATE TABLE IF NOT EXISTS order_digests (
+			id INT PRIMARY KEY AUTO_INCREMENT,
+			order_count INT NOT NULL DEFAULT 0,
+			total DECIMAL(10, 2) NOT NULL DEFAULT 0,
+			sent_at DATETIME NOT NULL,
+			INDEX idx_sent_at (sent_at)
+		)`,
+
+		// Products each cart session viewed most recently, trimmed to the configured count
+		`CREATE TABLE IF NOT EXISTS recently_viewed (
+			session_id VARCHAR(255) NOT NULL,
+			product_id INT NOT NULL,
+			viewed_at DATETIME NOT NULL,
+			PRIMARY KEY (session_id, product_id),
+			INDEX idx_session_viewed (session_id, viewed_at)
+		)`,
+
+		// Product text in other languages, see TranslateProduct
+		`CREATE TABLE IF NOT EXISTS product_translations (
+			product_id INT NOT NULL,
+			locale VARCHAR(20) NOT NULL,
+			name VARCHAR(255) NOT NULL DEFAULT '',
+			description TEXT,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			PRIMARY KEY (product_id, locale)
+		)`,
+
+		// Products a bundle is made of; selling the bundle draws down their stock instead of its own
+		`CREATE TABLE IF NOT EXISTS product_bundle_items (
+			bundle_id INT NOT NULL,
+			component_id INT NOT NULL,
+			quantity INT NOT NULL DEFAULT 1,
+			PRIMARY KEY (bundle_id, component_id),
+			INDEX idx_component_id (component_id)
+		)`,
+
+		// Store credit issued to and spent by customers; customers.store_credit holds the balance
+		`CREATE TABLE IF NOT EXISTS store_credit_transactions (
+			id INT PRIMARY KEY AUTO_INCREMENT,
+			customer_id INT NOT NULL,
+			amount DECIMAL(10, 2) NOT NULL,
+			balance_after DECIMAL(10, 2) NOT NULL,
+			order_id INT DEFAULT NULL,
+			reason VARCHAR(50) NOT NULL,
+			created_by VARCHAR(255) NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			INDEX idx_customer_id (customer_id),
+			INDEX idx_order_id (order_id)
+		)`,
+
+		// Store credit set aside for a PaymentIntent until /checkout turns it into an order, so the
+		// balance can't be spent twice while the card is charged
+		`CREATE TABLE IF NOT EXISTS store_credit_holds (
+			payment_intent_id VARCHAR(255) PRIMARY KEY,
+			customer_id INT NOT NULL,
+			amount DECIMAL(10, 2) NOT NULL,
+			transaction_id INT NOT NULL,
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME NOT NULL,
+			INDEX idx_customer_id (customer_id),
+			INDEX idx_expires_at (expires_at)
+		)`,
+
+		// Returns (RMAs) customers request for delivered orders
+		`CREATE TABLE IF NOT EXISTS order_returns (
+			id INT PRIMARY KEY AUTO_INCREMENT,
+			order_id INT NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'requested',
+			reason TEXT,
+			admin_note TEXT,
+			refund_amount DECIMAL(10, 2) NOT NULL DEFAULT 0.00,
+			restocked TINYINT(1) NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			INDEX idx_order_id (order_id),
+			INDEX idx_status (status)
+		)`,
+
+		// Order lines and quantities in each return
+		`CREATE TABLE IF NOT EXISTS order_return_items (
+			return_id INT NOT NULL,
+			order_item_id INT NOT NULL,
+			quantity INT NOT NULL,
+			PRIMARY KEY (return_id, order_item_id)
+		)`,
+
+		// Inventory changes made outside of orders, e.g. stocktake adjustments
+		`CREATE TABLE IF NOT EXISTS inventory_log (
+			id INT PRIMARY KEY AUTO_INCREMENT,
+			product_id INT NOT NULL,
+			variant_id INT DEFAULT NULL,
+			sku VARCHAR(100),
+			delta INT NOT NULL,
+			quantity_after INT NOT NULL,
+			reason VARCHAR(50) NOT NULL,
+			created_at DATETIME NOT NULL,
+			INDEX idx_product_id (product_id),
+			INDEX idx_created_at (created_at)
+		)`,
+
+		// Product reviews, only approved ones count towards the product's rating summary
+		`CREATE TABLE IF NOT EXISTS product_reviews (
+			id INT PRIMARY KEY AUTO_INCREMENT,
+			product_id INT NOT NULL,
+			customer_id INT DEFAULT NULL,
+			name VARCHAR(100) NOT NULL DEFAULT '',
+			email VARCHAR(255) NOT NULL DEFAULT '',
+			rating TINYINT NOT NULL,
+			title VARCHAR(255) NOT NULL DEFAULT '',
+			body TEXT,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			INDEX idx_product_status (product_id, status)
+		)`,
+
+		// Customer questions about products, shown once answered and published
+		`CREATE TABLE IF NOT EXISTS product_questions (
+			id INT PRIMARY KEY AUTO_INCREMENT,
+			product_id INT NOT NULL,
+			name VARCHAR(100) NOT NULL DEFAULT '',
+			email VARCHAR(255) NOT NULL DEFAULT '',
+			question TEXT NOT NULL,
+			answer TEXT,
+			answered_by VARCHAR(100) NOT NULL DEFAULT '',
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			answered_at DATETIME DEFAULT NULL,
+			INDEX idx_product_status (product_id, status),
+			INDEX idx_status_created (status, created_at)
+		)`,
+
 		// Shopping Carts
 		`CREATE TABLE IF NOT EXISTS carts (
 			id VARCHAR(255) PRIMARY KEY,
@@ -171,6 +356,7 @@ func (db *DBConnection) InitEcommerceTables() error {
 			INDEX idx_created_at (created_at),
 			INDEX idx_orders_customer_date (customer_id, created_at),
 			INDEX idx_orders_status_date (payment_status, created_at),
+			UNIQUE INDEX idx_orders_payment_intent (stripe_payment_intent_id),
 			FOREIGN KEY (customer_id) REFERENCES customers(id) ON DELETE SET NULL
 		)`,
 
@@ -206,6 +392,112 @@ func (db *DBConnection) InitEcommerceTables() error {
 			INDEX idx_verified (verified),
 			INDEX idx_unsubscribed (unsubscribed)
 		)`,
+
+		// Recurring orders (Stripe subscriptions)
+		`CREATE TABLE IF NOT EXISTS subscriptions (
+			id INT PRIMARY KEY AUTO_INCREMENT,
+			stripe_subscription_id VARCHAR(255) UNIQUE NOT NULL,
+			customer_id INT DEFAULT NULL,
+			customer_email VARCHAR(255) NOT NULL,
+			customer_name VARCHAR(255) NOT NULL,
+			cart_id VARCHAR(255) DEFAULT NULL,
+			shipping_address TEXT NOT NULL,
+			items TEXT NOT NULL,
+			billing_interval VARCHAR(10) NOT NULL,
+			amount DECIMAL(10, 2) NOT NULL,
+			status VARCHAR(50) NOT NULL DEFAULT 'incomplete',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			INDEX idx_customer_email (customer_email),
+			INDEX idx_status (status)
+		)`,
+
+		// Named counters (order numbers)
+		`CREATE TABLE IF NOT EXISTS sequences (
+			name VARCHAR(50) PRIMARY KEY,
+			value BIGINT NOT NULL DEFAULT 0
+		)`,
+
+		// Product price changes, recorded by the admin on create and whenever price/compare-at changes
+		`CREATE TABLE IF NOT EXISTS product_price_history (
+			id INT PRIMARY KEY AUTO_INCREMENT,
+			product_id INT NOT NULL,
+			price DECIMAL(10, 2) NOT NULL,
+			compare_at_price DECIMAL(10, 2) DEFAULT NULL,
+			previous_price DECIMAL(10, 2) DEFAULT NULL,
+			previous_compare_at_price DECIMAL(10, 2) DEFAULT NULL,
+			changed_by VARCHAR(255) NOT NULL DEFAULT '',
+			changed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_product_changed (product_id, changed_at)
+		)`,
+
+		// Collection sales scheduled in the admin; the sale scheduler applies them at starts_at and reverts them at ends_at
+		`CREATE TABLE IF NOT EXISTS scheduled_sales (
+			id INT PRIMARY KEY AUTO_INCREMENT,
+			collection_id INT NOT NULL,
+			name VARCHAR(255) NOT NULL DEFAULT '',
+			percent_off DECIMAL(5, 2) NOT NULL,
+			starts_at DATETIME NOT NULL,
+			ends_at DATETIME NOT NULL,
+			status VARCHAR(50) NOT NULL DEFAULT 'scheduled',
+			created_by VARCHAR(255) NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_status_starts (status, starts_at),
+			INDEX idx_status_ends (status, ends_at)
+		)`,
+
+		// Prices each product had before a sale was applied, so the sale can be reverted exactly
+		`CREATE TABLE IF NOT EXISTS scheduled_sale_items (
+			sale_id INT NOT NULL,
+			product_id INT NOT NULL,
+			original_price DECIMAL(10, 2) NOT NULL,
+			original_compare_at_price DECIMAL(10, 2) DEFAULT NULL,
+			sale_price DECIMAL(10, 2) NOT NULL,
+			PRIMARY KEY (sale_id, product_id),
+			INDEX idx_product (product_id)
+		)`,
+
+		// Inbound webhook deliveries (Stripe, Shippo, SMS) with redacted bodies, shown in the admin
+		`CREATE TABLE IF NOT EXISTS webhook_log (
+			id INT PRIMARY KEY AUTO_INCREMENT,
+			provider VARCHAR(20) NOT NULL,
+			event_type VARCHAR(100) NOT NULL DEFAULT '',
+			event_id VARCHAR(255) NOT NULL DEFAULT '',
+			status_code INT NOT NULL,
+			test TINYINT NOT NULL DEFAULT 0,
+			body TEXT,
+			received_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_received_at (received_at),
+			INDEX idx_provider_received (provider, received_at)
+		)`,
+
+		// Outbound webhooks waiting to be delivered or retried
+		`CREATE TABLE IF NOT EXISTS outbound_deliveries (
+			id INT PRIMARY KEY AUTO_INCREMENT,
+			endpoint VARCHAR(50) NOT NULL,
+			event VARCHAR(100) NOT NULL,
+			payload MEDIUMTEXT NOT NULL,
+			attempts INT NOT NULL DEFAULT 0,
+			last_status_code INT NOT NULL DEFAULT 0,
+			last_error TEXT,
+			next_attempt_at DATETIME NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_next_attempt_at (next_attempt_at)
+		)`,
+
+		// Outbound webhooks that failed for good, kept until they're replayed or deleted in the admin
+		`CREATE TABLE IF NOT EXISTS outbound_dead_letters (
+			id INT PRIMARY KEY AUTO_INCREMENT,
+			endpoint VARCHAR(50) NOT NULL,
+			event VARCHAR(100) NOT NULL,
+			payload MEDIUMTEXT NOT NULL,
+			attempts INT NOT NULL,
+			last_status_code INT NOT NULL DEFAULT 0,
+			last_error TEXT,
+			created_at DATETIME NOT NULL,
+			failed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_failed_at (failed_at)
+		)`,
 	}
 
 	for _, schema := range schemas {
@@ -215,16 +507,117 @@ func (db *DBConnection) InitEcommerceTables() error {
 		}
 	}
 
+	// Columns added after the original schema, for existing databases
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"products_unified", "max_per_order", "INT DEFAULT NULL"},
+		{"product_variants", "max_per_order", "INT DEFAULT NULL"},
+		{"products_unified", "subscription_interval", "VARCHAR(10) DEFAULT NULL"},
+		{"products_unified", "review_count", "INT NOT NULL DEFAULT 0"},
+		{"products_unified", "average_rating", "DECIMAL(3, 2) NOT NULL DEFAULT 0.00"},
+		{"orders", "risk_score", "INT NOT NULL DEFAULT 0"},
+		{"orders", "risk_reasons", "TEXT"},
+		{"orders", "digest_id", "INT DEFAULT NULL"},
+		{"products_unified", "preorder", "TINYINT(1) NOT NULL DEFAULT 0"},
+		{"orders", "ships_on", "DATE DEFAULT NULL"},
+		{"customers", "store_credit", "DECIMAL(10, 2) NOT NULL DEFAULT 0.00"},
+		{"customers", "store_credit_code", "VARCHAR(32) DEFAULT NULL"},
+		{"orders", "store_credit", "DECIMAL(10, 2) NOT NULL DEFAULT 0.00"},
+		{"order_return_items", "restocked", "TINYINT(1) NOT NULL DEFAULT 0"},
+	}
+
+	for _, c := range columns {
+		err := db.AddColumnIfMissing(c.table, c.column, c.definition)
+		if err != nil {
+			return fmt.Errorf("failed to migrate e-commerce table: %v", err)
+		}
+	}
+
+	// One order per PaymentIntent, so a webhook Stripe delivers twice can't create two. Orders
+	// without one used to store an empty string rather than NULL
+	if _, err := db.Database.Exec(`UPDATE orders SET stripe_payment_intent_id = NULL WHERE stripe_payment_intent_id = ''`); err != nil {
+		return fmt.Errorf("failed to migrate e-commerce table: %v", err)
+	}
+	if err := db.AddIndexIfMissing("orders", "idx_orders_payment_intent", "UNIQUE INDEX idx_orders_payment_intent (stripe_payment_intent_id)"); err != nil {
+		return fmt.Errorf("failed to add the orders payment intent index, check for orders sharing a payment intent: %v", err)
+	}
+
+	if err := db.normalizeStoredCountryCodes(); err != nil {
+		return fmt.Errorf("failed to normalize country codes: %v", err)
+	}
+
+	return nil
+}
+
+// normalizeStoredCountryCodes rewrites order countries to ISO alpha-2 codes and SMS dial codes to
+// "+NN", for rows written before values were normalized on insert. Already-normalized values are
+// left alone, so after the first run this only reads the distinct values
+func (db *DBConnection) normalizeStoredCountryCodes() error {
+	// BINARY keeps case variants like "us" and "US" apart, which the column collation would merge
+	rows, err := db.Database.Query(`SELECT DISTINCT CAST(shipping_country AS BINARY) FROM orders WHERE shipping_country IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	var countries []string
+	for rows.Next() {
+		var country string
+		if err := rows.Scan(&country); err != nil {
+			rows.Close()
+			return err
+		}
+		countries = append(countries, country)
+	}
+	rows.Close()
+
+	for _, country := range countries {
+		normalized := utils.NormalizeCountry(country)
+		if normalized == country {
+			continue
+		}
+		if _, err := db.Database.Exec(`UPDATE orders SET shipping_country = ? WHERE BINARY shipping_country = ?`, normalized, country); err != nil {
+			return err
+		}
+	}
+
+	rows, err = db.Database.Query(`SELECT DISTINCT IFNULL(country_code, '') FROM sms_signups`)
+	if err != nil {
+		return err
+	}
+	var dialCodes []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			rows.Close()
+			return err
+		}
+		dialCodes = append(dialCodes, code)
+	}
+	rows.Close()
+
+	for _, code := range dialCodes {
+		normalized := utils.NormalizeDialCode(code)
+		if normalized == code {
+			continue
+		}
+		if _, err := db.Database.Exec(`UPDATE sms_signups SET country_code = ? WHERE IFNULL(country_code, '') = ?`, normalized, code); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// GetProduct retrieves a single product by slug
+// GetProduct retrieves a single published product by slug, or sql.ErrNoRows when there's none
 func (db *DBConnection) GetProduct(slug string) (structs.Product, error) {
 	sqlQuery := `
 		SELECT
 			id, name, slug, description, price, compare_at_price,
-			sku, inventory_quantity, inventory_policy, status, featured,
-			created_at, updated_at, released_date
+			sku, inventory_quantity, inventory_policy, IFNULL(max_per_order, 0), IFNULL(subscription_interval, ''), status, featured,
+			review_count, average_rating, created_at, updated_at, released_date,
+			(preorder = 1 AND IFNULL(released_date > NOW(), 0))
 		FROM products_unified
 		WHERE slug = ? AND status = 'published'
 		LIMIT 1
@@ -235,8 +628,8 @@ func (db *DBConnection) GetProduct(slug string) (structs.Product, error) {
 	err := db.QueryRow(sqlQuery, slug).Scan(
 		&product.ID, &product.Name, &product.Slug, &product.Description,
 		&product.Price, &product.CompareAtPrice, &product.SKU,
-		&product.InventoryQuantity, &product.InventoryPolicy, &product.Status, &product.Featured,
-		&product.CreatedAt, &product.UpdatedAt, &releasedDate,
+		&product.InventoryQuantity, &product.InventoryPolicy, &product.MaxPerOrder, &product.SubscriptionInterval, &product.Status, &product.Featured,
+		&product.Reviews.ReviewCount, &product.Reviews.AverageRating, &product.CreatedAt, &product.UpdatedAt, &releasedDate, &product.Preorder,
 	)
 
 	if err != nil {
@@ -247,9 +640,7 @@ func (db *DBConnection) GetProduct(slug string) (structs.Product, error) {
 		product.ReleasedDate = releasedDate.Time
 	}
 
-	if err != nil {
-		return structs.Product{}, err
-	}
+	product.DiscountPercent = discountPercent(product.Price, product.CompareAtPrice)
 
 	// Get product images
 	product.Images, err = db.getProductImages(product.ID)
@@ -262,6 +653,8 @@ func (db *DBConnection) GetProduct(slug string) (structs.Product, error) {
 	if err != nil {
 		return product, err
 	}
+	applyMaxPerOrder(&product)
+	applyVariantDefaults(&product)
 
 	// Get product collections
 	product.Collections, err = db.getProductCollections(product.ID)
@@ -269,6 +662,15 @@ func (db *DBConnection) GetProduct(slug string) (structs.Product, error) {
 		return product, err
 	}
 
+	// Get what's in the product when it's a bundle
+	components, err := db.getBundleComponents(product.ID)
+	if err != nil {
+		return product, err
+	}
+	for _, c := range components {
+		product.BundleItems = append(product.BundleItems, c.BundleItem)
+	}
+
 	return product, nil
 }
 
@@ -288,16 +690,19 @@ func (db *DBConnection) GetProducts(vars map[string]string, params map[string]st
 		}
 	}
 
+	where := productListWhere(params)
+
 	sqlQuery := fmt.Sprintf(`
 		SELECT
 			id, name, slug, description, price, compare_at_price,
-			sku, inventory_quantity, inventory_policy, status, featured, sort_order,
-			created_at, updated_at, released_date
+			sku, inventory_quantity, inventory_policy, IFNULL(max_per_order, 0), IFNULL(subscription_interval, ''), status, featured, sort_order,
+			created_at, updated_at, released_date,
+			(preorder = 1 AND IFNULL(released_date > NOW(), 0))
 		FROM products_unified
-		WHERE status = 'published'
+		WHERE %s
 		ORDER BY %s
 		LIMIT %d, %d
-	`, orderby, offset, count)
+	`, where, orderby, offset, count)
 
 	rows, err := db.QueryRows(sqlQuery)
 	if err != nil {
@@ -312,8 +717,8 @@ func (db *DBConnection) GetProducts(vars map[string]string, params map[string]st
 		err := rows.Scan(
 			&product.ID, &product.Name, &product.Slug, &product.Description,
 			&product.Price, &product.CompareAtPrice, &product.SKU,
-			&product.InventoryQuantity, &product.InventoryPolicy, &product.Status, &product.Featured, &product.SortOrder,
-			&product.CreatedAt, &product.UpdatedAt, &releasedDate,
+			&product.InventoryQuantity, &product.InventoryPolicy, &product.MaxPerOrder, &product.SubscriptionInterval, &product.Status, &product.Featured, &product.SortOrder,
+			&product.CreatedAt, &product.UpdatedAt, &releasedDate, &product.Preorder,
 		)
 		if err != nil {
 			return nil, err
@@ -323,11 +728,15 @@ func (db *DBConnection) GetProducts(vars map[string]string, params map[string]st
 			product.ReleasedDate = releasedDate.Time
 		}
 
+		product.DiscountPercent = discountPercent(product.Price, product.CompareAtPrice)
+
 		// Get product images
 		product.Images, _ = db.getProductImages(product.ID)
 
 		// Get product variants
 		product.Variants, _ = db.getProductVariants(product.ID)
+		applyMaxPerOrder(&product)
+		applyVariantDefaults(&product)
 
 		products = append(products, product)
 	}
@@ -335,6 +744,52 @@ func (db *DBConnection) GetProducts(vars map[string]string, params map[string]st
 	return products, nil
 }
 
+// productListWhere builds the WHERE clause shared by GetProducts and CountProducts
+func productListWhere(params map[string]string) string {
+	where := `status = 'published'`
+	if params["on_sale"] == "true" {
+		where += ` AND compare_at_price > 0 AND price < compare_at_price`
+	}
+	return where
+}
+
+// CountProducts returns how many products match the listing filter GetProducts uses
+func (db *DBConnection) CountProducts(params map[string]string) (int, error) {
+	var total int
+	err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM products_unified WHERE %s`, productListWhere(params))).Scan(&total)
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// CountCollectionProducts returns how many published products are in a published collection
+func (db *DBConnection) CountCollectionProducts(collectionSlug string) (int, error) {
+	var total int
+	err := db.QueryRow(`
+		SELECT COUNT(*)
+		FROM products_unified p
+		JOIN product_collections pc ON p.id = pc.product_id
+		JOIN collections_unified c ON pc.collection_id = c.id
+		WHERE c.slug = ? AND p.status = 'published' AND c.status = 'published'
+	`, collectionSlug).Scan(&total)
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// GetOnSaleProducts retrieves products currently priced below their compare-at price
+func (db *DBConnection) GetOnSaleProducts(vars map[string]string, params map[string]string) ([]structs.Product, error) {
+	saleParams := make(map[string]string, len(params)+1)
+	for key, value := range params {
+		saleParams[key] = value
+	}
+	saleParams["on_sale"] = "true"
+
+	return db.GetProducts(vars, saleParams)
+}
+
 // GetFeaturedProducts retrieves featured products with pagination
 func (db *DBConnection) GetFeaturedProducts(vars map[string]string, params map[string]string) ([]structs.Product, error) {
 	offset, count := defaultOffsetCount(vars)
@@ -354,8 +809,9 @@ func (db *DBConnection) GetFeaturedProducts(vars map[string]string, params map[s
 	sqlQuery := fmt.Sprintf(`
 		SELECT
 			id, name, slug, description, price, compare_at_price,
-			sku, inventory_quantity, inventory_policy, status, featured, sort_order,
-			created_at, updated_at, released_date
+			sku, inventory_quantity, inventory_policy, IFNULL(max_per_order, 0), IFNULL(subscription_interval, ''), status, featured, sort_order,
+			created_at, updated_at, released_date,
+			(preorder = 1 AND IFNULL(released_date > NOW(), 0))
 		FROM products_unified
 		WHERE status = 'published' AND featured = 1
 		ORDER BY %s
@@ -375,8 +831,8 @@ func (db *DBConnection) GetFeaturedProducts(vars map[string]string, params map[s
 		err := rows.Scan(
 			&product.ID, &product.Name, &product.Slug, &product.Description,
 			&product.Price, &product.CompareAtPrice, &product.SKU,
-			&product.InventoryQuantity, &product.InventoryPolicy, &product.Status, &product.Featured, &product.SortOrder,
-			&product.CreatedAt, &product.UpdatedAt, &releasedDate,
+			&product.InventoryQuantity, &product.InventoryPolicy, &product.MaxPerOrder, &product.SubscriptionInterval, &product.Status, &product.Featured, &product.SortOrder,
+			&product.CreatedAt, &product.UpdatedAt, &releasedDate, &product.Preorder,
 		)
 		if err != nil {
 			return nil, err
@@ -386,11 +842,15 @@ func (db *DBConnection) GetFeaturedProducts(vars map[string]string, params map[s
 			product.ReleasedDate = releasedDate.Time
 		}
 
+		product.DiscountPercent = discountPercent(product.Price, product.CompareAtPrice)
+
 		// Get product images
 		product.Images, _ = db.getProductImages(product.ID)
 
 		// Get product variants
 		product.Variants, _ = db.getProductVariants(product.ID)
+		applyMaxPerOrder(&product)
+		applyVariantDefaults(&product)
 
 		products = append(products, product)
 	}
@@ -398,7 +858,7 @@ func (db *DBConnection) GetFeaturedProducts(vars map[string]string, params map[s
 	return products, nil
 }
 
-// GetCollection retrieves a single collection by slug
+// GetCollection retrieves a single published collection by slug, or sql.ErrNoRows when there's none
 func (db *DBConnection) GetCollection(slug string) (structs.Collection, error) {
 	sqlQuery := `
 		SELECT
@@ -490,8 +950,9 @@ func (db *DBConnection) GetCollectionProducts(collectionSlug string, vars map[st
 	sqlQuery := fmt.Sprintf(`
 		SELECT
 			p.id, p.name, p.slug, p.description, p.price, p.compare_at_price,
-			p.sku, p.inventory_quantity, p.inventory_policy, p.status, p.featured,
-			p.created_at, p.updated_at, p.released_date
+			p.sku, p.inventory_quantity, p.inventory_policy, IFNULL(p.max_per_order, 0), IFNULL(p.subscription_interval, ''), p.status, p.featured,
+			p.created_at, p.updated_at, p.released_date,
+			(p.preorder = 1 AND IFNULL(p.released_date > NOW(), 0))
 		FROM products_unified p
 		JOIN product_collections pc ON p.id = pc.product_id
 		JOIN collections_unified c ON pc.collection_id = c.id
@@ -513,8 +974,8 @@ func (db *DBConnection) GetCollectionProducts(collectionSlug string, vars map[st
 		err := rows.Scan(
 			&product.ID, &product.Name, &product.Slug, &product.Description,
 			&product.Price, &product.CompareAtPrice, &product.SKU,
-			&product.InventoryQuantity, &product.InventoryPolicy, &product.Status, &product.Featured,
-			&product.CreatedAt, &product.UpdatedAt, &releasedDate,
+			&product.InventoryQuantity, &product.InventoryPolicy, &product.MaxPerOrder, &product.SubscriptionInterval, &product.Status, &product.Featured,
+			&product.CreatedAt, &product.UpdatedAt, &releasedDate, &product.Preorder,
 		)
 		if err != nil {
 			return nil, err
@@ -524,11 +985,15 @@ func (db *DBConnection) GetCollectionProducts(collectionSlug string, vars map[st
 			product.ReleasedDate = releasedDate.Time
 		}
 
+		product.DiscountPercent = discountPercent(product.Price, product.CompareAtPrice)
+
 		// Get product images
 		product.Images, _ = db.getProductImages(product.ID)
 
 		// Get product variants
 		product.Variants, _ = db.getProductVariants(product.ID)
+		applyMaxPerOrder(&product)
+		applyVariantDefaults(&product)
 
 		products = append(products, product)
 	}
@@ -538,6 +1003,193 @@ func (db *DBConnection) GetCollectionProducts(collectionSlug string, vars map[st
 
 // Helper functions
 
+// DefaultMaxPerOrder is the quantity cap used when a product sets no limit
+const DefaultMaxPerOrder = 100
+
+// ErrMaxPerOrderExceeded is returned when a cart line would exceed its purchase limit
+var ErrMaxPerOrderExceeded = errors.New("quantity exceeds the per-order limit")
+
+// applyMaxPerOrder resolves the effective purchase limits for a product and its variants
+func applyMaxPerOrder(product *structs.Product) {
+	if product.MaxPerOrder <= 0 {
+		product.MaxPerOrder = DefaultMaxPerOrder
+	}
+	for i := range product.Variants {
+		if product.Variants[i].MaxPerOrder <= 0 {
+			product.Variants[i].MaxPerOrder = product.MaxPerOrder
+		}
+	}
+}
+
+// applyVariantDefaults marks a product with variants as sold only through them and picks the
+// variant storefronts preselect, the first by position (variants are loaded in that order)
+func applyVariantDefaults(product *structs.Product) {
+	product.RequiresVariant = len(product.Variants) > 0
+	if product.RequiresVariant {
+		product.DefaultVariantID = product.Variants[0].ID
+	}
+}
+
+// GetMaxPerOrder returns the effective purchase limit for a product or one of its variants, and
+// whether it's the variant's own limit. Any other limit covers all of the product's variants together
+func (db *DBConnection) GetMaxPerOrder(productID int, variantID int) (int, bool, error) {
+	var productLimit, variantLimit int
+	err := db.QueryRow(`
+		SELECT IFNULL(p.max_per_order, 0), IFNULL(pv.max_per_order, 0)
+		FROM products_unified p
+		LEFT JOIN product_variants pv ON pv.id = ? AND pv.product_id = p.id
+		WHERE p.id = ?
+	`, variantID, productID).Scan(&productLimit, &variantLimit)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if variantLimit > 0 {
+		return variantLimit, true, nil
+	}
+	if productLimit > 0 {
+		return productLimit, false, nil
+	}
+	return DefaultMaxPerOrder, false, nil
+}
+
+// CheckMaxPerOrder returns ErrMaxPerOrderExceeded (with the limit) if a cart line is over its
+// purchase limit. lineQuantity is the line's own quantity, which a variant's limit applies to, and
+// productQuantity that of all the product's lines, which the product's limit applies to
+func (db *DBConnection) CheckMaxPerOrder(productID int, variantID int, lineQuantity int, productQuantity int) error {
+	limit, perVariant, err := db.GetMaxPerOrder(productID, variantID)
+	if err != nil {
+		return err
+	}
+	quantity := productQuantity
+	if perVariant {
+		quantity = lineQuantity
+	}
+	if quantity > limit {
+		return fmt.Errorf("%w: maximum of %d per order", ErrMaxPerOrderExceeded, limit)
+	}
+	return nil
+}
+
+// cartProductQuantity is how many of a product a cart holds across all its lines, leaving out the
+// line with exceptItemID
+func (db *DBConnection) cartProductQuantity(sessionID string, productID int, exceptItemID int) (int, error) {
+	var quantity int
+	err := db.QueryRow(`
+		SELECT IFNULL(SUM(quantity), 0) FROM cart_items
+		WHERE cart_id = ? AND product_id = ? AND id != ?
+	`, sessionID, productID, exceptItemID).Scan(&quantity)
+	return quantity, err
+}
+
+// ReviewStatusApproved is the status of reviews that are shown and counted in the rating summary
+const ReviewStatusApproved = "approved"
+
+// GetProductReviewsSummary works out the average rating and count of a product's approved reviews,
+// zeros when it has none. Product reads use the copy RefreshProductReviewsSummary stores instead
+func (db *DBConnection) GetProductReviewsSummary(productID int) (structs.ReviewSummary, error) {
+	var summary structs.ReviewSummary
+	err := db.QueryRow(`
+		SELECT IFNULL(AVG(rating), 0), COUNT(*)
+		FROM product_reviews
+		WHERE product_id = ? AND status = ?
+	`, productID, ReviewStatusApproved).Scan(&summary.AverageRating, &summary.ReviewCount)
+	if err != nil {
+		return structs.ReviewSummary{}, err
+	}
+
+	summary.AverageRating = math.Round(summary.AverageRating*100) / 100
+	return summary, nil
+}
+
+// RefreshProductReviewsSummary recomputes a product's rating summary and stores it on the product,
+// so reading a product doesn't aggregate its reviews every time
+func (db *DBConnection) RefreshProductReviewsSummary(productID int) error {
+	summary, err := db.GetProductReviewsSummary(productID)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Database.Exec(`
+		UPDATE products_unified SET review_count = ?, average_rating = ? WHERE id = ?
+	`, summary.ReviewCount, summary.AverageRating, productID)
+	return err
+}
+
+// SetProductReviewStatus approves or rejects a review and refreshes its product's rating summary
+func (db *DBConnection) SetProductReviewStatus(reviewID int, status string) error {
+	var productID int
+	if err := db.QueryRow(`SELECT product_id FROM product_reviews WHERE id = ?`, reviewID).Scan(&productID); err != nil {
+		return err
+	}
+
+	if _, err := db.Database.Exec(`UPDATE product_reviews SET status = ? WHERE id = ?`, status, reviewID); err != nil {
+		return err
+	}
+
+	return db.RefreshProductReviewsSummary(productID)
+}
+
+// QuestionStatusPublished is the status of answered questions shown on the product
+const QuestionStatusPublished = "published"
+
+// CreateProductQuestion stores a customer's question about a published product for moderation,
+// returning sql.ErrNoRows when there's no such product
+func (db *DBConnection) CreateProductQuestion(slug, name, email, question string) (structs.Product, error) {
+	var product structs.Product
+	err := db.QueryRow(`SELECT id, name FROM products_unified WHERE slug = ? AND status = 'published'`, slug).Scan(&product.ID, &product.Name)
+	if err != nil {
+		return structs.Product{}, err
+	}
+
+	_, err = db.Database.Exec(`
+		INSERT INTO product_questions (product_id, name, email, question)
+		VALUES (?, ?, ?, ?)
+	`, product.ID, name, email, question)
+	if err != nil {
+		return structs.Product{}, fmt.Errorf("failed to create product question: %v", err)
+	}
+
+	return product, nil
+}
+
+// GetProductQuestions returns the answered, published questions on a published product, newest first
+func (db *DBConnection) GetProductQuestions(slug string) ([]structs.ProductQuestion, error) {
+	rows, err := db.QueryRows(`
+		SELECT q.id, q.product_id, q.name, q.question, q.answer, q.created_at, q.answered_at
+		FROM product_questions q
+		JOIN products_unified p ON p.id = q.product_id
+		WHERE p.slug = ? AND p.status = 'published'
+			AND q.status = ? AND IFNULL(q.answer, '') != ''
+		ORDER BY q.answered_at DESC
+	`, slug, QuestionStatusPublished)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	questions := []structs.ProductQuestion{}
+	for rows.Next() {
+		var question structs.ProductQuestion
+		var answeredAt sql.NullTime
+		if err := rows.Scan(&question.ID, &question.ProductID, &question.Name, &question.Question, &question.Answer, &question.CreatedAt, &answeredAt); err != nil {
+			return nil, err
+		}
+		question.AnsweredAt = answeredAt.Time
+		questions = append(questions, question)
+	}
+
+	return questions, rows.Err()
+}
+
+// discountPercent returns the whole-number percentage off the compare-at price, or 0 if not on sale
+func discountPercent(price, compareAtPrice float64) float64 {
+	if compareAtPrice <= 0 || price >= compareAtPrice {
+		return 0
+	}
+	return math.Round((compareAtPrice - price) / compareAtPrice * 100)
+}
+
 func (db *DBConnection) getProductImages(productID int) ([]structs.ProductImage, error) {
 	sqlQuery := `
 		SELECT
@@ -578,7 +1230,7 @@ func (db *DBConnection) getProductImages(productID int) ([]structs.ProductImage,
 func (db *DBConnection) getProductVariants(productID int) ([]structs.ProductVariant, error) {
 	sqlQuery := `
 		SELECT
-			id, product_id, title, price_modifier, sku, inventory_quantity, position
+			id, product_id, title, price_modifier, sku, inventory_quantity, IFNULL(max_per_order, 0), position
 		FROM product_variants
 		WHERE product_id = ?
 		ORDER BY position ASC
@@ -598,7 +1250,7 @@ func (db *DBConnection) getProductVariants(productID int) ([]structs.ProductVari
 		err := rows.Scan(
 			&variant.ID, &variant.ProductID, &variant.Title,
 			&variant.PriceModifier, &sku,
-			&variant.InventoryQuantity, &variant.Position,
+			&variant.InventoryQuantity, &variant.MaxPerOrder, &variant.Position,
 		)
 		if err != nil {
 			return nil, err
@@ -674,21 +1326,112 @@ func (db *DBConnection) GetCart(sessionID string) (structs.Cart, error) {
 		return cart, err
 	}
 
-	// Calculate subtotal
-	cart.Subtotal = 0
+	// Calculate subtotal from server-side line totals (includes variant price modifiers), in cents
+	// so adding them up doesn't drift
+	var subtotalCents int64
 	for _, item := range cart.Items {
-		cart.Subtotal += item.Total
+		subtotalCents += utils.ToCents(item.Total, "")
 	}
+	cart.Subtotal = float64(subtotalCents) / 100
 
 	return cart, nil
 }
 
-func (db *DBConnection) createCart(sessionID string) (structs.Cart, error) {
-	now := time.Now()
-	expiresAt := now.Add(24 * time.Hour * 7) // 7 days
+// lowStockThreshold is the available quantity at or below which a cart item is flagged as low stock
+const lowStockThreshold = 5
 
-	sqlQuery := `
-		INSERT INTO carts (id, created_at, updated_at, expires_at)
+// LowStockItem is a product or variant in an order that's left with little stock
+type LowStockItem struct {
+	ProductName  string
+	VariantTitle string
+	SKU          string
+	Available    int
+}
+
+// GetOrderLowStockItems returns the order's products and variants that track inventory and have
+// threshold or fewer left (lowStockThreshold when threshold isn't set)
+func (db *DBConnection) GetOrderLowStockItems(orderID int, threshold int) ([]LowStockItem, error) {
+	if threshold <= 0 {
+		threshold = lowStockThreshold
+	}
+
+	rows, err := db.QueryRows(`
+		SELECT DISTINCT p.name, IFNULL(pv.title, ''), IFNULL(IF(pv.id IS NULL, p.sku, pv.sku), ''),
+			IF(pv.id IS NULL, p.inventory_quantity, pv.inventory_quantity) as available
+		FROM order_items oi
+		JOIN products_unified p ON p.id = oi.product_id
+		LEFT JOIN product_variants pv ON pv.id = oi.variant_id AND pv.product_id = p.id
+		WHERE oi.order_id = ? AND p.inventory_policy != 'continue'
+		HAVING available <= ?
+		ORDER BY available, p.name
+	`, orderID, threshold)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []LowStockItem
+	for rows.Next() {
+		var item LowStockItem
+		if err := rows.Scan(&item.ProductName, &item.VariantTitle, &item.SKU, &item.Available); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// GetCartDetailed retrieves a cart with per-item stock availability for the cart page
+func (db *DBConnection) GetCartDetailed(sessionID string) (structs.Cart, error) {
+	cart, err := db.GetCart(sessionID)
+	if err != nil {
+		return cart, err
+	}
+
+	for i := range cart.Items {
+		item := &cart.Items[i]
+
+		var available int
+		var inventoryPolicy string
+		err := db.QueryRow(`
+			SELECT IF(pv.id IS NULL, p.inventory_quantity, pv.inventory_quantity), p.inventory_policy
+			FROM products_unified p
+			LEFT JOIN product_variants pv ON pv.id = ? AND pv.product_id = p.id
+			WHERE p.id = ?
+		`, item.VariantID, item.ProductID).Scan(&available, &inventoryPolicy)
+		if err != nil {
+			return cart, err
+		}
+
+		// A bundle is only as available as its scarcest component
+		components, err := db.getBundleComponents(item.ProductID)
+		if err != nil {
+			return cart, err
+		}
+		if len(components) > 0 {
+			available, inventoryPolicy = bundleAvailability(components)
+		}
+
+		inStock := inventoryPolicy == "continue" || available >= item.Quantity
+		item.Available = &available
+		item.InStock = &inStock
+
+		// Overselling allowed, so never warn about low stock
+		if inventoryPolicy != "continue" {
+			item.LowStock = inStock && available <= lowStockThreshold
+		}
+	}
+
+	return cart, nil
+}
+
+func (db *DBConnection) createCart(sessionID string) (structs.Cart, error) {
+	now := time.Now()
+	expiresAt := now.Add(24 * time.Hour * 7) // 7 days
+
+	sqlQuery := `
+		INSERT INTO carts (id, created_at, updated_at, expires_at)
 		VALUES (?, ?, ?, ?)
 	`
 
@@ -711,8 +1454,8 @@ func (db *DBConnection) getCartItems(cartID string) ([]structs.CartItem, error)
 	sqlQuery := `
 		SELECT
 			ci.id, ci.product_id, ci.variant_id, ci.quantity, ci.price,
-			p.name, p.slug, p.description, p.price,
-			ifnull(pv.title, '')
+			p.name, p.slug, p.description, p.price, ifnull(p.subscription_interval, ''),
+			ifnull(pv.title, ''), ifnull(pv.price_modifier, 0)
 		FROM cart_items ci
 		JOIN products_unified p ON ci.product_id = p.id
 		LEFT JOIN product_variants pv ON ci.variant_id = pv.id
@@ -726,47 +1469,222 @@ func (db *DBConnection) getCartItems(cartID string) ([]structs.CartItem, error)
 	defer rows.Close()
 
 	var items []structs.CartItem
+	stalePrices := make(map[int]float64)
 	for rows.Next() {
 		var item structs.CartItem
+		var storedPrice float64
 		err := rows.Scan(
-			&item.ID, &item.ProductID, &item.VariantID, &item.Quantity, &item.Price,
-			&item.Product.Name, &item.Product.Slug, &item.Product.Description, &item.Product.Price,
-			&item.Variant.Title,
+			&item.ID, &item.ProductID, &item.VariantID, &item.Quantity, &storedPrice,
+			&item.Product.Name, &item.Product.Slug, &item.Product.Description, &item.Product.Price, &item.Product.SubscriptionInterval,
+			&item.Variant.Title, &item.Variant.PriceModifier,
 		)
 		if err != nil {
 			return nil, err
 		}
 
+		// Always price from the catalog, never from the stored line price
+		item.Price = utils.RoundMoney(item.Product.Price+item.Variant.PriceModifier, "")
+		if item.Price != storedPrice {
+			stalePrices[item.ID] = item.Price
+		}
+
 		// Load product images
 		item.Product.Images, _ = db.getProductImages(item.ProductID)
 
-		item.Total = item.Price * float64(item.Quantity)
+		item.Total = utils.RoundMoney(item.Price*float64(item.Quantity), "")
 		items = append(items, item)
 	}
+	rows.Close()
+
+	// Keep stored line prices in sync with the catalog
+	for itemID, price := range stalePrices {
+		_, err := db.ExecuteQuery("UPDATE cart_items SET price = ? WHERE id = ?", price, itemID)
+		if err != nil {
+			log.Printf("Warning: Failed to update price for cart item %d: %v", itemID, err)
+		}
+	}
 
 	return items, nil
 }
 
+// Cart validation errors returned by AddToCart
+var (
+	ErrProductNotFound   = errors.New("product not found")
+	ErrVariantMismatch   = errors.New("variant does not belong to product")
+	ErrVariantRequired   = errors.New("choose one of the product's variants")
+	ErrInsufficientStock = errors.New("not enough inventory available")
+
+	// ErrBundleComponentMissing means a bundle includes a product that's been deleted or unpublished
+	ErrBundleComponentMissing = errors.New("bundle includes a product that's no longer available")
+)
+
+// TranslateProduct replaces a product's name and description with the first of locales it has a
+// translation for, setting its Locale. Blank translated fields, or no translation at all, leave
+// the default language's text
+func (db *DBConnection) TranslateProduct(product *structs.Product, locales []string) error {
+	locale, err := db.applyTranslation("product_translations", "product_id", product.ID, locales,
+		[]string{"name", "description"}, &product.Name, &product.Description)
+	if err != nil {
+		return err
+	}
+	product.Locale = locale
+	return nil
+}
+
+// bundleComponent is a product in a bundle along with its stock
+type bundleComponent struct {
+	structs.BundleItem
+	Available       int
+	InventoryPolicy string
+	Status          string // empty when the product has been deleted
+}
+
+// getBundleComponents returns the products a bundle is made of, none when the product isn't a bundle
+func (db *DBConnection) getBundleComponents(bundleID int) ([]bundleComponent, error) {
+	rows, err := db.QueryRows(`
+		SELECT bi.component_id, IFNULL(p.name, ''), IFNULL(p.slug, ''), bi.quantity,
+			IFNULL(p.inventory_quantity, 0), IFNULL(p.inventory_policy, ''), IFNULL(p.status, '')
+		FROM product_bundle_items bi
+		LEFT JOIN products_unified p ON p.id = bi.component_id
+		WHERE bi.bundle_id = ?
+		ORDER BY p.name
+	`, bundleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var components []bundleComponent
+	for rows.Next() {
+		var c bundleComponent
+		if err := rows.Scan(&c.ProductID, &c.Name, &c.Slug, &c.Quantity, &c.Available, &c.InventoryPolicy, &c.Status); err != nil {
+			return nil, err
+		}
+		components = append(components, c)
+	}
+	return components, rows.Err()
+}
+
+// bundleAvailability returns how many bundles the components' stock makes up, with the
+// "continue" policy only when every component allows overselling
+func bundleAvailability(components []bundleComponent) (int, string) {
+	available, policy := -1, "continue"
+	for _, c := range components {
+		if c.Status != "published" {
+			return 0, "deny"
+		}
+		if c.InventoryPolicy == "continue" {
+			continue
+		}
+		policy = "deny"
+		if n := c.Available / c.Quantity; available < 0 || n < available {
+			available = n
+		}
+	}
+	if available < 0 {
+		available = 0
+	}
+	return available, policy
+}
+
+// CheckBundleStock checks that the bundles among items are made of products that are still
+// published and have stock for the whole cart, counting components also bought on their own
+func (db *DBConnection) CheckBundleStock(items []structs.CartItem) error {
+	needed := make(map[int]int)
+	components := make(map[int]bundleComponent)
+	for _, item := range items {
+		bundle, err := db.getBundleComponents(item.ProductID)
+		if err != nil {
+			return err
+		}
+		for _, c := range bundle {
+			if c.Status != "published" {
+				return ErrBundleComponentMissing
+			}
+			needed[c.ProductID] += c.Quantity * item.Quantity
+			components[c.ProductID] = c
+		}
+	}
+	if len(components) == 0 {
+		return nil
+	}
+
+	for _, item := range items {
+		if _, ok := components[item.ProductID]; ok && item.VariantID == 0 {
+			needed[item.ProductID] += item.Quantity
+		}
+	}
+
+	for productID, quantity := range needed {
+		c := components[productID]
+		if c.InventoryPolicy != "continue" && quantity > c.Available {
+			return fmt.Errorf("%w: %s", ErrInsufficientStock, c.Name)
+		}
+	}
+	return nil
+}
+
 // AddToCart adds an item to the cart
 func (db *DBConnection) AddToCart(sessionID string, productID int, variantID int, quantity int) error {
-	// Get the base price from product
+	// Get the base price, stock and inventory policy from product
 	var basePrice float64
-	err := db.QueryRow("SELECT price FROM products_unified WHERE id = ?", productID).Scan(&basePrice)
-	if err != nil {
+	var available int
+	var inventoryPolicy string
+	var hasVariants bool
+	err := db.QueryRow(`
+		SELECT price, inventory_quantity, inventory_policy,
+			EXISTS(SELECT 1 FROM product_variants WHERE product_id = products_unified.id)
+		FROM products_unified
+		WHERE id = ? AND status = 'published'
+	`, productID).Scan(&basePrice, &available, &inventoryPolicy, &hasVariants)
+	if err == sql.ErrNoRows {
+		return ErrProductNotFound
+	} else if err != nil {
 		return err
 	}
 
+	// A product with variants is only sold as one of them (see applyVariantDefaults)
+	if hasVariants && variantID <= 0 {
+		return ErrVariantRequired
+	}
+
 	// Calculate final price (base price + variant modifier if applicable)
 	finalPrice := basePrice
 	if variantID > 0 {
 		var priceModifier float64
-		err := db.QueryRow("SELECT price_modifier FROM product_variants WHERE id = ?", variantID).Scan(&priceModifier)
-		if err != nil {
+		var variantProductID int
+		err := db.QueryRow("SELECT product_id, price_modifier, inventory_quantity FROM product_variants WHERE id = ?", variantID).Scan(&variantProductID, &priceModifier, &available)
+		if err == sql.ErrNoRows {
+			return ErrVariantMismatch
+		} else if err != nil {
 			return err
 		}
+		if variantProductID != productID {
+			return ErrVariantMismatch
+		}
 		finalPrice = basePrice + priceModifier
 	}
 
+	// Bundles hold no stock of their own, so check what they're made of instead
+	components, err := db.getBundleComponents(productID)
+	if err != nil {
+		return err
+	}
+	if len(components) > 0 {
+		for _, c := range components {
+			if c.Status != "published" {
+				return ErrBundleComponentMissing
+			}
+		}
+		available, inventoryPolicy = bundleAvailability(components)
+	}
+
+	// How many of the product the cart already holds, on any line
+	cartQuantity, err := db.cartProductQuantity(sessionID, productID, 0)
+	if err != nil {
+		return err
+	}
+
 	// Check if item already exists in cart
 	var existingID int
 	var existingQuantity int
@@ -775,6 +1693,20 @@ func (db *DBConnection) AddToCart(sessionID string, productID int, variantID int
 		WHERE cart_id = ? AND product_id = ? AND variant_id = ?
 	`, sessionID, productID, variantID).Scan(&existingID, &existingQuantity)
 
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	// Enforce the purchase limit on the combined quantity, of the line and of the product's lines
+	if err := db.CheckMaxPerOrder(productID, variantID, existingQuantity+quantity, cartQuantity+quantity); err != nil {
+		return err
+	}
+
+	// Enforce stock unless the product allows overselling
+	if inventoryPolicy != "continue" && existingQuantity+quantity > available {
+		return ErrInsufficientStock
+	}
+
 	if err == sql.ErrNoRows {
 		// Insert new item
 		sqlQuery := `
@@ -783,8 +1715,6 @@ func (db *DBConnection) AddToCart(sessionID string, productID int, variantID int
 		`
 		_, err = db.ExecuteQuery(sqlQuery, sessionID, productID, variantID, quantity, finalPrice)
 		return err
-	} else if err != nil {
-		return err
 	}
 
 	// Update existing item quantity
@@ -798,22 +1728,373 @@ func (db *DBConnection) UpdateCartItem(cartItemID int, quantity int) error {
 		return db.RemoveFromCart(cartItemID)
 	}
 
-	sqlQuery := `UPDATE cart_items SET quantity = ? WHERE id = ?`
-	_, err := db.ExecuteQuery(sqlQuery, quantity, cartItemID)
-	return err
+	var sessionID string
+	var productID, variantID int
+	err := db.QueryRow(`SELECT cart_id, product_id, variant_id FROM cart_items WHERE id = ?`, cartItemID).Scan(&sessionID, &productID, &variantID)
+	if err != nil {
+		return err
+	}
+
+	otherQuantity, err := db.cartProductQuantity(sessionID, productID, cartItemID)
+	if err != nil {
+		return err
+	}
+	if err := db.CheckMaxPerOrder(productID, variantID, quantity, otherQuantity+quantity); err != nil {
+		return err
+	}
+
+	sqlQuery := `UPDATE cart_items SET quantity = ? WHERE id = ?`
+	_, err = db.ExecuteQuery(sqlQuery, quantity, cartItemID)
+	return err
+}
+
+// RemoveFromCart removes an item from the cart
+func (db *DBConnection) RemoveFromCart(cartItemID int) error {
+	sqlQuery := `DELETE FROM cart_items WHERE id = ?`
+	_, err := db.ExecuteQuery(sqlQuery, cartItemID)
+	return err
+}
+
+// ClearCart removes all items from a cart
+func (db *DBConnection) ClearCart(sessionID string) error {
+	sqlQuery := `DELETE FROM cart_items WHERE cart_id = ?`
+	_, err := db.ExecuteQuery(sqlQuery, sessionID)
+	return err
+}
+
+// listProductColumns are the products_unified columns, aliased p, that scanListProduct reads
+const listProductColumns = `
+			p.id, p.name, p.slug, p.description, p.price, p.compare_at_price,
+			p.sku, p.inventory_quantity, p.inventory_policy, IFNULL(p.max_per_order, 0), IFNULL(p.subscription_interval, ''), p.status, p.featured, p.sort_order,
+			p.review_count, p.average_rating, p.created_at, p.updated_at, p.released_date,
+			(p.preorder = 1 AND IFNULL(p.released_date > NOW(), 0))`
+
+// scanListProduct scans a row selecting listProductColumns followed by any extra columns, and loads
+// the product's images and variants
+func (db *DBConnection) scanListProduct(rows *sql.Rows, extra ...interface{}) (structs.Product, error) {
+	var product structs.Product
+	var releasedDate sql.NullTime
+	dest := []interface{}{
+		&product.ID, &product.Name, &product.Slug, &product.Description,
+		&product.Price, &product.CompareAtPrice, &product.SKU,
+		&product.InventoryQuantity, &product.InventoryPolicy, &product.MaxPerOrder, &product.SubscriptionInterval, &product.Status, &product.Featured, &product.SortOrder,
+		&product.Reviews.ReviewCount, &product.Reviews.AverageRating, &product.CreatedAt, &product.UpdatedAt, &releasedDate, &product.Preorder,
+	}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return structs.Product{}, err
+	}
+
+	if releasedDate.Valid {
+		product.ReleasedDate = releasedDate.Time
+	}
+	product.DiscountPercent = discountPercent(product.Price, product.CompareAtPrice)
+
+	product.Images, _ = db.getProductImages(product.ID)
+	product.Variants, _ = db.getProductVariants(product.ID)
+	applyMaxPerOrder(&product)
+	applyVariantDefaults(&product)
+
+	return product, nil
+}
+
+// MaxWishlistItems caps how many products a wishlist can hold
+const MaxWishlistItems = 100
+
+// ErrWishlistFull is returned when adding to a wishlist that already holds MaxWishlistItems products
+var ErrWishlistFull = fmt.Errorf("wishlist can hold at most %d products", MaxWishlistItems)
+
+// getWishlistID returns the ID of the session's wishlist, 0 when it doesn't have one
+func (db *DBConnection) getWishlistID(sessionID string) (int, error) {
+	var wishlistID int
+	err := db.QueryRow(`SELECT id FROM wishlists WHERE session_id = ?`, sessionID).Scan(&wishlistID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return wishlistID, err
+}
+
+// GetWishlist returns the session's saved products, most recently saved first. Products that
+// have since been unpublished are left out
+func (db *DBConnection) GetWishlist(sessionID string) (structs.Wishlist, error) {
+	wishlist := structs.Wishlist{Items: []structs.WishlistItem{}}
+
+	rows, err := db.QueryRows(`
+		SELECT `+listProductColumns+`, i.created_at
+		FROM wishlists w
+		JOIN wishlist_items i ON i.wishlist_id = w.id
+		JOIN products_unified p ON p.id = i.product_id
+		WHERE w.session_id = ? AND p.status = 'published'
+		ORDER BY i.created_at DESC
+		LIMIT ?
+	`, sessionID, MaxWishlistItems)
+	if err != nil {
+		return wishlist, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item structs.WishlistItem
+		item.Product, err = db.scanListProduct(rows, &item.AddedAt)
+		if err != nil {
+			return wishlist, err
+		}
+		wishlist.Items = append(wishlist.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return wishlist, err
+	}
+
+	wishlist.Count = len(wishlist.Items)
+	return wishlist, nil
+}
+
+// AddToWishlist saves a published product to the session's wishlist, creating the wishlist if
+// needed. Saving a product that's already there is a no-op
+func (db *DBConnection) AddToWishlist(sessionID string, productID int) error {
+	var exists bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM products_unified WHERE id = ? AND status = 'published')`, productID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrProductNotFound
+	}
+
+	if _, err := db.ExecuteQuery(`INSERT IGNORE INTO wishlists (session_id) VALUES (?)`, sessionID); err != nil {
+		return err
+	}
+	wishlistID, err := db.getWishlistID(sessionID)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	var saved bool
+	err = db.QueryRow(`
+		SELECT COUNT(*), IFNULL(SUM(product_id = ?), 0) > 0 FROM wishlist_items WHERE wishlist_id = ?
+	`, productID, wishlistID).Scan(&count, &saved)
+	if err != nil {
+		return err
+	}
+	if saved {
+		return nil
+	}
+	if count >= MaxWishlistItems {
+		return ErrWishlistFull
+	}
+
+	_, err = db.ExecuteQuery(`INSERT IGNORE INTO wishlist_items (wishlist_id, product_id) VALUES (?, ?)`, wishlistID, productID)
+	return err
+}
+
+// RemoveFromWishlist removes a product from the session's wishlist
+func (db *DBConnection) RemoveFromWishlist(sessionID string, productID int) error {
+	_, err := db.ExecuteQuery(`
+		DELETE i FROM wishlist_items i
+		JOIN wishlists w ON w.id = i.wishlist_id
+		WHERE w.session_id = ? AND i.product_id = ?
+	`, sessionID, productID)
+	return err
+}
+
+// MergeWishlist hands the session's wishlist to the customer once they've identified themselves at
+// checkout. A customer with a wishlist from an earlier session gets this session's products added to
+// it (up to MaxWishlistItems), and the session takes it over so their earlier saves show up again
+func (db *DBConnection) MergeWishlist(sessionID string, customerID int) error {
+	sessionWishlistID, err := db.getWishlistID(sessionID)
+	if err != nil {
+		return err
+	}
+
+	var customerWishlistID int
+	err = db.QueryRow(`SELECT id FROM wishlists WHERE customer_id = ?`, customerID).Scan(&customerWishlistID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	switch {
+	case customerWishlistID == 0 && sessionWishlistID == 0:
+		return nil
+	case customerWishlistID == 0:
+		_, err = db.ExecuteQuery(`UPDATE wishlists SET customer_id = ? WHERE id = ?`, customerID, sessionWishlistID)
+		return err
+	case customerWishlistID == sessionWishlistID:
+		return nil
+	}
+
+	if sessionWishlistID != 0 {
+		var count int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM wishlist_items WHERE wishlist_id = ?`, customerWishlistID).Scan(&count); err != nil {
+			return err
+		}
+		if room := MaxWishlistItems - count; room > 0 {
+			_, err = db.ExecuteQuery(`
+				INSERT IGNORE INTO wishlist_items (wishlist_id, product_id, created_at)
+				SELECT ?, product_id, created_at FROM wishlist_items WHERE wishlist_id = ?
+				ORDER BY created_at DESC
+				LIMIT ?
+			`, customerWishlistID, sessionWishlistID, room)
+			if err != nil {
+				return err
+			}
+		}
+
+		if _, err := db.ExecuteQuery(`DELETE FROM wishlist_items WHERE wishlist_id = ?`, sessionWishlistID); err != nil {
+			return err
+		}
+		if _, err := db.ExecuteQuery(`DELETE FROM wishlists WHERE id = ?`, sessionWishlistID); err != nil {
+			return err
+		}
+	}
+
+	_, err = db.ExecuteQuery(`UPDATE wishlists SET session_id = ? WHERE id = ?`, sessionID, customerWishlistID)
+	return err
+}
+
+// DefaultRecentlyViewedLimit is how many recently viewed products are kept per session when
+// ecommerce.recentlyViewedLimit isn't set
+const DefaultRecentlyViewedLimit = 10
+
+// RecordProductView remembers that the session viewed a published product, keeping only the
+// limit most recent views (plus one, so the product being viewed can be left out of the list)
+func (db *DBConnection) RecordProductView(sessionID string, productID int, limit int) error {
+	_, err := db.ExecuteQuery(`
+		INSERT INTO recently_viewed (session_id, product_id, viewed_at)
+		SELECT ?, id, NOW() FROM products_unified WHERE id = ? AND status = 'published'
+		ON DUPLICATE KEY UPDATE viewed_at = NOW()
+	`, sessionID, productID)
+	if err != nil {
+		return err
+	}
+
+	// The derived table lets MySQL read the table it's deleting from
+	_, err = db.ExecuteQuery(`
+		DELETE FROM recently_viewed
+		WHERE session_id = ? AND product_id NOT IN (
+			SELECT product_id FROM (
+				SELECT product_id FROM recently_viewed WHERE session_id = ? ORDER BY viewed_at DESC LIMIT ?
+			) keep
+		)
+	`, sessionID, sessionID, limit+1)
+	return err
+}
+
+// GetRecentlyViewedProducts returns up to limit products the session viewed most recently, leaving
+// out excludeProductID (the product being viewed) and products that have been unpublished
+func (db *DBConnection) GetRecentlyViewedProducts(sessionID string, excludeProductID int, limit int) ([]structs.Product, error) {
+	rows, err := db.QueryRows(`
+		SELECT `+listProductColumns+`
+		FROM recently_viewed v
+		JOIN products_unified p ON p.id = v.product_id
+		WHERE v.session_id = ? AND v.product_id != ? AND p.status = 'published'
+		ORDER BY v.viewed_at DESC
+		LIMIT ?
+	`, sessionID, excludeProductID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []structs.Product{}
+	for rows.Next() {
+		product, err := db.scanListProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, product)
+	}
+
+	return products, rows.Err()
+}
+
+// DefaultOrderNumberPrefix is used when a site doesn't configure its own prefix
+const DefaultOrderNumberPrefix = "ORD-"
+
+// NextSequenceValue atomically increments and returns the named counter, starting at 1
+func (db *DBConnection) NextSequenceValue(name string) (int64, error) {
+	// LAST_INSERT_ID(expr) makes the new value available through the result of this
+	// statement, so concurrent callers never see the same number
+	result, err := db.Database.Exec(`
+		INSERT INTO sequences (name, value) VALUES (?, LAST_INSERT_ID(1))
+		ON DUPLICATE KEY UPDATE value = LAST_INSERT_ID(value + 1)
+	`, name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment sequence %s: %v", name, err)
+	}
+
+	return result.LastInsertId()
+}
+
+// GenerateOrderNumber returns the next order number, e.g. ORD-000042
+func (db *DBConnection) GenerateOrderNumber(prefix string) (string, error) {
+	if prefix == "" {
+		prefix = DefaultOrderNumberPrefix
+	}
+
+	next, err := db.NextSequenceValue("order_number")
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s%06d", prefix, next), nil
+}
+
+// preorderShipDate is the latest release date among the items' products that are on preorder and
+// not yet released, nil when everything can ship now
+func (db *DBConnection) preorderShipDate(cartItems []structs.CartItem) (*time.Time, error) {
+	if len(cartItems) == 0 {
+		return nil, nil
+	}
+
+	placeholders := make([]string, len(cartItems))
+	args := make([]interface{}, len(cartItems))
+	for i, item := range cartItems {
+		placeholders[i] = "?"
+		args[i] = item.ProductID
+	}
+
+	var shipsOn sql.NullTime
+	err := db.QueryRow(`
+		SELECT MAX(released_date) FROM products_unified
+		WHERE preorder = 1 AND released_date > NOW() AND id IN (`+strings.Join(placeholders, ", ")+`)
+	`, args...).Scan(&shipsOn)
+	if err != nil || !shipsOn.Valid {
+		return nil, err
+	}
+	return &shipsOn.Time, nil
 }
 
-// RemoveFromCart removes an item from the cart
-func (db *DBConnection) RemoveFromCart(cartItemID int) error {
-	sqlQuery := `DELETE FROM cart_items WHERE id = ?`
-	_, err := db.ExecuteQuery(sqlQuery, cartItemID)
-	return err
+// OrderTotals works out an order's tax and total. With tax-inclusive pricing the subtotal already
+// contains the tax, so it's backed out of the subtotal instead of being added on top. Tax is
+// rounded to the cent with rounding (see utils.ToCents) and the total is added up in cents, so
+// it's the amount Stripe charges
+func OrderTotals(subtotal, taxRate, shippingCost float64, taxInclusive bool, rounding string) (tax, total float64) {
+	subtotalCents := utils.ToCents(subtotal, rounding)
+	shippingCents := utils.ToCents(shippingCost, rounding)
+	if taxInclusive {
+		return IncludedTax(subtotal, taxRate, rounding), float64(subtotalCents+shippingCents) / 100
+	}
+	taxCents := utils.ToCents(float64(subtotalCents)/100*taxRate, rounding)
+	return float64(taxCents) / 100, float64(subtotalCents+taxCents+shippingCents) / 100
+}
+
+// IncludedTax is the tax contained in a tax-inclusive amount, rounded to the cent
+func IncludedTax(amount, taxRate float64, rounding string) float64 {
+	if taxRate <= 0 {
+		return 0
+	}
+	return utils.RoundMoney(utils.RoundMoney(amount, rounding)*taxRate/(1+taxRate), rounding)
 }
 
-// CreateOrder creates an order from cart data
+// CreateOrder creates an order from cart data, failing with ErrDuplicateOrder when its PaymentIntent
+// already has one
 func (db *DBConnection) CreateOrder(orderData map[string]interface{}) (structs.Order, error) {
 	// Generate order number
-	orderNumber := fmt.Sprintf("ORD-%d", time.Now().Unix())
+	prefix, _ := orderData["order_number_prefix"].(string)
+	orderNumber, err := db.GenerateOrderNumber(prefix)
+	if err != nil {
+		return structs.Order{}, err
+	}
 
 	// Extract data from map
 	cartItems := orderData["cart_items"].([]structs.CartItem)
@@ -833,10 +2114,13 @@ func (db *DBConnection) CreateOrder(orderData map[string]interface{}) (structs.O
 		log.Printf("Warning: failed to create/get customer: %v\n", err)
 	}
 
-	// Extract payment information (if provided)
+	// Extract payment information (if provided). Orders without a PaymentIntent store NULL, which
+	// the unique index on it allows any number of
 	paymentIntentID := ""
-	if val, ok := orderData["payment_intent_id"].(string); ok {
+	var paymentIntentValue interface{} = nil
+	if val, ok := orderData["payment_intent_id"].(string); ok && val != "" {
 		paymentIntentID = val
+		paymentIntentValue = val
 	}
 	paymentStatus := "pending"
 	if val, ok := orderData["payment_status"].(string); ok {
@@ -844,24 +2128,63 @@ func (db *DBConnection) CreateOrder(orderData map[string]interface{}) (structs.O
 	}
 
 	// Calculate totals
-	subtotal := 0.0
+	var subtotalCents int64
 	for _, item := range cartItems {
-		subtotal += item.Total
+		subtotalCents += utils.ToCents(item.Total, "")
 	}
+	subtotal := float64(subtotalCents) / 100
 
 	// Get tax rate and shipping cost from orderData (0 is valid)
 	taxRate := 0.0
 	if tr, ok := orderData["tax_rate"].(float64); ok {
 		taxRate = tr
 	}
-	tax := subtotal * taxRate
+	taxInclusive, _ := orderData["tax_inclusive"].(bool)
+	taxRounding, _ := orderData["tax_rounding"].(string)
 
 	shippingCost := 0.0
 	if sc, ok := orderData["shipping_cost"].(float64); ok {
 		shippingCost = sc
 	}
 
-	total := subtotal + tax + shippingCost
+	tax, total := OrderTotals(subtotal, taxRate, shippingCost, taxInclusive, taxRounding)
+
+	// The order, its items, the stock it takes and the store credit it spends are written together
+	tx, err := db.Database.Begin()
+	if err != nil {
+		return structs.Order{}, err
+	}
+	defer tx.Rollback()
+
+	// Credit held for the PaymentIntent (see HoldStoreCredit) is what the card charge left out, so
+	// it's spent as is. Otherwise it's the credit the API applied, see AvailableStoreCredit, which
+	// has to pay for the order entirely since there's no card charge for the rest
+	hold, held, err := lockStoreCreditHold(tx, paymentIntentID)
+	if err != nil {
+		return structs.Order{}, err
+	}
+	storeCredit, _ := orderData["store_credit"].(float64)
+	if held {
+		storeCredit = hold.amount
+		if hold.customerID != customer.ID {
+			return structs.Order{}, ErrStoreCreditCode
+		}
+	} else if paymentIntentID != "" {
+		// The card charge was for the whole order, so there's no credit to spend
+		storeCredit = 0
+	} else if storeCredit > total {
+		storeCredit = total
+	} else if storeCredit > 0 && utils.ToCents(storeCredit, "") < utils.ToCents(total, "") {
+		return structs.Order{}, ErrPartialStoreCredit
+	}
+	if storeCredit > 0 && customer.ID == 0 {
+		return structs.Order{}, ErrStoreCreditCode
+	}
+	paymentMethod := "card"
+	if storeCredit > 0 && storeCredit >= total {
+		paymentMethod = "store_credit"
+		paymentStatus = "paid"
+	}
 
 	// Build full address from nested fields
 	address1 := shippingAddr["address"].(string)
@@ -872,7 +2195,24 @@ func (db *DBConnection) CreateOrder(orderData map[string]interface{}) (structs.O
 	city := shippingAddr["city"].(string)
 	state := shippingAddr["state"].(string)
 	zip := shippingAddr["zip"].(string)
-	country := shippingAddr["country"].(string)
+	country := utils.NormalizeCountry(shippingAddr["country"].(string))
+
+	// Billing country, when the checkout collected one
+	var billingCountry interface{} = nil
+	if billingAddr, ok := orderData["billing_address"].(map[string]interface{}); ok {
+		if bc, ok := billingAddr["country"].(string); ok && bc != "" {
+			billingCountry = utils.NormalizeCountry(bc)
+		}
+	}
+
+	// Fraud-risk score from the API, see ScoreOrderRisk
+	riskScore, _ := orderData["risk_score"].(int)
+	riskReasons := "[]"
+	if reasons, ok := orderData["risk_reasons"].([]string); ok && len(reasons) > 0 {
+		if data, err := json.Marshal(reasons); err == nil {
+			riskReasons = string(data)
+		}
+	}
 
 	// Prepare customer ID for insertion (NULL if customer creation failed)
 	var customerID interface{} = nil
@@ -885,18 +2225,28 @@ func (db *DBConnection) CreateOrder(orderData map[string]interface{}) (structs.O
 		INSERT INTO orders (
 			order_number, customer_email, customer_name, customer_id,
 			shipping_address_line1, shipping_address_line2, shipping_city, shipping_state, shipping_zip, shipping_country,
-			subtotal, tax, shipping_cost, total,
-			payment_status, fulfillment_status, stripe_payment_intent_id, payment_method, created_at, updated_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'unfulfilled', ?, 'card', NOW(), NOW())
+			billing_country, subtotal, tax, shipping_cost, total, store_credit,
+			payment_status, fulfillment_status, stripe_payment_intent_id, payment_method,
+			risk_score, risk_reasons, ships_on, created_at, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'unfulfilled', ?, ?, ?, ?, ?, NOW(), NOW())
 	`
 
-	result, err := db.ExecuteQuery(sqlQuery,
+	// Orders with preorder items can't ship until the last of them is released
+	shipsOn, err := db.preorderShipDate(cartItems)
+	if err != nil {
+		return structs.Order{}, err
+	}
+
+	result, err := tx.Exec(sqlQuery,
 		orderNumber, customerEmail, customerName, customerID,
 		address1, address2, city, state, zip, country,
-		subtotal, tax, shippingCost, total,
-		paymentStatus, paymentIntentID,
+		billingCountry, subtotal, tax, shippingCost, total, storeCredit,
+		paymentStatus, paymentIntentValue, paymentMethod,
+		riskScore, riskReasons, shipsOn,
 	)
-	if err != nil {
+	if isDuplicateKey(err, "idx_orders_payment_intent") {
+		return structs.Order{}, ErrDuplicateOrder
+	} else if err != nil {
 		return structs.Order{}, err
 	}
 
@@ -905,7 +2255,18 @@ func (db *DBConnection) CreateOrder(orderData map[string]interface{}) (structs.O
 		return structs.Order{}, err
 	}
 
-	// Insert order items and deduct inventory
+	if held {
+		err = claimStoreCreditHold(tx, hold, orderID)
+	} else if storeCredit > 0 {
+		// Fails when the balance was spent elsewhere since the API checked it, taking the order with it
+		_, err = spendStoreCredit(tx, customer.ID, orderID, storeCredit)
+	}
+	if err != nil {
+		return structs.Order{}, err
+	}
+
+	// Insert order items and deduct inventory in the same transaction, so an item that's out of
+	// stock takes the order and its store credit spend with it
 	for _, item := range cartItems {
 		itemQuery := `
 			INSERT INTO order_items (
@@ -913,7 +2274,7 @@ func (db *DBConnection) CreateOrder(orderData map[string]interface{}) (structs.O
 				quantity, price, total
 			) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 		`
-		_, err = db.ExecuteQuery(itemQuery,
+		_, err = tx.Exec(itemQuery,
 			orderID, item.ProductID, item.VariantID, item.Product.Name, item.Variant.Title,
 			item.Quantity, item.Price, item.Total,
 		)
@@ -921,7 +2282,30 @@ func (db *DBConnection) CreateOrder(orderData map[string]interface{}) (structs.O
 			return structs.Order{}, err
 		}
 
-		// Deduct inventory
+		// Deduct inventory, from the components when the item is a bundle
+		components, err := db.getBundleComponents(item.ProductID)
+		if err != nil {
+			return structs.Order{}, err
+		}
+		if len(components) > 0 {
+			for _, c := range components {
+				quantity := c.Quantity * item.Quantity
+				result, err := tx.Exec(`
+					UPDATE products_unified
+					SET inventory_quantity = inventory_quantity - ?
+					WHERE id = ? AND inventory_quantity >= ?
+				`, quantity, c.ProductID, quantity)
+				if err != nil {
+					return structs.Order{}, fmt.Errorf("failed to deduct bundle component inventory: %v", err)
+				}
+				rowsAffected, _ := result.RowsAffected()
+				if rowsAffected == 0 {
+					return structs.Order{}, fmt.Errorf("insufficient inventory for product ID %d in bundle %d", c.ProductID, item.ProductID)
+				}
+			}
+			continue
+		}
+
 		if item.VariantID > 0 {
 			// Deduct from variant inventory
 			inventoryQuery := `
@@ -929,7 +2313,7 @@ func (db *DBConnection) CreateOrder(orderData map[string]interface{}) (structs.O
 				SET inventory_quantity = inventory_quantity - ?
 				WHERE id = ? AND inventory_quantity >= ?
 			`
-			result, err := db.ExecuteQuery(inventoryQuery, item.Quantity, item.VariantID, item.Quantity)
+			result, err := tx.Exec(inventoryQuery, item.Quantity, item.VariantID, item.Quantity)
 			if err != nil {
 				return structs.Order{}, fmt.Errorf("failed to deduct variant inventory: %v", err)
 			}
@@ -944,7 +2328,7 @@ func (db *DBConnection) CreateOrder(orderData map[string]interface{}) (structs.O
 				SET inventory_quantity = inventory_quantity - ?
 				WHERE id = ? AND inventory_quantity >= ?
 			`
-			result, err := db.ExecuteQuery(inventoryQuery, item.Quantity, item.ProductID, item.Quantity)
+			result, err := tx.Exec(inventoryQuery, item.Quantity, item.ProductID, item.Quantity)
 			if err != nil {
 				return structs.Order{}, fmt.Errorf("failed to deduct product inventory: %v", err)
 			}
@@ -955,11 +2339,157 @@ func (db *DBConnection) CreateOrder(orderData map[string]interface{}) (structs.O
 		}
 	}
 
+	if err := tx.Commit(); err != nil {
+		return structs.Order{}, err
+	}
+
+	// Stock levels changed, so cached product reads are stale, and order stats need recounting
+	Publish(db.Name, ProductsChanged, OrdersChanged)
+
 	// Return the created order
 	return db.GetOrder(orderNumber)
 }
 
-// GetOrder retrieves an order by order number
+// DefaultOrderConfirmTTL is how long a confirmation token from createOrder can be redeemed when
+// ecommerce.orderConfirmMinutes isn't set
+const DefaultOrderConfirmTTL = 30 * time.Minute
+
+// orderConfirmTokenHash is what's stored for a confirmation token, so a database read can't redeem one
+func orderConfirmTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkoutSnapshotDays is how long a checkout snapshot is kept, well past the 24 hours a hosted
+// Checkout session can stay open
+const checkoutSnapshotDays = 3
+
+// CheckoutSnapshot is the cart a hosted Checkout session was created for, see SaveCheckoutSnapshot
+type CheckoutSnapshot struct {
+	CartSessionID string
+	Items         []structs.CartItem
+	AmountTotal   int64  // what Stripe should charge, in the currency's smallest unit
+	Currency      string // lower-case ISO 4217 code, as Stripe reports it
+}
+
+// SaveCheckoutSnapshot records the cart lines and amount a hosted Checkout session was created
+// for, so its order is built from what the customer paid for rather than the cart as it is when
+// the webhook arrives. Old snapshots are cleared out on the way
+func (db *DBConnection) SaveCheckoutSnapshot(checkoutSessionID string, snapshot CheckoutSnapshot) error {
+	if _, err := db.ExecuteQuery(`DELETE FROM checkout_snapshots WHERE created_at < DATE_SUB(NOW(), INTERVAL ? DAY)`, checkoutSnapshotDays); err != nil {
+		log.Printf("Warning: failed to clear old checkout snapshots: %v", err)
+	}
+
+	// Only what CreateOrder reads is kept
+	items := make([]structs.CartItem, len(snapshot.Items))
+	for i, item := range snapshot.Items {
+		items[i] = structs.CartItem{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			Product:   structs.Product{Name: item.Product.Name},
+			Variant:   structs.ProductVariant{Title: item.Variant.Title},
+			Quantity:  item.Quantity,
+			Price:     item.Price,
+			Total:     item.Total,
+		}
+	}
+	data, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecuteQuery(`
+		INSERT INTO checkout_snapshots (checkout_session_id, cart_session_id, items, amount_total, currency, created_at)
+		VALUES (?, ?, ?, ?, ?, NOW())
+	`, checkoutSessionID, snapshot.CartSessionID, string(data), snapshot.AmountTotal, snapshot.Currency)
+	return err
+}
+
+// GetCheckoutSnapshot returns the snapshot saved for a hosted Checkout session, sql.ErrNoRows when
+// there isn't one
+func (db *DBConnection) GetCheckoutSnapshot(checkoutSessionID string) (CheckoutSnapshot, error) {
+	var snapshot CheckoutSnapshot
+	var items string
+	err := db.QueryRow(`
+		SELECT cart_session_id, items, amount_total, currency FROM checkout_snapshots
+		WHERE checkout_session_id = ?
+	`, checkoutSessionID).Scan(&snapshot.CartSessionID, &items, &snapshot.AmountTotal, &snapshot.Currency)
+	if err != nil {
+		return CheckoutSnapshot{}, err
+	}
+	if err := json.Unmarshal([]byte(items), &snapshot.Items); err != nil {
+		return CheckoutSnapshot{}, err
+	}
+	return snapshot, nil
+}
+
+// DeleteCheckoutSnapshot removes a hosted Checkout session's snapshot once its order is placed
+func (db *DBConnection) DeleteCheckoutSnapshot(checkoutSessionID string) error {
+	_, err := db.ExecuteQuery(`DELETE FROM checkout_snapshots WHERE checkout_session_id = ?`, checkoutSessionID)
+	return err
+}
+
+// CreateOrderConfirmation issues a one-time token that RedeemOrderConfirmation exchanges for the
+// order, from the same cart session, within ttl. Expired tokens are cleared out on the way
+func (db *DBConnection) CreateOrderConfirmation(orderID int, sessionID string, ttl time.Duration) (string, error) {
+	if _, err := db.ExecuteQuery(`DELETE FROM order_confirmations WHERE expires_at < NOW()`); err != nil {
+		log.Printf("Warning: failed to clear expired order confirmations: %v", err)
+	}
+
+	token := utils.GenerateSessionID()
+	_, err := db.ExecuteQuery(`
+		INSERT INTO order_confirmations (token_hash, order_id, session_id, expires_at)
+		VALUES (?, ?, ?, DATE_ADD(NOW(), INTERVAL ? SECOND))
+	`, orderConfirmTokenHash(token), orderID, sessionID, int(ttl.Seconds()))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RedeemOrderConfirmation returns the order a confirmation token was issued for and uses the token
+// up. A token that's unknown, expired, already used or presented from another session gives sql.ErrNoRows
+func (db *DBConnection) RedeemOrderConfirmation(token, sessionID string) (structs.Order, error) {
+	tokenHash := orderConfirmTokenHash(token)
+
+	var orderNumber string
+	err := db.QueryRow(`
+		SELECT o.order_number
+		FROM order_confirmations c
+		JOIN orders o ON o.id = c.order_id
+		WHERE c.token_hash = ? AND c.session_id = ? AND c.expires_at > NOW()
+	`, tokenHash, sessionID).Scan(&orderNumber)
+	if err != nil {
+		return structs.Order{}, err
+	}
+
+	// Deleting is the redemption, only the request that removes the row gets the order
+	result, err := db.ExecuteQuery(`DELETE FROM order_confirmations WHERE token_hash = ?`, tokenHash)
+	if err != nil {
+		return structs.Order{}, err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return structs.Order{}, sql.ErrNoRows
+	}
+
+	return db.GetOrder(orderNumber)
+}
+
+// GetEmailOrderHistory counts an email address's paid orders, and its failed payments in the last
+// failedWithinHours, for fraud-risk scoring
+func (db *DBConnection) GetEmailOrderHistory(email string, failedWithinHours int) (paidOrders int, failedPayments int, err error) {
+	sqlQuery := `
+		SELECT
+			COUNT(CASE WHEN payment_status IN ('paid', 'authorized', 'refunded') THEN 1 END),
+			COUNT(CASE WHEN payment_status = 'failed' AND created_at >= NOW() - INTERVAL ? HOUR THEN 1 END)
+		FROM orders
+		WHERE customer_email = ?
+	`
+	err = db.QueryRow(sqlQuery, failedWithinHours, email).Scan(&paidOrders, &failedPayments)
+	return paidOrders, failedPayments, err
+}
+
+// GetOrder retrieves an order by order number, or sql.ErrNoRows when there's none
 func (db *DBConnection) GetOrder(orderNumber string) (structs.Order, error) {
 	sqlQuery := `
 		SELECT
@@ -967,8 +2497,8 @@ func (db *DBConnection) GetOrder(orderNumber string) (structs.Order, error) {
 			shipping_address_line1, shipping_address_line2,
 			shipping_city, shipping_state, shipping_zip, shipping_country,
 			subtotal, tax, shipping_cost, total,
-			payment_status, fulfillment_status, payment_method,
-			stripe_payment_intent_id, created_at, updated_at
+			store_credit, payment_status, fulfillment_status, payment_method,
+			stripe_payment_intent_id, ships_on, created_at, updated_at
 		FROM orders
 		WHERE order_number = ?
 		LIMIT 1
@@ -976,14 +2506,15 @@ func (db *DBConnection) GetOrder(orderNumber string) (structs.Order, error) {
 
 	var order structs.Order
 	var shippingLine2, paymentMethod, stripeIntent sql.NullString
+	var shipsOn sql.NullTime
 
 	err := db.QueryRow(sqlQuery, orderNumber).Scan(
 		&order.ID, &order.OrderNumber, &order.CustomerEmail, &order.CustomerName,
 		&order.ShippingAddressLine1, &shippingLine2,
 		&order.ShippingCity, &order.ShippingState, &order.ShippingZip, &order.ShippingCountry,
 		&order.Subtotal, &order.Tax, &order.ShippingCost, &order.Total,
-		&order.PaymentStatus, &order.FulfillmentStatus, &paymentMethod,
-		&stripeIntent, &order.CreatedAt, &order.UpdatedAt,
+		&order.StoreCredit, &order.PaymentStatus, &order.FulfillmentStatus, &paymentMethod,
+		&stripeIntent, &shipsOn, &order.CreatedAt, &order.UpdatedAt,
 	)
 
 	if err != nil {
@@ -993,6 +2524,9 @@ func (db *DBConnection) GetOrder(orderNumber string) (structs.Order, error) {
 	order.ShippingAddressLine2 = shippingLine2.String
 	order.PaymentMethod = paymentMethod.String
 	order.StripePaymentIntent = stripeIntent.String
+	if shipsOn.Valid {
+		order.ShipsOn = &shipsOn.Time
+	}
 
 	// Get order items
 	order.Items, err = db.getOrderItems(order.ID)
@@ -1042,6 +2576,9 @@ func (db *DBConnection) UpdateOrderPaymentStatus(orderNumber string, status stri
 		WHERE order_number = ?
 	`
 	_, err := db.ExecuteQuery(sqlQuery, status, paymentIntentID, paymentMethod, orderNumber)
+	if err == nil {
+		Publish(db.Name, OrdersChanged)
+	}
 	return err
 }
 
@@ -1053,9 +2590,31 @@ func (db *DBConnection) UpdateOrderPaymentStatusByIntentID(paymentIntentID strin
 		WHERE stripe_payment_intent_id = ?
 	`
 	_, err := db.ExecuteQuery(sqlQuery, status, paymentIntentID)
+	if err == nil {
+		Publish(db.Name, OrdersChanged)
+	}
 	return err
 }
 
+// MarkOrderPaidByIntentID marks an order paid by payment intent ID, reporting whether it wasn't
+// paid already so each payment is only counted once
+func (db *DBConnection) MarkOrderPaidByIntentID(paymentIntentID string) (bool, error) {
+	sqlQuery := `
+		UPDATE orders
+		SET payment_status = 'paid', updated_at = NOW()
+		WHERE stripe_payment_intent_id = ? AND payment_status <> 'paid'
+	`
+	result, err := db.ExecuteQuery(sqlQuery, paymentIntentID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if n > 0 {
+		Publish(db.Name, OrdersChanged)
+	}
+	return n > 0, err
+}
+
 // UpdateOrderTrackingStatus updates the tracking status of an order
 func (db *DBConnection) UpdateOrderTrackingStatus(trackingNumber, trackingStatus string) error {
 	sqlQuery := `
@@ -1064,6 +2623,9 @@ func (db *DBConnection) UpdateOrderTrackingStatus(trackingNumber, trackingStatus
 		WHERE tracking_number = ?
 	`
 	_, err := db.ExecuteQuery(sqlQuery, trackingStatus, trackingNumber)
+	if err == nil {
+		Publish(db.Name, OrdersChanged)
+	}
 	return err
 }
 
@@ -1176,6 +2738,208 @@ func (db *DBConnection) GetOrderByPaymentIntentID(paymentIntentID string) (struc
 	return order, nil
 }
 
+// ErrDuplicateOrder means an order already exists for the PaymentIntent, see CreateOrder
+var ErrDuplicateOrder = errors.New("an order already exists for this payment intent")
+
+// isDuplicateKey reports whether err is MySQL refusing a row that repeats the unique index's value
+func isDuplicateKey(err error, index string) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, index)
+}
+
+// ErrStoreCreditCode means the store credit code given at checkout isn't the customer's
+var ErrStoreCreditCode = errors.New("store credit code doesn't match this email")
+
+// ErrInsufficientStoreCredit means the customer's balance no longer covers the credit applied
+var ErrInsufficientStoreCredit = errors.New("not enough store credit available")
+
+// ErrPartialStoreCredit means store credit was applied to an order without a card payment for the
+// rest, see CreateOrder
+var ErrPartialStoreCredit = errors.New("store credit doesn't cover this order, pay the rest by card")
+
+// AvailableStoreCredit returns the store credit balance of the customer with the email, who has to
+// give the code issued with their credit since checkout isn't signed in
+func (db *DBConnection) AvailableStoreCredit(email, code string) (float64, error) {
+	if email == "" || code == "" {
+		return 0, ErrStoreCreditCode
+	}
+
+	var balance float64
+	err := db.QueryRow(`
+		SELECT store_credit FROM customers
+		WHERE LOWER(email) = LOWER(?) AND store_credit_code = ?
+	`, email, strings.ToUpper(strings.TrimSpace(code))).Scan(&balance)
+	if err == sql.ErrNoRows {
+		return 0, ErrStoreCreditCode
+	}
+	return balance, err
+}
+
+// spendStoreCredit takes amount off a customer's balance and records it against orderID (nil while
+// it's only held), failing with ErrInsufficientStoreCredit rather than going negative. It returns
+// the store_credit_transactions row's ID
+func spendStoreCredit(tx *sql.Tx, customerID int, orderID interface{}, amount float64) (int64, error) {
+	result, err := tx.Exec(`
+		UPDATE customers SET store_credit = store_credit - ?
+		WHERE id = ? AND store_credit >= ?
+	`, amount, customerID, amount)
+	if err != nil {
+		return 0, err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return 0, ErrInsufficientStoreCredit
+	}
+
+	result, err = tx.Exec(`
+		INSERT INTO store_credit_transactions (customer_id, amount, balance_after, order_id, reason, created_at)
+		SELECT id, ?, store_credit, ?, 'checkout', NOW() FROM customers WHERE id = ?
+	`, -amount, orderID, customerID)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// HoldStoreCredit takes amount off the balance of the customer with the email and code and sets it
+// aside for a PaymentIntent, so the card is only charged for the rest once the credit is certain.
+// CreateOrder spends the hold; ReleaseStoreCreditHold gives it back if the payment never happens.
+// Fails with ErrStoreCreditCode or ErrInsufficientStoreCredit
+func (db *DBConnection) HoldStoreCredit(email, code, paymentIntentID string, amount float64, ttl time.Duration) error {
+	tx, err := db.Database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var customerID int
+	err = tx.QueryRow(`
+		SELECT id FROM customers
+		WHERE LOWER(email) = LOWER(?) AND store_credit_code = ?
+		FOR UPDATE
+	`, email, strings.ToUpper(strings.TrimSpace(code))).Scan(&customerID)
+	if err == sql.ErrNoRows {
+		return ErrStoreCreditCode
+	} else if err != nil {
+		return err
+	}
+
+	transactionID, err := spendStoreCredit(tx, customerID, nil, amount)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO store_credit_holds (payment_intent_id, customer_id, amount, transaction_id, expires_at, created_at)
+		VALUES (?, ?, ?, ?, DATE_ADD(NOW(), INTERVAL ? SECOND), NOW())
+	`, paymentIntentID, customerID, amount, transactionID, int(ttl.Seconds()))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// storeCreditHold is store credit set aside for a PaymentIntent, see HoldStoreCredit
+type storeCreditHold struct {
+	paymentIntentID string
+	customerID      int
+	amount          float64
+	transactionID   int64
+}
+
+// lockStoreCreditHold returns the credit held for a PaymentIntent, locked until tx ends, and
+// whether there is any
+func lockStoreCreditHold(tx *sql.Tx, paymentIntentID string) (storeCreditHold, bool, error) {
+	hold := storeCreditHold{paymentIntentID: paymentIntentID}
+	if paymentIntentID == "" {
+		return hold, false, nil
+	}
+
+	err := tx.QueryRow(`
+		SELECT customer_id, amount, transaction_id FROM store_credit_holds
+		WHERE payment_intent_id = ?
+		FOR UPDATE
+	`, paymentIntentID).Scan(&hold.customerID, &hold.amount, &hold.transactionID)
+	if err == sql.ErrNoRows {
+		return hold, false, nil
+	}
+	return hold, err == nil, err
+}
+
+// StoreCreditHeld returns the store credit held for a PaymentIntent, 0 when there's no hold
+func (db *DBConnection) StoreCreditHeld(paymentIntentID string) (float64, error) {
+	var amount float64
+	err := db.Database.QueryRow(`SELECT amount FROM store_credit_holds WHERE payment_intent_id = ?`, paymentIntentID).Scan(&amount)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return amount, err
+}
+
+// claimStoreCreditHold turns a hold into credit spent on an order
+func claimStoreCreditHold(tx *sql.Tx, hold storeCreditHold, orderID int64) error {
+	if _, err := tx.Exec(`UPDATE store_credit_transactions SET order_id = ? WHERE id = ?`, orderID, hold.transactionID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM store_credit_holds WHERE payment_intent_id = ?`, hold.paymentIntentID)
+	return err
+}
+
+// ReleaseStoreCreditHold gives the credit held for a PaymentIntent back to the customer, for when
+// the intent is canceled or the hold expires before an order is placed. It reports whether there
+// was a hold to release
+func (db *DBConnection) ReleaseStoreCreditHold(paymentIntentID string) (bool, error) {
+	tx, err := db.Database.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	hold, held, err := lockStoreCreditHold(tx, paymentIntentID)
+	if err != nil || !held {
+		return false, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM store_credit_holds WHERE payment_intent_id = ?`, paymentIntentID); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`UPDATE customers SET store_credit = store_credit + ? WHERE id = ?`, hold.amount, hold.customerID); err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO store_credit_transactions (customer_id, amount, balance_after, order_id, reason, created_at)
+		SELECT id, ?, store_credit, NULL, 'checkout_released', NOW() FROM customers WHERE id = ?
+	`, hold.amount, hold.customerID)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// StaleStoreCreditHolds lists PaymentIntents whose store credit hold a new checkout can release:
+// those of the customer with the email, which the new checkout supersedes, and any that expired
+func (db *DBConnection) StaleStoreCreditHolds(email string) ([]string, error) {
+	rows, err := db.Database.Query(`
+		SELECT h.payment_intent_id FROM store_credit_holds h
+		JOIN customers c ON c.id = h.customer_id
+		WHERE LOWER(c.email) = LOWER(?) OR h.expires_at < NOW()
+		LIMIT 50
+	`, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 // GetOrCreateCustomer finds an existing customer by email or creates a new one
 // Email comparison is case-insensitive for deduplication
 func (db *DBConnection) GetOrCreateCustomer(email, firstName, lastName string) (structs.Customer, error) {
@@ -1276,6 +3040,8 @@ func (db *DBConnection) UpdateCustomerStripeID(customerID int, stripeCustomerID
 
 // CreateSMSSignup creates or updates an SMS signup entry
 func (db *DBConnection) CreateSMSSignup(countryCode, phone, email, source string) (int64, error) {
+	countryCode = utils.NormalizeDialCode(countryCode)
+
 	sqlQuery := `
 		INSERT INTO sms_signups (country_code, phone, email, source)
 		VALUES (?, ?, ?, ?)
@@ -1297,6 +3063,8 @@ func (db *DBConnection) CreateSMSSignup(countryCode, phone, email, source string
 
 // SetSMSVerificationCode sets a verification code for a phone number
 func (db *DBConnection) SetSMSVerificationCode(countryCode, phone, code string, expiresAt time.Time) error {
+	countryCode = utils.NormalizeDialCode(countryCode)
+
 	sqlQuery := `
 		INSERT INTO sms_signups (country_code, phone, verification_code, verification_expires_at, verified)
 		VALUES (?, ?, ?, ?, 0)
@@ -1312,6 +3080,8 @@ func (db *DBConnection) SetSMSVerificationCode(countryCode, phone, code string,
 
 // VerifySMSCode verifies the code and marks the signup as verified
 func (db *DBConnection) VerifySMSCode(countryCode, phone, code string) (bool, error) {
+	countryCode = utils.NormalizeDialCode(countryCode)
+
 	sqlQuery := `
 		UPDATE sms_signups
 		SET verified = 1, verification_code = NULL, verification_expires_at = NULL
@@ -1362,6 +3132,8 @@ func (db *DBConnection) GetVerifiedSMSSignups() ([]structs.SMSSignup, error) {
 
 // UnsubscribeSMS marks a phone number as unsubscribed
 func (db *DBConnection) UnsubscribeSMS(countryCode, phone string) error {
+	countryCode = utils.NormalizeDialCode(countryCode)
+
 	sqlQuery := `
 		UPDATE sms_signups
 		SET unsubscribed = 1, updated_at = NOW()
@@ -1422,3 +3194,102 @@ func (db *DBConnection) DeleteSMSSignup(signupID int) error {
 	_, err := db.ExecuteQuery(sqlQuery, signupID)
 	return err
 }
+
+// ValidSubscriptionIntervals are the billing intervals a subscription product can use
+var ValidSubscriptionIntervals = []string{"week", "month", "year"}
+
+// CreateSubscription records a new Stripe subscription and the cart snapshot its orders are built from
+func (db *DBConnection) CreateSubscription(sub structs.Subscription) error {
+	shippingJSON, err := json.Marshal(sub.ShippingAddress)
+	if err != nil {
+		return err
+	}
+	itemsJSON, err := json.Marshal(sub.Items)
+	if err != nil {
+		return err
+	}
+
+	var customerID interface{}
+	if sub.CustomerID != nil {
+		customerID = *sub.CustomerID
+	}
+
+	sqlQuery := `
+		INSERT INTO subscriptions (
+			stripe_subscription_id, customer_id, customer_email, customer_name, cart_id,
+			shipping_address, items, billing_interval, amount, status
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = db.ExecuteQuery(sqlQuery,
+		sub.StripeSubscriptionID, customerID, sub.CustomerEmail, sub.CustomerName, sub.CartID,
+		string(shippingJSON), string(itemsJSON), sub.BillingInterval, sub.Amount, sub.Status,
+	)
+	return err
+}
+
+// GetSubscriptionByStripeID retrieves a subscription by its Stripe subscription ID
+func (db *DBConnection) GetSubscriptionByStripeID(stripeSubscriptionID string) (structs.Subscription, error) {
+	sqlQuery := `
+		SELECT id, stripe_subscription_id, customer_id, customer_email, customer_name, IFNULL(cart_id, ''),
+			shipping_address, items, billing_interval, amount, status, created_at, updated_at
+		FROM subscriptions
+		WHERE stripe_subscription_id = ?
+	`
+
+	var sub structs.Subscription
+	var customerID sql.NullInt64
+	var shippingJSON, itemsJSON string
+	err := db.QueryRow(sqlQuery, stripeSubscriptionID).Scan(
+		&sub.ID, &sub.StripeSubscriptionID, &customerID, &sub.CustomerEmail, &sub.CustomerName, &sub.CartID,
+		&shippingJSON, &itemsJSON, &sub.BillingInterval, &sub.Amount, &sub.Status, &sub.CreatedAt, &sub.UpdatedAt,
+	)
+	if err != nil {
+		return structs.Subscription{}, err
+	}
+
+	if customerID.Valid {
+		id := int(customerID.Int64)
+		sub.CustomerID = &id
+	}
+	if err := json.Unmarshal([]byte(shippingJSON), &sub.ShippingAddress); err != nil {
+		return structs.Subscription{}, fmt.Errorf("invalid shipping address for subscription %s: %v", stripeSubscriptionID, err)
+	}
+	if err := json.Unmarshal([]byte(itemsJSON), &sub.Items); err != nil {
+		return structs.Subscription{}, fmt.Errorf("invalid items for subscription %s: %v", stripeSubscriptionID, err)
+	}
+
+	return sub, nil
+}
+
+// UpdateSubscriptionStatus updates the status of a subscription, mirroring Stripe's status
+func (db *DBConnection) UpdateSubscriptionStatus(stripeSubscriptionID string, status string) error {
+	sqlQuery := `UPDATE subscriptions SET status = ?, updated_at = NOW() WHERE stripe_subscription_id = ?`
+	_, err := db.ExecuteQuery(sqlQuery, status, stripeSubscriptionID)
+	return err
+}
+
+// webhookLogDays is how long webhook deliveries are kept
+const webhookLogDays = 30
+
+// RecordWebhook logs an inbound webhook delivery and the status it was answered with, dropping
+// entries older than webhookLogDays. body should already be redacted
+func (db *DBConnection) RecordWebhook(provider, eventType, eventID string, statusCode int, test bool, body string) error {
+	if len(eventType) > 100 {
+		eventType = eventType[:100]
+	}
+	if len(eventID) > 255 {
+		eventID = eventID[:255]
+	}
+
+	sqlQuery := `
+		INSERT INTO webhook_log (provider, event_type, event_id, status_code, test, body)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	if _, err := db.ExecuteQuery(sqlQuery, provider, eventType, eventID, statusCode, test, body); err != nil {
+		return err
+	}
+
+	sqlQuery = `DELETE FROM webhook_log WHERE received_at < NOW() - INTERVAL ? DAY`
+	_, err := db.ExecuteQuery(sqlQuery, webhookLogDays)
+	return err
+}
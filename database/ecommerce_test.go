@@ -0,0 +1,601 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/murdinc/stencil2/structs"
+	"github.com/murdinc/stencil2/utils"
+)
+
+// testDB connects to the MySQL database in STENCIL_TEST_DSN, e.g.
+// "user:pass@tcp(127.0.0.1:3306)/stencil_test?parseTime=true", and creates the ecommerce tables.
+// Tests that need a database are skipped when it isn't set
+func testDB(t *testing.T) *DBConnection {
+	t.Helper()
+	dsn := os.Getenv("STENCIL_TEST_DSN")
+	if dsn == "" {
+		t.Skip("STENCIL_TEST_DSN not set")
+	}
+
+	pool, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	if err := pool.Ping(); err != nil {
+		t.Fatalf("connecting to the test database: %v", err)
+	}
+
+	db := &DBConnection{Database: pool, Connected: true, Name: "stencil_test"}
+	if err := db.InitEcommerceTables(); err != nil {
+		t.Fatalf("creating the ecommerce tables: %v", err)
+	}
+	return db
+}
+
+// createTestProduct inserts a published product and returns its ID
+func createTestProduct(t *testing.T, db *DBConnection, price float64, inventory int) int {
+	t.Helper()
+	slug := fmt.Sprintf("test-product-%d", time.Now().UnixNano())
+	result, err := db.ExecuteQuery(`
+		INSERT INTO products_unified (name, slug, price, inventory_quantity, inventory_policy, status)
+		VALUES (?, ?, ?, ?, 'deny', 'published')
+	`, slug, slug, price, inventory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := result.LastInsertId()
+	t.Cleanup(func() {
+		db.ExecuteQuery(`DELETE FROM product_variants WHERE product_id = ?`, id)
+		db.ExecuteQuery(`DELETE FROM products_unified WHERE id = ?`, id)
+	})
+	return int(id)
+}
+
+// createTestVariant adds a variant to a product and returns its ID
+func createTestVariant(t *testing.T, db *DBConnection, productID int, priceModifier float64, inventory int) int {
+	t.Helper()
+	result, err := db.ExecuteQuery(`
+		INSERT INTO product_variants (product_id, title, price_modifier, inventory_quantity)
+		VALUES (?, 'Test variant', ?, ?)
+	`, productID, priceModifier, inventory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := result.LastInsertId()
+	return int(id)
+}
+
+// testCart starts an empty cart and returns its session ID
+func testCart(t *testing.T, db *DBConnection) string {
+	t.Helper()
+	sessionID := fmt.Sprintf("test-cart-%d", time.Now().UnixNano())
+	if _, err := db.GetCart(sessionID); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.ClearCart(sessionID)
+		db.ExecuteQuery(`DELETE FROM carts WHERE id = ?`, sessionID)
+	})
+	return sessionID
+}
+
+func TestDiscountPercent(t *testing.T) {
+	tests := []struct {
+		name           string
+		price          float64
+		compareAtPrice float64
+		want           float64
+	}{
+		{"no compare price", 20, 0, 0},
+		{"compare price equal to price", 20, 20, 0},
+		{"compare price below price", 25, 20, 0},
+		{"quarter off", 15, 20, 25},
+		{"rounds to the nearest percent", 19.99, 29.99, 33},
+		{"free", 0, 10, 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := discountPercent(tt.price, tt.compareAtPrice); got != tt.want {
+				t.Errorf("discountPercent(%v, %v) = %v, want %v", tt.price, tt.compareAtPrice, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddToCartVariantMismatch(t *testing.T) {
+	db := testDB(t)
+	productID := createTestProduct(t, db, 20, 10)
+	otherProductID := createTestProduct(t, db, 30, 10)
+	otherVariantID := createTestVariant(t, db, otherProductID, 0, 10)
+	sessionID := testCart(t, db)
+
+	if err := db.AddToCart(sessionID, productID, otherVariantID, 1); !errors.Is(err, ErrVariantMismatch) {
+		t.Errorf("adding another product's variant: got %v, want ErrVariantMismatch", err)
+	}
+	if err := db.AddToCart(sessionID, productID, otherVariantID+1000000, 1); !errors.Is(err, ErrVariantMismatch) {
+		t.Errorf("adding a variant that doesn't exist: got %v, want ErrVariantMismatch", err)
+	}
+
+	cart, err := db.GetCart(sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cart.Items) != 0 {
+		t.Errorf("cart has %d items after rejected adds, want 0", len(cart.Items))
+	}
+}
+
+func TestAddToCartVariantPrice(t *testing.T) {
+	db := testDB(t)
+	productID := createTestProduct(t, db, 20, 10)
+	variantID := createTestVariant(t, db, productID, 4.5, 10)
+	sessionID := testCart(t, db)
+
+	if err := db.AddToCart(sessionID, productID, variantID, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	cart, err := db.GetCart(sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cart.Items) != 1 {
+		t.Fatalf("cart has %d items, want 1", len(cart.Items))
+	}
+	item := cart.Items[0]
+	if item.Price != 24.5 {
+		t.Errorf("line price = %v, want 24.5 (product price plus the variant's modifier)", item.Price)
+	}
+	if item.Total != 49 {
+		t.Errorf("line total = %v, want 49", item.Total)
+	}
+	if cart.Subtotal != 49 {
+		t.Errorf("subtotal = %v, want 49", cart.Subtotal)
+	}
+}
+
+func TestMaxPerOrderAcrossVariants(t *testing.T) {
+	db := testDB(t)
+	productID := createTestProduct(t, db, 20, 10)
+	small := createTestVariant(t, db, productID, 0, 10)
+	medium := createTestVariant(t, db, productID, 0, 10)
+	large := createTestVariant(t, db, productID, 0, 10)
+	if _, err := db.ExecuteQuery(`UPDATE products_unified SET max_per_order = 2 WHERE id = ?`, productID); err != nil {
+		t.Fatal(err)
+	}
+	sessionID := testCart(t, db)
+
+	// The product's limit covers all its variants together
+	if err := db.AddToCart(sessionID, productID, small, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddToCart(sessionID, productID, medium, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddToCart(sessionID, productID, large, 1); !errors.Is(err, ErrMaxPerOrderExceeded) {
+		t.Errorf("adding a third variant: got %v, want ErrMaxPerOrderExceeded", err)
+	}
+	cart, err := db.GetCart(sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cart.Items) != 2 {
+		t.Fatalf("cart has %d items, want 2", len(cart.Items))
+	}
+	if err := db.UpdateCartItem(cart.Items[0].ID, 2); !errors.Is(err, ErrMaxPerOrderExceeded) {
+		t.Errorf("raising one line past the product's limit: got %v, want ErrMaxPerOrderExceeded", err)
+	}
+	if err := db.CheckMaxPerOrder(productID, small, 1, 3); !errors.Is(err, ErrMaxPerOrderExceeded) {
+		t.Errorf("checking out 3 across variants: got %v, want ErrMaxPerOrderExceeded", err)
+	}
+
+	// A variant's own limit only covers its line
+	if _, err := db.ExecuteQuery(`UPDATE product_variants SET max_per_order = 3 WHERE id = ?`, large); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddToCart(sessionID, productID, large, 3); err != nil {
+		t.Errorf("adding up to the variant's own limit: %v", err)
+	}
+	if err := db.AddToCart(sessionID, productID, large, 1); !errors.Is(err, ErrMaxPerOrderExceeded) {
+		t.Errorf("adding past the variant's own limit: got %v, want ErrMaxPerOrderExceeded", err)
+	}
+}
+
+func TestCreateOrderConcurrentOrderNumbers(t *testing.T) {
+	db := testDB(t)
+	const orders = 25
+	productID := createTestProduct(t, db, 10, orders)
+	prefix := fmt.Sprintf("T%d-", time.Now().UnixNano()%1e9)
+	t.Cleanup(func() {
+		db.ExecuteQuery(`DELETE oi FROM order_items oi JOIN orders o ON o.id = oi.order_id WHERE o.order_number LIKE ?`, prefix+"%")
+		db.ExecuteQuery(`DELETE FROM orders WHERE order_number LIKE ?`, prefix+"%")
+		db.ExecuteQuery(`DELETE FROM customers WHERE email LIKE ?`, prefix+"%")
+	})
+
+	var wg sync.WaitGroup
+	numbers := make([]string, orders)
+	errs := make([]error, orders)
+	for i := 0; i < orders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			order, err := db.CreateOrder(map[string]interface{}{
+				"order_number_prefix": prefix,
+				"email":               fmt.Sprintf("%sbuyer%d@example.com", prefix, i),
+				"cart_items": []structs.CartItem{{
+					ProductID: productID,
+					Product:   structs.Product{Name: "Test product"},
+					Quantity:  1,
+					Price:     10,
+					Total:     10,
+				}},
+				"shipping_address": map[string]interface{}{
+					"first_name": "Test",
+					"last_name":  "Buyer",
+					"address":    "1 Main St",
+					"city":       "Springfield",
+					"state":      "IL",
+					"zip":        "62701",
+					"country":    "US",
+				},
+			})
+			numbers[i], errs[i] = order.OrderNumber, err
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i, number := range numbers {
+		if errs[i] != nil {
+			t.Fatalf("order %d: %v", i, errs[i])
+		}
+		if seen[number] {
+			t.Errorf("order number %s was given out twice", number)
+		}
+		seen[number] = true
+	}
+}
+
+func TestCreateOrderDuplicatePaymentIntent(t *testing.T) {
+	db := testDB(t)
+	productID := createTestProduct(t, db, 10, 10)
+	email := fmt.Sprintf("dup%d@example.com", time.Now().UnixNano())
+	paymentIntentID := fmt.Sprintf("pi_test_dup_%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		db.ExecuteQuery(`DELETE oi FROM order_items oi JOIN orders o ON o.id = oi.order_id WHERE o.customer_email = ?`, email)
+		db.ExecuteQuery(`DELETE FROM orders WHERE customer_email = ?`, email)
+		db.ExecuteQuery(`DELETE FROM customers WHERE email = ?`, email)
+	})
+	orderData := func() map[string]interface{} {
+		return map[string]interface{}{
+			"email":             email,
+			"payment_intent_id": paymentIntentID,
+			"cart_items": []structs.CartItem{{
+				ProductID: productID,
+				Product:   structs.Product{Name: "Test product"},
+				Quantity:  1,
+				Price:     10,
+				Total:     10,
+			}},
+			"shipping_address": map[string]interface{}{
+				"first_name": "Test",
+				"last_name":  "Buyer",
+				"address":    "1 Main St",
+				"city":       "Springfield",
+				"state":      "IL",
+				"zip":        "62701",
+				"country":    "US",
+			},
+		}
+	}
+
+	if _, err := db.CreateOrder(orderData()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateOrder(orderData()); !errors.Is(err, ErrDuplicateOrder) {
+		t.Errorf("second order for the payment intent: got %v, want ErrDuplicateOrder", err)
+	}
+}
+
+func TestOrderTotals(t *testing.T) {
+	tests := []struct {
+		name         string
+		subtotal     float64
+		taxRate      float64
+		shipping     float64
+		taxInclusive bool
+		rounding     string
+		wantTax      float64
+		wantTotal    float64
+	}{
+		{"exclusive", 100, 0.08, 5, false, "", 8, 113},
+		{"exclusive rounds the tax", 19.99, 0.0825, 0, false, "", 1.65, 21.64},
+		{"exclusive half cent rounds up", 10.50, 0.05, 0, false, utils.RoundHalfUp, 0.53, 11.03},
+		{"exclusive half cent rounds to even", 10.50, 0.05, 0, false, utils.RoundHalfEven, 0.52, 11.02},
+		{"exclusive without tax", 42.42, 0, 3.5, false, "", 0, 45.92},
+		{"inclusive", 108, 0.08, 5, true, "", 8, 113},
+		{"inclusive backs out the tax", 19.99, 0.0825, 0, true, "", 1.52, 19.99},
+		{"inclusive without tax", 42.42, 0, 3.5, true, "", 0, 45.92},
+		{"inclusive total doesn't drift", 0.1 + 0.2, 0.2, 0, true, "", 0.05, 0.3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tax, total := OrderTotals(tt.subtotal, tt.taxRate, tt.shipping, tt.taxInclusive, tt.rounding)
+			if tax != tt.wantTax || total != tt.wantTotal {
+				t.Errorf("OrderTotals(%v, %v, %v, %v) = tax %v, total %v; want tax %v, total %v",
+					tt.subtotal, tt.taxRate, tt.shipping, tt.taxInclusive, tax, total, tt.wantTax, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestIncludedTax(t *testing.T) {
+	tests := []struct {
+		amount  float64
+		taxRate float64
+		want    float64
+	}{
+		{108, 0.08, 8},
+		{19.99, 0.0825, 1.52},
+		{120, 0.2, 20},
+		{9.99, 0.2, 1.67},
+		{50, 0, 0},
+		{50, -0.1, 0},
+	}
+
+	for _, tt := range tests {
+		if got := IncludedTax(tt.amount, tt.taxRate, ""); got != tt.want {
+			t.Errorf("IncludedTax(%v, %v) = %v, want %v", tt.amount, tt.taxRate, got, tt.want)
+		}
+	}
+}
+
+func TestStoreCreditHold(t *testing.T) {
+	db := testDB(t)
+	productID := createTestProduct(t, db, 30, 10)
+	email := fmt.Sprintf("credit%d@example.com", time.Now().UnixNano())
+	const code = "TESTCREDIT"
+	_, err := db.ExecuteQuery(`
+		INSERT INTO customers (email, first_name, last_name, store_credit, store_credit_code)
+		VALUES (?, 'Test', 'Buyer', 25, ?)
+	`, email, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.ExecuteQuery(`DELETE FROM store_credit_holds WHERE customer_id IN (SELECT id FROM customers WHERE email = ?)`, email)
+		db.ExecuteQuery(`DELETE FROM store_credit_transactions WHERE customer_id IN (SELECT id FROM customers WHERE email = ?)`, email)
+		db.ExecuteQuery(`DELETE oi FROM order_items oi JOIN orders o ON o.id = oi.order_id WHERE o.customer_email = ?`, email)
+		db.ExecuteQuery(`DELETE FROM orders WHERE customer_email = ?`, email)
+		db.ExecuteQuery(`DELETE FROM customers WHERE email = ?`, email)
+	})
+	balance := func() float64 {
+		t.Helper()
+		credit, err := db.AvailableStoreCredit(email, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return credit
+	}
+	intentID := func(name string) string {
+		return fmt.Sprintf("pi_test_%s_%d", name, time.Now().UnixNano())
+	}
+
+	// A hold takes the credit off the balance, so a second checkout can't spend it too
+	canceled := intentID("canceled")
+	if err := db.HoldStoreCredit(email, code, canceled, 20, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if got := balance(); got != 5 {
+		t.Errorf("balance while held = %v, want 5", got)
+	}
+	if err := db.HoldStoreCredit(email, code, intentID("second"), 20, time.Hour); !errors.Is(err, ErrInsufficientStoreCredit) {
+		t.Errorf("holding more than the balance: got %v, want ErrInsufficientStoreCredit", err)
+	}
+
+	// Releasing gives it back, once
+	if released, err := db.ReleaseStoreCreditHold(canceled); err != nil || !released {
+		t.Fatalf("ReleaseStoreCreditHold = %v, %v; want true", released, err)
+	}
+	if released, err := db.ReleaseStoreCreditHold(canceled); err != nil || released {
+		t.Errorf("releasing again = %v, %v; want false", released, err)
+	}
+	if got := balance(); got != 25 {
+		t.Errorf("balance after release = %v, want 25", got)
+	}
+
+	// The order placed with the intent spends the hold, whatever the body claims
+	paid := intentID("paid")
+	if err := db.HoldStoreCredit(email, code, paid, 20, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	order, err := db.CreateOrder(map[string]interface{}{
+		"email":             email,
+		"payment_intent_id": paid,
+		"store_credit":      25.0,
+		"cart_items": []structs.CartItem{{
+			ProductID: productID,
+			Product:   structs.Product{Name: "Test product"},
+			Quantity:  1,
+			Price:     30,
+			Total:     30,
+		}},
+		"shipping_address": map[string]interface{}{
+			"first_name": "Test",
+			"last_name":  "Buyer",
+			"address":    "1 Main St",
+			"city":       "Springfield",
+			"state":      "IL",
+			"zip":        "62701",
+			"country":    "US",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.StoreCredit != 20 {
+		t.Errorf("order store credit = %v, want the 20 held", order.StoreCredit)
+	}
+	if got := balance(); got != 5 {
+		t.Errorf("balance after the order = %v, want 5", got)
+	}
+	if released, err := db.ReleaseStoreCreditHold(paid); err != nil || released {
+		t.Errorf("releasing a spent hold = %v, %v; want false", released, err)
+	}
+
+	// Without a hold the card paid for everything, so claimed credit isn't spent
+	order, err = db.CreateOrder(map[string]interface{}{
+		"email":             email,
+		"payment_intent_id": intentID("unheld"),
+		"store_credit":      5.0,
+		"cart_items": []structs.CartItem{{
+			ProductID: productID,
+			Product:   structs.Product{Name: "Test product"},
+			Quantity:  1,
+			Price:     30,
+			Total:     30,
+		}},
+		"shipping_address": map[string]interface{}{
+			"first_name": "Test",
+			"last_name":  "Buyer",
+			"address":    "1 Main St",
+			"city":       "Springfield",
+			"state":      "IL",
+			"zip":        "62701",
+			"country":    "US",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.StoreCredit != 0 || order.PaymentStatus != "pending" {
+		t.Errorf("unheld order store credit = %v, status %q; want 0, pending", order.StoreCredit, order.PaymentStatus)
+	}
+	if got := balance(); got != 5 {
+		t.Errorf("balance after the unheld order = %v, want 5", got)
+	}
+
+	// Without a card payment, credit that only covers part of the order would leave the rest unpaid
+	_, err = db.CreateOrder(map[string]interface{}{
+		"email":        email,
+		"store_credit": 5.0,
+		"cart_items": []structs.CartItem{{
+			ProductID: productID,
+			Product:   structs.Product{Name: "Test product"},
+			Quantity:  1,
+			Price:     30,
+			Total:     30,
+		}},
+		"shipping_address": map[string]interface{}{
+			"first_name": "Test",
+			"last_name":  "Buyer",
+			"address":    "1 Main St",
+			"city":       "Springfield",
+			"state":      "IL",
+			"zip":        "62701",
+			"country":    "US",
+		},
+	})
+	if !errors.Is(err, ErrPartialStoreCredit) {
+		t.Errorf("partial credit without a payment intent: got %v, want ErrPartialStoreCredit", err)
+	}
+	if got := balance(); got != 5 {
+		t.Errorf("balance after the refused order = %v, want 5", got)
+	}
+
+	// An item that's out of stock takes the order with it, leaving the hold unspent
+	outOfStock := intentID("outofstock")
+	if err := db.HoldStoreCredit(email, code, outOfStock, 5, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.CreateOrder(map[string]interface{}{
+		"email":             email,
+		"payment_intent_id": outOfStock,
+		"cart_items": []structs.CartItem{{
+			ProductID: productID,
+			Product:   structs.Product{Name: "Test product"},
+			Quantity:  100,
+			Price:     30,
+			Total:     3000,
+		}},
+		"shipping_address": map[string]interface{}{
+			"first_name": "Test",
+			"last_name":  "Buyer",
+			"address":    "1 Main St",
+			"city":       "Springfield",
+			"state":      "IL",
+			"zip":        "62701",
+			"country":    "US",
+		},
+	})
+	if err == nil {
+		t.Fatal("ordering more than is in stock succeeded")
+	}
+	if _, err := db.GetOrderByPaymentIntentID(outOfStock); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("order for the failed checkout: got %v, want sql.ErrNoRows", err)
+	}
+	if released, err := db.ReleaseStoreCreditHold(outOfStock); err != nil || !released {
+		t.Errorf("releasing the failed checkout's hold = %v, %v; want true", released, err)
+	}
+	if got := balance(); got != 5 {
+		t.Errorf("balance after the failed order = %v, want 5", got)
+	}
+}
+
+func TestCheckoutSnapshot(t *testing.T) {
+	db := testDB(t)
+	checkoutSessionID := fmt.Sprintf("cs_test_%d", time.Now().UnixNano())
+	t.Cleanup(func() { db.DeleteCheckoutSnapshot(checkoutSessionID) })
+
+	err := db.SaveCheckoutSnapshot(checkoutSessionID, CheckoutSnapshot{
+		CartSessionID: "test-cart",
+		Items: []structs.CartItem{{
+			ProductID: 7,
+			VariantID: 9,
+			Product:   structs.Product{Name: "Mug", Description: "Not kept"},
+			Variant:   structs.ProductVariant{Title: "Blue"},
+			Quantity:  2,
+			Price:     12.5,
+			Total:     25,
+		}},
+		AmountTotal: 2500,
+		Currency:    "usd",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := db.GetCheckoutSnapshot(checkoutSessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.CartSessionID != "test-cart" || snapshot.AmountTotal != 2500 || snapshot.Currency != "usd" {
+		t.Errorf("snapshot = %+v", snapshot)
+	}
+	if len(snapshot.Items) != 1 {
+		t.Fatalf("snapshot has %d items, want 1", len(snapshot.Items))
+	}
+	item := snapshot.Items[0]
+	if item.ProductID != 7 || item.VariantID != 9 || item.Product.Name != "Mug" || item.Variant.Title != "Blue" || item.Quantity != 2 || item.Total != 25 {
+		t.Errorf("item = %+v", item)
+	}
+	if item.Product.Description != "" {
+		t.Errorf("kept the product description, only what orders need should be")
+	}
+
+	if err := db.DeleteCheckoutSnapshot(checkoutSessionID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetCheckoutSnapshot(checkoutSessionID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("after delete: got %v, want sql.ErrNoRows", err)
+	}
+}
@@ -0,0 +1,60 @@
+package database
+
+import "sync"
+
+// ContentEvent names a kind of site content that changed, so in-memory caches of it know to drop
+// what they hold. Events are published by database name, which the admin, frontend and API all
+// know a site by
+type ContentEvent string
+
+const (
+	ProductsChanged    ContentEvent = "products"    // products, variants, stock, prices, sales, reviews
+	CollectionsChanged ContentEvent = "collections" // collections and which products are in them
+	ArticlesChanged    ContentEvent = "articles"    // articles, categories, images
+	SettingsChanged    ContentEvent = "settings"    // the site's config (currency, tax, shipping etc.)
+	OrdersChanged      ContentEvent = "orders"      // orders placed, paid, refunded or shipped from the storefront
+)
+
+var (
+	subscribersMu sync.RWMutex
+	subscribers   = make(map[string][]func(ContentEvent))
+	allSites      []func(dbName string, event ContentEvent)
+)
+
+// Subscribe calls fn for every event published for the site using dbName. fn runs on the
+// publisher's goroutine, so it should be quick and must not publish itself
+func Subscribe(dbName string, fn func(ContentEvent)) {
+	subscribersMu.Lock()
+	subscribers[dbName] = append(subscribers[dbName], fn)
+	subscribersMu.Unlock()
+}
+
+// SubscribeAll calls fn for every event published for any site, including sites added after it's
+// called. The same rules as Subscribe apply to fn
+func SubscribeAll(fn func(dbName string, event ContentEvent)) {
+	subscribersMu.Lock()
+	allSites = append(allSites, fn)
+	subscribersMu.Unlock()
+}
+
+// Publish tells the site's subscribers that events happened. Duplicate events are sent once
+func Publish(dbName string, events ...ContentEvent) {
+	subscribersMu.RLock()
+	fns := subscribers[dbName]
+	all := allSites
+	subscribersMu.RUnlock()
+
+	seen := make(map[ContentEvent]bool, len(events))
+	for _, event := range events {
+		if seen[event] {
+			continue
+		}
+		seen[event] = true
+		for _, fn := range fns {
+			fn(event)
+		}
+		for _, fn := range all {
+			fn(dbName, event)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package database
+
+import "testing"
+
+func TestSubscribeAll(t *testing.T) {
+	type published struct {
+		dbName string
+		event  ContentEvent
+	}
+	var got []published
+	SubscribeAll(func(dbName string, event ContentEvent) {
+		if dbName == "events_test_a" || dbName == "events_test_b" {
+			got = append(got, published{dbName, event})
+		}
+	})
+
+	var siteA []ContentEvent
+	Subscribe("events_test_a", func(event ContentEvent) { siteA = append(siteA, event) })
+
+	Publish("events_test_a", OrdersChanged, ProductsChanged, OrdersChanged)
+	Publish("events_test_b", OrdersChanged)
+
+	want := []published{{"events_test_a", OrdersChanged}, {"events_test_a", ProductsChanged}, {"events_test_b", OrdersChanged}}
+	if len(got) != len(want) {
+		t.Fatalf("SubscribeAll got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("SubscribeAll got %v, want %v", got, want)
+			break
+		}
+	}
+	if len(siteA) != 2 {
+		t.Errorf("site subscriber got %v, want each of site A's events once", siteA)
+	}
+}
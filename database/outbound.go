@@ -0,0 +1,113 @@
+package database
+
+import (
+	"time"
+
+	"github.com/murdinc/stencil2/structs"
+)
+
+// outboundLease is how long a claimed delivery is held before another dispatcher may take it, in
+// case the one sending it stopped part way
+const outboundLease = 5 * time.Minute
+
+// QueueOutboundDelivery queues a webhook for the endpoint, due straight away
+func (db *DBConnection) QueueOutboundDelivery(endpoint, event string, payload []byte) (int, error) {
+	result, err := db.ExecuteQuery(`
+		INSERT INTO outbound_deliveries (endpoint, event, payload, next_attempt_at)
+		VALUES (?, ?, ?, NOW())
+	`, endpoint, event, string(payload))
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// ClaimOutboundDeliveries returns up to limit deliveries that are due, oldest first, pushing their
+// next attempt back by the lease so they aren't picked up twice
+func (db *DBConnection) ClaimOutboundDeliveries(limit int) ([]structs.OutboundDelivery, error) {
+	rows, err := db.QueryRows(`
+		SELECT id, endpoint, event, payload, attempts, created_at
+		FROM outbound_deliveries
+		WHERE next_attempt_at <= NOW()
+		ORDER BY id
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var due []structs.OutboundDelivery
+	for rows.Next() {
+		var delivery structs.OutboundDelivery
+		var payload string
+		if err := rows.Scan(&delivery.ID, &delivery.Endpoint, &delivery.Event, &payload, &delivery.Attempts, &delivery.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		delivery.Payload = []byte(payload)
+		due = append(due, delivery)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []structs.OutboundDelivery
+	for _, delivery := range due {
+		result, err := db.ExecuteQuery(`
+			UPDATE outbound_deliveries
+			SET next_attempt_at = DATE_ADD(NOW(), INTERVAL ? SECOND)
+			WHERE id = ? AND next_attempt_at <= NOW()
+		`, int(outboundLease.Seconds()), delivery.ID)
+		if err != nil {
+			return claimed, err
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 1 {
+			claimed = append(claimed, delivery)
+		}
+	}
+
+	return claimed, nil
+}
+
+// DeleteOutboundDelivery removes a delivery that was sent
+func (db *DBConnection) DeleteOutboundDelivery(id int) error {
+	_, err := db.ExecuteQuery(`DELETE FROM outbound_deliveries WHERE id = ?`, id)
+	return err
+}
+
+// RetryOutboundDelivery records a failed attempt and schedules the next one after delay
+func (db *DBConnection) RetryOutboundDelivery(id, attempts, statusCode int, lastError string, delay time.Duration) error {
+	_, err := db.ExecuteQuery(`
+		UPDATE outbound_deliveries
+		SET attempts = ?, last_status_code = ?, last_error = ?, next_attempt_at = DATE_ADD(NOW(), INTERVAL ? SECOND)
+		WHERE id = ?
+	`, attempts, statusCode, lastError, int(delay.Seconds()), id)
+	return err
+}
+
+// DeadLetterOutboundDelivery moves a delivery that won't be tried again to outbound_dead_letters
+func (db *DBConnection) DeadLetterOutboundDelivery(id, attempts, statusCode int, lastError string) error {
+	tx, err := db.Database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO outbound_dead_letters (endpoint, event, payload, attempts, last_status_code, last_error, created_at)
+		SELECT endpoint, event, payload, ?, ?, ?, created_at
+		FROM outbound_deliveries
+		WHERE id = ?
+	`, attempts, statusCode, lastError, id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM outbound_deliveries WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/murdinc/stencil2/structs"
@@ -36,36 +37,144 @@ func (db *DBConnection) GetCategories(params map[string]string) ([]structs.Categ
 			A.name,
 			A.slug,
 			ifnull(A.description, '') as description,
+			ifnull(A.parent_id, 0) as parent_id,
 			A.count
 			%s
 		FROM categories_unified A
 		%s
-		WHERE A.count > 0 ORDER BY
+		ORDER BY
 		A.name ASC
 	`, fullCategory, fullCategoryJoin)
 
-	rows, err := db.QueryRows(sqlQuery)
+	rows, err := db.ReadRows(sqlQuery)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var categories []structs.Category
+	var all []structs.Category
 	for rows.Next() {
 		var category structs.Category
 		if err := rows.Scan(
-			&category.ID, &category.Name, &category.Slug, &category.Description, &category.Count,
+			&category.ID, &category.Name, &category.Slug, &category.Description, &category.ParentID, &category.Count,
 			&category.ImageUrl, &category.AltText,
 		); err != nil {
 			return nil, err
 		}
 
-		categories = append(categories, category)
+		all = append(all, category)
+	}
+
+	if params["tree"] == "true" {
+		return buildCategoryTree(all), nil
+	}
+
+	// Flat listing only includes categories that have posts
+	var categories []structs.Category
+	for _, category := range all {
+		if category.Count > 0 {
+			categories = append(categories, category)
+		}
 	}
 
 	return categories, nil
 }
 
+// buildCategoryTree nests categories under their parents, dropping branches without any posts.
+// Categories whose parent no longer exists are treated as top-level
+func buildCategoryTree(categories []structs.Category) []structs.Category {
+	byID := make(map[int]bool, len(categories))
+	for _, category := range categories {
+		byID[category.ID] = true
+	}
+
+	childrenOf := make(map[int][]structs.Category)
+	for _, category := range categories {
+		parentID := category.ParentID
+		if parentID == category.ID || !byID[parentID] {
+			parentID = 0
+		}
+		childrenOf[parentID] = append(childrenOf[parentID], category)
+	}
+
+	visited := make(map[int]bool)
+	var attach func(parentID int) []structs.Category
+	attach = func(parentID int) []structs.Category {
+		var nodes []structs.Category
+		for _, category := range childrenOf[parentID] {
+			if visited[category.ID] {
+				continue // guard against parent cycles
+			}
+			visited[category.ID] = true
+
+			category.Children = attach(category.ID)
+			if category.Count == 0 && len(category.Children) == 0 {
+				continue
+			}
+			nodes = append(nodes, category)
+		}
+		return nodes
+	}
+
+	return attach(0)
+}
+
+// applyTranslation looks up the row's translation in table for the first of locales that has one
+// and copies its non-blank columns into fields. Returns the locale used, empty when none matched
+func (db *DBConnection) applyTranslation(table, idColumn string, id int, locales []string, columns []string, fields ...*string) (string, error) {
+	if len(locales) == 0 {
+		return "", nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(locales)), ", ")
+	args := []interface{}{id}
+	for _, locale := range locales {
+		args = append(args, locale)
+	}
+	for _, locale := range locales {
+		args = append(args, locale)
+	}
+
+	values := make([]sql.NullString, len(columns))
+	dest := []interface{}{new(string)}
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+
+	err := db.QueryRow(`
+		SELECT locale, `+strings.Join(columns, ", ")+`
+		FROM `+table+`
+		WHERE `+idColumn+` = ? AND locale IN (`+placeholders+`)
+		ORDER BY FIELD(locale, `+placeholders+`)
+		LIMIT 1
+	`, args...).Scan(dest...)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	for i, v := range values {
+		if strings.TrimSpace(v.String) != "" {
+			*fields[i] = v.String
+		}
+	}
+	return *dest[0].(*string), nil
+}
+
+// TranslatePost replaces a post's title, description, deck and content with the first of locales
+// it has a translation for, setting its Locale. Blank translated fields, or no translation at
+// all, leave the default language's text
+func (db *DBConnection) TranslatePost(post *structs.Post, locales []string) error {
+	locale, err := db.applyTranslation("article_translations", "article_id", post.ID, locales,
+		[]string{"title", "description", "deck", "content"}, &post.Title, &post.Description, &post.Deck, &post.Content)
+	if err != nil {
+		return err
+	}
+	post.Locale = locale
+	return nil
+}
+
 // GetSingularPost retrieves a singular post from the database
 func (db *DBConnection) GetSingularPost(vars map[string]string, params map[string]string) (structs.Post, error) {
 
@@ -227,43 +336,7 @@ func (db *DBConnection) GetMultiplePosts(vars map[string]string, params map[stri
 
 	offset, count := defaultOffsetCount(vars)
 
-	queryArgs := []interface{}{}
-
-	queryJoin := ``
-	queryWhereAnd := ``
-
-	if vars["slug"] != "" {
-		switch vars["taxonomy"] {
-		case "tag":
-			queryArgs = append(queryArgs, vars["slug"])
-			queryJoin = `
-				JOIN article_tags C ON C.post_id = A.id
-				JOIN tags_unified D ON D.id = C.tag_id
-				`
-			queryWhereAnd = `AND D.slug = ?`
-
-		case "category":
-			queryArgs = append(queryArgs, vars["slug"])
-			queryJoin = `
-				JOIN article_categories C ON C.post_id = A.id
-				JOIN categories_unified D ON D.id = C.category_id
-				`
-			queryWhereAnd = `AND D.slug = ?`
-
-		case "author":
-			queryArgs = append(queryArgs, vars["slug"])
-			queryJoin = `
-				JOIN article_authors C ON C.post_id = A.id
-				JOIN authors_unified D ON D.id = C.author_id
-				`
-			queryWhereAnd = `AND D.slug = ?`
-
-		case "type":
-			queryArgs = append(queryArgs, vars["slug"])
-
-			queryWhereAnd = `AND A.type = ?`
-		}
-	}
+	queryJoin, queryWhereAnd, queryArgs := postListFilter(vars)
 
 	sqlQuery := fmt.Sprintf(`
 		SELECT
@@ -298,7 +371,7 @@ func (db *DBConnection) GetMultiplePosts(vars map[string]string, params map[stri
 		LIMIT %d, %d;
 	`, fullFeed, queryJoin, queryWhereAnd, orderby, offset, count)
 
-	rows, err := db.QueryRows(sqlQuery, queryArgs...)
+	rows, err := db.ReadRows(sqlQuery, queryArgs...)
 	if err != nil {
 		return nil, err
 	}
@@ -544,6 +617,71 @@ func (db *DBConnection) GetAllPublishedCollections() ([]structs.Collection, erro
 	return collections, nil
 }
 
+// postListFilter builds the join, extra WHERE clause and args for a taxonomy-filtered post listing
+func postListFilter(vars map[string]string) (string, string, []interface{}) {
+	queryArgs := []interface{}{}
+	queryJoin := ``
+	queryWhereAnd := ``
+
+	if vars["slug"] != "" {
+		switch vars["taxonomy"] {
+		case "tag":
+			queryArgs = append(queryArgs, vars["slug"])
+			queryJoin = `
+				JOIN article_tags C ON C.post_id = A.id
+				JOIN tags_unified D ON D.id = C.tag_id
+				`
+			queryWhereAnd = `AND D.slug = ?`
+
+		case "category":
+			queryArgs = append(queryArgs, vars["slug"])
+			queryJoin = `
+				JOIN article_categories C ON C.post_id = A.id
+				JOIN categories_unified D ON D.id = C.category_id
+				`
+			queryWhereAnd = `AND D.slug = ?`
+
+		case "author":
+			queryArgs = append(queryArgs, vars["slug"])
+			queryJoin = `
+				JOIN article_authors C ON C.post_id = A.id
+				JOIN authors_unified D ON D.id = C.author_id
+				`
+			queryWhereAnd = `AND D.slug = ?`
+
+		case "type":
+			queryArgs = append(queryArgs, vars["slug"])
+
+			queryWhereAnd = `AND A.type = ?`
+		}
+	}
+
+	return queryJoin, queryWhereAnd, queryArgs
+}
+
+// CountMultiplePosts returns how many published posts match the listing filter GetMultiplePosts uses
+func (db *DBConnection) CountMultiplePosts(vars map[string]string) (int, error) {
+	queryJoin, queryWhereAnd, queryArgs := postListFilter(vars)
+
+	sqlQuery := fmt.Sprintf(`
+		SELECT COUNT(DISTINCT A.id)
+		FROM articles_unified A
+		%s
+		WHERE A.status = 'published' AND A.type NOT IN ('page') %s
+	`, queryJoin, queryWhereAnd)
+
+	var total int
+	if err := db.ReadRow(sqlQuery, queryArgs...).Scan(&total); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// ListWindow returns the offset and count a listing request resolves to, for pagination metadata
+func ListWindow(vars map[string]string) (int, int) {
+	return defaultOffsetCount(vars)
+}
+
 func defaultOffsetCount(vars map[string]string) (int, int) {
 
 	// Convert offset to an integer, default to 0 if not a number
@@ -0,0 +1,209 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/murdinc/stencil2/structs"
+)
+
+// Return statuses. A return goes requested -> approved -> received -> refunded, or is rejected
+// from requested
+const (
+	ReturnRequested = "requested"
+	ReturnApproved  = "approved"
+	ReturnRejected  = "rejected"
+	ReturnReceived  = "received"
+	ReturnRefunded  = "refunded"
+)
+
+// maxReturnReasonLength caps the reason a customer gives for a return
+const maxReturnReasonLength = 1000
+
+// Errors returned by CreateReturn for requests the customer can fix or that aren't allowed
+var (
+	ErrReturnNotDelivered  = errors.New("only delivered orders can be returned")
+	ErrReturnReason        = errors.New("a reason for the return is required")
+	ErrReturnNoItems       = errors.New("choose at least one item to return")
+	ErrReturnItemNotFound  = errors.New("item is not part of this order")
+	ErrReturnQuantityLimit = errors.New("more items requested than were ordered and not already returned")
+)
+
+// CreateReturn records a customer's request to return items from a delivered, paid order. Only
+// OrderItemID and Quantity of each item are used; quantities for the same line are added up
+func (db *DBConnection) CreateReturn(order structs.Order, reason string, items []structs.OrderReturnItem) (structs.OrderReturn, error) {
+	if order.FulfillmentStatus != "delivered" || order.PaymentStatus != "paid" {
+		return structs.OrderReturn{}, ErrReturnNotDelivered
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return structs.OrderReturn{}, ErrReturnReason
+	}
+	if len(reason) > maxReturnReasonLength {
+		reason = reason[:maxReturnReasonLength]
+	}
+
+	orderItems := make(map[int]structs.OrderItem, len(order.Items))
+	for _, item := range order.Items {
+		orderItems[item.ID] = item
+	}
+
+	requested := make(map[int]int)
+	var lineIDs []int
+	for _, item := range items {
+		if item.Quantity <= 0 {
+			continue
+		}
+		if _, ok := orderItems[item.OrderItemID]; !ok {
+			return structs.OrderReturn{}, ErrReturnItemNotFound
+		}
+		if _, seen := requested[item.OrderItemID]; !seen {
+			lineIDs = append(lineIDs, item.OrderItemID)
+		}
+		requested[item.OrderItemID] += item.Quantity
+	}
+	if len(requested) == 0 {
+		return structs.OrderReturn{}, ErrReturnNoItems
+	}
+
+	tx, err := db.Database.Begin()
+	if err != nil {
+		return structs.OrderReturn{}, err
+	}
+	defer tx.Rollback()
+
+	// Lock the order so two requests can't both claim the same items
+	var lockedID int
+	if err := tx.QueryRow(`SELECT id FROM orders WHERE id = ? FOR UPDATE`, order.ID).Scan(&lockedID); err != nil {
+		return structs.OrderReturn{}, err
+	}
+
+	rows, err := tx.Query(`
+		SELECT ri.order_item_id, SUM(ri.quantity)
+		FROM order_return_items ri
+		JOIN order_returns r ON r.id = ri.return_id
+		WHERE r.order_id = ? AND r.status <> ?
+		GROUP BY ri.order_item_id
+	`, order.ID, ReturnRejected)
+	if err != nil {
+		return structs.OrderReturn{}, err
+	}
+	alreadyReturned := make(map[int]int)
+	for rows.Next() {
+		var itemID, quantity int
+		if err := rows.Scan(&itemID, &quantity); err != nil {
+			rows.Close()
+			return structs.OrderReturn{}, err
+		}
+		alreadyReturned[itemID] = quantity
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return structs.OrderReturn{}, err
+	}
+
+	for itemID, quantity := range requested {
+		if quantity+alreadyReturned[itemID] > orderItems[itemID].Quantity {
+			return structs.OrderReturn{}, fmt.Errorf("%w: %s", ErrReturnQuantityLimit, orderItems[itemID].ProductName)
+		}
+	}
+
+	result, err := tx.Exec(`INSERT INTO order_returns (order_id, status, reason) VALUES (?, ?, ?)`, order.ID, ReturnRequested, reason)
+	if err != nil {
+		return structs.OrderReturn{}, err
+	}
+	returnID, err := result.LastInsertId()
+	if err != nil {
+		return structs.OrderReturn{}, err
+	}
+
+	orderReturn := structs.OrderReturn{
+		ID:          int(returnID),
+		OrderNumber: order.OrderNumber,
+		Status:      ReturnRequested,
+		Reason:      reason,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	for _, itemID := range lineIDs {
+		if _, err := tx.Exec(`INSERT INTO order_return_items (return_id, order_item_id, quantity) VALUES (?, ?, ?)`, returnID, itemID, requested[itemID]); err != nil {
+			return structs.OrderReturn{}, err
+		}
+		line := orderItems[itemID]
+		orderReturn.Items = append(orderReturn.Items, structs.OrderReturnItem{
+			OrderItemID:  itemID,
+			ProductID:    line.ProductID,
+			VariantID:    line.VariantID,
+			ProductName:  line.ProductName,
+			VariantTitle: line.VariantTitle,
+			Quantity:     requested[itemID],
+			Price:        line.Price,
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return structs.OrderReturn{}, err
+	}
+	return orderReturn, nil
+}
+
+// GetOrderReturns lists an order's returns with their items, oldest first
+func (db *DBConnection) GetOrderReturns(order structs.Order) ([]structs.OrderReturn, error) {
+	rows, err := db.QueryRows(`
+		SELECT id, status, IFNULL(reason, ''), refund_amount, created_at, updated_at
+		FROM order_returns
+		WHERE order_id = ?
+		ORDER BY id
+	`, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	returns := []structs.OrderReturn{}
+	byID := make(map[int]int)
+	for rows.Next() {
+		r := structs.OrderReturn{OrderNumber: order.OrderNumber, Items: []structs.OrderReturnItem{}}
+		if err := rows.Scan(&r.ID, &r.Status, &r.Reason, &r.RefundAmount, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		byID[r.ID] = len(returns)
+		returns = append(returns, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(returns) == 0 {
+		return returns, nil
+	}
+
+	itemRows, err := db.QueryRows(`
+		SELECT ri.return_id, ri.order_item_id, oi.product_id, IFNULL(oi.variant_id, 0), oi.product_name,
+			IFNULL(oi.variant_title, ''), ri.quantity, oi.price
+		FROM order_return_items ri
+		JOIN order_returns r ON r.id = ri.return_id
+		JOIN order_items oi ON oi.id = ri.order_item_id
+		WHERE r.order_id = ?
+		ORDER BY ri.return_id, ri.order_item_id
+	`, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer itemRows.Close()
+
+	for itemRows.Next() {
+		var returnID int
+		var item structs.OrderReturnItem
+		if err := itemRows.Scan(&returnID, &item.OrderItemID, &item.ProductID, &item.VariantID, &item.ProductName,
+			&item.VariantTitle, &item.Quantity, &item.Price); err != nil {
+			return nil, err
+		}
+		if i, ok := byID[returnID]; ok {
+			returns[i].Items = append(returns[i].Items, item)
+		}
+	}
+
+	return returns, itemRows.Err()
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/murdinc/stencil2/metrics"
+)
+
+// slowQueryThreshold holds the time.Duration above which queries are logged; zero turns logging off
+var slowQueryThreshold atomic.Int64
+
+// SetSlowQueryThreshold turns on logging of queries that take longer than threshold. Only queries
+// made through DBConnection's helpers and TimedDB are timed
+func SetSlowQueryThreshold(threshold time.Duration) {
+	slowQueryThreshold.Store(int64(threshold))
+}
+
+// logIfSlow logs a query that ran for longer than the threshold with the site it ran against and
+// the function that made it. Every query's time also goes to the metrics, when they're enabled
+func logIfSlow(site, query string, started time.Time) {
+	elapsed := time.Since(started)
+	metrics.ObserveQuery(site, elapsed)
+
+	threshold := time.Duration(slowQueryThreshold.Load())
+	if threshold <= 0 || elapsed < threshold {
+		return
+	}
+
+	// Skip logIfSlow and the wrapper method to name the function that ran the query
+	caller := "unknown"
+	if pc, _, _, ok := runtime.Caller(2); ok {
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			caller = fn.Name()
+			if i := strings.LastIndex(caller, "/"); i >= 0 {
+				caller = caller[i+1:]
+			}
+		}
+	}
+
+	// Collapse the query onto one line and keep it short
+	compact := strings.Join(strings.Fields(query), " ")
+	if len(compact) > 200 {
+		compact = compact[:200] + "..."
+	}
+
+	log.Printf("Slow query (%v) on %s in %s: %s", elapsed.Round(time.Millisecond), site, caller, compact)
+}
+
+// TimedDB is a *sql.DB whose Query, QueryRow and Exec log slow queries for a site
+type TimedDB struct {
+	*sql.DB
+	Site string
+}
+
+// NewTimedDB wraps db so its queries are timed against the slow query threshold
+func NewTimedDB(db *sql.DB, site string) *TimedDB {
+	return &TimedDB{DB: db, Site: site}
+}
+
+// Query runs a query that returns rows, logging it if it's slow
+func (db *TimedDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	started := time.Now()
+	rows, err := db.DB.Query(query, args...)
+	logIfSlow(db.Site, query, started)
+	return rows, err
+}
+
+// QueryRow runs a query that returns at most one row, logging it if it's slow
+func (db *TimedDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	started := time.Now()
+	row := db.DB.QueryRow(query, args...)
+	logIfSlow(db.Site, query, started)
+	return row
+}
+
+// Exec runs a statement that doesn't return rows, logging it if it's slow
+func (db *TimedDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	started := time.Now()
+	result, err := db.DB.Exec(query, args...)
+	logIfSlow(db.Site, query, started)
+	return result, err
+}
+
+// QueryContext runs a query that returns rows, logging it if it's slow. The query is cancelled
+// when ctx is
+func (db *TimedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	started := time.Now()
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	logIfSlow(db.Site, query, started)
+	return rows, err
+}
+
+// QueryRowContext runs a query that returns at most one row, logging it if it's slow. The query
+// is cancelled when ctx is
+func (db *TimedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	started := time.Now()
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	logIfSlow(db.Site, query, started)
+	return row
+}
+
+// ExecContext runs a statement that doesn't return rows, logging it if it's slow. The statement
+// is cancelled when ctx is
+func (db *TimedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	started := time.Now()
+	result, err := db.DB.ExecContext(ctx, query, args...)
+	logIfSlow(db.Site, query, started)
+	return result, err
+}
@@ -3,11 +3,14 @@ package email
 import (
 	"crypto/tls"
 	"fmt"
+	"html"
 	"net"
 	"net/smtp"
 	"strings"
+	"time"
 
 	"github.com/murdinc/stencil2/configs"
+	"github.com/murdinc/stencil2/utils"
 )
 
 type EmailService struct {
@@ -190,9 +193,10 @@ func (e *EmailService) sendViaPlain(addr, username, password, from string, to []
 }
 
 // SendOrderConfirmation sends an order confirmation email
-func (e *EmailService) SendOrderConfirmation(siteConfig *configs.WebsiteConfig, orderNumber, customerEmail, customerName string, items []OrderItem, subtotal, tax, shipping, total float64) error {
-	htmlBody := e.buildOrderConfirmationHTML(siteConfig.SiteName, orderNumber, customerName, items, subtotal, tax, shipping, total)
-	textBody := e.buildOrderConfirmationText(siteConfig.SiteName, orderNumber, customerName, items, subtotal, tax, shipping, total)
+// shipsOn is the expected ship date of an order with preorder items, nil for one that ships right away
+func (e *EmailService) SendOrderConfirmation(siteConfig *configs.WebsiteConfig, orderNumber, customerEmail, customerName string, items []OrderItem, subtotal, tax, shipping, total float64, shipsOn *time.Time) error {
+	htmlBody := e.buildOrderConfirmationHTML(siteConfig.SiteName, siteConfig.Ecommerce.Currency, orderNumber, customerName, items, subtotal, tax, shipping, total, shipsOn)
+	textBody := e.buildOrderConfirmationText(siteConfig.SiteName, siteConfig.Ecommerce.Currency, orderNumber, customerName, items, subtotal, tax, shipping, total, shipsOn)
 
 	fromAddress := siteConfig.Email.FromAddress
 	fromName := siteConfig.Email.FromName
@@ -224,7 +228,22 @@ type OrderItem struct {
 	Total         float64
 }
 
-func (e *EmailService) buildOrderConfirmationHTML(siteName, orderNumber, customerName string, items []OrderItem, subtotal, tax, shipping, total float64) string {
+// preorderShipNote gives the expected ship date of an order with preorder items, empty when shipsOn is nil
+func preorderShipNote(shipsOn *time.Time) string {
+	if shipsOn == nil {
+		return ""
+	}
+	return fmt.Sprintf("This order includes preorder items and is expected to ship on %s.", shipsOn.Format("January 2, 2006"))
+}
+
+func (e *EmailService) buildOrderConfirmationHTML(siteName, currency, orderNumber, customerName string, items []OrderItem, subtotal, tax, shipping, total float64, shipsOn *time.Time) string {
+	money := func(amount float64) string { return utils.FormatMoney(amount, currency) }
+
+	preorderNoteHTML := ""
+	if note := preorderShipNote(shipsOn); note != "" {
+		preorderNoteHTML = fmt.Sprintf("        <p><strong>%s</strong></p>\n", note)
+	}
+
 	html := fmt.Sprintf(`
 <!DOCTYPE html>
 <html>
@@ -253,7 +272,7 @@ func (e *EmailService) buildOrderConfirmationHTML(siteName, orderNumber, custome
 
         <p>Hi %s,</p>
         <p>Thank you for your order! We've received your payment and will process your order shortly.</p>
-
+%s
         <table>
             <thead>
                 <tr>
@@ -264,7 +283,7 @@ func (e *EmailService) buildOrderConfirmationHTML(siteName, orderNumber, custome
                 </tr>
             </thead>
             <tbody>
-`, siteName, orderNumber, customerName)
+`, siteName, orderNumber, customerName, preorderNoteHTML)
 
 	for _, item := range items {
 		productName := item.ProductName
@@ -275,10 +294,10 @@ func (e *EmailService) buildOrderConfirmationHTML(siteName, orderNumber, custome
                 <tr>
                     <td>%s</td>
                     <td>%d</td>
-                    <td style="text-align: right;">$%.2f</td>
-                    <td style="text-align: right;">$%.2f</td>
+                    <td style="text-align: right;">%s</td>
+                    <td style="text-align: right;">%s</td>
                 </tr>
-`, productName, item.Quantity, item.Price, item.Total)
+`, productName, item.Quantity, money(item.Price), money(item.Total))
 	}
 
 	html += fmt.Sprintf(`
@@ -286,10 +305,10 @@ func (e *EmailService) buildOrderConfirmationHTML(siteName, orderNumber, custome
         </table>
 
         <div class="totals">
-            <div><span>Subtotal:</span><span>$%.2f</span></div>
-            <div><span>Tax:</span><span>$%.2f</span></div>
-            <div><span>Shipping:</span><span>$%.2f</span></div>
-            <div class="total-row"><span>Total:</span><span>$%.2f</span></div>
+            <div><span>Subtotal:</span><span>%s</span></div>
+            <div><span>Tax:</span><span>%s</span></div>
+            <div><span>Shipping:</span><span>%s</span></div>
+            <div class="total-row"><span>Total:</span><span>%s</span></div>
         </div>
 
         <div class="footer">
@@ -299,12 +318,14 @@ func (e *EmailService) buildOrderConfirmationHTML(siteName, orderNumber, custome
     </div>
 </body>
 </html>
-`, subtotal, tax, shipping, total, orderNumber)
+`, money(subtotal), money(tax), money(shipping), money(total), orderNumber)
 
 	return html
 }
 
-func (e *EmailService) buildOrderConfirmationText(siteName, orderNumber, customerName string, items []OrderItem, subtotal, tax, shipping, total float64) string {
+func (e *EmailService) buildOrderConfirmationText(siteName, currency, orderNumber, customerName string, items []OrderItem, subtotal, tax, shipping, total float64, shipsOn *time.Time) string {
+	money := func(amount float64) string { return utils.FormatMoney(amount, currency) }
+
 	text := fmt.Sprintf(`%s
 
 Order #%s
@@ -312,42 +333,45 @@ Order #%s
 Hi %s,
 
 Thank you for your order! We've received your payment and will process your order shortly.
-
-ORDER ITEMS:
 `, siteName, orderNumber, customerName)
 
+	if note := preorderShipNote(shipsOn); note != "" {
+		text += note + "\n"
+	}
+	text += "\nORDER ITEMS:\n"
+
 	for _, item := range items {
 		productName := item.ProductName
 		if item.VariantTitle != "" {
 			productName += fmt.Sprintf(" - %s", item.VariantTitle)
 		}
-		text += fmt.Sprintf("%s x%d - $%.2f\n", productName, item.Quantity, item.Total)
+		text += fmt.Sprintf("%s x%d - %s\n", productName, item.Quantity, money(item.Total))
 	}
 
 	text += fmt.Sprintf(`
-Subtotal: $%.2f
-Tax: $%.2f
-Shipping: $%.2f
-Total: $%.2f
+Subtotal: %s
+Tax: %s
+Shipping: %s
+Total: %s
 
 Order Number: %s
 
 If you have any questions, please reply to this email.
-`, subtotal, tax, shipping, total, orderNumber)
+`, money(subtotal), money(tax), money(shipping), money(total), orderNumber)
 
 	return text
 }
 
 // SendAdminOrderNotification sends a new order notification to the admin
-func (e *EmailService) SendAdminOrderNotification(siteConfig *configs.WebsiteConfig, orderNumber, customerEmail, customerName string, items []OrderItem, subtotal, tax, shipping, total float64) error {
+func (e *EmailService) SendAdminOrderNotification(siteConfig *configs.WebsiteConfig, orderNumber, customerEmail, customerName string, items []OrderItem, subtotal, tax, shipping, total float64, shipsOn *time.Time) error {
 	adminEmail := siteConfig.Email.FromAddress
 
 	if adminEmail == "" {
 		return fmt.Errorf("no admin email configured")
 	}
 
-	htmlBody := e.buildAdminOrderNotificationHTML(siteConfig.SiteName, orderNumber, customerName, customerEmail, items, subtotal, tax, shipping, total)
-	textBody := e.buildAdminOrderNotificationText(siteConfig.SiteName, orderNumber, customerName, customerEmail, items, subtotal, tax, shipping, total)
+	htmlBody := e.buildAdminOrderNotificationHTML(siteConfig.SiteName, siteConfig.Ecommerce.Currency, orderNumber, customerName, customerEmail, items, subtotal, tax, shipping, total, shipsOn)
+	textBody := e.buildAdminOrderNotificationText(siteConfig.SiteName, siteConfig.Ecommerce.Currency, orderNumber, customerName, customerEmail, items, subtotal, tax, shipping, total, shipsOn)
 
 	fromAddress := siteConfig.Email.FromAddress
 	fromName := "Store Notifications"
@@ -369,7 +393,14 @@ func (e *EmailService) SendAdminOrderNotification(siteConfig *configs.WebsiteCon
 	)
 }
 
-func (e *EmailService) buildAdminOrderNotificationHTML(siteName, orderNumber, customerName, customerEmail string, items []OrderItem, subtotal, tax, shipping, total float64) string {
+func (e *EmailService) buildAdminOrderNotificationHTML(siteName, currency, orderNumber, customerName, customerEmail string, items []OrderItem, subtotal, tax, shipping, total float64, shipsOn *time.Time) string {
+	money := func(amount float64) string { return utils.FormatMoney(amount, currency) }
+
+	preorderNoteHTML := ""
+	if note := preorderShipNote(shipsOn); note != "" {
+		preorderNoteHTML = fmt.Sprintf("            <p><strong>%s</strong> It can't be shipped before then.</p>\n", note)
+	}
+
 	html := fmt.Sprintf(`
 <!DOCTYPE html>
 <html>
@@ -405,7 +436,7 @@ func (e *EmailService) buildAdminOrderNotificationHTML(siteName, orderNumber, cu
             <h3 style="margin-top: 0;">Customer Information</h3>
             <p><strong>Name:</strong> %s</p>
             <p><strong>Email:</strong> %s</p>
-        </div>
+%s        </div>
 
         <table>
             <thead>
@@ -417,7 +448,7 @@ func (e *EmailService) buildAdminOrderNotificationHTML(siteName, orderNumber, cu
                 </tr>
             </thead>
             <tbody>
-`, siteName, orderNumber, customerName, customerEmail)
+`, siteName, orderNumber, customerName, customerEmail, preorderNoteHTML)
 
 	for _, item := range items {
 		productName := item.ProductName
@@ -428,10 +459,10 @@ func (e *EmailService) buildAdminOrderNotificationHTML(siteName, orderNumber, cu
                 <tr>
                     <td>%s</td>
                     <td>%d</td>
-                    <td style="text-align: right;">$%.2f</td>
-                    <td style="text-align: right;">$%.2f</td>
+                    <td style="text-align: right;">%s</td>
+                    <td style="text-align: right;">%s</td>
                 </tr>
-`, productName, item.Quantity, item.Price, item.Total)
+`, productName, item.Quantity, money(item.Price), money(item.Total))
 	}
 
 	html += fmt.Sprintf(`
@@ -439,10 +470,10 @@ func (e *EmailService) buildAdminOrderNotificationHTML(siteName, orderNumber, cu
         </table>
 
         <div class="totals">
-            <div><span>Subtotal:</span><span>$%.2f</span></div>
-            <div><span>Tax:</span><span>$%.2f</span></div>
-            <div><span>Shipping:</span><span>$%.2f</span></div>
-            <div class="total-row"><span>Total:</span><span>$%.2f</span></div>
+            <div><span>Subtotal:</span><span>%s</span></div>
+            <div><span>Tax:</span><span>%s</span></div>
+            <div><span>Shipping:</span><span>%s</span></div>
+            <div class="total-row"><span>Total:</span><span>%s</span></div>
         </div>
 
         <div style="margin-top: 30px; padding: 20px; background: #e6ffed; border-radius: 8px; border: 1px solid #48bb78;">
@@ -452,12 +483,14 @@ func (e *EmailService) buildAdminOrderNotificationHTML(siteName, orderNumber, cu
     </div>
 </body>
 </html>
-`, subtotal, tax, shipping, total)
+`, money(subtotal), money(tax), money(shipping), money(total))
 
 	return html
 }
 
-func (e *EmailService) buildAdminOrderNotificationText(siteName, orderNumber, customerName, customerEmail string, items []OrderItem, subtotal, tax, shipping, total float64) string {
+func (e *EmailService) buildAdminOrderNotificationText(siteName, currency, orderNumber, customerName, customerEmail string, items []OrderItem, subtotal, tax, shipping, total float64, shipsOn *time.Time) string {
+	money := func(amount float64) string { return utils.FormatMoney(amount, currency) }
+
 	text := fmt.Sprintf(`NEW ORDER RECEIVED
 %s
 
@@ -466,31 +499,221 @@ Order #%s
 CUSTOMER:
 Name: %s
 Email: %s
-
-ORDER ITEMS:
 `, siteName, orderNumber, customerName, customerEmail)
 
+	if note := preorderShipNote(shipsOn); note != "" {
+		text += "\n" + note + " It can't be shipped before then.\n"
+	}
+	text += "\nORDER ITEMS:\n"
+
 	for _, item := range items {
 		productName := item.ProductName
 		if item.VariantTitle != "" {
 			productName += fmt.Sprintf(" - %s", item.VariantTitle)
 		}
-		text += fmt.Sprintf("%s x%d - $%.2f\n", productName, item.Quantity, item.Total)
+		text += fmt.Sprintf("%s x%d - %s\n", productName, item.Quantity, money(item.Total))
 	}
 
 	text += fmt.Sprintf(`
-Subtotal: $%.2f
-Tax: $%.2f
-Shipping: $%.2f
-Total: $%.2f
+Subtotal: %s
+Tax: %s
+Shipping: %s
+Total: %s
 
 Payment confirmed via Stripe.
 Log in to your admin panel to process this order.
-`, subtotal, tax, shipping, total)
+`, money(subtotal), money(tax), money(shipping), money(total))
 
 	return text
 }
 
+// SendAdminQuestionNotification tells the admin a customer asked a question about a product, which
+// waits for an answer in the admin before it's shown. Replies go to the customer
+func (e *EmailService) SendAdminQuestionNotification(siteConfig *configs.WebsiteConfig, productName, customerName, customerEmail, question string) error {
+	adminEmail := siteConfig.Email.FromAddress
+
+	if adminEmail == "" {
+		return fmt.Errorf("no admin email configured")
+	}
+
+	htmlBody := fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', sans-serif; line-height: 1.6; color: #333; }
+        .container { max-width: 600px; margin: 0 auto; padding: 20px; }
+        .header { background: #f8f9fa; padding: 20px; border-radius: 8px; margin-bottom: 20px; }
+        .question { background: #fff4e6; border-left: 4px solid #f59e0b; padding: 16px; margin: 20px 0; white-space: pre-wrap; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>%s</h1>
+            <p style="margin: 0;">New question about <strong>%s</strong></p>
+        </div>
+
+        <p><strong>From:</strong> %s (%s)</p>
+        <div class="question">%s</div>
+
+        <p style="font-size: 14px; color: #666;">Log in to your admin panel to answer it. It's shown on the product once answered.</p>
+    </div>
+</body>
+</html>
+`, html.EscapeString(siteConfig.SiteName), html.EscapeString(productName), html.EscapeString(customerName), html.EscapeString(customerEmail), html.EscapeString(question))
+
+	textBody := fmt.Sprintf(`NEW PRODUCT QUESTION
+%s
+
+Product: %s
+From: %s (%s)
+
+%s
+
+Log in to your admin panel to answer it. It's shown on the product once answered.
+`, siteConfig.SiteName, productName, customerName, customerEmail, question)
+
+	return e.SendEmailWithSMTP(
+		EmailMessage{
+			To:          []string{adminEmail},
+			FromAddress: siteConfig.Email.FromAddress,
+			FromName:    "Store Notifications",
+			ReplyTo:     customerEmail,
+			Subject:     fmt.Sprintf("New question about %s", productName),
+			HTMLBody:    htmlBody,
+			TextBody:    textBody,
+		},
+		siteConfig.Email.SMTP.Server,
+		siteConfig.Email.SMTP.Port,
+		siteConfig.Email.SMTP.Username,
+		siteConfig.Email.SMTP.Password,
+		siteConfig.Email.SMTP.UseTLS,
+	)
+}
+
+// DigestOrder is one order listed in the admin order digest
+type DigestOrder struct {
+	OrderNumber   string
+	CustomerName  string
+	CustomerEmail string
+	Total         float64
+	CreatedAt     time.Time
+	URL           string // link to the order in the admin, empty when the admin's public URL isn't set
+}
+
+// SendAdminOrderDigest sends the admin one email listing the orders placed since the last digest,
+// in place of a notification per order
+func (e *EmailService) SendAdminOrderDigest(siteConfig *configs.WebsiteConfig, orders []DigestOrder) error {
+	adminEmail := siteConfig.Email.FromAddress
+
+	if adminEmail == "" {
+		return fmt.Errorf("no admin email configured")
+	}
+
+	money := func(amount float64) string { return utils.FormatMoney(amount, siteConfig.Ecommerce.Currency) }
+
+	var total float64
+	for _, order := range orders {
+		total += order.Total
+	}
+
+	htmlBody := fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', sans-serif; line-height: 1.6; color: #333; }
+        .container { max-width: 600px; margin: 0 auto; padding: 20px; }
+        .header { background: #f8f9fa; padding: 20px; border-radius: 8px; margin-bottom: 20px; }
+        table { width: 100%%; border-collapse: collapse; margin: 20px 0; }
+        th { text-align: left; padding: 10px; border-bottom: 1px solid #ddd; font-weight: 600; background: #f8f9fa; }
+        td { padding: 10px; border-bottom: 1px solid #eee; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>%s</h1>
+            <p style="margin: 0;"><strong>%d new %s</strong> totaling <strong>%s</strong></p>
+        </div>
+
+        <table>
+            <thead>
+                <tr>
+                    <th>Order</th>
+                    <th>Customer</th>
+                    <th>Placed</th>
+                    <th style="text-align: right;">Total</th>
+                </tr>
+            </thead>
+            <tbody>
+`, html.EscapeString(siteConfig.SiteName), len(orders), pluralOrders(len(orders)), money(total))
+
+	textBody := fmt.Sprintf(`NEW ORDERS
+%s
+
+%d new %s totaling %s
+
+`, siteConfig.SiteName, len(orders), pluralOrders(len(orders)), money(total))
+
+	for _, order := range orders {
+		orderCell := "#" + html.EscapeString(order.OrderNumber)
+		if order.URL != "" {
+			orderCell = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(order.URL), orderCell)
+		}
+		htmlBody += fmt.Sprintf(`
+                <tr>
+                    <td>%s</td>
+                    <td>%s<br><span style="font-size: 13px; color: #666;">%s</span></td>
+                    <td>%s</td>
+                    <td style="text-align: right;">%s</td>
+                </tr>
+`, orderCell, html.EscapeString(order.CustomerName), html.EscapeString(order.CustomerEmail), order.CreatedAt.Format("Jan 2, 3:04 PM"), money(order.Total))
+
+		textBody += fmt.Sprintf("#%s - %s <%s> - %s\n", order.OrderNumber, order.CustomerName, order.CustomerEmail, money(order.Total))
+		if order.URL != "" {
+			textBody += order.URL + "\n"
+		}
+	}
+
+	htmlBody += `
+            </tbody>
+        </table>
+
+        <p style="font-size: 14px; color: #666;">Log in to your admin panel to process these orders.</p>
+    </div>
+</body>
+</html>
+`
+	textBody += "\nLog in to your admin panel to process these orders.\n"
+
+	return e.SendEmailWithSMTP(
+		EmailMessage{
+			To:          []string{adminEmail},
+			FromAddress: siteConfig.Email.FromAddress,
+			FromName:    "Store Notifications",
+			Subject:     fmt.Sprintf("%d new %s - %s", len(orders), pluralOrders(len(orders)), money(total)),
+			HTMLBody:    htmlBody,
+			TextBody:    textBody,
+		},
+		siteConfig.Email.SMTP.Server,
+		siteConfig.Email.SMTP.Port,
+		siteConfig.Email.SMTP.Username,
+		siteConfig.Email.SMTP.Password,
+		siteConfig.Email.SMTP.UseTLS,
+	)
+}
+
+func pluralOrders(n int) string {
+	if n == 1 {
+		return "order"
+	}
+	return "orders"
+}
+
 // SendShippingConfirmation sends a shipping confirmation email to the customer
 func (e *EmailService) SendShippingConfirmation(siteConfig *configs.WebsiteConfig, orderNumber, customerEmail, customerName, trackingNumber, carrier string) error {
 	htmlBody := e.buildShippingConfirmationHTML(siteConfig.SiteName, orderNumber, customerName, trackingNumber, carrier)
@@ -674,3 +897,114 @@ Thank you for shopping with us!
 Order Number: %s
 `, siteName, orderNumber, customerName, orderNumber)
 }
+
+// returnStatusMessages is the headline and explanation a customer gets for each return status
+var returnStatusMessages = map[string][2]string{
+	"requested": {"Return Requested", "We've received your return request and will review it shortly. We'll email you once it's approved, with instructions for sending the items back."},
+	"approved":  {"Return Approved", "Your return has been approved. Please send the items back to us, including your order number in the package."},
+	"rejected":  {"Return Declined", "Unfortunately we can't accept this return. Reply to this email if you have any questions."},
+	"received":  {"Return Received", "We've received the items you sent back. Your refund will be processed shortly."},
+	"refunded":  {"Return Refunded", "Your return is complete and your refund has been issued."},
+}
+
+// SendReturnUpdate tells a customer their return's status changed. note is an optional message
+// from the store, and refundAmount is only shown once the return is refunded
+func (e *EmailService) SendReturnUpdate(siteConfig *configs.WebsiteConfig, orderNumber, customerEmail, customerName string, returnID int, status, note string, refundAmount float64) error {
+	message, ok := returnStatusMessages[status]
+	if !ok {
+		return fmt.Errorf("unknown return status %q", status)
+	}
+
+	refundLine := ""
+	if status == "refunded" && refundAmount > 0 {
+		refundLine = fmt.Sprintf("Refund: %s", utils.FormatMoney(refundAmount, siteConfig.Ecommerce.Currency))
+	}
+
+	htmlBody := e.buildReturnUpdateHTML(siteConfig.SiteName, orderNumber, customerName, returnID, message[0], message[1], note, refundLine)
+	textBody := e.buildReturnUpdateText(siteConfig.SiteName, orderNumber, customerName, returnID, message[0], message[1], note, refundLine)
+
+	return e.SendEmailWithSMTP(
+		EmailMessage{
+			To:          []string{customerEmail},
+			FromAddress: siteConfig.Email.FromAddress,
+			FromName:    siteConfig.Email.FromName,
+			ReplyTo:     siteConfig.Email.ReplyTo,
+			Subject:     fmt.Sprintf("%s - Order #%s", message[0], orderNumber),
+			HTMLBody:    htmlBody,
+			TextBody:    textBody,
+		},
+		siteConfig.Email.SMTP.Server,
+		siteConfig.Email.SMTP.Port,
+		siteConfig.Email.SMTP.Username,
+		siteConfig.Email.SMTP.Password,
+		siteConfig.Email.SMTP.UseTLS,
+	)
+}
+
+func (e *EmailService) buildReturnUpdateHTML(siteName, orderNumber, customerName string, returnID int, title, explanation, note, refundLine string) string {
+	extra := ""
+	if refundLine != "" {
+		extra += fmt.Sprintf(`<p><strong>%s</strong></p>`, html.EscapeString(refundLine))
+	}
+	if note != "" {
+		extra += fmt.Sprintf(`<div class="note">%s</div>`, html.EscapeString(note))
+	}
+
+	return fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', sans-serif; line-height: 1.6; color: #333; }
+        .container { max-width: 600px; margin: 0 auto; padding: 20px; }
+        .header { border-bottom: 2px solid #000; padding-bottom: 20px; margin-bottom: 30px; }
+        .order-number { font-size: 20px; font-weight: 600; margin: 10px 0; }
+        .note { background: #f8f9fa; border-left: 4px solid #000; padding: 16px; margin: 20px 0; white-space: pre-wrap; }
+        .footer { margin-top: 40px; padding-top: 20px; border-top: 1px solid #ddd; color: #666; font-size: 14px; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>%s</h1>
+            <div class="order-number">Order #%s</div>
+        </div>
+
+        <p>Hi %s,</p>
+
+        <h2>%s</h2>
+        <p>%s</p>
+        %s
+
+        <div class="footer">
+            <p>Return #%d for order %s</p>
+        </div>
+    </div>
+</body>
+</html>
+`, siteName, orderNumber, html.EscapeString(customerName), title, explanation, extra, returnID, orderNumber)
+}
+
+func (e *EmailService) buildReturnUpdateText(siteName, orderNumber, customerName string, returnID int, title, explanation, note, refundLine string) string {
+	var extra strings.Builder
+	if refundLine != "" {
+		extra.WriteString(refundLine + "\n\n")
+	}
+	if note != "" {
+		extra.WriteString(note + "\n\n")
+	}
+
+	return fmt.Sprintf(`%s
+
+Order #%s
+
+Hi %s,
+
+%s
+
+%s
+
+%sReturn #%d for order %s
+`, siteName, orderNumber, customerName, title, explanation, extra.String(), returnID, orderNumber)
+}
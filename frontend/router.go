@@ -2,6 +2,8 @@ package frontend
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,6 +16,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/murdinc/stencil2/api"
 	"github.com/murdinc/stencil2/media"
+	"github.com/murdinc/stencil2/metrics"
 	"github.com/murdinc/stencil2/session"
 )
 
@@ -36,6 +39,22 @@ func (website *Website) NotFoundHandler(w http.ResponseWriter, r *http.Request)
 	website.RenderError(w, pageData)
 }
 
+// renderLookupError renders the error page for a product or collection page whose item couldn't be
+// loaded: not found when there's no such item, a 500 for anything else so database problems aren't
+// passed off as missing pages
+func (website *Website) renderLookupError(w http.ResponseWriter, pageData PageData, item string, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		pageData.ErrorString = item + " Not Found!"
+		pageData.StatusCode = http.StatusNotFound
+	} else {
+		log.Printf("Error loading %s %q: %v", strings.ToLower(item), pageData.Slug, err)
+		pageData.ErrorString = "Something went wrong"
+		pageData.ErrorDescription = err.Error()
+		pageData.StatusCode = http.StatusInternalServerError
+	}
+	website.RenderError(w, pageData)
+}
+
 // HandleUnlockPage renders the unlock page
 func (website *Website) HandleUnlockPage(w http.ResponseWriter, r *http.Request) {
 	// If early access is disabled, redirect to homepage
@@ -89,13 +108,33 @@ func (website *Website) HandleRobotsTxt(w http.ResponseWriter, r *http.Request)
 
 	robotsTxt := website.WebsiteConfig.RobotsTxt
 	if robotsTxt == "" {
-		// Default robots.txt if not configured
-		robotsTxt = fmt.Sprintf("User-agent: *\nAllow: /\n\nSitemap: %s/sitemap.xml", website.EnvironmentConfig.BaseURL)
+		robotsTxt = DefaultRobotsTxt(website.EnvironmentConfig.BaseURL)
 	}
 
 	w.Write([]byte(robotsTxt))
 }
 
+// DefaultRobotsTxt is the robots.txt served when a site doesn't configure one: allow everything
+// and point at the sitemap
+func DefaultRobotsTxt(baseURL string) string {
+	return fmt.Sprintf("User-agent: *\nAllow: /\n\nSitemap: %s/sitemap.xml", baseURL)
+}
+
+// HandleApplePayDomainAssociation serves the Apple Pay domain verification file from
+// <site directory>/.well-known/apple-developer-merchantid-domain-association
+func (website *Website) HandleApplePayDomainAssociation(w http.ResponseWriter, r *http.Request) {
+	filePath := filepath.Join(website.WebsiteConfig.Directory, ".well-known", "apple-developer-merchantid-domain-association")
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(data)
+}
+
 // HandleSitemapIndex serves the root sitemap index at /sitemap.xml
 func (website *Website) HandleSitemapIndex(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
@@ -269,12 +308,8 @@ func (website *Website) GetRoute(name string) func(w http.ResponseWriter, r *htt
 			case "product":
 				product, err := website.DBConn.GetProduct(vars["slug"])
 				if err != nil {
-					pageData.ErrorDescription = err.Error()
-					pageData.StatusCode = 500
-				}
-				if product.Slug == "" {
-					pageData.ErrorString = "Product not found!"
-					pageData.StatusCode = 404
+					website.renderLookupError(w, pageData, "Product", err)
+					return
 				}
 				pageData.Product = product
 
@@ -303,6 +338,15 @@ func (website *Website) GetRoute(name string) func(w http.ResponseWriter, r *htt
 				// Allow empty products - template will show empty state
 				pageData.Products = products
 
+			case "on-sale-products":
+				products, err := website.DBConn.GetOnSaleProducts(vars, URLParams)
+				if err != nil {
+					pageData.ErrorDescription = err.Error()
+					pageData.StatusCode = 500
+				}
+				// Allow empty products - template will show empty state
+				pageData.Products = products
+
 			case "featured-products":
 				products, err := website.DBConn.GetFeaturedProducts(vars, URLParams)
 				if err != nil {
@@ -315,12 +359,8 @@ func (website *Website) GetRoute(name string) func(w http.ResponseWriter, r *htt
 			case "collection":
 				collection, err := website.DBConn.GetCollection(vars["slug"])
 				if err != nil {
-					pageData.ErrorDescription = err.Error()
-					pageData.StatusCode = 500
-				}
-				if collection.Slug == "" {
-					pageData.ErrorString = "Collection not found!"
-					pageData.StatusCode = 404
+					website.renderLookupError(w, pageData, "Collection", err)
+					return
 				}
 				pageData.Collection = collection
 
@@ -372,7 +412,11 @@ func (website *Website) GetRouter() func() chi.Router {
 	router := func() chi.Router {
 		r := chi.NewRouter()
 
+		// Count and time every request to the site
+		r.Use(metrics.Middleware(website.WebsiteConfig.SiteName))
+
 		// Apply early access middleware globally
+		r.Use(website.MaintenanceMiddleware)
 		r.Use(website.EarlyAccessMiddleware)
 
 		r.NotFound(website.NotFoundHandler)
@@ -387,6 +431,9 @@ func (website *Website) GetRouter() func() chi.Router {
 		// sitemap.xml index route
 		r.Get("/sitemap.xml", website.HandleSitemapIndex)
 
+		// Apple Pay domain verification file
+		r.Get("/.well-known/apple-developer-merchantid-domain-association", website.HandleApplePayDomainAssociation)
+
 		// Load Website templates
 		for _, template := range *website.TemplateConfigs {
 			if template.Path != "" {
@@ -431,6 +478,13 @@ func (website *Website) GetRouter() func() chi.Router {
 		fmt.Printf("			> Setting up sitemaps folder: %s\n", sitemapsDir)
 		FileServer(r, "/sitemaps/", sitemapsDir)
 
+		// private uploads, only served with a signed link from the admin
+		r.Get(media.PrivateUploadPath+"*", func(w http.ResponseWriter, r *http.Request) {
+			siteDir, _ := filepath.Rel("websites", website.WebsiteConfig.Directory)
+			site := media.UploadSite{Directory: siteDir, HTTPAddress: website.WebsiteConfig.HTTP.Address}
+			media.ServePrivateUpload(w, r, []byte(website.EnvironmentConfig.Uploads.SigningKey), site)
+		})
+
 		// start media resizer
 		r.Get("/media-proxy/width/{width}", func(w http.ResponseWriter, r *http.Request) {
 			imageURL := r.URL.Query().Get("url")
@@ -477,6 +531,52 @@ func paginate302Redirect(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, redirectURL, http.StatusTemporaryRedirect)
 }
 
+// defaultMaintenanceRetryAfter is the Retry-After, in seconds, sent when maintenance.retryAfter isn't set
+const defaultMaintenanceRetryAfter = 3600
+
+// MaintenanceMiddleware answers visitors with a 503 maintenance page while maintenance mode is on.
+// The API stays up so payment webhooks still land, and the admin runs on its own server
+func (website *Website) MaintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		maintenance := website.WebsiteConfig.Maintenance
+		if !maintenance.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Skip for API routes (webhooks), assets the page needs, and domain verification
+		if strings.HasPrefix(r.URL.Path, "/api/") ||
+			strings.HasPrefix(r.URL.Path, "/public/") ||
+			strings.HasPrefix(r.URL.Path, "/.well-known/") ||
+			strings.HasPrefix(r.URL.Path, "/media-proxy/") ||
+			r.URL.Path == "/robots.txt" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		retryAfter := maintenance.RetryAfter
+		if retryAfter <= 0 {
+			retryAfter = defaultMaintenanceRetryAfter
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		w.Header().Set("Cache-Control", "no-store")
+
+		message := maintenance.Message
+		if message == "" {
+			message = "We're making some improvements and will be back shortly."
+		}
+
+		pageData := PageData{
+			ErrorString:      "Down for Maintenance",
+			ErrorDescription: message,
+			StatusCode:       http.StatusServiceUnavailable,
+			ProdMode:         website.EnvironmentConfig.ProdMode,
+			HideErrors:       website.EnvironmentConfig.HideErrors,
+		}
+		website.RenderError(w, pageData)
+	})
+}
+
 // EarlyAccessMiddleware checks if early access is enabled and redirects to unlock if needed
 func (website *Website) EarlyAccessMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -498,6 +598,7 @@ func (website *Website) EarlyAccessMiddleware(next http.Handler) http.Handler {
 		if strings.HasPrefix(r.URL.Path, "/public/") ||
 			strings.HasPrefix(r.URL.Path, "/api/") ||
 			strings.HasPrefix(r.URL.Path, "/sitemaps/") ||
+			strings.HasPrefix(r.URL.Path, "/.well-known/") ||
 			strings.HasPrefix(r.URL.Path, "/media-proxy/") {
 			next.ServeHTTP(w, r)
 			return
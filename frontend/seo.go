@@ -7,6 +7,7 @@ import (
 
 	"github.com/murdinc/stencil2/configs"
 	"github.com/murdinc/stencil2/structs"
+	"github.com/murdinc/stencil2/utils"
 )
 
 // GenerateArticleSchema generates schema.org Article structured data
@@ -110,7 +111,7 @@ func GenerateProductSchema(product structs.Product, siteConfig configs.WebsiteCo
 
 	if product.Price > 0 {
 		offer["price"] = fmt.Sprintf("%.2f", product.Price)
-		offer["priceCurrency"] = "USD"
+		offer["priceCurrency"] = utils.NormalizeCurrency(siteConfig.Ecommerce.Currency)
 	}
 
 	// Set availability based on inventory
@@ -13,6 +13,7 @@ import (
 	"github.com/Masterminds/sprig"
 	"github.com/murdinc/stencil2/configs"
 	"github.com/murdinc/stencil2/structs"
+	"github.com/murdinc/stencil2/utils"
 )
 
 type SEOData struct {
@@ -77,6 +78,13 @@ func (website *Website) ExecuteTemplate(w http.ResponseWriter, tpl configs.Templ
 	funcMap["hash"] = func() string {
 		return website.Hash
 	}
+	// formatMoney formats an amount in the site currency, or in the currency given
+	funcMap["formatMoney"] = func(amount float64, currency ...string) string {
+		if len(currency) > 0 && currency[0] != "" {
+			return utils.FormatMoney(amount, currency[0])
+		}
+		return utils.FormatMoney(amount, website.WebsiteConfig.Ecommerce.Currency)
+	}
 
 	// Load the template file
 	tplName := fmt.Sprintf("%s.tpl", tpl.Name)
@@ -182,6 +190,7 @@ func (website *Website) RenderError(w http.ResponseWriter, pageData PageData) {
 	if pageData.ProdMode == false && pageData.HideErrors == false {
 		tmpl := template.New("devError").Funcs(funcMap)
 		tmpl, _ = tmpl.Parse(devErrTemplate)
+		w.WriteHeader(pageData.StatusCode)
 		tmpl.Execute(w, pageData)
 		return
 	}
@@ -58,6 +58,11 @@ func NewWebsite(envConfig configs.EnvironmentConfig, websiteConfig configs.Websi
 			return nil, fmt.Errorf("[%s] database ping failed: %v", siteName, err)
 		}
 
+		// Send listings and analytics reads to the read replica, if there is one
+		if host, port, user, password, ok := envConfig.ReadReplica(); ok {
+			dbConn.ConnectReplica(user, password, host, port)
+		}
+
 		// Initialize article/content tables if they don't exist
 		err = dbConn.InitArticleTables()
 		if err != nil {
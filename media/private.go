@@ -0,0 +1,145 @@
+package media
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PrivateUploadPath is the path private uploads are served under. Requests need a signature from
+// SignMediaURL, so files like unreleased product photos can be previewed without being public
+const PrivateUploadPath = "/media/private/"
+
+// PrivateUploadDir is where a site's private uploads are kept, outside its public directory
+func PrivateUploadDir(site UploadSite) string {
+	return filepath.Join("websites", site.Directory, "private", "uploads")
+}
+
+// SavePrivateUpload writes a file to the site's private uploads. Private uploads always stay on
+// local disk until PublishPrivateUpload hands them to the configured backend
+func SavePrivateUpload(site UploadSite, filename string, r io.Reader) (StoredFile, error) {
+	filePath, written, err := writeFile(PrivateUploadDir(site), filename, r)
+	if err != nil {
+		return StoredFile{}, err
+	}
+
+	return StoredFile{
+		URL:  fmt.Sprintf("//%s%s%s", site.HTTPAddress, PrivateUploadPath, filename),
+		Path: filePath,
+		Size: written,
+	}, nil
+}
+
+// PrivateUploadFile returns the file on disk behind a private upload URL, and false for any other URL
+func PrivateUploadFile(site UploadSite, fileURL string) (string, bool) {
+	prefix := fmt.Sprintf("//%s%s", site.HTTPAddress, PrivateUploadPath)
+	if !strings.HasPrefix(fileURL, prefix) {
+		return "", false
+	}
+	return filepath.Join(PrivateUploadDir(site), filepath.Base(strings.TrimPrefix(fileURL, prefix))), true
+}
+
+// DeletePrivateUpload removes a private upload. Other URLs are ignored
+func DeletePrivateUpload(site UploadSite, fileURL string) error {
+	filePath, ok := PrivateUploadFile(site, fileURL)
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// PublishPrivateUpload moves a private upload to uploader, after which it's served directly
+func PublishPrivateUpload(ctx context.Context, uploader Uploader, site UploadSite, fileURL string) (StoredFile, error) {
+	filePath, ok := PrivateUploadFile(site, fileURL)
+	if !ok {
+		return StoredFile{}, fmt.Errorf("%s is not a private upload", fileURL)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return StoredFile{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return StoredFile{}, err
+	}
+
+	filename := filepath.Base(filePath)
+	stored, err := uploader.Save(ctx, site, filename, file, info.Size(), mime.TypeByExtension(filepath.Ext(filename)))
+	if err != nil {
+		return StoredFile{}, err
+	}
+
+	file.Close()
+	os.Remove(filePath)
+
+	return stored, nil
+}
+
+// SignMediaURL returns a private upload URL that works until expires
+func SignMediaURL(key []byte, fileURL string, expires time.Time) string {
+	i := strings.Index(fileURL, PrivateUploadPath)
+	if i < 0 {
+		return fileURL
+	}
+
+	expiresStr := strconv.FormatInt(expires.Unix(), 10)
+	return fmt.Sprintf("%s?expires=%s&signature=%s", fileURL, expiresStr, mediaSignature(key, fileURL[i:], expiresStr))
+}
+
+// VerifyMediaSignature reports whether signature is valid for path and hasn't expired
+func VerifyMediaSignature(key []byte, path, expires, signature string) bool {
+	if len(key) == 0 {
+		return false
+	}
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(mediaSignature(key, path, expires)))
+}
+
+func mediaSignature(key []byte, path, expires string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(path + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ServePrivateUpload serves a file from the site's private uploads when the request carries a
+// valid signature. Anything else gets a 404 so private files can't be discovered
+func ServePrivateUpload(w http.ResponseWriter, r *http.Request, key []byte, site UploadSite) {
+	query := r.URL.Query()
+	if !strings.HasPrefix(r.URL.Path, PrivateUploadPath) ||
+		!VerifyMediaSignature(key, r.URL.Path, query.Get("expires"), query.Get("signature")) {
+		http.NotFound(w, r)
+		return
+	}
+
+	filePath := filepath.Join(PrivateUploadDir(site), filepath.Base(strings.TrimPrefix(r.URL.Path, PrivateUploadPath)))
+	if _, err := os.Stat(filePath); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Signed links are per-viewer and short-lived, so keep them out of shared caches
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	http.ServeFile(w, r, filePath)
+}
@@ -0,0 +1,66 @@
+package media
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestVerifyMediaSignature(t *testing.T) {
+	key := []byte("test-media-key")
+	sign := func(fileURL string, expires time.Time) (path, expiresParam, signature string) {
+		t.Helper()
+		signed, err := url.Parse(SignMediaURL(key, fileURL, expires))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed.Path, signed.Query().Get("expires"), signed.Query().Get("signature")
+	}
+
+	path, expires, signature := sign("//shop.example.com/media/private/draft.jpg", time.Now().Add(time.Hour))
+	if path != "/media/private/draft.jpg" || expires == "" || signature == "" {
+		t.Fatalf("signed URL has path %q, expires %q, signature %q", path, expires, signature)
+	}
+	_, expiredAt, expiredSignature := sign("//shop.example.com/media/private/draft.jpg", time.Now().Add(-time.Minute))
+	_, _, otherSignature := sign("//shop.example.com/media/private/other.jpg", time.Now().Add(time.Hour))
+	tampered := "0" + signature[1:]
+	if signature[0] == '0' {
+		tampered = "1" + signature[1:]
+	}
+
+	tests := []struct {
+		name      string
+		key       []byte
+		path      string
+		expires   string
+		signature string
+		want      bool
+	}{
+		{"valid", key, path, expires, signature, true},
+		{"expired", key, path, expiredAt, expiredSignature, false},
+		{"expiry pushed back", key, path, expiredAt + "0", expiredSignature, false},
+		{"tampered path", key, "/media/private/draft.png", expires, signature, false},
+		{"tampered signature", key, path, expires, tampered, false},
+		{"no signature", key, path, expires, "", false},
+		{"no expiry", key, path, "", signature, false},
+		{"another file's signature", key, path, expires, otherSignature, false},
+		{"signed with another key", []byte("another-key"), path, expires, signature, false},
+		{"empty key", nil, path, expires, mediaSignature(nil, path, expires), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyMediaSignature(tt.key, tt.path, tt.expires, tt.signature); got != tt.want {
+				t.Errorf("VerifyMediaSignature = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignMediaURLPublic(t *testing.T) {
+	// Only private uploads are signed, public ones keep their direct URL
+	fileURL := "//shop.example.com/public/uploads/photo.jpg"
+	if got := SignMediaURL([]byte("test-media-key"), fileURL, time.Now().Add(time.Hour)); got != fileURL {
+		t.Errorf("SignMediaURL(%q) = %q, want it unchanged", fileURL, got)
+	}
+}
@@ -0,0 +1,296 @@
+package media
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// UploadSite identifies the website an upload belongs to
+type UploadSite struct {
+	Directory   string // Site directory under websites/
+	HTTPAddress string // Host the site is served from
+}
+
+// StoredFile describes a file saved by an Uploader
+type StoredFile struct {
+	URL  string // URL the file is served from, stored on image records
+	Path string // Where the backend put it: a local file path or an s3:// location
+	Size int64
+}
+
+// Uploader stores files uploaded through the admin
+type Uploader interface {
+	// Save stores size bytes from r under filename for site
+	Save(ctx context.Context, site UploadSite, filename string, r io.Reader, size int64, contentType string) (StoredFile, error)
+	// Delete removes the file served from fileURL. URLs the backend didn't create are ignored
+	Delete(ctx context.Context, site UploadSite, fileURL string) error
+}
+
+// S3Config holds the settings for storing uploads in S3 or an S3-compatible service
+type S3Config struct {
+	Bucket          string
+	Region          string
+	Endpoint        string // Optional, for S3-compatible services (e.g. "https://<account>.r2.cloudflarestorage.com")
+	AccessKeyID     string
+	SecretAccessKey string
+	PublicURL       string // Optional base URL uploads are served from, e.g. a CDN; defaults to the bucket URL
+	Prefix          string // Optional key prefix, e.g. "uploads"
+}
+
+// NewUploader returns the uploader for backend: "local" (or "") or "s3"
+func NewUploader(backend string, s3 S3Config) (Uploader, error) {
+	switch backend {
+	case "", "local":
+		return LocalUploader{}, nil
+	case "s3":
+		if s3.Bucket == "" || s3.Region == "" || s3.AccessKeyID == "" || s3.SecretAccessKey == "" {
+			return nil, fmt.Errorf("s3 uploads need a bucket, region, access key ID and secret access key")
+		}
+		return &S3Uploader{config: s3, client: &http.Client{Timeout: 2 * time.Minute}}, nil
+	}
+	return nil, fmt.Errorf("unknown upload backend %q", backend)
+}
+
+// ====================
+// Local
+// ====================
+
+// LocalUploader writes uploads to websites/<dir>/public/uploads, served by the site itself
+type LocalUploader struct{}
+
+func (LocalUploader) dir(site UploadSite) string {
+	return filepath.Join("websites", site.Directory, "public", "uploads")
+}
+
+// Save writes the file to the site's uploads directory
+func (u LocalUploader) Save(ctx context.Context, site UploadSite, filename string, r io.Reader, size int64, contentType string) (StoredFile, error) {
+	filePath, written, err := writeFile(u.dir(site), filename, r)
+	if err != nil {
+		return StoredFile{}, err
+	}
+
+	// Protocol-relative so the image works over http and https
+	return StoredFile{
+		URL:  fmt.Sprintf("//%s/public/uploads/%s", site.HTTPAddress, filename),
+		Path: filePath,
+		Size: written,
+	}, nil
+}
+
+// writeFile copies r to filename in dir, creating dir if needed
+func writeFile(dir, filename string, r io.Reader) (string, int64, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", 0, fmt.Errorf("error creating uploads directory: %v", err)
+	}
+
+	filePath := filepath.Join(dir, filename)
+	dst, err := os.Create(filePath)
+	if err != nil {
+		return "", 0, fmt.Errorf("error creating file: %v", err)
+	}
+	defer dst.Close()
+
+	written, err := io.Copy(dst, r)
+	if err != nil {
+		os.Remove(filePath)
+		return "", 0, fmt.Errorf("error saving file: %v", err)
+	}
+	return filePath, written, nil
+}
+
+// Delete removes the file from the site's uploads directory
+func (u LocalUploader) Delete(ctx context.Context, site UploadSite, fileURL string) error {
+	prefix := fmt.Sprintf("//%s/public/uploads/", site.HTTPAddress)
+	if !strings.HasPrefix(fileURL, prefix) {
+		return nil
+	}
+
+	// Base keeps a crafted URL from reaching outside the uploads directory
+	filename := filepath.Base(strings.TrimPrefix(fileURL, prefix))
+	if err := os.Remove(filepath.Join(u.dir(site), filename)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ProxiedUploadURL points a local upload URL (//host/public/uploads/...) at proxyURL, a media proxy
+// or CDN that serves the site's /public path. Any other URL, or any URL when proxyURL is empty, is
+// returned unchanged
+func ProxiedUploadURL(fileURL, proxyURL string) string {
+	if proxyURL == "" || !strings.HasPrefix(fileURL, "//") {
+		return fileURL
+	}
+
+	host, rest, found := strings.Cut(strings.TrimPrefix(fileURL, "//"), "/")
+	if !found || host == "" || !strings.HasPrefix("/"+rest, "/public/uploads/") {
+		return fileURL
+	}
+
+	return strings.TrimRight(proxyURL, "/") + "/" + rest
+}
+
+// ====================
+// S3
+// ====================
+
+// S3Uploader puts uploads in an S3 bucket, signing requests with AWS Signature Version 4
+type S3Uploader struct {
+	config S3Config
+	client *http.Client
+}
+
+// key returns the object key for a site's file
+func (u *S3Uploader) key(site UploadSite, filename string) string {
+	return strings.TrimPrefix(path.Join(u.config.Prefix, site.Directory, filename), "/")
+}
+
+// objectURL returns the API URL for a key: path-style for custom endpoints, virtual-hosted for AWS
+func (u *S3Uploader) objectURL(key string) string {
+	if u.config.Endpoint != "" {
+		return fmt.Sprintf("%s/%s/%s", strings.TrimRight(u.config.Endpoint, "/"), u.config.Bucket, s3EscapePath(key))
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", u.config.Bucket, u.config.Region, s3EscapePath(key))
+}
+
+// publicBase returns the URL prefix uploads are served from
+func (u *S3Uploader) publicBase() string {
+	if u.config.PublicURL != "" {
+		return strings.TrimRight(u.config.PublicURL, "/") + "/"
+	}
+	return strings.TrimSuffix(u.objectURL(""), "/") + "/"
+}
+
+// Save uploads the file to the bucket
+func (u *S3Uploader) Save(ctx context.Context, site UploadSite, filename string, r io.Reader, size int64, contentType string) (StoredFile, error) {
+	key := u.key(site, filename)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.objectURL(key), r)
+	if err != nil {
+		return StoredFile{}, err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if err := u.do(req); err != nil {
+		return StoredFile{}, fmt.Errorf("error uploading to s3: %v", err)
+	}
+
+	return StoredFile{
+		URL:  u.publicBase() + s3EscapePath(key),
+		Path: fmt.Sprintf("s3://%s/%s", u.config.Bucket, key),
+		Size: size,
+	}, nil
+}
+
+// Delete removes the object behind fileURL from the bucket
+func (u *S3Uploader) Delete(ctx context.Context, site UploadSite, fileURL string) error {
+	base := u.publicBase()
+	if !strings.HasPrefix(fileURL, base) {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u.objectURL("")+strings.TrimPrefix(fileURL, base), nil)
+	if err != nil {
+		return err
+	}
+
+	if err := u.do(req); err != nil {
+		return fmt.Errorf("error deleting from s3: %v", err)
+	}
+	return nil
+}
+
+// do signs and sends a request, turning non-2xx responses into errors
+func (u *S3Uploader) do(req *http.Request) error {
+	u.sign(req, time.Now().UTC())
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// sign adds a Signature Version 4 Authorization header. The body is sent unsigned, which S3
+// allows over HTTPS, so uploads can be streamed without hashing them first
+func (u *S3Uploader) sign(req *http.Request, now time.Time) {
+	const payloadHash = "UNSIGNED-PAYLOAD"
+
+	amzDate := now.Format("20060102T150405Z")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", req.URL.Host, payloadHash, amzDate)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope, signature := u.signature(amzDate, canonicalRequest)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		u.config.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// signature signs a canonical request made at amzDate (e.g. 20130524T000000Z), returning the
+// credential scope it was signed for and the signature
+func (u *S3Uploader) signature(amzDate, canonicalRequest string) (string, string) {
+	day := amzDate[:8]
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", day, u.config.Region)
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := fmt.Sprintf("AWS4-HMAC-SHA256\n%s\n%s\n%s", amzDate, scope, hex.EncodeToString(hashed[:]))
+
+	signingKey := hmacSHA256([]byte("AWS4"+u.config.SecretAccessKey), day)
+	signingKey = hmacSHA256(signingKey, u.config.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	return scope, hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapePath percent-encodes each segment of a key the way SigV4 expects, leaving the slashes
+func s3EscapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		var b strings.Builder
+		for _, c := range []byte(segment) {
+			if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+				c == '-' || c == '_' || c == '.' || c == '~' {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		}
+		segments[i] = b.String()
+	}
+	return strings.Join(segments, "/")
+}
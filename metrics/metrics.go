@@ -0,0 +1,222 @@
+// Package metrics keeps counters and histograms in memory and serves them in the Prometheus text
+// format. Nothing is recorded until Enable is called, so the hooks cost next to nothing when
+// metrics are turned off
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var enabled atomic.Bool
+
+// Enable starts recording. It's called at startup when metrics.enabled is set
+func Enable() {
+	enabled.Store(true)
+}
+
+// Enabled reports whether metrics are being recorded
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// collector is a metric family that can write itself out
+type collector interface {
+	write(b *strings.Builder)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	registry = append(registry, c)
+	registryMu.Unlock()
+}
+
+// Counter is a value that only goes up, kept per combination of label values
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labelValues []string
+	value       float64
+}
+
+// NewCounter registers a counter. Label values are passed, in the same order, to Add and Inc
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels, values: make(map[string]*counterSeries)}
+	register(c)
+	return c
+}
+
+// Add adds v to the counter for the label values
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if !Enabled() || v < 0 {
+		return
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	series, ok := c.values[key]
+	if !ok {
+		series = &counterSeries{labelValues: labelValues}
+		c.values[key] = series
+	}
+	series.value += v
+	c.mu.Unlock()
+}
+
+// Inc adds one to the counter for the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) write(b *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(b, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		series := c.values[key]
+		fmt.Fprintf(b, "%s%s %s\n", c.name, formatLabels(c.labels, series.labelValues, "", ""), formatValue(series.value))
+	}
+}
+
+// Histogram counts observations into buckets, per combination of label values
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64 // per bucket, not cumulative
+	sum         float64
+	count       uint64
+}
+
+// DurationBuckets suit request and query timings in seconds
+var DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// NewHistogram registers a histogram with the given upper bounds, in increasing order
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+	register(h)
+	return h
+}
+
+// Observe records v for the label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	if !Enabled() {
+		return
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		series.counts[i]++
+	}
+	series.sum += v
+	series.count++
+	h.mu.Unlock()
+}
+
+func (h *Histogram) write(b *strings.Builder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(b, h.name, h.help, "histogram")
+	for _, key := range sortedKeys(h.series) {
+		series := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += series.counts[i]
+			fmt.Fprintf(b, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, series.labelValues, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, series.labelValues, "le", "+Inf"), series.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", h.name, formatLabels(h.labels, series.labelValues, "", ""), formatValue(series.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", h.name, formatLabels(h.labels, series.labelValues, "", ""), series.count)
+	}
+}
+
+// Handler serves every registered metric in the Prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registryMu.Lock()
+		collectors := append([]collector(nil), registry...)
+		registryMu.Unlock()
+
+		var b strings.Builder
+		for _, c := range collectors {
+			c.write(&b)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write([]byte(b.String()))
+	})
+}
+
+func writeHeader(b *strings.Builder, name, help, kind string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// formatLabels renders {name="value",...}, with an extra label on the end when extraName is set
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, name+"="+strconv.Quote(value))
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+"="+strconv.Quote(extraValue))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+var (
+	httpRequests = NewCounter("stencil_http_requests_total",
+		"HTTP requests served, by site, method, route pattern and status code", "site", "method", "route", "code")
+	httpDuration = NewHistogram("stencil_http_request_duration_seconds",
+		"Time taken to serve HTTP requests, by site, method and route pattern", DurationBuckets, "site", "method", "route")
+	ordersPaid = NewCounter("stencil_orders_paid_total",
+		"Orders marked paid, by site", "site")
+	revenuePaid = NewCounter("stencil_revenue_paid_total",
+		"Totals of orders marked paid, by site and currency", "site", "currency")
+	dbQueryDuration = NewHistogram("stencil_db_query_duration_seconds",
+		"Time taken by database queries, by database", DurationBuckets, "database")
+)
+
+// routeKey holds the route a request matched, for routers mounted with their own chi version
+type routeKey struct{}
+
+// Middleware counts and times a site's requests. The route label is the chi route pattern, so
+// /product/{slug} is one series however many products there are
+func Middleware(site string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			started := time.Now()
+			route := new(string)
+			r = r.WithContext(context.WithValue(r.Context(), routeKey{}, route))
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			if *route == "" {
+				if rctx := chi.RouteContext(r.Context()); rctx != nil {
+					*route = rctx.RoutePattern()
+				}
+			}
+			if *route == "" {
+				*route = "unmatched"
+			}
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			httpRequests.Inc(site, r.Method, *route, strconv.Itoa(status))
+			httpDuration.Observe(time.Since(started).Seconds(), site, r.Method, *route)
+		})
+	}
+}
+
+// SetRoute names the route a request matched, for sub-routers Middleware can't see into (the API
+// runs on an older chi)
+func SetRoute(r *http.Request, route string) {
+	if holder, ok := r.Context().Value(routeKey{}).(*string); ok && route != "" {
+		*holder = route
+	}
+}
+
+// RecordPaidOrder counts an order that was just marked paid
+func RecordPaidOrder(site, currency string, total float64) {
+	if currency == "" {
+		currency = "USD"
+	}
+	ordersPaid.Inc(site)
+	revenuePaid.Add(total, site, currency)
+}
+
+// ObserveQuery records how long a query against a database took
+func ObserveQuery(database string, elapsed time.Duration) {
+	dbQueryDuration.Observe(elapsed.Seconds(), database)
+}
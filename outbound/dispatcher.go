@@ -0,0 +1,208 @@
+// Package outbound delivers the store's own webhooks (chat notifications, events for other
+// systems) from a queue in the site's database. Failed deliveries are retried with backoff and
+// moved to a dead-letter table once they run out of attempts, where the admin can replay them
+package outbound
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/murdinc/stencil2/structs"
+)
+
+// DefaultMaxAttempts is how many times a delivery is tried before it's dead-lettered, when the
+// endpoint doesn't say
+const DefaultMaxAttempts = 6
+
+// pollInterval is how often the dispatcher looks for deliveries that are due, on top of being
+// woken by Notify
+const pollInterval = 30 * time.Second
+
+// batchSize caps the deliveries claimed at a time
+const batchSize = 20
+
+// Endpoint is where a named hook's deliveries go
+type Endpoint struct {
+	URL         string
+	Secret      string // signs each payload when set
+	MaxAttempts int    // DefaultMaxAttempts when 0
+}
+
+// Store is the queue deliveries are kept in
+type Store interface {
+	// ClaimOutboundDeliveries returns up to limit due deliveries, leased so no other dispatcher
+	// sends them at the same time
+	ClaimOutboundDeliveries(limit int) ([]structs.OutboundDelivery, error)
+	// DeleteOutboundDelivery removes a delivery that was sent
+	DeleteOutboundDelivery(id int) error
+	// RetryOutboundDelivery records a failed attempt and how long to wait before the next
+	RetryOutboundDelivery(id, attempts, statusCode int, lastError string, delay time.Duration) error
+	// DeadLetterOutboundDelivery moves a delivery that won't be tried again to the dead letters
+	DeadLetterOutboundDelivery(id, attempts, statusCode int, lastError string) error
+}
+
+// Dispatcher sends a site's queued deliveries
+type Dispatcher struct {
+	store      Store
+	resolve    func(endpoint string) (Endpoint, bool)
+	httpClient *http.Client
+	wake       chan struct{}
+}
+
+// NewDispatcher creates a dispatcher for a site's queue. resolve looks up an endpoint by name when
+// a delivery is sent, so changes to the site's config apply to deliveries already queued
+func NewDispatcher(store Store, resolve func(endpoint string) (Endpoint, bool)) *Dispatcher {
+	return &Dispatcher{
+		store:      store,
+		resolve:    resolve,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Run sends due deliveries until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.deliverDue(ctx)
+		select {
+		case <-ticker.C:
+		case <-d.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Notify wakes the dispatcher to send a delivery that was just queued
+func (d *Dispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliverDue sends every due delivery, a batch at a time
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		deliveries, err := d.store.ClaimOutboundDeliveries(batchSize)
+		if err != nil {
+			log.Printf("Error claiming outbound webhook deliveries: %v", err)
+			return
+		}
+		for _, delivery := range deliveries {
+			d.attempt(ctx, delivery)
+		}
+		if len(deliveries) < batchSize {
+			return
+		}
+	}
+}
+
+// attempt sends one delivery and records the outcome
+func (d *Dispatcher) attempt(ctx context.Context, delivery structs.OutboundDelivery) {
+	attempts := delivery.Attempts + 1
+
+	endpoint, ok := d.resolve(delivery.Endpoint)
+	if !ok || endpoint.URL == "" {
+		d.deadLetter(delivery, attempts, 0, "endpoint is no longer configured")
+		return
+	}
+
+	statusCode, err := Send(ctx, d.httpClient, endpoint, delivery)
+	if err == nil {
+		if err := d.store.DeleteOutboundDelivery(delivery.ID); err != nil {
+			log.Printf("Error removing sent outbound webhook %d: %v", delivery.ID, err)
+		}
+		return
+	}
+
+	maxAttempts := endpoint.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	if attempts >= maxAttempts || !retryable(statusCode) {
+		d.deadLetter(delivery, attempts, statusCode, err.Error())
+		return
+	}
+
+	if err := d.store.RetryOutboundDelivery(delivery.ID, attempts, statusCode, err.Error(), Backoff(attempts)); err != nil {
+		log.Printf("Error rescheduling outbound webhook %d: %v", delivery.ID, err)
+	}
+}
+
+func (d *Dispatcher) deadLetter(delivery structs.OutboundDelivery, attempts, statusCode int, lastError string) {
+	log.Printf("Outbound webhook %d (%s %s) failed for good after %d attempts: %s", delivery.ID, delivery.Endpoint, delivery.Event, attempts, lastError)
+	if err := d.store.DeadLetterOutboundDelivery(delivery.ID, attempts, statusCode, lastError); err != nil {
+		log.Printf("Error dead-lettering outbound webhook %d: %v", delivery.ID, err)
+	}
+}
+
+// Backoff is how long to wait after a delivery's nth failed attempt: a minute, then four times
+// longer each time, up to 12 hours
+func Backoff(attempts int) time.Duration {
+	delay := time.Minute
+	for i := 1; i < attempts && delay < 12*time.Hour; i++ {
+		delay *= 4
+	}
+	if delay > 12*time.Hour {
+		delay = 12 * time.Hour
+	}
+	return delay
+}
+
+// retryable reports whether a failure might succeed later. Network errors (status 0), server
+// errors, timeouts and rate limits are retried; other client errors won't change on their own
+func retryable(statusCode int) bool {
+	return statusCode == 0 || statusCode >= 500 || statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests
+}
+
+// Send POSTs a delivery's payload to the endpoint and returns the response status. Any status
+// outside 2xx is an error
+func Send(ctx context.Context, client *http.Client, endpoint Endpoint, delivery structs.OutboundDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Stencil-Webhooks/1.0")
+	req.Header.Set("X-Stencil-Event", delivery.Event)
+	req.Header.Set("X-Stencil-Delivery", strconv.Itoa(delivery.ID))
+	if endpoint.Secret != "" {
+		req.Header.Set("X-Stencil-Signature", Sign(endpoint.Secret, time.Now().Unix(), delivery.Payload))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("endpoint returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the X-Stencil-Signature header for a payload: t=<unix time>,v1=<hex HMAC-SHA256 of
+// "<unix time>.<payload>" keyed with the endpoint's secret>. Receivers recompute it and should
+// reject old timestamps
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(payload)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
@@ -14,6 +14,9 @@ const (
 	EarlyAccessCookieName = "stencil_early_access"
 	EarlyAccessCookiePath = "/"
 	EarlyAccessCookieMaxAge = 60 * 60 * 24 * 30 // 30 days in seconds
+
+	OrderConfirmCookieName = "stencil_order_confirm"
+	OrderConfirmCookiePath = "/"
 )
 
 // GetOrCreateCartSession retrieves or creates a cart session ID
@@ -60,3 +63,18 @@ func GetEarlyAccessSession(r *http.Request) string {
 	}
 	return cookie.Value
 }
+
+// SetOrderConfirmSession remembers the cart session an order was placed from, after the cart cookie
+// is cleared, so the order's confirmation token only works in the browser that checked out
+func SetOrderConfirmSession(w http.ResponseWriter, cartID string, maxAge int) {
+	utils.SetCookie(w, OrderConfirmCookieName, cartID, OrderConfirmCookiePath, maxAge)
+}
+
+// GetOrderConfirmSession retrieves the cart session the last order was placed from, if any
+func GetOrderConfirmSession(r *http.Request) string {
+	cookie, err := r.Cookie(OrderConfirmCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
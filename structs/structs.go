@@ -34,6 +34,7 @@ type Post struct {
 	Tags          []Tag         `json:"tags"`
 	Image         Image         `json:"image"`
 	Slides        []Slide       `json:"slides"`
+	Locale        string        `json:"locale,omitempty"` // translation the text is in, empty for the default language
 }
 
 type Slide struct {
@@ -62,13 +63,15 @@ type Author struct {
 }
 
 type Category struct {
-	ID          int    `json:"id"`
-	Name        string `json:"name"`
-	Slug        string `json:"slug"`
-	Description string `json:"description"`
-	Count       int    `json:"count"`
-	ImageUrl    string `json:"image_url"`
-	AltText     string `json:"alt_text"`
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Slug        string     `json:"slug"`
+	Description string     `json:"description"`
+	ParentID    int        `json:"parent_id"` // 0 for top-level categories
+	Count       int        `json:"count"`     // published posts in this category
+	ImageUrl    string     `json:"image_url"`
+	AltText     string     `json:"alt_text"`
+	Children    []Category `json:"children,omitempty"` // only populated for ?tree=true
 }
 
 type Tag struct {
@@ -80,24 +83,60 @@ type Tag struct {
 // E-commerce Structs
 
 type Product struct {
-	ID                int              `json:"id"`
-	Name              string           `json:"name"`
-	Slug              string           `json:"slug"`
-	Description       string           `json:"description"`
-	Price             float64          `json:"price"`
-	CompareAtPrice    float64          `json:"compare_at_price"`
-	SKU               string           `json:"sku"`
-	InventoryQuantity int              `json:"inventory_quantity"`
-	InventoryPolicy   string           `json:"inventory_policy"`
-	Status            string           `json:"status"`
-	Featured          bool             `json:"featured"`
-	SortOrder         int              `json:"sort_order"`
-	Images            []ProductImage   `json:"images"`
-	Variants          []ProductVariant `json:"variants"`
-	Collections       []Collection     `json:"collections"`
-	CreatedAt         time.Time        `json:"created_at"`
-	UpdatedAt         time.Time        `json:"updated_at"`
-	ReleasedDate      time.Time        `json:"released_date"`
+	ID                   int              `json:"id"`
+	Name                 string           `json:"name"`
+	Slug                 string           `json:"slug"`
+	Description          string           `json:"description"`
+	Price                float64          `json:"price"`
+	PriceIncludesTax     bool             `json:"price_includes_tax"`     // set when the site's prices are tax-inclusive
+	IncludedTax          float64          `json:"included_tax,omitempty"` // tax contained in Price when it's tax-inclusive
+	CompareAtPrice       float64          `json:"compare_at_price"`
+	DiscountPercent      float64          `json:"discount_percent"`
+	SKU                  string           `json:"sku"`
+	InventoryQuantity    int              `json:"inventory_quantity"`
+	InventoryPolicy      string           `json:"inventory_policy"`
+	MaxPerOrder          int              `json:"max_per_order"`
+	SubscriptionInterval string           `json:"subscription_interval,omitempty"` // week, month or year for subscription products
+	Status               string           `json:"status"`
+	Featured             bool             `json:"featured"`
+	SortOrder            int              `json:"sort_order"`
+	Images               []ProductImage   `json:"images"`
+	Variants             []ProductVariant `json:"variants"`
+	DefaultVariantID     int              `json:"default_variant_id,omitempty"` // variant to preselect, the first by position
+	RequiresVariant      bool             `json:"requires_variant"`             // sold only as one of its variants, so one must be chosen before adding to cart
+	Collections          []Collection     `json:"collections"`
+	Reviews              ReviewSummary    `json:"reviews"` // approved reviews only, zeros when there are none
+	CreatedAt            time.Time        `json:"created_at"`
+	UpdatedAt            time.Time        `json:"updated_at"`
+	ReleasedDate         time.Time        `json:"released_date"`
+	Preorder             bool             `json:"preorder"`               // on preorder until ReleasedDate, when it ships
+	BundleItems          []BundleItem     `json:"bundle_items,omitempty"` // set when the product is a bundle of other products
+	Locale               string           `json:"locale,omitempty"`       // translation the text is in, empty for the default language
+}
+
+// BundleItem is a product included in a bundle
+type BundleItem struct {
+	ProductID int    `json:"product_id"`
+	Name      string `json:"name"`
+	Slug      string `json:"slug"`
+	Quantity  int    `json:"quantity"` // how many come in one bundle
+}
+
+// ProductQuestion is a customer question about a product and the shop's answer
+type ProductQuestion struct {
+	ID         int       `json:"id"`
+	ProductID  int       `json:"product_id"`
+	Name       string    `json:"name"`
+	Question   string    `json:"question"`
+	Answer     string    `json:"answer"`
+	CreatedAt  time.Time `json:"created_at"`
+	AnsweredAt time.Time `json:"answered_at"`
+}
+
+// ReviewSummary is the rating summary shown with a product
+type ReviewSummary struct {
+	AverageRating float64 `json:"average_rating"`
+	ReviewCount   int     `json:"review_count"`
 }
 
 type Collection struct {
@@ -113,6 +152,32 @@ type Collection struct {
 	UpdatedAt    time.Time `json:"updated_at"`
 }
 
+// ContentChanges is what /api/v1/changes returns: the products, articles and collections changed
+// since a time, and those deleted or unpublished since then
+type ContentChanges struct {
+	Since       time.Time         `json:"since"`
+	NextSince   time.Time         `json:"next_since"` // pass as ?since= on the next poll
+	Products    []ContentChange   `json:"products"`
+	Articles    []ContentChange   `json:"articles"`
+	Collections []ContentChange   `json:"collections"`
+	Deleted     []ContentDeletion `json:"deleted"`
+}
+
+// ContentChange points at a changed item, which clients fetch again by slug
+type ContentChange struct {
+	ID        int       `json:"id"`
+	Slug      string    `json:"slug"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ContentDeletion is an item clients should drop, because it was deleted or is no longer published
+type ContentDeletion struct {
+	Type      string    `json:"type"` // product, article or collection
+	ID        int       `json:"id"`
+	Slug      string    `json:"slug"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
 type ProductVariant struct {
 	ID                int     `json:"id"`
 	ProductID         int     `json:"product_id"`
@@ -120,6 +185,7 @@ type ProductVariant struct {
 	PriceModifier     float64 `json:"price_modifier"`
 	SKU               string  `json:"sku"`
 	InventoryQuantity int     `json:"inventory_quantity"`
+	MaxPerOrder       int     `json:"max_per_order"`
 	Position          int     `json:"position"`
 }
 
@@ -148,6 +214,20 @@ type CartItem struct {
 	Quantity  int            `json:"quantity"`
 	Price     float64        `json:"price"`
 	Total     float64        `json:"total"`
+	Available *int           `json:"available,omitempty"` // Only set by GetCartDetailed
+	InStock   *bool          `json:"in_stock,omitempty"`  // Only set by GetCartDetailed
+	LowStock  bool           `json:"low_stock,omitempty"`
+}
+
+// Wishlist is a shopper's saved products
+type Wishlist struct {
+	Items []WishlistItem `json:"items"`
+	Count int            `json:"count"`
+}
+
+type WishlistItem struct {
+	Product Product   `json:"product"`
+	AddedAt time.Time `json:"added_at"`
 }
 
 type Order struct {
@@ -171,6 +251,7 @@ type Order struct {
 	Tax                  float64     `json:"tax"`
 	ShippingCost         float64     `json:"shipping_cost"`
 	Total                float64     `json:"total"`
+	StoreCredit          float64     `json:"store_credit"` // part of Total paid with store credit
 	PaymentStatus        string      `json:"payment_status"`
 	FulfillmentStatus    string      `json:"fulfillment_status"`
 	PaymentMethod        string      `json:"payment_method"`
@@ -182,6 +263,7 @@ type Order struct {
 	ShippingLabelURL     string      `json:"shipping_label_url"`
 	ShippoTransactionID  string      `json:"shippo_transaction_id"`
 	Items                []OrderItem `json:"items"`
+	ShipsOn              *time.Time  `json:"ships_on,omitempty"` // release date of its last preorder item, nil when nothing is on preorder
 	CreatedAt            time.Time   `json:"created_at"`
 	UpdatedAt            time.Time   `json:"updated_at"`
 }
@@ -197,6 +279,55 @@ type OrderItem struct {
 	Total        float64 `json:"total"`
 }
 
+// OrderReturn is a customer's request to send back items from a delivered order
+type OrderReturn struct {
+	ID           int               `json:"id"`
+	OrderNumber  string            `json:"order_number"`
+	Status       string            `json:"status"` // requested, approved, rejected, received or refunded
+	Reason       string            `json:"reason"`
+	Items        []OrderReturnItem `json:"items"`
+	RefundAmount float64           `json:"refund_amount"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+// OrderReturnItem is how many of an order line are being returned
+type OrderReturnItem struct {
+	OrderItemID  int     `json:"order_item_id"`
+	ProductID    int     `json:"product_id"`
+	VariantID    int     `json:"variant_id"`
+	ProductName  string  `json:"product_name"`
+	VariantTitle string  `json:"variant_title"`
+	Quantity     int     `json:"quantity"`
+	Price        float64 `json:"price"`
+}
+
+// OutboundDelivery is a webhook the store sends, queued until it's delivered
+type OutboundDelivery struct {
+	ID        int
+	Endpoint  string // "chat" or the name of one of the site's notifications.webhooks
+	Event     string
+	Payload   []byte
+	Attempts  int
+	CreatedAt time.Time
+}
+
+type Subscription struct {
+	ID                   int                    `json:"id"`
+	StripeSubscriptionID string                 `json:"stripe_subscription_id"`
+	CustomerID           *int                   `json:"customer_id"`
+	CustomerEmail        string                 `json:"customer_email"`
+	CustomerName         string                 `json:"customer_name"`
+	CartID               string                 `json:"-"`
+	ShippingAddress      map[string]interface{} `json:"shipping_address"`
+	Items                []CartItem             `json:"items"` // Snapshot of the cart each recurring order is created from
+	BillingInterval      string                 `json:"billing_interval"`
+	Amount               float64                `json:"amount"`
+	Status               string                 `json:"status"`
+	CreatedAt            time.Time              `json:"created_at"`
+	UpdatedAt            time.Time              `json:"updated_at"`
+}
+
 type Customer struct {
 	ID               int        `json:"id"`
 	Email            string     `json:"email"`
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// localePattern matches a normalized BCP 47 language tag like "fr" or "pt-br"
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// NormalizeLocale lowercases a language tag and uses hyphens, so "pt_BR" becomes "pt-br". Returns
+// an empty string for anything that isn't a language tag
+func NormalizeLocale(s string) string {
+	s = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(s), "_", "-"))
+	if !localePattern.MatchString(s) {
+		return ""
+	}
+	return s
+}
+
+// ParseAcceptLanguage returns the normalized locales in an Accept-Language header, most preferred
+// first. Wildcards and locales with q=0 are left out
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		locale string
+		q      float64
+	}
+
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		locale := NormalizeLocale(fields[0])
+		if locale == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && name == "q" {
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weighted{locale, q})
+		}
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	locales := make([]string, len(tags))
+	for i, t := range tags {
+		locales[i] = t.locale
+	}
+	return locales
+}
+
+// LocaleFallbacks adds each locale's language on its own after it, so "fr-ca" also tries "fr",
+// and drops duplicates
+func LocaleFallbacks(locales []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	add := func(locale string) {
+		if !seen[locale] {
+			seen[locale] = true
+			result = append(result, locale)
+		}
+	}
+	for _, locale := range locales {
+		add(locale)
+		if language, _, ok := strings.Cut(locale, "-"); ok {
+			add(language)
+		}
+	}
+	return result
+}
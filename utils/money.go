@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is used when a site doesn't set ecommerce.currency
+const DefaultCurrency = "USD"
+
+// currencySymbols are the symbols FormatMoney puts in front of amounts. Other currencies are
+// shown with their code, e.g. "1,000.00 SEK"
+var currencySymbols = map[string]string{
+	"AUD": "A$",
+	"BRL": "R$",
+	"CAD": "CA$",
+	"CHF": "CHF ",
+	"CNY": "¥",
+	"EUR": "€",
+	"GBP": "£",
+	"HKD": "HK$",
+	"INR": "₹",
+	"JPY": "¥",
+	"KRW": "₩",
+	"MXN": "MX$",
+	"NZD": "NZ$",
+	"SGD": "S$",
+	"USD": "$",
+	"VND": "₫",
+}
+
+// zeroDecimalCurrencies have no minor unit, so amounts are shown without cents. Stripe charges
+// these in whole units too
+var zeroDecimalCurrencies = map[string]bool{
+	"BIF": true, "CLP": true, "DJF": true, "GNF": true, "JPY": true, "KMF": true, "KRW": true,
+	"MGA": true, "PYG": true, "RWF": true, "UGX": true, "VND": true, "VUV": true, "XAF": true,
+	"XOF": true, "XPF": true,
+}
+
+// Rounding modes for ecommerce.taxRounding
+const (
+	RoundHalfUp   = "halfUp"   // half a cent rounds away from zero, the default
+	RoundHalfEven = "halfEven" // half a cent rounds to the even cent (banker's rounding)
+)
+
+// ToCents converts amount to whole cents, rounding half cents with mode (RoundHalfUp when it's
+// empty). The amount is first snapped to a millionth, so float error like 1.005 being stored as
+// 1.00499999... can't move it to the other side of a half cent
+func ToCents(amount float64, mode string) int64 {
+	cents := math.Round(amount*1e6) / 1e4
+	if mode == RoundHalfEven {
+		return int64(math.RoundToEven(cents))
+	}
+	return int64(math.Round(cents))
+}
+
+// RoundMoney rounds amount to the cent the same way ToCents does
+func RoundMoney(amount float64, mode string) float64 {
+	return float64(ToCents(amount, mode)) / 100
+}
+
+// NormalizeCurrency returns the upper-case ISO 4217 code for currency, or DefaultCurrency when it's empty
+func NormalizeCurrency(currency string) string {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		return DefaultCurrency
+	}
+	return currency
+}
+
+// StripeAmount converts amount to the smallest unit Stripe charges currency in: cents, or whole
+// units for zero-decimal currencies
+func StripeAmount(amount float64, currency string) int64 {
+	if CurrencyDecimals(currency) == 0 {
+		return int64(math.Round(amount))
+	}
+	return ToCents(amount, "")
+}
+
+// CurrencyDecimals returns how many decimal places amounts in currency are shown with
+func CurrencyDecimals(currency string) int {
+	if zeroDecimalCurrencies[NormalizeCurrency(currency)] {
+		return 0
+	}
+	return 2
+}
+
+// FormatMoney formats amount in currency with its symbol and thousands separators, e.g.
+// "$1,234.50", "¥1,235" or "-€12.00"
+func FormatMoney(amount float64, currency string) string {
+	currency = NormalizeCurrency(currency)
+	decimals := CurrencyDecimals(currency)
+
+	// Round half away from zero, the way prices are usually rounded
+	scale := math.Pow(10, float64(decimals))
+	amount = math.Round(amount*scale) / scale
+
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	number := strconv.FormatFloat(amount, 'f', decimals, 64)
+	whole, fraction, _ := strings.Cut(number, ".")
+
+	var b strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(digit)
+	}
+	if fraction != "" {
+		b.WriteByte('.')
+		b.WriteString(fraction)
+	}
+
+	if symbol, ok := currencySymbols[currency]; ok {
+		return sign + symbol + b.String()
+	}
+	return sign + b.String() + " " + currency
+}
@@ -0,0 +1,84 @@
+package utils
+
+import "testing"
+
+func TestToCents(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   float64
+		halfUp   int64
+		halfEven int64
+	}{
+		{"whole cents", 4.35, 435, 435},
+		{"stored just under its value", 1.13, 113, 113},
+		{"sum with float error", 0.1 + 0.2, 30, 30},
+		{"19.99 at 8.25% tax", 19.99 * 0.0825, 165, 165},
+		{"1.005 stored as 1.00499...", 1.005, 101, 100},
+		{"1.015", 1.015, 102, 102},
+		{"2.675 stored as 2.67499...", 2.675, 268, 268},
+		{"an eighth", 0.125, 13, 12},
+		{"10.50 at 5% tax", 10.50 * 0.05, 53, 52},
+		{"negative half cent", -1.005, -101, -100},
+		{"large amount", 1000000.005, 100000001, 100000000},
+		{"zero", 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToCents(tt.amount, RoundHalfUp); got != tt.halfUp {
+				t.Errorf("ToCents(%v, halfUp) = %d, want %d", tt.amount, got, tt.halfUp)
+			}
+			if got := ToCents(tt.amount, ""); got != tt.halfUp {
+				t.Errorf("ToCents(%v, \"\") = %d, want the halfUp %d", tt.amount, got, tt.halfUp)
+			}
+			if got := ToCents(tt.amount, RoundHalfEven); got != tt.halfEven {
+				t.Errorf("ToCents(%v, halfEven) = %d, want %d", tt.amount, got, tt.halfEven)
+			}
+		})
+	}
+}
+
+func TestRoundMoney(t *testing.T) {
+	tests := []struct {
+		amount   float64
+		halfUp   float64
+		halfEven float64
+	}{
+		{19.99 * 0.0825, 1.65, 1.65},
+		{1.005, 1.01, 1.00},
+		{2.675, 2.68, 2.68},
+		{0.125, 0.13, 0.12},
+		{0.1 + 0.2, 0.3, 0.3},
+		{3 * 8.33, 24.99, 24.99},
+	}
+
+	for _, tt := range tests {
+		if got := RoundMoney(tt.amount, RoundHalfUp); got != tt.halfUp {
+			t.Errorf("RoundMoney(%v, halfUp) = %v, want %v", tt.amount, got, tt.halfUp)
+		}
+		if got := RoundMoney(tt.amount, RoundHalfEven); got != tt.halfEven {
+			t.Errorf("RoundMoney(%v, halfEven) = %v, want %v", tt.amount, got, tt.halfEven)
+		}
+	}
+}
+
+func TestStripeAmount(t *testing.T) {
+	tests := []struct {
+		amount   float64
+		currency string
+		want     int64
+	}{
+		{19.99, "USD", 1999},
+		{19.99, "", 1999},
+		{1.005, "eur", 101},
+		{1500, "JPY", 1500},
+		{1500.4, "jpy", 1500},
+		{12000, "KRW", 12000},
+	}
+
+	for _, tt := range tests {
+		if got := StripeAmount(tt.amount, tt.currency); got != tt.want {
+			t.Errorf("StripeAmount(%v, %q) = %d, want %d", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
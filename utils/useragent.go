@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"strings"
+)
+
+// Device types returned by ClassifyDevice
+const (
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+	DeviceBot     = "bot"
+)
+
+// botMarkers are user agent fragments (lower case) used by crawlers, previewers and scripts
+var botMarkers = []string{
+	"bot", "crawl", "spider", "slurp", "facebookexternalhit", "embedly", "preview",
+	"headless", "lighthouse", "pingdom", "uptime", "monitor", "curl/", "wget/",
+	"python-requests", "python-urllib", "go-http-client", "java/", "okhttp", "axios/",
+}
+
+// ClassifyDevice works out whether a user agent is a mobile, tablet, desktop or bot. Screen width
+// is a secondary signal: it separates iPads (which report a Mac user agent) from Macs, and is the
+// only signal when the user agent is missing
+func ClassifyDevice(userAgent string, screenWidth int) string {
+	ua := strings.ToLower(userAgent)
+
+	if ua == "" {
+		switch {
+		case screenWidth <= 0:
+			return DeviceDesktop
+		case screenWidth < 768:
+			return DeviceMobile
+		case screenWidth < 1024:
+			return DeviceTablet
+		default:
+			return DeviceDesktop
+		}
+	}
+
+	for _, marker := range botMarkers {
+		if strings.Contains(ua, marker) {
+			return DeviceBot
+		}
+	}
+
+	switch {
+	case strings.Contains(ua, "ipad"), strings.Contains(ua, "tablet"), strings.Contains(ua, "kindle"),
+		strings.Contains(ua, "silk/"), strings.Contains(ua, "playbook"):
+		return DeviceTablet
+	case strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		// Android tablets leave "Mobile" out of the user agent
+		return DeviceTablet
+	case strings.Contains(ua, "mobi"), strings.Contains(ua, "iphone"), strings.Contains(ua, "ipod"),
+		strings.Contains(ua, "android"), strings.Contains(ua, "windows phone"), strings.Contains(ua, "blackberry"),
+		strings.Contains(ua, "opera mini"):
+		return DeviceMobile
+	case strings.Contains(ua, "macintosh") && screenWidth > 0 && screenWidth <= 1024:
+		// iPadOS requests the desktop site with a Mac user agent
+		return DeviceTablet
+	}
+
+	return DeviceDesktop
+}
+
+// ClassifyBrowser returns the browser family for a user agent, e.g. "Chrome" or "Safari".
+// Order matters: most browsers include "Chrome" or "Safari" in their user agent
+func ClassifyBrowser(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	switch {
+	case ua == "":
+		return "Unknown"
+	case ClassifyDevice(userAgent, 0) == DeviceBot:
+		return "Bot"
+	case strings.Contains(ua, "edg/"), strings.Contains(ua, "edge/"), strings.Contains(ua, "edga/"), strings.Contains(ua, "edgios/"):
+		return "Edge"
+	case strings.Contains(ua, "opr/"), strings.Contains(ua, "opera"):
+		return "Opera"
+	case strings.Contains(ua, "samsungbrowser/"):
+		return "Samsung Internet"
+	case strings.Contains(ua, "firefox/"), strings.Contains(ua, "fxios/"):
+		return "Firefox"
+	case strings.Contains(ua, "chrome/"), strings.Contains(ua, "crios/"), strings.Contains(ua, "chromium/"):
+		return "Chrome"
+	case strings.Contains(ua, "safari/"):
+		return "Safari"
+	case strings.Contains(ua, "msie "), strings.Contains(ua, "trident/"):
+		return "Internet Explorer"
+	}
+
+	return "Other"
+}